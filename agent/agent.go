@@ -4,17 +4,21 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/version"
 )
@@ -32,6 +36,17 @@ type Agent struct {
 
 	// prevOO is previous pitr.oplogOnly value
 	prevOO *bool
+
+	// binVer is the cached version of the mongod binary
+	// physical restores run
+	binVer *mongodBinVer
+}
+
+type mongodBinVer struct {
+	bin  string
+	mod  time.Time
+	size int64
+	ver  string
 }
 
 func New(pbm *pbm.PBM) *Agent {
@@ -67,7 +82,46 @@ func (a *Agent) CanStart() error {
 		return errors.New("mongos is not supported")
 	}
 
-	return nil
+	return a.checkNoOtherAgent()
+}
+
+// checkNoOtherAgent checks that the node isn't already served by another
+// running agent. Agents of different mongod instances can share the same
+// host, but there should be only one agent per mongod.
+func (a *Agent) checkNoOtherAgent() error {
+	stat, err := a.pbm.GetAgentStatus(a.node.RS(), a.node.Name())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "get agent status")
+	}
+
+	ct, err := a.pbm.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+	if stat.IsStale(ct) || stat.PID == 0 {
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	if stat.Host == host && (stat.PID == os.Getpid() || !processAlive(stat.PID)) {
+		return nil
+	}
+
+	return errors.Errorf("node %s/%s is already served by the agent %s (pid %d), "+
+		"there should be only one agent per mongod",
+		a.node.RS(), a.node.Name(), stat.Host, stat.PID)
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return p.Signal(syscall.Signal(0)) == nil
 }
 
 // Start starts listening the commands stream.
@@ -75,6 +129,8 @@ func (a *Agent) Start() error {
 	a.log.Printf("pbm-agent:\n%s", version.DefaultInfo.All(""))
 	a.log.Printf("node: %s", a.node.ID())
 
+	go a.selfCheckOnStart()
+
 	c, cerr := a.pbm.ListenCmd(a.closeCMD)
 
 	a.log.Printf("listening for the commands")
@@ -117,6 +173,10 @@ func (a *Agent) Start() error {
 				a.DeletePITR(cmd.DeletePITR, cmd.OPID, ep)
 			case pbm.CmdCleanup:
 				a.Cleanup(cmd.Cleanup, cmd.OPID, ep)
+			case pbm.CmdAgentDoctor:
+				go a.SelfCheck(cmd.OPID, ep)
+			case pbm.CmdListDBpath:
+				go a.ListDBpath(cmd.OPID, ep)
 			}
 		case err, ok := <-cerr:
 			if !ok {
@@ -175,8 +235,9 @@ func (a *Agent) Delete(d *pbm.DeleteBackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		l.Debug("skip: lock not acquired")
 		return
 	}
+	var opErr error
 	defer func() {
-		err := lock.Release()
+		err := lock.ReleaseWith(opErr)
 		if err != nil {
 			l.Error("release lock: %v", err)
 		}
@@ -188,21 +249,22 @@ func (a *Agent) Delete(d *pbm.DeleteBackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		obj := t.Format("2006-01-02T15:04:05Z")
 		l = a.pbm.Logger().NewEvent(string(pbm.CmdDeleteBackup), obj, opid.String(), ep.TS())
 		l.Info("deleting backups older than %v", t)
-		err := a.pbm.DeleteOlderThan(t, l)
-		if err != nil {
-			l.Error("deleting: %v", err)
+		opErr = a.pbm.DeleteOlderThan(t, l)
+		if opErr != nil {
+			l.Error("deleting: %v", opErr)
 			return
 		}
 	case d.Backup != "":
 		l = a.pbm.Logger().NewEvent(string(pbm.CmdDeleteBackup), d.Backup, opid.String(), ep.TS())
 		l.Info("deleting backup")
-		err := a.pbm.DeleteBackup(d.Backup, l)
-		if err != nil {
-			l.Error("deleting: %v", err)
+		opErr = a.pbm.DeleteBackup(d.Backup, l)
+		if opErr != nil {
+			l.Error("deleting: %v", opErr)
 			return
 		}
 	default:
 		l.Error("malformed command received in Delete() of backup: %v", d)
+		opErr = errors.New("malformed command")
 		return
 	}
 
@@ -249,9 +311,8 @@ func (a *Agent) DeletePITR(d *pbm.DeletePITRCmd, opid pbm.OPID, ep pbm.Epoch) {
 		return
 	}
 	defer func() {
-		err := lock.Release()
-		if err != nil {
-			l.Error("release lock: %v", err)
+		if rerr := lock.ReleaseWith(err); rerr != nil {
+			l.Error("release lock: %v", rerr)
 		}
 	}()
 
@@ -311,8 +372,9 @@ func (a *Agent) Cleanup(d *pbm.CleanupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		l.Debug("skip: lock not acquired")
 		return
 	}
+	var opErr error
 	defer func() {
-		if err := lock.Release(); err != nil {
+		if err := lock.ReleaseWith(opErr); err != nil {
 			l.Error("release lock: %v", err)
 		}
 	}()
@@ -320,6 +382,7 @@ func (a *Agent) Cleanup(d *pbm.CleanupCmd, opid pbm.OPID, ep pbm.Epoch) {
 	stg, err := a.pbm.GetStorage(l)
 	if err != nil {
 		l.Error("get storage: " + err.Error())
+		opErr = err
 	}
 
 	eg := errgroup.Group{}
@@ -328,6 +391,7 @@ func (a *Agent) Cleanup(d *pbm.CleanupCmd, opid pbm.OPID, ep pbm.Epoch) {
 	cr, err := pbm.MakeCleanupInfo(a.pbm.Context(), a.pbm.Conn, d.OlderThan)
 	if err != nil {
 		l.Error("make cleanup report: " + err.Error())
+		opErr = err
 		return
 	}
 
@@ -335,12 +399,21 @@ func (a *Agent) Cleanup(d *pbm.CleanupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		name := cr.Chunks[i].FName
 
 		eg.Go(func() error {
-			err := stg.Delete(name)
+			err := storage.CheckUnlocked(stg, name)
+			if errors.Is(err, storage.ErrLocked) {
+				l.Info("skip chunk: %v", err)
+				return nil
+			}
+			if err != nil {
+				return errors.WithMessagef(err, "check chunk file %q lock", name)
+			}
+			err = stg.Delete(name)
 			return errors.WithMessagef(err, "delete chunk file %q", name)
 		})
 	}
 	if err := eg.Wait(); err != nil {
 		l.Error(err.Error())
+		opErr = err
 	}
 
 	for i := range cr.Backups {
@@ -348,16 +421,22 @@ func (a *Agent) Cleanup(d *pbm.CleanupCmd, opid pbm.OPID, ep pbm.Epoch) {
 
 		eg.Go(func() error {
 			err := a.pbm.DeleteBackupFiles(bcp, stg)
+			if errors.Is(err, storage.ErrLocked) {
+				l.Info("skip backup %s: %v", bcp.Name, err)
+				return nil
+			}
 			return errors.WithMessagef(err, "delete backup files %q", bcp.Name)
 		})
 	}
 	if err := eg.Wait(); err != nil {
 		l.Error(err.Error())
+		opErr = err
 	}
 
 	err = a.pbm.ResyncStorage(l)
 	if err != nil {
 		l.Error("storage resync: " + err.Error())
+		opErr = err
 	}
 }
 
@@ -399,7 +478,7 @@ func (a *Agent) Resync(opid pbm.OPID, ep pbm.Epoch) {
 	}
 
 	defer func() {
-		err = lock.Release()
+		err = lock.ReleaseWith(err)
 		if err != nil {
 			l.Error("reslase lock %v: %v", lock, err)
 		}
@@ -413,6 +492,9 @@ func (a *Agent) Resync(opid pbm.OPID, ep pbm.Epoch) {
 	}
 	l.Info("succeed")
 
+	// restores may be kept on the separate storage (see GetRestoreStorage)
+	a.SyncPhysRestores()
+
 	epch, err := a.pbm.ResetEpoch()
 	if err != nil {
 		l.Error("reset epoch: %v", err)
@@ -422,6 +504,42 @@ func (a *Agent) Resync(opid pbm.OPID, ep pbm.Epoch) {
 	l.Debug("epoch set to %v", epch)
 }
 
+// SyncPhysRestores mirrors the outcome of physical restores from the storage
+// into the PBM db. The db isn't available during physical restores (mongod is
+// down and its data is replaced), so the final result is written on
+// the cluster's start after the restore, on resync and after a failed
+// restore which left the cluster running. So are the mongos checked (see
+// restore.CheckMongos). Runs only on the cluster leader.
+func (a *Agent) SyncPhysRestores() {
+	l := a.log.NewEvent("syncRestores", "", "", primitive.Timestamp{})
+
+	nodeInfo, err := a.node.GetInfo()
+	if err != nil {
+		l.Error("get node info: %v", err)
+		return
+	}
+	if !nodeInfo.IsClusterLeader() {
+		return
+	}
+
+	stg, err := a.pbm.GetRestoreStorage(l)
+	if err != nil {
+		l.Error("get storage: %v", err)
+		return
+	}
+
+	err = a.pbm.SyncPhysRestores(stg, false, l)
+	if err != nil {
+		l.Error("sync physical restores meta: %v", err)
+		return
+	}
+
+	err = restore.CheckMongos(a.pbm, stg, a.node.ConnURI(), l)
+	if err != nil {
+		l.Error("check mongos: %v", err)
+	}
+}
+
 type lockAquireFn func() (bool, error)
 
 // acquireLock tries to acquire the lock. If there is a stale lock
@@ -479,7 +597,9 @@ func (a *Agent) HbStatus() {
 		Node: a.node.Name(),
 		RS:   a.node.RS(),
 		Ver:  version.DefaultInfo.Version,
+		PID:  os.Getpid(),
 	}
+	hb.Host, _ = os.Hostname()
 	defer func() {
 		if err := a.pbm.RmAgentStatus(hb); err != nil {
 			logger := a.log.NewEvent("agentCheckup", "", "", primitive.Timestamp{})
@@ -496,6 +616,10 @@ func (a *Agent) HbStatus() {
 	const checkStoreIn = int(60 / (pbm.AgentsStatCheckRange / time.Second))
 	var cc int
 
+	// the last coverage forecast sent and when it was checked
+	var coverageSent string
+	var coverageChecked time.Time
+
 	for range tk.C {
 		// don't check if on pause (e.g. physical restore)
 		if !a.HbIsRun() {
@@ -511,6 +635,17 @@ func (a *Agent) HbStatus() {
 		cc++
 		hb.StorageStatus = a.storStatus(l, cc == checkStoreIn)
 		logHbStatus("storage connection", hb.StorageStatus, l)
+		if hb.Caps == nil || cc == checkStoreIn {
+			hb.Caps = a.restoreCaps(l)
+			// the running slicer doesn't reread the config
+			if cfg, err := a.pbm.GetConfig(); err == nil {
+				pbm.ApplyUploadLimit(cfg)
+				pbm.ApplyRetryConf(cfg)
+			}
+		}
+		upl := pbm.UploadLimiter().Stat()
+		hb.Upload = &upl
+		reap := cc == checkStoreIn
 		if cc == checkStoreIn {
 			cc = 0
 		}
@@ -538,6 +673,32 @@ func (a *Agent) HbStatus() {
 		} else {
 			hb.Hidden = inf.Hidden
 			hb.Passive = inf.Passive
+			if reap && inf.IsClusterLeader() {
+				a.reapStaleBackups(l)
+			}
+			if inf.IsClusterLeader() && time.Since(coverageChecked) >= coverageCheckRange {
+				coverageChecked = time.Now()
+				coverageSent = a.checkCoverage(coverageSent, l)
+			}
+		}
+
+		hb.ReplLag = 0
+		if hb.State == pbm.NodeStateSecondary {
+			lag, err := a.node.ReplicationLag()
+			if err != nil {
+				l.Error("get replication lag: %v", err)
+				hb.Err += fmt.Sprintf("get replication lag: %v", err)
+			} else {
+				hb.ReplLag = lag
+			}
+		}
+
+		ver, err := a.node.GetMongoVersion()
+		if err != nil {
+			l.Error("get mongo version: %v", err)
+			hb.Err += fmt.Sprintf("get mongo version: %v", err)
+		} else {
+			hb.MongoVer = ver.VersionString
 		}
 
 		err = a.pbm.SetAgentStatus(hb)
@@ -547,6 +708,78 @@ func (a *Agent) HbStatus() {
 	}
 }
 
+// reapStaleBackups fails the backups orphaned by their agents
+// (see pbm.ReapStaleBackups)
+func (a *Agent) reapStaleBackups(l *log.Event) {
+	bcps, err := a.pbm.ReapStaleBackups()
+	for _, b := range bcps {
+		l.Warning("backup %s (opid %s) was orphaned in %s status, last heartbeat %d: marked as failed",
+			b.Name, b.OPID, b.Status, b.Hb.T)
+	}
+	if err != nil {
+		l.Error("reap stale backups: %v", err)
+	}
+}
+
+const coverageCheckRange = time.Hour
+
+// checkCoverage forecasts the coverage objectives under the retention policy
+// (see pbm.CheckCoverage) and sends pbm.NotifyCoverageRisk if the forecasted
+// violations differ from the `sent` ones, so a standing violation isn't sent
+// every check. It returns the key of the sent forecast.
+func (a *Agent) checkCoverage(sent string, l *log.Event) string {
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		l.Error("coverage check: get config: %v", err)
+		return sent
+	}
+	ws, err := a.pbm.CheckCoverage(time.Now(), cfg.Retention)
+	if err != nil {
+		l.Error("coverage check: %v", err)
+		return sent
+	}
+
+	var key string
+	for _, w := range ws {
+		key += fmt.Sprintf("%s:%d;", w.Objective, w.InDays)
+	}
+	if key == sent {
+		return sent
+	}
+	if len(ws) == 0 {
+		l.Info("coverage check: the objectives are met")
+		return key
+	}
+
+	for _, w := range ws {
+		l.Warning("coverage check: %s", w)
+	}
+	err = pbm.Notify(a.pbm.Context(), cfg.Notify, pbm.NotifyCoverageRisk, ws)
+	if err != nil {
+		l.Warning("coverage check: notify: %v", err)
+		return sent
+	}
+
+	return key
+}
+
+// inMaintenance tells if the node is put in maintenance by the user
+func (a *Agent) inMaintenance() (bool, error) {
+	stat, err := a.pbm.GetAgentStatus(a.node.RS(), a.node.Name())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "get agent status")
+	}
+
+	ct, err := a.pbm.ClusterTime()
+	if err != nil {
+		return false, errors.Wrap(err, "get cluster time")
+	}
+
+	return stat.InMaintenance(ct), nil
+}
+
 func (a *Agent) pbmStatus() (sts pbm.SubsysStatus) {
 	err := a.pbm.Conn.Ping(a.pbm.Context(), nil)
 	if err != nil {
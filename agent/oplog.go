@@ -19,10 +19,11 @@ func (a *Agent) OplogReplay(r *pbm.ReplayCmd, opID pbm.OPID, ep pbm.Epoch) {
 
 	l := a.log.NewEvent(string(pbm.CmdReplay), r.Name, opID.String(), ep.TS())
 
-	l.Info("time: %s-%s",
-		time.Unix(int64(r.Start.T), 0).UTC().Format(time.RFC3339),
-		time.Unix(int64(r.End.T), 0).UTC().Format(time.RFC3339),
-	)
+	end := "the latest common point"
+	if !r.End.IsZero() {
+		end = time.Unix(int64(r.End.T), 0).UTC().Format(time.RFC3339)
+	}
+	l.Info("time: %s-%s", time.Unix(int64(r.Start.T), 0).UTC().Format(time.RFC3339), end)
 
 	nodeInfo, err := a.node.GetInfo()
 	if err != nil {
@@ -53,8 +54,9 @@ func (a *Agent) OplogReplay(r *pbm.ReplayCmd, opID pbm.OPID, ep pbm.Epoch) {
 		return
 	}
 
+	var opErr error
 	defer func() {
-		if err := lock.Release(); err != nil {
+		if err := lock.ReleaseWith(opErr); err != nil {
 			l.Error("release lock: %s", err.Error())
 		}
 	}()
@@ -65,6 +67,7 @@ func (a *Agent) OplogReplay(r *pbm.ReplayCmd, opID pbm.OPID, ep pbm.Epoch) {
 			l.Info("no oplog for the shard, skipping")
 		} else {
 			l.Error("oplog replay: %v", err.Error())
+			opErr = err
 		}
 		return
 	}
@@ -73,6 +76,7 @@ func (a *Agent) OplogReplay(r *pbm.ReplayCmd, opID pbm.OPID, ep pbm.Epoch) {
 	resetEpoch, err := a.pbm.ResetEpoch()
 	if err != nil {
 		l.Error("reset epoch: %s", err.Error())
+		opErr = err
 		return
 	}
 
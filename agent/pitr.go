@@ -11,6 +11,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
 	"github.com/percona/percona-backup-mongodb/pbm/pitr"
 	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
 type currentPitr struct {
@@ -112,11 +113,24 @@ func (a *Agent) pitr() (err error) {
 
 	l := a.log.NewEvent(string(pbm.CmdPITR), "", "", ep.TS())
 
-	spant := time.Duration(cfg.PITR.OplogSpanMin * float64(time.Minute))
-	if spant == 0 {
-		spant = pbm.PITRdefaultSpan
+	// the slicer cuts the last chunk on stop and catches up
+	// from it once the freeze is lifted
+	frz, err := a.pbm.GetBackupFreeze()
+	if err != nil {
+		return errors.Wrap(err, "check backup freeze")
+	}
+	if frz != nil {
+		if p != nil {
+			l.Info("stopping: backups are frozen %s", frz)
+			p.cancel()
+		} else {
+			l.Debug("skip: backups are frozen %s", frz)
+		}
+		return nil
 	}
 
+	spant := cfg.PITR.Span()
+
 	// already do the job
 	if p != nil {
 		// update slicer span
@@ -154,17 +168,27 @@ func (a *Agent) pitr() (err error) {
 	if err != nil {
 		return errors.Wrap(err, "get node info")
 	}
-	q, err := backup.NodeSuits(a.node, ninf)
+	q, err := backup.NodeSuits(a.node, ninf, cfg.Backup.MaxReplLag())
 	if err != nil {
 		return errors.Wrap(err, "node check")
 	}
 
 	// node is not suitable for doing backup
-	if !q {
+	if !q.OK() {
+		l.Debug("skip: node is not suitable: %s", q)
+		return nil
+	}
+
+	mnt, err := a.inMaintenance()
+	if err != nil {
+		return errors.Wrap(err, "check maintenance")
+	}
+	if mnt {
+		l.Debug("skip: node is in maintenance")
 		return nil
 	}
 
-	stg, err := a.pbm.GetStorage(l)
+	stg, err := a.pbm.GetUploadStorage(l, storage.OpPITR)
 	if err != nil {
 		return errors.Wrap(err, "unable to get storage configuration")
 	}
@@ -188,6 +212,10 @@ func (a *Agent) pitr() (err error) {
 
 	ibcp := pitr.NewSlicer(a.node.RS(), a.pbm, a.node, stg, ep)
 	ibcp.SetSpan(spant)
+	ibcp.SetChunkSize(int64(cfg.PITR.ChunkSizeMb*1024*1024), cfg.PITR.MaxSpan())
+	ibcp.SetThrottle(cfg.PITR, nil)
+	ibcp.SetGapWatch(cfg)
+	ibcp.SetMaxReplLag(cfg.Backup.MaxReplLag())
 
 	if cfg.PITR.OplogOnly {
 		err = ibcp.OplogOnlyCatchup()
@@ -300,8 +328,9 @@ func (a *Agent) PITRestore(r *pbm.PITRestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 		return
 	}
 
+	var opErr error
 	defer func() {
-		err := lock.Release()
+		err := lock.ReleaseWith(opErr)
 		if err != nil {
 			l.Error("release lock: %v", err)
 		}
@@ -314,6 +343,7 @@ func (a *Agent) PITRestore(r *pbm.PITRestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 			l.Info("no data for the shard in backup, skipping")
 		} else {
 			l.Error("restore: %v", err)
+			opErr = err
 		}
 		return
 	}
@@ -323,6 +353,7 @@ func (a *Agent) PITRestore(r *pbm.PITRestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 		epch, err := a.pbm.ResetEpoch()
 		if err != nil {
 			l.Error("reset epoch")
+			opErr = err
 			return
 		}
 
@@ -0,0 +1,251 @@
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+const defaultDBpath = "/data/db"
+
+// SelfCheck checks the agent's environment: the node connection and the
+// user's roles, access to the dbpath, the mongod binary for physical
+// restores, the storage and the clock. The results are saved in the agent
+// status and each failure is logged with the hint on how to fix it.
+func (a *Agent) SelfCheck(opid pbm.OPID, ep pbm.Epoch) {
+	l := a.log.NewEvent(string(pbm.CmdAgentDoctor), "", opid.String(), ep.TS())
+
+	chk := &pbm.SelfCheck{}
+	add := func(name string, err error) {
+		it := pbm.SelfCheckItem{Name: name, OK: err == nil}
+		if err != nil {
+			it.Msg = err.Error()
+			l.Error("self-check %s: %s", name, it.Msg)
+		}
+		chk.Items = append(chk.Items, it)
+	}
+
+	add("node connection", a.checkNodeConn())
+	add("roles", a.checkRoles())
+
+	opts, err := a.node.GetOpts(nil)
+	add("getCmdLineOpts", errors.Wrap(err, "the user needs the clusterMonitor role"))
+
+	_, err = a.node.GetReplsetStatus()
+	add("replSetGetStatus", errors.Wrap(err, "the user needs the clusterMonitor role"))
+
+	if opts != nil {
+		add("dbpath", checkDBpath(opts.Storage.DBpath))
+	}
+	add("mongod binary", a.checkMongodBin(l))
+	add("storage", a.checkStorage(l))
+	add("clock", a.checkClock())
+
+	err = a.pbm.SetAgentSelfCheck(a.node.RS(), a.node.Name(), chk)
+	if err != nil {
+		l.Error("save self-check results: %v", err)
+		return
+	}
+
+	if f := chk.Failed(); len(f) != 0 {
+		l.Warning("self-check: %d of %d checks failed", len(f), len(chk.Items))
+		return
+	}
+	l.Info("self-check: all checks passed")
+}
+
+func (a *Agent) checkNodeConn() error {
+	err := a.node.Session().Ping(a.pbm.Context(), nil)
+	return errors.Wrapf(err, "ping %s. Check the agent's --mongodb-uri points to the local mongod", a.node.Name())
+}
+
+func (a *Agent) checkRoles() error {
+	auth, err := a.node.CurrentUser()
+	if err != nil {
+		return errors.Wrap(err, "get connection status")
+	}
+
+	return pbm.CheckRoles(auth)
+}
+
+// checkDBpath checks the agent can read the dbpath and mongod.lock.
+// The physical backups and restores need it.
+func checkDBpath(dbpath string) error {
+	if dbpath == "" {
+		dbpath = defaultDBpath
+	}
+
+	_, err := os.ReadDir(dbpath)
+	if err != nil {
+		return errors.Wrapf(err, "read dbpath. Run the agent as the user mongod runs as "+
+			"or grant it read access to %s", dbpath)
+	}
+
+	f, err := os.Open(filepath.Join(dbpath, "mongod.lock"))
+	if err != nil {
+		return errors.Wrapf(err, "open mongod.lock. Run the agent as the user mongod runs as "+
+			"or grant it read access to %s", dbpath)
+	}
+	f.Close()
+
+	return nil
+}
+
+// checkMongodBin checks the mongod binary physical restores run is available
+func (a *Agent) checkMongodBin(l *log.Event) error {
+	_, err := a.mongodBin(l)
+	return err
+}
+
+// mongodBin looks up the mongod binary physical restores run
+// (see pbm.RestoreConf.MongodLocationFor)
+func (a *Agent) mongodBin(l *log.Event) (string, error) {
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		l.Debug("get config: %v", err)
+	}
+
+	bin := ""
+	if inf, err := a.node.GetInfo(); err == nil {
+		bin = cfg.Restore.MongodLocationFor(inf.Me, inf.Tags)
+	}
+	if bin == "" {
+		bin = "mongod"
+	}
+
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return bin, errors.Wrapf(err, "find mongod binary. Add it to the agent's $PATH "+
+			"or set restore.mongodLocation in the config")
+	}
+	return path, nil
+}
+
+// restoreCaps returns the node's capabilities for physical restores
+func (a *Agent) restoreCaps(l *log.Event) *pbm.AgentCaps {
+	c := &pbm.AgentCaps{DBpathFree: -1}
+
+	bin, err := a.mongodBin(l)
+	c.MongodBin = bin
+	if err == nil {
+		c.MongodBinVer, err = a.mongodBinVer(bin)
+	}
+	if err != nil {
+		c.MongodBinErr = err.Error()
+	}
+
+	dbpath := a.dbpath()
+	if free, err := pbm.FreeSpace(dbpath); err == nil {
+		c.DBpathFree = free
+	} else {
+		l.Debug("get free space of %s: %v", dbpath, err)
+	}
+
+	return c
+}
+
+// mongodBinVer returns the version of the mongod binary. It's cached until
+// the binary changes, so `mongod --version` isn't run on every check.
+func (a *Agent) mongodBinVer(bin string) (string, error) {
+	fi, err := os.Stat(bin)
+	if err != nil {
+		return "", errors.Wrap(err, "stat mongod binary")
+	}
+
+	a.mx.Lock()
+	c := a.binVer
+	a.mx.Unlock()
+	if c != nil && c.bin == bin && c.mod.Equal(fi.ModTime()) && c.size == fi.Size() {
+		return c.ver, nil
+	}
+
+	ver, err := restore.NewMongodRunner().Version(bin)
+	if err != nil {
+		return "", err
+	}
+
+	a.mx.Lock()
+	a.binVer = &mongodBinVer{bin: bin, mod: fi.ModTime(), size: fi.Size(), ver: ver}
+	a.mx.Unlock()
+	return ver, nil
+}
+
+// dbpath returns the node's dbpath
+func (a *Agent) dbpath() string {
+	if opts, err := a.node.GetOpts(nil); err == nil && opts.Storage.DBpath != "" {
+		return opts.Storage.DBpath
+	}
+	return defaultDBpath
+}
+
+// ListDBpath lists the dbpath content a physical restore would wipe and
+// saves it in the agent status for the dry-run restore
+func (a *Agent) ListDBpath(opid pbm.OPID, ep pbm.Epoch) {
+	l := a.log.NewEvent(string(pbm.CmdListDBpath), "", opid.String(), ep.TS())
+
+	ignore := restore.DefaultDBPathIgnore
+	if cfg, err := a.pbm.GetConfig(); err == nil && len(cfg.Restore.DBPathIgnore) != 0 {
+		ignore = cfg.Restore.DBPathIgnore
+	}
+
+	dbpath := a.dbpath()
+	list := &pbm.DBpathList{}
+	files, err := restore.ListDBPath(dbpath, ignore)
+	if err != nil {
+		l.Warning("list dbpath %s: %v", dbpath, err)
+		list.Err = err.Error()
+	} else {
+		list.Files = pbm.NewFileList(files, pbm.MaxFilesList)
+	}
+
+	err = a.pbm.SetAgentDBpathList(a.node.RS(), a.node.Name(), list)
+	if err != nil {
+		l.Error("save dbpath list: %v", err)
+	}
+}
+
+func (a *Agent) checkStorage(l *log.Event) error {
+	stg, err := a.pbm.GetStorage(l)
+	if err != nil {
+		return errors.Wrap(err, "get storage. Check the storage options in the config")
+	}
+
+	_, err = stg.FileStat(pbm.StorInitFile)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return errors.Wrap(err, "access storage. Check the storage credentials and the network access to it")
+	}
+
+	return nil
+}
+
+func (a *Agent) checkClock() error {
+	ct, err := a.pbm.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+
+	return pbm.CheckClockSkew(time.Now().Unix(), ct)
+}
+
+// selfCheckOnStart runs the self-check once the agent has registered
+// its status
+func (a *Agent) selfCheckOnStart() {
+	for i := 0; i < 10; i++ {
+		_, err := a.pbm.GetAgentStatus(a.node.RS(), a.node.Name())
+		if err == nil {
+			break
+		}
+		time.Sleep(pbm.AgentsStatCheckRange)
+	}
+
+	a.SelfCheck(pbm.NilOPID(), pbm.Epoch(primitive.Timestamp{}))
+}
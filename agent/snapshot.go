@@ -2,6 +2,7 @@ package agent
 
 import (
 	"context"
+	"math"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,6 +11,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/backup"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/retry"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
@@ -56,6 +58,16 @@ func (a *Agent) Backup(cmd *pbm.BackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 
 	l := a.log.NewEvent(string(pbm.CmdBackup), cmd.Name, opid.String(), ep.TS())
 
+	frz, err := a.pbm.GetBackupFreeze()
+	if err != nil {
+		l.Error("check backup freeze: %v", err)
+		return
+	}
+	if frz != nil {
+		l.Info("skipping: backups are frozen %s", frz)
+		return
+	}
+
 	nodeInfo, err := a.node.GetInfo()
 	if err != nil {
 		l.Error("get node info: %v", err)
@@ -66,15 +78,34 @@ func (a *Agent) Backup(cmd *pbm.BackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 		return
 	}
 
-	q, err := backup.NodeSuits(a.node, nodeInfo)
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		l.Error("get config: %v", err)
+		return
+	}
+	maxLag := cfg.Backup.MaxReplLag()
+	// the node explicitly set for the backup isn't subject to the replication lag
+	if override, err := pbm.ParseBcpNodes(cmd.Nodes); err == nil && override[nodeInfo.SetName] == nodeInfo.Me {
+		maxLag = math.MaxInt
+	}
+	q, err := backup.NodeSuits(a.node, nodeInfo, maxLag)
 	if err != nil {
 		l.Error("node check: %v", err)
 		return
 	}
+	// the node explicitly set for the backup isn't subject to the priority
+	if q.OK() && len(cmd.Nodes) == 0 {
+		q = pbm.PrioritySuitability(cfg.Backup.Priority, nodeInfo.Me)
+	}
+
+	err = a.pbm.SetAgentSuitability(nodeInfo.SetName, nodeInfo.Me, q)
+	if err != nil {
+		l.Warning("record node suitability: %v", err)
+	}
 
 	// node is not suitable for doing backup
-	if !q {
-		l.Info("node is not suitable for backup")
+	if !q.OK() {
+		l.Info("node is not suitable for backup: %s", q)
 		return
 	}
 
@@ -137,18 +168,38 @@ func (a *Agent) Backup(cmd *pbm.BackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 			l.Error("get nodes priority: %v", err)
 			return
 		}
+		override, err := pbm.ParseBcpNodes(cmd.Nodes)
+		if err == nil {
+			err = a.pbm.CheckBcpNodes(override)
+		}
+		if err != nil {
+			l.Error("check backup nodes: %v", err)
+			ferr := a.pbm.ChangeBackupState(cmd.Name, pbm.StatusError, "check backup nodes: "+err.Error())
+			if ferr != nil {
+				l.Error("mark backup as failed: %v", ferr)
+			}
+			return
+		}
+		if !a.checkMongoVersions(cmd.Name, l) {
+			return
+		}
 		shards, err := a.pbm.ClusterMembers()
 		if err != nil {
 			l.Error("get cluster members: %v", err)
 			return
 		}
 		for _, sh := range shards {
-			go func(rs string) {
-				err := a.nominateRS(cmd.Name, rs, nodes.RS(rs), l)
+			candidates := nodes.RS(sh.RS)
+			if n, ok := override[sh.RS]; ok {
+				l.Info("backup node for %s is set by the user: %s", sh.RS, n)
+				candidates = [][]string{{n}}
+			}
+			go func(rs string, candidates [][]string) {
+				err := a.nominateRS(cmd.Name, rs, candidates, l)
 				if err != nil {
 					l.Error("nodes nomination for %s: %v", rs, err)
 				}
-			}(sh.RS)
+			}(sh.RS, candidates)
 		}
 	}
 
@@ -211,12 +262,42 @@ func (a *Agent) Backup(cmd *pbm.BackupCmd, opid pbm.OPID, ep pbm.Epoch) {
 	}
 
 	l.Debug("releasing lock")
-	err = lock.Release()
+	err = lock.ReleaseWith(err)
 	if err != nil {
 		l.Error("unable to release backup lock %v: %v", lock, err)
 	}
 }
 
+// checkMongoVersions checks that the nodes run consistent mongod versions.
+// Depending on the config it only warns or fails the backup. Returns false
+// if the backup should not proceed.
+func (a *Agent) checkMongoVersions(bcp string, l *log.Event) bool {
+	cfg, err := a.pbm.GetConfig()
+	if err != nil {
+		l.Error("get config: %v", err)
+		return false
+	}
+	if cfg.Backup.MongoVersionCheck == pbm.MongoVersionCheckNone {
+		return true
+	}
+
+	err = a.pbm.CheckMongoVersions(cfg.Backup.MongoVersionTolerance)
+	if err == nil {
+		return true
+	}
+	if cfg.Backup.MongoVersionCheck != pbm.MongoVersionCheckStrict {
+		l.Warning("check mongo versions: %v", err)
+		return true
+	}
+
+	l.Error("check mongo versions: %v", err)
+	ferr := a.pbm.ChangeBackupState(bcp, pbm.StatusError, "check mongo versions: "+err.Error())
+	if ferr != nil {
+		l.Error("mark backup as failed: %v", ferr)
+	}
+	return false
+}
+
 const renominationFrame = 5 * time.Second
 
 func (a *Agent) nominateRS(bcp, rs string, nodes [][]string, l *log.Event) error {
@@ -247,7 +328,7 @@ func (a *Agent) nominateRS(bcp, rs string, nodes [][]string, l *log.Event) error
 			l.Warning("send heartbeat: %v", err)
 		}
 
-		time.Sleep(renominationFrame)
+		time.Sleep(retry.Jitter(renominationFrame))
 	}
 
 	return nil
@@ -298,7 +379,7 @@ func (a *Agent) Restore(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 	var stg storage.Storage
 	bcp, err := a.pbm.GetBackupMeta(r.BackupName)
 	if errors.Is(err, pbm.ErrNotFound) {
-		stg, err = a.pbm.GetStorage(l)
+		stg, err = a.pbm.GetRestoreStorage(l)
 		if err != nil {
 			l.Error("get storage: %v", err)
 			return
@@ -313,6 +394,11 @@ func (a *Agent) Restore(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 	switch bcp.Type {
 	case pbm.PhysicalBackup, pbm.IncrementalBackup:
 		err = a.restorePhysical(r, opid, ep, l)
+		if err != nil {
+			// the agent is stopped after a successful restore and syncs
+			// on the next start. Otherwise, the cluster may be still up.
+			a.SyncPhysRestores()
+		}
 	case pbm.LogicalBackup:
 		fallthrough
 	default:
@@ -326,7 +412,7 @@ func (a *Agent) Restore(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch) {
 }
 
 // restoreLogical starts the restore
-func (a *Agent) restoreLogical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch, l *log.Event) error {
+func (a *Agent) restoreLogical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch, l *log.Event) (err error) {
 	nodeInfo, err := a.node.GetInfo()
 	if err != nil {
 		return errors.Wrap(err, "get node info")
@@ -355,9 +441,9 @@ func (a *Agent) restoreLogical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch, l
 
 	defer func() {
 		l.Debug("releasing lock")
-		err := lock.Release()
-		if err != nil {
-			l.Error("release lock: %v", err)
+		rerr := lock.ReleaseWith(err)
+		if rerr != nil {
+			l.Error("release lock: %v", rerr)
 		}
 	}()
 
@@ -392,7 +478,7 @@ func (a *Agent) restorePhysical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch,
 		return errors.Wrap(err, "get node info")
 	}
 
-	rstr, err := restore.NewPhysical(a.pbm, a.node, nodeInfo, r.RSMap)
+	rstr, err := restore.NewPhysical(a.pbm, a.node, nodeInfo, r.RSMap, restore.NewMongodRunner())
 	if err != nil {
 		return errors.Wrap(err, "init physical backup")
 	}
@@ -426,6 +512,8 @@ func (a *Agent) restorePhysical(r *pbm.RestoreCmd, opid pbm.OPID, ep pbm.Epoch,
 		// restore. And the commands stream is down as well.
 		// The lock also updates its heartbeats but Restore waits only for one state
 		// with the timeout twice as short pbm.StaleFrameSec.
+		// The op log entry covers only the time the lock was held then,
+		// the restore outcome is in its metadata.
 		lock.Release()
 	}
 
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+type agentMaintenanceOpts struct {
+	state    string
+	node     string
+	duration time.Duration
+}
+
+func agentMaintenance(cn *pbm.PBM, o *agentMaintenanceOpts) (fmt.Stringer, error) {
+	rs, node, err := pbm.ParseNode(o.node)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse --node option")
+	}
+
+	d := o.duration
+	if o.state == "off" {
+		d = 0
+	} else if d <= 0 {
+		return nil, errors.New("maintenance duration should be positive")
+	}
+
+	err = cn.SetAgentMaintenance(rs, node, d)
+	if err != nil {
+		return nil, errors.Wrap(err, "set maintenance")
+	}
+
+	if d == 0 {
+		return outMsg{fmt.Sprintf("Maintenance of %s/%s is off", rs, node)}, nil
+	}
+	return outMsg{fmt.Sprintf("%s/%s is in maintenance for %s", rs, node, d)}, nil
+}
+
+type agentDoctorOpts struct {
+	wait time.Duration
+}
+
+type agentCheck struct {
+	RS    string              `json:"rs"`
+	Node  string              `json:"node"`
+	Items []pbm.SelfCheckItem `json:"checks,omitempty"`
+	// Err is set if the agent hasn't reported the results in time
+	Err string `json:"error,omitempty"`
+}
+
+type agentChecks []agentCheck
+
+func (c agentChecks) String() string {
+	s := ""
+	for _, a := range c {
+		s += fmt.Sprintf("%s/%s:\n", a.RS, a.Node)
+		if a.Err != "" {
+			s += fmt.Sprintf("  ERROR: %s\n", a.Err)
+		}
+		for _, i := range a.Items {
+			s += fmt.Sprintf("  - %s\n", i)
+		}
+	}
+	return s
+}
+
+// agentDoctor makes all agents run the self-check and waits for the results
+func agentDoctor(cn *pbm.PBM, o *agentDoctorOpts) (fmt.Stringer, error) {
+	ct, err := cn.ClusterTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+
+	err = cn.SendCmd(pbm.Cmd{Cmd: pbm.CmdAgentDoctor})
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	tmr := time.NewTimer(o.wait)
+	defer tmr.Stop()
+	tkr := time.NewTicker(time.Second)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-tkr.C:
+			agents, err := cn.AgentsStatus()
+			if err != nil {
+				return nil, errors.Wrap(err, "get agents status")
+			}
+			if rv, done := collectChecks(agents, ct, false); done {
+				return rv, nil
+			}
+		case <-tmr.C:
+			agents, err := cn.AgentsStatus()
+			if err != nil {
+				return nil, errors.Wrap(err, "get agents status")
+			}
+			rv, _ := collectChecks(agents, ct, true)
+			return rv, nil
+		}
+	}
+}
+
+// collectChecks returns the agents' self-check results made since `since`
+// and tells if all agents have reported them
+func collectChecks(agents []pbm.AgentStat, since primitive.Timestamp, timedOut bool) (agentChecks, bool) {
+	rv := make(agentChecks, 0, len(agents))
+	done := true
+	for _, a := range agents {
+		c := agentCheck{RS: a.RS, Node: a.Node}
+		if a.SelfCheck == nil || primitive.CompareTimestamp(a.SelfCheck.TS, since) < 0 {
+			done = false
+			if timedOut {
+				c.Err = "no self-check results. Check the agent is running and its logs"
+			}
+		} else {
+			c.Items = a.SelfCheck.Items
+		}
+		rv = append(rv, c)
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].RS != rv[j].RS {
+			return rv[i].RS < rv[j].RS
+		}
+		return rv[i].Node < rv[j].Node
+	})
+	return rv, done
+}
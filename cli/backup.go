@@ -3,15 +3,12 @@ package cli
 import (
 	"context"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/mod/semver"
-	"gopkg.in/yaml.v2"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
@@ -26,6 +23,7 @@ type backupOpts struct {
 	compressionLevel []int
 	ns               string
 	wait             bool
+	nodes            []string
 }
 
 type backupOut struct {
@@ -37,10 +35,6 @@ func (b backupOut) String() string {
 	return fmt.Sprintf("Backup '%s' to remote store '%s' has started", b.Name, b.Storage)
 }
 
-type descBcp struct {
-	name string
-}
-
 func runBackup(cn *pbm.PBM, b *backupOpts, outf outFormat) (fmt.Stringer, error) {
 	nss, err := parseCLINSOption(b.ns)
 	if err != nil {
@@ -53,6 +47,22 @@ func runBackup(cn *pbm.PBM, b *backupOpts, outf outFormat) (fmt.Stringer, error)
 		return nil, errors.New("--ns flag is not allowed for physical backup")
 	}
 
+	nodes, err := pbm.ParseBcpNodes(b.nodes)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse --node option")
+	}
+	if err := cn.CheckBcpNodes(nodes); err != nil {
+		return nil, errors.WithMessage(err, "check --node option")
+	}
+
+	frz, err := cn.GetBackupFreeze()
+	if err != nil {
+		return nil, errors.Wrap(err, "check backup freeze")
+	}
+	if frz != nil {
+		return nil, errors.Errorf("backups are frozen %s. Lift it with `pbm backup-freeze off`", frz)
+	}
+
 	if err := checkConcurrentOp(cn); err != nil {
 		// PITR slicing can be run along with the backup start - agents will resolve it.
 		op, ok := err.(concurentOpErr)
@@ -88,6 +98,7 @@ func runBackup(cn *pbm.PBM, b *backupOpts, outf outFormat) (fmt.Stringer, error)
 			Namespaces:       nss,
 			Compression:      compression,
 			CompressionLevel: level,
+			Nodes:            b.nodes,
 		},
 	})
 	if err != nil {
@@ -196,46 +207,6 @@ func waitForBcpStatus(ctx context.Context, cn *pbm.PBM, bcpName string) (err err
 	}
 }
 
-type bcpDesc struct {
-	Name               string         `json:"name" yaml:"name"`
-	OPID               string         `json:"opid" yaml:"opid"`
-	Type               pbm.BackupType `json:"type" yaml:"type"`
-	LastWriteTS        int64          `json:"last_write_ts" yaml:"-"`
-	LastTransitionTS   int64          `json:"last_transition_ts" yaml:"-"`
-	LastWriteTime      string         `json:"last_write_time" yaml:"last_write_time"`
-	LastTransitionTime string         `json:"last_transition_time" yaml:"last_transition_time"`
-	Namespaces         []string       `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
-	MongoVersion       string         `json:"mongodb_version" yaml:"mongodb_version"`
-	FCV                string         `json:"fcv" yaml:"fcv"`
-	PBMVersion         string         `json:"pbm_version" yaml:"pbm_version"`
-	Status             pbm.Status     `json:"status" yaml:"status"`
-	Size               int64          `json:"size" yaml:"-"`
-	HSize              string         `json:"size_h" yaml:"size_h"`
-	Err                *string        `json:"error,omitempty" yaml:"error,omitempty"`
-	Replsets           []bcpReplDesc  `json:"replsets" yaml:"replsets"`
-}
-
-type bcpReplDesc struct {
-	Name               string             `json:"name" yaml:"name"`
-	Status             pbm.Status         `json:"status" yaml:"status"`
-	LastWriteTS        int64              `json:"last_write_ts" yaml:"-"`
-	LastTransitionTS   int64              `json:"last_transition_ts" yaml:"-"`
-	LastWriteTime      string             `json:"last_write_time" yaml:"last_write_time"`
-	LastTransitionTime string             `json:"last_transition_time" yaml:"last_transition_time"`
-	IsConfigSvr        *bool              `json:"configsvr,omitempty" yaml:"configsvr,omitempty"`
-	SecurityOpts       *pbm.MongodOptsSec `json:"security,omitempty" yaml:"security,omitempty"`
-	Error              *string            `json:"error,omitempty" yaml:"error,omitempty"`
-}
-
-func (b *bcpDesc) String() string {
-	data, err := yaml.Marshal(b)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return string(data)
-}
-
 func byteCountIEC(b int64) string {
 	const unit = 1024
 
@@ -252,68 +223,35 @@ func byteCountIEC(b int64) string {
 	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
 }
 
-func describeBackup(cn *pbm.PBM, b *descBcp) (fmt.Stringer, error) {
-	bcp, err := cn.GetBackupMeta(b.name)
+type protectBcpOpts struct {
+	name  string
+	state string
+}
+
+func protectBackup(cn *pbm.PBM, o *protectBcpOpts) (fmt.Stringer, error) {
+	err := cn.SetBackupProtected(o.name, o.state == "on")
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "set protection")
 	}
 
-	rv := &bcpDesc{
-		Name:               bcp.Name,
-		OPID:               bcp.OPID,
-		Type:               bcp.Type,
-		Namespaces:         bcp.Namespaces,
-		MongoVersion:       bcp.MongoVersion,
-		FCV:                bcp.FCV,
-		PBMVersion:         bcp.PBMVersion,
-		LastWriteTS:        int64(bcp.LastWriteTS.T),
-		LastTransitionTS:   bcp.LastTransitionTS,
-		LastWriteTime:      time.Unix(int64(bcp.LastWriteTS.T), 0).UTC().Format(time.RFC3339),
-		LastTransitionTime: time.Unix(bcp.LastTransitionTS, 0).UTC().Format(time.RFC3339),
-		Status:             bcp.Status,
-		Size:               bcp.Size,
-		HSize:              byteCountIEC(bcp.Size),
-	}
-	if bcp.Err != "" {
-		rv.Err = &bcp.Err
-	}
-
-	if bcp.Size == 0 {
-		switch bcp.Status {
-		case pbm.StatusDone, pbm.StatusCancelled, pbm.StatusError:
-			stg, err := cn.GetStorage(cn.Logger().NewEvent("", "", "", primitive.Timestamp{}))
-			if err != nil {
-				return nil, errors.WithMessage(err, "get storage")
-			}
-
-			rv.Size, err = getLegacySnapshotSize(bcp, stg)
-			if errors.Is(err, errMissedFile) && bcp.Status != pbm.StatusDone {
-				// canceled/failed backup can be incomplete. ignore
-				return nil, errors.WithMessage(err, "get snapshot size")
-			}
-		}
+	if o.state == "off" {
+		return outMsg{fmt.Sprintf("Backup %s is no longer protected", o.name)}, nil
 	}
+	return outMsg{fmt.Sprintf("Backup %s is protected from deletion", o.name)}, nil
+}
 
-	rv.Replsets = make([]bcpReplDesc, len(bcp.Replsets))
-	for i, r := range bcp.Replsets {
-		rv.Replsets[i] = bcpReplDesc{
-			Name:               r.Name,
-			IsConfigSvr:        r.IsConfigSvr,
-			Status:             r.Status,
-			LastWriteTS:        int64(r.LastWriteTS.T),
-			LastTransitionTS:   r.LastTransitionTS,
-			LastWriteTime:      time.Unix(int64(r.LastWriteTS.T), 0).UTC().Format(time.RFC3339),
-			LastTransitionTime: time.Unix(r.LastTransitionTS, 0).UTC().Format(time.RFC3339),
-		}
-		if r.Error != "" {
-			rv.Replsets[i].Error = &r.Error
-		}
-		if r.MongodOpts != nil && r.MongodOpts.Security != nil {
-			rv.Replsets[i].SecurityOpts = r.MongodOpts.Security
-		}
+type markVerifiedOptions struct {
+	name   string
+	status string
+}
+
+func markVerified(cn *pbm.PBM, o *markVerifiedOptions) (fmt.Stringer, error) {
+	err := cn.SetBackupVerified(o.name, pbm.VerifyStatus(o.status), time.Now().Unix())
+	if err != nil {
+		return nil, errors.Wrap(err, "mark verified")
 	}
 
-	return rv, err
+	return outMsg{fmt.Sprintf("Backup %s is marked as verified: %s", o.name, o.status)}, nil
 }
 
 // bcpsMatchCluster checks if given backups match shards in the cluster. Match means that
@@ -71,7 +71,12 @@ func Main() {
 	configCmd.Flag("list", "List current settings").BoolVar(&cfg.list)
 	configCmd.Flag("file", "Upload config from YAML file").StringVar(&cfg.file)
 	configCmd.Flag("set", "Set the option value <key.name=value>").StringMapVar(&cfg.set)
-	configCmd.Arg("key", "Show the value of a specified key").StringVar(&cfg.key)
+	configCmd.Flag("export", "Print the whole config in the --file format (e.g. to keep it under version control). Secrets are redacted").BoolVar(&cfg.export)
+	configCmd.Flag("with-secrets", "Don't redact secrets in --export").BoolVar(&cfg.withSecrets)
+	configCmd.Flag("import", "Replace the config with the one from --export file (- for stdin) and show what has changed. Redacted secrets are kept from the current config").StringVar(&cfg.importFile)
+	configCmd.Flag("test-storage", "Check that the storage supports all operations PBM needs. Checks the storage from --file (without applying it) if given").BoolVar(&cfg.testStorage)
+	configCmd.Arg("key", "Show the value of a specified key. Or `history` to show config changes, `rollback <version>` to restore the config version").StringVar(&cfg.key)
+	configCmd.Arg("version", "Config version to roll back to").StringVar(&cfg.version)
 
 	backupCmd := pbmCmd.Command("backup", "Make backup")
 	backup := backupOpts{}
@@ -94,26 +99,56 @@ func Main() {
 		IntsVar(&backup.compressionLevel)
 	backupCmd.Flag("ns", `Namespaces to backup (e.g. "db.*", "db.collection"). If not set, backup all ("*.*")`).StringVar(&backup.ns)
 	backupCmd.Flag("wait", "Wait for the backup to finish").Short('w').BoolVar(&backup.wait)
+	backupCmd.Flag("node", "Take the backup from the given node instead of the one chosen by priority. Format: rs/host:port. Can be set once per replset").StringsVar(&backup.nodes)
 
 	cancelBcpCmd := pbmCmd.Command("cancel-backup", "Cancel backup")
 
 	descBcpCmd := pbmCmd.Command("describe-backup", "Describe backup")
 	descBcp := descBcp{}
 	descBcpCmd.Arg("backup_name", "Backup name").StringVar(&descBcp.name)
+	descBcpCmd.Flag("with-files", "Show the full list of the backup files of each replset (can be huge)").BoolVar(&descBcp.files)
+	descBcpCmd.Flag("verify-sizes", "Check the backup files exist on the storage and match the sizes in the backup metadata").BoolVar(&descBcp.verifySizes)
+	descBcpCmd.Flag("full-history", "Show all state transitions of the backup instead of the latest ones kept in the metadata").BoolVar(&descBcp.fullHistory)
 
 	restoreCmd := pbmCmd.Command("restore", "Restore backup")
 	restore := restoreOpts{}
 	restoreCmd.Arg("backup_name", "Backup name to restore").StringVar(&restore.bcp)
 	restoreCmd.Flag("time", fmt.Sprintf("Restore to the point-in-time. Set in format %s", datetimeFormat)).StringVar(&restore.pitr)
+	restoreCmd.Flag("before", fmt.Sprintf("Restore to the latest point strictly before the given time (e.g. of an erroneous operation). Set in format %s or as a cluster time <T,I>", datetimeFormat)).StringVar(&restore.before)
 	restoreCmd.Flag("base-snapshot", "Override setting: Name of older snapshot that PITR will be based on during restore.").StringVar(&restore.pitrBase)
+	restoreCmd.Flag("type", fmt.Sprintf("Type of the point-in-time restore base: <%s>/<%s>. For %s, only --dry-run is supported yet to preview the base: the most recent physical or incremental backup unless --base-snapshot is set",
+		pbm.LogicalBackup, pbm.PhysicalBackup, pbm.PhysicalBackup)).
+		Default(string(pbm.LogicalBackup)).
+		EnumVar(&restore.pitrType, string(pbm.LogicalBackup), string(pbm.PhysicalBackup))
+	restoreCmd.Flag("max-lag", "Point-in-time restore: allow to stop up to this duration (e.g. 5m) before --time if the oplog isn't available up to it yet. All replsets stop at the same point").DurationVar(&restore.maxLag)
 	restoreCmd.Flag("ns", `Namespaces to restore (e.g. "db1.*,db2.collection2"). If not set, restore all ("*.*")`).StringVar(&restore.ns)
 	restoreCmd.Flag("wait", "Wait for the restore to finish.").Short('w').BoolVar(&restore.wait)
 	restoreCmd.Flag(RSMappingFlag, RSMappingDoc).Envar(RSMappingEnvVar).StringVar(&restore.rsMap)
+	restoreCmd.Flag("allow-platform-mismatch", "Restore a physical backup even if it's made on a platform (CPU architecture, OS) known to be incompatible with the target one").BoolVar(&restore.allowPlatformMismatch)
+	restoreCmd.Flag("force", "Physical restore: remove files in the dbpath that weren't created by mongod and proceed if the dbpath is shared with other mongod instances instead of failing").BoolVar(&restore.force)
+	restoreCmd.Flag("with-pbm-state", "Logical restore: restore the PBM config and backups metadata from the backup (disaster recovery of PBM itself). The backups list is resynced from the restored config's storage afterwards").BoolVar(&restore.withPBMState)
+	restoreCmd.Flag("skip-capability-check", "Physical restore: don't check the nodes' mongod binary and free disk space reported by the agents before starting").BoolVar(&restore.skipCapCheck)
+	restoreCmd.Flag("wt-salvage", "Physical restore: salvage the data of a backup with a damaged WiredTiger checkpoint with `mongod --repair`. DATA IT CAN'T READ IS DISCARDED. Has to be allowed by restore.wiredTigerSalvage in the config").BoolVar(&restore.wtSalvage)
+	restoreCmd.Flag("new-replset-id", "Physical restore: generate new replicaSetIds for the restored replsets instead of keeping the current ones (e.g. for a clone of the cluster). Members that aren't restored (arbiters, nodes without pbm-agent) have to be wiped and initial synced to rejoin").BoolVar(&restore.newReplsetID)
+	restoreCmd.Flag("snapshot-only", "Physical restore: land each replset exactly at its backup checkpoint without rolling the oplog forward to the backup point (e.g. for bit-exact compliance copies). Replsets may end up at different points in time. Not for incremental backups").BoolVar(&restore.snapshotOnly)
+	restoreCmd.Flag("oplog-size-mb", "Physical restore: oplog size (in MB) of the restored nodes. By default, a node keeps its own oplog size if it's larger than the backup's one").Float64Var(&restore.oplogSizeMB)
+	restoreCmd.Flag("leader-rs", "Physical restore: replset whose primary coordinates the restore instead of the config server primary (e.g. if the config servers have slow disks or links)").StringVar(&restore.leaderRS)
+	restoreCmd.Flag("partly-done", "Physical restore: outcome of a replset where some nodes failed. lenient - partlyDone if any node succeeded, quorum - if the majority of voting members succeeded, strict - fail. Overrides restore.partlyDonePolicy in the config").
+		EnumVar(&restore.partlyDone, string(pbm.PartlyDoneLenient), string(pbm.PartlyDoneQuorum), string(pbm.PartlyDoneStrict))
+	restoreCmd.Flag("ns-allowlist", `Namespaces the backup may contain (e.g. "db1.*,db2.collection2"). The restore is refused if it contains others. Only logical backups list their namespaces`).StringVar(&restore.nsAllowlist)
+	restoreCmd.Flag("ns-allowlist-warn", "Only warn if the backup contains namespaces not on --ns-allowlist").BoolVar(&restore.nsAllowlistWarn)
+	restoreCmd.Flag("dry-run", "Resolve the backup, run the --ns-allowlist check and report the plan without starting the restore. For the physical restore, it reports the dbpath data each node would wipe and the backup data written instead").BoolVar(&restore.dryRun)
+	restoreCmd.Flag("list-files", "With --dry-run, list the files the physical restore would wipe and write on each node").BoolVar(&restore.listFiles)
+	restoreCmd.Flag("yes", "Don't ask confirmation if the backup's shards differ from the cluster's ones").Short('y').BoolVar(&restore.yes)
 
 	replayCmd := pbmCmd.Command("oplog-replay", "Replay oplog")
 	replayOpts := replayOptions{}
-	replayCmd.Flag("start", fmt.Sprintf("Replay oplog from the time. Set in format %s", datetimeFormat)).Required().StringVar(&replayOpts.start)
-	replayCmd.Flag("end", "Replay oplog to the time. Set in format %s").Required().StringVar(&replayOpts.end)
+	replayCmd.Flag("start", fmt.Sprintf("Replay oplog from the time. Set in format %s", datetimeFormat)).StringVar(&replayOpts.start)
+	replayCmd.Flag("end", fmt.Sprintf("Replay oplog to the time. Set in format %s", datetimeFormat)).StringVar(&replayOpts.end)
+	replayCmd.Flag("roll-forward", "Roll the cluster forward from the point the last successful restore (or oplog replay) brought it to. "+
+		"The replay is refused if there's a gap between the point and --start, a physical restore was started after that restore "+
+		"or the cluster has taken writes since it. Without --end, replays up to the latest point all replsets have the oplog for").
+		BoolVar(&replayOpts.rollForward)
 	replayCmd.Flag("wait", "Wait for the restore to finish.").Short('w').BoolVar(&replayOpts.wait)
 	replayCmd.Flag(RSMappingFlag, RSMappingDoc).Envar(RSMappingEnvVar).StringVar(&replayOpts.rsMap)
 	// todo(add oplog cancel)
@@ -133,6 +168,21 @@ func Main() {
 	deleteBcpCmd.Flag("yes", "Don't ask confirmation").Short('y').BoolVar(&deleteBcp.force)
 	deleteBcpCmd.Flag("force", "Force. Don't ask confirmation").Short('f').BoolVar(&deleteBcp.force)
 
+	protectBcpCmd := pbmCmd.Command("protect-backup", "Protect a backup from deletion")
+	protectBcp := protectBcpOpts{}
+	protectBcpCmd.Arg("name", "Backup name").Required().StringVar(&protectBcp.name)
+	protectBcpCmd.Arg("state", "Protection state <on>/<off>").Default("on").EnumVar(&protectBcp.state, "on", "off")
+
+	unprotectBcpCmd := pbmCmd.Command("unprotect-backup", "Lift the deletion protection of a backup")
+	unprotectBcp := protectBcpOpts{state: "off"}
+	unprotectBcpCmd.Arg("name", "Backup name").Required().StringVar(&unprotectBcp.name)
+
+	markVerifiedCmd := pbmCmd.Command("mark-verified", "Record the outcome of a backup verification made outside of PBM")
+	markVerifiedOpts := markVerifiedOptions{}
+	markVerifiedCmd.Arg("name", "Backup name").Required().StringVar(&markVerifiedOpts.name)
+	markVerifiedCmd.Flag("status", "Verification outcome <ok>/<failed>").Default(string(pbm.VerifyOK)).
+		EnumVar(&markVerifiedOpts.status, string(pbm.VerifyOK), string(pbm.VerifyFailed))
+
 	deletePitrCmd := pbmCmd.Command("delete-pitr", "Delete PITR chunks")
 	deletePitr := deletePitrOpts{}
 	deletePitrCmd.Flag("older-than", fmt.Sprintf("Delete backups older than date/time in format %s or %s", datetimeFormat, dateFormat)).StringVar(&deletePitr.olderThan)
@@ -140,6 +190,18 @@ func Main() {
 	deletePitrCmd.Flag("yes", "Don't ask confirmation").Short('y').BoolVar(&deletePitr.force)
 	deletePitrCmd.Flag("force", "Force. Don't ask confirmation").Short('f').BoolVar(&deletePitr.force)
 
+	exportPitrCmd := pbmCmd.Command("export-pitr", "Copy PITR chunks to another storage (e.g. of a DR site)")
+	exportPitr := pitrCopyOpts{}
+	exportPitrCmd.Flag("to", "Path to a PBM config with the destination storage").Required().StringVar(&exportPitr.cfg)
+	exportPitrCmd.Flag("start", fmt.Sprintf("Copy chunks from the time. Set in format %s", datetimeFormat)).StringVar(&exportPitr.start)
+	exportPitrCmd.Flag("end", fmt.Sprintf("Copy chunks to the time. Set in format %s", datetimeFormat)).StringVar(&exportPitr.end)
+
+	importPitrCmd := pbmCmd.Command("import-pitr", "Copy PITR chunks from another storage and make them available for restores")
+	importPitr := pitrCopyOpts{}
+	importPitrCmd.Flag("from", "Path to a PBM config with the source storage").Required().StringVar(&importPitr.cfg)
+	importPitrCmd.Flag("start", fmt.Sprintf("Copy chunks from the time. Set in format %s", datetimeFormat)).StringVar(&importPitr.start)
+	importPitrCmd.Flag("end", fmt.Sprintf("Copy chunks to the time. Set in format %s", datetimeFormat)).StringVar(&importPitr.end)
+
 	cleanupCmd := pbmCmd.Command("cleanup", "Delete Backups and PITR chunks")
 	cleanupOpts := cleanupOptions{}
 	cleanupCmd.Flag("older-than", fmt.Sprintf("Delete older than date/time in format %s or %s", datetimeFormat, dateFormat)).StringVar(&cleanupOpts.olderThan)
@@ -161,19 +223,72 @@ func Main() {
 	statusOpts := statusOptions{}
 	statusCmd := pbmCmd.Command("status", "Show PBM status")
 	statusCmd.Flag(RSMappingFlag, RSMappingDoc).Envar(RSMappingEnvVar).StringVar(&statusOpts.rsMap)
-	statusCmd.Flag("sections", "Sections of status to display <cluster>/<pitr>/<running>/<backups>.").Short('s').
-		EnumsVar(&statusOpts.sections, "cluster", "pitr", "running", "backups")
+	statusCmd.Flag("sections", "Sections of status to display <cluster>/<pitr>/<running>/<backups>/<coverage>.").Short('s').
+		EnumsVar(&statusOpts.sections, "cluster", "pitr", "running", "backups", "coverage")
+	statusCmd.Flag("pitr-stats", "Show size and span distribution of the recent PITR chunks").BoolVar(&statusOpts.pitrStats)
+
+	freezeCmd := pbmCmd.Command("backup-freeze", "Suppress new backups and PITR slicing cluster-wide (e.g. during a maintenance window)")
+	freeze := backupFreezeOpts{}
+	freezeCmd.Arg("state", "Freeze state <on>/<off>").Required().EnumVar(&freeze.state, "on", "off")
+	freezeCmd.Flag("duration", "Lift the freeze automatically after the duration (e.g. 30m, 2h). It lasts until turned off if not set").DurationVar(&freeze.duration)
+
+	agentCmd := pbmCmd.Command("agent", "Manage agents")
+	agentMntCmd := agentCmd.Command("maintenance", "Exclude the node from backups and restores without stopping the agent")
+	agentMnt := agentMaintenanceOpts{}
+	agentMntCmd.Arg("state", "Maintenance state <on>/<off>").Required().EnumVar(&agentMnt.state, "on", "off")
+	agentMntCmd.Flag("node", "The node in format rs/host:port").Required().StringVar(&agentMnt.node)
+	agentMntCmd.Flag("duration", "Turn the maintenance off automatically after the duration (e.g. 30m, 2h)").Default("1h").DurationVar(&agentMnt.duration)
+	agentDoctorCmd := agentCmd.Command("doctor", "Run the self-check on all agents and show the results")
+	agentDoc := agentDoctorOpts{}
+	agentDoctorCmd.Flag("wait", "Time to wait for the agents' results").Default("30s").DurationVar(&agentDoc.wait)
+
+	opLogCmd := pbmCmd.Command("oplog", "PBM operations log")
+	opLogListCmd := opLogCmd.Command("list", "Show the timeline of operations across replsets")
+	opLog := opLogOpts{}
+	opLogListCmd.Flag("since", fmt.Sprintf("Show operations started since date/time in format %s or %s", datetimeFormat, dateFormat)).StringVar(&opLog.since)
 
 	describeRestoreCmd := pbmCmd.Command("describe-restore", "Describe restore")
 	describeRestoreOpts := descrRestoreOpts{}
 	describeRestoreCmd.Arg("name", "Restore name").StringVar(&describeRestoreOpts.restore)
 	describeRestoreCmd.Flag("config", "Path to PBM config").Short('c').StringVar(&describeRestoreOpts.cfg)
+	describeRestoreCmd.Flag("full-history", "Show all state transitions of the restore").BoolVar(&describeRestoreOpts.fullHistory)
+	describeRestoreCmd.Flag("list-files", "List the files the physical restore wiped and wrote on each node").BoolVar(&describeRestoreOpts.listFiles)
+
+	restoreValidateCmd := pbmCmd.Command("restore-validate", "Compare the restored namespaces' counts and sizes with the backup's ones")
+	restoreValidate := restoreValidateOpts{}
+	restoreValidateCmd.Arg("name", "Restore name").Required().StringVar(&restoreValidate.restore)
+	restoreValidateCmd.Flag(RSMappingFlag, RSMappingDoc).Envar(RSMappingEnvVar).StringVar(&restoreValidate.rsMap)
+	restoreValidateCmd.Flag("count-tolerance", "Allowed difference of the documents count, percent").Default("1").Float64Var(&restoreValidate.countPct)
+	restoreValidateCmd.Flag("size-tolerance", "Allowed difference of the data size, percent").Default("10").Float64Var(&restoreValidate.sizePct)
+
+	restoreLogsCmd := pbmCmd.Command("restore-logs", "Show the log of a node's physical restore saved to the storage")
+	restoreLogs := restoreLogsOpts{}
+	restoreLogsCmd.Arg("name", "Restore name").Required().StringVar(&restoreLogs.restore)
+	restoreLogsCmd.Flag("node", "The node in format rs/host:port").Short('n').Required().StringVar(&restoreLogs.node)
+	restoreLogsCmd.Flag("config", "Path to PBM config").Short('c').Required().StringVar(&restoreLogs.cfg)
+	restoreLogsCmd.Flag("follow", "Follow the log while the node's restore runs").Short('f').BoolVar(&restoreLogs.follow)
+	restoreLogsCmd.Flag("severity", "Severity level D, I, W, E or F, low to high. Choosing one includes higher levels too.").Short('s').Default("I").EnumVar(&restoreLogs.severity, "D", "I", "W", "E", "F")
+
+	completionCmd := pbmCmd.Command("completion", "Print the shell completion script. E.g. `source <(pbm completion bash)`")
+	completionShell := completionCmd.Arg("shell", "Shell <bash>/<zsh>/<fish>").Required().Enum("bash", "zsh", "fish")
+
+	completeCmd := pbmCmd.Command(completeCmdName, "Print completions for the command line words").Hidden()
+	completeWords := completeCmd.Arg("words", "Command line words, the last one is completed").Strings()
 
 	cmd, err := pbmCmd.DefaultEnvars().Parse(os.Args[1:])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error: parse command line parameters:", err)
 		os.Exit(1)
 	}
+
+	switch cmd {
+	case completionCmd.FullCommand():
+		printCompletionScript(*completionShell)
+		return
+	case completeCmd.FullCommand():
+		runComplete(os.Stdout, pbmCmd, *mURL, *completeWords)
+		return
+	}
 	pbmOutF := outFormat(*pbmOutFormat)
 	var out fmt.Stringer
 
@@ -202,7 +317,8 @@ func Main() {
 
 	var pbmClient *pbm.PBM
 	// we don't need pbm connection if it is `pbm describe-restore -c ...`
-	if describeRestoreOpts.cfg == "" {
+	// or `pbm restore-logs`
+	if describeRestoreOpts.cfg == "" && cmd != restoreLogsCmd.FullCommand() {
 		pbmClient, err = pbm.New(ctx, *mURL, "pbm-ctl")
 		if err != nil {
 			exitErr(errors.Wrap(err, "connect to mongodb"), pbmOutF)
@@ -228,8 +344,18 @@ func Main() {
 		out, err = runList(pbmClient, &list)
 	case deleteBcpCmd.FullCommand():
 		out, err = deleteBackup(pbmClient, &deleteBcp, pbmOutF)
+	case protectBcpCmd.FullCommand():
+		out, err = protectBackup(pbmClient, &protectBcp)
+	case unprotectBcpCmd.FullCommand():
+		out, err = protectBackup(pbmClient, &unprotectBcp)
+	case markVerifiedCmd.FullCommand():
+		out, err = markVerified(pbmClient, &markVerifiedOpts)
 	case deletePitrCmd.FullCommand():
 		out, err = deletePITR(pbmClient, &deletePitr, pbmOutF)
+	case exportPitrCmd.FullCommand():
+		out, err = exportPITR(pbmClient, &exportPitr)
+	case importPitrCmd.FullCommand():
+		out, err = importPITR(pbmClient, &importPitr)
 	case cleanupCmd.FullCommand():
 		out, err = retentionCleanup(pbmClient, &cleanupOpts)
 	case logsCmd.FullCommand():
@@ -238,6 +364,18 @@ func Main() {
 		out, err = status(pbmClient, *mURL, statusOpts, pbmOutF == outJSONpretty)
 	case describeRestoreCmd.FullCommand():
 		out, err = describeRestore(pbmClient, describeRestoreOpts)
+	case restoreValidateCmd.FullCommand():
+		out, err = runRestoreValidate(pbmClient, *mURL, &restoreValidate)
+	case restoreLogsCmd.FullCommand():
+		out, err = runRestoreLogs(&restoreLogs)
+	case freezeCmd.FullCommand():
+		out, err = backupFreeze(pbmClient, &freeze)
+	case agentMntCmd.FullCommand():
+		out, err = agentMaintenance(pbmClient, &agentMnt)
+	case agentDoctorCmd.FullCommand():
+		out, err = agentDoctor(pbmClient, &agentDoc)
+	case opLogListCmd.FullCommand():
+		out, err = opLogList(pbmClient, &opLog)
 	}
 
 	if err != nil {
@@ -322,20 +460,7 @@ func runLogs(cn *pbm.PBM, l *logsOpts) (fmt.Stringer, error) {
 		r.OPID = l.opid
 	}
 
-	switch l.severity {
-	case "F":
-		r.Severity = log.Fatal
-	case "E":
-		r.Severity = log.Error
-	case "W":
-		r.Severity = log.Warning
-	case "I":
-		r.Severity = log.Info
-	case "D":
-		r.Severity = log.Debug
-	default:
-		r.Severity = log.Info
-	}
+	r.Severity = parseSeverity(l.severity)
 
 	if l.follow {
 		err := followLogs(cn, r, r.Node == "", l.extr)
@@ -400,6 +525,12 @@ type snapshotStat struct {
 	PBMVersion string         `json:"pbmVersion"`
 	Type       pbm.BackupType `json:"type"`
 	SrcBackup  string         `json:"src"`
+	Protected  bool           `json:"protected,omitempty"`
+
+	LastVerifiedTS      int64            `json:"lastVerified,omitempty"`
+	LastVerifyStatus    pbm.VerifyStatus `json:"lastVerifyStatus,omitempty"`
+	LastRestoreTestName string           `json:"lastRestoreTest,omitempty"`
+	LastRestoreTestTS   int64            `json:"lastRestoreTestTS,omitempty"`
 }
 
 type pitrRange struct {
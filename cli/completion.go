@@ -0,0 +1,449 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+const (
+	// completeCmdName is the hidden command the completion scripts call
+	// with the words of the command line (the last one is the word being
+	// completed) to get the candidates, one per line
+	completeCmdName = "__complete"
+
+	// completeTimeout bounds connecting and querying the cluster so the
+	// shell doesn't hang if it's unreachable
+	completeTimeout = 2 * time.Second
+	// completeLimit is the max number of backups/restores to suggest
+	completeLimit = 100
+)
+
+type completeKind int
+
+const (
+	completeNone completeKind = iota
+	completeBackups
+	completeRestores
+	completeConfigKeys
+)
+
+// completeArgs is the dynamic completion of the first positional argument
+// of the commands
+var completeArgs = map[string]completeKind{
+	"config":           completeConfigKeys,
+	"describe-backup":  completeBackups,
+	"restore":          completeBackups,
+	"delete-backup":    completeBackups,
+	"protect-backup":   completeBackups,
+	"unprotect-backup": completeBackups,
+	"mark-verified":    completeBackups,
+	"describe-restore": completeRestores,
+	"restore-validate": completeRestores,
+	"restore-logs":     completeRestores,
+}
+
+// completionSource provides the cluster's metadata for dynamic completions
+type completionSource interface {
+	// Backups returns names of the done backups
+	Backups() ([]string, error)
+	Restores() ([]string, error)
+	// Replsets returns names of the cluster's replsets
+	Replsets() ([]string, error)
+	// BackupReplsets returns names of the replsets in the done backups
+	BackupReplsets() ([]string, error)
+}
+
+// pbmSource is completionSource that queries the cluster. It connects on
+// the first query, so static completions don't wait for the cluster.
+type pbmSource struct {
+	ctx context.Context
+	uri string
+
+	once    sync.Once
+	cn      *pbm.PBM
+	err     error
+	backups []pbm.BackupMeta
+}
+
+func (s *pbmSource) conn() (*pbm.PBM, error) {
+	s.once.Do(func() {
+		if s.uri == "" {
+			s.err = errors.New("no mongodb connection URI supplied")
+			return
+		}
+		s.cn, s.err = pbm.New(s.ctx, s.uri, "pbm-ctl")
+	})
+	return s.cn, s.err
+}
+
+func (s *pbmSource) doneBackups() ([]pbm.BackupMeta, error) {
+	if s.backups != nil {
+		return s.backups, nil
+	}
+	cn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	s.backups, err = cn.BackupsDoneList(nil, completeLimit, -1)
+	return s.backups, err
+}
+
+func (s *pbmSource) Backups() ([]string, error) {
+	bcps, err := s.doneBackups()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(bcps))
+	for _, b := range bcps {
+		names = append(names, b.Name)
+	}
+	return names, nil
+}
+
+func (s *pbmSource) Restores() ([]string, error) {
+	cn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	rs, err := cn.RestoresList(completeLimit)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rs))
+	for _, r := range rs {
+		names = append(names, r.Name)
+	}
+	return names, nil
+}
+
+func (s *pbmSource) Replsets() ([]string, error) {
+	cn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	shards, err := cn.ClusterMembers()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(shards))
+	for _, sh := range shards {
+		names = append(names, sh.RS)
+	}
+	return names, nil
+}
+
+func (s *pbmSource) BackupReplsets() ([]string, error) {
+	bcps, err := s.doneBackups()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, b := range bcps {
+		for _, rs := range b.Replsets {
+			names = append(names, rs.Name)
+		}
+	}
+	return names, nil
+}
+
+// completer suggests the next word of the pbm command line
+type completer struct {
+	app *kingpin.ApplicationModel
+	src completionSource
+}
+
+// complete returns the candidates for the last of the words. Failed
+// dynamic completions yield no candidates.
+func (c *completer) complete(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+
+	var (
+		cmd      *kingpin.CmdModel
+		cmds     = c.app.Commands
+		flags    = c.app.Flags
+		args     int
+		flagArgs bool
+	)
+	for i := 0; i < len(words)-1; i++ {
+		w := words[i]
+		switch {
+		case w == "--":
+			flagArgs = true
+		case strings.HasPrefix(w, "-") && !flagArgs:
+			// the next word is the flag's value
+			if f := findFlag(flags, w); f != nil && !f.IsBoolFlag() && !strings.Contains(w, "=") {
+				i++
+			}
+		default:
+			if sub := findCmd(cmds, w); sub != nil && args == 0 {
+				cmd = sub
+				cmds = sub.Commands
+				flags = append(flags[:len(flags):len(flags)], sub.Flags...)
+				continue
+			}
+			args++
+		}
+	}
+
+	// a flag's value
+	if !flagArgs {
+		if i := strings.Index(cur, "="); strings.HasPrefix(cur, "-") && i != -1 {
+			if f := findFlag(flags, cur[:i]); f != nil {
+				return prefixed(cur[:i+1], c.flagValue(cmd, f, cur[i+1:]))
+			}
+			return nil
+		}
+		if len(words) > 1 {
+			prev := words[len(words)-2]
+			if f := findFlag(flags, prev); strings.HasPrefix(prev, "-") && f != nil && !f.IsBoolFlag() && !strings.Contains(prev, "=") {
+				return c.flagValue(cmd, f, cur)
+			}
+		}
+	}
+
+	if strings.HasPrefix(cur, "-") && !flagArgs {
+		var cands []string
+		for _, f := range flags {
+			if !f.Hidden {
+				cands = append(cands, "--"+f.Name)
+			}
+		}
+		return filterPrefix(cands, cur)
+	}
+
+	if args == 0 && len(cmds) > 0 {
+		var cands []string
+		for _, sc := range cmds {
+			if !sc.Hidden {
+				cands = append(cands, sc.Name)
+			}
+		}
+		return filterPrefix(cands, cur)
+	}
+
+	if cmd == nil || args != 0 {
+		return nil
+	}
+	switch completeArgs[cmd.FullCommand] {
+	case completeBackups:
+		return filterPrefix(ignoreErr(c.src.Backups()), cur)
+	case completeRestores:
+		return filterPrefix(ignoreErr(c.src.Restores()), cur)
+	case completeConfigKeys:
+		return filterPrefix(pbm.ConfigKeys(), cur)
+	}
+	return nil
+}
+
+func (c *completer) flagValue(cmd *kingpin.CmdModel, f *kingpin.FlagModel, cur string) []string {
+	switch {
+	case f.Name == RSMappingFlag:
+		return c.rsMapping(cur)
+	case f.Name == "set" && cmd != nil && cmd.FullCommand == "config":
+		return filterPrefix(prefixed("", pbm.ConfigKeys(), "="), cur)
+	}
+	return nil
+}
+
+// rsMapping completes the last to_name=from_name pair of the
+// --replset-remapping value: the cluster's replsets before `=`, and the
+// backups' ones after it
+func (c *completer) rsMapping(cur string) []string {
+	done := ""
+	pair := cur
+	if i := strings.LastIndex(cur, ","); i != -1 {
+		done, pair = cur[:i+1], cur[i+1:]
+	}
+
+	if i := strings.Index(pair, "="); i != -1 {
+		names := ignoreErr(c.src.BackupReplsets())
+		return filterPrefix(prefixed(done+pair[:i+1], unique(names)), cur)
+	}
+
+	names := ignoreErr(c.src.Replsets())
+	return filterPrefix(prefixed(done, unique(names), "="), cur)
+}
+
+func runComplete(w io.Writer, app *kingpin.Application, uri string, words []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), completeTimeout)
+	defer cancel()
+
+	c := &completer{
+		app: app.Model(),
+		src: &pbmSource{ctx: ctx, uri: completeURI(uri, words)},
+	}
+	for _, s := range c.complete(words) {
+		fmt.Fprintln(w, s)
+	}
+}
+
+// completeURI returns the --mongodb-uri given on the completed command
+// line if any
+func completeURI(uri string, words []string) string {
+	for i, w := range words {
+		switch {
+		case w == "--mongodb-uri" && i+1 < len(words)-1:
+			return words[i+1]
+		case strings.HasPrefix(w, "--mongodb-uri=") && i < len(words)-1:
+			return strings.TrimPrefix(w, "--mongodb-uri=")
+		}
+	}
+	return uri
+}
+
+func findCmd(cmds []*kingpin.CmdModel, name string) *kingpin.CmdModel {
+	for _, c := range cmds {
+		if c.Name == name {
+			return c
+		}
+		for _, a := range c.Aliases {
+			if a == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// findFlag returns the flag of `--name[=value]` or `-s` word
+func findFlag(flags []*kingpin.FlagModel, w string) *kingpin.FlagModel {
+	if strings.HasPrefix(w, "--") {
+		name := strings.SplitN(w[2:], "=", 2)[0]
+		for _, f := range flags {
+			if f.Name == name {
+				return f
+			}
+		}
+		return nil
+	}
+	if len(w) == 2 && w[0] == '-' {
+		for _, f := range flags {
+			if f.Short == rune(w[1]) {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+func filterPrefix(cands []string, prefix string) []string {
+	var ret []string
+	for _, c := range cands {
+		if strings.HasPrefix(c, prefix) {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func prefixed(prefix string, ss []string, suffix ...string) []string {
+	sfx := strings.Join(suffix, "")
+	ret := make([]string, 0, len(ss))
+	for _, s := range ss {
+		ret = append(ret, prefix+s+sfx)
+	}
+	return ret
+}
+
+func unique(ss []string) []string {
+	set := make(map[string]struct{}, len(ss))
+	ret := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if _, ok := set[s]; !ok {
+			set[s] = struct{}{}
+			ret = append(ret, s)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ignoreErr drops the candidates of failed queries
+func ignoreErr(ss []string, err error) []string {
+	if err != nil {
+		return nil
+	}
+	return ss
+}
+
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion, nil
+	case "zsh":
+		return zshCompletion, nil
+	case "fish":
+		return fishCompletion, nil
+	}
+	return "", errors.Errorf("unsupported shell %q", shell)
+}
+
+func printCompletionScript(shell string) {
+	s, err := completionScript(shell)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	fmt.Print(s)
+}
+
+// bash splits words at `=`, `:` and `,` too, so the words are taken from
+// the line and the candidates trimmed to the part after the last of them
+const bashCompletion = `# pbm bash completion. Load with: source <(pbm completion bash)
+_pbm() {
+    local line="${COMP_LINE:0:$COMP_POINT}"
+    local -a words
+    read -ra words <<< "$line"
+    [[ $line == *[[:space:]] ]] && words+=("")
+    local cur="${words[${#words[@]}-1]}"
+    local pre="${cur%[=:,]*}" n=0
+    [[ $pre != "$cur" ]] && n=$(( ${#pre} + 1 ))
+
+    local IFS=$'\n'
+    COMPREPLY=( $("${words[0]}" ` + completeCmdName + ` -- "${words[@]:1}" 2>/dev/null | cut -c$(( n + 1 ))-) )
+    if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == *[=,] ]]; then
+        compopt -o nospace
+    fi
+}
+complete -o default -F _pbm pbm
+`
+
+const zshCompletion = `#compdef pbm
+# pbm zsh completion. Load with: source <(pbm completion zsh)
+_pbm() {
+    local -a cands
+    local c
+    cands=("${(@f)$(${words[1]} ` + completeCmdName + ` -- "${(@)words[2,$CURRENT]}" 2>/dev/null)}")
+    for c in $cands; do
+        if [[ $c == *[=,] ]]; then
+            compadd -Q -S '' -- "$c"
+        else
+            compadd -Q -- "$c"
+        fi
+    done
+}
+compdef _pbm pbm
+`
+
+const fishCompletion = `# pbm fish completion. Load with: pbm completion fish | source
+function __pbm_complete
+    set -l cur (commandline -ct)
+    set -l words (commandline -opc) "$cur"
+    $words[1] ` + completeCmdName + ` -- $words[2..-1] 2>/dev/null
+end
+complete -c pbm -f -a '(__pbm_complete)'
+`
@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/pkg/errors"
+)
+
+type fakeCompletionSource struct {
+	err error
+}
+
+func (s fakeCompletionSource) Backups() ([]string, error) {
+	return []string{"2023-01-02T10:00:00Z", "2023-01-01T10:00:00Z"}, s.err
+}
+
+func (s fakeCompletionSource) Restores() ([]string, error) {
+	return []string{"2023-02-01T10:00:00.1Z"}, s.err
+}
+
+func (s fakeCompletionSource) Replsets() ([]string, error) {
+	return []string{"cfg", "rs0", "rs1"}, s.err
+}
+
+func (s fakeCompletionSource) BackupReplsets() ([]string, error) {
+	return []string{"rsA", "cfg", "rsA", "rsB"}, s.err
+}
+
+func testCompleter(src completionSource) *completer {
+	app := kingpin.New("pbm", "")
+	app.Flag("mongodb-uri", "").String()
+	app.Flag("out", "").Short('o').String()
+	cfg := app.Command("config", "")
+	cfg.Flag("set", "").StringMap()
+	cfg.Flag("list", "").Bool()
+	cfg.Arg("key", "").String()
+	rst := app.Command("restore", "")
+	rst.Flag(RSMappingFlag, "").String()
+	rst.Flag("wait", "").Bool()
+	rst.Arg("backup_name", "").String()
+	app.Command("describe-restore", "").Arg("name", "").String()
+	app.Command("hidden", "").Hidden()
+	oplog := app.Command("oplog", "")
+	oplog.Command("list", "")
+
+	return &completer{app: app.Model(), src: src}
+}
+
+func TestComplete(t *testing.T) {
+	c := testCompleter(fakeCompletionSource{})
+
+	cases := []struct {
+		name  string
+		words []string
+		want  []string
+	}{
+		{"commands", []string{""}, []string{"config", "restore", "describe-restore", "oplog"}},
+		{"commands prefix", []string{"-o", "json", "re"}, []string{"restore"}},
+		{"subcommands", []string{"oplog", ""}, []string{"list"}},
+		{"flags", []string{"restore", "--w"}, []string{"--wait"}},
+		{"backups", []string{"restore", "2023-01-02"}, []string{"2023-01-02T10:00:00Z"}},
+		{"backups after flags", []string{"restore", "--wait", "-o", "json", ""}, []string{"2023-01-02T10:00:00Z", "2023-01-01T10:00:00Z"}},
+		{"second arg", []string{"restore", "2023-01-02T10:00:00Z", ""}, nil},
+		{"restores", []string{"describe-restore", ""}, []string{"2023-02-01T10:00:00.1Z"}},
+		{"config key", []string{"config", "pitr.ena"}, []string{"pitr.enabled"}},
+		{"config set", []string{"config", "--set", "pitr.ena"}, []string{"pitr.enabled="}},
+		{"config set=", []string{"config", "--set=pitr.ena"}, []string{"--set=pitr.enabled="}},
+		{"rsmap to", []string{"restore", "--" + RSMappingFlag, "r"}, []string{"rs0=", "rs1="}},
+		{"rsmap from", []string{"restore", "--" + RSMappingFlag, "rs0=rs"}, []string{"rs0=rsA", "rs0=rsB"}},
+		{"rsmap next", []string{"restore", "--" + RSMappingFlag + "=rs0=rsA,rs1=rsB"}, []string{"--" + RSMappingFlag + "=rs0=rsA,rs1=rsB"}},
+		{"rsmap next to", []string{"restore", "--" + RSMappingFlag + "=rs0=rsA,"}, []string{
+			"--" + RSMappingFlag + "=rs0=rsA,cfg=",
+			"--" + RSMappingFlag + "=rs0=rsA,rs0=",
+			"--" + RSMappingFlag + "=rs0=rsA,rs1=",
+		}},
+		{"unknown flag value", []string{"--out", ""}, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := c.complete(tc.words)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("complete(%q) = %q, want %q", tc.words, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCompleteUnreachable(t *testing.T) {
+	c := testCompleter(fakeCompletionSource{err: errors.New("server selection timeout")})
+
+	if got := c.complete([]string{"restore", ""}); len(got) != 0 {
+		t.Errorf("backups: got %q, want none", got)
+	}
+	if got := c.complete([]string{"restore", "--" + RSMappingFlag, ""}); len(got) != 0 {
+		t.Errorf("rsmap: got %q, want none", got)
+	}
+	if got := c.complete([]string{"rest"}); !reflect.DeepEqual(got, []string{"restore"}) {
+		t.Errorf("commands: got %q, want [restore]", got)
+	}
+}
+
+func TestCompleteURI(t *testing.T) {
+	cases := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"restore", ""}, "env"},
+		{[]string{"--mongodb-uri", "mongodb://a", "restore", ""}, "mongodb://a"},
+		{[]string{"--mongodb-uri=mongodb://b", "restore", ""}, "mongodb://b"},
+		{[]string{"--mongodb-uri", "mongodb://"}, "env"},
+	}
+	for _, tc := range cases {
+		if got := completeURI("env", tc.words); got != tc.want {
+			t.Errorf("completeURI(%q) = %q, want %q", tc.words, got, tc.want)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package cli
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -20,6 +22,12 @@ type configOpts struct {
 	file  string
 	set   map[string]string
 	key   string
+	// version to roll back to
+	version     string
+	testStorage bool
+	export      bool
+	withSecrets bool
+	importFile  string
 }
 
 type confKV struct {
@@ -64,6 +72,52 @@ func runConfig(cn *pbm.PBM, c *configOpts) (fmt.Stringer, error) {
 			}
 		}
 		return o, nil
+	case c.key == "history":
+		h, err := cn.ConfigHistory()
+		if err != nil {
+			return nil, errors.Wrap(err, "get config history")
+		}
+		return configHistory(h), nil
+	case c.key == "rollback":
+		v, err := strconv.ParseInt(c.version, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid config version %q", c.version)
+		}
+		prev, err := cn.GetConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "get current config")
+		}
+		err = cn.RollbackConfig(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "rollback config")
+		}
+		curr, err := cn.GetConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "get config")
+		}
+		if !reflect.DeepEqual(prev.Storage, curr.Storage) {
+			if err := rsync(cn); err != nil {
+				return nil, errors.WithMessage(err, "resync")
+			}
+		}
+		return outMsg{fmt.Sprintf("Config is rolled back to version %d", v)}, nil
+	case c.export:
+		return nil, cn.ExportConfig(os.Stdout, c.withSecrets)
+	case len(c.importFile) > 0:
+		return importConfig(cn, c.importFile)
+	case c.testStorage:
+		cfg, err := cn.GetConfig()
+		if len(c.file) > 0 {
+			cfg, err = readConfigFile(c.file)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "get config")
+		}
+		rep, err := cn.TestStorage(cfg)
+		if err != nil {
+			return nil, errors.Wrap(err, "test storage")
+		}
+		return storageTestResult{rep}, nil
 	case len(c.key) > 0:
 		k, err := cn.GetConfigVar(c.key)
 		if err != nil {
@@ -76,14 +130,7 @@ func runConfig(cn *pbm.PBM, c *configOpts) (fmt.Stringer, error) {
 		}
 		return outMsg{"Storage resync started"}, nil
 	case len(c.file) > 0:
-		var buf []byte
-		var err error
-
-		if c.file == "-" {
-			buf, err = io.ReadAll(os.Stdin)
-		} else {
-			buf, err = os.ReadFile(c.file)
-		}
+		buf, err := readFile(c.file)
 		if err != nil {
 			return nil, errors.Wrap(err, "unable to read config file")
 		}
@@ -119,6 +166,90 @@ func runConfig(cn *pbm.PBM, c *configOpts) (fmt.Stringer, error) {
 	}
 }
 
+type configChanges []pbm.ConfigChange
+
+func (c configChanges) String() string {
+	if len(c) == 0 {
+		return "Config is the same, nothing is changed\n"
+	}
+
+	s := "Config is imported. Changes:\n"
+	for _, ch := range c {
+		switch {
+		case ch.Old == "":
+			s += fmt.Sprintf("  + %s: %s\n", ch.Key, ch.New)
+		case ch.New == "":
+			s += fmt.Sprintf("  - %s: %s\n", ch.Key, ch.Old)
+		default:
+			s += fmt.Sprintf("  ~ %s: %s -> %s\n", ch.Key, ch.Old, ch.New)
+		}
+	}
+	return s
+}
+
+func importConfig(cn *pbm.PBM, file string) (fmt.Stringer, error) {
+	buf, err := readFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read config file")
+	}
+
+	changes, err := cn.ImportConfig(bytes.NewReader(buf))
+	if err != nil {
+		return nil, errors.Wrap(err, "import config")
+	}
+
+	for _, ch := range changes {
+		if strings.HasPrefix(ch.Key, "storage.") {
+			if err := rsync(cn); err != nil {
+				return nil, errors.WithMessage(err, "resync")
+			}
+			break
+		}
+	}
+
+	return configChanges(changes), nil
+}
+
+func readFile(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
+
+func readConfigFile(name string) (pbm.Config, error) {
+	var cfg pbm.Config
+
+	buf, err := readFile(name)
+	if err != nil {
+		return cfg, errors.Wrap(err, "unable to read config file")
+	}
+	err = yaml.UnmarshalStrict(buf, &cfg)
+	if err != nil {
+		return cfg, errors.Wrap(err, "unable to  unmarshal config file")
+	}
+
+	return cfg, nil
+}
+
+type storageTestResult struct {
+	pbm.StorageTestReport
+}
+
+func (r storageTestResult) HasError() bool {
+	return !r.OK()
+}
+
+type configHistory []pbm.ConfigVersion
+
+func (h configHistory) String() string {
+	s := ""
+	for _, v := range h {
+		s += fmt.Sprintf("%4d  %s  %s  %s\n", v.Version, fmtTS(v.TS), v.Initiator, v.Op)
+	}
+	return s
+}
+
 func rsync(cn *pbm.PBM) error {
 	return cn.SendCmd(pbm.Cmd{
 		Cmd: pbm.CmdResync,
@@ -0,0 +1,362 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v2"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+type descBcp struct {
+	name string
+	// files adds the full list of the backup files of each replset
+	files bool
+	// verifySizes checks the files on the storage against the meta
+	verifySizes bool
+	// fullHistory shows all state transitions, not only the latest
+	// ones kept in the meta (see pbm.MaxConditions)
+	fullHistory bool
+}
+
+type bcpDesc struct {
+	Name               string                   `json:"name" yaml:"name"`
+	OPID               string                   `json:"opid" yaml:"opid"`
+	Type               pbm.BackupType           `json:"type" yaml:"type"`
+	Chain              []string                 `json:"chain,omitempty" yaml:"chain,omitempty"`
+	LastWriteTS        int64                    `json:"last_write_ts" yaml:"-"`
+	LastTransitionTS   int64                    `json:"last_transition_ts" yaml:"-"`
+	LastWriteTime      string                   `json:"last_write_time" yaml:"last_write_time"`
+	LastTransitionTime string                   `json:"last_transition_time" yaml:"last_transition_time"`
+	ConsistentTS       int64                    `json:"cluster_consistent_ts,omitempty" yaml:"-"`
+	ConsistentTime     string                   `json:"cluster_consistent_time,omitempty" yaml:"cluster_consistent_time,omitempty"`
+	Namespaces         []string                 `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	MongoVersion       string                   `json:"mongodb_version" yaml:"mongodb_version"`
+	FCV                string                   `json:"fcv" yaml:"fcv"`
+	PBMVersion         string                   `json:"pbm_version" yaml:"pbm_version"`
+	Status             pbm.Status               `json:"status" yaml:"status"`
+	Size               int64                    `json:"size" yaml:"-"`
+	HSize              string                   `json:"size_h" yaml:"size_h"`
+	Compression        compress.CompressionType `json:"compression,omitempty" yaml:"compression,omitempty"`
+	CompressionRatio   float64                  `json:"compression_ratio,omitempty" yaml:"compression_ratio,omitempty"`
+	LowCompression     bool                     `json:"low_compression,omitempty" yaml:"low_compression,omitempty"`
+	Storage            string                   `json:"storage,omitempty" yaml:"storage,omitempty"`
+	Err                *string                  `json:"error,omitempty" yaml:"error,omitempty"`
+	Protected          bool                     `json:"protected,omitempty" yaml:"protected,omitempty"`
+	Layout             string                   `json:"layout,omitempty" yaml:"layout,omitempty"`
+	Verification       *bcpVerifyDesc           `json:"verification,omitempty" yaml:"verification,omitempty"`
+	BalancerStop       *pbm.BalancerStopState   `json:"balancer_stop,omitempty" yaml:"balancer_stop,omitempty"`
+	Conditions         []condDesc               `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	Replsets           []bcpReplDesc            `json:"replsets" yaml:"replsets"`
+}
+
+type bcpVerifyDesc struct {
+	LastVerifiedTS      int64            `json:"last_verified_ts,omitempty" yaml:"-"`
+	LastVerifiedTime    string           `json:"last_verified_time,omitempty" yaml:"last_verified_time,omitempty"`
+	LastVerifyStatus    pbm.VerifyStatus `json:"last_verify_status,omitempty" yaml:"last_verify_status,omitempty"`
+	LastRestoreTest     string           `json:"last_restore_test,omitempty" yaml:"last_restore_test,omitempty"`
+	LastRestoreTestTS   int64            `json:"last_restore_test_ts,omitempty" yaml:"-"`
+	LastRestoreTestTime string           `json:"last_restore_test_time,omitempty" yaml:"last_restore_test_time,omitempty"`
+}
+
+type bcpReplDesc struct {
+	Name               string             `json:"name" yaml:"name"`
+	Status             pbm.Status         `json:"status" yaml:"status"`
+	Node               string             `json:"node,omitempty" yaml:"node,omitempty"`
+	ReplLagSec         int                `json:"repl_lag_sec,omitempty" yaml:"repl_lag_sec,omitempty"`
+	LastWriteTS        int64              `json:"last_write_ts" yaml:"-"`
+	LastTransitionTS   int64              `json:"last_transition_ts" yaml:"-"`
+	LastWriteTime      string             `json:"last_write_time" yaml:"last_write_time"`
+	LastTransitionTime string             `json:"last_transition_time" yaml:"last_transition_time"`
+	IsConfigSvr        *bool              `json:"configsvr,omitempty" yaml:"configsvr,omitempty"`
+	MongoVersion       string             `json:"mongodb_version,omitempty" yaml:"mongodb_version,omitempty"`
+	SecurityOpts       *pbm.MongodOptsSec `json:"security,omitempty" yaml:"security,omitempty"`
+	Error              *string            `json:"error,omitempty" yaml:"error,omitempty"`
+	// FilesCount and Size are of the physical backup data files.
+	// Size of the logical backup is known only with --verify-sizes.
+	FilesCount int            `json:"files_count,omitempty" yaml:"files_count,omitempty"`
+	Size       int64          `json:"size,omitempty" yaml:"-"`
+	HSize      string         `json:"size_h,omitempty" yaml:"size_h,omitempty"`
+	DumpName   string         `json:"dump,omitempty" yaml:"dump,omitempty"`
+	OplogName  string         `json:"oplog,omitempty" yaml:"oplog,omitempty"`
+	Conditions []condDesc     `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	SizeCheck  *sizeCheckDesc `json:"size_check,omitempty" yaml:"size_check,omitempty"`
+	Files      []bcpFileDesc  `json:"files,omitempty" yaml:"files,omitempty"`
+}
+
+type condDesc struct {
+	TS     int64      `json:"ts" yaml:"-"`
+	Time   string     `json:"time" yaml:"time"`
+	Status pbm.Status `json:"status" yaml:"status"`
+	Error  string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type bcpFileDesc struct {
+	// Path is the path of the file's object on the storage
+	Path    string `json:"path" yaml:"path"`
+	Size    int64  `json:"size" yaml:"size"`
+	StgSize int64  `json:"stg_size" yaml:"stg_size"`
+}
+
+// sizeCheckDesc is the result of the check of the replset files on
+// the storage against the meta (see --verify-sizes)
+type sizeCheckDesc struct {
+	OK         bool     `json:"ok" yaml:"ok"`
+	Checked    int      `json:"checked" yaml:"checked"`
+	Missing    []string `json:"missing,omitempty" yaml:"missing,omitempty"`
+	Mismatched []string `json:"mismatched,omitempty" yaml:"mismatched,omitempty"`
+}
+
+func (b *bcpDesc) String() string {
+	data, err := yaml.Marshal(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// fmtTSOpt formats the unix time or returns an empty string if it's not
+// set (legacy backups may have no such fields in the meta)
+func fmtTSOpt(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return fmtTS(ts)
+}
+
+func describeBackup(cn *pbm.PBM, b *descBcp) (fmt.Stringer, error) {
+	bcp, err := cn.GetBackupMeta(b.name)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := &bcpDesc{
+		Name:               bcp.Name,
+		OPID:               bcp.OPID,
+		Type:               bcp.Type,
+		Namespaces:         bcp.Namespaces,
+		MongoVersion:       bcp.MongoVersion,
+		FCV:                bcp.FCV,
+		PBMVersion:         bcp.PBMVersion,
+		LastWriteTS:        int64(bcp.LastWriteTS.T),
+		LastTransitionTS:   bcp.LastTransitionTS,
+		LastWriteTime:      fmtTSOpt(int64(bcp.LastWriteTS.T)),
+		LastTransitionTime: fmtTSOpt(bcp.LastTransitionTS),
+		Status:             bcp.Status,
+		Size:               bcp.Size,
+		Compression:        bcp.Compression,
+		CompressionRatio:   math.Round(bcp.CompressionRatio()*100) / 100,
+		LowCompression:     bcp.LowCompression,
+		Protected:          bcp.Protected,
+		BalancerStop:       bcp.BalancerStop,
+		Conditions:         describeConditions(bcp.Conditions),
+	}
+	if bcp.Err != "" {
+		rv.Err = &bcp.Err
+	}
+	if bcp.Store.Type != "" {
+		rv.Storage = bcp.Store.Path()
+	}
+	if !bcp.ClusterConsistentTS.IsZero() {
+		rv.ConsistentTS = int64(bcp.ClusterConsistentTS.T)
+		rv.ConsistentTime = fmtTS(int64(bcp.ClusterConsistentTS.T))
+	}
+	if bcp.Layout != nil {
+		rv.Layout = bcp.Layout.String()
+	}
+	if bcp.Type == pbm.IncrementalBackup {
+		rv.Chain = describeBcpChain(cn, bcp)
+	}
+	rv.Verification = describeBcpVerification(bcp)
+
+	var stg storage.Storage
+	if bcp.Size == 0 || b.verifySizes {
+		stg, err = cn.GetStorage(cn.Logger().NewEvent("", "", "", primitive.Timestamp{}))
+		if err != nil {
+			return nil, errors.WithMessage(err, "get storage")
+		}
+	}
+
+	if bcp.Size == 0 {
+		switch bcp.Status {
+		case pbm.StatusDone, pbm.StatusCancelled, pbm.StatusError:
+			rv.Size, err = getLegacySnapshotSize(bcp, stg)
+			if errors.Is(err, errMissedFile) && bcp.Status != pbm.StatusDone {
+				// canceled/failed backup can be incomplete. ignore
+				return nil, errors.WithMessage(err, "get snapshot size")
+			}
+		}
+	}
+	rv.HSize = byteCountIEC(rv.Size)
+
+	rv.Replsets = make([]bcpReplDesc, len(bcp.Replsets))
+	for i := range bcp.Replsets {
+		rv.Replsets[i] = describeBcpReplset(bcp, &bcp.Replsets[i], b.files)
+		if b.verifySizes {
+			checkBcpReplsetSizes(bcp, &bcp.Replsets[i], stg, &rv.Replsets[i])
+		}
+	}
+
+	if b.fullHistory {
+		evs, err := cn.Events(pbm.EventBackup, bcp.Name)
+		if err != nil {
+			return nil, errors.WithMessage(err, "get events")
+		}
+		// backups made by older versions have no events
+		if len(evs) != 0 {
+			rv.Conditions = describeConditions(pbm.EventsConditions(evs, ""))
+			for i := range rv.Replsets {
+				rv.Replsets[i].Conditions = describeConditions(pbm.EventsConditions(evs, rv.Replsets[i].Name))
+			}
+		}
+	}
+
+	return rv, err
+}
+
+func describeConditions(cs []pbm.Condition) []condDesc {
+	if len(cs) == 0 {
+		return nil
+	}
+
+	rv := make([]condDesc, len(cs))
+	for i, c := range cs {
+		rv[i] = condDesc{TS: c.Timestamp, Time: fmtTSOpt(c.Timestamp), Status: c.Status, Error: c.Error}
+	}
+	return rv
+}
+
+// describeBcpChain returns the chain of the incremental backup from
+// the base one. Missing backups are marked so and end the chain.
+func describeBcpChain(cn *pbm.PBM, bcp *pbm.BackupMeta) []string {
+	chain := []string{bcp.Name}
+	seen := map[string]bool{bcp.Name: true}
+	for src := bcp.SrcBackup; src != "" && !seen[src]; {
+		seen[src] = true
+		m, err := cn.GetBackupMeta(src)
+		if err != nil {
+			chain = append(chain, src+" (not found)")
+			break
+		}
+		chain = append(chain, src)
+		src = m.SrcBackup
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+func describeBcpVerification(bcp *pbm.BackupMeta) *bcpVerifyDesc {
+	if bcp.LastVerifiedTS == 0 && bcp.LastRestoreTestTS == 0 {
+		return nil
+	}
+
+	return &bcpVerifyDesc{
+		LastVerifiedTS:      bcp.LastVerifiedTS,
+		LastVerifiedTime:    fmtTSOpt(bcp.LastVerifiedTS),
+		LastVerifyStatus:    bcp.LastVerifyStatus,
+		LastRestoreTest:     bcp.LastRestoreTestName,
+		LastRestoreTestTS:   bcp.LastRestoreTestTS,
+		LastRestoreTestTime: fmtTSOpt(bcp.LastRestoreTestTS),
+	}
+}
+
+// describeBcpReplset returns the replset summary. The list of files is
+// added only if `files` is set as it can be huge.
+func describeBcpReplset(bcp *pbm.BackupMeta, r *pbm.BackupReplset, files bool) bcpReplDesc {
+	rv := bcpReplDesc{
+		Name:               r.Name,
+		Node:               r.Node,
+		ReplLagSec:         r.ReplLagSec,
+		IsConfigSvr:        r.IsConfigSvr,
+		MongoVersion:       r.MongoVersion,
+		Status:             r.Status,
+		LastWriteTS:        int64(r.LastWriteTS.T),
+		LastTransitionTS:   r.LastTransitionTS,
+		LastWriteTime:      fmtTSOpt(int64(r.LastWriteTS.T)),
+		LastTransitionTime: fmtTSOpt(r.LastTransitionTS),
+		DumpName:           r.DumpName,
+		OplogName:          r.OplogName,
+		Conditions:         describeConditions(r.Conditions),
+	}
+	if r.Error != "" {
+		rv.Error = &r.Error
+	}
+	if r.MongodOpts != nil && r.MongodOpts.Security != nil {
+		rv.SecurityOpts = r.MongodOpts.Security
+	}
+
+	rv.FilesCount = len(r.Files)
+	for _, f := range r.Files {
+		rv.Size += f.StgSize
+		if files {
+			rv.Files = append(rv.Files, bcpFileDesc{
+				Path:    bcp.Layout.FilePath(bcp.Name, r.Name, f, bcp.Compression),
+				Size:    f.Size,
+				StgSize: f.StgSize,
+			})
+		}
+	}
+	if rv.Size != 0 {
+		rv.HSize = byteCountIEC(rv.Size)
+	}
+
+	return rv
+}
+
+// checkBcpReplsetSizes checks the replset files exist on the storage and
+// (for physical backups) have the sizes recorded in the meta. Sizes of
+// the logical backup files aren't in the meta, so the replset size is
+// taken from the storage.
+func checkBcpReplsetSizes(bcp *pbm.BackupMeta, r *pbm.BackupReplset, stg storage.Storage, rv *bcpReplDesc) {
+	c := &sizeCheckDesc{}
+	stat := func(name string) (int64, bool) {
+		c.Checked++
+		f, err := stg.FileStat(name)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotExist) {
+				c.Missing = append(c.Missing, name)
+			} else {
+				c.Missing = append(c.Missing, fmt.Sprintf("%s (%v)", name, err))
+			}
+			return 0, false
+		}
+		return f.Size, true
+	}
+
+	switch bcp.Type {
+	case pbm.LogicalBackup:
+		var size int64
+		for _, name := range []string{r.DumpName, r.OplogName} {
+			if name == "" {
+				continue
+			}
+			s, _ := stat(name)
+			size += s
+		}
+		rv.Size = size
+		rv.HSize = byteCountIEC(size)
+	default:
+		for _, f := range r.Files {
+			name := bcp.Layout.FilePath(bcp.Name, r.Name, f, bcp.Compression)
+			s, ok := stat(name)
+			// legacy meta may have no storage size
+			if ok && f.StgSize != 0 && s != f.StgSize {
+				c.Mismatched = append(c.Mismatched,
+					fmt.Sprintf("%s: %d in meta, %d on storage", name, f.StgSize, s))
+			}
+		}
+	}
+
+	c.OK = len(c.Missing) == 0 && len(c.Mismatched) == 0
+	rv.SizeCheck = c
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestDescribeBcpReplsetSizes(t *testing.T) {
+	bcp := &pbm.BackupMeta{
+		Name:        "2023-01-01T00:00:00Z",
+		Type:        pbm.IncrementalBackup,
+		Compression: compress.CompressionTypeS2,
+	}
+	rs := &pbm.BackupReplset{
+		Name: "rs0",
+		Files: []pbm.File{
+			{Name: "collection-1.wt", Size: 100, StgSize: 4},
+			{Name: "collection-2.wt", Off: 8, Len: 16, Size: 100, StgSize: 5},
+			{Name: "index-1.wt", Size: 100, StgSize: 6},
+		},
+	}
+
+	d := describeBcpReplset(bcp, rs, false)
+	if d.FilesCount != 3 || d.Size != 15 || d.Files != nil {
+		t.Errorf("summary: got %d files, size %d, list %v", d.FilesCount, d.Size, d.Files)
+	}
+
+	d = describeBcpReplset(bcp, rs, true)
+	want := []string{
+		bcp.Name + "/rs0/collection-1.wt.s2",
+		bcp.Name + "/rs0/collection-2.wt.s2.8-16",
+		bcp.Name + "/rs0/index-1.wt.s2",
+	}
+	var got []string
+	for _, f := range d.Files {
+		got = append(got, f.Path)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files: got %v, want %v", got, want)
+	}
+
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+	for path, size := range map[string]int{want[0]: 4, want[1]: 7} {
+		if err := stg.Save(path, bytes.NewReader(make([]byte, size)), int64(size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkBcpReplsetSizes(bcp, rs, stg, &d)
+	c := d.SizeCheck
+	if c.OK || c.Checked != 3 || len(c.Missing) != 1 || len(c.Mismatched) != 1 {
+		t.Errorf("size check: got %+v", c)
+	}
+	if c.Missing[0] != want[2] {
+		t.Errorf("missing: got %v, want %v", c.Missing, want[2:])
+	}
+}
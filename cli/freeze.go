@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+type backupFreezeOpts struct {
+	state    string
+	duration time.Duration
+}
+
+func backupFreeze(cn *pbm.PBM, o *backupFreezeOpts) (fmt.Stringer, error) {
+	if o.state == "off" {
+		err := cn.ClearBackupFreeze()
+		if err != nil {
+			return nil, errors.Wrap(err, "clear freeze")
+		}
+		return outMsg{"Backups are unfrozen"}, nil
+	}
+
+	if o.duration < 0 {
+		return nil, errors.New("freeze duration should be positive")
+	}
+	var until time.Time
+	if o.duration > 0 {
+		until = time.Now().Add(o.duration)
+	}
+
+	err := cn.SetBackupFreeze(until)
+	if err != nil {
+		return nil, errors.Wrap(err, "set freeze")
+	}
+
+	if o.duration == 0 {
+		return outMsg{"Backups are frozen until `pbm backup-freeze off`. Running backups aren't affected"}, nil
+	}
+	return outMsg{fmt.Sprintf("Backups are frozen for %s. Running backups aren't affected", o.duration)}, nil
+}
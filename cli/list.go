@@ -30,6 +30,7 @@ type restoreStatus struct {
 	Name             string          `json:"name,omitempty"`
 	Namespaces       []string        `json:"namespaces,omitempty"`
 	Error            string          `json:"error,omitempty"`
+	FollowUp         []string        `json:"follow_up,omitempty"`
 }
 
 type restoreListType string
@@ -71,6 +72,10 @@ func (r restoreListOut) String() string {
 		switch v.Status {
 		case pbm.StatusDone, pbm.StatusPartlyDone:
 			rprint = fmt.Sprintf("%s\t%s", name, v.Status)
+			if len(v.FollowUp) != 0 {
+				rprint += fmt.Sprintf(" [%d manual steps required, see `pbm describe-restore %s`]",
+					len(v.FollowUp), v.Name)
+			}
 		case pbm.StatusError:
 			rprint = fmt.Sprintf("%s\tFailed with \"%s\"", name, v.Error)
 		default:
@@ -123,6 +128,7 @@ func restoreList(cn *pbm.PBM, size int64) (*restoreListOut, error) {
 			Name:             r.Name,
 			Namespaces:       r.Namespaces,
 			Error:            r.Error,
+			FollowUp:         r.FollowUp,
 		}
 
 		if r.PITR != 0 {
@@ -163,7 +169,17 @@ func (bl backupListOut) String() string {
 			kind += ", base"
 		}
 
-		s += fmt.Sprintf("  %s <%s> [restore_to_time: %s]\n", b.Name, kind, fmtTS(int64(b.RestoreTS)))
+		s += fmt.Sprintf("  %s <%s> [restore_to_time: %s]", b.Name, kind, fmtTS(int64(b.RestoreTS)))
+		if b.Protected {
+			s += " [protected]"
+		}
+		if b.LastVerifiedTS != 0 {
+			s += fmt.Sprintf(" [verified: %s %s]", b.LastVerifyStatus, fmtTS(b.LastVerifiedTS))
+		}
+		if b.LastRestoreTestTS != 0 {
+			s += fmt.Sprintf(" [restore-tested: %s]", fmtTS(b.LastRestoreTestTS))
+		}
+		s += "\n"
 	}
 	if bl.PITR.On {
 		s += fmt.Sprintln("\nPITR <on>:")
@@ -253,6 +269,12 @@ func getSnapshotList(cn *pbm.PBM, size int, rsMap map[string]string) (s []snapsh
 			PBMVersion: b.PBMVersion,
 			Type:       b.Type,
 			SrcBackup:  b.SrcBackup,
+			Protected:  b.Protected,
+
+			LastVerifiedTS:      b.LastVerifiedTS,
+			LastVerifyStatus:    b.LastVerifyStatus,
+			LastRestoreTestName: b.LastRestoreTestName,
+			LastRestoreTestTS:   b.LastRestoreTestTS,
 		})
 	}
 
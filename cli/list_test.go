@@ -1,7 +1,9 @@
 package cli
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
@@ -103,3 +105,28 @@ func check(t *testing.T, got, want []pitrRange) {
 		t.Errorf("got: %v, want: %v", got, want)
 	}
 }
+
+func TestBackupListOutMarkers(t *testing.T) {
+	bl := backupListOut{Snapshots: []snapshotStat{
+		{Name: "b1", Type: pbm.LogicalBackup, RestoreTS: 100},
+		{
+			Name:              "b2",
+			Type:              pbm.PhysicalBackup,
+			RestoreTS:         200,
+			Protected:         true,
+			LastVerifiedTS:    300,
+			LastVerifyStatus:  pbm.VerifyOK,
+			LastRestoreTestTS: 400,
+		},
+	}}
+
+	out := strings.Split(bl.String(), "\n")
+	if strings.Contains(out[1], "verified") || strings.Contains(out[1], "restore-tested") ||
+		strings.Contains(out[1], "protected") {
+		t.Errorf("unexpected markers for b1: %q", out[1])
+	}
+	want := fmt.Sprintf("[protected] [verified: ok %s] [restore-tested: %s]", fmtTS(300), fmtTS(400))
+	if !strings.HasSuffix(out[2], want) {
+		t.Errorf("expected %q in %q", want, out[2])
+	}
+}
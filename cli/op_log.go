@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+type opLogOpts struct {
+	since string
+}
+
+type opLogNode struct {
+	RS       string        `json:"rs"`
+	Node     string        `json:"node"`
+	Start    int64         `json:"start"`
+	End      int64         `json:"end,omitempty"`
+	Duration int64         `json:"duration"`
+	Outcome  pbm.OpOutcome `json:"outcome"`
+}
+
+type opLogEntry struct {
+	OPID  string      `json:"opid"`
+	Type  pbm.Command `json:"type"`
+	Start int64       `json:"start"`
+	End   int64       `json:"end,omitempty"`
+	Nodes []opLogNode `json:"replsets"`
+}
+
+type opLogTimeline []opLogEntry
+
+func (t opLogTimeline) String() string {
+	if len(t) == 0 {
+		return "No operations found\n"
+	}
+
+	var b strings.Builder
+	for _, op := range t {
+		end := "..."
+		if op.End != 0 {
+			end = fmtTS(op.End)
+		}
+		fmt.Fprintf(&b, "%s - %s %s [%s]\n", fmtTS(op.Start), end, op.Type, op.OPID)
+		for _, n := range op.Nodes {
+			end := "..."
+			if n.End != 0 {
+				end = fmtTS(n.End)
+			}
+			fmt.Fprintf(&b, "  %s/%s: %s - %s %s %s\n", n.RS, n.Node,
+				fmtTS(n.Start), end, time.Duration(n.Duration)*time.Second, n.Outcome)
+		}
+	}
+	return b.String()
+}
+
+// opLogList returns the timeline of the operations from the PBM op log
+// (the log of acquired locks) started since `o.since`
+func opLogList(cn *pbm.PBM, o *opLogOpts) (fmt.Stringer, error) {
+	var since primitive.Timestamp
+	if o.since != "" {
+		t, err := parseDateT(o.since)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse --since")
+		}
+		since.T = uint32(t.Unix())
+	}
+
+	ops, err := cn.GetOpLog(since)
+	if err != nil {
+		return nil, errors.Wrap(err, "get op log")
+	}
+
+	return makeOpLogTimeline(ops), nil
+}
+
+// makeOpLogTimeline groups the op log entries of the replsets by the
+// operation. Operations are ordered by the start time.
+func makeOpLogTimeline(ops []pbm.OpLog) opLogTimeline {
+	idx := make(map[string]int)
+	var rv opLogTimeline
+	for _, o := range ops {
+		n := opLogNode{
+			RS:       o.Replset,
+			Node:     o.Node,
+			Start:    int64(o.StartTS.T),
+			End:      int64(o.EndTS.T),
+			Duration: o.Duration,
+			Outcome:  o.Outcome,
+		}
+
+		i, ok := idx[o.OPID]
+		if !ok {
+			i = len(rv)
+			idx[o.OPID] = i
+			rv = append(rv, opLogEntry{OPID: o.OPID, Type: o.Type, Start: n.Start})
+		}
+		e := &rv[i]
+		e.Nodes = append(e.Nodes, n)
+		if n.Start < e.Start {
+			e.Start = n.Start
+		}
+	}
+
+	for i := range rv {
+		e := &rv[i]
+		for _, n := range e.Nodes {
+			if n.End == 0 {
+				e.End = 0
+				break
+			}
+			if n.End > e.End {
+				e.End = n.End
+			}
+		}
+	}
+	sort.SliceStable(rv, func(i, j int) bool { return rv[i].Start < rv[j].Start })
+
+	return rv
+}
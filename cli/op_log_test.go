@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestMakeOpLogTimeline(t *testing.T) {
+	op := func(opid, rs string, start, end uint32, outcome pbm.OpOutcome) pbm.OpLog {
+		o := pbm.OpLog{
+			LockHeader: pbm.LockHeader{Type: pbm.CmdBackup, Replset: rs, Node: rs + "01:27017", OPID: opid},
+			StartTS:    primitive.Timestamp{T: start},
+			EndTS:      primitive.Timestamp{T: end},
+			Outcome:    outcome,
+		}
+		if end != 0 {
+			o.Duration = int64(end - start)
+		}
+		return o
+	}
+
+	tl := makeOpLogTimeline([]pbm.OpLog{
+		op("b", "rs1", 200, 0, pbm.OpRunning),
+		op("a", "cfg", 100, 160, pbm.OpDone),
+		op("a", "rs1", 90, 150, pbm.OpFailed),
+		op("b", "cfg", 210, 300, pbm.OpDone),
+	})
+
+	if len(tl) != 2 || tl[0].OPID != "a" || tl[1].OPID != "b" {
+		t.Fatalf("unexpected ops order: %+v", tl)
+	}
+	if tl[0].Start != 90 || tl[0].End != 160 || len(tl[0].Nodes) != 2 {
+		t.Errorf("op a: %+v", tl[0])
+	}
+	if tl[1].End != 0 {
+		t.Errorf("op b is still running, got end %d", tl[1].End)
+	}
+
+	s := tl.String()
+	if !strings.Contains(s, "rs1/rs101:27017") || !strings.Contains(s, "1m0s failed") {
+		t.Errorf("unexpected output:\n%s", s)
+	}
+
+	b, err := json.Marshal(tl[0].Nodes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"outcome":"failed"`) {
+		t.Errorf("unexpected json: %s", b)
+	}
+}
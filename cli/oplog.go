@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
 )
@@ -15,12 +16,16 @@ type replayOptions struct {
 	end   string
 	wait  bool
 	rsMap string
+	// rollForward replays from the recovery point of the last restore
+	rollForward bool
 }
 
 type oplogReplayResult struct {
 	Name string `json:"name"`
-	done bool
-	err  string
+	// Point is the cluster time the replay brought the data to
+	Point *primitive.Timestamp `json:"point,omitempty"`
+	done  bool
+	err   string
 }
 
 func (r oplogReplayResult) HasError() bool {
@@ -29,6 +34,10 @@ func (r oplogReplayResult) HasError() bool {
 
 func (r oplogReplayResult) String() string {
 	if r.done {
+		if r.Point != nil {
+			return fmt.Sprintf("\nOplog replay successfully finished! The data is at %s <%d,%d>\n",
+				time.Unix(int64(r.Point.T), 0).UTC().Format(time.RFC3339), r.Point.T, r.Point.I)
+		}
 		return "\nOplog replay successfully finished!\n"
 	}
 	if r.err != "" {
@@ -43,13 +52,44 @@ func replayOplog(cn *pbm.PBM, o replayOptions, outf outFormat) (fmt.Stringer, er
 		return nil, errors.WithMessage(err, "cannot parse replset mapping")
 	}
 
-	startTS, err := parseTS(o.start)
-	if err != nil {
-		return nil, errors.Wrap(err, "parse start time")
+	if !o.rollForward && (o.start == "" || o.end == "") {
+		return nil, errors.New("--start and --end are required unless --roll-forward")
 	}
-	endTS, err := parseTS(o.end)
-	if err != nil {
-		return nil, errors.Wrap(err, "parse end time")
+
+	var startTS, endTS primitive.Timestamp
+	if o.start != "" {
+		startTS, err = parseTS(o.start)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse start time")
+		}
+	}
+	if o.end != "" {
+		endTS, err = parseTS(o.end)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse end time")
+		}
+	}
+
+	var noWritesAfter primitive.Timestamp
+	if o.rollForward {
+		base, rst, err := cn.LastRecoveryPoint()
+		if errors.Is(err, pbm.ErrNotFound) {
+			return nil, errors.New("no successful restore to roll forward from")
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "get the cluster's recovery point")
+		}
+		startTS, err = rollForwardStart(base, startTS)
+		if err != nil {
+			return nil, errors.Wrapf(err, "restore %s", rst.Name)
+		}
+		noWritesAfter = primitive.Timestamp{T: uint32(rst.LastTransitionTS)}
+		if outf == outText {
+			fmt.Printf("Rolling forward from %v, the recovery point of restore %s\n", base, rst.Name)
+		}
+	}
+	if !endTS.IsZero() && primitive.CompareTimestamp(startTS, endTS) != -1 {
+		return nil, errors.Errorf("start %v isn't before end %v", startTS, endTS)
 	}
 
 	err = checkConcurrentOp(cn)
@@ -57,6 +97,11 @@ func replayOplog(cn *pbm.PBM, o replayOptions, outf outFormat) (fmt.Stringer, er
 		return nil, err
 	}
 
+	err = cn.CheckRestoreMaintenance(false)
+	if err != nil {
+		return nil, err
+	}
+
 	name := time.Now().UTC().Format(time.RFC3339Nano)
 	cmd := pbm.Cmd{
 		Cmd: pbm.CmdReplay,
@@ -65,6 +110,8 @@ func replayOplog(cn *pbm.PBM, o replayOptions, outf outFormat) (fmt.Stringer, er
 			Start: startTS,
 			End:   endTS,
 			RSMap: rsMap,
+
+			NoWritesAfter: noWritesAfter,
 		},
 	}
 	if err := cn.SendCmd(cmd); err != nil {
@@ -75,7 +122,11 @@ func replayOplog(cn *pbm.PBM, o replayOptions, outf outFormat) (fmt.Stringer, er
 		return oplogReplayResult{Name: name}, nil
 	}
 
-	fmt.Printf("Starting oplog replay '%s - %s'", o.start, o.end)
+	end := o.end
+	if end == "" {
+		end = "latest"
+	}
+	fmt.Printf("Starting oplog replay '%v - %s'", startTS, end)
 
 	ctx, cancel := context.WithTimeout(context.Background(), pbm.WaitActionStart)
 	defer cancel()
@@ -90,10 +141,33 @@ func replayOplog(cn *pbm.PBM, o replayOptions, outf outFormat) (fmt.Stringer, er
 	}
 
 	fmt.Print("Started.\nWaiting to finish")
-	err = waitRestore(cn, m, 0)
+	_, err = waitRestore(cn, m, 0)
 	if err != nil {
 		return oplogReplayResult{err: err.Error()}, nil
 	}
 
-	return oplogReplayResult{Name: name, done: true}, nil
+	res := oplogReplayResult{Name: name, done: true}
+	m, err = cn.GetRestoreMeta(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+	if !m.StopTS.IsZero() {
+		res.Point = &m.StopTS
+	}
+
+	return res, nil
+}
+
+// rollForwardStart returns where the replay rolling the cluster forward
+// from its recovery point starts. The start (if set) can't be after
+// the point, so there's no gap in the applied oplog.
+func rollForwardStart(point, start primitive.Timestamp) (primitive.Timestamp, error) {
+	if start.IsZero() {
+		return point, nil
+	}
+	if primitive.CompareTimestamp(start, point) == 1 {
+		return start, errors.Errorf("gap between the cluster's recovery point %v and the start %v", point, start)
+	}
+
+	return start, nil
 }
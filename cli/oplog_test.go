@@ -0,0 +1,22 @@
+package cli
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRollForwardStart(t *testing.T) {
+	point := primitive.Timestamp{T: 100, I: 2}
+
+	if s, err := rollForwardStart(point, primitive.Timestamp{}); err != nil || s != point {
+		t.Errorf("no start: expected %v, got %v, %v", point, s, err)
+	}
+	before := primitive.Timestamp{T: 90}
+	if s, err := rollForwardStart(point, before); err != nil || s != before {
+		t.Errorf("start before the point: expected %v, got %v, %v", before, s, err)
+	}
+	if _, err := rollForwardStart(point, primitive.Timestamp{T: 100, I: 3}); err == nil {
+		t.Error("expected error on the gap")
+	}
+}
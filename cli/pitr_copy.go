@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+type pitrCopyOpts struct {
+	cfg   string
+	start string
+	end   string
+}
+
+// log events of the PITR chunks copy. The copy stats are kept in
+// the PBM log under them (see `pbm logs -e pitr-export`).
+const (
+	pitrExportEvent = "pitr-export"
+	pitrImportEvent = "pitr-import"
+)
+
+type pitrCopyOut struct {
+	Op    string        `json:"op"`
+	Stats pbm.CopyStats `json:"stats"`
+	Mode  string        `json:"mode"`
+}
+
+func (p pitrCopyOut) String() string {
+	return fmt.Sprintf("PITR chunks %s done: %s", p.Op, p.Stats)
+}
+
+// exportPITR copies the PITR chunks of the range from the configured
+// storage to the storage of the `cfg` PBM config file
+func exportPITR(cn *pbm.PBM, o *pitrCopyOpts) (fmt.Stringer, error) {
+	rng, err := o.timeRange()
+	if err != nil {
+		return nil, err
+	}
+
+	l := cn.Logger().NewEvent(pitrExportEvent, "", "", primitive.Timestamp{})
+	dst, err := storageFromFile(o.cfg, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "get destination storage")
+	}
+
+	st, err := cn.ExportPITRChunks(rng, dst, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "export chunks")
+	}
+
+	return pitrCopyOut{Op: "export", Stats: st, Mode: st.Mode()}, nil
+}
+
+// importPITR copies the PITR chunks of the range from the storage of the
+// `cfg` PBM config file to the configured storage and registers them
+func importPITR(cn *pbm.PBM, o *pitrCopyOpts) (fmt.Stringer, error) {
+	rng, err := o.timeRange()
+	if err != nil {
+		return nil, err
+	}
+
+	l := cn.Logger().NewEvent(pitrImportEvent, "", "", primitive.Timestamp{})
+	src, err := storageFromFile(o.cfg, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "get source storage")
+	}
+
+	st, err := cn.ImportPITRChunks(rng, src, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "import chunks")
+	}
+
+	return pitrCopyOut{Op: "import", Stats: st, Mode: st.Mode()}, nil
+}
+
+func (o *pitrCopyOpts) timeRange() (pbm.TimeRange, error) {
+	var rng pbm.TimeRange
+	var err error
+	if o.start != "" {
+		rng.Start, err = parseTS(o.start)
+		if err != nil {
+			return rng, errors.Wrap(err, "parse start time")
+		}
+	}
+	if o.end != "" {
+		rng.End, err = parseTS(o.end)
+		if err != nil {
+			return rng, errors.Wrap(err, "parse end time")
+		}
+		if primitive.CompareTimestamp(rng.End, rng.Start) < 0 {
+			return rng, errors.New("--end is before --start")
+		}
+	}
+
+	return rng, nil
+}
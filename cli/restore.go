@@ -1,9 +1,11 @@
 package cli
 
 import (
+	"bufio"
 	"context"
 	"fmt"
-	"io/ioutil"
+	"math"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -20,9 +22,34 @@ type restoreOpts struct {
 	bcp      string
 	pitr     string
 	pitrBase string
+	pitrType string
+	before   string
 	wait     bool
 	ns       string
 	rsMap    string
+	maxLag   time.Duration
+
+	allowPlatformMismatch bool
+	force                 bool
+	withPBMState          bool
+	skipCapCheck          bool
+	wtSalvage             bool
+	newReplsetID          bool
+	snapshotOnly          bool
+	oplogSizeMB           float64
+	leaderRS              string
+	partlyDone            string
+
+	// nsAllowlist are namespaces the backup may contain
+	nsAllowlist     string
+	nsAllowlistWarn bool
+	dryRun          bool
+	// listFiles lists the files the dry-run physical restore
+	// would wipe and write on each node
+	listFiles bool
+	// yes skips the confirmation of restoring into a cluster
+	// with different shards than the backup
+	yes bool
 }
 
 type restoreRet struct {
@@ -32,6 +59,8 @@ type restoreRet struct {
 	done     bool
 	physical bool
 	err      string
+	// followUp is the manual steps the finished restore requires
+	followUp []string
 }
 
 func (r restoreRet) HasError() bool {
@@ -42,7 +71,9 @@ func (r restoreRet) String() string {
 	switch {
 	case r.done:
 		m := "\nRestore successfully finished!\n"
-		if r.physical {
+		if len(r.followUp) != 0 {
+			m += fmtFollowUp(r.followUp)
+		} else if r.physical {
 			m += "Restart the cluster and pbm-agents, and run `pbm config --force-resync`"
 		}
 		return m
@@ -81,6 +112,76 @@ func runRestore(cn *pbm.PBM, o *restoreOpts, outf outFormat) (fmt.Stringer, erro
 		return nil, errors.New("either a backup name or point in time should be set, non both together!")
 	}
 
+	if o.before != "" {
+		if o.pitr != "" || o.bcp != "" || o.pitrBase != "" {
+			return nil, errors.New("--before can't be used along with a backup name, --time or --base-snapshot")
+		}
+
+		rp, out, err := restorePointBefore(cn, o.before)
+		if err != nil {
+			return nil, err
+		}
+		if outf == outText {
+			fmt.Print(out)
+		}
+
+		if rp.IsPITR() {
+			o.pitr = fmt.Sprintf("%d,%d", rp.TS.T, rp.TS.I)
+			o.pitrBase = rp.Backup.Name
+		} else {
+			o.bcp = rp.Backup.Name
+		}
+	}
+
+	if o.maxLag != 0 && o.pitr == "" {
+		return nil, errors.New("--max-lag is only for the point-in-time restore")
+	}
+	if o.withPBMState && o.pitr != "" {
+		return nil, errors.New("--with-pbm-state is only for the snapshot restore")
+	}
+
+	if o.pitrType == string(pbm.PhysicalBackup) {
+		if o.pitr == "" {
+			return nil, errors.Errorf("--type %s is only for the point-in-time restore", pbm.PhysicalBackup)
+		}
+		// there is no physical oplog replay yet, so only the base backup
+		// resolution is available to preview what such restore would use
+		if !o.dryRun {
+			return nil, errors.New("physical point-in-time restore is not supported yet. " +
+				"Use --dry-run to preview the base backup it would be based on")
+		}
+		base, err := physicalBase(cn, o.pitr, o.pitrBase)
+		if err != nil {
+			return nil, err
+		}
+		return base, nil
+	}
+
+	if o.listFiles && !o.dryRun {
+		return nil, errors.New("--list-files is only for --dry-run")
+	}
+	if o.nsAllowlist != "" || o.dryRun {
+		plan, err := restorePlan(cn, o, nss, rsMap)
+		if err != nil {
+			return nil, err
+		}
+		if o.dryRun {
+			return plan, nil
+		}
+		if outf == outText && !plan.NSAllowlist.OK() {
+			fmt.Print(plan)
+		}
+		if len(plan.NSAllowlist.Unexpected) != 0 && !o.nsAllowlistWarn {
+			return nil, errors.New("the backup contains namespaces not on the allowlist. " +
+				"Use --ns-allowlist-warn to restore anyway")
+		}
+	}
+
+	err = confirmShardSetChange(cn, o, rsMap, outf)
+	if err != nil {
+		return nil, err
+	}
+
 	clusterTime, err := cn.ClusterTime()
 	if err != nil {
 		return nil, errors.Wrap(err, "read cluster time")
@@ -89,7 +190,7 @@ func runRestore(cn *pbm.PBM, o *restoreOpts, outf outFormat) (fmt.Stringer, erro
 
 	switch {
 	case o.bcp != "":
-		m, err := restore(cn, o.bcp, nss, rsMap, outf)
+		m, err := restore(cn, o, nss, rsMap, outf)
 		if err != nil {
 			return nil, err
 		}
@@ -106,11 +207,12 @@ func runRestore(cn *pbm.PBM, o *restoreOpts, outf outFormat) (fmt.Stringer, erro
 			typ = " physical restore.\nWaiting to finish"
 		}
 		fmt.Printf("Started%s", typ)
-		err = waitRestore(cn, m, tdiff)
+		rmeta, err := waitRestore(cn, m, tdiff)
 		if err == nil {
 			return restoreRet{
 				done:     true,
 				physical: m.Type == pbm.PhysicalBackup || m.Type == pbm.IncrementalBackup,
+				followUp: rmeta.FollowUp,
 			}, nil
 		}
 
@@ -119,7 +221,7 @@ func runRestore(cn *pbm.PBM, o *restoreOpts, outf outFormat) (fmt.Stringer, erro
 		}
 		return restoreRet{err: fmt.Sprintf("%s.\n Try to check logs on node %s", err.Error(), m.Leader)}, nil
 	case o.pitr != "":
-		m, err := pitrestore(cn, o.pitr, o.pitrBase, nss, rsMap, outf)
+		m, err := pitrestore(cn, o.pitr, o.pitrBase, nss, rsMap, o.maxLag, outf)
 		if err != nil {
 			return nil, err
 		}
@@ -127,13 +229,14 @@ func runRestore(cn *pbm.PBM, o *restoreOpts, outf outFormat) (fmt.Stringer, erro
 			return restoreRet{PITR: o.pitr, Name: m.Name}, nil
 		}
 		fmt.Print("Started.\nWaiting to finish")
-		err = waitRestore(cn, m, tdiff)
+		rmeta, err := waitRestore(cn, m, tdiff)
 		if err != nil {
 			return restoreRet{err: err.Error()}, nil
 		}
 		return restoreRet{
-			done: true,
-			PITR: o.pitr,
+			done:     true,
+			PITR:     o.pitr,
+			followUp: rmeta.FollowUp,
 		}, nil
 	default:
 		return nil, errors.New("undefined restore state")
@@ -145,12 +248,14 @@ func runRestore(cn *pbm.PBM, o *restoreOpts, outf outFormat) (fmt.Stringer, erro
 // But for physical ones, the cluster by this time is down. So we compare with
 // the wall time taking into account a time skew (wallTime - clusterTime) taken
 // when the cluster time was still available.
-func waitRestore(cn *pbm.PBM, m *pbm.RestoreMeta, tskew int64) error {
+//
+// It returns the meta of the finished restore.
+func waitRestore(cn *pbm.PBM, m *pbm.RestoreMeta, tskew int64) (*pbm.RestoreMeta, error) {
 	ep, _ := cn.GetEpoch()
 	l := cn.Logger().NewEvent(string(pbm.CmdRestore), m.Backup, m.OPID, ep.TS())
-	stg, err := cn.GetStorage(l)
+	stg, err := cn.GetRestoreStorage(l)
 	if err != nil {
-		return errors.Wrap(err, "get storage")
+		return nil, errors.Wrap(err, "get storage")
 	}
 
 	tk := time.NewTicker(time.Second * 1)
@@ -177,20 +282,20 @@ func waitRestore(cn *pbm.PBM, m *pbm.RestoreMeta, tskew int64) error {
 			continue
 		}
 		if err != nil {
-			return errors.Wrap(err, "get restore metadata")
+			return nil, errors.Wrap(err, "get restore metadata")
 		}
 
 		switch rmeta.Status {
 		case pbm.StatusDone, pbm.StatusPartlyDone:
-			return nil
+			return rmeta, nil
 		case pbm.StatusError:
-			return errRestoreFailed{fmt.Sprintf("operation failed with: %s", rmeta.Error)}
+			return nil, errRestoreFailed{fmt.Sprintf("operation failed with: %s", rmeta.Error)}
 		}
 
 		if m.Type == pbm.LogicalBackup {
 			clusterTime, err := cn.ClusterTime()
 			if err != nil {
-				return errors.Wrap(err, "read cluster time")
+				return nil, errors.Wrap(err, "read cluster time")
 			}
 			ctime = clusterTime.T
 		} else {
@@ -198,11 +303,39 @@ func waitRestore(cn *pbm.PBM, m *pbm.RestoreMeta, tskew int64) error {
 		}
 
 		if rmeta.Hb.T+frameSec < ctime {
-			return errors.Errorf("operation staled, last heartbeat: %v", rmeta.Hb.T)
+			return nil, errors.Errorf("operation staled, last heartbeat: %v", rmeta.Hb.T)
 		}
 	}
 
-	return nil
+	return rmeta, nil
+}
+
+func fmtFollowUp(steps []string) string {
+	s := "\n!!! The cluster needs manual steps to complete the restore:\n"
+	for i, st := range steps {
+		s += fmt.Sprintf("  %d. %s\n", i+1, st)
+	}
+	return s
+}
+
+// checkLeaderRS checks the replset designated as the restore leader
+// is in the cluster
+func checkLeaderRS(cn *pbm.PBM, bcp *pbm.BackupMeta, rs string) error {
+	if bcp.Type != pbm.PhysicalBackup && bcp.Type != pbm.IncrementalBackup {
+		return errors.New("--leader-rs is for the physical restore only")
+	}
+
+	shards, err := cn.ClusterMembers()
+	if err != nil {
+		return errors.Wrap(err, "get cluster members")
+	}
+	for _, s := range shards {
+		if s.RS == rs {
+			return nil
+		}
+	}
+
+	return errors.Errorf("replset %s not found in cluster", rs)
 }
 
 type errRestoreFailed struct {
@@ -213,7 +346,9 @@ func (e errRestoreFailed) Error() string {
 	return e.string
 }
 
-func restore(cn *pbm.PBM, bcpName string, nss []string, rsMapping map[string]string, outf outFormat) (*pbm.RestoreMeta, error) {
+// restore starts the restore of the snapshot backup `o.bcp`
+func restore(cn *pbm.PBM, o *restoreOpts, nss []string, rsMapping map[string]string, outf outFormat) (*pbm.RestoreMeta, error) {
+	bcpName := o.bcp
 	bcp, err := cn.GetBackupMeta(bcpName)
 	if errors.Is(err, pbm.ErrNotFound) {
 		return nil, errors.Errorf("backup '%s' not found", bcpName)
@@ -224,12 +359,85 @@ func restore(cn *pbm.PBM, bcpName string, nss []string, rsMapping map[string]str
 	if bcp.Status != pbm.StatusDone {
 		return nil, errors.Errorf("backup '%s' didn't finish successfully", bcpName)
 	}
+	if o.withPBMState {
+		if bcp.Type != pbm.LogicalBackup {
+			return nil, errors.New("--with-pbm-state is for the logical restore only")
+		}
+		if len(nss) != 0 {
+			return nil, errors.New("--with-pbm-state can't be used with --ns")
+		}
+	}
+
+	if o.wtSalvage {
+		if bcp.Type != pbm.PhysicalBackup && bcp.Type != pbm.IncrementalBackup {
+			return nil, errors.New("--wt-salvage is for the physical restore only")
+		}
+		cfg, err := cn.GetConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "get config")
+		}
+		if !cfg.Restore.WiredTigerSalvage {
+			return nil, errors.New("--wt-salvage has to be allowed by the config: " +
+				"`pbm config --set restore.wiredTigerSalvage=true`")
+		}
+		if outf == outText {
+			fmt.Fprintln(os.Stderr, "Warning: the restored data will be salvaged with `mongod --repair`. "+
+				"Data it can't read is discarded, the restored data may be incomplete")
+		}
+	}
+
+	if o.newReplsetID && bcp.Type != pbm.PhysicalBackup && bcp.Type != pbm.IncrementalBackup {
+		return nil, errors.New("--new-replset-id is for the physical restore only")
+	}
+
+	if o.snapshotOnly {
+		if _, err := pbm.SnapshotOnlyRecoveryTS(bcp); err != nil {
+			return nil, errors.Wrap(err, "--snapshot-only")
+		}
+	}
+
+	if o.oplogSizeMB != 0 {
+		if bcp.Type != pbm.PhysicalBackup && bcp.Type != pbm.IncrementalBackup {
+			return nil, errors.New("--oplog-size-mb is for the physical restore only")
+		}
+		if err := pbm.ValidateOplogSizeMB(o.oplogSizeMB); err != nil {
+			return nil, errors.Wrap(err, "--oplog-size-mb")
+		}
+	}
+
+	if o.leaderRS != "" {
+		err = checkLeaderRS(cn, bcp, o.leaderRS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.partlyDone != "" && bcp.Type != pbm.PhysicalBackup && bcp.Type != pbm.IncrementalBackup {
+		return nil, errors.New("--partly-done is for the physical restore only")
+	}
 
 	err = checkConcurrentOp(cn)
 	if err != nil {
 		return nil, err
 	}
 
+	err = cn.CheckRestoreMaintenance(bcp.Type == pbm.PhysicalBackup || bcp.Type == pbm.IncrementalBackup)
+	if err != nil {
+		return nil, err
+	}
+
+	if !o.skipCapCheck {
+		warns, err := cn.CheckRestoreCaps(bcp, rsMapping)
+		if outf == outText {
+			for _, w := range warns {
+				fmt.Fprintln(os.Stderr, "Warning:", w)
+			}
+		}
+		if err != nil {
+			return nil, errors.Errorf("nodes can't restore the backup: %v. "+
+				"Use --skip-capability-check to restore anyway", err)
+		}
+	}
+
 	name := time.Now().UTC().Format(time.RFC3339Nano)
 	err = cn.SendCmd(pbm.Cmd{
 		Cmd: pbm.CmdRestore,
@@ -238,6 +446,16 @@ func restore(cn *pbm.PBM, bcpName string, nss []string, rsMapping map[string]str
 			BackupName: bcpName,
 			Namespaces: nss,
 			RSMap:      rsMapping,
+
+			AllowPlatformMismatch: o.allowPlatformMismatch,
+			Force:                 o.force,
+			WithPBMState:          o.withPBMState,
+			WiredTigerSalvage:     o.wtSalvage,
+			NewReplsetID:          o.newReplsetID,
+			SnapshotOnly:          o.snapshotOnly,
+			OplogSizeMB:           o.oplogSizeMB,
+			LeaderRS:              o.leaderRS,
+			PartlyDonePolicy:      pbm.PartlyDonePolicy(o.partlyDone),
 		},
 	})
 	if err != nil {
@@ -264,7 +482,7 @@ func restore(cn *pbm.PBM, bcpName string, nss []string, rsMapping map[string]str
 	const waitPhysRestoreStart = time.Second * 120
 	if bcp.Type == pbm.PhysicalBackup || bcp.Type == pbm.IncrementalBackup {
 		ep, _ := cn.GetEpoch()
-		stg, err := cn.GetStorage(cn.Logger().NewEvent(string(pbm.CmdRestore), bcpName, "", ep.TS()))
+		stg, err := cn.GetRestoreStorage(cn.Logger().NewEvent(string(pbm.CmdRestore), bcpName, "", ep.TS()))
 		if err != nil {
 			return nil, errors.Wrap(err, "get storage")
 		}
@@ -304,7 +522,359 @@ func parseTS(t string) (ts primitive.Timestamp, err error) {
 	return primitive.Timestamp{T: uint32(tsto.Unix()), I: 0}, nil
 }
 
-func pitrestore(cn *pbm.PBM, t, base string, nss []string, rsMap map[string]string, outf outFormat) (rmeta *pbm.RestoreMeta, err error) {
+type physicalBaseOut struct {
+	PITR       string   `json:"point-in-time" yaml:"point-in-time"`
+	Backup     string   `json:"backup" yaml:"backup"`
+	Type       string   `json:"type" yaml:"type"`
+	Increments int      `json:"increments" yaml:"increments"`
+	Chain      []string `json:"chain" yaml:"chain"`
+}
+
+func (b physicalBaseOut) String() string {
+	s := fmt.Sprintf("Point-in-time %s would be based on %s <%s>", b.PITR, b.Backup, b.Type)
+	if b.Increments > 0 {
+		s += fmt.Sprintf(" with %d increment(s) on top of %s", b.Increments, b.Chain[0])
+	}
+	return s + "\n"
+}
+
+// physicalBase resolves the base backup for the physical point-in-time restore
+func physicalBase(cn *pbm.PBM, t, force string) (*physicalBaseOut, error) {
+	ts, err := parseTS(t)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := cn.GetPhysicalBase(ts, force)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve base backup")
+	}
+
+	return &physicalBaseOut{
+		PITR:       t,
+		Backup:     base.Backup.Name,
+		Type:       string(base.Backup.Type),
+		Increments: base.Increments(),
+		Chain:      base.Chain,
+	}, nil
+}
+
+type restorePlanOut struct {
+	Backup      string                 `json:"backup" yaml:"backup"`
+	Type        pbm.BackupType         `json:"type" yaml:"type"`
+	PITR        string                 `json:"point-in-time,omitempty" yaml:"point-in-time,omitempty"`
+	NSAllowlist *pbm.NSAllowlistReport `json:"ns_allowlist,omitempty" yaml:"ns_allowlist,omitempty"`
+	ShardSet    *pbm.ShardSetChange    `json:"shard_set_change,omitempty" yaml:"shard_set_change,omitempty"`
+	// Files is what the physical restore would replace on each node
+	Files []pbm.NodeRestoreFiles `json:"files,omitempty" yaml:"files,omitempty"`
+
+	listFiles bool
+}
+
+func (p restorePlanOut) String() string {
+	s := fmt.Sprintf("Backup: %s <%s>\n", p.Backup, p.Type)
+	if p.PITR != "" {
+		s += fmt.Sprintf("Point in time: %s\n", p.PITR)
+	}
+
+	for _, w := range p.ShardSet.Consequences() {
+		s += fmt.Sprintf("Warning: %s\n", w)
+	}
+
+	if len(p.Files) != 0 {
+		s += "Nodes data:\n"
+	}
+	for _, n := range p.Files {
+		s += fmt.Sprintf("  %s/%s: ", n.RS, n.Node)
+		if n.Wipe != nil {
+			s += fmt.Sprintf("wipes %d files (%s), ", n.Wipe.Count, fmtSize(n.Wipe.Size))
+		} else {
+			s += "the dbpath content is unknown (the agent hasn't listed it in time or may be outdated), "
+		}
+		s += fmt.Sprintf("writes %d files (%s)\n", n.Write.Count, fmtSize(n.Write.Size))
+		if p.listFiles {
+			s += fmtFileList("wipe", n.Wipe) + fmtFileList("write", n.Write)
+		}
+	}
+
+	a := p.NSAllowlist
+	if a == nil {
+		return s
+	}
+	s += fmt.Sprintf("Namespaces allowlist: %d checked", a.Checked)
+	if a.OK() {
+		return s + ", all allowed\n"
+	}
+	s += "\n"
+	for _, ns := range a.Unexpected {
+		s += fmt.Sprintf("  not allowed: %s\n", ns)
+	}
+	for _, l := range a.Limitations {
+		s += fmt.Sprintf("  not checked: %s\n", l)
+	}
+	return s
+}
+
+func fmtFileList(op string, l *pbm.FileList) string {
+	if l == nil {
+		return ""
+	}
+
+	s := ""
+	for _, f := range l.Files {
+		s += fmt.Sprintf("    %s %s %s\n", op, f.Name, fmtSize(f.Size))
+	}
+	if l.Truncated {
+		s += fmt.Sprintf("    %s ... %d more files\n", op, l.Count-len(l.Files))
+	}
+	return s
+}
+
+// restorePlan resolves the backup the restore would use and compares its
+// namespaces against the allowlist if it's set. The dry-run plan also
+// reports the difference of its shards from the cluster's ones
+func restorePlan(cn *pbm.PBM, o *restoreOpts, nss []string, rsMap map[string]string) (*restorePlanOut, error) {
+	bcp, err := restoreBackupMeta(cn, o)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &restorePlanOut{
+		Backup: bcp.Name,
+		Type:   bcp.Type,
+		PITR:   o.pitr,
+	}
+	// the restore itself warns about it on its own (see confirmShardSetChange)
+	if o.dryRun {
+		plan.ShardSet, err = cn.CheckShardSetChange(bcp, rsMap)
+		if err != nil {
+			return nil, errors.Wrap(err, "check shards")
+		}
+		if bcp.Type == pbm.PhysicalBackup || bcp.Type == pbm.IncrementalBackup {
+			plan.Files, err = planRestoreFiles(cn, bcp, rsMap)
+			if err != nil {
+				return nil, err
+			}
+			plan.listFiles = o.listFiles
+		}
+	}
+	if o.nsAllowlist == "" {
+		return plan, nil
+	}
+
+	allow, err := parseCLINSOption(o.nsAllowlist)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse --ns-allowlist option")
+	}
+	stg, err := cn.GetStorage(cn.Logger().NewEvent("", "", "", primitive.Timestamp{}))
+	if err != nil {
+		return nil, errors.Wrap(err, "get storage")
+	}
+	plan.NSAllowlist, err = pbm.CheckNSAllowlist(bcp, stg, allow, nss)
+	if err != nil {
+		return nil, errors.Wrap(err, "check namespaces allowlist")
+	}
+
+	return plan, nil
+}
+
+// dbpathListWait is how long the dry-run restore waits for the agents
+// to list their dbpath
+const dbpathListWait = 30 * time.Second
+
+// planRestoreFiles makes the agents list their dbpath and returns what the
+// physical restore of the backup would replace on each node. The nodes
+// which haven't listed it in time have the dbpath content unknown.
+func planRestoreFiles(cn *pbm.PBM, bcp *pbm.BackupMeta, rsMap map[string]string) ([]pbm.NodeRestoreFiles, error) {
+	chain, err := cn.BackupChain(bcp)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup chain")
+	}
+
+	ct, err := cn.ClusterTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+	err = cn.SendCmd(pbm.Cmd{Cmd: pbm.CmdListDBpath})
+	if err != nil {
+		return nil, errors.Wrap(err, "send command")
+	}
+
+	tmr := time.NewTimer(dbpathListWait)
+	defer tmr.Stop()
+	tkr := time.NewTicker(time.Second)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-tkr.C:
+			agents, err := cn.AgentsStatus()
+			if err != nil {
+				return nil, errors.Wrap(err, "get agents status")
+			}
+			if dbpathsListed(agents, ct) {
+				return pbm.PlanRestoreFiles(chain, agents, ct, rsMap), nil
+			}
+		case <-tmr.C:
+			agents, err := cn.AgentsStatus()
+			if err != nil {
+				return nil, errors.Wrap(err, "get agents status")
+			}
+			return pbm.PlanRestoreFiles(chain, agents, ct, rsMap), nil
+		}
+	}
+}
+
+// dbpathsListed tells if all agents have listed their dbpath since `since`
+func dbpathsListed(agents []pbm.AgentStat, since primitive.Timestamp) bool {
+	for _, a := range agents {
+		if a.DBpath == nil || primitive.CompareTimestamp(a.DBpath.TS, since) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreBackupMeta returns the backup the restore would use
+func restoreBackupMeta(cn *pbm.PBM, o *restoreOpts) (*pbm.BackupMeta, error) {
+	var bcp *pbm.BackupMeta
+	var err error
+	switch {
+	case o.bcp != "":
+		bcp, err = cn.GetBackupMeta(o.bcp)
+	case o.pitrBase != "":
+		bcp, err = cn.GetBackupMeta(o.pitrBase)
+	case o.pitr != "":
+		ts, perr := parseTS(o.pitr)
+		if perr != nil {
+			return nil, perr
+		}
+		bcp, err = cn.GetLastBackup(&ts)
+	default:
+		return nil, errors.New("a backup name or point in time is required")
+	}
+	if errors.Is(err, pbm.ErrNotFound) {
+		return nil, errors.New("no backup to restore from found")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup data")
+	}
+
+	return bcp, nil
+}
+
+// confirmShardSetChange warns if the backup predates a shard addition or
+// removal and asks to confirm the restore unless --yes is set. It returns
+// an error if the restore is declined or can't be confirmed: there is no
+// TTY or the output isn't text, so nothing is there to answer.
+func confirmShardSetChange(cn *pbm.PBM, o *restoreOpts, rsMap map[string]string, outf outFormat) error {
+	bcp, err := restoreBackupMeta(cn, o)
+	if err != nil {
+		return err
+	}
+	c, err := cn.CheckShardSetChange(bcp, rsMap)
+	if err != nil {
+		return errors.Wrap(err, "check shards")
+	}
+	if c.Empty() {
+		return nil
+	}
+
+	if outf == outText {
+		for _, w := range c.Consequences() {
+			fmt.Fprintln(os.Stderr, "Warning:", w)
+		}
+	}
+	if o.yes {
+		return nil
+	}
+	if outf != outText || !isTTY() {
+		return errors.New("the cluster's shards differ from the backup's ones. " +
+			"Use --yes to restore anyway")
+	}
+
+	fmt.Fprintf(os.Stderr, "The cluster's shards differ from the backup's ones. %s Restore anyway? [y/N] ",
+		shardSetRestoreImpact(bcp.Type, o.pitr != ""))
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	switch strings.TrimSpace(scanner.Text()) {
+	case "yes", "Yes", "YES", "Y", "y":
+		return nil
+	}
+	return errors.New("restore canceled")
+}
+
+// shardSetRestoreImpact tells what the restore of the given type does to
+// the cluster with different shards
+func shardSetRestoreImpact(typ pbm.BackupType, pitr bool) string {
+	var s string
+	switch typ {
+	case pbm.PhysicalBackup, pbm.IncrementalBackup:
+		s = "The physical restore replaces the data and the sharding metadata on all nodes of the backup's shards"
+	default:
+		s = "The logical restore brings the backup's sharding metadata back"
+	}
+	if pitr {
+		s += " and replays the oplog of the backup's shards"
+	}
+	return s + "."
+}
+
+type restorePointOut struct {
+	Target string
+	Point  string
+	Backup string
+	PITR   string
+	Loss   string
+}
+
+func (p restorePointOut) String() string {
+	s := fmt.Sprintf("Restoring to %s, the latest restorable point before %s.\n", p.Point, p.Target)
+	if p.PITR != "" {
+		s += fmt.Sprintf("Base snapshot: %s, covered by the PITR timeline %s.\n", p.Backup, p.PITR)
+	} else {
+		s += fmt.Sprintf("Snapshot: %s.\n", p.Backup)
+	}
+	if p.Loss != "0s" {
+		s += fmt.Sprintf("Warning: changes made during %s before the target time will be lost!\n", p.Loss)
+	}
+	return s
+}
+
+// restorePointBefore finds the latest restorable point strictly before `t`
+func restorePointBefore(cn *pbm.PBM, t string) (*pbm.RestorePoint, restorePointOut, error) {
+	ts, err := parseTS(t)
+	if err != nil {
+		return nil, restorePointOut{}, err
+	}
+
+	rp, err := cn.RestorePointBefore(ts)
+	if err != nil {
+		return nil, restorePointOut{}, errors.Wrap(err, "find restore point")
+	}
+
+	out := restorePointOut{
+		Target: fmtTSI(ts),
+		Point:  fmtTSI(rp.TS),
+		Backup: rp.Backup.Name,
+		Loss:   rp.Loss.String(),
+	}
+	if rp.IsPITR() {
+		out.PITR = rp.Timeline.String()
+	}
+
+	return rp, out, nil
+}
+
+// fmtTSI formats the timestamp as a date unless the increment
+// is meaningful
+func fmtTSI(ts primitive.Timestamp) string {
+	if ts.I == 0 {
+		return time.Unix(int64(ts.T), 0).UTC().Format(datetimeFormat)
+	}
+	return fmt.Sprintf("%s (%d,%d)", time.Unix(int64(ts.T), 0).UTC().Format(datetimeFormat), ts.T, ts.I)
+}
+
+func pitrestore(cn *pbm.PBM, t, base string, nss []string, rsMap map[string]string, maxLag time.Duration, outf outFormat) (rmeta *pbm.RestoreMeta, err error) {
 	ts, err := parseTS(t)
 	if err != nil {
 		return nil, err
@@ -315,6 +885,11 @@ func pitrestore(cn *pbm.PBM, t, base string, nss []string, rsMap map[string]stri
 		return nil, err
 	}
 
+	err = cn.CheckRestoreMaintenance(false)
+	if err != nil {
+		return nil, err
+	}
+
 	name := time.Now().UTC().Format(time.RFC3339Nano)
 	err = cn.SendCmd(pbm.Cmd{
 		Cmd: pbm.CmdPITRestore,
@@ -325,6 +900,7 @@ func pitrestore(cn *pbm.PBM, t, base string, nss []string, rsMap map[string]stri
 			Bcp:        base,
 			Namespaces: nss,
 			RSMap:      rsMap,
+			MaxLag:     int64(maxLag.Seconds()),
 		},
 	})
 	if err != nil {
@@ -399,6 +975,10 @@ func waitForRestoreStatus(ctx context.Context, cn *pbm.PBM, name string, getfn g
 type descrRestoreOpts struct {
 	restore string
 	cfg     string
+	// fullHistory adds all state transitions of the restore
+	fullHistory bool
+	// listFiles lists the files the physical restore wiped and wrote
+	listFiles bool
 }
 
 type describeRestoreResult struct {
@@ -413,9 +993,75 @@ type describeRestoreResult struct {
 	StartTime          *string          `json:"start,omitempty" yaml:"start,omitempty"`
 	PITR               *int64           `json:"ts_to_restore,omitempty" yaml:"-"`
 	PITRTime           *string          `json:"time_to_restore,omitempty" yaml:"time_to_restore,omitempty"`
+	StopTS             *string          `json:"stopped_at,omitempty" yaml:"stopped_at,omitempty"`
 	LastTransitionTS   int64            `json:"last_transition_ts" yaml:"-"`
 	LastTransitionTime string           `json:"last_transition_time" yaml:"last_transition_time"`
+	Canary             *RestoreCanary   `json:"canary,omitempty" yaml:"canary,omitempty"`
+	Progress           *RestoreProgress `json:"progress,omitempty" yaml:"progress,omitempty"`
 	Replsets           []RestoreReplset `json:"replsets" yaml:"replsets"`
+	Mongos             []MongosCheck    `json:"mongos,omitempty" yaml:"mongos,omitempty"`
+	FollowUp           []string         `json:"follow_up,omitempty" yaml:"follow_up,omitempty"`
+	WiredTigerSalvage  bool             `json:"wt_salvage,omitempty" yaml:"wt_salvage,omitempty"`
+	PartlyDonePolicy   string           `json:"partly_done_policy,omitempty" yaml:"partly_done_policy,omitempty"`
+	NewReplsetID       bool             `json:"new_replset_id,omitempty" yaml:"new_replset_id,omitempty"`
+	SnapshotOnly       bool             `json:"snapshot_only,omitempty" yaml:"snapshot_only,omitempty"`
+	RecoveryTS         *string          `json:"recovery_ts,omitempty" yaml:"recovery_ts,omitempty"`
+	OplogSizeMB        float64          `json:"oplog_size_mb,omitempty" yaml:"oplog_size_mb,omitempty"`
+	History            []condDesc       `json:"history,omitempty" yaml:"history,omitempty"`
+}
+
+type MongosCheck struct {
+	Host      string `json:"host" yaml:"host"`
+	Pending   bool   `json:"pending,omitempty" yaml:"pending,omitempty"`
+	Reachable bool   `json:"reachable" yaml:"reachable"`
+	UptimeSec int64  `json:"uptime,omitempty" yaml:"uptime,omitempty"`
+	Restarted bool   `json:"restarted" yaml:"restarted"`
+	Flushed   bool   `json:"flushed,omitempty" yaml:"flushed,omitempty"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type RestoreCanary struct {
+	Replset            string     `json:"replset" yaml:"replset"`
+	Status             pbm.Status `json:"status" yaml:"status"`
+	Error              *string    `json:"error,omitempty" yaml:"error,omitempty"`
+	LastTransitionTS   int64      `json:"last_transition_ts" yaml:"-"`
+	LastTransitionTime string     `json:"last_transition_time" yaml:"last_transition_time"`
+}
+
+type RestoreProgress struct {
+	Slowest   *NodeProgress  `json:"slowest,omitempty" yaml:"slowest,omitempty"`
+	ETA       string         `json:"eta,omitempty" yaml:"eta,omitempty"`
+	Nodes     []NodeProgress `json:"nodes,omitempty" yaml:"nodes,omitempty"`
+	UpdatedAt string         `json:"updated_at" yaml:"updated_at"`
+}
+
+type NodeProgress struct {
+	Node      string  `json:"node" yaml:"node"`
+	Phase     string  `json:"phase,omitempty" yaml:"phase,omitempty"`
+	Done      int64   `json:"done_bytes,omitempty" yaml:"done_bytes,omitempty"`
+	Total     int64   `json:"total_bytes,omitempty" yaml:"total_bytes,omitempty"`
+	Rate      float64 `json:"rate_bytes_sec,omitempty" yaml:"rate_bytes_sec,omitempty"`
+	ETA       string  `json:"eta,omitempty" yaml:"eta,omitempty"`
+	SlowSince string  `json:"slow_since,omitempty" yaml:"slow_since,omitempty"`
+}
+
+func fmtUnix(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+func nodeProgress(n pbm.NodeProgress) NodeProgress {
+	return NodeProgress{
+		Node:      n.Node,
+		Phase:     n.Phase,
+		Done:      n.Done,
+		Total:     n.Total,
+		Rate:      n.Rate,
+		ETA:       fmtUnix(n.ETA),
+		SlowSince: fmtUnix(n.SlowSince),
+	}
 }
 
 type RestoreReplset struct {
@@ -425,14 +1071,88 @@ type RestoreReplset struct {
 	LastTransitionTS   int64         `json:"last_transition_ts" yaml:"-"`
 	LastTransitionTime string        `json:"last_transition_time" yaml:"last_transition_time"`
 	Nodes              []RestoreNode `json:"nodes,omitempty" yaml:"nodes,omitempty"`
+	Roster             []RosterNode  `json:"roster,omitempty" yaml:"roster,omitempty"`
+	Sample             *SampleCheck  `json:"sample,omitempty" yaml:"sample,omitempty"`
+	ReplLagSec         int           `json:"repl_lag_sec,omitempty" yaml:"repl_lag_sec,omitempty"`
+	History            []condDesc    `json:"history,omitempty" yaml:"history,omitempty"`
+}
+
+type SampleCheck struct {
+	Sampled  int64           `json:"sampled" yaml:"sampled"`
+	Verified int64           `json:"verified" yaml:"verified"`
+	Failed   []NSSampleCheck `json:"failed,omitempty" yaml:"failed,omitempty"`
+}
+
+type NSSampleCheck struct {
+	NS       string   `json:"ns" yaml:"ns"`
+	Sampled  int64    `json:"sampled" yaml:"sampled"`
+	Verified int64    `json:"verified" yaml:"verified"`
+	Errors   []string `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+type RosterNode struct {
+	Name          string            `json:"name" yaml:"name"`
+	Participation pbm.Participation `json:"participation" yaml:"participation"`
+	Status        pbm.Status        `json:"status,omitempty" yaml:"status,omitempty"`
 }
 
 type RestoreNode struct {
-	Name               string     `json:"name" yaml:"name"`
-	Status             pbm.Status `json:"status" yaml:"status"`
-	Error              *string    `json:"error,omitempty" yaml:"error,omitempty"`
-	LastTransitionTS   int64      `json:"last_transition_ts" yaml:"-"`
-	LastTransitionTime string     `json:"last_transition_time" yaml:"last_transition_time"`
+	Name               string           `json:"name" yaml:"name"`
+	Status             pbm.Status       `json:"status" yaml:"status"`
+	Error              *string          `json:"error,omitempty" yaml:"error,omitempty"`
+	LastTransitionTS   int64            `json:"last_transition_ts" yaml:"-"`
+	LastTransitionTime string           `json:"last_transition_time" yaml:"last_transition_time"`
+	Validate           []CollValidation `json:"validate,omitempty" yaml:"validate,omitempty"`
+	TmpPort            int              `json:"tmp_port,omitempty" yaml:"tmp_port,omitempty"`
+	Oplog              *RestoreOplog    `json:"oplog,omitempty" yaml:"oplog,omitempty"`
+	Files              *RestoreFiles    `json:"files,omitempty" yaml:"files,omitempty"`
+}
+
+// RestoreFiles is the data the physical restore wiped and wrote on the node
+type RestoreFiles struct {
+	Wiped   *RestoreFileList `json:"wiped,omitempty" yaml:"wiped,omitempty"`
+	Written *RestoreFileList `json:"written,omitempty" yaml:"written,omitempty"`
+}
+
+type RestoreFileList struct {
+	Count  int     `json:"count" yaml:"count"`
+	SizeMB float64 `json:"size_mb" yaml:"size_mb"`
+	// Files are listed only on request and only the largest ones
+	// if Truncated (see pbm.MaxFilesList)
+	Files     []pbm.FileSize `json:"files,omitempty" yaml:"files,omitempty"`
+	Truncated bool           `json:"truncated,omitempty" yaml:"truncated,omitempty"`
+}
+
+func restoreFileList(l *pbm.FileList, list bool) *RestoreFileList {
+	if l == nil {
+		return nil
+	}
+
+	rv := &RestoreFileList{Count: l.Count, SizeMB: bytesToMB(l.Size)}
+	if list {
+		rv.Files = l.Files
+		rv.Truncated = l.Truncated
+	}
+	return rv
+}
+
+// RestoreOplog is the oplog size (in MB) the restored node ends up with
+type RestoreOplog struct {
+	TargetSizeMB float64 `json:"target_size_mb,omitempty" yaml:"target_size_mb,omitempty"`
+	BackupSizeMB float64 `json:"backup_size_mb" yaml:"backup_size_mb"`
+	SizeMB       float64 `json:"size_mb" yaml:"size_mb"`
+}
+
+func bytesToMB(b int64) float64 {
+	return math.Round(float64(b)/(1<<20)*100) / 100
+}
+
+type CollValidation struct {
+	NS       string   `json:"ns" yaml:"ns"`
+	Valid    bool     `json:"valid" yaml:"valid"`
+	Errors   []string `json:"errors,omitempty" yaml:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+	Duration string   `json:"duration" yaml:"duration"`
 }
 
 func (r describeRestoreResult) String() string {
@@ -448,21 +1168,10 @@ func describeRestore(cn *pbm.PBM, o descrRestoreOpts) (fmt.Stringer, error) {
 	var res describeRestoreResult
 	var meta *pbm.RestoreMeta
 	if o.cfg != "" {
-		buf, err := ioutil.ReadFile(o.cfg)
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to read config file")
-		}
-
-		var cfg pbm.Config
-		err = yaml.UnmarshalStrict(buf, &cfg)
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to  unmarshal config file")
-		}
-
 		l := log.New(nil, "cli", "").NewEvent("", "", "", primitive.Timestamp{})
-		stg, err := pbm.Storage(cfg, l)
+		stg, err := restoreStorageFromFile(o.cfg, l)
 		if err != nil {
-			return nil, errors.Wrap(err, "get storage")
+			return nil, err
 		}
 
 		meta, err = pbm.GetPhysRestoreMeta(o.restore, stg, l)
@@ -502,6 +1211,36 @@ func describeRestore(cn *pbm.PBM, o descrRestoreOpts) (fmt.Stringer, error) {
 		s := time.Unix(meta.PITR, 0).UTC().Format(time.RFC3339)
 		res.PITRTime = &s
 	}
+	if !meta.StopTS.IsZero() && int64(meta.StopTS.T) != meta.PITR {
+		s := fmt.Sprintf("%s <%d,%d>", time.Unix(int64(meta.StopTS.T), 0).UTC().Format(time.RFC3339), meta.StopTS.T, meta.StopTS.I)
+		res.StopTS = &s
+	}
+
+	if meta.Canary != nil {
+		res.Canary = &RestoreCanary{
+			Replset:            meta.Canary.Replset,
+			Status:             meta.Canary.Status,
+			LastTransitionTS:   meta.Canary.Timestamp,
+			LastTransitionTime: time.Unix(meta.Canary.Timestamp, 0).UTC().Format(time.RFC3339),
+		}
+		if meta.Canary.Error != "" {
+			res.Canary.Error = &meta.Canary.Error
+		}
+	}
+
+	if p := meta.Progress; p != nil {
+		res.Progress = &RestoreProgress{
+			ETA:       fmtUnix(p.ETA),
+			UpdatedAt: fmtUnix(p.TS),
+		}
+		if p.Slowest != nil {
+			s := nodeProgress(*p.Slowest)
+			res.Progress.Slowest = &s
+		}
+		for _, n := range p.Nodes {
+			res.Progress.Nodes = append(res.Progress.Nodes, nodeProgress(n))
+		}
+	}
 
 	for _, rs := range meta.Replsets {
 		mrs := RestoreReplset{
@@ -509,6 +1248,7 @@ func describeRestore(cn *pbm.PBM, o descrRestoreOpts) (fmt.Stringer, error) {
 			Status:             rs.Status,
 			LastTransitionTS:   rs.LastTransitionTS,
 			LastTransitionTime: time.Unix(rs.LastTransitionTS, 0).UTC().Format(time.RFC3339),
+			ReplLagSec:         rs.ReplLagSec,
 		}
 		if rs.Status == pbm.StatusError {
 			mrs.Error = &rs.Error
@@ -519,6 +1259,7 @@ func describeRestore(cn *pbm.PBM, o descrRestoreOpts) (fmt.Stringer, error) {
 				Status:             node.Status,
 				LastTransitionTS:   node.LastTransitionTS,
 				LastTransitionTime: time.Unix(node.LastTransitionTS, 0).UTC().Format(time.RFC3339),
+				TmpPort:            node.TmpPort,
 			}
 			if node.Status == pbm.StatusError {
 				serr := node.Error
@@ -533,10 +1274,110 @@ func describeRestore(cn *pbm.PBM, o descrRestoreOpts) (fmt.Stringer, error) {
 				mnode.Error = &serr
 			}
 
+			if o := node.Oplog; o != nil {
+				mnode.Oplog = &RestoreOplog{
+					TargetSizeMB: bytesToMB(o.TargetSize),
+					BackupSizeMB: bytesToMB(o.BackupSize),
+					SizeMB:       bytesToMB(o.Size),
+				}
+			}
+
+			if f := node.Files; f != nil {
+				mnode.Files = &RestoreFiles{
+					Wiped:   restoreFileList(f.Wipe, o.listFiles),
+					Written: restoreFileList(f.Write, o.listFiles),
+				}
+			}
+
+			for _, v := range node.Validate {
+				mnode.Validate = append(mnode.Validate, CollValidation{
+					NS:       v.NS,
+					Valid:    v.Valid,
+					Errors:   v.Errors,
+					Warnings: v.Warnings,
+					Duration: (time.Duration(v.DurationMs) * time.Millisecond).String(),
+				})
+			}
+
 			mrs.Nodes = append(mrs.Nodes, mnode)
 		}
+		for _, n := range rs.Roster {
+			mrs.Roster = append(mrs.Roster, RosterNode(n))
+		}
+		if rs.Sample != nil {
+			mrs.Sample = &SampleCheck{
+				Sampled:  rs.Sample.Sampled,
+				Verified: rs.Sample.Verified,
+			}
+			for _, f := range rs.Sample.Failed {
+				mrs.Sample.Failed = append(mrs.Sample.Failed, NSSampleCheck(f))
+			}
+		}
 		res.Replsets = append(res.Replsets, mrs)
 	}
+	for _, m := range meta.Mongos {
+		res.Mongos = append(res.Mongos, MongosCheck(m))
+	}
+	res.FollowUp = meta.FollowUp
+	res.WiredTigerSalvage = meta.WiredTigerSalvage
+	res.PartlyDonePolicy = string(meta.PartlyDonePolicy)
+	res.NewReplsetID = meta.NewReplsetID
+	res.SnapshotOnly = meta.SnapshotOnly
+	res.OplogSizeMB = meta.OplogSizeMB
+	if !meta.RecoveryTS.IsZero() {
+		s := fmt.Sprintf("%s <%d,%d>", time.Unix(int64(meta.RecoveryTS.T), 0).UTC().Format(time.RFC3339),
+			meta.RecoveryTS.T, meta.RecoveryTS.I)
+		res.RecoveryTS = &s
+	}
+
+	if o.fullHistory {
+		err := describeRestoreHistory(cn, meta, &res, o.cfg == "")
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return res, nil
 }
+
+// describeRestoreHistory adds the state transitions of the restore and its
+// replsets. They're read from the events if `fromEvents` is set and there
+// are any, otherwise it's the latest ones kept in the meta.
+func describeRestoreHistory(cn *pbm.PBM, meta *pbm.RestoreMeta, res *describeRestoreResult, fromEvents bool) error {
+	var evs []pbm.Event
+	if fromEvents {
+		var err error
+		evs, err = cn.Events(pbm.EventRestore, meta.Name)
+		if err != nil {
+			return errors.WithMessage(err, "get events")
+		}
+	}
+
+	if len(evs) != 0 {
+		res.History = describeConditions(pbm.EventsConditions(evs, ""))
+		for i := range res.Replsets {
+			res.Replsets[i].History = describeConditions(pbm.EventsConditions(evs, res.Replsets[i].Name))
+		}
+		return nil
+	}
+
+	res.History = describeConditions(derefConditions(meta.Conditions))
+	for i := range res.Replsets {
+		for _, rs := range meta.Replsets {
+			if rs.Name == res.Replsets[i].Name {
+				res.Replsets[i].History = describeConditions(derefConditions(rs.Conditions))
+			}
+		}
+	}
+	return nil
+}
+
+func derefConditions(cs pbm.Conditions) []pbm.Condition {
+	rv := make([]pbm.Condition, 0, len(cs))
+	for _, c := range cs {
+		if c != nil {
+			rv = append(rv, *c)
+		}
+	}
+	return rv
+}
@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v2"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+type restoreLogsOpts struct {
+	restore  string
+	node     string
+	cfg      string
+	follow   bool
+	severity string
+}
+
+// restoreLogsPoll is how often the storage is checked for new log
+// segments with --follow
+const restoreLogsPoll = 5 * time.Second
+
+// runRestoreLogs prints the log of the node's physical restore saved to the
+// storage. The log is saved in segments as the node's log buffer fills
+// up (and once the restore is over), along with the log of the internal
+// mongod runs if it's kept. With `follow`, new segments are polled for
+// while the node's restore runs.
+func runRestoreLogs(o *restoreLogsOpts) (fmt.Stringer, error) {
+	rs, node, ok := strings.Cut(o.node, "/")
+	if !ok || rs == "" || node == "" {
+		return nil, errors.Errorf("invalid node %q, it should be in format rs/host:port", o.node)
+	}
+	l := log.New(nil, "cli", "").NewEvent("", "", "", primitive.Timestamp{})
+	stg, err := restoreStorageFromFile(o.cfg, l)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &restoreLogTail{
+		stg:      stg,
+		restore:  o.restore,
+		rs:       rs,
+		node:     node,
+		severity: parseSeverity(o.severity),
+		out:      os.Stdout,
+	}
+	return nil, t.run(o.follow, restoreLogsPoll)
+}
+
+// restoreStorageFromFile returns the restore storage (see
+// pbm.RestoreStorage) of the PBM config file
+func restoreStorageFromFile(path string, l *log.Event) (storage.Storage, error) {
+	cfg, err := configFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stg, err := pbm.RestoreStorage(cfg, l)
+	return stg, errors.Wrap(err, "get storage")
+}
+
+// storageFromFile returns the storage of the PBM config file
+func storageFromFile(path string, l *log.Event) (storage.Storage, error) {
+	cfg, err := configFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stg, err := pbm.Storage(cfg, l)
+	return stg, errors.Wrap(err, "get storage")
+}
+
+func configFromFile(path string) (pbm.Config, error) {
+	var cfg pbm.Config
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, errors.Wrap(err, "unable to read config file")
+	}
+
+	err = yaml.UnmarshalStrict(buf, &cfg)
+	return cfg, errors.Wrap(err, "unable to  unmarshal config file")
+}
+
+func parseSeverity(s string) log.Severity {
+	switch s {
+	case "F":
+		return log.Fatal
+	case "E":
+		return log.Error
+	case "W":
+		return log.Warning
+	case "D":
+		return log.Debug
+	default:
+		return log.Info
+	}
+}
+
+type restoreLogTail struct {
+	stg      storage.Storage
+	restore  string
+	rs       string
+	node     string
+	severity log.Severity
+	out      io.Writer
+
+	// the next log segment to print
+	next int
+}
+
+func (t *restoreLogTail) logPath(segment string) string {
+	return fmt.Sprintf("%s/%s/rs.%s/log/%s.%s.log", pbm.PhysRestoresDir, t.restore, t.rs, t.node, segment)
+}
+
+func (t *restoreLogTail) run(follow bool, poll time.Duration) error {
+	for {
+		// check before printing, so the segments written right before
+		// the restore is over are printed as well
+		active := follow && t.active()
+
+		err := t.printSegments()
+		if err != nil {
+			return err
+		}
+		if !active {
+			break
+		}
+		time.Sleep(poll)
+	}
+
+	if t.next == 0 {
+		return errors.Errorf("no logs of node %s/%s in restore %s", t.rs, t.node, t.restore)
+	}
+	return t.printMongodLog()
+}
+
+// printSegments prints the segments saved since the last call
+func (t *restoreLogTail) printSegments() error {
+	for ; ; t.next++ {
+		name := t.logPath(strconv.Itoa(t.next))
+		_, err := t.stg.FileStat(name)
+		if errors.Is(err, storage.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "get file %s", name)
+		}
+
+		err = t.print(name, t.pbmEntry)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// printMongodLog prints the log of the internal mongod runs if it's saved
+func (t *restoreLogTail) printMongodLog() error {
+	name := t.logPath("mongod")
+	_, err := t.stg.FileStat(name)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "get file %s", name)
+	}
+
+	fmt.Fprintln(t.out, "--- internal mongod log ---")
+	return t.print(name, t.mongodEntry)
+}
+
+func (t *restoreLogTail) print(name string, format func(line []byte) (string, bool)) error {
+	r, err := t.stg.SourceReader(name)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", name)
+	}
+	defer r.Close()
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for sc.Scan() {
+		if s, ok := format(sc.Bytes()); ok {
+			fmt.Fprintln(t.out, s)
+		}
+	}
+	return errors.Wrapf(sc.Err(), "read %s", name)
+}
+
+// pbmEntry formats the PBM log entry. Lines that can't be parsed are
+// printed as is.
+func (t *restoreLogTail) pbmEntry(line []byte) (string, bool) {
+	e := log.Entry{}
+	if err := json.Unmarshal(line, &e); err != nil {
+		return string(line), true
+	}
+	return e.Stringify(tsUTC, false, false), e.Severity <= t.severity
+}
+
+// mongodEntry filters the mongod structured log entry by its severity
+// (F, E, W, I or D1-D5). Lines that can't be parsed are printed as is.
+func (t *restoreLogTail) mongodEntry(line []byte) (string, bool) {
+	e := struct {
+		S string `json:"s"`
+	}{}
+	if err := json.Unmarshal(line, &e); err != nil || e.S == "" {
+		return string(line), true
+	}
+	return string(line), parseSeverity(e.S[:1]) <= t.severity
+}
+
+// active tells if the node's restore still runs: its heartbeat is fresh
+// and it hasn't reached the final status
+func (t *restoreLogTail) active() bool {
+	node := fmt.Sprintf("%s/%s/rs.%s/node.%s", pbm.PhysRestoresDir, t.restore, t.rs, t.node)
+	for _, s := range []pbm.Status{pbm.StatusDone, pbm.StatusPartlyDone, pbm.StatusError} {
+		if _, err := t.stg.FileStat(node + "." + string(s)); err == nil {
+			return false
+		}
+	}
+
+	ts, err := pbm.LastPhysRestoreHb(t.stg, t.restore, fmt.Sprintf("rs.%s/node.%s", t.rs, t.node))
+	if err != nil {
+		return false
+	}
+	return ts+pbm.PhysRestoreStaleSec >= time.Now().Unix()
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestRestoreLogTail(t *testing.T) {
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+	save := func(name, data string) {
+		t.Helper()
+		err := stg.Save(pbm.PhysRestoresDir+"/rst/rs.rs0/"+name, strings.NewReader(data), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entry := func(s log.Severity, msg string) string {
+		b, _ := json.Marshal(log.Entry{TS: 1700000000, LogKeys: log.LogKeys{Severity: s}, Msg: msg})
+		return string(b) + "\n"
+	}
+
+	save("log/host:27017.0.log", entry(log.Info, "copying")+entry(log.Debug, "file a"))
+	save("node.host:27017.hb", strconv.FormatInt(time.Now().Unix(), 10))
+
+	buf := &bytes.Buffer{}
+	tl := &restoreLogTail{stg: stg, restore: "rst", rs: "rs0", node: "host:27017", severity: log.Info, out: buf}
+
+	done := make(chan error)
+	go func() { done <- tl.run(true, time.Millisecond*10) }()
+
+	time.Sleep(time.Millisecond * 50)
+	save("log/host:27017.1.log", entry(log.Error, "failed"))
+	save("log/host:27017.mongod.log", `{"s":"I","msg":"started"}`+"\n"+`{"s":"D2","msg":"debug"}`+"\n")
+	save("node.host:27017.error", "1700000001:failed")
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("still following after the node's restore is over")
+	}
+
+	out := buf.String()
+	for _, s := range []string{"copying", "failed", `"msg":"started"`} {
+		if !strings.Contains(out, s) {
+			t.Errorf("no %q in output:\n%s", s, out)
+		}
+	}
+	for _, s := range []string{"file a", `"msg":"debug"`} {
+		if strings.Contains(out, s) {
+			t.Errorf("debug %q in output:\n%s", s, out)
+		}
+	}
+	if strings.Index(out, "copying") > strings.Index(out, "failed") {
+		t.Errorf("segments are out of order:\n%s", out)
+	}
+
+	tl = &restoreLogTail{stg: stg, restore: "rst", rs: "rs0", node: "other:27017", severity: log.Info, out: buf}
+	if err := tl.run(false, 0); err == nil {
+		t.Error("expected error on no logs")
+	}
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+)
+
+type restoreValidateOpts struct {
+	restore  string
+	rsMap    string
+	countPct float64
+	sizePct  float64
+}
+
+type restoreValidateOut struct {
+	Restore string `json:"restore"`
+	Backup  string `json:"backup"`
+	// Note explains the expected differences (e.g. the oplog applied
+	// on top of the backup by the PITR restore)
+	Note     string              `json:"note,omitempty"`
+	Replsets []restoreValidateRS `json:"replsets"`
+}
+
+type restoreValidateRS struct {
+	Name string `json:"name"`
+	// Backup is the replset's name in the backup if it's different
+	Backup  string `json:"backup,omitempty"`
+	Checked int    `json:"checked"`
+	// Skipped is why the replset wasn't checked
+	Skipped string           `json:"skipped,omitempty"`
+	Diff    []pbm.NSStatDiff `json:"diff,omitempty"`
+}
+
+func (o restoreValidateOut) HasError() bool {
+	for _, rs := range o.Replsets {
+		if len(rs.Diff) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (o restoreValidateOut) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "Restore %s of backup %s\n", o.Restore, o.Backup)
+	if o.Note != "" {
+		fmt.Fprintf(&s, "Note: %s\n", o.Note)
+	}
+	for _, rs := range o.Replsets {
+		name := rs.Name
+		if rs.Backup != "" {
+			name += " (" + rs.Backup + " in the backup)"
+		}
+		switch {
+		case rs.Skipped != "":
+			fmt.Fprintf(&s, "%s: skipped: %s\n", name, rs.Skipped)
+			continue
+		case len(rs.Diff) == 0:
+			fmt.Fprintf(&s, "%s: OK, %d namespaces checked\n", name, rs.Checked)
+			continue
+		}
+
+		fmt.Fprintf(&s, "%s: %d of %d namespaces differ\n", name, len(rs.Diff), rs.Checked)
+		for _, d := range rs.Diff {
+			ns := d.NS
+			if ns == pbm.NSStatsTotal {
+				ns = "total"
+			}
+			fmt.Fprintf(&s, "  %s: %s", ns, strings.Join(d.Mismatch, ", "))
+			switch {
+			case d.Backup != nil && d.Restored != nil:
+				fmt.Fprintf(&s, " (count %d -> %d, size %s -> %s, indexes %d -> %d)",
+					d.Backup.Count, d.Restored.Count, fmtSize(d.Backup.Size), fmtSize(d.Restored.Size),
+					d.Backup.Indexes, d.Restored.Indexes)
+			case d.Backup != nil:
+				fmt.Fprintf(&s, " (count %d, size %s in the backup)", d.Backup.Count, fmtSize(d.Backup.Size))
+			case d.Restored != nil:
+				fmt.Fprintf(&s, " (count %d, size %s restored)", d.Restored.Count, fmtSize(d.Restored.Size))
+			}
+			s.WriteString("\n")
+		}
+	}
+
+	return s.String()
+}
+
+// runRestoreValidate checks the restored namespaces against the stats
+// captured at the backup start (see pbm.BackupReplset.NSStats). Each
+// replset is checked on its primary.
+func runRestoreValidate(cn *pbm.PBM, uri string, o *restoreValidateOpts) (fmt.Stringer, error) {
+	rsMap, err := parseRSNamesMapping(o.rsMap)
+	if err != nil {
+		return nil, errors.WithMessage(err, "cannot parse replset mapping")
+	}
+	if o.countPct < 0 || o.sizePct < 0 {
+		return nil, errors.New("tolerance can't be negative")
+	}
+
+	meta, err := cn.GetRestoreMeta(o.restore)
+	if errors.Is(err, pbm.ErrNotFound) {
+		return nil, errors.Errorf("restore %q not found. The physical restore is listed "+
+			"after `pbm config --force-resync`", o.restore)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "get restore meta")
+	}
+	if meta.Status != pbm.StatusDone && meta.Status != pbm.StatusPartlyDone {
+		return nil, errors.Errorf("restore is %s, not done", meta.Status)
+	}
+	bcp, err := cn.GetBackupMeta(meta.Backup)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get backup %q meta", meta.Backup)
+	}
+
+	members, err := cn.ClusterMembers()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster members")
+	}
+	hosts := make(map[string]string, len(members))
+	for _, m := range members {
+		hosts[m.RS] = m.Host
+	}
+
+	out := restoreValidateOut{
+		Restore: meta.Name,
+		Backup:  bcp.Name,
+		Note:    restoreValidateNote(meta, bcp),
+	}
+
+	// the selective restore leaves other namespaces as they were
+	var match func(string) bool
+	switch {
+	case sel.IsSelective(meta.Namespaces):
+		match = sel.MakeSelectedPred(meta.Namespaces)
+	case sel.IsSelective(bcp.Namespaces):
+		match = sel.MakeSelectedPred(bcp.Namespaces)
+	}
+	tol := pbm.NSStatsTolerance{CountPct: o.countPct, SizePct: o.sizePct}
+	mapRS := pbm.MakeRSMapFunc(rsMap)
+	for _, brs := range bcp.Replsets {
+		rs := restoreValidateRS{Name: mapRS(brs.Name)}
+		if rs.Name != brs.Name {
+			rs.Backup = brs.Name
+		}
+
+		host, ok := hosts[rs.Name]
+		switch {
+		case brs.NSStats == nil:
+			rs.Skipped = "the backup has no namespaces stats"
+		case !ok:
+			rs.Skipped = "no such replset in the cluster"
+		default:
+			base := brs.NSStats.Filter(match)
+			rs.Checked = len(base.Namespaces)
+			rs.Diff, err = validateRSStats(cn, uri, host, base, match, tol)
+			if err != nil {
+				return nil, errors.Wrapf(err, "check replset %s", rs.Name)
+			}
+		}
+		out.Replsets = append(out.Replsets, rs)
+	}
+	sort.Slice(out.Replsets, func(i, j int) bool { return out.Replsets[i].Name < out.Replsets[j].Name })
+
+	return out, nil
+}
+
+func validateRSStats(cn *pbm.PBM, uri, host string, base *pbm.NSStats, match func(string) bool, tol pbm.NSStatsTolerance) ([]pbm.NSStatDiff, error) {
+	ctx := cn.Context()
+	conn, err := connect(ctx, uri, host)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect")
+	}
+	defer conn.Disconnect(ctx)
+
+	stats, err := pbm.GetNSStats(ctx, conn, match)
+	if err != nil {
+		return nil, errors.Wrap(err, "get namespaces stats")
+	}
+
+	return pbm.CompareNSStats(base, stats, tol), nil
+}
+
+// restoreValidateNote explains why the restored data may differ from
+// the backup's stats beyond the oplog window of the backup itself
+func restoreValidateNote(meta *pbm.RestoreMeta, bcp *pbm.BackupMeta) string {
+	var notes []string
+	if meta.PITR != 0 {
+		notes = append(notes, fmt.Sprintf("point-in-time restore: the oplog up to %s was applied "+
+			"on top of the backup, the stats reflect the backup start",
+			time.Unix(meta.PITR, 0).UTC().Format(time.RFC3339)))
+	}
+	if bcp.Type == pbm.LogicalBackup {
+		notes = append(notes, "counts are estimates and may differ by the writes during the backup")
+	}
+	return strings.Join(notes, "; ")
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/url"
 	"sort"
 	"strings"
 	"sync"
@@ -25,8 +24,9 @@ import (
 )
 
 type statusOptions struct {
-	rsMap    string
-	sections []string
+	rsMap     string
+	sections  []string
+	pitrStats bool
 }
 
 type statusOut struct {
@@ -103,9 +103,15 @@ func status(cn *pbm.PBM, curi string, opts statusOptions, pretty bool) (fmt.Stri
 					return clusterStatus(cn, curi)
 				},
 			},
-			{"pitr", "PITR incremental backup", nil, getPitrStatus},
+			{
+				"pitr", "PITR incremental backup", nil,
+				func(cn *pbm.PBM) (fmt.Stringer, error) {
+					return getPitrStatus(cn, opts.pitrStats)
+				},
+			},
 			{"running", "Currently running", nil, getCurrOps},
 			{"backups", "Backups", nil, storageStatFn},
+			{"coverage", "Coverage forecast", nil, getCoverageStatus},
 		},
 		pretty: pretty,
 	}
@@ -174,6 +180,31 @@ type node struct {
 	Role RSRole   `json:"role"`
 	OK   bool     `json:"ok"`
 	Errs []string `json:"errors,omitempty"`
+	// Maintenance is the time the node's maintenance expires at
+	Maintenance string `json:"maintenanceUntil,omitempty"`
+	// Suitability is the latest check if the node can make a backup
+	Suitability *pbm.NodeSuitability `json:"backupSuitability,omitempty"`
+	// Uploads is the state of the agent's uploads limiter
+	Uploads *storage.LimiterStat `json:"uploads,omitempty"`
+	// SelfCheck is the failed checks of the agent's latest self-check.
+	// They fail physical restores only.
+	SelfCheck []pbm.SelfCheckItem `json:"selfCheckFailed,omitempty"`
+}
+
+func fmtUploads(u *storage.LimiterStat) string {
+	s := fmt.Sprintf("%d running", u.Running)
+	if u.MaxConcurrent != 0 {
+		s = fmt.Sprintf("%d/%d running", u.Running, u.MaxConcurrent)
+	}
+	if u.MaxBytesPerSec != 0 {
+		s += fmt.Sprintf(", max %s/s", fmtSize(u.MaxBytesPerSec))
+	}
+	for _, c := range u.Classes {
+		if c.Waiting != 0 {
+			s += fmt.Sprintf(", %s: %d waiting", c.Class, c.Waiting)
+		}
+	}
+	return s
 }
 
 func (n node) String() (s string) {
@@ -187,13 +218,25 @@ func (n node) String() (s string) {
 	}
 
 	s += fmt.Sprintf("%s [%s]: pbm-agent %v", n.Host, role, n.Ver)
+	if n.Maintenance != "" {
+		s += fmt.Sprintf(" [maintenance until %s]", n.Maintenance)
+	}
 	if n.OK {
 		s += " OK"
-		return s
+	} else {
+		s += " FAILED status:"
+		for _, e := range n.Errs {
+			s += fmt.Sprintf("\n      > ERROR with %s", e)
+		}
+	}
+	for _, c := range n.SelfCheck {
+		s += fmt.Sprintf("\n      > self-check %s failed: %s", c.Name, c.Msg)
+	}
+	if n.Suitability != nil && !n.Suitability.OK() {
+		s += fmt.Sprintf("\n      > not suitable for backup at %s: %s", fmtTS(int64(n.Suitability.TS.T)), n.Suitability)
 	}
-	s += " FAILED status:"
-	for _, e := range n.Errs {
-		s += fmt.Sprintf("\n      > ERROR with %s", e)
+	if u := n.Uploads; u != nil && (u.MaxConcurrent != 0 || u.MaxBytesPerSec != 0) {
+		s += "\n      > uploads: " + fmtUploads(u)
 	}
 
 	return s
@@ -275,6 +318,12 @@ func clusterStatus(cn *pbm.PBM, uri string) (fmt.Stringer, error) {
 				}
 				nd.Ver = "v" + stat.Ver
 				nd.OK, nd.Errs = stat.OK()
+				nd.Suitability = stat.Suitability
+				nd.SelfCheck = stat.SelfCheck.Failed()
+				nd.Uploads = stat.Upload
+				if stat.InMaintenance(clusterTime) {
+					nd.Maintenance = fmtTS(int64(stat.Maintenance.Until.T))
+				}
 			}
 
 			m.Lock()
@@ -297,18 +346,13 @@ func connect(ctx context.Context, uri, hosts string) (*mongo.Client, error) {
 		host = chost[0]
 	}
 
-	curi, err := url.Parse("mongodb://" + strings.Replace(uri, "mongodb://", "", 1))
+	// Preserving the `replicaSet` parameter will cause an error while connecting to the ConfigServer (mismatched replicaset names)
+	curi, err := pbm.HostsURI(uri, host)
 	if err != nil {
-		return nil, errors.Wrapf(err, "parse mongo-uri '%s'", uri)
+		return nil, err
 	}
 
-	// Preserving the `replicaSet` parameter will cause an error while connecting to the ConfigServer (mismatched replicaset names)
-	query := curi.Query()
-	query.Del("replicaSet")
-	curi.RawQuery = query.Encode()
-	curi.Host = host
-
-	conn, err := mongo.NewClient(options.Client().ApplyURI(curi.String()).SetAppName("pbm-status"))
+	conn, err := mongo.NewClient(options.Client().ApplyURI(curi).SetAppName("pbm-status"))
 	if err != nil {
 		return nil, errors.Wrap(err, "create mongo client")
 	}
@@ -326,9 +370,14 @@ func connect(ctx context.Context, uri, hosts string) (*mongo.Client, error) {
 }
 
 type pitrStat struct {
-	InConf  bool   `json:"conf"`
-	Running bool   `json:"run"`
-	Err     string `json:"error,omitempty"`
+	InConf  bool                 `json:"conf"`
+	Running bool                 `json:"run"`
+	Err     string               `json:"error,omitempty"`
+	Stats   []pbm.PITRChunkStats `json:"stats,omitempty"`
+	// Gaps are the oplog ranges lost for PITR since the last backup
+	Gaps []pbm.PITRGap `json:"gaps,omitempty"`
+	// Freeze is the backup freeze in effect, if any
+	Freeze *pbm.BackupFreeze `json:"freeze,omitempty"`
 }
 
 func (p pitrStat) String() string {
@@ -337,13 +386,42 @@ func (p pitrStat) String() string {
 		status = "ON"
 	}
 	s := fmt.Sprintf("Status [%s]", status)
+	if p.Freeze != nil {
+		s += fmt.Sprintf("\n! Backups and PITR are frozen %s", p.Freeze)
+	}
 	if p.Err != "" {
 		s += fmt.Sprintf("\n! ERROR while running PITR backup: %s", p.Err)
 	}
+	for _, g := range p.Gaps {
+		s += fmt.Sprintf("\n! Oplog gap on %s: %s - %s, no PITR within it. Run `pbm backup` if not yet",
+			g.RS, fmtTS(int64(g.From.T)), fmtTS(int64(g.To.T)))
+	}
+	if p.Stats != nil {
+		s += fmt.Sprintf("\nLast %d chunks:", pitrStatsChunks)
+		if len(p.Stats) == 0 {
+			s += " none"
+		}
+	}
+	for _, st := range p.Stats {
+		s += fmt.Sprintf("\n  %s: %d chunks, size min/p50/p90/max %s/%s/%s/%s, avg span %v",
+			st.RS, st.Chunks, fmtSize(st.MinSize), fmtSize(st.P50Size), fmtSize(st.P90Size), fmtSize(st.MaxSize), st.AvgSpan)
+		if len(st.Cuts) > 0 {
+			cuts := make([]string, 0, len(st.Cuts))
+			for c, n := range st.Cuts {
+				cuts = append(cuts, fmt.Sprintf("%s: %d", c, n))
+			}
+			sort.Strings(cuts)
+			s += fmt.Sprintf(", cut by %s", strings.Join(cuts, ", "))
+		}
+	}
 	return s
 }
 
-func getPitrStatus(cn *pbm.PBM) (fmt.Stringer, error) {
+// pitrStatsChunks is the number of the most recent chunks (per replset)
+// `pbm status --pitr-stats` reports about
+const pitrStatsChunks = 100
+
+func getPitrStatus(cn *pbm.PBM, stats bool) (fmt.Stringer, error) {
 	var p pitrStat
 	var err error
 	p.InConf, err = cn.IsPITR()
@@ -357,8 +435,37 @@ func getPitrStatus(cn *pbm.PBM) (fmt.Stringer, error) {
 	}
 
 	p.Err, err = getPitrErr(cn)
+	if err != nil {
+		return p, errors.Wrap(err, "check for errors")
+	}
+
+	p.Freeze, err = cn.GetBackupFreeze()
+	if err != nil {
+		return p, errors.Wrap(err, "check backup freeze")
+	}
+
+	// gaps before the last backup don't matter as PITR goes on from it
+	var since primitive.Timestamp
+	bcp, err := cn.GetLastBackup(nil)
+	if err != nil && !errors.Is(err, pbm.ErrNotFound) {
+		return p, errors.Wrap(err, "get last backup")
+	}
+	if bcp != nil {
+		since = bcp.LastWriteTS
+	}
+	p.Gaps, err = cn.PITRGaps(since)
+	if err != nil {
+		return p, errors.Wrap(err, "get oplog gaps")
+	}
+
+	if stats {
+		p.Stats, err = cn.PITRChunksStats(pitrStatsChunks)
+		if err != nil {
+			return p, errors.Wrap(err, "get chunks stats")
+		}
+	}
 
-	return p, errors.Wrap(err, "check for errors")
+	return p, nil
 }
 
 func getPitrErr(cn *pbm.PBM) (string, error) {
@@ -443,6 +550,41 @@ func (c currOp) String() string {
 	}
 }
 
+type coverageStat struct {
+	Warnings []pbm.CoverageWarning `json:"warnings"`
+}
+
+func (c coverageStat) String() string {
+	if len(c.Warnings) == 0 {
+		return "The restorable window meets the objectives"
+	}
+
+	s := ""
+	for _, w := range c.Warnings {
+		s += "Warning " + w.String() + "\n"
+	}
+	return s
+}
+
+// getCoverageStatus forecasts the restorable window under the retention
+// policy. The section is omitted if no objectives are set.
+func getCoverageStatus(cn *pbm.PBM) (fmt.Stringer, error) {
+	cfg, err := cn.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "get config")
+	}
+	if cfg.Retention.MinSnapshots == 0 && cfg.Retention.MinPITRDays == 0 && cfg.Retention.KeepDays == 0 {
+		return nil, nil
+	}
+
+	ws, err := cn.CheckCoverage(time.Now(), cfg.Retention)
+	if err != nil {
+		return nil, err
+	}
+
+	return coverageStat{Warnings: ws}, nil
+}
+
 func getCurrOps(cn *pbm.PBM) (fmt.Stringer, error) {
 	var r currOp
 
@@ -556,6 +698,10 @@ func (s storageStat) String() string {
 			kind += ", base"
 		}
 
+		if sn.Protected {
+			status += " [protected]"
+		}
+
 		ret += fmt.Sprintf("    %s %s <%s> %s\n",
 			sn.Name, fmtSize(sn.Size), kind, status)
 	}
@@ -647,6 +793,7 @@ func getStorageStat(cn *pbm.PBM, rsMap map[string]string) (fmt.Stringer, error)
 			PBMVersion: bcp.PBMVersion,
 			Type:       bcp.Type,
 			SrcBackup:  bcp.SrcBackup,
+			Protected:  bcp.Protected,
 		}
 		if err := bcp.Error(); err != nil {
 			snpsht.Err = err
@@ -7,7 +7,6 @@ import (
 	"os"
 	"runtime"
 	"strconv"
-	"strings"
 
 	"github.com/alecthomas/kingpin"
 	"github.com/pkg/errors"
@@ -27,12 +26,20 @@ func main() {
 		mURI      = pbmAgentCmd.Flag(mongoConnFlag, "MongoDB connection string").Envar("PBM_MONGODB_URI").Required().String()
 		dumpConns = pbmAgentCmd.Flag("dump-parallel-collections", "Number of collections to dump in parallel").Envar("PBM_DUMP_PARALLEL_COLLECTIONS").Default(strconv.Itoa(runtime.NumCPU() / 2)).Int()
 
+		connOpts pbm.ConnOpts
+
 		versionCmd    = pbmCmd.Command("version", "PBM version info")
 		versionShort  = versionCmd.Flag("short", "Only version info").Default("false").Bool()
 		versionCommit = versionCmd.Flag("commit", "Only git commit info").Default("false").Bool()
 		versionFormat = versionCmd.Flag("format", "Output format <json or \"\">").Default("").String()
 	)
 
+	pbmAgentCmd.Flag("write-concern", "Write concern for PBM metadata: \"majority\" or the number of nodes (at least 1)").
+		Envar("PBM_WRITE_CONCERN").Default("majority").StringVar(&connOpts.WriteConcern)
+	pbmAgentCmd.Flag("hb-write-concern", "Write concern for heartbeats and agents' statuses. Weaker one saves cross-region round trips, "+
+		"but such writes may be lost on failover. Same as --write-concern if not set").
+		Envar("PBM_HB_WRITE_CONCERN").StringVar(&connOpts.HeartbeatWriteConcern)
+
 	cmd, err := pbmCmd.DefaultEnvars().Parse(os.Args[1:])
 	if err != nil && cmd != versionCmd.FullCommand() {
 		log.Println("Error: Parse command line parameters:", err)
@@ -52,22 +59,22 @@ func main() {
 	}
 
 	// hidecreds() will rewrite the flag content, so we have to make a copy before passing it on
-	url := "mongodb://" + strings.Replace(*mURI, "mongodb://", "", 1)
+	url := pbm.NormalizeURI(*mURI)
 
 	hidecreds()
 
-	err = runAgent(url, *dumpConns)
+	err = runAgent(url, *dumpConns, connOpts)
 	log.Println("Exit:", err)
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
-func runAgent(mongoURI string, dumpConns int) error {
+func runAgent(mongoURI string, dumpConns int, connOpts pbm.ConnOpts) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	pbmClient, err := pbm.New(ctx, mongoURI, "pbm-agent")
+	pbmClient, err := pbm.NewWithOpts(ctx, mongoURI, "pbm-agent", connOpts)
 	if err != nil {
 		return errors.Wrap(err, "connect to PBM")
 	}
@@ -86,6 +93,7 @@ func runAgent(mongoURI string, dumpConns int) error {
 
 	go agnt.PITR()
 	go agnt.HbStatus()
+	go agnt.SyncPhysRestores()
 
 	return errors.Wrap(agnt.Start(), "listen the commands stream")
 }
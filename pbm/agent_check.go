@@ -0,0 +1,128 @@
+package pbm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxClockSkewSec is the max difference between the agent's clock
+// and the cluster time the self-check tolerates
+const MaxClockSkewSec = 30
+
+// SelfCheck is the result of the agent's self-check. It's run on the
+// agent start and on `pbm agent doctor`.
+type SelfCheck struct {
+	// TS is the cluster time of the check
+	TS    primitive.Timestamp `bson:"ts" json:"ts"`
+	Items []SelfCheckItem     `bson:"items" json:"items"`
+}
+
+type SelfCheckItem struct {
+	Name string `bson:"n" json:"name"`
+	OK   bool   `bson:"ok" json:"ok"`
+	// Msg is the error with the hint on how to fix it for failed checks
+	Msg string `bson:"msg,omitempty" json:"msg,omitempty"`
+}
+
+// Failed returns the failed checks
+func (c *SelfCheck) Failed() []SelfCheckItem {
+	if c == nil {
+		return nil
+	}
+
+	var rv []SelfCheckItem
+	for _, i := range c.Items {
+		if !i.OK {
+			rv = append(rv, i)
+		}
+	}
+	return rv
+}
+
+// SetAgentSelfCheck records the results of the node agent's self-check
+func (p *PBM) SetAgentSelfCheck(rs, node string, c *SelfCheck) error {
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+	c.TS = ct
+
+	_, err = p.Conn.Database(DB).Collection(AgentsStatusCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"n", node}, {"rs", rs}},
+		bson.D{{"$set", bson.M{"chk": c}}},
+	)
+	return errors.Wrap(err, "write into db")
+}
+
+// requiredRoles are the roles the PBM user needs on the node
+var requiredRoles = []string{"backup", "restore", "clusterMonitor", "readWrite"}
+
+// missingRoles returns the roles the user lacks out of requiredRoles.
+// Superuser roles cover all of them. No authenticated user means
+// the access control is off.
+func missingRoles(auth *AuthInfo) []string {
+	if auth == nil || len(auth.Users) == 0 {
+		return nil
+	}
+
+	has := make(map[string]bool)
+	for _, r := range auth.UserRoles {
+		switch r.Role {
+		case "root", "__system":
+			return nil
+		case "readWriteAnyDatabase":
+			has["readWrite"] = true
+		}
+		has[r.Role] = true
+	}
+
+	var rv []string
+	for _, r := range requiredRoles {
+		if !has[r] {
+			rv = append(rv, r)
+		}
+	}
+	return rv
+}
+
+// CheckRoles returns an error listing the required roles the user lacks
+func CheckRoles(auth *AuthInfo) error {
+	m := missingRoles(auth)
+	if len(m) == 0 {
+		return nil
+	}
+
+	var u []string
+	for _, a := range auth.Users {
+		u = append(u, a.User+"@"+a.DB)
+	}
+	return errors.Errorf("user %s lacks roles: %s. Grant them with db.grantRolesToUser()",
+		strings.Join(u, ", "), strings.Join(m, ", "))
+}
+
+// CheckClockSkew returns an error if the local time (unix seconds)
+// is off the cluster time by more than MaxClockSkewSec
+func CheckClockSkew(local int64, ct primitive.Timestamp) error {
+	d := local - int64(ct.T)
+	if d < 0 {
+		d = -d
+	}
+	if d <= MaxClockSkewSec {
+		return nil
+	}
+
+	return errors.Errorf("local clock is off the cluster time by %ds. "+
+		"Sync the host clock (e.g. with NTP)", d)
+}
+
+func (i SelfCheckItem) String() string {
+	if i.OK {
+		return fmt.Sprintf("%s: OK", i.Name)
+	}
+	return fmt.Sprintf("%s: FAILED: %s", i.Name, i.Msg)
+}
@@ -0,0 +1,66 @@
+package pbm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMissingRoles(t *testing.T) {
+	usr := []AuthUser{{User: "pbm", DB: "admin"}}
+	cases := []struct {
+		name string
+		auth *AuthInfo
+		want []string
+	}{
+		{"no auth", &AuthInfo{}, nil},
+		{"root", &AuthInfo{Users: usr, UserRoles: []AuthUserRoles{{Role: "root", DB: "admin"}}}, nil},
+		{"all", &AuthInfo{Users: usr, UserRoles: []AuthUserRoles{
+			{Role: "backup"}, {Role: "restore"}, {Role: "clusterMonitor"}, {Role: "readWriteAnyDatabase"},
+		}}, nil},
+		{"missing", &AuthInfo{Users: usr, UserRoles: []AuthUserRoles{{Role: "backup"}}},
+			[]string{"restore", "clusterMonitor", "readWrite"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := missingRoles(c.auth); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	ct := primitive.Timestamp{T: 1000}
+	if err := CheckClockSkew(1000+MaxClockSkewSec, ct); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	err := CheckClockSkew(1000-MaxClockSkewSec-5, ct)
+	if err == nil || !strings.Contains(err.Error(), "by 35s") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// failed self-checks matter only for the physical restore (see
+// TestCheckRestoreCaps), the node still suits backups
+func TestAgentStatOKSelfCheck(t *testing.T) {
+	s := AgentStat{
+		PBMStatus:     SubsysStatus{OK: true},
+		NodeStatus:    SubsysStatus{OK: true},
+		StorageStatus: SubsysStatus{OK: true},
+	}
+	if ok, _ := s.OK(); !ok {
+		t.Error("expected ok without self-check")
+	}
+
+	s.SelfCheck = &SelfCheck{Items: []SelfCheckItem{
+		{Name: "roles", OK: true},
+		{Name: "dbpath", Msg: "permission denied"},
+	}}
+	if ok, errs := s.OK(); !ok {
+		t.Errorf("expected ok with the failed self-check, got %v", errs)
+	}
+}
@@ -3,12 +3,17 @@ package pbm
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
 type AgentStat struct {
@@ -24,6 +29,95 @@ type AgentStat struct {
 	StorageStatus SubsysStatus        `bson:"stors"`
 	Heartbeat     primitive.Timestamp `bson:"hb"`
 	Err           string              `bson:"e"`
+	// Host and PID identify the agent process serving the node
+	Host string `bson:"host,omitempty"`
+	PID  int    `bson:"pid,omitempty"`
+	// Maintenance is set by the user (see `pbm agent maintenance`)
+	// and preserved by the agent's heartbeats
+	Maintenance *AgentMaintenance `bson:"mnt,omitempty"`
+	// Suitability is the latest decision on whether the node
+	// can make a backup
+	Suitability *NodeSuitability `bson:"suit,omitempty"`
+	// MongoVer is the version of the mongod the agent serves
+	MongoVer string `bson:"mv,omitempty"`
+	// SelfCheck is the latest result of the agent's self-check.
+	// Heartbeats preserve it.
+	SelfCheck *SelfCheck `bson:"chk,omitempty"`
+	// Caps are the node's capabilities for physical restores
+	Caps *AgentCaps `bson:"caps,omitempty"`
+	// DBpath is the dbpath content a physical restore would wipe. It's
+	// listed only on request of the dry-run restore and heartbeats
+	// preserve it.
+	DBpath *DBpathList `bson:"dbp,omitempty"`
+	// ReplLag is the node's replication lag in seconds. Zero is stored
+	// too, so the caught up node's lag gets cleared.
+	ReplLag int `bson:"lag"`
+	// Upload is the state of the agent's uploads limiter
+	// (see UploadLimitConf)
+	Upload *storage.LimiterStat `bson:"upl,omitempty"`
+}
+
+// AgentCaps are what the agent reports about its ability to run
+// a physical restore on the node
+type AgentCaps struct {
+	// MongodBin is the mongod binary the restore would run
+	MongodBin string `bson:"bin,omitempty"`
+	// MongodBinVer is the version of MongodBin
+	MongodBinVer string `bson:"binv,omitempty"`
+	// MongodBinErr is why MongodBin is unusable
+	MongodBinErr string `bson:"bine,omitempty"`
+	// DBpathFree is the free space on the dbpath volume in bytes,
+	// -1 if unknown
+	DBpathFree int64 `bson:"free"`
+}
+
+// UnsuitableReason is the reason the node can't make a backup
+type UnsuitableReason string
+
+const (
+	UnsuitableReplLag UnsuitableReason = "replicationLag"
+	UnsuitableHealth  UnsuitableReason = "unhealthy"
+	UnsuitableState   UnsuitableReason = "state"
+	// UnsuitableHidden is a hidden member with the secondary delay,
+	// its data is behind on purpose
+	UnsuitableHidden UnsuitableReason = "hiddenDelayed"
+	// UnsuitablePriority is a node excluded by the negative
+	// backup.priority (see PrioritySuitability)
+	UnsuitablePriority UnsuitableReason = "priority"
+)
+
+// NodeSuitability is the result of the check if the node
+// can make a backup
+type NodeSuitability struct {
+	// Reason is empty if the node suits
+	Reason UnsuitableReason `bson:"r,omitempty" json:"reason,omitempty"`
+	Detail string           `bson:"d,omitempty" json:"detail,omitempty"`
+	// TS is the cluster time of the check
+	TS primitive.Timestamp `bson:"ts" json:"ts"`
+}
+
+// OK tells if the node suits
+func (s NodeSuitability) OK() bool {
+	return s.Reason == ""
+}
+
+func (s NodeSuitability) String() string {
+	if s.OK() {
+		return "suitable"
+	}
+	return fmt.Sprintf("%s: %s", s.Reason, s.Detail)
+}
+
+// AgentMaintenance marks the node as being in maintenance. Such node isn't
+// nominated for backups and can't take part in restores.
+type AgentMaintenance struct {
+	// Until is the cluster time the maintenance expires at
+	Until primitive.Timestamp `bson:"until"`
+}
+
+// InMaintenance tells if the node is in maintenance at the given cluster time
+func (s *AgentStat) InMaintenance(ct primitive.Timestamp) bool {
+	return s.Maintenance != nil && s.Maintenance.Until.T > ct.T
 }
 
 type SubsysStatus struct {
@@ -56,15 +150,85 @@ func (p *PBM) SetAgentStatus(stat AgentStat) error {
 	}
 	stat.Heartbeat = ct
 
-	_, err = p.Conn.Database(DB).Collection(AgentsStatusCollection).ReplaceOne(
+	_, err = p.hbCollection(AgentsStatusCollection).UpdateOne(
 		p.ctx,
 		bson.D{{"n", stat.Node}, {"rs", stat.RS}},
-		stat,
-		options.Replace().SetUpsert(true),
+		agentStatusUpdate(stat),
+		options.Update().SetUpsert(true),
 	)
 	return errors.Wrap(err, "write into db")
 }
 
+// agentStatusUpdate returns the update of the fields the agent's heartbeat
+// owns. The empty optional ones are unset, so the heartbeat clears them.
+// The maintenance flag, the suitability, the self-check and the dbpath
+// list are written separately (see SetAgentMaintenance, SetAgentSuitability,
+// SetAgentSelfCheck and SetAgentDBpathList) and kept as they are.
+func agentStatusUpdate(stat AgentStat) bson.D {
+	set := bson.D{
+		{"n", stat.Node},
+		{"rs", stat.RS},
+		{"s", stat.State},
+		{"str", stat.StateStr},
+		{"hdn", stat.Hidden},
+		{"psv", stat.Passive},
+		{"v", stat.Ver},
+		{"pbms", stat.PBMStatus},
+		{"nodes", stat.NodeStatus},
+		{"stors", stat.StorageStatus},
+		{"hb", stat.Heartbeat},
+		{"e", stat.Err},
+		{"lag", stat.ReplLag},
+	}
+	unset := bson.D{}
+	opt := func(key string, val interface{}, empty bool) {
+		if empty {
+			unset = append(unset, bson.E{key, 1})
+		} else {
+			set = append(set, bson.E{key, val})
+		}
+	}
+	opt("host", stat.Host, stat.Host == "")
+	opt("pid", stat.PID, stat.PID == 0)
+	opt("mv", stat.MongoVer, stat.MongoVer == "")
+	opt("caps", stat.Caps, stat.Caps == nil)
+	opt("upl", stat.Upload, stat.Upload == nil)
+
+	upd := bson.D{{"$set", set}}
+	if len(unset) != 0 {
+		upd = append(upd, bson.E{"$unset", unset})
+	}
+	return upd
+}
+
+// SetAgentMaintenance puts the node in maintenance for the given duration.
+// A non-positive duration turns the maintenance off.
+func (p *PBM) SetAgentMaintenance(rs, node string, d time.Duration) error {
+	upd := bson.D{{"$unset", bson.M{"mnt": 1}}}
+	if d > 0 {
+		ct, err := p.ClusterTime()
+		if err != nil {
+			return errors.Wrap(err, "get cluster time")
+		}
+		ct.T += uint32(d.Round(time.Second) / time.Second)
+		upd = bson.D{{"$set", bson.M{"mnt": AgentMaintenance{Until: ct}}}}
+	}
+
+	res, err := p.Conn.Database(DB).Collection(AgentsStatusCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"n", node}, {"rs", rs}},
+		upd,
+	)
+	if err != nil {
+		return errors.Wrap(err, "write into db")
+	}
+	if res.MatchedCount == 0 {
+		return errors.Errorf("no agent found for %s/%s", rs, node)
+	}
+
+	return nil
+}
+
 func (p *PBM) RmAgentStatus(stat AgentStat) error {
 	_, err := p.Conn.Database(DB).Collection(AgentsStatusCollection).DeleteOne(
 		p.ctx,
@@ -74,6 +238,194 @@ func (p *PBM) RmAgentStatus(stat AgentStat) error {
 	return err
 }
 
+// SetAgentSuitability records the latest suitability decision for the node
+func (p *PBM) SetAgentSuitability(rs, node string, s NodeSuitability) error {
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+	s.TS = ct
+
+	_, err = p.Conn.Database(DB).Collection(AgentsStatusCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"n", node}, {"rs", rs}},
+		bson.D{{"$set", bson.M{"suit": s}}},
+	)
+	return errors.Wrap(err, "write into db")
+}
+
+// CheckRestoreMaintenance returns an error if any node required
+// for the restore is in maintenance (see checkRestoreMaintenance)
+func (p *PBM) CheckRestoreMaintenance(physical bool) error {
+	agents, err := p.AgentsStatus()
+	if err != nil {
+		return errors.Wrap(err, "get agents list")
+	}
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+
+	return checkRestoreMaintenance(agents, ct, physical)
+}
+
+// checkRestoreMaintenance returns an error listing nodes in maintenance
+// that the restore needs. Physical restore requires all nodes, the
+// logical one is done by primaries.
+func checkRestoreMaintenance(agents []AgentStat, ct primitive.Timestamp, physical bool) error {
+	var nodes []string
+	for _, a := range agents {
+		if !a.InMaintenance(ct) {
+			continue
+		}
+		if physical || a.State == NodeStatePrimary {
+			nodes = append(nodes, a.RS+"/"+a.Node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	return errors.Errorf("nodes required for the restore are in maintenance: %s. "+
+		"Turn it off with `pbm agent maintenance off` or wait until it expires", strings.Join(nodes, ", "))
+}
+
+// CheckRestoreCaps checks the nodes are capable of restoring the backup
+// (see checkRestoreCaps)
+func (p *PBM) CheckRestoreCaps(bcp *BackupMeta, rsMap map[string]string) (warns []string, err error) {
+	agents, err := p.AgentsStatus()
+	if err != nil {
+		return nil, errors.Wrap(err, "get agents list")
+	}
+	chain, err := p.BackupChain(bcp)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backup chain")
+	}
+
+	return checkRestoreCaps(chain, agents, rsMap)
+}
+
+// checkRestoreCaps checks the capabilities reported by the agents against
+// the physical or incremental backup. A missing or unusable mongod binary
+// and its version not matching the backup's one are errors. Not enough
+// free space on the dbpath and agents not reporting capabilities (older
+// ones) are warnings. So are failed self-checks of the agents (see
+// SelfCheck). Logical backups need nothing of it. `chain` is the backup
+// and its sources (see BackupChain).
+func checkRestoreCaps(chain []*BackupMeta, agents []AgentStat, rsMap map[string]string) (warns []string, err error) {
+	bcp := chain[0]
+	if bcp.Type != PhysicalBackup && bcp.Type != IncrementalBackup {
+		return nil, nil
+	}
+
+	mapRevRS := MakeReverseRSMapFunc(rsMap)
+	var errs []string
+	for _, a := range agents {
+		rs := bcp.RS(mapRevRS(a.RS))
+		if rs == nil {
+			continue
+		}
+		node := a.RS + "/" + a.Node
+		for _, c := range a.SelfCheck.Failed() {
+			errs = append(errs, fmt.Sprintf("%s: self-check %s: %s", node, c.Name, c.Msg))
+		}
+		if a.Caps == nil {
+			warns = append(warns, node+": capabilities aren't reported, the agent may be outdated")
+			continue
+		}
+
+		if a.Caps.MongodBinErr != "" {
+			errs = append(errs, fmt.Sprintf("%s: mongod binary: %s", node, a.Caps.MongodBinErr))
+		} else {
+			need := bcp.MongoVersion
+			if rs.MongoVersion != "" {
+				need = rs.MongoVersion
+			}
+			// older backups may have no version recorded
+			if need != "" && a.Caps.MongodBinVer != "" && versionPrefix(a.Caps.MongodBinVer, 2) != versionPrefix(need, 2) {
+				errs = append(errs, fmt.Sprintf("%s: mongod binary %s (v%s) doesn't match the backup's Mongo v%s",
+					node, a.Caps.MongodBin, a.Caps.MongodBinVer, need))
+			}
+		}
+
+		if a.Caps.DBpathFree >= 0 {
+			if size := rsDataSize(chain, rs.Name); a.Caps.DBpathFree < size {
+				warns = append(warns, fmt.Sprintf("%s: %d bytes free on the dbpath, the backup data is %d bytes",
+					node, a.Caps.DBpathFree, size))
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return warns, errors.New(strings.Join(errs, "; "))
+	}
+	return warns, nil
+}
+
+// rsDataSize returns the size of the replset's data files once restored
+// from the backup chain (see BackupFiles)
+func rsDataSize(chain []*BackupMeta, rs string) int64 {
+	var rv int64
+	for _, f := range BackupFiles(rsChainFiles(chain, rs)) {
+		rv += f.Size
+	}
+	return rv
+}
+
+// CheckMongoVersions returns an error if the agents' mongod versions
+// differ beyond the tolerance (see checkMongoVersions)
+func (p *PBM) CheckMongoVersions(tolerance VersionTolerance) error {
+	agents, err := p.AgentsStatus()
+	if err != nil {
+		return errors.Wrap(err, "get agents list")
+	}
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+
+	return checkMongoVersions(agents, ct, tolerance)
+}
+
+// checkMongoVersions returns an error listing the nodes' mongod versions if
+// they differ beyond the tolerance. Agents which don't report the version
+// (older ones) and the ones with the heartbeat stale at the cluster time
+// `ct` are skipped.
+func checkMongoVersions(agents []AgentStat, ct primitive.Timestamp, tolerance VersionTolerance) error {
+	parts := 2
+	if tolerance == VersionToleranceMinor {
+		parts = 1
+	}
+
+	vers := make(map[string][]string)
+	for _, a := range agents {
+		if a.MongoVer == "" || a.IsStale(ct) {
+			continue
+		}
+		v := versionPrefix(a.MongoVer, parts)
+		vers[v] = append(vers[v], fmt.Sprintf("%s/%s: %s", a.RS, a.Node, a.MongoVer))
+	}
+	if len(vers) < 2 {
+		return nil
+	}
+
+	var nodes []string
+	for _, n := range vers {
+		nodes = append(nodes, n...)
+	}
+	sort.Strings(nodes)
+	return errors.Errorf("nodes run inconsistent mongod versions: %s", strings.Join(nodes, ", "))
+}
+
+// versionPrefix returns the first `parts` parts of the dot-separated version
+func versionPrefix(v string, parts int) string {
+	p := strings.SplitN(v, ".", parts+1)
+	if len(p) > parts {
+		p = p[:parts]
+	}
+	return strings.Join(p, ".")
+}
+
 // GetAgentStatus returns agent status by given node and rs
 // it's up to user how to handle ErrNoDocuments
 func (p *PBM) GetAgentStatus(rs, node string) (s AgentStat, err error) {
@@ -89,12 +441,9 @@ func (p *PBM) GetAgentStatus(rs, node string) (s AgentStat, err error) {
 	return s, errors.Wrap(err, "decode")
 }
 
-// AgentStatusGC cleans up stale agent statuses
-func (p *PBM) AgentStatusGC() error {
-	ct, err := p.ClusterTime()
-	if err != nil {
-		return errors.Wrap(err, "get cluster time")
-	}
+// agentStaleSec returns the number of seconds after which
+// the agent's heartbeat is considered stale
+func agentStaleSec() uint32 {
 	// 30 secs is the connection time out for mongo. So if there are some connection issues the agent checker
 	// may stuck for 30 sec on ping (trying to connect), it's HB became stale and it would be collected.
 	// Which would lead to the false clamin "not found" in the status output. So stale range should at least 30 sec
@@ -103,7 +452,23 @@ func (p *PBM) AgentStatusGC() error {
 	if stalesec < 35 {
 		stalesec = 35
 	}
-	ct.T -= uint32(stalesec)
+
+	return uint32(stalesec)
+}
+
+// IsStale returns true if the agent's heartbeat is too old
+// with respect to the given cluster time
+func (s *AgentStat) IsStale(ct primitive.Timestamp) bool {
+	return s.Heartbeat.T+agentStaleSec() < ct.T
+}
+
+// AgentStatusGC cleans up stale agent statuses
+func (p *PBM) AgentStatusGC() error {
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+	ct.T -= agentStaleSec()
 	_, err = p.Conn.Database(DB).Collection(AgentsStatusCollection).DeleteMany(
 		p.ctx,
 		bson.M{"hb": bson.M{"$lt": ct}},
@@ -0,0 +1,202 @@
+package pbm
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckRestoreMaintenance(t *testing.T) {
+	ct := primitive.Timestamp{T: 1000}
+	mnt := &AgentMaintenance{Until: primitive.Timestamp{T: 2000}}
+	agents := []AgentStat{
+		{RS: "rs1", Node: "h1:27017", State: NodeStatePrimary},
+		{RS: "rs1", Node: "h2:27017", State: NodeStateSecondary, Maintenance: mnt},
+		{RS: "rs2", Node: "h3:27017", State: NodeStatePrimary,
+			Maintenance: &AgentMaintenance{Until: primitive.Timestamp{T: 999}}},
+	}
+
+	if err := checkRestoreMaintenance(agents, ct, false); err != nil {
+		t.Errorf("logical: unexpected error %v", err)
+	}
+	err := checkRestoreMaintenance(agents, ct, true)
+	if err == nil || !strings.Contains(err.Error(), "rs1/h2:27017") || strings.Contains(err.Error(), "h3") {
+		t.Errorf("physical: unexpected error %v", err)
+	}
+
+	agents[0].Maintenance = mnt
+	if err := checkRestoreMaintenance(agents, ct, false); err == nil {
+		t.Error("logical: expected error for the primary in maintenance")
+	}
+}
+
+func TestCheckMongoVersions(t *testing.T) {
+	ct := primitive.Timestamp{T: 1675000100}
+	hb := primitive.Timestamp{T: 1675000095}
+	agents := []AgentStat{
+		{RS: "rs1", Node: "h1:27017", MongoVer: "6.0.5-4", Heartbeat: hb},
+		{RS: "rs1", Node: "h2:27017", MongoVer: "6.0.6-5", Heartbeat: hb},
+		{RS: "rs2", Node: "h3:27017", Heartbeat: hb},
+		// a gone agent still running the old version
+		{RS: "rs2", Node: "h4:27017", MongoVer: "5.0.14", Heartbeat: primitive.Timestamp{T: 1675000000}},
+	}
+
+	if err := checkMongoVersions(agents, ct, VersionTolerancePatch); err != nil {
+		t.Errorf("patch difference: unexpected error %v", err)
+	}
+
+	agents[2].MongoVer = "6.1.0"
+	err := checkMongoVersions(agents, ct, "")
+	if err == nil || !strings.Contains(err.Error(), "rs2/h3:27017: 6.1.0") {
+		t.Errorf("minor difference: unexpected error %v", err)
+	}
+	if err := checkMongoVersions(agents, ct, VersionToleranceMinor); err != nil {
+		t.Errorf("minor difference with minor tolerance: unexpected error %v", err)
+	}
+
+	agents[2].MongoVer = "7.0.1"
+	if err := checkMongoVersions(agents, ct, VersionToleranceMinor); err == nil {
+		t.Error("major difference: expected error")
+	}
+}
+
+func TestCheckRestoreCaps(t *testing.T) {
+	bcp := &BackupMeta{
+		Type:         PhysicalBackup,
+		MongoVersion: "6.0.5",
+		Replsets: []BackupReplset{
+			{Name: "rs0", Files: []File{
+				{Name: "collection-1.wt", Size: 100},
+				{Name: "collection-1.wt", Off: 10, Len: 10, Size: 100},
+				{Name: "index-1.wt", Size: 50},
+			}},
+		},
+	}
+	caps := &AgentCaps{MongodBin: "/usr/bin/mongod", MongodBinVer: "6.0.8", DBpathFree: 1000}
+	agents := []AgentStat{
+		{RS: "rs1", Node: "h1:27017", Caps: caps},
+		{RS: "rs2", Node: "h2:27017"},
+	}
+	rsMap := map[string]string{"rs0": "rs1"}
+
+	warns, err := checkRestoreCaps([]*BackupMeta{bcp}, agents, rsMap)
+	if err != nil || len(warns) != 0 {
+		t.Errorf("unexpected result: %v, %v", warns, err)
+	}
+
+	agents = append(agents, AgentStat{RS: "rs1", Node: "h3:27017",
+		Caps: &AgentCaps{MongodBin: "/usr/bin/mongod", MongodBinVer: "5.0.1", DBpathFree: 120}})
+	agents = append(agents, AgentStat{RS: "rs1", Node: "h4:27017",
+		Caps: &AgentCaps{MongodBin: "mongod", MongodBinErr: "not found", DBpathFree: -1}})
+	agents = append(agents, AgentStat{RS: "rs1", Node: "h5:27017"})
+	warns, err = checkRestoreCaps([]*BackupMeta{bcp}, agents, rsMap)
+	if err == nil || !strings.Contains(err.Error(), "h3:27017") || !strings.Contains(err.Error(), "h4:27017") {
+		t.Errorf("expected errors for h3 and h4, got %v", err)
+	}
+	if len(warns) != 2 || !strings.Contains(warns[0], "h3:27017") || !strings.Contains(warns[1], "h5:27017") {
+		t.Errorf("expected warnings for h3 and h5, got %v", warns)
+	}
+
+	agents = []AgentStat{{RS: "rs1", Node: "h6:27017", Caps: caps,
+		SelfCheck: &SelfCheck{Items: []SelfCheckItem{{Name: "dbpath", Msg: "permission denied"}}}}}
+	_, err = checkRestoreCaps([]*BackupMeta{bcp}, agents, rsMap)
+	if err == nil || !strings.Contains(err.Error(), "h6:27017: self-check dbpath: permission denied") {
+		t.Errorf("expected the self-check error for h6, got %v", err)
+	}
+
+	// the size of the incremental backup is of the whole chain
+	inc := &BackupMeta{
+		Type:      IncrementalBackup,
+		SrcBackup: "base",
+		Replsets: []BackupReplset{
+			{Name: "rs0", Files: []File{
+				{Name: "collection-1.wt", Off: 0, Len: 10, Size: 100},
+				{Name: "index-1.wt", Off: -1, Len: -1, Size: 50},
+			}},
+		},
+	}
+	agents = []AgentStat{{RS: "rs1", Node: "h7:27017", Caps: &AgentCaps{MongodBinVer: "6.0.8", DBpathFree: 120}}}
+	warns, err = checkRestoreCaps([]*BackupMeta{inc, bcp}, agents, rsMap)
+	if err != nil || len(warns) != 1 || !strings.Contains(warns[0], "the backup data is 150 bytes") {
+		t.Errorf("expected the free space warning for h7, got %v, %v", warns, err)
+	}
+	// no version to check against
+	bcp.MongoVersion = ""
+	agents[0].Caps.DBpathFree = 1000
+	if warns, err := checkRestoreCaps([]*BackupMeta{bcp}, agents, rsMap); err != nil || len(warns) != 0 {
+		t.Errorf("no version: unexpected result: %v, %v", warns, err)
+	}
+
+	bcp.Type = LogicalBackup
+	if warns, err := checkRestoreCaps([]*BackupMeta{bcp}, agents, rsMap); err != nil || len(warns) != 0 {
+		t.Errorf("logical: unexpected result: %v, %v", warns, err)
+	}
+}
+
+// updateKeys returns the keys of the update operator's fields
+func updateKeys(upd bson.D, op string) map[string]interface{} {
+	keys := make(map[string]interface{})
+	for _, e := range upd {
+		if e.Key != op {
+			continue
+		}
+		for _, f := range e.Value.(bson.D) {
+			keys[f.Key] = f.Value
+		}
+	}
+	return keys
+}
+
+func TestAgentStatusUpdate(t *testing.T) {
+	upd := agentStatusUpdate(AgentStat{Node: "h1:27017", RS: "rs1", Host: "h1", Caps: &AgentCaps{DBpathFree: 1}})
+	set, unset := updateKeys(upd, "$set"), updateKeys(upd, "$unset")
+
+	for _, k := range []string{"n", "rs", "s", "hb", "e", "host", "caps"} {
+		if _, ok := set[k]; !ok {
+			t.Errorf("%s isn't set", k)
+		}
+	}
+	for _, k := range []string{"pid", "mv", "upl"} {
+		if _, ok := unset[k]; !ok {
+			t.Errorf("empty %s isn't unset", k)
+		}
+	}
+	// written separately, the heartbeat keeps them
+	for _, k := range []string{"mnt", "suit", "chk"} {
+		if _, ok := set[k]; ok {
+			t.Errorf("%s is set", k)
+		}
+		if _, ok := unset[k]; ok {
+			t.Errorf("%s is unset", k)
+		}
+	}
+}
+
+// The caught up node's lag replaces the stored one, so the node isn't
+// down-ranked for backups anymore
+func TestAgentStatusUpdateLag(t *testing.T) {
+	doc := bson.M{}
+	for _, lag := range []int{30, 0} {
+		upd := agentStatusUpdate(AgentStat{Node: "h1:27017", RS: "rs1", ReplLag: lag})
+		for k := range updateKeys(upd, "$unset") {
+			delete(doc, k)
+		}
+		for k, v := range updateKeys(upd, "$set") {
+			doc[k] = v
+		}
+	}
+
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stat AgentStat
+	if err := bson.Unmarshal(b, &stat); err != nil {
+		t.Fatal(err)
+	}
+	if stat.ReplLag != 0 {
+		t.Errorf("expected the lag to be cleared, got %d", stat.ReplLag)
+	}
+}
@@ -39,6 +39,8 @@ var terminatorBytes = []byte{0xFF, 0xFF, 0xFF, 0xFF}
 type (
 	NSFilterFn  func(ns string) bool
 	DocFilterFn func(ns string, d bson.Raw) bool
+	// NSMetaFn may alter the namespace metadata before it goes to the archive
+	NSMetaFn func(ns *Namespace) error
 )
 
 func DefaultNSFilter(string) bool { return true }
@@ -81,7 +83,7 @@ func Decompose(r io.Reader, newWriter NewWriter, nsFilter NSFilterFn, docFilter
 	return errors.WithMessage(err, "metadata")
 }
 
-func Compose(w io.Writer, nsFilter NSFilterFn, newReader NewReader) error {
+func Compose(w io.Writer, nsFilter NSFilterFn, metaFn NSMetaFn, newReader NewReader) error {
 	meta, err := readMetadata(newReader)
 	if err != nil {
 		return errors.WithMessage(err, "metadata")
@@ -89,9 +91,15 @@ func Compose(w io.Writer, nsFilter NSFilterFn, newReader NewReader) error {
 
 	nss := make([]*Namespace, 0, len(meta.Namespaces))
 	for _, ns := range meta.Namespaces {
-		if nsFilter(NSify(ns.Database, ns.Collection)) {
-			nss = append(nss, ns)
+		if !nsFilter(NSify(ns.Database, ns.Collection)) {
+			continue
+		}
+		if metaFn != nil {
+			if err := metaFn(ns); err != nil {
+				return errors.WithMessagef(err, "namespace %s metadata", NSify(ns.Database, ns.Collection))
+			}
 		}
+		nss = append(nss, ns)
 	}
 
 	meta.Namespaces = nss
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"time"
 
@@ -15,7 +16,9 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/tracing"
 	"github.com/percona/percona-backup-mongodb/version"
 )
 
@@ -32,6 +35,8 @@ type Backup struct {
 	node     *pbm.Node
 	typ      pbm.BackupType
 	incrBase bool
+	// meta persists the backup metadata changes made during the Run
+	meta *metaWriter
 }
 
 func New(cn *pbm.PBM, node *pbm.Node) *Backup {
@@ -82,6 +87,11 @@ func (b *Backup) Init(bcp *pbm.BackupCmd, opid pbm.OPID, inf *pbm.NodeInfo, bala
 		Hb:             ts,
 	}
 
+	meta.NodesOverride, err = pbm.ParseBcpNodes(bcp.Nodes)
+	if err != nil {
+		return errors.WithMessage(err, "parse nodes")
+	}
+
 	cfg, err := b.cn.GetConfig()
 	if err == pbm.ErrStorageUndefined {
 		return errors.New("backups cannot be saved because PBM storage configuration hasn't been set yet")
@@ -89,6 +99,9 @@ func (b *Backup) Init(bcp *pbm.BackupCmd, opid pbm.OPID, inf *pbm.NodeInfo, bala
 		return errors.Wrap(err, "unable to get PBM config settings")
 	}
 	meta.Store = cfg.Storage
+	if b.typ == pbm.PhysicalBackup || b.typ == pbm.IncrementalBackup {
+		meta.Layout = cfg.Storage.Layout()
+	}
 
 	ver, err := b.node.GetMongoVersion()
 	if err != nil {
@@ -142,8 +155,35 @@ func (b *Backup) Run(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, l *
 	if v := inf.IsConfigSrv(); v {
 		rsMeta.IsConfigSvr = &v
 	}
+	if lag, err := b.node.ReplicationLag(); err != nil {
+		l.Warning("get replication lag: %v", err)
+	} else {
+		rsMeta.ReplLagSec = lag
+	}
+
+	cfg, err := b.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	pbm.ApplyRetryConf(cfg)
+	b.meta = newMetaWriter(time.Duration(cfg.Backup.MetaRetryWindowSec)*time.Second, l)
+
+	// all nodes put their spans into the trace of the operation
+	tracer := tracing.New(cfg.Tracing, "pbm-agent", map[string]string{
+		"host.name":   inf.Me,
+		"pbm.replset": inf.SetName,
+	})
+	span := tracer.Start("backup", tracing.SpanContext{TraceID: tracing.TraceIDFrom(opid[:])})
+	span.SetAttr("pbm.backup", bcp.Name)
+	span.SetAttr("pbm.backup.type", b.typ)
+	defer func() {
+		span.End(err)
+		if err := tracer.Flush(context.Background()); err != nil {
+			l.Warning("tracing: export spans: %v", err)
+		}
+	}()
 
-	stg, err := b.cn.GetStorage(l)
+	stg, err := pbm.UploadStorage(cfg, l, storage.OpBackup)
 	if err != nil {
 		return errors.Wrap(err, "unable to get PBM storage configuration settings")
 	}
@@ -161,11 +201,14 @@ func (b *Backup) Run(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, l *
 				status = pbm.StatusCancelled
 			}
 
-			ferr := b.cn.ChangeRSState(bcp.Name, rsMeta.Name, status, err.Error())
+			ferr := b.changeRSState(bcp.Name, rsMeta.Name, status, err.Error())
 			l.Info("mark RS as %s `%v`: %v", status, err, ferr)
 
 			if inf.IsLeader() {
-				ferr := b.cn.ChangeBackupState(bcp.Name, status, err.Error())
+				ts := time.Now().UTC().Unix()
+				ferr := b.meta.write("backup state", func() error {
+					return b.cn.ChangeBackupStateAt(bcp.Name, status, err.Error(), ts)
+				})
 				l.Info("mark backup as %s `%v`: %v", status, err, ferr)
 			}
 		}
@@ -216,9 +259,23 @@ func (b *Backup) Run(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, l *
 			if err != nil {
 				return errors.Wrap(err, "set balancer OFF")
 			}
+		}
+		if inf.IsSharded() {
 			l.Debug("waiting for balancer off")
-			bs := waitForBalancerOff(b.cn, time.Second*30, l)
-			l.Debug("balancer status: %s", bs)
+			st, err := verifyBalancerStopped(b.cn.GetBalancerStatus,
+				cfg.Backup.BalancerStopCheck, cfg.Backup.BalancerStopTimeout(), l)
+			if st != nil {
+				l.Debug("balancer status: %s, in round: %v", st.Mode, st.InRound)
+				werr := b.meta.write("balancer stop state", func() error {
+					return b.cn.SetBalancerStopState(bcp.Name, st)
+				})
+				if werr != nil {
+					return errors.Wrap(werr, "set balancer stop state")
+				}
+			}
+			if err != nil {
+				return errors.Wrap(err, "verify balancer stopped")
+			}
 		}
 	}
 
@@ -239,19 +296,74 @@ func (b *Backup) Run(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, l *
 		}
 	}()
 
+	ver, err := b.node.GetMongoVersion()
+	if err != nil {
+		return errors.Wrap(err, "get mongo version")
+	}
+	rsMeta.MongoVersion = ver.VersionString
+
+	if b.typ == pbm.PhysicalBackup || b.typ == pbm.IncrementalBackup {
+		rsMeta.Platform, err = b.node.GetPlatform()
+		if err != nil {
+			return errors.Wrap(err, "get platform")
+		}
+	}
+
+	if b.typ == pbm.IncrementalBackup && !b.incrBase {
+		rst, err := b.cn.GetIncrReset()
+		if err != nil {
+			return errors.Wrap(err, "check incremental backup history reset")
+		}
+		if rst != nil {
+			l.Info("incremental backup history was reset by the physical restore %s "+
+				"of backup %s. Making a base backup instead", rst.Restore, rst.Backup)
+			b.incrBase = true
+		}
+	}
+
+	var nsMatch func(string) bool
+	if sel.IsSelective(bcp.Namespaces) {
+		nsMatch = sel.MakeSelectedPred(bcp.Namespaces)
+	}
+	// the stats are taken along with the upload so a lot of namespaces
+	// don't delay the backup start
+	sctx, scancel := context.WithTimeout(ctx, pbm.NSStatsTimeout)
+	defer scancel()
+	nsStatsC := make(chan *pbm.NSStats, 1)
+	go func() {
+		defer close(nsStatsC)
+		stats, err := pbm.GetNSStats(sctx, b.node.Session(), nsMatch)
+		if err != nil {
+			l.Warning("get namespaces stats: %v", err)
+			return
+		}
+		nsStatsC <- pbm.SummarizeNSStats(stats, pbm.MaxNSStats)
+	}()
+
+	dspan := span.Child("upload")
 	switch b.typ {
 	case pbm.LogicalBackup:
 		err = b.doLogical(ctx, bcp, opid, &rsMeta, inf, stg, l)
 	case pbm.PhysicalBackup, pbm.IncrementalBackup:
-		err = b.doPhysical(ctx, bcp, opid, &rsMeta, inf, stg, l)
+		err = b.doPhysical(ctx, bcp, opid, &rsMeta, inf, stg, bcpm.Layout, l)
 	default:
 		return errors.New("undefined backup type")
 	}
+	dspan.End(err)
 	if err != nil {
 		return err
 	}
 
-	err = b.cn.ChangeRSState(bcp.Name, rsMeta.Name, pbm.StatusDone, "")
+	if nss := <-nsStatsC; nss != nil {
+		err = b.meta.write("namespaces stats", func() error {
+			return b.cn.SetRSNSStats(bcp.Name, rsMeta.Name, nss)
+		})
+		if err != nil {
+			l.Warning("set namespaces stats: %v", err)
+		}
+	}
+
+	err = b.changeRSState(bcp.Name, rsMeta.Name, pbm.StatusDone, "")
 	if err != nil {
 		return errors.Wrap(err, "set shard's StatusDone")
 	}
@@ -274,10 +386,51 @@ func (b *Backup) Run(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, l *
 			return errors.Wrap(err, "get backup metadata")
 		}
 
+		bcpm.ClusterConsistentTS = bcpm.ConsistentTS()
+		err = b.meta.write("cluster consistent ts", func() error {
+			return b.cn.SetClusterConsistentTS(bcp.Name, bcpm.ClusterConsistentTS)
+		})
+		if err != nil {
+			return errors.Wrap(err, "set cluster consistent ts")
+		}
+
+		if nsw := bcpm.ClusterNSWrites(); nsw != nil {
+			bcpm.NSWrites = nsw
+			err = b.meta.write("namespaces write spans", func() error {
+				return b.cn.SetNSWrites(bcp.Name, nsw)
+			})
+			if err != nil {
+				return errors.Wrap(err, "set namespaces write spans")
+			}
+		}
+
+		if lc := pbm.CheckCompressionRatio(bcpm, cfg.Backup.MinCompressionRatio); lc != nil {
+			l.Warning("compression ratio %.2f is below %.2f (%s): the data barely compresses, "+
+				"consider another compression or none", lc.Ratio, lc.MinRatio, lc.Compression)
+			bcpm.LowCompression = true
+			err = b.meta.write("low compression flag", func() error {
+				return b.cn.SetBackupLowCompression(bcp.Name)
+			})
+			if err != nil {
+				l.Warning("set low compression flag: %v", err)
+			}
+			err = pbm.Notify(ctx, cfg.Notify, pbm.NotifyBackupLowCompression, lc)
+			if err != nil {
+				l.Warning("notify low compression: %v", err)
+			}
+		}
+
 		err = writeMeta(stg, bcpm)
 		if err != nil {
 			return errors.Wrap(err, "dump metadata")
 		}
+
+		if b.typ == pbm.IncrementalBackup && b.incrBase {
+			err = b.cn.ClearIncrReset(bcpm.StartTS)
+			if err != nil {
+				l.Warning("clear incremental backup history reset mark: %v", err)
+			}
+		}
 	}
 
 	// to be sure the locks released only after the "done" status had written
@@ -285,54 +438,96 @@ func (b *Backup) Run(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, l *
 	return errors.Wrap(err, "waiting for done")
 }
 
-func waitForBalancerOff(cn *pbm.PBM, t time.Duration, l *plog.Event) pbm.BalancerMode {
-	dn := time.NewTimer(t)
+// balancerCheckInterval is how often the balancer status is polled
+var balancerCheckInterval = time.Millisecond * 500
+
+// verifyBalancerStopped waits for the balancer to be off with no round
+// (and so no chunk migration) in flight. With pbm.BalancerStopFail,
+// a round in flight fails right away. It returns the last seen state
+// if there is any, even along with an error.
+func verifyBalancerStopped(
+	get func() (*pbm.BalancerStatus, error),
+	check pbm.BalancerStopCheck,
+	timeout time.Duration,
+	l *plog.Event,
+) (*pbm.BalancerStopState, error) {
+	start := time.Now()
+	dn := time.NewTimer(timeout)
 	defer dn.Stop()
-	tk := time.NewTicker(time.Millisecond * 500)
+	tk := time.NewTicker(balancerCheckInterval)
 	defer tk.Stop()
 
-	var bs *pbm.BalancerStatus
-	var err error
-Loop:
+	var st *pbm.BalancerStopState
 	for {
-		select {
-		case <-tk.C:
-			bs, err = cn.GetBalancerStatus()
-			if err != nil {
-				l.Error("get balancer status: %v", err)
-				continue
+		bs, err := get()
+		if err != nil {
+			l.Error("get balancer status: %v", err)
+		} else {
+			st = &pbm.BalancerStopState{
+				Mode:     bs.Mode,
+				InRound:  bs.InBalancerRound,
+				Stopped:  bs.IsStopped(),
+				WaitedMs: time.Since(start).Milliseconds(),
 			}
-			if bs.Mode == pbm.BalancerModeOff {
-				return pbm.BalancerModeOff
+			if st.Stopped {
+				return st, nil
 			}
+			if check == pbm.BalancerStopFail && bs.Mode == pbm.BalancerModeOff && bs.InBalancerRound {
+				return st, errors.New("balancer round is in flight")
+			}
+		}
+
+		select {
+		case <-tk.C:
 		case <-dn.C:
-			break Loop
+			if st == nil {
+				return nil, errors.Errorf("no balancer status in %v", timeout)
+			}
+			return st, errors.Errorf("balancer isn't stopped in %v: mode %s, in round %v",
+				timeout, st.Mode, st.InRound)
 		}
 	}
-	if bs != nil {
-		return pbm.BalancerMode("")
-	}
-
-	return bs.Mode
 }
 
-const maxReplicationLagTimeSec = 21
-
-// NodeSuits checks if node can perform backup
-func NodeSuits(node *pbm.Node, inf *pbm.NodeInfo) (bool, error) {
+// NodeSuits checks if node can perform backup. The node with the replication
+// lag of maxLag (in seconds) or more doesn't. Neither does the hidden member
+// with the secondary delay.
+func NodeSuits(node *pbm.Node, inf *pbm.NodeInfo, maxLag int) (pbm.NodeSuitability, error) {
 	status, err := node.Status()
 	if err != nil {
-		return false, errors.Wrap(err, "get node status")
+		return pbm.NodeSuitability{}, errors.Wrap(err, "get node status")
 	}
 
 	replLag, err := node.ReplicationLag()
 	if err != nil {
-		return false, errors.Wrap(err, "get node replication lag")
+		return pbm.NodeSuitability{}, errors.Wrap(err, "get node replication lag")
+	}
+
+	return nodeSuitability(status, inf, replLag, maxLag), nil
+}
+
+func nodeSuitability(status *pbm.NodeStatus, inf *pbm.NodeInfo, replLag, maxLag int) pbm.NodeSuitability {
+	switch {
+	case status.Health != pbm.NodeHealthUp:
+		return pbm.NodeSuitability{Reason: pbm.UnsuitableHealth, Detail: "node is down"}
+	case status.State != pbm.NodeStatePrimary && status.State != pbm.NodeStateSecondary:
+		return pbm.NodeSuitability{
+			Reason: pbm.UnsuitableState,
+			Detail: fmt.Sprintf("node is %s, should be PRIMARY or SECONDARY", status.StateStr),
+		}
+	case inf.Hidden && inf.SecondaryDelay() > 0:
+		return pbm.NodeSuitability{
+			Reason: pbm.UnsuitableHidden,
+			Detail: fmt.Sprintf("node is a hidden member delayed by %ds", inf.SecondaryDelay()),
+		}
+	case replLag >= maxLag:
+		return pbm.NodeSuitability{
+			Reason: pbm.UnsuitableReplLag,
+			Detail: fmt.Sprintf("replication lag %ds, should be less than %ds", replLag, maxLag),
+		}
 	}
 
-	return replLag < maxReplicationLagTimeSec && status.Health == pbm.NodeHealthUp &&
-			(status.State == pbm.NodeStatePrimary || status.State == pbm.NodeStateSecondary),
-		nil
+	return pbm.NodeSuitability{}
 }
 
 // rwErr multierror for the read/compress/write-to-store operations set
@@ -523,7 +718,10 @@ func (b *Backup) converged(bcpName, opid string, shards []pbm.Shard, status pbm.
 	}
 
 	if shardsToFinish == 0 {
-		err := b.cn.ChangeBackupState(bcpName, status, "")
+		ts := time.Now().UTC().Unix()
+		err := b.meta.write("backup state", func() error {
+			return b.cn.ChangeBackupStateAt(bcpName, status, "", ts)
+		})
 		if err != nil {
 			return false, errors.Wrapf(err, "update backup meta with %s", status)
 		}
@@ -578,6 +776,42 @@ func (b *Backup) waitForStatus(bcpName string, status pbm.Status, waitFor *time.
 	}
 }
 
+// waitForBalancerStop waits for the leader to verify the balancer is
+// stopped (see verifyBalancerStopped)
+func (b *Backup) waitForBalancerStop(bcpName string) error {
+	cfg, err := b.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	tmr := time.NewTimer(pbm.WaitBackupStart + cfg.Backup.BalancerStopTimeout())
+	defer tmr.Stop()
+	tk := time.NewTicker(time.Second * 1)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-tk.C:
+			bmeta, err := b.cn.GetBackupMeta(bcpName)
+			if err != nil {
+				return errors.Wrap(err, "get backup metadata")
+			}
+			switch bmeta.Status {
+			case pbm.StatusCancelled:
+				return ErrCancelled
+			case pbm.StatusError:
+				return errors.Errorf("cluster failed: %s", bmeta.Err)
+			}
+			if st := bmeta.BalancerStop; st != nil && st.Stopped {
+				return nil
+			}
+		case <-tmr.C:
+			return errors.New("no balancer stop state, looks like the leader failed to verify it")
+		case <-b.cn.Context().Done():
+			return nil
+		}
+	}
+}
+
 func (b *Backup) waitForFirstLastWrite(bcpName string) (first, last primitive.Timestamp, err error) {
 	tk := time.NewTicker(time.Second * 1)
 	defer tk.Stop()
@@ -613,6 +847,7 @@ func writeMeta(stg storage.Storage, meta *pbm.BackupMeta) error {
 		return errors.Wrap(err, "marshal data")
 	}
 
+	stg = storage.WithOpClass(stg, storage.OpMeta)
 	err = stg.Save(meta.Name+pbm.MetadataFileSuffix, bytes.NewReader(b), -1)
 	return errors.Wrap(err, "write to store")
 }
@@ -630,7 +865,9 @@ func (b *Backup) setClusterFirstWrite(bcpName string) error {
 		}
 	}
 
-	err = b.cn.SetFirstWrite(bcpName, fw)
+	err = b.meta.write("first write ts", func() error {
+		return b.cn.SetFirstWrite(bcpName, fw)
+	})
 	return errors.Wrap(err, "set timestamp")
 }
 
@@ -647,6 +884,26 @@ func (b *Backup) setClusterLastWrite(bcpName string) error {
 		}
 	}
 
-	err = b.cn.SetLastWrite(bcpName, lw)
+	err = b.meta.write("last write ts", func() error {
+		return b.cn.SetLastWrite(bcpName, lw)
+	})
 	return errors.Wrap(err, "set timestamp")
 }
+
+// changeRSState persists the replset's state transition after all
+// previously queued metadata changes, keeping the transition time.
+func (b *Backup) changeRSState(bcpName, rsName string, s pbm.Status, msg string) error {
+	ts := time.Now().UTC().Unix()
+	return b.meta.write("replset state "+string(s), func() error {
+		return b.cn.ChangeRSStateAt(bcpName, rsName, s, msg, ts)
+	})
+}
+
+// queueRSState is like changeRSState but doesn't block
+// if the metadata can't be written right away
+func (b *Backup) queueRSState(bcpName, rsName string, s pbm.Status, msg string) error {
+	ts := time.Now().UTC().Unix()
+	return b.meta.queue("replset state "+string(s), func() error {
+		return b.cn.ChangeRSStateAt(bcpName, rsName, s, msg, ts)
+	})
+}
@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+func TestNodeSuitability(t *testing.T) {
+	up2 := pbm.NodeStatus{Health: pbm.NodeHealthUp, State: pbm.NodeStateSecondary}
+	cases := []struct {
+		status pbm.NodeStatus
+		inf    pbm.NodeInfo
+		lag    int
+		want   pbm.UnsuitableReason
+	}{
+		{up2, pbm.NodeInfo{}, 0, ""},
+		{pbm.NodeStatus{Health: pbm.NodeHealthUp, State: pbm.NodeStatePrimary}, pbm.NodeInfo{}, 20, ""},
+		{pbm.NodeStatus{Health: pbm.NodeHealthDown, State: pbm.NodeStateSecondary}, pbm.NodeInfo{}, 0, pbm.UnsuitableHealth},
+		{pbm.NodeStatus{Health: pbm.NodeHealthUp, State: pbm.NodeStateRecovering, StateStr: "RECOVERING"}, pbm.NodeInfo{}, 0, pbm.UnsuitableState},
+		{up2, pbm.NodeInfo{}, 21, pbm.UnsuitableReplLag},
+		{up2, pbm.NodeInfo{Hidden: true}, 0, ""},
+		{up2, pbm.NodeInfo{Hidden: true, SecondaryDelaySecs: 3600}, 0, pbm.UnsuitableHidden},
+		{up2, pbm.NodeInfo{Hidden: true, SecondaryDelayOld: 3600}, 0, pbm.UnsuitableHidden},
+	}
+
+	for i, c := range cases {
+		got := nodeSuitability(&c.status, &c.inf, c.lag, pbm.DefaultMaxReplLagSec)
+		if got.Reason != c.want {
+			t.Errorf("case %d: expected %q, got %q", i, c.want, got)
+		}
+		if !got.OK() && got.Detail == "" {
+			t.Errorf("case %d: no details", i)
+		}
+	}
+}
+
+// balancerStatuses returns the statuses one by one, repeating the last one
+func balancerStatuses(ss ...pbm.BalancerStatus) func() (*pbm.BalancerStatus, error) {
+	return func() (*pbm.BalancerStatus, error) {
+		s := ss[0]
+		if len(ss) > 1 {
+			ss = ss[1:]
+		}
+		if s.Ok < 0 {
+			return nil, errors.New("not primary")
+		}
+		return &s, nil
+	}
+}
+
+func TestVerifyBalancerStopped(t *testing.T) {
+	defer func(d time.Duration) { balancerCheckInterval = d }(balancerCheckInterval)
+	balancerCheckInterval = time.Millisecond
+
+	l := plog.New(nil, "", "").NewEvent("backup", "", "", primitive.Timestamp{})
+	on := pbm.BalancerStatus{Mode: pbm.BalancerModeOn, InBalancerRound: true}
+	inRound := pbm.BalancerStatus{Mode: pbm.BalancerModeOff, InBalancerRound: true}
+	off := pbm.BalancerStatus{Mode: pbm.BalancerModeOff}
+	failed := pbm.BalancerStatus{Ok: -1}
+
+	cases := []struct {
+		name   string
+		get    func() (*pbm.BalancerStatus, error)
+		check  pbm.BalancerStopCheck
+		err    bool
+		status *pbm.BalancerStopState
+	}{
+		{"stopped", balancerStatuses(off), "", false, &pbm.BalancerStopState{Mode: pbm.BalancerModeOff, Stopped: true}},
+		{"wait round", balancerStatuses(on, failed, inRound, off), pbm.BalancerStopWait, false,
+			&pbm.BalancerStopState{Mode: pbm.BalancerModeOff, Stopped: true}},
+		{"fail round", balancerStatuses(on, inRound, off), pbm.BalancerStopFail, true,
+			&pbm.BalancerStopState{Mode: pbm.BalancerModeOff, InRound: true}},
+		{"timeout", balancerStatuses(inRound), pbm.BalancerStopWait, true,
+			&pbm.BalancerStopState{Mode: pbm.BalancerModeOff, InRound: true}},
+		{"no status", balancerStatuses(failed), pbm.BalancerStopWait, true, nil},
+	}
+	for _, c := range cases {
+		st, err := verifyBalancerStopped(c.get, c.check, time.Millisecond*50, l)
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+		if st != nil {
+			st.WaitedMs = 0
+		}
+		if (st == nil) != (c.status == nil) || st != nil && *st != *c.status {
+			t.Errorf("%s: got state %+v, want %+v", c.name, st, c.status)
+		}
+	}
+}
@@ -45,6 +45,9 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 	}
 
 	oplog := oplog.NewOplogBackup(b.node.Session())
+	if sel.IsSelective(bcp.Namespaces) && !inf.IsConfigSrv() {
+		oplog.TrackNSWrites(sel.MakeSelectedPred(bcp.Namespaces))
+	}
 	oplogTS, err := oplog.LastWrite()
 	if err != nil {
 		return errors.Wrap(err, "define oplog start position")
@@ -54,7 +57,9 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 	rsMeta.FirstWriteTS = oplogTS
 	rsMeta.OplogName = path.Join(bcp.Name, rsMeta.Name, "local.oplog.rs.bson") + bcp.Compression.Suffix()
 	rsMeta.DumpName = path.Join(bcp.Name, rsMeta.Name, archive.MetaFile)
-	err = b.cn.AddRSMeta(bcp.Name, *rsMeta)
+	err = b.meta.write("replset meta", func() error {
+		return b.cn.AddRSMeta(bcp.Name, *rsMeta)
+	})
 	if err != nil {
 		return errors.Wrap(err, "add shard's metadata")
 	}
@@ -134,9 +139,9 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 		docFilter = makeConfigsvrDocFilter(bcp.Namespaces, chunkSelector)
 	}
 
-	snapshotSize, err := snapshot.UploadDump(dump,
+	snapshotSize, snapshotSizeRaw, err := snapshot.UploadDump(dump,
 		func(ns, ext string, r io.Reader) error {
-			stg, err := pbm.Storage(cfg, l)
+			stg, err := pbm.UploadStorage(cfg, l, storage.OpBackup)
 			if err != nil {
 				return errors.WithMessage(err, "get storage")
 			}
@@ -155,7 +160,7 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 	}
 	l.Info("mongodump finished, waiting for the oplog")
 
-	err = b.cn.ChangeRSState(bcp.Name, rsMeta.Name, pbm.StatusDumpDone, "")
+	err = b.queueRSState(bcp.Name, rsMeta.Name, pbm.StatusDumpDone, "")
 	if err != nil {
 		return errors.Wrap(err, "set shard's StatusDumpDone")
 	}
@@ -165,7 +170,9 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 		return errors.Wrap(err, "get shard's last write ts")
 	}
 
-	err = b.cn.SetRSLastWrite(bcp.Name, rsMeta.Name, lwts)
+	err = b.meta.write("replset last write ts", func() error {
+		return b.cn.SetRSLastWrite(bcp.Name, rsMeta.Name, lwts)
+	})
 	if err != nil {
 		return errors.Wrap(err, "set shard's last write ts")
 	}
@@ -195,12 +202,30 @@ func (b *Backup) doLogical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPI
 	l.Debug("set oplog span to %v / %v", fwTS, lwTS)
 	oplog.SetTailingSpan(fwTS, lwTS)
 	// size -1 - we're assuming oplog never exceed 97Gb (see comments in s3.Save method)
-	oplogSize, err := Upload(ctx, oplog, stg, bcp.Compression, bcp.CompressionLevel, rsMeta.OplogName, -1)
+	oplogSizeRaw, err := Upload(ctx, oplog, stg, bcp.Compression, bcp.CompressionLevel, rsMeta.OplogName, -1)
 	if err != nil {
 		return errors.Wrap(err, "oplog")
 	}
+	// Upload counts the bytes before the compression
+	oplogSize := oplogSizeRaw
+	if finf, err := stg.FileStat(rsMeta.OplogName); err != nil {
+		l.Warning("get oplog file stat: %v", err)
+	} else {
+		oplogSize = finf.Size
+	}
+
+	if nsw := oplog.NSWrites(); nsw != nil {
+		err = b.meta.write("namespaces write spans", func() error {
+			return b.cn.SetRSNSWrites(bcp.Name, rsMeta.Name, nsw)
+		})
+		if err != nil {
+			return errors.Wrap(err, "set namespaces write spans")
+		}
+	}
 
-	err = b.cn.IncBackupSize(ctx, bcp.Name, snapshotSize+oplogSize)
+	err = b.meta.write("backup size", func() error {
+		return b.cn.IncBackupSize(ctx, bcp.Name, rsMeta.Name, snapshotSize+oplogSize, snapshotSizeRaw+oplogSizeRaw)
+	})
 	if err != nil {
 		return errors.Wrap(err, "inc backup size")
 	}
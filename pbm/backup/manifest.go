@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// stgFilePath returns the path of the file's object on the storage
+func stgFilePath(f pbm.File, bcp, rs string, layout *pbm.BackupLayout, c compress.CompressionType) string {
+	return layout.FilePath(bcp, rs, f, c)
+}
+
+// checkManifest verifies that all files listed in the replset's meta are
+// present on the storage. Depending on the level, it also checks objects'
+// sizes and reads back the content. It returns an error listing all missing
+// or inconsistent objects.
+func checkManifest(files []pbm.File, bcp, rs string, layout *pbm.BackupLayout, stg storage.Storage,
+	c compress.CompressionType, level pbm.ManifestCheck, l *plog.Event,
+) error {
+	if level == "" {
+		level = pbm.ManifestCheckSize
+	}
+	if level == pbm.ManifestCheckNone || stg.Type() == storage.BlackHole {
+		return nil
+	}
+
+	// the replset's objects are spread all over the backup dir
+	// with the hashed layout
+	dir := path.Join(bcp, rs)
+	if layout != nil && layout.Type == pbm.LayoutHashed {
+		dir = bcp
+	}
+	list, err := stg.List(dir, "")
+	if err != nil {
+		return errors.Wrap(err, "list files on the storage")
+	}
+	onstg := make(map[string]int64, len(list))
+	for _, f := range list {
+		onstg[path.Join(dir, f.Name)] = f.Size
+	}
+
+	var bad []string
+	for _, f := range files {
+		// unchanged files of the incremental backup aren't uploaded
+		if f.Off == -1 && f.Len == -1 {
+			continue
+		}
+
+		name := stgFilePath(f, bcp, rs, layout, c)
+		sz, ok := onstg[name]
+		if !ok {
+			bad = append(bad, name+": missing")
+			continue
+		}
+		if level == pbm.ManifestCheckExist {
+			continue
+		}
+		if sz != f.StgSize {
+			bad = append(bad, fmt.Sprintf("%s: size %d, expected %d", name, sz, f.StgSize))
+			continue
+		}
+		if level != pbm.ManifestCheckChecksum {
+			continue
+		}
+
+		err := readBackFile(name, srcSize(f), f.Checksum, stg, c)
+		if err != nil {
+			bad = append(bad, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	if len(bad) != 0 {
+		return errors.Errorf("%d file(s) failed the manifest check (%s): %s",
+			len(bad), level, strings.Join(bad, "; "))
+	}
+
+	l.Debug("manifest check (%s) passed for %d files", level, len(files))
+	return nil
+}
+
+// srcSize returns the amount of data uploaded from the source file
+func srcSize(f pbm.File) int64 {
+	if f.Len == 0 {
+		return f.Size
+	}
+	if f.Off+f.Len > f.Size {
+		return f.Size - f.Off
+	}
+	return f.Len
+}
+
+// readBackFile reads and decompresses the object checking it yields
+// the expected amount of data with the expected checksum (if any)
+func readBackFile(name string, size int64, checksum string, stg storage.Storage, c compress.CompressionType) error {
+	r, err := stg.SourceReader(name)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	defer r.Close()
+
+	data, err := compress.Decompress(r, c)
+	if err != nil {
+		return errors.Wrap(err, "decompress")
+	}
+	defer data.Close()
+
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	n, err := io.Copy(h, data)
+	if err != nil {
+		return errors.Wrap(err, "read")
+	}
+	if n != size {
+		return errors.Errorf("read %d bytes, expected %d", n, size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); checksum != "" && sum != checksum {
+		return errors.Errorf("checksum %s, expected %s", sum, checksum)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestCheckManifestChecksum(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	src := filepath.Join(t.TempDir(), "collection-1.wt")
+	err := os.WriteFile(src, bytes.Repeat([]byte("data"), 1024), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := compress.CompressionTypeS2
+	f, err := WriteFile(context.Background(), pbm.File{Name: src}, "bcp/rs0/collection-1.wt", stg, c, nil, l)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	f.Name = "collection-1.wt"
+	if f.Checksum == "" {
+		t.Fatal("no checksum recorded")
+	}
+
+	err = checkManifest([]pbm.File{*f}, "bcp", "rs0", nil, stg, c, pbm.ManifestCheckChecksum, l)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bad := *f
+	bad.Checksum = "00000000"
+	err = checkManifest([]pbm.File{bad}, "bcp", "rs0", nil, stg, c, pbm.ManifestCheckChecksum, l)
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package backup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/retry"
+)
+
+const (
+	defaultMetaRetryWindow = time.Minute
+	metaRetryMinBackoff    = time.Millisecond * 500
+	metaRetryMaxBackoff    = time.Second * 10
+)
+
+type metaOp struct {
+	name string
+	fn   func() error
+}
+
+// metaWriter persists backup metadata changes. Failed writes are kept
+// in the local queue and replayed in the original order with backoff.
+// It gives up only if metadata couldn't be persisted within the window
+// since the first failure.
+//
+// The data streaming to the storage doesn't depend on PBM's db. So
+// a short unavailability of the ConfigServer primary (e.g. an election)
+// shouldn't fail the backup.
+//
+// A failed write may still have been applied (e.g. the connection broke
+// before the reply), so the ops have to be idempotent: state transitions
+// carry their time (see PBM.ChangeRSStateAt) and the size counters
+// are added once per replset (see PBM.IncBackupSize).
+type metaWriter struct {
+	mx      sync.Mutex
+	pending []metaOp
+	// the time of the first failed attempt to persist pending ops
+	failedSince time.Time
+
+	window     time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	l *plog.Event
+}
+
+func newMetaWriter(window time.Duration, l *plog.Event) *metaWriter {
+	if window <= 0 {
+		window = defaultMetaRetryWindow
+	}
+
+	return &metaWriter{
+		window:     window,
+		minBackoff: metaRetryMinBackoff,
+		maxBackoff: metaRetryMaxBackoff,
+		l:          l,
+	}
+}
+
+// write persists the change after all previously queued ones.
+// It blocks until everything is written or the retry window is exceeded.
+func (w *metaWriter) write(name string, fn func() error) error {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	w.pending = append(w.pending, metaOp{name: name, fn: fn})
+	return w.flush()
+}
+
+// queue tries to persist the change right away but doesn't block on
+// failure. The change stays in the queue and will be replayed on the
+// next write or flush. An error is returned only if the retry window
+// is already exceeded.
+func (w *metaWriter) queue(name string, fn func() error) error {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	w.pending = append(w.pending, metaOp{name: name, fn: fn})
+	err := w.tryPending()
+	if err == nil {
+		return nil
+	}
+
+	if time.Since(w.failedSince) > w.window {
+		return errors.Wrapf(err, "persist %s: retry window %v exceeded", w.pending[0].name, w.window)
+	}
+	w.l.Warning("persist %s: %v. %d change(s) are queued and will be retried",
+		w.pending[0].name, err, len(w.pending))
+	return nil
+}
+
+// Flush persists all queued changes.
+func (w *metaWriter) Flush() error {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	return w.flush()
+}
+
+func (w *metaWriter) flush() error {
+	backoff := w.minBackoff
+	for {
+		err := w.tryPending()
+		if err == nil {
+			return nil
+		}
+
+		if time.Since(w.failedSince) > w.window {
+			return errors.Wrapf(err, "persist %s: retry window %v exceeded", w.pending[0].name, w.window)
+		}
+
+		wait := retry.Jitter(backoff)
+		w.l.Warning("persist %s: %v. Retry in %v", w.pending[0].name, err, wait)
+		time.Sleep(wait)
+		backoff *= 2
+		if backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+}
+
+// tryPending makes a single attempt to write queued ops in order.
+// It stops on the first failure leaving the rest in the queue.
+func (w *metaWriter) tryPending() error {
+	for len(w.pending) > 0 {
+		err := w.pending[0].fn()
+		if err != nil {
+			if w.failedSince.IsZero() {
+				w.failedSince = time.Now()
+			}
+			return err
+		}
+
+		w.pending = w.pending[1:]
+		w.failedSince = time.Time{}
+	}
+
+	return nil
+}
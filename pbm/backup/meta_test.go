@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// failingColl imitates the backup meta collection which is unavailable
+// for the first `fails` writes
+type failingColl struct {
+	fails   int
+	calls   int
+	applied []string
+}
+
+func (c *failingColl) op(name string) func() error {
+	return func() error {
+		c.calls++
+		if c.calls <= c.fails {
+			return errors.New("not primary")
+		}
+		c.applied = append(c.applied, name)
+		return nil
+	}
+}
+
+func testMetaWriter(window time.Duration) *metaWriter {
+	w := newMetaWriter(window, plog.New(nil, "", "").NewEvent("backup", "", "", primitive.Timestamp{}))
+	w.minBackoff = time.Millisecond
+	w.maxBackoff = time.Millisecond * 5
+	return w
+}
+
+func TestMetaWriterReplayInOrder(t *testing.T) {
+	c := &failingColl{fails: 5}
+	w := testMetaWriter(time.Second * 5)
+
+	if err := w.queue("running", c.op("running")); err != nil {
+		t.Fatalf("queue running: %v", err)
+	}
+	if err := w.queue("dumpDone", c.op("dumpDone")); err != nil {
+		t.Fatalf("queue dumpDone: %v", err)
+	}
+	if len(c.applied) != 0 {
+		t.Fatalf("expected nothing applied yet, got %v", c.applied)
+	}
+
+	if err := w.write("done", c.op("done")); err != nil {
+		t.Fatalf("write done: %v", err)
+	}
+
+	want := []string{"running", "dumpDone", "done"}
+	if !reflect.DeepEqual(c.applied, want) {
+		t.Errorf("expected %v, got %v", want, c.applied)
+	}
+	if len(w.pending) != 0 {
+		t.Errorf("expected empty queue, got %d", len(w.pending))
+	}
+}
+
+func TestMetaWriterWindowExceeded(t *testing.T) {
+	c := &failingColl{fails: 1 << 30}
+	w := testMetaWriter(time.Millisecond * 50)
+
+	if err := w.queue("running", c.op("running")); err != nil {
+		t.Fatalf("queue running: %v", err)
+	}
+	if err := w.write("done", c.op("done")); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(c.applied) != 0 {
+		t.Errorf("expected nothing applied, got %v", c.applied)
+	}
+
+	// once the window is exceeded, queue fails right away
+	if err := w.queue("error", c.op("error")); err == nil {
+		t.Error("queue: expected error")
+	}
+}
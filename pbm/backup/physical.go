@@ -3,7 +3,11 @@ package backup
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
 	"os"
 	"path"
 	"strings"
@@ -20,6 +24,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	plog "github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/retry"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 )
 
@@ -59,23 +64,26 @@ func NewBackupCursor(n *pbm.Node, l *plog.Event, opts bson.D) *BackupCursor {
 	}
 }
 
-func (bc *BackupCursor) create(ctx context.Context, retry int) (cur *mongo.Cursor, err error) {
-	for i := 0; i < retry; i++ {
-		cur, err = bc.n.Session().Database("admin").Aggregate(ctx, mongo.Pipeline{
-			{{"$backupCursor", bc.opts}},
-		})
-		if err != nil && strings.Contains(err.Error(), "(Location50915)") {
+func (bc *BackupCursor) create(ctx context.Context, tries int) (*mongo.Cursor, error) {
+	var cur *mongo.Cursor
+	err := retry.Do(ctx, retry.Policy{Attempts: tries, Backoff: time.Second, Linear: true},
+		func() (err error) {
+			cur, err = bc.n.Session().Database("admin").Aggregate(ctx, mongo.Pipeline{
+				{{"$backupCursor", bc.opts}},
+			})
+			return err
+		},
+		retry.If(func(err error) bool {
+			return strings.Contains(err.Error(), "(Location50915)")
+		}),
+		retry.OnRetry(func(retry.Attempt) {
 			bc.l.Debug("a checkpoint took place, retrying")
-			time.Sleep(time.Second * time.Duration(i+1))
-			continue
-		} else if err != nil {
-			return nil, err
-		}
-
-		return cur, nil
+		}))
+	if err != nil {
+		return nil, err
 	}
 
-	return cur, err
+	return cur, nil
 }
 
 func (bc *BackupCursor) Data(ctx context.Context) (bcp *BackupCursorData, err error) {
@@ -158,7 +166,7 @@ func (bc *BackupCursor) Close() {
 	}
 }
 
-func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, rsMeta *pbm.BackupReplset, inf *pbm.NodeInfo, stg storage.Storage, l *plog.Event) error {
+func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OPID, rsMeta *pbm.BackupReplset, inf *pbm.NodeInfo, stg storage.Storage, layout *pbm.BackupLayout, l *plog.Event) error {
 	currOpts := bson.D{}
 	if b.typ == pbm.IncrementalBackup {
 		currOpts = bson.D{
@@ -176,7 +184,9 @@ func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OP
 
 			// ? should be done during Init()?
 			if inf.IsLeader() {
-				err := b.cn.SetSrcBackup(bcp.Name, src.Name)
+				err := b.meta.write("source backup", func() error {
+					return b.cn.SetSrcBackup(bcp.Name, src.Name)
+				})
 				if err != nil {
 					return errors.Wrap(err, "set source backup in meta")
 				}
@@ -199,6 +209,14 @@ func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OP
 			}
 		}
 	}
+	// chunk migrations mustn't run while the data is copied
+	if inf.IsSharded() {
+		err := b.waitForBalancerStop(bcp.Name)
+		if err != nil {
+			return errors.Wrap(err, "wait for balancer stop")
+		}
+	}
+
 	cursor := NewBackupCursor(b.node, l, currOpts)
 	defer cursor.Close()
 
@@ -233,7 +251,10 @@ func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OP
 	rsMeta.Status = pbm.StatusRunning
 	rsMeta.FirstWriteTS = bcur.Meta.OplogEnd.TS
 	rsMeta.LastWriteTS = lwts
-	err = b.cn.AddRSMeta(bcp.Name, *rsMeta)
+	rsMeta.CheckpointTS = bcur.Meta.CheckpointTS
+	err = b.meta.write("replset meta", func() error {
+		return b.cn.AddRSMeta(bcp.Name, *rsMeta)
+	})
 	if err != nil {
 		return errors.Wrap(err, "add shard's metadata")
 	}
@@ -286,7 +307,7 @@ func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OP
 	}
 
 	l.Info("uploading data")
-	rsMeta.Files, err = uploadFiles(ctx, data, bcp.Name+"/"+rsMeta.Name, bcur.Meta.DBpath,
+	rsMeta.Files, err = uploadFiles(ctx, data, bcp.Name, rsMeta.Name, layout, bcur.Meta.DBpath,
 		b.typ == pbm.IncrementalBackup, stg, bcp.Compression, bcp.CompressionLevel, l)
 	if err != nil {
 		return err
@@ -294,7 +315,7 @@ func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OP
 	l.Info("uploading data done")
 
 	l.Info("uploading journals")
-	ju, err := uploadFiles(ctx, jrnls, bcp.Name+"/"+rsMeta.Name, bcur.Meta.DBpath,
+	ju, err := uploadFiles(ctx, jrnls, bcp.Name, rsMeta.Name, layout, bcur.Meta.DBpath,
 		false, stg, bcp.Compression, bcp.CompressionLevel, l)
 	if err != nil {
 		return err
@@ -302,17 +323,32 @@ func (b *Backup) doPhysical(ctx context.Context, bcp *pbm.BackupCmd, opid pbm.OP
 	l.Info("uploading journals done")
 	rsMeta.Files = append(rsMeta.Files, ju...)
 
-	err = b.cn.RSSetPhyFiles(bcp.Name, rsMeta.Name, rsMeta)
+	cfg, err := b.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	err = checkManifest(rsMeta.Files, bcp.Name, rsMeta.Name, layout, stg,
+		bcp.Compression, cfg.Backup.ManifestCheck, l)
+	if err != nil {
+		return errors.Wrap(err, "check uploaded files")
+	}
+
+	err = b.meta.write("replset files", func() error {
+		return b.cn.RSSetPhyFiles(bcp.Name, rsMeta.Name, rsMeta)
+	})
 	if err != nil {
 		return errors.Wrap(err, "set shard's files list")
 	}
 
-	size := int64(0)
+	size, sizeRaw := int64(0), int64(0)
 	for _, f := range rsMeta.Files {
 		size += f.StgSize
+		sizeRaw += f.RawSize()
 	}
 
-	err = b.cn.IncBackupSize(ctx, bcp.Name, size)
+	err = b.meta.write("backup size", func() error {
+		return b.cn.IncBackupSize(ctx, bcp.Name, rsMeta.Name, size, sizeRaw)
+	})
 	if err != nil {
 		return errors.Wrap(err, "inc backup size")
 	}
@@ -375,8 +411,8 @@ func (id *UUID) IsZero() bool {
 // If this is an incremental, NOT base backup, it will skip uploading of
 // unchanged files (Len == 0) but add them to the meta as we need know
 // what files shouldn't be restored (those which isn't in the target backup).
-func uploadFiles(ctx context.Context, files []pbm.File, subdir, trimPrefix string, incr bool,
-	stg storage.Storage, comprT compress.CompressionType, comprL *int, l *plog.Event) (data []pbm.File, err error) {
+func uploadFiles(ctx context.Context, files []pbm.File, bcp, rs string, layout *pbm.BackupLayout, trimPrefix string,
+	incr bool, stg storage.Storage, comprT compress.CompressionType, comprL *int, l *plog.Event) (data []pbm.File, err error) {
 	if len(files) == 0 {
 		return data, err
 	}
@@ -416,7 +452,7 @@ func uploadFiles(ctx context.Context, files []pbm.File, subdir, trimPrefix strin
 			continue
 		}
 
-		fw, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, comprT, comprL, l)
+		fw, err := WriteFile(ctx, wfile, layout.Path(bcp, rs, trim(wfile.Name)), stg, comprT, comprL, l)
 		if err != nil {
 			return data, errors.Wrapf(err, "upload file `%s`", wfile.Name)
 		}
@@ -431,7 +467,7 @@ func uploadFiles(ctx context.Context, files []pbm.File, subdir, trimPrefix strin
 		return data, nil
 	}
 
-	f, err := writeFile(ctx, wfile, path.Join(subdir, trim(wfile.Name)), stg, comprT, comprL, l)
+	f, err := WriteFile(ctx, wfile, layout.Path(bcp, rs, trim(wfile.Name)), stg, comprT, comprL, l)
 	if err != nil {
 		return data, errors.Wrapf(err, "upload file `%s`", wfile.Name)
 	}
@@ -442,7 +478,10 @@ func uploadFiles(ctx context.Context, files []pbm.File, subdir, trimPrefix strin
 	return data, nil
 }
 
-func writeFile(ctx context.Context, src pbm.File, dst string, stg storage.Storage, compression compress.CompressionType, compressLevel *int, l *plog.Event) (*pbm.File, error) {
+// WriteFile uploads the local file (or its part if Len is set) into `dst`
+// on the storage compressing it on the fly. It doesn't need a running
+// mongod, so the upload path can be measured alone.
+func WriteFile(ctx context.Context, src pbm.File, dst string, stg storage.Storage, compression compress.CompressionType, compressLevel *int, l *plog.Event) (*pbm.File, error) {
 	fstat, err := os.Stat(src.Name)
 	if err != nil {
 		return nil, errors.Wrap(err, "get file stat")
@@ -461,7 +500,8 @@ func writeFile(ctx context.Context, src pbm.File, dst string, stg storage.Storag
 	}
 	l.Debug("uploading: %s %s", src, fmtSize(sz))
 
-	_, err = Upload(ctx, &src, stg, compression, compressLevel, dst, sz)
+	cs := &checksumSource{src: &src, h: crc32.New(crc32.MakeTable(crc32.Castagnoli))}
+	_, err = Upload(ctx, cs, stg, compression, compressLevel, dst, sz)
 	if err != nil {
 		return nil, errors.Wrap(err, "upload file")
 	}
@@ -472,15 +512,32 @@ func writeFile(ctx context.Context, src pbm.File, dst string, stg storage.Storag
 	}
 
 	return &pbm.File{
-		Name:    src.Name,
-		Size:    fstat.Size(),
-		Fmode:   fstat.Mode(),
-		StgSize: finf.Size,
-		Off:     src.Off,
-		Len:     src.Len,
+		Name:     src.Name,
+		Size:     fstat.Size(),
+		Fmode:    fstat.Mode(),
+		StgSize:  finf.Size,
+		Off:      src.Off,
+		Len:      src.Len,
+		Checksum: hex.EncodeToString(cs.h.Sum(nil)),
 	}, nil
 }
 
+// checksumSource computes the checksum of the data it writes
+type checksumSource struct {
+	src Source
+	h   hash.Hash
+}
+
+func (s *checksumSource) WriteTo(w io.Writer) (int64, error) {
+	return s.src.WriteTo(io.MultiWriter(w, s.h))
+}
+
+func (s *checksumSource) Cancel() {
+	if c, ok := s.src.(Canceller); ok {
+		c.Cancel()
+	}
+}
+
 func fmtSize(size int64) string {
 	const (
 		_          = iota
@@ -0,0 +1,88 @@
+package pbm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BackupFreeze suppresses new backups and PITR slicing cluster-wide, e.g.
+// during a maintenance window. Backups already running aren't affected.
+type BackupFreeze struct {
+	Since int64 `bson:"since" json:"since"`
+	// Until is the unix time the freeze expires at. Zero means it lasts
+	// until cleared.
+	Until int64 `bson:"until,omitempty" json:"until,omitempty"`
+}
+
+// Active tells if the freeze is in effect at the given unix time
+func (f *BackupFreeze) Active(now int64) bool {
+	return f != nil && (f.Until == 0 || f.Until > now)
+}
+
+func (f *BackupFreeze) String() string {
+	if f.Until == 0 {
+		return "until cleared"
+	}
+	return fmt.Sprintf("until %s", time.Unix(f.Until, 0).UTC().Format(time.RFC3339))
+}
+
+// SetBackupFreeze freezes backups until the given time. The zero time
+// means until ClearBackupFreeze.
+func (p *PBM) SetBackupFreeze(until time.Time) error {
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+
+	f := BackupFreeze{Since: int64(ct.T)}
+	if !until.IsZero() {
+		if until.Unix() <= f.Since {
+			return errors.Errorf("freeze expiry %s is in the past", until.UTC().Format(time.RFC3339))
+		}
+		f.Until = until.Unix()
+	}
+
+	_, err = p.Conn.Database(DB).Collection(BackupFreezeCollection).ReplaceOne(p.ctx, bson.D{}, f,
+		options.Replace().SetUpsert(true))
+	return errors.Wrap(err, "write into db")
+}
+
+// ClearBackupFreeze lifts the freeze. Agents resume PITR slicing on
+// their next check.
+func (p *PBM) ClearBackupFreeze() error {
+	_, err := p.Conn.Database(DB).Collection(BackupFreezeCollection).DeleteMany(p.ctx, bson.D{})
+	return errors.Wrap(err, "delete")
+}
+
+// GetBackupFreeze returns the freeze in effect and nil if there is none
+// or it has expired
+func (p *PBM) GetBackupFreeze() (*BackupFreeze, error) {
+	res := p.Conn.Database(DB).Collection(BackupFreezeCollection).FindOne(p.ctx, bson.D{})
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, errors.Wrap(res.Err(), "get")
+	}
+
+	f := &BackupFreeze{}
+	err := res.Decode(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+	if !f.Active(int64(ct.T)) {
+		return nil, nil
+	}
+
+	return f, nil
+}
@@ -0,0 +1,24 @@
+package pbm
+
+import "testing"
+
+func TestBackupFreezeActive(t *testing.T) {
+	cases := []struct {
+		name string
+		f    *BackupFreeze
+		now  int64
+		want bool
+	}{
+		{"none", nil, 100, false},
+		{"until cleared", &BackupFreeze{Since: 50}, 100, true},
+		{"not expired", &BackupFreeze{Since: 50, Until: 101}, 100, true},
+		{"expired", &BackupFreeze{Since: 50, Until: 100}, 100, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.f.Active(c.now); got != c.want {
+				t.Errorf("expected %v, got %v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package pbm
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BackupReapStaleSec is how old the heartbeat of the running backup may be
+// before the backup is considered orphaned (e.g. its agents died) and
+// reaped. It's well above StaleFrameSec the backup itself fails after, so
+// only backups nobody is left to fail are touched.
+const BackupReapStaleSec uint32 = 10 * StaleFrameSec
+
+// backupActiveStatuses are the non-terminal backup statuses
+var backupActiveStatuses = []Status{StatusStarting, StatusRunning, StatusDumpDone}
+
+// ReapStaleBackups marks the backups stuck in a non-terminal status with
+// no heartbeat for BackupReapStaleSec as failed. So they're excluded from
+// the restore selection and can be cleaned up. Backups whose heartbeat is
+// fresh are never touched, even if it changes while reaping. It returns
+// the reaped backups.
+func (p *PBM) ReapStaleBackups() ([]BackupMeta, error) {
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
+
+	cur, err := p.Conn.Database(DB).Collection(BcpCollection).Find(
+		p.ctx,
+		bson.M{"status": bson.M{"$in": backupActiveStatuses}},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "query mongo")
+	}
+	var bcps []BackupMeta
+	err = cur.All(p.ctx, &bcps)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	var reaped []BackupMeta
+	for _, b := range bcps {
+		if !isOrphanedBackup(&b, ct, BackupReapStaleSec) {
+			continue
+		}
+
+		// the status and heartbeat are matched so a backup that has just
+		// moved on isn't failed
+		ok, err := p.changeBackupStateIf(
+			bson.D{{"name", b.Name}, {"status", b.Status}, {"hb", b.Hb}},
+			StatusError,
+			fmt.Sprintf("orphaned: no heartbeat since %d while %s", b.Hb.T, b.Status),
+		)
+		if err != nil {
+			return reaped, errors.Wrapf(err, "mark backup %s failed", b.Name)
+		}
+		if ok {
+			reaped = append(reaped, b)
+		}
+	}
+
+	return reaped, nil
+}
+
+// isOrphanedBackup tells if the backup is in a non-terminal status and
+// neither its heartbeat nor its start is fresher than `staleSec` with
+// respect to the cluster time. The start covers backups that haven't
+// beaten yet.
+func isOrphanedBackup(b *BackupMeta, ct primitive.Timestamp, staleSec uint32) bool {
+	active := false
+	for _, s := range backupActiveStatuses {
+		if b.Status == s {
+			active = true
+			break
+		}
+	}
+	if !active {
+		return false
+	}
+
+	last := int64(b.Hb.T)
+	if b.StartTS > last {
+		last = b.StartTS
+	}
+	return last+int64(staleSec) < int64(ct.T)
+}
@@ -0,0 +1,35 @@
+package pbm
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestIsOrphanedBackup(t *testing.T) {
+	ct := primitive.Timestamp{T: 10000}
+	stale := uint32(300)
+
+	cases := []struct {
+		name string
+		b    BackupMeta
+		want bool
+	}{
+		{"stale running", BackupMeta{Status: StatusRunning, StartTS: 9000, Hb: primitive.Timestamp{T: 9600}}, true},
+		{"stale dumpDone", BackupMeta{Status: StatusDumpDone, StartTS: 9000, Hb: primitive.Timestamp{T: 9600}}, true},
+		{"fresh hb", BackupMeta{Status: StatusRunning, StartTS: 9000, Hb: primitive.Timestamp{T: 9800}}, false},
+		{"hb at threshold", BackupMeta{Status: StatusRunning, StartTS: 9000, Hb: primitive.Timestamp{T: 9700}}, false},
+		{"starting without hb", BackupMeta{Status: StatusStarting, StartTS: 9000}, true},
+		{"just started without hb", BackupMeta{Status: StatusStarting, StartTS: 9900}, false},
+		{"done", BackupMeta{Status: StatusDone, StartTS: 9000, Hb: primitive.Timestamp{T: 9000}}, false},
+		{"error", BackupMeta{Status: StatusError, StartTS: 9000, Hb: primitive.Timestamp{T: 9000}}, false},
+		{"canceled", BackupMeta{Status: StatusCancelled, StartTS: 9000}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isOrphanedBackup(&c.b, ct, stale); got != c.want {
+				t.Errorf("expected %v, got %v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package pbm
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// futureTolerance is how far (in seconds) backup times may be ahead of
+// the cluster time before they're reported. Nodes' clocks are never
+// exactly in sync.
+const futureTolerance = 60
+
+// BackupTimeAnomaly is a backup whose timestamps can't be right: they
+// go backwards or lie in the future. Restores and PITR planned on such
+// backups go wrong in subtle ways, so they shouldn't be relied upon nor
+// used as an incremental base.
+type BackupTimeAnomaly struct {
+	Backup string `json:"backup"`
+	// Replset is empty for the cluster-wide ones
+	Replset string `json:"replset,omitempty"`
+	Issue   string `json:"issue"`
+}
+
+// BackupsTimeAnomalies scans the backups metadata for temporal anomalies.
+// It's read-only and doesn't change the backups.
+func (p *PBM) BackupsTimeAnomalies() ([]BackupTimeAnomaly, error) {
+	bcps, err := p.BackupsList(0)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backups list")
+	}
+	now, err := p.ClusterTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "read cluster time")
+	}
+
+	return backupsTimeAnomalies(bcps, now), nil
+}
+
+func backupsTimeAnomalies(bcps []BackupMeta, now primitive.Timestamp) []BackupTimeAnomaly {
+	byName := make(map[string]*BackupMeta, len(bcps))
+	for i := range bcps {
+		byName[bcps[i].Name] = &bcps[i]
+	}
+
+	var rv []BackupTimeAnomaly
+	for i := range bcps {
+		b := &bcps[i]
+		for _, s := range timeAnomalies(b.StartTS, b.LastTransitionTS, b.FirstWriteTS, b.LastWriteTS, now) {
+			rv = append(rv, BackupTimeAnomaly{Backup: b.Name, Issue: s})
+		}
+		if ts := b.ClusterConsistentTS; !ts.IsZero() && !b.LastWriteTS.IsZero() &&
+			primitive.CompareTimestamp(b.LastWriteTS, ts) < 0 {
+			rv = append(rv, BackupTimeAnomaly{
+				Backup: b.Name,
+				Issue:  fmt.Sprintf("cluster consistent ts %v is after last write ts %v", ts, b.LastWriteTS),
+			})
+		}
+		if src, ok := byName[b.SrcBackup]; ok && !src.LastWriteTS.IsZero() &&
+			!b.LastWriteTS.IsZero() && primitive.CompareTimestamp(b.LastWriteTS, src.LastWriteTS) < 0 {
+			rv = append(rv, BackupTimeAnomaly{
+				Backup: b.Name,
+				Issue: fmt.Sprintf("last write ts %v is before the one %v of its incremental base %s",
+					b.LastWriteTS, src.LastWriteTS, src.Name),
+			})
+		}
+
+		for _, rs := range b.Replsets {
+			for _, s := range timeAnomalies(rs.StartTS, rs.LastTransitionTS, rs.FirstWriteTS, rs.LastWriteTS, now) {
+				rv = append(rv, BackupTimeAnomaly{Backup: b.Name, Replset: rs.Name, Issue: s})
+			}
+		}
+	}
+
+	return rv
+}
+
+// timeAnomalies checks the times of a backup or its replset. Zero values
+// are not set yet (e.g. the backup is running) and aren't checked.
+func timeAnomalies(start, transition int64, first, last, now primitive.Timestamp) []string {
+	var rv []string
+	future := int64(now.T) + futureTolerance
+
+	if start > future {
+		rv = append(rv, fmt.Sprintf("start ts %d is in the future (cluster time %d)", start, now.T))
+	}
+	if transition != 0 && start > transition {
+		rv = append(rv, fmt.Sprintf("last transition ts %d is before start ts %d", transition, start))
+	}
+	if !first.IsZero() && !last.IsZero() && primitive.CompareTimestamp(last, first) < 0 {
+		rv = append(rv, fmt.Sprintf("first write ts %v is after last write ts %v", first, last))
+	}
+	if int64(last.T) > future {
+		rv = append(rv, fmt.Sprintf("last write ts %v is in the future (cluster time %v)", last, now))
+	}
+
+	return rv
+}
@@ -0,0 +1,53 @@
+package pbm
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBackupsTimeAnomalies(t *testing.T) {
+	now := primitive.Timestamp{T: 1000}
+	bcps := []BackupMeta{
+		{
+			Name:             "ok",
+			StartTS:          100,
+			LastTransitionTS: 200,
+			FirstWriteTS:     primitive.Timestamp{T: 110},
+			LastWriteTS:      primitive.Timestamp{T: 150},
+			Replsets: []BackupReplset{{
+				Name:         "rs0",
+				StartTS:      100,
+				FirstWriteTS: primitive.Timestamp{T: 110},
+				LastWriteTS:  primitive.Timestamp{T: 150},
+			}},
+		},
+		{
+			Name:         "incr",
+			SrcBackup:    "ok",
+			StartTS:      300,
+			FirstWriteTS: primitive.Timestamp{T: 310},
+			LastWriteTS:  primitive.Timestamp{T: 140},
+			Replsets: []BackupReplset{{
+				Name:    "rs0",
+				StartTS: 5000,
+			}},
+		},
+		{
+			Name:    "running",
+			StartTS: 990,
+		},
+	}
+
+	got := backupsTimeAnomalies(bcps, now)
+	want := map[BackupTimeAnomaly]bool{}
+	for _, a := range got {
+		if a.Backup != "incr" {
+			t.Errorf("unexpected anomaly: %+v", a)
+		}
+		want[BackupTimeAnomaly{Backup: a.Backup, Replset: a.Replset}] = true
+	}
+	if len(got) != 3 || !want[BackupTimeAnomaly{Backup: "incr", Replset: "rs0"}] {
+		t.Errorf("got %+v", got)
+	}
+}
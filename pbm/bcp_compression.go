@@ -0,0 +1,52 @@
+package pbm
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+)
+
+// LowCompression describes the backup compressed worse than
+// BackupConf.MinCompressionRatio
+type LowCompression struct {
+	Backup           string                   `json:"backup"`
+	Compression      compress.CompressionType `json:"compression"`
+	Ratio            float64                  `json:"ratio"`
+	MinRatio         float64                  `json:"min_ratio"`
+	Size             int64                    `json:"size"`
+	SizeUncompressed int64                    `json:"size_uncompressed"`
+}
+
+// CheckCompressionRatio returns the LowCompression if the compression ratio
+// of the backup is below min. It's nil if min is zero, the backup isn't
+// compressed or its ratio is unknown.
+func CheckCompressionRatio(bcp *BackupMeta, min float64) *LowCompression {
+	if min <= 0 || bcp.Compression == "" || bcp.Compression == compress.CompressionTypeNone {
+		return nil
+	}
+	r := bcp.CompressionRatio()
+	if r == 0 || r >= min {
+		return nil
+	}
+
+	return &LowCompression{
+		Backup:           bcp.Name,
+		Compression:      bcp.Compression,
+		Ratio:            r,
+		MinRatio:         min,
+		Size:             bcp.Size,
+		SizeUncompressed: bcp.SizeUncompressed,
+	}
+}
+
+// SetBackupLowCompression flags the backup compressed worse than
+// BackupConf.MinCompressionRatio
+func (p *PBM) SetBackupLowCompression(name string) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"low_compression": true}}},
+	)
+
+	return err
+}
@@ -0,0 +1,61 @@
+package pbm
+
+import (
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+)
+
+func TestCheckCompressionRatio(t *testing.T) {
+	bcp := func(c compress.CompressionType, size, raw int64) *BackupMeta {
+		return &BackupMeta{Name: "b", Compression: c, Size: size, SizeUncompressed: raw}
+	}
+
+	cases := []struct {
+		name  string
+		bcp   *BackupMeta
+		min   float64
+		ratio float64
+	}{
+		{"disabled", bcp(compress.CompressionTypeS2, 100, 100), 0, 0},
+		{"no compression", bcp(compress.CompressionTypeNone, 100, 100), 1.5, 0},
+		{"unknown ratio", bcp(compress.CompressionTypeS2, 100, 0), 1.5, 0},
+		{"above min", bcp(compress.CompressionTypeS2, 100, 300), 1.5, 0},
+		{"at min", bcp(compress.CompressionTypeS2, 100, 150), 1.5, 0},
+		{"below min", bcp(compress.CompressionTypeS2, 100, 104), 1.5, 1.04},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lc := CheckCompressionRatio(c.bcp, c.min)
+			if c.ratio == 0 {
+				if lc != nil {
+					t.Errorf("expected no alert, got %+v", lc)
+				}
+				return
+			}
+			if lc == nil {
+				t.Fatal("expected an alert")
+			}
+			if lc.Ratio != c.ratio || lc.MinRatio != c.min || lc.Backup != "b" {
+				t.Errorf("unexpected alert %+v", lc)
+			}
+		})
+	}
+}
+
+func TestFileRawSize(t *testing.T) {
+	cases := []struct {
+		f    File
+		want int64
+	}{
+		{File{Size: 100}, 100},
+		{File{Size: 100, Off: 16, Len: 16}, 16},
+		{File{Size: 100, Off: 96, Len: 16}, 4},
+	}
+	for _, c := range cases {
+		if got := c.f.RawSize(); got != c.want {
+			t.Errorf("%v: got %d, want %d", c.f, got, c.want)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package pbm
 
 import (
+	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const defaultScore = 1.0
@@ -50,6 +53,10 @@ func (p *PBM) BcpNodesPriority(c map[string]float64) (*NodesPriority, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "get agents list")
 	}
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return nil, errors.Wrap(err, "get cluster time")
+	}
 
 	// if cfg.Backup.Priority doesn't set apply defaults
 	f := func(a AgentStat) float64 {
@@ -66,7 +73,7 @@ func (p *PBM) BcpNodesPriority(c map[string]float64) (*NodesPriority, error) {
 	if cfg.Backup.Priority != nil || len(cfg.Backup.Priority) > 0 {
 		f = func(a AgentStat) float64 {
 			sc, ok := cfg.Backup.Priority[a.Node]
-			if !ok || sc < 0 {
+			if !ok {
 				return defaultScore
 			}
 
@@ -74,23 +81,140 @@ func (p *PBM) BcpNodesPriority(c map[string]float64) (*NodesPriority, error) {
 		}
 	}
 
-	return bcpNodesPriority(agents, f), nil
+	return bcpNodesPriority(agents, ct, f, cfg.Backup.MaxReplLag()), nil
 }
 
-func bcpNodesPriority(agents []AgentStat, f agentScore) *NodesPriority {
+// bcpNodesPriority scores healthy agents. Nodes in maintenance
+// at the cluster time `ct`, the ones with the replication lag of
+// maxLag or more and the ones with a negative score are skipped. Nodes lagging by more than half of maxLag
+// have their score halved.
+func bcpNodesPriority(agents []AgentStat, ct primitive.Timestamp, f agentScore, maxLag int) *NodesPriority {
 	scores := NewNodesPriority()
 
 	for _, a := range agents {
 		if ok, _ := a.OK(); !ok {
 			continue
 		}
+		if a.InMaintenance(ct) {
+			continue
+		}
+		if a.ReplLag >= maxLag {
+			continue
+		}
 
-		scores.Add(a.RS, a.Node, f(a))
+		sc := f(a)
+		if sc < 0 {
+			continue
+		}
+		if a.ReplLag*2 > maxLag {
+			sc /= 2
+		}
+		scores.Add(a.RS, a.Node, sc)
 	}
 
 	return scores
 }
 
+// PrioritySuitability tells if the node isn't excluded from backups by
+// a negative priority in the backup.priority config
+func PrioritySuitability(prio map[string]float64, node string) NodeSuitability {
+	if sc, ok := prio[node]; ok && sc < 0 {
+		return NodeSuitability{
+			Reason: UnsuitablePriority,
+			Detail: fmt.Sprintf("backup.priority is %v, negative excludes the node from backups", sc),
+		}
+	}
+	return NodeSuitability{}
+}
+
+// ParseBcpNodes parses nodes explicitly set for the backup in the format
+// `rs/host:port` and returns them as a map of replset to the node.
+// Only one node per replset is allowed.
+func ParseBcpNodes(nodes []string) (map[string]string, error) {
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+
+	rv := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		rs, host, err := ParseNode(n)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := rv[rs]; ok {
+			return nil, errors.Errorf("more than one node set for replset %s", rs)
+		}
+		rv[rs] = host
+	}
+
+	return rv, nil
+}
+
+// ParseNode parses the node in the format `rs/host:port`
+func ParseNode(n string) (rs, host string, err error) {
+	rs, host, ok := strings.Cut(strings.TrimSpace(n), "/")
+	if !ok || rs == "" || host == "" || !strings.Contains(host, ":") {
+		return "", "", errors.Errorf("malformatted node %q, expected format rs/host:port", n)
+	}
+
+	return rs, host, nil
+}
+
+// CheckBcpNodes ensures that each of the given nodes (replset -> node)
+// belongs to the cluster and has a healthy agent on a node suitable
+// for the backup.
+func (p *PBM) CheckBcpNodes(nodes map[string]string) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	shards, err := p.ClusterMembers()
+	if err != nil {
+		return errors.Wrap(err, "get cluster members")
+	}
+	rss := make(map[string]struct{}, len(shards))
+	for _, s := range shards {
+		rss[s.RS] = struct{}{}
+	}
+
+	agents, err := p.AgentsStatus()
+	if err != nil {
+		return errors.Wrap(err, "get agents list")
+	}
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+
+	for rs, node := range nodes {
+		if _, ok := rss[rs]; !ok {
+			return errors.Errorf("replset %s is not a part of the cluster", rs)
+		}
+
+		var agent *AgentStat
+		for i := range agents {
+			if agents[i].RS == rs && agents[i].Node == node {
+				agent = &agents[i]
+				break
+			}
+		}
+		if agent == nil {
+			return errors.Errorf("no running agent found for %s/%s", rs, node)
+		}
+		if ok, errs := agent.OK(); !ok {
+			return errors.Errorf("agent on %s/%s is unhealthy: %s", rs, node, strings.Join(errs, ", "))
+		}
+		if agent.InMaintenance(ct) {
+			return errors.Errorf("node %s/%s is in maintenance", rs, node)
+		}
+		if agent.State != NodeStatePrimary && agent.State != NodeStateSecondary {
+			return errors.Errorf("node %s/%s is not suitable for the backup: %s", rs, node, agent.StateStr)
+		}
+	}
+
+	return nil
+}
+
 type nodeScores struct {
 	idx []float64
 	m   map[float64][]string
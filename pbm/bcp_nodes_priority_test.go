@@ -0,0 +1,98 @@
+package pbm
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseBcpNodes(t *testing.T) {
+	cases := []struct {
+		nodes []string
+		want  map[string]string
+		err   bool
+	}{
+		{nil, nil, false},
+		{[]string{"rs1/host:27017"}, map[string]string{"rs1": "host:27017"}, false},
+		{[]string{"rs1/h1:27017", "rs2/h2:27018"}, map[string]string{"rs1": "h1:27017", "rs2": "h2:27018"}, false},
+		{[]string{"rs1/h1:27017", "rs1/h2:27017"}, nil, true},
+		{[]string{"h1:27017"}, nil, true},
+		{[]string{"rs1/h1"}, nil, true},
+		{[]string{"/h1:27017"}, nil, true},
+		{[]string{"rs1/"}, nil, true},
+	}
+
+	for _, c := range cases {
+		got, err := ParseBcpNodes(c.nodes)
+		if (err != nil) != c.err {
+			t.Errorf("%v: expected error %v, got %v", c.nodes, c.err, err)
+			continue
+		}
+		if !c.err && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: expected %v, got %v", c.nodes, c.want, got)
+		}
+	}
+}
+
+func TestBcpNodesPriorityMaintenance(t *testing.T) {
+	ct := primitive.Timestamp{T: 1000}
+	ok := SubsysStatus{OK: true}
+	agents := []AgentStat{
+		{RS: "rs1", Node: "h1:27017", PBMStatus: ok, NodeStatus: ok, StorageStatus: ok},
+		{RS: "rs1", Node: "h2:27017", PBMStatus: ok, NodeStatus: ok, StorageStatus: ok,
+			Maintenance: &AgentMaintenance{Until: primitive.Timestamp{T: 2000}}},
+		{RS: "rs1", Node: "h3:27017", PBMStatus: ok, NodeStatus: ok, StorageStatus: ok,
+			Maintenance: &AgentMaintenance{Until: primitive.Timestamp{T: 500}}},
+	}
+
+	got := bcpNodesPriority(agents, ct, func(AgentStat) float64 { return defaultScore }, DefaultMaxReplLagSec).RS("rs1")
+	want := [][]string{{"h1:27017", "h3:27017"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestBcpNodesPriorityReplLag(t *testing.T) {
+	ok := SubsysStatus{OK: true}
+	agents := []AgentStat{
+		{RS: "rs1", Node: "h1:27017", PBMStatus: ok, NodeStatus: ok, StorageStatus: ok},
+		{RS: "rs1", Node: "h2:27017", PBMStatus: ok, NodeStatus: ok, StorageStatus: ok, ReplLag: 6},
+		{RS: "rs1", Node: "h3:27017", PBMStatus: ok, NodeStatus: ok, StorageStatus: ok, ReplLag: 4},
+		{RS: "rs1", Node: "h4:27017", PBMStatus: ok, NodeStatus: ok, StorageStatus: ok, ReplLag: 10},
+	}
+
+	got := bcpNodesPriority(agents, primitive.Timestamp{}, func(AgentStat) float64 { return defaultScore }, 10).RS("rs1")
+	want := [][]string{{"h1:27017", "h3:27017"}, {"h2:27017"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestBcpNodesPriorityNegative(t *testing.T) {
+	ok := SubsysStatus{OK: true}
+	agents := []AgentStat{
+		{RS: "rs1", Node: "h1:27017", PBMStatus: ok, NodeStatus: ok, StorageStatus: ok},
+		{RS: "rs1", Node: "h2:27017", PBMStatus: ok, NodeStatus: ok, StorageStatus: ok},
+	}
+	prio := map[string]float64{"h2:27017": -1}
+
+	f := func(a AgentStat) float64 {
+		if sc, ok := prio[a.Node]; ok {
+			return sc
+		}
+		return defaultScore
+	}
+	got := bcpNodesPriority(agents, primitive.Timestamp{}, f, DefaultMaxReplLagSec).RS("rs1")
+	want := [][]string{{"h1:27017"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if s := PrioritySuitability(prio, "h2:27017"); s.Reason != UnsuitablePriority {
+		t.Errorf("h2: expected %q, got %q", UnsuitablePriority, s)
+	}
+	if s := PrioritySuitability(prio, "h1:27017"); !s.OK() {
+		t.Errorf("h1: expected to suit, got %q", s)
+	}
+}
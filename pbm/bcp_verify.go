@@ -0,0 +1,94 @@
+package pbm
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// VerifyStatus is the outcome of the backup verification
+type VerifyStatus string
+
+const (
+	VerifyOK     VerifyStatus = "ok"
+	VerifyFailed VerifyStatus = "failed"
+)
+
+func IsValidVerifyStatus(s string) bool {
+	switch VerifyStatus(s) {
+	case VerifyOK, VerifyFailed:
+		return true
+	}
+
+	return false
+}
+
+// SetBackupVerified records the outcome of the backup verification
+// made at `ts` (unix time)
+func (p *PBM) SetBackupVerified(name string, s VerifyStatus, ts int64) error {
+	if !IsValidVerifyStatus(string(s)) {
+		return errors.Errorf("invalid verification status %q", s)
+	}
+
+	return p.updateBackupMeta(name,
+		func(m *BackupMeta) bool {
+			m.LastVerifiedTS = ts
+			m.LastVerifyStatus = s
+			return true
+		},
+		bson.M{"last_verified_ts": ts, "last_verify_status": s})
+}
+
+// SetBackupRestoreTested records the successful restore of the backup
+// finished at `ts` (unix time). Only the most recent restore is kept.
+func (p *PBM) SetBackupRestoreTested(name, restore string, ts int64) error {
+	return p.updateBackupMeta(name,
+		func(m *BackupMeta) bool {
+			if ts <= m.LastRestoreTestTS {
+				return false
+			}
+			m.LastRestoreTestName = restore
+			m.LastRestoreTestTS = ts
+			return true
+		},
+		bson.M{"last_restore_test": restore, "last_restore_test_ts": ts})
+}
+
+// updateBackupMeta changes the backup metadata on the storage with `fn`
+// and sets the `fields` in the db. The storage goes first as the db is
+// rebuilt from it on resync and the backup may be not in the db yet
+// (e.g. during the resync). `fn` returns false if there is nothing to change.
+func (p *PBM) updateBackupMeta(name string, fn func(*BackupMeta) bool, fields bson.M) error {
+	stg, err := p.GetStorage(nil)
+	if err != nil {
+		return errors.Wrap(err, "get storage")
+	}
+
+	rd, err := stg.SourceReader(name + MetadataFileSuffix)
+	if err != nil {
+		return errors.Wrap(err, "read metadata from storage")
+	}
+	meta := new(BackupMeta)
+	err = json.NewDecoder(rd).Decode(meta)
+	rd.Close()
+	if err != nil {
+		return errors.Wrap(err, "decode metadata")
+	}
+
+	if !fn(meta) {
+		return nil
+	}
+
+	err = writeBackupMeta(stg, meta)
+	if err != nil {
+		return errors.Wrap(err, "write metadata to storage")
+	}
+
+	_, err = p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", fields}},
+	)
+	return errors.Wrap(err, "write metadata to db")
+}
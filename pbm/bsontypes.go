@@ -43,6 +43,7 @@ type NodeInfo struct {
 	SecondaryDelaySecs           int                  `bson:"secondaryDelaySecs"`
 	ConfigSvr                    int                  `bson:"configsvr,omitempty"`
 	Me                           string               `bson:"me"`
+	Tags                         map[string]string    `bson:"tags,omitempty"`
 	LastWrite                    MongoLastWrite       `bson:"lastWrite"`
 	ClusterTime                  *ClusterTime         `bson:"$clusterTime,omitempty"`
 	ConfigServerState            *ConfigServerState   `bson:"$configServerState,omitempty"`
@@ -50,6 +51,14 @@ type NodeInfo struct {
 	opts                         MongodOpts
 }
 
+// SecondaryDelay returns the member's secondary delay in seconds
+func (i *NodeInfo) SecondaryDelay() int {
+	if i.SecondaryDelaySecs != 0 {
+		return i.SecondaryDelaySecs
+	}
+	return i.SecondaryDelayOld
+}
+
 // IsSharded returns true is replset is part sharded cluster
 func (i *NodeInfo) IsSharded() bool {
 	return i.SetName != "" && (i.ConfigServerState != nil || i.opts.Sharding.ClusterRole != "" || i.ConfigSvr == 2)
@@ -232,6 +241,23 @@ func (b *BalancerStatus) IsOn() bool {
 	return b.Mode == BalancerModeOn
 }
 
+// IsStopped tells if the balancer is off and has no round (and so no
+// chunk migration) in flight
+func (b *BalancerStatus) IsStopped() bool {
+	return b.Mode == BalancerModeOff && !b.InBalancerRound
+}
+
+// BalancerStopState is the balancer state the leader of a sharded backup
+// verified before the data is copied
+type BalancerStopState struct {
+	Mode    BalancerMode `bson:"mode" json:"mode" yaml:"mode"`
+	InRound bool         `bson:"in_round" json:"in_round" yaml:"in_round"`
+	// Stopped means the balancer is off with no round in flight
+	Stopped bool `bson:"stopped" json:"stopped" yaml:"stopped"`
+	// WaitedMs is how long it took the balancer to stop
+	WaitedMs int64 `bson:"waited_ms" json:"waited_ms" yaml:"waited_ms"`
+}
+
 type MongodOpts struct {
 	Net struct {
 		BindIp string `bson:"bindIp" json:"bindIp" yaml:"bindIp"`
@@ -245,28 +271,49 @@ type MongodOpts struct {
 }
 
 type MongodOptsSec struct {
-	EnableEncryption     *bool   `bson:"enableEncryption,omitempty" json:"enableEncryption,omitempty" yaml:"enableEncryption,omitempty"`
-	EncryptionCipherMode *string `bson:"encryptionCipherMode,omitempty" json:"encryptionCipherMode,omitempty" yaml:"encryptionCipherMode,omitempty"`
-	EncryptionKeyFile    *string `bson:"encryptionKeyFile,omitempty" json:"encryptionKeyFile,omitempty" yaml:"encryptionKeyFile,omitempty"`
-	RelaxPermChecks      *bool   `bson:"relaxPermChecks,omitempty" json:"relaxPermChecks,omitempty" yaml:"relaxPermChecks,omitempty"`
-	Vault                *struct {
-		ServerName           *string `bson:"serverName,omitempty" json:"serverName,omitempty" yaml:"serverName,omitempty"`
-		Port                 *int    `bson:"port,omitempty" json:"port,omitempty" yaml:"port,omitempty"`
-		TokenFile            *string `bson:"tokenFile,omitempty" json:"tokenFile,omitempty" yaml:"tokenFile,omitempty"`
-		Secret               *string `bson:"secret,omitempty" json:"secret,omitempty" yaml:"secret,omitempty"`
-		ServerCAFile         *string `bson:"serverCAFile,omitempty" json:"serverCAFile,omitempty" yaml:"serverCAFile,omitempty"`
-		SecretVersion        *uint32 `bson:"secretVersion,omitempty" json:"secretVersion,omitempty" yaml:"secretVersion,omitempty"`
-		DisableTLSForTesting *bool   `bson:"disableTLSForTesting,omitempty" json:"disableTLSForTesting,omitempty" yaml:"disableTLSForTesting,omitempty"`
-	} `bson:"vault,omitempty" json:"vault,omitempty" yaml:"vault,omitempty"`
-	KMIP *struct {
-		ServerName                *string `bson:"serverName,omitempty" json:"serverName,omitempty" yaml:"serverName,omitempty"`
-		Port                      *int    `bson:"port,omitempty" json:"port,omitempty" yaml:"port,omitempty"`
-		ClientCertificateFile     *string `bson:"clientCertificateFile,omitempty" json:"clientCertificateFile,omitempty" yaml:"clientCertificateFile,omitempty"`
-		ClientKeyFile             *string `bson:"clientKeyFile,omitempty" json:"clientKeyFile,omitempty" yaml:"clientKeyFile,omitempty"`
-		ServerCAFile              *string `bson:"serverCAFile,omitempty" json:"serverCAFile,omitempty" yaml:"serverCAFile,omitempty"`
-		KeyIdentifier             *string `bson:"keyIdentifier,omitempty" json:"keyIdentifier,omitempty" yaml:"keyIdentifier,omitempty"`
-		ClientCertificatePassword *string `bson:"clientCertificatePassword,omitempty" json:"-" yaml:"clientCertificatePassword,omitempty"`
-	} `bson:"kmip,omitempty" json:"kmip,omitempty" yaml:"kmip,omitempty"`
+	EnableEncryption     *bool            `bson:"enableEncryption,omitempty" json:"enableEncryption,omitempty" yaml:"enableEncryption,omitempty"`
+	EncryptionCipherMode *string          `bson:"encryptionCipherMode,omitempty" json:"encryptionCipherMode,omitempty" yaml:"encryptionCipherMode,omitempty"`
+	EncryptionKeyFile    *string          `bson:"encryptionKeyFile,omitempty" json:"encryptionKeyFile,omitempty" yaml:"encryptionKeyFile,omitempty"`
+	RelaxPermChecks      *bool            `bson:"relaxPermChecks,omitempty" json:"relaxPermChecks,omitempty" yaml:"relaxPermChecks,omitempty"`
+	Vault                *MongodOptsVault `bson:"vault,omitempty" json:"vault,omitempty" yaml:"vault,omitempty"`
+	KMIP                 *MongodOptsKMIP  `bson:"kmip,omitempty" json:"kmip,omitempty" yaml:"kmip,omitempty"`
+}
+
+// Masked returns a copy of the options with the KMIP client certificate
+// password masked
+func (s *MongodOptsSec) Masked() *MongodOptsSec {
+	if s == nil {
+		return nil
+	}
+
+	mask := "***"
+	c := *s
+	if c.KMIP != nil && c.KMIP.ClientCertificatePassword != nil {
+		k := *c.KMIP
+		k.ClientCertificatePassword = &mask
+		c.KMIP = &k
+	}
+	return &c
+}
+
+type MongodOptsVault struct {
+	ServerName           *string `bson:"serverName,omitempty" json:"serverName,omitempty" yaml:"serverName,omitempty"`
+	Port                 *int    `bson:"port,omitempty" json:"port,omitempty" yaml:"port,omitempty"`
+	TokenFile            *string `bson:"tokenFile,omitempty" json:"tokenFile,omitempty" yaml:"tokenFile,omitempty"`
+	Secret               *string `bson:"secret,omitempty" json:"secret,omitempty" yaml:"secret,omitempty"`
+	ServerCAFile         *string `bson:"serverCAFile,omitempty" json:"serverCAFile,omitempty" yaml:"serverCAFile,omitempty"`
+	SecretVersion        *uint32 `bson:"secretVersion,omitempty" json:"secretVersion,omitempty" yaml:"secretVersion,omitempty"`
+	DisableTLSForTesting *bool   `bson:"disableTLSForTesting,omitempty" json:"disableTLSForTesting,omitempty" yaml:"disableTLSForTesting,omitempty"`
+}
+
+type MongodOptsKMIP struct {
+	ServerName                *string `bson:"serverName,omitempty" json:"serverName,omitempty" yaml:"serverName,omitempty"`
+	Port                      *int    `bson:"port,omitempty" json:"port,omitempty" yaml:"port,omitempty"`
+	ClientCertificateFile     *string `bson:"clientCertificateFile,omitempty" json:"clientCertificateFile,omitempty" yaml:"clientCertificateFile,omitempty"`
+	ClientKeyFile             *string `bson:"clientKeyFile,omitempty" json:"clientKeyFile,omitempty" yaml:"clientKeyFile,omitempty"`
+	ServerCAFile              *string `bson:"serverCAFile,omitempty" json:"serverCAFile,omitempty" yaml:"serverCAFile,omitempty"`
+	KeyIdentifier             *string `bson:"keyIdentifier,omitempty" json:"keyIdentifier,omitempty" yaml:"keyIdentifier,omitempty"`
+	ClientCertificatePassword *string `bson:"clientCertificatePassword,omitempty" json:"-" yaml:"clientCertificatePassword,omitempty"`
 }
 
 type MongodOptsStorage struct {
@@ -293,13 +340,18 @@ type RSConfig struct {
 	Version                 int        `bson:"version" json:"version"`
 	Members                 []RSMember `bson:"members" json:"members"`
 	WConcernMajorityJournal bool       `bson:"writeConcernMajorityJournalDefault,omitempty" json:"writeConcernMajorityJournalDefault"`
-	Settings                struct {
-		ChainingAllowed         bool `bson:"chainingAllowed,omitempty" json:"chainingAllowed"`
-		HeartbeatIntervalMillis int  `bson:"heartbeatIntervalMillis,omitempty" json:"heartbeatIntervalMillis"`
-		HeartbeatTimeoutSecs    int  `bson:"heartbeatTimeoutSecs,omitempty" json:"heartbeatTimeoutSecs"`
-		ElectionTimeoutMillis   int  `bson:"electionTimeoutMillis,omitempty" json:"electionTimeoutMillis"`
-		CatchUpTimeoutMillis    int  `bson:"catchUpTimeoutMillis,omitempty" json:"catchUpTimeoutMillis"`
-	} `bson:"settings,omitempty" json:"settings"`
+	Settings                RSSettings `bson:"settings,omitempty" json:"settings"`
+}
+
+type RSSettings struct {
+	ChainingAllowed         bool `bson:"chainingAllowed,omitempty" json:"chainingAllowed"`
+	HeartbeatIntervalMillis int  `bson:"heartbeatIntervalMillis,omitempty" json:"heartbeatIntervalMillis"`
+	HeartbeatTimeoutSecs    int  `bson:"heartbeatTimeoutSecs,omitempty" json:"heartbeatTimeoutSecs"`
+	ElectionTimeoutMillis   int  `bson:"electionTimeoutMillis,omitempty" json:"electionTimeoutMillis"`
+	CatchUpTimeoutMillis    int  `bson:"catchUpTimeoutMillis,omitempty" json:"catchUpTimeoutMillis"`
+	// ReplicaSetID is generated by mongod on the replset initiation.
+	// Members with different ones refuse to talk to each other.
+	ReplicaSetID primitive.ObjectID `bson:"replicaSetId,omitempty" json:"replicaSetId,omitempty"`
 }
 
 type RSMember struct {
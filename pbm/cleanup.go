@@ -17,7 +17,30 @@ type CleanupInfo struct {
 	Chunks  []OplogChunk `json:"chunks"`
 }
 
+// MakeCleanupInfo returns backups and chunks to delete to clean up before
+// the `ts`. Protected backups (see BackupMeta.Protected) are left.
 func MakeCleanupInfo(ctx context.Context, m *mongo.Client, ts primitive.Timestamp) (CleanupInfo, error) {
+	info, err := makeCleanupInfo(ctx, m, ts)
+	if err != nil {
+		return info, err
+	}
+
+	protected, err := listProtected(ctx, m)
+	if err != nil {
+		return CleanupInfo{}, errors.WithMessage(err, "list protected backups")
+	}
+	backups := info.Backups[:0]
+	for _, b := range info.Backups {
+		if _, ok := protected[b.Name]; !ok {
+			backups = append(backups, b)
+		}
+	}
+	info.Backups = backups
+
+	return info, nil
+}
+
+func makeCleanupInfo(ctx context.Context, m *mongo.Client, ts primitive.Timestamp) (CleanupInfo, error) {
 	backups, err := listBackupsBefore(ctx, m, primitive.Timestamp{T: ts.T + 1})
 	if err != nil {
 		return CleanupInfo{}, errors.WithMessage(err, "list backups before")
@@ -92,6 +115,51 @@ func MakeCleanupInfo(ctx context.Context, m *mongo.Client, ts primitive.Timestam
 	return CleanupInfo{Backups: backups, Chunks: chunks}, nil
 }
 
+// listProtected returns the backups that can't be deleted mapped to
+// the protected backup they are kept for: the protected backups themselves
+// and the ones protected incremental backups are based on
+func listProtected(ctx context.Context, m *mongo.Client) (map[string]string, error) {
+	o := options.Find().SetProjection(bson.D{{"name", 1}, {"src_backup", 1}, {"protected", 1}})
+	cur, err := m.Database(DB).Collection(BcpCollection).Find(ctx, bson.D{}, o)
+	if err != nil {
+		return nil, errors.WithMessage(err, "query")
+	}
+
+	bcps := []BackupMeta{}
+	if err := cur.All(ctx, &bcps); err != nil {
+		return nil, errors.WithMessage(err, "cursor: all")
+	}
+
+	return protectedBackups(bcps), nil
+}
+
+func protectedBackups(bcps []BackupMeta) map[string]string {
+	src := make(map[string]string, len(bcps))
+	rv := make(map[string]string)
+	for _, b := range bcps {
+		src[b.Name] = b.SrcBackup
+		if b.Protected {
+			rv[b.Name] = b.Name
+		}
+	}
+
+	for _, b := range bcps {
+		if !b.Protected {
+			continue
+		}
+		// the chain can't be longer than the list
+		n := src[b.Name]
+		for i := 0; n != "" && i < len(bcps); i++ {
+			if _, ok := rv[n]; !ok {
+				rv[n] = b.Name
+			}
+			n = src[n]
+		}
+	}
+
+	return rv
+}
+
 // listBackupsBefore returns backups with restore cluster time less than or equals to ts
 func listBackupsBefore(ctx context.Context, m *mongo.Client, ts primitive.Timestamp) ([]BackupMeta, error) {
 	f := bson.D{{"last_write_ts", bson.M{"$lt": ts}}}
@@ -0,0 +1,32 @@
+package pbm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProtectedBackups(t *testing.T) {
+	bcps := []BackupMeta{
+		{Name: "full1"},
+		{Name: "inc1", SrcBackup: "full1"},
+		{Name: "inc2", SrcBackup: "inc1", Protected: true},
+		{Name: "inc3", SrcBackup: "inc2"},
+		{Name: "full2", Protected: true},
+		{Name: "full3"},
+		// a broken cycle mustn't hang
+		{Name: "incA", SrcBackup: "incB", Protected: true},
+		{Name: "incB", SrcBackup: "incA"},
+	}
+
+	want := map[string]string{
+		"full1": "inc2",
+		"inc1":  "inc2",
+		"inc2":  "inc2",
+		"full2": "full2",
+		"incA":  "incA",
+		"incB":  "incA",
+	}
+	if got := protectedBackups(bcps); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,520 @@
+package pbm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// CompactIncrementalChain merges incremental backups from `base` through
+// `upTo` into a new base backup with the same restore point as `upTo`.
+// Increments made on top of `upTo` are re-pointed to the new base.
+// The merged backups are kept. It returns the name of the new backup.
+//
+// The new base can't be a source for further increments as mongod doesn't
+// know it. So `upTo` must not be the latest incremental backup. It fails if
+// a backup, restore or another operation on backups (e.g. delete) is running.
+func (p *PBM) CompactIncrementalChain(base, upTo string) (string, error) {
+	return p.compactIncrementalChain(base, upTo, false)
+}
+
+// CompactAndDeleteIncrementalChain is the same as CompactIncrementalChain
+// but also deletes the merged backups once the new base is ready. It fails
+// if any other increment is based on a backup of the chain but `upTo`.
+func (p *PBM) CompactAndDeleteIncrementalChain(base, upTo string) (string, error) {
+	return p.compactIncrementalChain(base, upTo, true)
+}
+
+func (p *PBM) compactIncrementalChain(base, upTo string, del bool) (_ string, err error) {
+	lock, err := p.lockCompact()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if rerr := lock.ReleaseWith(err); rerr != nil && err == nil {
+			err = errors.Wrap(rerr, "release lock")
+		}
+	}()
+
+	bcps, err := p.BackupsDoneList(nil, 0, -1)
+	if err != nil {
+		return "", errors.Wrap(err, "get backups list")
+	}
+
+	chain, err := compactChain(base, upTo, bcps)
+	if err != nil {
+		return "", err
+	}
+	if del {
+		for _, b := range chain {
+			if b.Protected {
+				return "", errors.Wrapf(ErrBackupProtected, "unable to delete %s", b.Name)
+			}
+		}
+		if deps := chainDependents(chain, bcps); len(deps) != 0 {
+			return "", errors.Errorf("backups %s are based on the chain and would be left without their source. "+
+				"Delete them first or keep the chain", strings.Join(deps, ", "))
+		}
+	}
+
+	cfg, err := p.GetConfig()
+	if err != nil {
+		return "", errors.Wrap(err, "get config")
+	}
+	stg, err := Storage(cfg, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "get storage")
+	}
+
+	last := chain[len(chain)-1]
+	name := time.Now().UTC().Format(time.RFC3339)
+	if _, err := p.GetBackupMeta(name); err == nil {
+		return "", errors.Errorf("backup %s already exists", name)
+	} else if !errors.Is(err, ErrNotFound) {
+		return "", errors.Wrapf(err, "check backup %s", name)
+	}
+
+	meta := *last
+	meta.Name = name
+	meta.OPID = ""
+	meta.SrcBackup = ""
+	meta.Status = StatusDone
+	meta.Conditions = nil
+	meta.Nomination = nil
+	meta.Protected = false
+	meta.Size = 0
+	meta.SizeUncompressed = 0
+	meta.Replsets = make([]BackupReplset, len(last.Replsets))
+	for i, rs := range last.Replsets {
+		files, err := compactRS(stg, chain, rs.Name, name, last.Compression, last.Layout, cfg.Backup.CompactWorkDir)
+		if err != nil {
+			return "", errors.Wrapf(err, "compact replset %s", rs.Name)
+		}
+
+		rs.Files = files
+		rs.Journal = nil
+		for _, f := range files {
+			meta.Size += f.StgSize
+			meta.SizeUncompressed += f.RawSize()
+		}
+		meta.Replsets[i] = rs
+	}
+
+	err = writeBackupMeta(stg, &meta)
+	if err != nil {
+		return "", errors.Wrap(err, "write metadata to storage")
+	}
+	err = p.SetBackupMeta(&meta)
+	if err != nil {
+		return "", errors.Wrap(err, "write metadata")
+	}
+
+	for i := range bcps {
+		child := &bcps[i]
+		if child.Type != IncrementalBackup || child.SrcBackup != last.Name {
+			continue
+		}
+
+		child.SrcBackup = name
+		err = p.SetSrcBackup(child.Name, name)
+		if err != nil {
+			return "", errors.Wrapf(err, "set source backup for %s", child.Name)
+		}
+		err = writeBackupMeta(stg, child)
+		if err != nil {
+			return "", errors.Wrapf(err, "write metadata of %s to storage", child.Name)
+		}
+	}
+
+	if !del {
+		return name, nil
+	}
+
+	for _, b := range chain {
+		err = p.DeleteBackupFiles(b, stg)
+		if err != nil {
+			return name, errors.Wrapf(err, "delete files of %s", b.Name)
+		}
+		_, err = p.Conn.Database(DB).Collection(BcpCollection).DeleteOne(p.ctx, bson.M{"name": b.Name})
+		if err != nil {
+			return name, errors.Wrapf(err, "delete metadata of %s", b.Name)
+		}
+		err = p.deleteBackupEvents(b.Name)
+		if err != nil {
+			return name, errors.WithMessage(err, b.Name)
+		}
+	}
+
+	return name, nil
+}
+
+// lockCompact takes the op lock on the leader replset, the same deletes take,
+// and checks no backup or restore is running. A new increment made meanwhile
+// is based on the latest one which isn't a part of the compacted chain.
+func (p *PBM) lockCompact() (*Lock, error) {
+	inf, err := p.GetNodeInfo()
+	if err != nil {
+		return nil, errors.Wrap(err, "get node info")
+	}
+	ep, err := p.GetEpoch()
+	if err != nil {
+		return nil, errors.Wrap(err, "get epoch")
+	}
+
+	epts := ep.TS()
+	lock := p.NewLockCol(LockHeader{
+		Type:    CmdCompact,
+		Replset: inf.SetName,
+		Node:    inf.Me,
+		OPID:    primitive.NewObjectID().Hex(),
+		Epoch:   &epts,
+	}, LockOpCollection)
+	got, err := lock.Acquire()
+	if err != nil {
+		return nil, errors.Wrap(err, "acquire lock")
+	}
+	if !got {
+		return nil, errors.New("lock not acquired")
+	}
+
+	err = p.checkNoMainOp()
+	if err != nil {
+		if rerr := lock.Release(); rerr != nil {
+			err = errors.Errorf("%v. Also failed to release the lock: %v", err, rerr)
+		}
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// checkNoMainOp returns ErrConcurrentOp if a backup or restore holds
+// an alive main lock. PITR slicing doesn't count.
+func (p *PBM) checkNoMainOp() error {
+	locks, err := p.GetLocks(&LockHeader{})
+	if err != nil {
+		return errors.Wrap(err, "get locks")
+	}
+	ts, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "read cluster time")
+	}
+
+	for _, l := range locks {
+		if l.Type != CmdPITR && l.Heartbeat.T+StaleFrameSec >= ts.T {
+			return ErrConcurrentOp{Lock: l.LockHeader}
+		}
+	}
+	return nil
+}
+
+// compactChain returns the chain of incremental backups from `base`
+// through `upTo` if it can be compacted
+func compactChain(base, upTo string, bcps []BackupMeta) ([]*BackupMeta, error) {
+	all := make(map[string]*BackupMeta, len(bcps))
+	var latest *BackupMeta
+	for i := range bcps {
+		b := &bcps[i]
+		all[b.Name] = b
+		if b.Type == IncrementalBackup && (latest == nil || b.StartTS > latest.StartTS) {
+			latest = b
+		}
+	}
+
+	b, ok := all[upTo]
+	if !ok {
+		return nil, errors.Errorf("backup %s not found or not done", upTo)
+	}
+	if b.Type != IncrementalBackup {
+		return nil, errors.Errorf("backup %s is not incremental", upTo)
+	}
+	if b == latest {
+		return nil, errors.Errorf("backup %s is the latest incremental backup and is needed as a source for the next one", upTo)
+	}
+
+	names, err := bcpChain(b, all)
+	if err != nil {
+		return nil, errors.Wrapf(err, "chain of %s", upTo)
+	}
+	if names[0] != base {
+		return nil, errors.Errorf("backup %s is not the base of %s, the base is %s", base, upTo, names[0])
+	}
+	if len(names) < 2 {
+		return nil, errors.New("nothing to compact")
+	}
+
+	chain := make([]*BackupMeta, len(names))
+	for i, n := range names {
+		chain[i] = all[n]
+	}
+
+	return chain, nil
+}
+
+// chainDependents returns increments based on the chain members other than
+// the last one (its increments are re-pointed to the new base) that are not
+// in the chain themselves
+func chainDependents(chain []*BackupMeta, bcps []BackupMeta) []string {
+	in := make(map[string]bool, len(chain))
+	for i, b := range chain {
+		in[b.Name] = i < len(chain)-1
+	}
+
+	var deps []string
+	for _, b := range bcps {
+		if _, ok := in[b.Name]; ok || b.Type != IncrementalBackup {
+			continue
+		}
+		if in[b.SrcBackup] {
+			deps = append(deps, b.Name)
+		}
+	}
+
+	return deps
+}
+
+// compactChunk is a piece of data stored in backup Bcp
+type compactChunk struct {
+	Bcp    string
+	Cmpr   compress.CompressionType
+	Layout *BackupLayout
+	File   File
+}
+
+func (c compactChunk) path(rs string) string {
+	p := c.Layout.Path(c.Bcp, rs, c.File.Name) + c.Cmpr.Suffix()
+	if c.File.Len != 0 {
+		p += fmt.Sprintf(".%d-%d", c.File.Off, c.File.Len)
+	}
+	return p
+}
+
+// compactPlan returns the chunks to apply (in order) to rebuild the files
+// of the replset `rs` as they are in the last backup of the chain. The same
+// as the physical restore does.
+func compactPlan(chain []*BackupMeta, rs string) ([]compactChunk, error) {
+	last := chain[len(chain)-1].RS(rs)
+	if last == nil {
+		return nil, errors.Errorf("no replset %s in backup %s", rs, chain[len(chain)-1].Name)
+	}
+
+	target := make(map[string]struct{})
+	for _, f := range append(last.Files, last.Journal...) {
+		target[f.Name] = struct{}{}
+	}
+
+	var chunks []compactChunk
+	for _, b := range chain {
+		r := b.RS(rs)
+		if r == nil {
+			return nil, errors.Errorf("no replset %s in backup %s", rs, b.Name)
+		}
+		for _, f := range append(r.Files, r.Journal...) {
+			if _, ok := target[f.Name]; ok && f.Off >= 0 && f.Len >= 0 {
+				chunks = append(chunks, compactChunk{Bcp: b.Name, Cmpr: b.Compression, Layout: b.Layout, File: f})
+			}
+		}
+	}
+
+	return chunks, nil
+}
+
+// rebuildFiles applies chunks to the files in dir
+func rebuildFiles(stg storage.Storage, rs string, chunks []compactChunk, dir string) error {
+	cpbuf := make([]byte, 32*1024)
+	for _, c := range chunks {
+		err := applyChunk(stg, c.path(rs), c, filepath.Join(dir, c.File.Name), cpbuf)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyChunk(stg storage.Storage, src string, c compactChunk, dst string, buf []byte) error {
+	err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0o700)
+	if err != nil {
+		return errors.Wrapf(err, "create path %s", filepath.Dir(dst))
+	}
+
+	sr, err := stg.SourceReader(src)
+	if err != nil {
+		return errors.Wrapf(err, "create source reader for <%s>", src)
+	}
+	defer sr.Close()
+
+	data, err := compress.Decompress(sr, c.Cmpr)
+	if err != nil {
+		return errors.Wrapf(err, "decompress object %s", src)
+	}
+	defer data.Close()
+
+	fw, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return errors.Wrapf(err, "create/open file <%s>", dst)
+	}
+	defer fw.Close()
+
+	if c.File.Off != 0 {
+		_, err = fw.Seek(c.File.Off, io.SeekStart)
+		if err != nil {
+			return errors.Wrapf(err, "set file offset <%s>|%d", dst, c.File.Off)
+		}
+	}
+	_, err = io.CopyBuffer(fw, data, buf)
+	if err != nil {
+		return errors.Wrapf(err, "copy file <%s>", dst)
+	}
+	if c.File.Size != 0 {
+		err = fw.Truncate(c.File.Size)
+		if err != nil {
+			return errors.Wrapf(err, "truncate file <%s>|%d", dst, c.File.Size)
+		}
+	}
+
+	return nil
+}
+
+// compactRS rebuilds files of the replset one by one in a temp dir within
+// workDir (the system temp dir if empty) and uploads them as the part of
+// the backup `name`. So the local disk has to fit only the largest file.
+// It returns the files list for the backup metadata.
+func compactRS(stg storage.Storage, chain []*BackupMeta, rs, name string,
+	cmpr compress.CompressionType, layout *BackupLayout, workDir string,
+) ([]File, error) {
+	chunks, err := compactPlan(chain, rs)
+	if err != nil {
+		return nil, err
+	}
+
+	built := make(map[string][]compactChunk)
+	var need int64
+	for _, c := range chunks {
+		built[c.File.Name] = append(built[c.File.Name], c)
+		if c.File.Size > need {
+			need = c.File.Size
+		}
+	}
+
+	if workDir == "" {
+		workDir = os.TempDir()
+	}
+	err = checkFreeSpace(workDir, need)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(workDir, "pbm-compact-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	last := chain[len(chain)-1].RS(rs)
+	var files []File
+	for _, f := range append(append([]File{}, last.Files...), last.Journal...) {
+		// keep files that have never been copied (see PBM-1063)
+		// so the restore creates their directories
+		fchunks, ok := built[f.Name]
+		if !ok {
+			files = append(files, f)
+			continue
+		}
+
+		err = rebuildFiles(stg, rs, fchunks, dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rebuild %s", f.Name)
+		}
+		src := filepath.Join(dir, f.Name)
+		nf, err := uploadCompacted(stg, src, layout.Path(name, rs, f.Name), cmpr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "upload %s", f.Name)
+		}
+		err = os.Remove(src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "remove rebuilt %s", f.Name)
+		}
+		nf.Name = f.Name
+		nf.Fmode = f.Fmode
+		files = append(files, nf)
+	}
+
+	return files, nil
+}
+
+// checkFreeSpace returns an error if the volume of dir has less than
+// need bytes available. The check is skipped where it isn't supported.
+func checkFreeSpace(dir string, need int64) error {
+	free, err := FreeSpace(dir)
+	if errors.Is(err, ErrFreeSpaceUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "statfs %s", dir)
+	}
+
+	if free < need {
+		return errors.Errorf("%s: %d bytes free, the largest file to rebuild is %d bytes. "+
+			"Set backup.compactWorkDir to a volume with more space", dir, free, need)
+	}
+	return nil
+}
+
+func uploadCompacted(stg storage.Storage, src, dst string, cmpr compress.CompressionType) (File, error) {
+	fstat, err := os.Stat(src)
+	if err != nil {
+		return File{}, errors.Wrap(err, "get file stat")
+	}
+
+	dst += cmpr.Suffix()
+	r, pw := io.Pipe()
+	go func() {
+		w, err := compress.Compress(pw, cmpr, nil)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		f := File{Name: src}
+		_, err = f.WriteTo(w)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+
+	err = stg.Save(dst, r, fstat.Size())
+	r.Close()
+	if err != nil {
+		return File{}, errors.Wrap(err, "save")
+	}
+
+	finf, err := stg.FileStat(dst)
+	if err != nil {
+		return File{}, errors.Wrapf(err, "get storage file stat %s", dst)
+	}
+
+	return File{Size: fstat.Size(), StgSize: finf.Size}, nil
+}
+
+func writeBackupMeta(stg storage.Storage, meta *BackupMeta) error {
+	b, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "marshal data")
+	}
+
+	return stg.Save(meta.Name+MetadataFileSuffix, bytes.NewReader(b), -1)
+}
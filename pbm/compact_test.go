@@ -0,0 +1,160 @@
+package pbm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestCompactChain(t *testing.T) {
+	bcps := []BackupMeta{
+		{Name: "b0", Type: IncrementalBackup, Status: StatusDone, StartTS: 1},
+		{Name: "b1", Type: IncrementalBackup, Status: StatusDone, StartTS: 2, SrcBackup: "b0"},
+		{Name: "b2", Type: IncrementalBackup, Status: StatusDone, StartTS: 3, SrcBackup: "b1"},
+		{Name: "b3", Type: IncrementalBackup, Status: StatusDone, StartTS: 4, SrcBackup: "b2"},
+		{Name: "p0", Type: PhysicalBackup, Status: StatusDone, StartTS: 5},
+	}
+
+	chain, err := compactChain("b0", "b2", bcps)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chain) != 3 || chain[0].Name != "b0" || chain[2].Name != "b2" {
+		t.Errorf("unexpected chain %v", chain)
+	}
+
+	for _, c := range []struct{ base, upTo string }{
+		{"b0", "b3"}, // the latest increment
+		{"b1", "b2"}, // not the base
+		{"b0", "b0"}, // nothing to compact
+		{"p0", "p0"}, // not incremental
+		{"b0", "bX"}, // doesn't exist
+	} {
+		if _, err := compactChain(c.base, c.upTo, bcps); err == nil {
+			t.Errorf("%s..%s: expected error", c.base, c.upTo)
+		}
+	}
+
+	if deps := chainDependents(chain, bcps); len(deps) != 0 {
+		t.Errorf("unexpected dependents %v", deps)
+	}
+	bcps = append(bcps, BackupMeta{Name: "b1x", Type: IncrementalBackup, Status: StatusDone, StartTS: 6, SrcBackup: "b1"})
+	chain, err = compactChain("b0", "b2", bcps)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// b3 is re-pointed to the new base, b1x would lose its source
+	if deps := chainDependents(chain, bcps); len(deps) != 1 || deps[0] != "b1x" {
+		t.Errorf("expected dependent b1x, got %v", deps)
+	}
+}
+
+func TestCompactRebuild(t *testing.T) {
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+	cmpr := compress.CompressionTypeS2
+
+	put := func(bcp string, f File, data string) File {
+		c := compactChunk{Bcp: bcp, Cmpr: cmpr, File: f}
+		buf := new(bytes.Buffer)
+		w, _ := compress.Compress(buf, cmpr, nil)
+		w.Write([]byte(data))
+		w.Close()
+		if err := stg.Save(c.path("rs0"), buf, -1); err != nil {
+			t.Fatalf("save %s: %v", c.path("rs0"), err)
+		}
+		return f
+	}
+	meta := func(name, src string, files ...File) *BackupMeta {
+		return &BackupMeta{
+			Name:        name,
+			SrcBackup:   src,
+			Type:        IncrementalBackup,
+			Compression: cmpr,
+			Replsets:    []BackupReplset{{Name: "rs0", Files: files}},
+		}
+	}
+
+	chain := []*BackupMeta{
+		meta("b0", "",
+			put("b0", File{Name: "a", Size: 6}, "aaaaaa"),
+			put("b0", File{Name: "b", Size: 3}, "bbb"),
+			put("b0", File{Name: "c", Size: 1}, "c"),
+		),
+		meta("b1", "b0",
+			put("b1", File{Name: "a", Off: 2, Len: 2, Size: 6}, "XY"),
+			File{Name: "b", Off: -1, Len: -1, Size: 3},
+		),
+		meta("b2", "b1",
+			File{Name: "a", Off: -1, Len: -1, Size: 6},
+			put("b2", File{Name: "b", Off: 0, Len: 5, Size: 5}, "BBBBB"),
+			File{Name: "d/e", Off: -1, Len: -1, Size: -1},
+		),
+	}
+
+	chunks, err := compactPlan(chain, "rs0")
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	// "c" is not in the last backup and "d/e" has never been copied
+	if len(chunks) != 4 {
+		t.Errorf("expected 4 chunks, got %v", chunks)
+	}
+
+	dir := t.TempDir()
+	err = rebuildFiles(stg, "rs0", chunks, dir)
+	if err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+
+	for name, want := range map[string]string{"a": "aaXYaa", "b": "BBBBB"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("read %s: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+
+	// the compacted backup may have another layout than the chain
+	layout := &BackupLayout{Type: LayoutHashed, Buckets: 4}
+	files, err := compactRS(stg, chain, "rs0", "new", cmpr, layout, t.TempDir())
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %v", files)
+	}
+	for _, f := range files {
+		if f.Name == "d/e" {
+			if f.Off != -1 {
+				t.Errorf("expected %s to be kept as is, got %v", f.Name, f)
+			}
+			continue
+		}
+		if f.Off != 0 || f.Len != 0 || f.StgSize == 0 {
+			t.Errorf("expected %s to be a full file, got %+v", f.Name, f)
+		}
+		if _, err := stg.FileStat(layout.Path("new", "rs0", f.Name) + cmpr.Suffix()); err != nil {
+			t.Errorf("stat uploaded %s: %v", f.Name, err)
+		}
+	}
+}
+
+func TestCompactFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkFreeSpace(dir, 1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkFreeSpace(dir, 1<<62); err == nil {
+		t.Error("expected not enough space error")
+	}
+	if err := checkFreeSpace(filepath.Join(dir, "none"), 1); err == nil {
+		t.Error("expected error for the missing dir")
+	}
+}
@@ -3,8 +3,10 @@ package pbm
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -23,40 +25,28 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm/storage/blackhole"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+	"github.com/percona/percona-backup-mongodb/pbm/tracing"
 )
 
 // Config is a pbm config
 type Config struct {
-	PITR    PITRConf            `bson:"pitr" json:"pitr" yaml:"pitr"`
-	Storage StorageConf         `bson:"storage" json:"storage" yaml:"storage"`
-	Restore RestoreConf         `bson:"restore" json:"restore,omitempty" yaml:"restore,omitempty"`
-	Backup  BackupConf          `bson:"backup" json:"backup,omitempty" yaml:"backup,omitempty"`
-	Epoch   primitive.Timestamp `bson:"epoch" json:"-" yaml:"-"`
+	PITR      PITRConf            `bson:"pitr" json:"pitr" yaml:"pitr"`
+	Storage   StorageConf         `bson:"storage" json:"storage" yaml:"storage"`
+	Restore   RestoreConf         `bson:"restore" json:"restore,omitempty" yaml:"restore,omitempty"`
+	Backup    BackupConf          `bson:"backup" json:"backup,omitempty" yaml:"backup,omitempty"`
+	Retention RetentionConf       `bson:"retention,omitempty" json:"retention,omitempty" yaml:"retention,omitempty"`
+	Notify    NotifyConf          `bson:"notify,omitempty" json:"notify,omitempty" yaml:"notify,omitempty"`
+	Tracing   tracing.Conf        `bson:"tracing,omitempty" json:"tracing,omitempty" yaml:"tracing,omitempty"`
+	Epoch     primitive.Timestamp `bson:"epoch" json:"-" yaml:"-"`
+
+	// UploadLimit limits the agents' uploads to the storage
+	UploadLimit *UploadLimitConf `bson:"uploadLimit,omitempty" json:"uploadLimit,omitempty" yaml:"uploadLimit,omitempty"`
+	// Retry tunes the retries of the agents' operations
+	Retry *RetryConf `bson:"retry,omitempty" json:"retry,omitempty" yaml:"retry,omitempty"`
 }
 
 func (c Config) String() string {
-	if c.Storage.S3.Credentials.AccessKeyID != "" {
-		c.Storage.S3.Credentials.AccessKeyID = "***"
-	}
-	if c.Storage.S3.Credentials.SecretAccessKey != "" {
-		c.Storage.S3.Credentials.SecretAccessKey = "***"
-	}
-	if c.Storage.S3.Credentials.SessionToken != "" {
-		c.Storage.S3.Credentials.SessionToken = "***"
-	}
-	if c.Storage.S3.Credentials.Vault.Secret != "" {
-		c.Storage.S3.Credentials.Vault.Secret = "***"
-	}
-	if c.Storage.S3.Credentials.Vault.Token != "" {
-		c.Storage.S3.Credentials.Vault.Token = "***"
-	}
-	if c.Storage.S3.ServerSideEncryption != nil &&
-		c.Storage.S3.ServerSideEncryption.SseCustomerKey != "" {
-		c.Storage.S3.ServerSideEncryption.SseCustomerKey = "***"
-	}
-	if c.Storage.Azure.Credentials.Key != "" {
-		c.Storage.Azure.Credentials.Key = "***"
-	}
+	c = redactConfig(c)
 
 	b, err := yaml.Marshal(c)
 	if err != nil {
@@ -73,6 +63,114 @@ type PITRConf struct {
 	OplogOnly        bool                     `bson:"oplogOnly,omitempty" json:"oplogOnly,omitempty" yaml:"oplogOnly,omitempty"`
 	Compression      compress.CompressionType `bson:"compression,omitempty" json:"compression,omitempty" yaml:"compression,omitempty"`
 	CompressionLevel *int                     `bson:"compressionLevel,omitempty" json:"compressionLevel,omitempty" yaml:"compressionLevel,omitempty"`
+	// ChunkSizeMb is the target size of the oplog chunk. If set, a chunk is
+	// cut as soon as it reaches the size, and on low traffic its span is
+	// extended up to OplogSpanMaxMin. Zero means chunks are cut by span only.
+	ChunkSizeMb     float64 `bson:"chunkSizeMb,omitempty" json:"chunkSizeMb,omitempty" yaml:"chunkSizeMb,omitempty"`
+	OplogSpanMaxMin float64 `bson:"oplogSpanMaxMin,omitempty" json:"oplogSpanMaxMin,omitempty" yaml:"oplogSpanMaxMin,omitempty"`
+	// Throttle makes the slicer cut chunks less often while
+	// the cluster is under load
+	Throttle *PITRThrottleConf `bson:"throttle,omitempty" json:"throttle,omitempty" yaml:"throttle,omitempty"`
+
+	// GapAction is what the slicer does when the oplog is about to roll
+	// over the entries it hasn't saved yet: start a base backup ("backup")
+	// or cut chunks as often as possible ("speedup"). The risk is only
+	// logged and notified about if not set.
+	GapAction PITRGapAction `bson:"gapAction,omitempty" json:"gapAction,omitempty" yaml:"gapAction,omitempty"`
+	// GapBackupType is the type of the base backup the "backup" GapAction
+	// starts. Default is LogicalBackup.
+	GapBackupType BackupType `bson:"gapBackupType,omitempty" json:"gapBackupType,omitempty" yaml:"gapBackupType,omitempty"`
+}
+
+// GapBcpType returns the type of the backup started on the gap risk
+func (c PITRConf) GapBcpType() BackupType {
+	if c.GapBackupType == "" {
+		return LogicalBackup
+	}
+	return c.GapBackupType
+}
+
+func isValidGapBackupType(t string) bool {
+	switch BackupType(t) {
+	case "", LogicalBackup, PhysicalBackup, IncrementalBackup:
+		return true
+	}
+	return false
+}
+
+type PITRGapAction string
+
+const (
+	PITRGapActionNone    PITRGapAction = ""
+	PITRGapActionBackup  PITRGapAction = "backup"
+	PITRGapActionSpeedup PITRGapAction = "speedup"
+)
+
+func IsValidPITRGapAction(a string) bool {
+	switch PITRGapAction(a) {
+	case PITRGapActionNone, PITRGapActionBackup, PITRGapActionSpeedup:
+		return true
+	}
+	return false
+}
+
+// PITRThrottleConf defines when the cluster is considered under load. Under
+// load the span of the oplog chunks doubles with each chunk up to MaxSpanMin
+// and gets back to OplogSpanMin once the load is gone.
+type PITRThrottleConf struct {
+	Enabled bool `bson:"enabled" json:"enabled" yaml:"enabled"`
+	// MaxReplLagSec is the replication lag of the slicing node
+	// considered as the load
+	MaxReplLagSec int `bson:"maxReplLagSec,omitempty" json:"maxReplLagSec,omitempty" yaml:"maxReplLagSec,omitempty"`
+	// MaxQueuedOps is the number of operations waiting for the locks
+	// considered as the load. Zero disables the check.
+	MaxQueuedOps int `bson:"maxQueuedOps,omitempty" json:"maxQueuedOps,omitempty" yaml:"maxQueuedOps,omitempty"`
+	// MaxSpanMin caps the span of the throttled chunks. The span never
+	// exceeds a half of the node's oplog window regardless.
+	MaxSpanMin float64 `bson:"maxSpanMin,omitempty" json:"maxSpanMin,omitempty" yaml:"maxSpanMin,omitempty"`
+}
+
+const (
+	// PITRdefaultThrottleReplLag is the default PITRThrottleConf.MaxReplLagSec
+	PITRdefaultThrottleReplLag = 10
+)
+
+// ReplLag returns the replication lag considered as the load
+func (c *PITRThrottleConf) ReplLag() int {
+	if c.MaxReplLagSec == 0 {
+		return PITRdefaultThrottleReplLag
+	}
+	return c.MaxReplLagSec
+}
+
+// ThrottleMaxSpan returns the max span of the throttled chunks
+func (c PITRConf) ThrottleMaxSpan() time.Duration {
+	if c.Throttle == nil || c.Throttle.MaxSpanMin == 0 {
+		if s := c.Span(); s > PITRdefaultMaxSpan {
+			return s
+		}
+		return PITRdefaultMaxSpan
+	}
+	return time.Duration(c.Throttle.MaxSpanMin * float64(time.Minute))
+}
+
+// Span returns the configured span of the oplog chunk
+func (c PITRConf) Span() time.Duration {
+	if c.OplogSpanMin == 0 {
+		return PITRdefaultSpan
+	}
+	return time.Duration(c.OplogSpanMin * float64(time.Minute))
+}
+
+// MaxSpan returns the max span of the oplog chunk when slicing by size
+func (c PITRConf) MaxSpan() time.Duration {
+	if c.OplogSpanMaxMin == 0 {
+		if s := c.Span(); s > PITRdefaultMaxSpan {
+			return s
+		}
+		return PITRdefaultMaxSpan
+	}
+	return time.Duration(c.OplogSpanMaxMin * float64(time.Minute))
 }
 
 // StorageConf is a configuration of the backup storage
@@ -81,6 +179,12 @@ type StorageConf struct {
 	S3         s3.Conf      `bson:"s3,omitempty" json:"s3,omitempty" yaml:"s3,omitempty"`
 	Azure      azure.Conf   `bson:"azure,omitempty" json:"azure,omitempty" yaml:"azure,omitempty"`
 	Filesystem fs.Conf      `bson:"filesystem,omitempty" json:"filesystem,omitempty" yaml:"filesystem,omitempty"`
+
+	// LayoutType is the layout of physical backups data objects (see
+	// StorageLayout). LayoutBuckets is the number of hash buckets of the
+	// hashed layout.
+	LayoutType    StorageLayout `bson:"layout,omitempty" json:"layout,omitempty" yaml:"layout,omitempty"`
+	LayoutBuckets int           `bson:"layoutBuckets,omitempty" json:"layoutBuckets,omitempty" yaml:"layoutBuckets,omitempty"`
 }
 
 func (s *StorageConf) Typ() string {
@@ -124,6 +228,22 @@ func (s *StorageConf) Path() string {
 	return path
 }
 
+// RetentionConf is the retention policy along with the coverage objectives.
+// PBM doesn't delete anything on its own, KeepDays is the age of backups and
+// PITR chunks the scheduled clean-up (`pbm cleanup --older-than`) is expected
+// to delete. It's used to forecast the restorable window (see
+// ForecastCoverage) and warn in `pbm status` and with the NotifyCoverageRisk
+// notification before it gets below objectives.
+type RetentionConf struct {
+	KeepDays int `bson:"keepDays,omitempty" json:"keepDays,omitempty" yaml:"keepDays,omitempty"`
+	// MinPITRDays is the minimal restorable PITR window, in days
+	MinPITRDays float64 `bson:"minPITRDays,omitempty" json:"minPITRDays,omitempty" yaml:"minPITRDays,omitempty"`
+	// MinSnapshots is the minimal number of restorable snapshots
+	MinSnapshots int `bson:"minSnapshots,omitempty" json:"minSnapshots,omitempty" yaml:"minSnapshots,omitempty"`
+	// ForecastDays is how far ahead to look. Default is 7 days.
+	ForecastDays int `bson:"forecastDays,omitempty" json:"forecastDays,omitempty" yaml:"forecastDays,omitempty"`
+}
+
 // RestoreConf is config options for the restore
 type RestoreConf struct {
 	// Logical restore
@@ -140,16 +260,627 @@ type RestoreConf struct {
 	MaxDownloadBufferMb int `bson:"maxDownloadBufferMb" json:"maxDownloadBufferMb,omitempty" yaml:"maxDownloadBufferMb,omitempty"`
 	DownloadChunkMb     int `bson:"downloadChunkMb" json:"downloadChunkMb,omitempty" yaml:"downloadChunkMb,omitempty"`
 
+	// NumCopyWorkers is the num of files (or parts of a large uncompressed
+	// file) the physical restore copies into the dbpath concurrently.
+	// The S3 download workers and buffer are split between them.
+	// Default is 1.
+	NumCopyWorkers int `bson:"numCopyWorkers,omitempty" json:"numCopyWorkers,omitempty" yaml:"numCopyWorkers,omitempty"`
+
 	// MongodLocation sets the location of mongod used for internal runs during
 	// physical restore. Will try $PATH/mongod if not set.
 	MongodLocation    string            `bson:"mongodLocation" json:"mongodLocation,omitempty" yaml:"mongodLocation,omitempty"`
 	MongodLocationMap map[string]string `bson:"mongodLocationMap" json:"mongodLocationMap,omitempty" yaml:"mongodLocationMap,omitempty"`
+	// MongodLocationTags sets the location of mongod for the nodes with
+	// the given replset member tags. The first matching entry is used.
+	// MongodLocationMap takes precedence, MongodLocation is the fallback.
+	MongodLocationTags []MongodLocationTag `bson:"mongodLocationTags,omitempty" json:"mongodLocationTags,omitempty" yaml:"mongodLocationTags,omitempty"`
+
+	// CanaryShard is the name of the replset that runs the physical restore
+	// first. The rest of the cluster waits for the canary to succeed before
+	// wiping its data and won't touch the data at all if the canary failed.
+	CanaryShard string `bson:"canaryShard" json:"canaryShard,omitempty" yaml:"canaryShard,omitempty"`
+
+	// TmpPortRange sets the range of ports ("from-to") to pick an ephemeral
+	// port from for internal mongod runs during physical restore. Hosts with
+	// several mongod instances should set distinct ranges for each node via
+	// TmpPortRangeMap. Defaults to 1111 ports right above the mongod's port.
+	TmpPortRange    string            `bson:"tmpPortRange" json:"tmpPortRange,omitempty" yaml:"tmpPortRange,omitempty"`
+	TmpPortRangeMap map[string]string `bson:"tmpPortRangeMap" json:"tmpPortRangeMap,omitempty" yaml:"tmpPortRangeMap,omitempty"`
+
+	// TmpPortLock makes the agents on the same host reserve the tmp port
+	// through a host-local lock file, so co-located nodes with overlapping
+	// ranges never pick the same port. Each node picks independently
+	// if not set.
+	TmpPortLock bool `bson:"tmpPortLock,omitempty" json:"tmpPortLock,omitempty" yaml:"tmpPortLock,omitempty"`
+
+	// MaxRestoreDurationMin sets the time limit (in minutes) for the physical
+	// restore to converge. Nodes that haven't yet touched the data abort the
+	// restore with an error once the limit is exceeded. Nodes past the point
+	// of no return keep going. No limit if not set.
+	MaxRestoreDurationMin float64 `bson:"maxRestoreDurationMin" json:"maxRestoreDurationMin,omitempty" yaml:"maxRestoreDurationMin,omitempty"`
+
+	// CollectionCompression maps namespaces ("db.coll", "db.*" or "*.*") to
+	// the WiredTiger block compressor (none, snappy, zlib, zstd) for
+	// collections created during the logical restore. The most specific
+	// namespace match wins. Collections with no match keep the options
+	// from the backup.
+	CollectionCompression map[string]string `bson:"collectionCompression" json:"collectionCompression,omitempty" yaml:"collectionCompression,omitempty"`
+
+	// DBPathIgnore is the list of entries (file name patterns) in the root
+	// of the dbpath or of a filesystem mounted within it which aren't
+	// mongod's and have to be left intact by the physical restore.
+	// Defaults to lost+found and storage snapshot dirs (e.g. .snapshot).
+	DBPathIgnore []string `bson:"dbpathIgnore,omitempty" json:"dbpathIgnore,omitempty" yaml:"dbpathIgnore,omitempty"`
+
+	// CacheDir is a local directory to cache backup files downloaded during
+	// the physical restore. Subsequent restores of the same backup read them
+	// from the disk. Should be outside of the dbpath. No cache if not set.
+	CacheDir string `bson:"cacheDir,omitempty" json:"cacheDir,omitempty" yaml:"cacheDir,omitempty"`
+	// CacheSizeMb caps the cache size. The least recently used files are
+	// evicted. Defaults to 10Gb.
+	CacheSizeMb int `bson:"cacheSizeMb,omitempty" json:"cacheSizeMb,omitempty" yaml:"cacheSizeMb,omitempty"`
+
+	// Encryption overrides the encryption at rest options (enableEncryption,
+	// key file, vault or kmip) of the node for internal mongod runs during
+	// physical restore. Needed if the backup was encrypted with a key
+	// the node's own key source doesn't provide.
+	Encryption *MongodOptsSec `bson:"encryption,omitempty" json:"encryption,omitempty" yaml:"encryption,omitempty"`
+
+	// DataOwner is the owner the files restored by the physical restore
+	// are given: "user[:group]" (names or ids) or "auto" for the owner of
+	// the dbpath. Needed if mongod runs as a different user than the agent.
+	// The agent has to run as root or have the CAP_CHOWN capability.
+	// Files are left as created by the agent if not set.
+	DataOwner string `bson:"dataOwner,omitempty" json:"dataOwner,omitempty" yaml:"dataOwner,omitempty"`
+
+	// DisableSELinuxRelabel turns off restoring the SELinux context of the
+	// files restored by the physical restore. The relabeling is done only
+	// if SELinux is enforcing on the node.
+	DisableSELinuxRelabel bool `bson:"disableSELinuxRelabel,omitempty" json:"disableSELinuxRelabel,omitempty" yaml:"disableSELinuxRelabel,omitempty"`
+	// RestoreconPath is the restorecon binary to relabel the restored files
+	// with. If not set, the files get the context the dbpath content had
+	// before the restore, or restorecon from the PATH is used if there was
+	// none.
+	RestoreconPath string `bson:"restoreconPath,omitempty" json:"restoreconPath,omitempty" yaml:"restoreconPath,omitempty"`
+
+	// ValidateNamespaces is the list of collections ("db.coll") to run
+	// `validate` on after the physical restore. It reads the whole collection
+	// and its indexes, which may take as long as copying them, so it's
+	// meant for a few high-value collections only. No validation if not set.
+	ValidateNamespaces []string `bson:"validateNamespaces,omitempty" json:"validateNamespaces,omitempty" yaml:"validateNamespaces,omitempty"`
+	// ValidateStrict fails the node's restore if any collection is invalid
+	// (the node is then handled as any other failed one). Otherwise, the
+	// results are only recorded in the restore meta.
+	ValidateStrict bool `bson:"validateStrict,omitempty" json:"validateStrict,omitempty" yaml:"validateStrict,omitempty"`
+
+	// VerifySampleRate is the share (0..1] of documents of each collection
+	// sampled from the backup and compared with the ones read back by _id
+	// after the logical restore of the snapshot (before the oplog replay).
+	// It gives some confidence in the restored data at a fraction of the
+	// full validation cost. The results are recorded in the restore meta.
+	// No sampling if not set.
+	VerifySampleRate float64 `bson:"verifySampleRate,omitempty" json:"verifySampleRate,omitempty" yaml:"verifySampleRate,omitempty"`
+	// VerifySampleMax caps the number of sampled documents per collection.
+	// Defaults to 1000.
+	VerifySampleMax int `bson:"verifySampleMax,omitempty" json:"verifySampleMax,omitempty" yaml:"verifySampleMax,omitempty"`
+
+	// S3 overrides the S3 storage options for restores. So a restore may
+	// pull the backup from another S3-compatible endpoint than the one
+	// the cluster's backups go to.
+	S3 *RestoreS3Conf `bson:"s3,omitempty" json:"s3,omitempty" yaml:"s3,omitempty"`
+
+	// Mongos are the mongos to check after the physical restore. The
+	// restore records them and the cluster leader agent checks them on
+	// the cluster's start. Failed checks are only reported.
+	Mongos *RestoreMongosConf `bson:"mongos,omitempty" json:"mongos,omitempty" yaml:"mongos,omitempty"`
+
+	// AlwaysKeepInternalMongodLog keeps the log of the internal mongod runs
+	// of the physical restore in the dbpath and uploads it to the storage
+	// next to the restore logs even if the node's restore succeeded.
+	// By default, the log is kept only on failure.
+	AlwaysKeepInternalMongodLog bool `bson:"alwaysKeepInternalMongodLog,omitempty" json:"alwaysKeepInternalMongodLog,omitempty" yaml:"alwaysKeepInternalMongodLog,omitempty"`
+
+	// SessionsDropRetries is how many times the physical restore tries to
+	// drop config.system.sessions while mongod reports a background
+	// operation on it. Defaults to 5. SessionsDropBackoffSec is the pause
+	// before the first retry, each next one waits longer by as much.
+	// Defaults to 1 second.
+	SessionsDropRetries    int     `bson:"sessionsDropRetries,omitempty" json:"sessionsDropRetries,omitempty" yaml:"sessionsDropRetries,omitempty"`
+	SessionsDropBackoffSec float64 `bson:"sessionsDropBackoffSec,omitempty" json:"sessionsDropBackoffSec,omitempty" yaml:"sessionsDropBackoffSec,omitempty"`
+
+	// PartlyDonePolicy defines whether the physical restore of a replset
+	// where some nodes failed succeeds (partlyDone). Default is
+	// PartlyDoneLenient. It can be overridden per restore.
+	PartlyDonePolicy PartlyDonePolicy `bson:"partlyDonePolicy,omitempty" json:"partlyDonePolicy,omitempty" yaml:"partlyDonePolicy,omitempty"`
+
+	// ReplCatchUpTimeoutSec is how long (in seconds) the logical restore
+	// waits for the secondaries to catch up with the primary once the oplog
+	// is applied. Default is 60 sec.
+	ReplCatchUpTimeoutSec int `bson:"replCatchUpTimeoutSec,omitempty" json:"replCatchUpTimeoutSec,omitempty" yaml:"replCatchUpTimeoutSec,omitempty"`
+
+	// K8sSignals hands the restart of mongod after the physical restore
+	// over to an external controller (e.g. a Kubernetes operator): each
+	// node records the final status, then writes the restart-needed signal
+	// and waits for the controller to confirm it (see RestartSignal).
+	// K8sSignalTimeoutSec bounds the wait, default is 600 sec. The node
+	// leaves the restart to the operator if it's exceeded.
+	K8sSignals          bool `bson:"k8sSignals,omitempty" json:"k8sSignals,omitempty" yaml:"k8sSignals,omitempty"`
+	K8sSignalTimeoutSec int  `bson:"k8sSignalTimeoutSec,omitempty" json:"k8sSignalTimeoutSec,omitempty" yaml:"k8sSignalTimeoutSec,omitempty"`
+
+	// The physical restore leader warns about the node whose copy rate
+	// stays below SlowNodeRateFraction (0.5 by default) of the cluster's
+	// median for more than SlowNodeMin minutes (5 by default). That's
+	// usually a degraded disk or network link.
+	SlowNodeRateFraction float64 `bson:"slowNodeRateFraction,omitempty" json:"slowNodeRateFraction,omitempty" yaml:"slowNodeRateFraction,omitempty"`
+	SlowNodeMin          float64 `bson:"slowNodeMin,omitempty" json:"slowNodeMin,omitempty" yaml:"slowNodeMin,omitempty"`
+
+	// PostFlushDelaySec is the pause between wiping the dbpath and copying
+	// the backup files during the physical restore. If set, the dbpath dir
+	// is fsync'ed before the pause. Some filesystems (e.g. network or
+	// thin-provisioned ones) reclaim the space of the removed files lazily,
+	// so copying right away may hit transient ENOSPC or stale file handle
+	// errors. Zero (the default) means no pause.
+	PostFlushDelaySec float64 `bson:"postFlushDelaySec,omitempty" json:"postFlushDelaySec,omitempty" yaml:"postFlushDelaySec,omitempty"`
+
+	// WiredTigerSalvage allows the physical restore to run `mongod --repair`
+	// on the copied data before recovering the oplog. It's the last resort
+	// for backups with a damaged WiredTiger checkpoint. The repair drops
+	// whatever data it can't read, so it's done only if the restore asks
+	// for it as well (see RestoreCmd.WiredTigerSalvage).
+	WiredTigerSalvage bool `bson:"wiredTigerSalvage,omitempty" json:"wiredTigerSalvage,omitempty" yaml:"wiredTigerSalvage,omitempty"`
+
+	// StrictShardCoverage fails the physical restore if the cluster has
+	// shards the backup has no data for. By default such shards are left
+	// out of the restore and keep their data.
+	StrictShardCoverage bool `bson:"strictShardCoverage,omitempty" json:"strictShardCoverage,omitempty" yaml:"strictShardCoverage,omitempty"`
+
+	// Limits lowers the scheduling priority of the physical restore's heavy
+	// phases (copying the files and the internal mongod runs), so they don't
+	// starve a co-located workload of CPU and IO.
+	Limits *RestoreLimitsConf `bson:"limits,omitempty" json:"limits,omitempty" yaml:"limits,omitempty"`
+}
+
+// RestoreLimitsConf is the scheduling options of the physical restore.
+// They are Linux-only. The ones that can't be applied on the node (e.g. no
+// permissions, no cgroup v2) are reported in the restore log and skipped.
+type RestoreLimitsConf struct {
+	// Nice is the niceness [-20, 19] of the copy workers and the internal
+	// mongod. Negative values need the CAP_SYS_NICE capability.
+	Nice int `bson:"nice,omitempty" json:"nice,omitempty" yaml:"nice,omitempty"`
+	// IOClass is the IO scheduling class ("best-effort" or "idle") and
+	// IOLevel is the priority [0, 7] within the best-effort class
+	// (0 is the highest). Defaults to 7 for the best-effort class.
+	IOClass string `bson:"ioClass,omitempty" json:"ioClass,omitempty" yaml:"ioClass,omitempty"`
+	IOLevel *int   `bson:"ioLevel,omitempty" json:"ioLevel,omitempty" yaml:"ioLevel,omitempty"`
+	// Cgroup is the cgroup v2 (absolute path or relative to /sys/fs/cgroup)
+	// the internal mongod runs in. The agent process joins it while copying
+	// the files and gets back to its own cgroup afterwards.
+	Cgroup string `bson:"cgroup,omitempty" json:"cgroup,omitempty" yaml:"cgroup,omitempty"`
+}
+
+const (
+	IOClassBestEffort = "best-effort"
+	IOClassIdle       = "idle"
+)
+
+// DefaultIOLevel is the priority within the best-effort IO class
+const DefaultIOLevel = 7
+
+func (c *RestoreLimitsConf) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Nice < -20 || c.Nice > 19 {
+		return errors.New("restore.limits.nice should be in the range [-20, 19]")
+	}
+	switch c.IOClass {
+	case "", IOClassBestEffort, IOClassIdle:
+	default:
+		return errors.Errorf("restore.limits.ioClass should be %q or %q", IOClassBestEffort, IOClassIdle)
+	}
+	if c.IOLevel != nil && (*c.IOLevel < 0 || *c.IOLevel > 7) {
+		return errors.New("restore.limits.ioLevel should be in the range [0, 7]")
+	}
+	return nil
+}
+
+// IsSet tells if any limit is set
+func (c *RestoreLimitsConf) IsSet() bool {
+	return c != nil && (c.Nice != 0 || c.IOClass != "" || c.Cgroup != "")
+}
+
+// RestoreMongosConf is the list of mongos to check after the physical
+// restore. Mongos running since before the restore keep the stale routing
+// table and have to be restarted.
+type RestoreMongosConf struct {
+	// URIs are the mongos connection strings
+	URIs []string `bson:"uris,omitempty" json:"uris,omitempty" yaml:"uris,omitempty"`
+	// Discover adds the mongos found in the restored config.mongos
+	// (they are connected to with the agent's credentials)
+	Discover bool `bson:"discover,omitempty" json:"discover,omitempty" yaml:"discover,omitempty"`
+	// FlushRouterConfig runs flushRouterConfig on the mongos that weren't
+	// restarted since the restore start
+	FlushRouterConfig bool `bson:"flushRouterConfig,omitempty" json:"flushRouterConfig,omitempty" yaml:"flushRouterConfig,omitempty"`
+}
+
+// RestoreS3Conf is the S3 storage options a restore may override
+// (see s3.Conf)
+type RestoreS3Conf struct {
+	EndpointURL    string `bson:"endpointUrl,omitempty" json:"endpointUrl,omitempty" yaml:"endpointUrl,omitempty"`
+	ForcePathStyle *bool  `bson:"forcePathStyle,omitempty" json:"forcePathStyle,omitempty" yaml:"forcePathStyle,omitempty"`
+
+	// ReadEndpoints are the storages (e.g. buckets replicated to other
+	// regions) the physical restore falls back to, in order, for the backup
+	// files it fails to copy from the restore storage.
+	ReadEndpoints []RestoreReadEndpoint `bson:"readEndpoints,omitempty" json:"readEndpoints,omitempty" yaml:"readEndpoints,omitempty"`
+}
+
+// RestoreReadEndpoint is the S3 storage the backup files are read from if
+// the restore storage fails. Options that aren't set are taken from the
+// restore storage, so is the layout (prefix) and credentials.
+type RestoreReadEndpoint struct {
+	EndpointURL string `bson:"endpointUrl,omitempty" json:"endpointUrl,omitempty" yaml:"endpointUrl,omitempty"`
+	Region      string `bson:"region,omitempty" json:"region,omitempty" yaml:"region,omitempty"`
+	Bucket      string `bson:"bucket,omitempty" json:"bucket,omitempty" yaml:"bucket,omitempty"`
+}
+
+func validateEndpointURL(s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return errors.Errorf("%q should be an absolute URL (e.g. https://minio.example.com:9000)", s)
+	}
+	return nil
+}
+
+// DataOwnerAuto makes restored files owned by the owner of the dbpath
+const DataOwnerAuto = "auto"
+
+func validateDataOwner(s string) error {
+	if s == "" || s == DataOwnerAuto {
+		return nil
+	}
+	u, g, hasGroup := strings.Cut(s, ":")
+	if u == "" || hasGroup && g == "" {
+		return errors.Errorf("%q should be either %q or user[:group]", s, DataOwnerAuto)
+	}
+	return nil
+}
+
+// MongodLocationTag is the location of mongod for the nodes that
+// have all of the Tags
+type MongodLocationTag struct {
+	Tags map[string]string `bson:"tags" json:"tags" yaml:"tags"`
+	Path string            `bson:"path" json:"path" yaml:"path"`
+}
+
+// Match tells if the node with the given tags matches
+func (m MongodLocationTag) Match(tags map[string]string) bool {
+	if len(m.Tags) == 0 {
+		return false
+	}
+	for k, v := range m.Tags {
+		if t, ok := tags[k]; !ok || t != v {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	defaultSessionsDropRetries = 5
+	defaultSessionsDropBackoff = time.Second
+)
+
+// SessionsDropRetry returns the number of tries to drop
+// config.system.sessions and the backoff step between them
+func (c RestoreConf) SessionsDropRetry() (int, time.Duration) {
+	n, backoff := c.SessionsDropRetries, defaultSessionsDropBackoff
+	if n <= 0 {
+		n = defaultSessionsDropRetries
+	}
+	if c.SessionsDropBackoffSec > 0 {
+		backoff = time.Duration(c.SessionsDropBackoffSec * float64(time.Second))
+	}
+	return n, backoff
+}
+
+// PartlyDonePolicy is the outcome of the physical restore of a replset
+// where some nodes failed. The failed nodes have to be re-synced manually.
+type PartlyDonePolicy string
+
+const (
+	// PartlyDoneLenient makes the replset partlyDone if any node succeeded
+	PartlyDoneLenient PartlyDonePolicy = "lenient"
+	// PartlyDoneQuorum makes the replset partlyDone if the majority of its
+	// voting members (arbiters aside) succeeded, otherwise it fails
+	PartlyDoneQuorum PartlyDonePolicy = "quorum"
+	// PartlyDoneStrict fails the replset (and so the cluster) if any node failed
+	PartlyDoneStrict PartlyDonePolicy = "strict"
+)
+
+func IsValidPartlyDonePolicy(s string) bool {
+	switch PartlyDonePolicy(s) {
+	case "", PartlyDoneLenient, PartlyDoneQuorum, PartlyDoneStrict:
+		return true
+	}
+
+	return false
+}
+
+const defaultReplCatchUpTimeout = 60 * time.Second
+
+// ReplCatchUpTimeout returns how long the logical restore waits for
+// the secondaries to catch up
+func (c RestoreConf) ReplCatchUpTimeout() time.Duration {
+	if c.ReplCatchUpTimeoutSec <= 0 {
+		return defaultReplCatchUpTimeout
+	}
+	return time.Duration(c.ReplCatchUpTimeoutSec) * time.Second
+}
+
+const defaultK8sSignalTimeout = 600 * time.Second
+
+// K8sSignalTimeout returns how long the physical restore waits for
+// the restart confirmation
+func (c RestoreConf) K8sSignalTimeout() time.Duration {
+	if c.K8sSignalTimeoutSec <= 0 {
+		return defaultK8sSignalTimeout
+	}
+	return time.Duration(c.K8sSignalTimeoutSec) * time.Second
+}
+
+const (
+	defaultSlowNodeRateFraction = 0.5
+	defaultSlowNodeWindow       = 5 * time.Minute
+)
+
+// SlowNode returns the fraction of the cluster median copy rate
+// the node is slow below and for how long it has to be slow to be
+// reported
+func (c RestoreConf) SlowNode() (float64, time.Duration) {
+	f, d := defaultSlowNodeRateFraction, defaultSlowNodeWindow
+	if c.SlowNodeRateFraction > 0 {
+		f = c.SlowNodeRateFraction
+	}
+	if c.SlowNodeMin > 0 {
+		d = time.Duration(c.SlowNodeMin * float64(time.Minute))
+	}
+	return f, d
+}
+
+// PostFlushDelay returns the pause between the dbpath flush and
+// the files copying
+func (c RestoreConf) PostFlushDelay() time.Duration {
+	if c.PostFlushDelaySec <= 0 {
+		return 0
+	}
+	return time.Duration(c.PostFlushDelaySec * float64(time.Second))
+}
+
+// MongodLocationFor returns the location of mongod for the node:
+// the node's entry in MongodLocationMap, then the first matching
+// MongodLocationTags entry, then MongodLocation. It is empty if
+// none is set.
+func (c RestoreConf) MongodLocationFor(node string, tags map[string]string) string {
+	if m, ok := c.MongodLocationMap[node]; ok {
+		return m
+	}
+	for _, t := range c.MongodLocationTags {
+		if t.Match(tags) {
+			return t.Path
+		}
+	}
+	return c.MongodLocation
+}
+
+// WTBlockCompressors is the list of WiredTiger block compressors
+var WTBlockCompressors = []string{"none", "snappy", "zlib", "zstd"}
+
+func isValidWTBlockCompressor(c string) bool {
+	for _, v := range WTBlockCompressors {
+		if c == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParsePortRange parses ports range in the "from-to" format
+func ParsePortRange(s string) (from, to int, err error) {
+	r := strings.SplitN(s, "-", 2)
+	if len(r) != 2 {
+		return 0, 0, errors.Errorf("invalid ports range %q, expected format is `from-to`", s)
+	}
+
+	from, err = strconv.Atoi(strings.TrimSpace(r[0]))
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "parse ports range %q", s)
+	}
+	to, err = strconv.Atoi(strings.TrimSpace(r[1]))
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "parse ports range %q", s)
+	}
+
+	if from <= 0 || to > 65535 || from > to {
+		return 0, 0, errors.Errorf("invalid ports range %q", s)
+	}
+
+	return from, to, nil
 }
 
 type BackupConf struct {
 	Priority         map[string]float64       `bson:"priority,omitempty" json:"priority,omitempty" yaml:"priority,omitempty"`
 	Compression      compress.CompressionType `bson:"compression,omitempty" json:"compression,omitempty" yaml:"compression,omitempty"`
 	CompressionLevel *int                     `bson:"compressionLevel,omitempty" json:"compressionLevel,omitempty" yaml:"compressionLevel,omitempty"`
+
+	// ManifestCheck defines how thoroughly physical backup files on the
+	// storage are verified before the backup is marked as done.
+	// Default is ManifestCheckSize.
+	ManifestCheck ManifestCheck `bson:"manifestCheck,omitempty" json:"manifestCheck,omitempty" yaml:"manifestCheck,omitempty"`
+
+	// MetaRetryWindowSec is the time (in seconds) the agent keeps retrying
+	// to persist the backup metadata while PBM's db isn't writable
+	// (e.g. the ConfigServer primary is flapping) before it fails
+	// the backup. Default is 60 sec.
+	MetaRetryWindowSec int `bson:"metaRetryWindowSec,omitempty" json:"metaRetryWindowSec,omitempty" yaml:"metaRetryWindowSec,omitempty"`
+
+	// MongoVersionCheck defines what the backup leader does if the nodes
+	// run mongod versions which differ beyond MongoVersionTolerance.
+	// Default is MongoVersionCheckWarn.
+	MongoVersionCheck MongoVersionCheck `bson:"mongoVersionCheck,omitempty" json:"mongoVersionCheck,omitempty" yaml:"mongoVersionCheck,omitempty"`
+	// MongoVersionTolerance is the part of the version the nodes' mongod
+	// may differ in. Default is VersionTolerancePatch.
+	MongoVersionTolerance VersionTolerance `bson:"mongoVersionTolerance,omitempty" json:"mongoVersionTolerance,omitempty" yaml:"mongoVersionTolerance,omitempty"`
+
+	// BalancerStopCheck defines what the leader of a sharded backup does
+	// if the balancer round (and so a chunk migration) is still in flight
+	// once the balancer is stopped. Default is BalancerStopWait.
+	BalancerStopCheck BalancerStopCheck `bson:"balancerStopCheck,omitempty" json:"balancerStopCheck,omitempty" yaml:"balancerStopCheck,omitempty"`
+	// BalancerStopTimeoutSec is how long (in seconds) the leader waits for
+	// the balancer to stop. Default is 30 sec.
+	BalancerStopTimeoutSec int `bson:"balancerStopTimeoutSec,omitempty" json:"balancerStopTimeoutSec,omitempty" yaml:"balancerStopTimeoutSec,omitempty"`
+
+	// MaxReplLagSec is the replication lag (in seconds) that makes the node
+	// unsuitable for the backup and the PITR slicing. Nodes lagging by more
+	// than half of it are nominated after the rest. Default is 21 sec.
+	MaxReplLagSec int `bson:"maxReplLagSec,omitempty" json:"maxReplLagSec,omitempty" yaml:"maxReplLagSec,omitempty"`
+
+	// MinCompressionRatio is the compression ratio (the size of the data
+	// before the compression to the size on the storage) below which
+	// the compressed backup is flagged and a warning is sent. E.g. the data
+	// encrypted at rest barely compresses. Zero (the default) disables it.
+	MinCompressionRatio float64 `bson:"minCompressionRatio,omitempty" json:"minCompressionRatio,omitempty" yaml:"minCompressionRatio,omitempty"`
+
+	// CompactWorkDir is a local directory where the incremental chain
+	// compaction rebuilds files before uploading them. Default is
+	// the system temp dir.
+	CompactWorkDir string `bson:"compactWorkDir,omitempty" json:"compactWorkDir,omitempty" yaml:"compactWorkDir,omitempty"`
+}
+
+// BalancerStopCheck is the action on the balancer round in flight
+// during a sharded backup
+type BalancerStopCheck string
+
+const (
+	// BalancerStopWait waits for the round to finish (up to
+	// BackupConf.BalancerStopTimeoutSec) and fails the backup if it doesn't
+	BalancerStopWait BalancerStopCheck = "wait"
+	// BalancerStopFail fails the backup right away
+	BalancerStopFail BalancerStopCheck = "fail"
+)
+
+const defaultBalancerStopTimeout = 30 * time.Second
+
+func IsValidBalancerStopCheck(s string) bool {
+	switch BalancerStopCheck(s) {
+	case "", BalancerStopWait, BalancerStopFail:
+		return true
+	}
+
+	return false
+}
+
+// BalancerStopTimeout returns how long to wait for the balancer to stop
+func (c BackupConf) BalancerStopTimeout() time.Duration {
+	if c.BalancerStopTimeoutSec <= 0 {
+		return defaultBalancerStopTimeout
+	}
+	return time.Duration(c.BalancerStopTimeoutSec) * time.Second
+}
+
+// DefaultMaxReplLagSec is the default BackupConf.MaxReplLagSec
+const DefaultMaxReplLagSec = 21
+
+// MaxReplLag returns the replication lag (in seconds) that makes the node
+// unsuitable for the backup
+func (c BackupConf) MaxReplLag() int {
+	if c.MaxReplLagSec <= 0 {
+		return DefaultMaxReplLagSec
+	}
+	return c.MaxReplLagSec
+}
+
+// MongoVersionCheck is the action on inconsistent mongod versions in the cluster
+type MongoVersionCheck string
+
+const (
+	// MongoVersionCheckNone skips the check
+	MongoVersionCheckNone MongoVersionCheck = "none"
+	// MongoVersionCheckWarn logs a warning and proceeds with the backup
+	MongoVersionCheckWarn MongoVersionCheck = "warn"
+	// MongoVersionCheckStrict fails the backup
+	MongoVersionCheckStrict MongoVersionCheck = "strict"
+)
+
+func IsValidMongoVersionCheck(s string) bool {
+	switch MongoVersionCheck(s) {
+	case "",
+		MongoVersionCheckNone,
+		MongoVersionCheckWarn,
+		MongoVersionCheckStrict:
+		return true
+	}
+
+	return false
+}
+
+// VersionTolerance is the part of the version which may differ
+type VersionTolerance string
+
+const (
+	// VersionTolerancePatch allows different patch versions
+	// within the same major.minor
+	VersionTolerancePatch VersionTolerance = "patch"
+	// VersionToleranceMinor allows different minor versions
+	// within the same major
+	VersionToleranceMinor VersionTolerance = "minor"
+)
+
+func IsValidVersionTolerance(s string) bool {
+	switch VersionTolerance(s) {
+	case "",
+		VersionTolerancePatch,
+		VersionToleranceMinor:
+		return true
+	}
+
+	return false
+}
+
+// ManifestCheck is the level of verification of uploaded backup files
+type ManifestCheck string
+
+const (
+	// ManifestCheckNone skips the verification
+	ManifestCheckNone ManifestCheck = "none"
+	// ManifestCheckExist checks that each file exists on the storage
+	ManifestCheckExist ManifestCheck = "exist"
+	// ManifestCheckSize checks that each file exists and has the expected size
+	ManifestCheckSize ManifestCheck = "size"
+	// ManifestCheckChecksum in addition to the size, reads back each file
+	// and verifies its decompressed content has the size and the checksum
+	// recorded on the upload (see File.Checksum).
+	ManifestCheckChecksum ManifestCheck = "checksum"
+)
+
+func IsValidManifestCheck(s string) bool {
+	switch ManifestCheck(s) {
+	case "",
+		ManifestCheckNone,
+		ManifestCheckExist,
+		ManifestCheckSize,
+		ManifestCheckChecksum:
+		return true
+	}
+
+	return false
 }
 
 type confMap map[string]reflect.Kind
@@ -191,7 +922,63 @@ func (p *PBM) SetConfigByte(buf []byte) error {
 	return errors.Wrap(p.SetConfig(cfg), "write to db")
 }
 
+// SetConfig validates and writes the whole config. The epoch is reset only
+// if agents have to reload (see configNeedsReload).
 func (p *PBM) SetConfig(cfg Config) error {
+	return p.setConfig(cfg, "set config", false)
+}
+
+func (p *PBM) setConfig(cfg Config, op string, replace bool) error {
+	err := validateConfig(&cfg)
+	if err != nil {
+		return err
+	}
+
+	cur, err := p.getRawConfig()
+	isNew := errors.Is(err, mongo.ErrNoDocuments)
+	if err != nil && !isNew {
+		return errors.Wrap(err, "get current config")
+	}
+
+	if isNew || !reflect.DeepEqual(cur.Storage, cfg.Storage) {
+		err = probeStorage(cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg.Epoch = cur.Epoch
+	if isNew || configNeedsReload(cur, cfg) {
+		ct, err := p.ClusterTime()
+		if err != nil {
+			return errors.Wrap(err, "get cluster time")
+		}
+		cfg.Epoch = ct
+	}
+
+	if replace {
+		_, err = p.Conn.Database(DB).Collection(ConfigCollection).ReplaceOne(
+			p.ctx,
+			bson.D{},
+			cfg,
+			options.Replace().SetUpsert(true),
+		)
+	} else {
+		_, err = p.Conn.Database(DB).Collection(ConfigCollection).UpdateOne(
+			p.ctx,
+			bson.D{},
+			bson.M{"$set": cfg},
+			options.Update().SetUpsert(true),
+		)
+	}
+	if err != nil {
+		return errors.Wrap(err, "mongo ConfigCollection UpdateOne")
+	}
+
+	return errors.Wrap(p.recordConfigChange(op), "record config change")
+}
+
+func validateConfig(cfg *Config) error {
 	switch cfg.Storage.Type {
 	case storage.S3:
 		err := cfg.Storage.S3.Cast()
@@ -209,28 +996,201 @@ func (p *PBM) SetConfig(cfg Config) error {
 		}
 	}
 
+	if l := string(cfg.Storage.LayoutType); !IsValidStorageLayout(l) {
+		return errors.Errorf("unsupported storage layout: %q", l)
+	}
+	if err := validateLayoutBuckets(cfg.Storage.LayoutBuckets); err != nil {
+		return errors.Wrap(err, "storage.layoutBuckets")
+	}
 	if c := string(cfg.PITR.Compression); c != "" && !compress.IsValidCompressionType(c) {
 		return errors.Errorf("unsupported compression type: %q", c)
 	}
+	if a := string(cfg.PITR.GapAction); !IsValidPITRGapAction(a) {
+		return errors.Errorf("unsupported pitr.gapAction: %q", a)
+	}
+	if t := string(cfg.PITR.GapBackupType); !isValidGapBackupType(t) {
+		return errors.Errorf("unsupported pitr.gapBackupType: %q", t)
+	}
+	if c := string(cfg.Backup.ManifestCheck); !IsValidManifestCheck(c) {
+		return errors.Errorf("unsupported manifest check: %q", c)
+	}
+	if c := string(cfg.Backup.BalancerStopCheck); !IsValidBalancerStopCheck(c) {
+		return errors.Errorf("unsupported balancer stop check: %q", c)
+	}
+	if cfg.Backup.BalancerStopTimeoutSec < 0 {
+		return errors.New("backup.balancerStopTimeoutSec can't be negative")
+	}
+	if cfg.Backup.MaxReplLagSec < 0 {
+		return errors.New("backup.maxReplLagSec can't be negative")
+	}
+	if cfg.Backup.MinCompressionRatio < 0 {
+		return errors.New("backup.minCompressionRatio can't be negative")
+	}
+	if cfg.Restore.ReplCatchUpTimeoutSec < 0 {
+		return errors.New("restore.replCatchUpTimeoutSec can't be negative")
+	}
+	if cfg.Restore.K8sSignalTimeoutSec < 0 {
+		return errors.New("restore.k8sSignalTimeoutSec can't be negative")
+	}
+	if f := cfg.Restore.SlowNodeRateFraction; f < 0 || f >= 1 {
+		return errors.New("restore.slowNodeRateFraction should be in [0, 1)")
+	}
+	if cfg.Restore.SlowNodeMin < 0 {
+		return errors.New("restore.slowNodeMin can't be negative")
+	}
+	if c := string(cfg.Backup.MongoVersionCheck); !IsValidMongoVersionCheck(c) {
+		return errors.Errorf("unsupported mongo version check: %q", c)
+	}
+	if t := string(cfg.Backup.MongoVersionTolerance); !IsValidVersionTolerance(t) {
+		return errors.Errorf("unsupported mongo version tolerance: %q", t)
+	}
+	if r := cfg.Retention; r.KeepDays < 0 || r.MinPITRDays < 0 || r.MinSnapshots < 0 || r.ForecastDays < 0 {
+		return errors.New("retention options can't be negative")
+	}
+	if p := cfg.PITR; p.OplogSpanMin < 0 || p.ChunkSizeMb < 0 || p.OplogSpanMaxMin < 0 {
+		return errors.New("pitr options can't be negative")
+	}
+	if p := cfg.PITR; p.OplogSpanMaxMin != 0 && p.MaxSpan() < p.Span() {
+		return errors.New("pitr.oplogSpanMaxMin can't be less than pitr.oplogSpanMin")
+	}
+	if err := validateUploadLimit(cfg.UploadLimit); err != nil {
+		return err
+	}
+	if err := validateRetryConf(cfg.Retry); err != nil {
+		return err
+	}
+	if t := cfg.PITR.Throttle; t != nil {
+		if t.MaxReplLagSec < 0 || t.MaxQueuedOps < 0 || t.MaxSpanMin < 0 {
+			return errors.New("pitr.throttle options can't be negative")
+		}
+		if t.MaxSpanMin != 0 && cfg.PITR.ThrottleMaxSpan() < cfg.PITR.Span() {
+			return errors.New("pitr.throttle.maxSpanMin can't be less than pitr.oplogSpanMin")
+		}
+	}
+	if r := cfg.Restore.TmpPortRange; r != "" {
+		if _, _, err := ParsePortRange(r); err != nil {
+			return errors.Wrap(err, "restore.tmpPortRange")
+		}
+	}
+	if err := validateDataOwner(cfg.Restore.DataOwner); err != nil {
+		return errors.Wrap(err, "restore.dataOwner")
+	}
+	if r := cfg.Restore.VerifySampleRate; r < 0 || r > 1 {
+		return errors.New("restore.verifySampleRate should be in the range [0, 1]")
+	}
+	if err := cfg.Restore.Limits.validate(); err != nil {
+		return err
+	}
+	if cfg.Restore.VerifySampleMax < 0 {
+		return errors.New("restore.verifySampleMax can't be negative")
+	}
+	if cfg.Restore.NumCopyWorkers < 0 {
+		return errors.New("restore.numCopyWorkers can't be negative")
+	}
+	if cfg.Restore.SessionsDropRetries < 0 {
+		return errors.New("restore.sessionsDropRetries can't be negative")
+	}
+	if cfg.Restore.SessionsDropBackoffSec < 0 {
+		return errors.New("restore.sessionsDropBackoffSec can't be negative")
+	}
+	if p := string(cfg.Restore.PartlyDonePolicy); !IsValidPartlyDonePolicy(p) {
+		return errors.Errorf("unsupported restore.partlyDonePolicy: %q", p)
+	}
+	if cfg.Restore.PostFlushDelaySec < 0 {
+		return errors.New("restore.postFlushDelaySec can't be negative")
+	}
+	for _, ns := range cfg.Restore.ValidateNamespaces {
+		if db, coll, ok := strings.Cut(ns, "."); !ok || db == "" || coll == "" || strings.Contains(ns, "*") {
+			return errors.Errorf("restore.validateNamespaces: %q should be a collection name (db.coll)", ns)
+		}
+	}
+	if cfg.Restore.S3 != nil {
+		if err := validateEndpointURL(cfg.Restore.S3.EndpointURL); err != nil {
+			return errors.Wrap(err, "restore.s3.endpointUrl")
+		}
+		for i, ep := range cfg.Restore.S3.ReadEndpoints {
+			if ep == (RestoreReadEndpoint{}) {
+				return errors.Errorf("restore.s3.readEndpoints[%d] is empty", i)
+			}
+			if err := validateEndpointURL(ep.EndpointURL); err != nil {
+				return errors.Wrapf(err, "restore.s3.readEndpoints[%d].endpointUrl", i)
+			}
+		}
+		if len(cfg.Restore.S3.ReadEndpoints) != 0 && cfg.Storage.Type != storage.S3 {
+			return errors.New("restore.s3.readEndpoints require the S3 storage")
+		}
+	}
+	if cfg.Restore.Mongos != nil {
+		for i, u := range cfg.Restore.Mongos.URIs {
+			if err := options.Client().ApplyURI(u).Validate(); err != nil {
+				return errors.Wrapf(err, "restore.mongos.uris[%d]", i)
+			}
+		}
+	}
+	if err := validateWebhook(cfg.Notify.Webhook); err != nil {
+		return errors.Wrap(err, "notify.webhook")
+	}
+	if err := tracing.ValidateEndpoint(cfg.Tracing.Endpoint); err != nil {
+		return errors.Wrap(err, "tracing.endpoint")
+	}
+	for ns, c := range cfg.Restore.CollectionCompression {
+		if !isValidWTBlockCompressor(c) {
+			return errors.Errorf("restore.collectionCompression: unsupported compressor %q for %s, should be one of %v",
+				c, ns, WTBlockCompressors)
+		}
+	}
+	for i, t := range cfg.Restore.MongodLocationTags {
+		if len(t.Tags) == 0 || t.Path == "" {
+			return errors.Errorf("restore.mongodLocationTags[%d]: both tags and path should be set", i)
+		}
+	}
+	for n, r := range cfg.Restore.TmpPortRangeMap {
+		if _, _, err := ParsePortRange(r); err != nil {
+			return errors.Wrapf(err, "restore.tmpPortRangeMap for %s", n)
+		}
+	}
 
-	ct, err := p.ClusterTime()
-	if err != nil {
-		return errors.Wrap(err, "get cluster time")
+	return nil
+}
+
+// configReloadSections are the config sections agents have to reload on
+// change (e.g. PITR slicing has to be restarted with the new storage).
+// Changing them resets the epoch.
+var configReloadSections = []string{"storage", "pitr"}
+
+func configKeyNeedsReload(key string) bool {
+	section := strings.Split(key, ".")[0]
+	for _, s := range configReloadSections {
+		if s == section {
+			return true
+		}
 	}
+	return false
+}
 
-	cfg.Epoch = ct
+func configNeedsReload(old, new Config) bool {
+	return !reflect.DeepEqual(old.Storage, new.Storage) || !reflect.DeepEqual(old.PITR, new.PITR)
+}
+
+// probeStorage checks that the storage is reachable with the given config.
+// The filesystem storage isn't checked as its path is local to agents.
+func probeStorage(cfg Config) error {
+	switch cfg.Storage.Type {
+	case storage.S3, storage.Azure:
+	default:
+		return nil
+	}
 
-	// TODO: if store or pitr changed - need to bump epoch
-	// TODO: struct tags to config opts `pbm:"resync,epoch"`?
-	p.GetConfig()
+	stg, err := Storage(cfg, nil)
+	if err != nil {
+		return errors.Wrap(err, "storage probe")
+	}
+	_, err = stg.FileStat(StorInitFile)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) && !errors.Is(err, storage.ErrEmpty) {
+		return errors.Wrap(err, "storage probe")
+	}
 
-	_, err = p.Conn.Database(DB).Collection(ConfigCollection).UpdateOne(
-		p.ctx,
-		bson.D{},
-		bson.M{"$set": cfg},
-		options.Update().SetUpsert(true),
-	)
-	return errors.Wrap(err, "mongo ConfigCollection UpdateOne")
+	return nil
 }
 
 func (p *PBM) SetConfigVar(key, val string) error {
@@ -238,8 +1198,7 @@ func (p *PBM) SetConfigVar(key, val string) error {
 		return errors.New("invalid config key")
 	}
 
-	// just check if config was set
-	_, err := p.GetConfig()
+	cfg, err := p.getRawConfig()
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return errors.New("config is not set")
@@ -265,11 +1224,168 @@ func (p *PBM) SetConfigVar(key, val string) error {
 	// TODO: how to be with special case options like pitr.enabled
 	switch key {
 	case "pitr.enabled":
-		return errors.Wrap(p.confSetPITR(key, v.(bool)), "write to db")
+		err = p.confSetPITR(key, v.(bool))
+		if err != nil {
+			return errors.Wrap(err, "write to db")
+		}
+		return errors.Wrap(p.recordConfigChange(fmt.Sprintf("set %s=%s", key, val)), "record config change")
 	case "pitr.compression":
 		if c := v.(string); c != "" && !compress.IsValidCompressionType(c) {
 			return errors.Errorf("unsupported compression type: %q", c)
 		}
+	case "pitr.gapAction":
+		if a := v.(string); !IsValidPITRGapAction(a) {
+			return errors.Errorf("unsupported pitr.gapAction: %q", a)
+		}
+	case "pitr.gapBackupType":
+		if t := v.(string); !isValidGapBackupType(t) {
+			return errors.Errorf("unsupported pitr.gapBackupType: %q", t)
+		}
+	case "pitr.oplogSpanMin":
+		if v.(float64) < 0 {
+			return errors.New("pitr.oplogSpanMin can't be negative")
+		}
+	case "pitr.throttle.maxReplLagSec", "pitr.throttle.maxQueuedOps":
+		if v.(int64) < 0 {
+			return errors.Errorf("%s can't be negative", key)
+		}
+	case "pitr.throttle.maxSpanMin":
+		if v.(float64) < 0 {
+			return errors.New("pitr.throttle.maxSpanMin can't be negative")
+		}
+	case "uploadLimit.maxConcurrent":
+		if n := v.(int64); n < 0 || n != 0 && n < int64(len(storage.OpClasses)) {
+			return errors.Errorf("uploadLimit.maxConcurrent should be 0 (no limit) or at least %d",
+				len(storage.OpClasses))
+		}
+	case "uploadLimit.maxMBps", "uploadLimit.weights.pitr", "uploadLimit.weights.backup", "uploadLimit.weights.meta":
+		if v.(float64) < 0 {
+			return errors.Errorf("%s can't be negative", key)
+		}
+	case "retry.attemptsFactor", "retry.backoffFactor", "retry.maxBackoffSec":
+		if v.(float64) < 0 {
+			return errors.Errorf("%s can't be negative", key)
+		}
+	case "retry.jitter":
+		if j := v.(float64); j < 0 || j >= 1 {
+			return errors.New("retry.jitter should be in the range [0, 1)")
+		}
+	case "restore.tmpPortRange":
+		if r := v.(string); r != "" {
+			if _, _, err := ParsePortRange(r); err != nil {
+				return err
+			}
+		}
+	case "restore.dataOwner":
+		if err := validateDataOwner(v.(string)); err != nil {
+			return err
+		}
+	case "restore.verifySampleRate":
+		if r := v.(float64); r < 0 || r > 1 {
+			return errors.New("restore.verifySampleRate should be in the range [0, 1]")
+		}
+	case "restore.limits.nice":
+		if n := v.(int64); n < -20 || n > 19 {
+			return errors.New("restore.limits.nice should be in the range [-20, 19]")
+		}
+	case "restore.limits.ioClass":
+		if c := v.(string); c != "" && c != IOClassBestEffort && c != IOClassIdle {
+			return errors.Errorf("restore.limits.ioClass should be %q or %q", IOClassBestEffort, IOClassIdle)
+		}
+	case "restore.limits.ioLevel":
+		if l := v.(int64); l < 0 || l > 7 {
+			return errors.New("restore.limits.ioLevel should be in the range [0, 7]")
+		}
+	case "restore.verifySampleMax":
+		if v.(int64) < 0 {
+			return errors.New("restore.verifySampleMax can't be negative")
+		}
+	case "restore.numCopyWorkers":
+		if v.(int64) < 0 {
+			return errors.New("restore.numCopyWorkers can't be negative")
+		}
+	case "restore.sessionsDropRetries":
+		if v.(int64) < 0 {
+			return errors.New("restore.sessionsDropRetries can't be negative")
+		}
+	case "restore.sessionsDropBackoffSec":
+		if v.(float64) < 0 {
+			return errors.New("restore.sessionsDropBackoffSec can't be negative")
+		}
+	case "restore.s3.endpointUrl":
+		if err := validateEndpointURL(v.(string)); err != nil {
+			return err
+		}
+	case "notify.webhook":
+		if err := validateWebhook(v.(string)); err != nil {
+			return err
+		}
+	case "tracing.endpoint":
+		if err := tracing.ValidateEndpoint(v.(string)); err != nil {
+			return err
+		}
+	case "backup.manifestCheck":
+		if c := v.(string); !IsValidManifestCheck(c) {
+			return errors.Errorf("unsupported manifest check: %q", c)
+		}
+	case "backup.mongoVersionCheck":
+		if c := v.(string); !IsValidMongoVersionCheck(c) {
+			return errors.Errorf("unsupported mongo version check: %q", c)
+		}
+	case "restore.partlyDonePolicy":
+		if p := v.(string); !IsValidPartlyDonePolicy(p) {
+			return errors.Errorf("unsupported restore.partlyDonePolicy: %q", p)
+		}
+	case "backup.balancerStopTimeoutSec":
+		if v.(int64) < 0 {
+			return errors.New("backup.balancerStopTimeoutSec can't be negative")
+		}
+	case "backup.maxReplLagSec":
+		if v.(int64) < 0 {
+			return errors.New("backup.maxReplLagSec can't be negative")
+		}
+	case "backup.minCompressionRatio":
+		if v.(float64) < 0 {
+			return errors.New("backup.minCompressionRatio can't be negative")
+		}
+	case "restore.replCatchUpTimeoutSec":
+		if v.(int64) < 0 {
+			return errors.New("restore.replCatchUpTimeoutSec can't be negative")
+		}
+	case "restore.k8sSignalTimeoutSec":
+		if v.(int64) < 0 {
+			return errors.New("restore.k8sSignalTimeoutSec can't be negative")
+		}
+	case "restore.slowNodeRateFraction":
+		if f := v.(float64); f < 0 || f >= 1 {
+			return errors.New("restore.slowNodeRateFraction should be in [0, 1)")
+		}
+	case "restore.slowNodeMin":
+		if v.(float64) < 0 {
+			return errors.New("restore.slowNodeMin can't be negative")
+		}
+	case "backup.balancerStopCheck":
+		if c := v.(string); !IsValidBalancerStopCheck(c) {
+			return errors.Errorf("unsupported balancer stop check: %q", c)
+		}
+	case "backup.mongoVersionTolerance":
+		if t := v.(string); !IsValidVersionTolerance(t) {
+			return errors.Errorf("unsupported mongo version tolerance: %q", t)
+		}
+	case "storage.type":
+		switch storage.Type(v.(string)) {
+		case storage.S3, storage.Azure, storage.Filesystem, storage.BlackHole:
+		default:
+			return errors.Errorf("unsupported storage type: %q", v)
+		}
+	case "storage.layout":
+		if l := v.(string); !IsValidStorageLayout(l) {
+			return errors.Errorf("unsupported storage layout: %q", l)
+		}
+	case "storage.layoutBuckets":
+		if err := validateLayoutBuckets(int(v.(int64))); err != nil {
+			return errors.Wrap(err, key)
+		}
 	case "storage.filesystem.path":
 		if v.(string) == "" {
 			return errors.New("storage.filesystem.path can't be empty")
@@ -277,14 +1393,78 @@ func (p *PBM) SetConfigVar(key, val string) error {
 	case "storage.s3.debugLogLevels":
 		s3.SDKLogLevel(v.(string), os.Stderr)
 	}
+	if strings.HasPrefix(key, "retention.") {
+		if n, ok := v.(int64); ok && n < 0 {
+			return errors.Errorf("%s can't be negative", key)
+		}
+		if n, ok := v.(float64); ok && n < 0 {
+			return errors.Errorf("%s can't be negative", key)
+		}
+	}
+
+	set := bson.M{key: v}
+	if configKeyNeedsReload(key) {
+		ncfg, err := applyConfigVar(cfg, key, v)
+		if err != nil {
+			return errors.Wrapf(err, "apply %s", key)
+		}
+		if strings.HasPrefix(key, "storage.") {
+			err = probeStorage(ncfg)
+			if err != nil {
+				return err
+			}
+		}
+
+		ct, err := p.ClusterTime()
+		if err != nil {
+			return errors.Wrap(err, "get cluster time")
+		}
+		set["epoch"] = ct
+	}
 
 	_, err = p.Conn.Database(DB).Collection(ConfigCollection).UpdateOne(
 		p.ctx,
 		bson.D{},
-		bson.M{"$set": bson.M{key: v}},
+		bson.M{"$set": set},
 	)
+	if err != nil {
+		return errors.Wrap(err, "write to db")
+	}
 
-	return errors.Wrap(err, "write to db")
+	return errors.Wrap(p.recordConfigChange(fmt.Sprintf("set %s=%s", key, val)), "record config change")
+}
+
+// applyConfigVar returns a copy of the config with the key set to v
+func applyConfigVar(cfg Config, key string, v interface{}) (Config, error) {
+	b, err := bson.Marshal(cfg)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "marshal")
+	}
+	var doc bson.M
+	err = bson.Unmarshal(b, &doc)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "unmarshal")
+	}
+
+	path := strings.Split(key, ".")
+	d := doc
+	for _, k := range path[:len(path)-1] {
+		sub, ok := d[k].(bson.M)
+		if !ok {
+			sub = bson.M{}
+			d[k] = sub
+		}
+		d = sub
+	}
+	d[path[len(path)-1]] = v
+
+	b, err = bson.Marshal(doc)
+	if err != nil {
+		return Config{}, errors.Wrap(err, "marshal")
+	}
+	var rv Config
+	err = bson.Unmarshal(b, &rv)
+	return rv, errors.Wrap(err, "unmarshal")
 }
 
 func (p *PBM) DeleteConfigVar(key string) error {
@@ -300,13 +1480,25 @@ func (p *PBM) DeleteConfigVar(key string) error {
 		return err
 	}
 
+	upd := bson.M{"$unset": bson.M{key: 1}}
+	if configKeyNeedsReload(key) {
+		ct, err := p.ClusterTime()
+		if err != nil {
+			return errors.Wrap(err, "get cluster time")
+		}
+		upd["$set"] = bson.M{"epoch": ct}
+	}
+
 	_, err = p.Conn.Database(DB).Collection(ConfigCollection).UpdateOne(
 		p.ctx,
 		bson.D{},
-		bson.M{"$unset": bson.M{key: 1}},
+		upd,
 	)
+	if err != nil {
+		return errors.Wrap(err, "write to db")
+	}
 
-	return errors.Wrap(err, "write to db")
+	return errors.Wrap(p.recordConfigChange("unset "+key), "record config change")
 }
 
 func (p *PBM) confSetPITR(k string, v bool) error {
@@ -359,6 +1551,16 @@ func ValidateConfigKey(k string) bool {
 	return ok
 }
 
+// ConfigKeys returns the sorted list of the config's valid keys
+func ConfigKeys() []string {
+	k := make([]string, 0, len(_confmap))
+	for n := range _confmap {
+		k = append(k, n)
+	}
+	sort.Strings(k)
+	return k
+}
+
 func (p *PBM) GetConfigYaml(fieldRedaction bool) ([]byte, error) {
 	c, err := p.GetConfig()
 	if err != nil {
@@ -366,24 +1568,7 @@ func (p *PBM) GetConfigYaml(fieldRedaction bool) ([]byte, error) {
 	}
 
 	if fieldRedaction {
-		if c.Storage.S3.Credentials.AccessKeyID != "" {
-			c.Storage.S3.Credentials.AccessKeyID = "***"
-		}
-		if c.Storage.S3.Credentials.SecretAccessKey != "" {
-			c.Storage.S3.Credentials.SecretAccessKey = "***"
-		}
-		if c.Storage.S3.Credentials.SessionToken != "" {
-			c.Storage.S3.Credentials.SessionToken = "***"
-		}
-		if c.Storage.S3.Credentials.Vault.Secret != "" {
-			c.Storage.S3.Credentials.Vault.Secret = "***"
-		}
-		if c.Storage.S3.Credentials.Vault.Token != "" {
-			c.Storage.S3.Credentials.Vault.Token = "***"
-		}
-		if c.Storage.Azure.Credentials.Key != "" {
-			c.Storage.Azure.Credentials.Key = "***"
-		}
+		c = redactConfig(c)
 	}
 
 	b, err := yaml.Marshal(c)
@@ -394,7 +1579,12 @@ func (p *PBM) GetConfig() (Config, error) {
 	return getPBMConfig(p.ctx, p.Conn)
 }
 
-func getPBMConfig(ctx context.Context, m *mongo.Client) (Config, error) {
+// getRawConfig returns the config as it is stored, with no defaults applied
+func (p *PBM) getRawConfig() (Config, error) {
+	return getRawConfig(p.ctx, p.Conn)
+}
+
+func getRawConfig(ctx context.Context, m *mongo.Client) (Config, error) {
 	res := m.Database(DB).Collection(ConfigCollection).FindOne(ctx, bson.D{})
 	if err := res.Err(); err != nil {
 		return Config{}, errors.WithMessage(err, "get")
@@ -405,6 +1595,15 @@ func getPBMConfig(ctx context.Context, m *mongo.Client) (Config, error) {
 		return Config{}, errors.WithMessage(err, "decode")
 	}
 
+	return c, nil
+}
+
+func getPBMConfig(ctx context.Context, m *mongo.Client) (Config, error) {
+	c, err := getRawConfig(ctx, m)
+	if err != nil {
+		return Config{}, err
+	}
+
 	if c.Backup.Compression == "" {
 		c.Backup.Compression = compress.CompressionTypeS2
 	}
@@ -432,6 +1631,153 @@ func (p *PBM) GetStorage(l *log.Event) (storage.Storage, error) {
 	return Storage(c, l)
 }
 
+// GetRestoreStorage reads current config and returns the storage restores
+// work with (see RestoreStorage)
+func (p *PBM) GetRestoreStorage(l *log.Event) (storage.Storage, error) {
+	c, err := p.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "get config")
+	}
+
+	return RestoreStorage(c, l)
+}
+
+// RestoreStorage creates the storage restores read the backup from and
+// write their sync files to (see RestoreStorageConf)
+func RestoreStorage(c Config, l *log.Event) (storage.Storage, error) {
+	c.Storage = RestoreStorageConf(c)
+	return Storage(c, l)
+}
+
+// RestoreStorageConf returns the storage config restores work with.
+// That's the config's storage with RestoreConf.S3 overrides applied.
+func RestoreStorageConf(c Config) StorageConf {
+	stg := c.Storage
+	if o := c.Restore.S3; o != nil && stg.Type == storage.S3 {
+		if o.EndpointURL != "" {
+			stg.S3.EndpointURL = o.EndpointURL
+		}
+		if o.ForcePathStyle != nil {
+			stg.S3.ForcePathStyle = o.ForcePathStyle
+		}
+	}
+
+	return stg
+}
+
+// RestoreReadEndpointsConf returns the S3 configs of the restore read
+// endpoints (see RestoreS3Conf.ReadEndpoints): the restore storage's one
+// with the endpoint's options applied
+func RestoreReadEndpointsConf(c Config) []s3.Conf {
+	if c.Restore.S3 == nil || c.Storage.Type != storage.S3 {
+		return nil
+	}
+
+	base := RestoreStorageConf(c).S3
+	var rv []s3.Conf
+	for _, ep := range c.Restore.S3.ReadEndpoints {
+		cf := base
+		if ep.EndpointURL != "" {
+			cf.EndpointURL = ep.EndpointURL
+		}
+		if ep.Region != "" {
+			cf.Region = ep.Region
+		}
+		if ep.Bucket != "" {
+			cf.Bucket = ep.Bucket
+		}
+		rv = append(rv, cf)
+	}
+	return rv
+}
+
+// BackupStorageConf returns the storage config the restore reads the backup
+// (and the PITR chunks on top of it) with. That's the restore storage's one
+// (see RestoreStorageConf) with the S3 region and endpoint recorded in the
+// backup if they differ for the same bucket, e.g. after the bucket was moved
+// to another region. The endpoint set for restores (see RestoreS3Conf) isn't
+// replaced. It returns false if the recorded settings aren't used.
+func BackupStorageConf(c Config, bcp *BackupMeta) (StorageConf, bool) {
+	stg := RestoreStorageConf(c)
+	rec := bcp.Store
+	if stg.Type != storage.S3 || rec.Type != storage.S3 || rec.S3.Bucket != stg.S3.Bucket {
+		return stg, false
+	}
+
+	used := false
+	if rec.S3.Region != "" && rec.S3.Region != stg.S3.Region {
+		stg.S3.Region = rec.S3.Region
+		used = true
+	}
+	if rec.S3.EndpointURL != stg.S3.EndpointURL && (c.Restore.S3 == nil || c.Restore.S3.EndpointURL == "") {
+		stg.S3.EndpointURL = rec.S3.EndpointURL
+		used = true
+	}
+
+	return stg, used
+}
+
+// BackupStorage creates the storage the restore reads the backup with
+// (see BackupStorageConf) and returns its config. If S3 redirects the
+// requests to another region, the client follows the bucket's region from
+// the redirect. The settings used are logged.
+func BackupStorage(c Config, bcp *BackupMeta, l *log.Event) (storage.Storage, StorageConf, error) {
+	cfgConf := RestoreStorageConf(c)
+	conf, recorded := BackupStorageConf(c, bcp)
+	c.Storage = conf
+	stg, err := Storage(c, l)
+	if err != nil {
+		return nil, conf, err
+	}
+	s, ok := stg.(*s3.S3)
+	if !ok {
+		return stg, conf, nil
+	}
+
+	from := "the config"
+	if recorded {
+		from = "the backup"
+	}
+	_, err = s.FileStat(bcp.Name + MetadataFileSuffix)
+	if s3.IsRegionRedirect(err) {
+		region, err := s.FollowRegion()
+		if err != nil {
+			l.Warning("follow the S3 redirect of bucket %q: %v", conf.S3.Bucket, err)
+		} else {
+			conf.S3.Region = region
+			from = "the S3 redirect"
+		}
+	}
+
+	logf := l.Info
+	if from == "the config" {
+		logf = l.Debug
+	}
+	logf("read backup %s from S3 bucket %q with region %q, endpoint %q taken from %s "+
+		"(the config has region %q, endpoint %q)", bcp.Name, conf.S3.Bucket, conf.S3.Region,
+		conf.S3.EndpointURL, from, cfgConf.S3.Region, cfgConf.S3.EndpointURL)
+
+	return stg, conf, nil
+}
+
+// CheckRestoreStorage checks that the storage returned by RestoreStorage is
+// reachable if its options are overridden for restores
+func CheckRestoreStorage(c Config, stg storage.Storage) error {
+	if c.Restore.S3 == nil || c.Storage.Type != storage.S3 {
+		return nil
+	}
+
+	ep := c.Restore.S3.EndpointURL
+	if ep == "" {
+		ep = c.Storage.S3.EndpointURL
+	}
+	_, err := stg.FileStat(StorInitFile)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return errors.Wrapf(err, "S3 endpoint %q is unreachable", ep)
+	}
+	return nil
+}
+
 // Storage creates and returns a storage object based on a given config
 func Storage(c Config, l *log.Event) (storage.Storage, error) {
 	switch c.Storage.Type {
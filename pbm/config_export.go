@@ -0,0 +1,225 @@
+package pbm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/yaml.v2"
+)
+
+const redactedSecret = "***"
+
+// ConfigChange is the change of the config key made by ImportConfig
+type ConfigChange struct {
+	Key string `json:"key"`
+	// Old and New are empty if the key isn't set
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// ExportConfig writes the current config as YAML (the format of
+// `pbm config --file`), so it can be kept under version control and
+// imported by ImportConfig. Secrets (storage credentials, encryption
+// passwords) are redacted unless `withSecrets` is set.
+func (p *PBM) ExportConfig(w io.Writer, withSecrets bool) error {
+	cfg, err := p.getRawConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	if !withSecrets {
+		cfg = redactConfig(cfg)
+	}
+
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return errors.Wrap(err, "marshal yaml")
+	}
+	_, err = w.Write(b)
+	return errors.Wrap(err, "write")
+}
+
+// ImportConfig replaces the config with the one exported by ExportConfig.
+// Redacted secrets are taken from the current config. The config is
+// validated and its storage probed before it's applied. It returns what
+// has changed versus the current config, nothing is written if it's
+// the same.
+func (p *PBM) ImportConfig(r io.Reader) ([]ConfigChange, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	var cfg Config
+	err = yaml.UnmarshalStrict(b, &cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal yaml")
+	}
+
+	cur, err := p.getRawConfig()
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.Wrap(err, "get current config")
+	}
+
+	err = unredactConfig(&cfg, &cur)
+	if err != nil {
+		return nil, err
+	}
+	err = validateConfig(&cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	err = probeStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := configDiff(cur, cfg)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	err = p.setConfig(cfg, "import config", true)
+	if err != nil {
+		return nil, errors.Wrap(err, "set config")
+	}
+
+	return changes, nil
+}
+
+type configSecret struct {
+	key string
+	v   *string
+}
+
+// configSecrets returns the secret fields of the config that are set
+func configSecrets(c *Config) []configSecret {
+	s := []configSecret{
+		{"storage.s3.credentials.access-key-id", &c.Storage.S3.Credentials.AccessKeyID},
+		{"storage.s3.credentials.secret-access-key", &c.Storage.S3.Credentials.SecretAccessKey},
+		{"storage.s3.credentials.session-token", &c.Storage.S3.Credentials.SessionToken},
+		{"storage.s3.credentials.vault.secret", &c.Storage.S3.Credentials.Vault.Secret},
+		{"storage.s3.credentials.vault.token", &c.Storage.S3.Credentials.Vault.Token},
+		{"storage.azure.credentials.key", &c.Storage.Azure.Credentials.Key},
+	}
+	if c.Storage.S3.ServerSideEncryption != nil {
+		s = append(s, configSecret{"storage.s3.serverSideEncryption.sseCustomerKey",
+			&c.Storage.S3.ServerSideEncryption.SseCustomerKey})
+	}
+	if c.Restore.Encryption != nil && c.Restore.Encryption.KMIP != nil &&
+		c.Restore.Encryption.KMIP.ClientCertificatePassword != nil {
+		s = append(s, configSecret{"restore.encryption.kmip.clientCertificatePassword",
+			c.Restore.Encryption.KMIP.ClientCertificatePassword})
+	}
+
+	rv := s[:0]
+	for _, v := range s {
+		if *v.v != "" {
+			rv = append(rv, v)
+		}
+	}
+	return rv
+}
+
+// redactConfig returns a copy of the config with the secrets redacted
+func redactConfig(c Config) Config {
+	if c.Storage.S3.ServerSideEncryption != nil {
+		sse := *c.Storage.S3.ServerSideEncryption
+		c.Storage.S3.ServerSideEncryption = &sse
+	}
+	if c.Restore.Encryption != nil {
+		enc := *c.Restore.Encryption
+		if enc.KMIP != nil {
+			kmip := *enc.KMIP
+			if kmip.ClientCertificatePassword != nil {
+				pass := *kmip.ClientCertificatePassword
+				kmip.ClientCertificatePassword = &pass
+			}
+			enc.KMIP = &kmip
+		}
+		c.Restore.Encryption = &enc
+	}
+
+	for _, s := range configSecrets(&c) {
+		*s.v = redactedSecret
+	}
+	return c
+}
+
+// unredactConfig sets the redacted secrets of the config to the values
+// of the current one. It fails if the current config doesn't have them.
+func unredactConfig(c, cur *Config) error {
+	curs := make(map[string]string)
+	for _, s := range configSecrets(cur) {
+		curs[s.key] = *s.v
+	}
+
+	for _, s := range configSecrets(c) {
+		if *s.v != redactedSecret {
+			continue
+		}
+		v, ok := curs[s.key]
+		if !ok {
+			return errors.Errorf("%s is redacted and isn't set in the current config", s.key)
+		}
+		*s.v = v
+	}
+
+	return nil
+}
+
+// configDiff returns the changed keys of the config. Secrets are redacted.
+func configDiff(old, new Config) []ConfigChange {
+	o, n := flattenConfig(old), flattenConfig(new)
+	ro, rn := flattenConfig(redactConfig(old)), flattenConfig(redactConfig(new))
+
+	var changes []ConfigChange
+	for k, v := range n {
+		if ov, ok := o[k]; !ok || ov != v {
+			changes = append(changes, ConfigChange{Key: k, Old: ro[k], New: rn[k]})
+		}
+	}
+	for k := range o {
+		if _, ok := n[k]; !ok {
+			changes = append(changes, ConfigChange{Key: k, Old: ro[k]})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// flattenConfig returns the config values by their keys
+// (e.g. "storage.s3.bucket")
+func flattenConfig(c Config) map[string]string {
+	rv := make(map[string]string)
+
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return rv
+	}
+	var m map[interface{}]interface{}
+	err = yaml.Unmarshal(b, &m)
+	if err != nil {
+		return rv
+	}
+
+	var flatten func(prefix string, m map[interface{}]interface{})
+	flatten = func(prefix string, m map[interface{}]interface{}) {
+		for k, v := range m {
+			key := fmt.Sprint(k)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if vm, ok := v.(map[interface{}]interface{}); ok {
+				flatten(key, vm)
+				continue
+			}
+			rv[key] = fmt.Sprint(v)
+		}
+	}
+	flatten("", m)
+
+	return rv
+}
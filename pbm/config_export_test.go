@@ -0,0 +1,77 @@
+package pbm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+)
+
+func TestConfigRedaction(t *testing.T) {
+	pass := "kmip-pass"
+	cfg := Config{
+		Storage: StorageConf{
+			Type: storage.S3,
+			S3: s3.Conf{
+				Bucket:               "bcp",
+				Credentials:          s3.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"},
+				ServerSideEncryption: &s3.AWSsse{SseCustomerKey: "key"},
+			},
+		},
+		Restore: RestoreConf{Encryption: &MongodOptsSec{KMIP: &MongodOptsKMIP{ClientCertificatePassword: &pass}}},
+	}
+
+	r := redactConfig(cfg)
+	if len(configSecrets(&r)) != 4 {
+		t.Fatalf("expected 4 secrets, got %v", configSecrets(&r))
+	}
+	for _, s := range configSecrets(&r) {
+		if *s.v != redactedSecret {
+			t.Errorf("%s isn't redacted", s.key)
+		}
+	}
+	if cfg.Storage.S3.ServerSideEncryption.SseCustomerKey != "key" || pass != "kmip-pass" {
+		t.Error("the original config is modified")
+	}
+
+	err := unredactConfig(&r, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, cfg) {
+		t.Errorf("unredacted config differs:\n%v\n%v", r, cfg)
+	}
+
+	r = redactConfig(cfg)
+	if err := unredactConfig(&r, &Config{}); err == nil {
+		t.Error("expected error on redacted secrets missing in the current config")
+	}
+}
+
+func TestConfigDiff(t *testing.T) {
+	old := Config{
+		Storage: StorageConf{
+			Type: storage.S3,
+			S3:   s3.Conf{Bucket: "bcp", Credentials: s3.Credentials{SecretAccessKey: "old"}},
+		},
+		PITR: PITRConf{Enabled: true, OplogSpanMin: 10},
+	}
+	new := old
+	new.Storage.S3.Credentials.SecretAccessKey = "new"
+	new.PITR.Enabled = false
+	new.Backup.MaxReplLagSec = 30
+
+	got := configDiff(old, new)
+	want := []ConfigChange{
+		{Key: "backup.maxReplLagSec", New: "30"},
+		{Key: "pitr.enabled", Old: "true", New: "false"},
+		{Key: "storage.s3.credentials.secret-access-key", Old: redactedSecret, New: redactedSecret},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if d := configDiff(old, old); len(d) != 0 {
+		t.Errorf("expected no changes, got %v", d)
+	}
+}
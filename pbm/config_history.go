@@ -0,0 +1,130 @@
+package pbm
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ConfigHistoryLen is the number of the config versions to keep
+const ConfigHistoryLen = 20
+
+// ConfigVersion is the config state after a change
+type ConfigVersion struct {
+	Version int64 `bson:"v" json:"version"`
+	TS      int64 `bson:"ts" json:"ts"`
+	// Initiator is the user and the host the change was made from
+	Initiator string `bson:"initiator" json:"initiator"`
+	// Op describes the change
+	Op     string `bson:"op" json:"op"`
+	Config Config `bson:"config" json:"-"`
+}
+
+// configVersionAttempts is how many times the version is allocated anew
+// if a concurrent change takes it first
+const configVersionAttempts = 5
+
+// recordConfigChange saves the current config as a new version
+// and trims the history to ConfigHistoryLen versions. The versions are
+// unique (see the index in setupNewDB), so concurrent changes can't both
+// take the same one: the loser retries with the next version.
+func (p *PBM) recordConfigChange(op string) error {
+	cfg, err := p.getRawConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+
+	v := ConfigVersion{
+		TS:        time.Now().UTC().Unix(),
+		Initiator: configInitiator(),
+		Op:        op,
+		Config:    cfg,
+	}
+	for i := 1; ; i++ {
+		last, err := p.lastConfigVersion()
+		if err != nil {
+			return errors.Wrap(err, "get last version")
+		}
+
+		v.Version = last + 1
+		_, err = p.Conn.Database(DB).Collection(ConfigHistoryCollection).InsertOne(p.ctx, v)
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) || i == configVersionAttempts {
+			return errors.Wrap(err, "insert")
+		}
+	}
+
+	_, err = p.Conn.Database(DB).Collection(ConfigHistoryCollection).DeleteMany(
+		p.ctx,
+		bson.M{"v": bson.M{"$lte": v.Version - ConfigHistoryLen}},
+	)
+	return errors.Wrap(err, "trim history")
+}
+
+func (p *PBM) lastConfigVersion() (int64, error) {
+	var v ConfigVersion
+	err := p.Conn.Database(DB).Collection(ConfigHistoryCollection).FindOne(
+		p.ctx,
+		bson.D{},
+		options.FindOne().SetSort(bson.D{{"v", -1}}),
+	).Decode(&v)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+
+	return v.Version, err
+}
+
+// ConfigHistory returns kept config versions, the latest first
+func (p *PBM) ConfigHistory() ([]ConfigVersion, error) {
+	cur, err := p.Conn.Database(DB).Collection(ConfigHistoryCollection).Find(
+		p.ctx,
+		bson.D{},
+		options.Find().SetSort(bson.D{{"v", -1}}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var vs []ConfigVersion
+	err = cur.All(p.ctx, &vs)
+	return vs, errors.Wrap(err, "decode")
+}
+
+// RollbackConfig replaces the config with the given version of it.
+// The rollback is recorded as a new version.
+func (p *PBM) RollbackConfig(version int64) error {
+	var v ConfigVersion
+	err := p.Conn.Database(DB).Collection(ConfigHistoryCollection).FindOne(
+		p.ctx,
+		bson.D{{"v", version}},
+	).Decode(&v)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errors.Errorf("config version %d not found", version)
+	} else if err != nil {
+		return errors.Wrap(err, "get version")
+	}
+
+	return p.setConfig(v.Config, "rollback to version "+strconv.FormatInt(version, 10), true)
+}
+
+func configInitiator() string {
+	u := "unknown"
+	if cu, err := user.Current(); err == nil {
+		u = cu.Username
+	}
+	h, err := os.Hostname()
+	if err != nil {
+		h = "unknown"
+	}
+
+	return u + "@" + h
+}
@@ -0,0 +1,396 @@
+package pbm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/retry"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+)
+
+func TestApplyConfigVar(t *testing.T) {
+	cfg := Config{
+		Storage: StorageConf{Type: storage.Filesystem},
+		PITR:    PITRConf{OplogSpanMin: 10},
+	}
+
+	got, err := applyConfigVar(cfg, "storage.s3.bucket", "b1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Storage.S3.Bucket != "b1" || got.Storage.Type != storage.Filesystem || got.PITR.OplogSpanMin != 10 {
+		t.Errorf("unexpected config %+v", got)
+	}
+	if cfg.Storage.S3.Bucket != "" {
+		t.Error("the original config is changed")
+	}
+
+	got, err = applyConfigVar(cfg, "pitr.oplogSpanMin", 5.0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.PITR.OplogSpanMin != 5 {
+		t.Errorf("expected oplogSpanMin 5, got %v", got.PITR.OplogSpanMin)
+	}
+}
+
+func TestConfigNeedsReload(t *testing.T) {
+	for k, want := range map[string]bool{
+		"storage.s3.bucket":   true,
+		"pitr.enabled":        true,
+		"backup.compression":  false,
+		"restore.batchSize":   false,
+		"retention.keep_days": false,
+	} {
+		if got := configKeyNeedsReload(k); got != want {
+			t.Errorf("%s: expected %v, got %v", k, want, got)
+		}
+	}
+
+	a := Config{Storage: StorageConf{Type: storage.S3}}
+	b := a
+	b.Backup.Compression = "zstd"
+	if configNeedsReload(a, b) {
+		t.Error("backup options change shouldn't require reload")
+	}
+	b.PITR.Enabled = true
+	if !configNeedsReload(a, b) {
+		t.Error("pitr options change should require reload")
+	}
+}
+
+func TestMongodLocationFor(t *testing.T) {
+	c := RestoreConf{
+		MongodLocation:    "/usr/bin/mongod",
+		MongodLocationMap: map[string]string{"rs0-1:27017": "/opt/host/mongod"},
+		MongodLocationTags: []MongodLocationTag{
+			{Tags: map[string]string{"arch": "arm64", "dc": "east"}, Path: "/opt/arm-east/mongod"},
+			{Tags: map[string]string{"arch": "arm64"}, Path: "/opt/arm/mongod"},
+		},
+	}
+
+	for _, tc := range []struct {
+		node string
+		tags map[string]string
+		want string
+	}{
+		{"rs0-1:27017", map[string]string{"arch": "arm64"}, "/opt/host/mongod"},
+		{"rs0-2:27017", map[string]string{"arch": "arm64", "dc": "east"}, "/opt/arm-east/mongod"},
+		{"rs0-3:27017", map[string]string{"arch": "arm64", "dc": "west"}, "/opt/arm/mongod"},
+		{"rs0-4:27017", map[string]string{"arch": "amd64"}, "/usr/bin/mongod"},
+		{"rs0-5:27017", nil, "/usr/bin/mongod"},
+	} {
+		if got := c.MongodLocationFor(tc.node, tc.tags); got != tc.want {
+			t.Errorf("%s %v: expected %s, got %s", tc.node, tc.tags, tc.want, got)
+		}
+	}
+
+	cfg := Config{Restore: RestoreConf{MongodLocationTags: []MongodLocationTag{{Path: "/opt/mongod"}}}}
+	if err := validateConfig(&cfg); err == nil {
+		t.Error("expected error on entry with no tags")
+	}
+}
+
+func TestSessionsDropRetry(t *testing.T) {
+	for _, tc := range []struct {
+		conf    RestoreConf
+		n       int
+		backoff time.Duration
+	}{
+		{RestoreConf{}, 5, time.Second},
+		{RestoreConf{SessionsDropRetries: 1, SessionsDropBackoffSec: 0.5}, 1, 500 * time.Millisecond},
+	} {
+		n, backoff := tc.conf.SessionsDropRetry()
+		if n != tc.n || backoff != tc.backoff {
+			t.Errorf("%+v: expected %d/%v, got %d/%v", tc.conf, tc.n, tc.backoff, n, backoff)
+		}
+	}
+
+	cfg := Config{Restore: RestoreConf{SessionsDropRetries: -1}}
+	if err := validateConfig(&cfg); err == nil {
+		t.Error("expected error on negative retries")
+	}
+}
+
+func TestPostFlushDelay(t *testing.T) {
+	if d := (RestoreConf{}).PostFlushDelay(); d != 0 {
+		t.Errorf("default: expected no delay, got %v", d)
+	}
+	if d := (RestoreConf{PostFlushDelaySec: 1.5}).PostFlushDelay(); d != 1500*time.Millisecond {
+		t.Errorf("expected 1.5s, got %v", d)
+	}
+
+	cfg := Config{Restore: RestoreConf{PostFlushDelaySec: -1}}
+	if err := validateConfig(&cfg); err == nil {
+		t.Error("expected error on negative delay")
+	}
+}
+
+// s3Endpoint is a MinIO-like path-style endpoint answering every request
+// with the status and recording the requested paths
+type s3Endpoint struct {
+	*httptest.Server
+	mu     sync.Mutex
+	paths  []string
+	status int
+}
+
+func newS3Endpoint(t *testing.T, status int) *s3Endpoint {
+	e := &s3Endpoint{status: status}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.paths = append(e.paths, r.URL.Path)
+		w.WriteHeader(e.status)
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func (e *s3Endpoint) requests() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.paths...)
+}
+
+func TestRestoreStorage(t *testing.T) {
+	def := newS3Endpoint(t, http.StatusNotFound)
+	minio := newS3Endpoint(t, http.StatusNotFound)
+
+	pathStyle := true
+	cfg := Config{
+		Storage: StorageConf{
+			Type: storage.S3,
+			S3: s3.Conf{
+				Region:      "us-east-1",
+				EndpointURL: def.URL,
+				Bucket:      "bcp",
+				Prefix:      "pbm",
+				Credentials: s3.Credentials{AccessKeyID: "k", SecretAccessKey: "s"},
+			},
+		},
+		Restore: RestoreConf{
+			S3: &RestoreS3Conf{EndpointURL: minio.URL, ForcePathStyle: &pathStyle},
+		},
+	}
+	if err := validateConfig(&cfg); err != nil {
+		t.Fatalf("validate config: %v", err)
+	}
+
+	sc := RestoreStorageConf(cfg)
+	if sc.S3.EndpointURL != minio.URL || sc.S3.ForcePathStyle == nil || !*sc.S3.ForcePathStyle {
+		t.Errorf("overrides aren't applied to the restore storage config: %+v", sc.S3)
+	}
+	if cfg.Storage.S3.EndpointURL != def.URL {
+		t.Errorf("the cluster's storage config is changed: %s", cfg.Storage.S3.EndpointURL)
+	}
+
+	stg, err := RestoreStorage(cfg, nil)
+	if err != nil {
+		t.Fatalf("restore storage: %v", err)
+	}
+	if err = CheckRestoreStorage(cfg, stg); err != nil {
+		t.Fatalf("check restore storage: %v", err)
+	}
+	if got := def.requests(); len(got) != 0 {
+		t.Errorf("unexpected requests to the default endpoint: %v", got)
+	}
+	if got := minio.requests(); len(got) != 1 || got[0] != "/bcp/pbm/"+StorInitFile {
+		t.Errorf("expected path-style request to the override endpoint, got %v", got)
+	}
+
+	minio.mu.Lock()
+	minio.status = http.StatusForbidden
+	minio.mu.Unlock()
+	if err = CheckRestoreStorage(cfg, stg); err == nil {
+		t.Error("expected error on the denied endpoint")
+	}
+
+	// the cluster's default storage is left intact
+	stg, err = Storage(cfg, nil)
+	if err != nil {
+		t.Fatalf("storage: %v", err)
+	}
+	if _, err = stg.FileStat(StorInitFile); err != storage.ErrNotExist {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if got := def.requests(); len(got) != 1 {
+		t.Errorf("expected request to the default endpoint, got %v", got)
+	}
+
+	cfg.Restore.S3.EndpointURL = "minio:9000"
+	if err := validateConfig(&cfg); err == nil {
+		t.Error("expected error on the relative endpoint URL")
+	}
+}
+
+func TestRestoreReadEndpointsConf(t *testing.T) {
+	cfg := Config{
+		Storage: StorageConf{
+			Type: storage.S3,
+			S3: s3.Conf{
+				Region:      "us-east-1",
+				EndpointURL: "https://s3.example.com",
+				Bucket:      "bcp",
+				Prefix:      "pbm",
+			},
+		},
+		Restore: RestoreConf{
+			S3: &RestoreS3Conf{
+				EndpointURL: "https://restore.example.com",
+				ReadEndpoints: []RestoreReadEndpoint{
+					{Region: "eu-west-1", Bucket: "bcp-replica"},
+					{EndpointURL: "https://dr.example.com"},
+				},
+			},
+		},
+	}
+	if err := validateConfig(&cfg); err != nil {
+		t.Fatalf("validate config: %v", err)
+	}
+
+	eps := RestoreReadEndpointsConf(cfg)
+	if len(eps) != 2 {
+		t.Fatalf("expected 2 endpoints, got %d", len(eps))
+	}
+	if e := eps[0]; e.EndpointURL != "https://restore.example.com" || e.Region != "eu-west-1" ||
+		e.Bucket != "bcp-replica" || e.Prefix != "pbm" {
+		t.Errorf("unexpected first endpoint: %+v", e)
+	}
+	if e := eps[1]; e.EndpointURL != "https://dr.example.com" || e.Region != "us-east-1" ||
+		e.Bucket != "bcp" || e.Prefix != "pbm" {
+		t.Errorf("unexpected second endpoint: %+v", e)
+	}
+
+	cfg.Restore.S3.ReadEndpoints = append(cfg.Restore.S3.ReadEndpoints, RestoreReadEndpoint{})
+	if err := validateConfig(&cfg); err == nil {
+		t.Error("expected error on the empty endpoint")
+	}
+	cfg.Restore.S3.ReadEndpoints = []RestoreReadEndpoint{{EndpointURL: "dr:9000"}}
+	if err := validateConfig(&cfg); err == nil {
+		t.Error("expected error on the relative endpoint URL")
+	}
+	cfg.Restore.S3.ReadEndpoints = []RestoreReadEndpoint{{Bucket: "b"}}
+	cfg.Storage = StorageConf{Type: storage.Filesystem}
+	if err := validateConfig(&cfg); err == nil {
+		t.Error("expected error on the non-S3 storage")
+	}
+}
+
+func TestReplLagConf(t *testing.T) {
+	if l := (BackupConf{}).MaxReplLag(); l != DefaultMaxReplLagSec {
+		t.Errorf("default: expected %d, got %d", DefaultMaxReplLagSec, l)
+	}
+	if d := (RestoreConf{ReplCatchUpTimeoutSec: 5}).ReplCatchUpTimeout(); d != 5*time.Second {
+		t.Errorf("expected 5s, got %v", d)
+	}
+
+	cfg := Config{Backup: BackupConf{MaxReplLagSec: -1}}
+	if err := validateConfig(&cfg); err == nil {
+		t.Error("expected error on negative max lag")
+	}
+}
+
+func TestUploadLimitConf(t *testing.T) {
+	cfg, err := applyConfigVar(Config{}, "uploadLimit.weights.backup", 3.0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lc := cfg.UploadLimit.LimiterConf()
+	want := map[storage.OpClass]float64{storage.OpPITR: DefaultUploadWeightPITR, storage.OpBackup: 3, storage.OpMeta: DefaultUploadWeightMeta}
+	if lc.MaxConcurrent != 0 || !reflect.DeepEqual(lc.Weights, want) {
+		t.Errorf("unexpected limiter conf %+v", lc)
+	}
+
+	cfg.UploadLimit.MaxMBps = 2
+	if lc := cfg.UploadLimit.LimiterConf(); lc.MaxBytesPerSec != 2<<20 {
+		t.Errorf("expected 2MB/s, got %d", lc.MaxBytesPerSec)
+	}
+
+	for _, c := range []UploadLimitConf{{MaxConcurrent: 2}, {MaxMBps: -1}, {Weights: &UploadWeights{PITR: -1}}} {
+		c := c
+		cfg := Config{UploadLimit: &c}
+		if err := validateConfig(&cfg); err == nil {
+			t.Errorf("expected error on %+v", c)
+		}
+	}
+}
+
+func TestRetryConf(t *testing.T) {
+	cfg, err := applyConfigVar(Config{}, "retry.maxBackoffSec", 1.5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tn := cfg.Retry.Tuning(); tn.MaxBackoff != 1500*time.Millisecond || tn.AttemptsFactor != 0 {
+		t.Errorf("unexpected tuning %+v", tn)
+	}
+	if tn := (*RetryConf)(nil).Tuning(); tn != (retry.Tuning{}) {
+		t.Errorf("expected zero tuning, got %+v", tn)
+	}
+
+	for _, c := range []RetryConf{{AttemptsFactor: -1}, {Jitter: 1}, {Jitter: -0.1}} {
+		c := c
+		cfg := Config{Retry: &c}
+		if err := validateConfig(&cfg); err == nil {
+			t.Errorf("expected error on %+v", c)
+		}
+	}
+}
+
+func TestBackupStorageConf(t *testing.T) {
+	s3conf := func(bucket, region, ep string) StorageConf {
+		return StorageConf{Type: storage.S3, S3: s3.Conf{Bucket: bucket, Region: region, EndpointURL: ep}}
+	}
+	cfg := Config{Storage: s3conf("b", "eu-west-1", "")}
+
+	cases := []struct {
+		name   string
+		store  StorageConf
+		rst    *RestoreS3Conf
+		region string
+		ep     string
+		used   bool
+	}{
+		{"same", s3conf("b", "eu-west-1", ""), nil, "eu-west-1", "", false},
+		{"region", s3conf("b", "us-west-2", ""), nil, "us-west-2", "", true},
+		{"endpoint", s3conf("b", "eu-west-1", "http://old"), nil, "eu-west-1", "http://old", true},
+		{"restore endpoint kept", s3conf("b", "us-west-2", "http://old"), &RestoreS3Conf{EndpointURL: "http://new"},
+			"us-west-2", "http://new", true},
+		{"other bucket", s3conf("old", "us-west-2", ""), nil, "eu-west-1", "", false},
+		{"other storage", StorageConf{Type: storage.Filesystem}, nil, "eu-west-1", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.Restore.S3 = c.rst
+			got, used := BackupStorageConf(cfg, &BackupMeta{Store: c.store})
+			if used != c.used || got.S3.Region != c.region || got.S3.EndpointURL != c.ep || got.S3.Bucket != "b" {
+				t.Errorf("got %v %+v, want %v region %q endpoint %q", used, got.S3, c.used, c.region, c.ep)
+			}
+		})
+	}
+}
+
+func TestGapBackupTypeConf(t *testing.T) {
+	if typ := (PITRConf{}).GapBcpType(); typ != LogicalBackup {
+		t.Errorf("default: expected %s, got %s", LogicalBackup, typ)
+	}
+	if typ := (PITRConf{GapBackupType: PhysicalBackup}).GapBcpType(); typ != PhysicalBackup {
+		t.Errorf("expected %s, got %s", PhysicalBackup, typ)
+	}
+
+	cfg := Config{PITR: PITRConf{GapBackupType: IncrementalBackup}}
+	if err := validateConfig(&cfg); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	cfg.PITR.GapBackupType = "external"
+	if err := validateConfig(&cfg); err == nil {
+		t.Error("expected error on unsupported gap backup type")
+	}
+}
@@ -0,0 +1,198 @@
+package pbm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultForecastDays = 7
+
+// CoverageObjective is the coverage objective a warning is about
+type CoverageObjective string
+
+const (
+	CoverageSnapshots CoverageObjective = "minSnapshots"
+	CoveragePITR      CoverageObjective = "minPITRDays"
+	// CoverageIncrBase is the base of the latest incremental chain
+	// which has to outlive its increments
+	CoverageIncrBase CoverageObjective = "incrementalBase"
+)
+
+// CoverageWarning is a forecasted violation of the coverage objectives
+type CoverageWarning struct {
+	Objective CoverageObjective `json:"objective"`
+	// InDays is the number of days from now when the objective gets
+	// violated. Zero means it's already violated.
+	InDays int    `json:"inDays"`
+	Msg    string `json:"msg"`
+}
+
+func (w CoverageWarning) String() string {
+	if w.InDays == 0 {
+		return "now: " + w.Msg
+	}
+	return fmt.Sprintf("in %d day(s): %s", w.InDays, w.Msg)
+}
+
+// Coverage is the restorable state at some point in time
+type Coverage struct {
+	// Snapshots is the number of restorable full snapshots
+	Snapshots int
+	// PITR is the total length of the restorable PITR timelines
+	PITR time.Duration
+}
+
+// CoverageAt returns what remains restorable at `t` if data older than
+// `keepDays` gets deleted by then and no new backups are made. It's a rough
+// model of the retention. Unlike the actual clean-up, it doesn't keep base
+// snapshots still needed by PITR, so it errs on the safe side.
+func CoverageAt(t time.Time, keepDays int, bcps []BackupMeta, tlns []Timeline) Coverage {
+	var cutoff uint32
+	if keepDays > 0 {
+		c := t.Add(-time.Duration(keepDays) * 24 * time.Hour).Unix()
+		if c > 0 {
+			cutoff = uint32(c)
+		}
+	}
+
+	retained := make(map[string]*BackupMeta, len(bcps))
+	for i := range bcps {
+		b := &bcps[i]
+		if b.Status == StatusDone && b.LastWriteTS.T >= cutoff {
+			retained[b.Name] = b
+		}
+	}
+
+	var cv Coverage
+	for _, b := range retained {
+		if len(b.Namespaces) != 0 {
+			continue
+		}
+		if _, err := bcpChain(b, retained); err != nil {
+			continue
+		}
+		cv.Snapshots++
+	}
+
+	for _, tl := range tlns {
+		start := tl.Start
+		if start < cutoff {
+			start = cutoff
+		}
+
+		// PITR needs a logical base snapshot within the timeline
+		var base uint32
+		for _, b := range retained {
+			if len(b.Namespaces) != 0 || (b.Type != LogicalBackup && b.Type != "") {
+				continue
+			}
+			lw := b.LastWriteTS.T
+			if lw < start || lw > tl.End {
+				continue
+			}
+			if base == 0 || lw < base {
+				base = lw
+			}
+		}
+		if base != 0 {
+			cv.PITR += time.Duration(tl.End-base) * time.Second
+		}
+	}
+
+	return cv
+}
+
+// ForecastCoverage checks the coverage for each of the next
+// conf.ForecastDays days against the objectives and returns warnings
+// about the first violation of each of them.
+func ForecastCoverage(now time.Time, bcps []BackupMeta, tlns []Timeline, conf RetentionConf) []CoverageWarning {
+	days := conf.ForecastDays
+	if days == 0 {
+		days = defaultForecastDays
+	}
+	// nothing expires with no retention
+	if conf.KeepDays == 0 {
+		days = 0
+	}
+
+	var lastIncr *BackupMeta
+	for i := range bcps {
+		b := &bcps[i]
+		if b.Type == IncrementalBackup && b.Status == StatusDone &&
+			(lastIncr == nil || b.LastWriteTS.T > lastIncr.LastWriteTS.T) {
+			lastIncr = b
+		}
+	}
+
+	var rv []CoverageWarning
+	var snapshots, pitr, chain bool
+	for d := 0; d <= days; d++ {
+		t := now.Add(time.Duration(d) * 24 * time.Hour)
+		cv := CoverageAt(t, conf.KeepDays, bcps, tlns)
+
+		if !snapshots && conf.MinSnapshots > 0 && cv.Snapshots < conf.MinSnapshots {
+			snapshots = true
+			rv = append(rv, CoverageWarning{Objective: CoverageSnapshots, InDays: d, Msg: fmt.Sprintf("%d restorable snapshot(s) left, the objective is %d",
+				cv.Snapshots, conf.MinSnapshots)})
+		}
+		if !pitr && conf.MinPITRDays > 0 && cv.PITR.Hours()/24 < conf.MinPITRDays {
+			pitr = true
+			rv = append(rv, CoverageWarning{Objective: CoveragePITR, InDays: d, Msg: fmt.Sprintf("PITR window is %.1f day(s), the objective is %.1f",
+				cv.PITR.Hours()/24, conf.MinPITRDays)})
+		}
+		if !chain && lastIncr != nil && d > 0 && incrChainExpired(lastIncr, t, conf.KeepDays, bcps) {
+			chain = true
+			rv = append(rv, CoverageWarning{Objective: CoverageIncrBase, InDays: d, Msg: fmt.Sprintf("the base of the latest incremental backup %s expires",
+				lastIncr.Name)})
+		}
+	}
+
+	return rv
+}
+
+// CheckCoverage forecasts the coverage of the current backups and PITR
+// timelines (see ForecastCoverage). It returns nothing if neither
+// the retention nor the objectives are set.
+func (p *PBM) CheckCoverage(now time.Time, conf RetentionConf) ([]CoverageWarning, error) {
+	if conf.MinSnapshots == 0 && conf.MinPITRDays == 0 && conf.KeepDays == 0 {
+		return nil, nil
+	}
+
+	bcps, err := p.BackupsDoneList(nil, 0, -1)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backups list")
+	}
+	tlns, err := p.PITRTimelines()
+	if err != nil {
+		return nil, errors.Wrap(err, "get PITR timelines")
+	}
+
+	return ForecastCoverage(now, bcps, tlns, conf), nil
+}
+
+// incrChainExpired tells if any backup of the incremental chain
+// is expired at `t` while the increment itself is not
+func incrChainExpired(b *BackupMeta, t time.Time, keepDays int, bcps []BackupMeta) bool {
+	cutoff := t.Add(-time.Duration(keepDays) * 24 * time.Hour).Unix()
+	if int64(b.LastWriteTS.T) < cutoff {
+		return false
+	}
+
+	all := make(map[string]*BackupMeta, len(bcps))
+	for i := range bcps {
+		all[bcps[i].Name] = &bcps[i]
+	}
+	chain, err := bcpChain(b, all)
+	if err != nil {
+		return false
+	}
+	for _, n := range chain {
+		if int64(all[n].LastWriteTS.T) < cutoff {
+			return true
+		}
+	}
+
+	return false
+}
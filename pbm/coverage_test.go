@@ -0,0 +1,49 @@
+package pbm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestForecastCoverage(t *testing.T) {
+	const day = 24 * 60 * 60
+	now := time.Unix(100*day, 0)
+	ago := func(d uint32) primitive.Timestamp { return primitive.Timestamp{T: 100*day - d*day} }
+
+	bcps := []BackupMeta{
+		{Name: "l1", Type: LogicalBackup, Status: StatusDone, LastWriteTS: ago(9)},
+		{Name: "i1", Type: IncrementalBackup, Status: StatusDone, LastWriteTS: ago(6)},
+		{Name: "s1", Type: LogicalBackup, Status: StatusDone, LastWriteTS: ago(4), Namespaces: []string{"db.c"}},
+		{Name: "l2", Type: LogicalBackup, Status: StatusDone, LastWriteTS: ago(3)},
+		{Name: "i2", Type: IncrementalBackup, Status: StatusDone, LastWriteTS: ago(1), SrcBackup: "i1"},
+	}
+	tlns := []Timeline{{Start: ago(9).T, End: ago(0).T}}
+
+	cv := CoverageAt(now, 10, bcps, tlns)
+	if cv.Snapshots != 4 || cv.PITR != 9*day*time.Second {
+		t.Errorf("unexpected coverage now: %+v", cv)
+	}
+
+	conf := RetentionConf{KeepDays: 10, MinSnapshots: 3, MinPITRDays: 8}
+	got := ForecastCoverage(now, bcps, tlns, conf)
+	want := []CoverageWarning{
+		{Objective: CoveragePITR, InDays: 2, Msg: "PITR window is 3.0 day(s), the objective is 8.0"},
+		{Objective: CoverageSnapshots, InDays: 5, Msg: "1 restorable snapshot(s) left, the objective is 3"},
+		{Objective: CoverageIncrBase, InDays: 5, Msg: "the base of the latest incremental backup i2 expires"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// only the current state is checked without retention
+	conf.KeepDays = 0
+	conf.MinSnapshots = 5
+	got = ForecastCoverage(now, bcps, tlns, conf)
+	want = []CoverageWarning{{Objective: CoverageSnapshots, InDays: 0, Msg: "4 restorable snapshot(s) left, the objective is 5"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("no retention: expected %v, got %v", want, got)
+	}
+}
@@ -1,7 +1,7 @@
 package pbm
 
 import (
-	"fmt"
+	"path"
 	"time"
 
 	"github.com/pkg/errors"
@@ -14,6 +14,41 @@ import (
 	"github.com/percona/percona-backup-mongodb/version"
 )
 
+// ErrBackupProtected means the backup, or an incremental backup based on it,
+// is protected from deletion (see BackupMeta.Protected)
+var ErrBackupProtected = errors.New("backup is protected")
+
+// SetBackupProtected protects the backup from deletion or lifts the
+// protection. The flag is saved in the backup metadata on the storage too,
+// so it survives the resync.
+func (p *PBM) SetBackupProtected(name string, protected bool) error {
+	meta, err := p.GetBackupMeta(name)
+	if err != nil {
+		return errors.Wrap(err, "get backup meta")
+	}
+	if protected && meta.Status != StatusDone {
+		return errors.Errorf("unable to protect backup in %s state", meta.Status)
+	}
+
+	stg, err := p.GetStorage(nil)
+	if err != nil {
+		return errors.Wrap(err, "get storage")
+	}
+
+	meta.Protected = protected
+	err = writeBackupMeta(stg, meta)
+	if err != nil {
+		return errors.Wrap(err, "write metadata to storage")
+	}
+
+	_, err = p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"protected": protected}}},
+	)
+	return errors.Wrap(err, "write metadata to db")
+}
+
 // DeleteBackup deletes backup with the given name from the current storage
 // and pbm database
 func (p *PBM) DeleteBackup(name string, l *log.Event) error {
@@ -47,7 +82,7 @@ func (p *PBM) DeleteBackup(name string, l *log.Event) error {
 		return errors.Wrap(err, "delete metadata from db")
 	}
 
-	return nil
+	return p.deleteBackupEvents(meta.Name)
 }
 
 func (p *PBM) probeDelete(backup *BackupMeta, tlns []Timeline) error {
@@ -58,6 +93,17 @@ func (p *PBM) probeDelete(backup *BackupMeta, tlns []Timeline) error {
 		return errors.Errorf("unable to delete backup in %s state", backup.Status)
 	}
 
+	protected, err := listProtected(p.ctx, p.Conn)
+	if err != nil {
+		return errors.Wrap(err, "get protected backups")
+	}
+	if by, ok := protected[backup.Name]; ok {
+		if by == backup.Name {
+			return errors.Wrap(ErrBackupProtected, "unable to delete")
+		}
+		return errors.Wrapf(ErrBackupProtected, "unable to delete: incremental backup %s is based on it", by)
+	}
+
 	// if backup isn't a base for any PITR timeline
 	for _, t := range tlns {
 		if backup.LastWriteTS.T == t.Start {
@@ -85,8 +131,15 @@ func (p *PBM) probeDelete(backup *BackupMeta, tlns []Timeline) error {
 	return nil
 }
 
-// DeleteBackupFiles removes backup's artifacts from storage
+// DeleteBackupFiles removes backup's artifacts from storage.
+// It deletes nothing and returns storage.ErrLocked if any of the files
+// is under the retention or the legal hold.
 func (p *PBM) DeleteBackupFiles(meta *BackupMeta, stg storage.Storage) (err error) {
+	err = checkBackupUnlocked(meta, stg)
+	if err != nil {
+		return err
+	}
+
 	switch meta.Type {
 	case PhysicalBackup, IncrementalBackup:
 		return p.deletePhysicalBackupFiles(meta, stg)
@@ -103,24 +156,42 @@ func (p *PBM) DeleteBackupFiles(meta *BackupMeta, stg storage.Storage) (err erro
 	}
 }
 
+// checkBackupUnlocked returns storage.ErrLocked if any of the backup files
+// can't be deleted because of the storage object lock
+func checkBackupUnlocked(meta *BackupMeta, stg storage.Storage) error {
+	if _, ok := storage.AsLockChecker(stg); !ok {
+		return nil
+	}
+
+	names := []string{meta.Name + MetadataFileSuffix}
+	if version.IsLegacyArchive(meta.PBMVersion) {
+		for _, r := range meta.Replsets {
+			names = append(names, r.OplogName, r.DumpName)
+		}
+	}
+	files, err := stg.List(meta.Name+"/", "")
+	if err != nil {
+		return errors.Wrap(err, "list backup files")
+	}
+	for _, f := range files {
+		names = append(names, path.Join(meta.Name, f.Name))
+	}
+
+	return storage.CheckUnlocked(stg, names...)
+}
+
 // DeleteBackupFiles removes backup's artifacts from storage
 func (p *PBM) deletePhysicalBackupFiles(meta *BackupMeta, stg storage.Storage) (err error) {
 	for _, r := range meta.Replsets {
 		for _, f := range r.Files {
-			fname := meta.Name + "/" + r.Name + "/" + f.Name + meta.Compression.Suffix()
-			if f.Len != 0 {
-				fname += fmt.Sprintf(".%d-%d", f.Off, f.Len)
-			}
+			fname := meta.Layout.FilePath(meta.Name, r.Name, f, meta.Compression)
 			err = stg.Delete(fname)
 			if err != nil && err != storage.ErrNotExist {
 				return errors.Wrapf(err, "delete %s", fname)
 			}
 		}
 		for _, f := range r.Journal {
-			fname := meta.Name + "/" + r.Name + "/" + f.Name + meta.Compression.Suffix()
-			if f.Len != 0 {
-				fname += fmt.Sprintf(".%d-%d", f.Off, f.Len)
-			}
+			fname := meta.Layout.FilePath(meta.Name, r.Name, f, meta.Compression)
 			err = stg.Delete(fname)
 			if err != nil && err != storage.ErrNotExist {
 				return errors.Wrapf(err, "delete %s", fname)
@@ -230,6 +301,10 @@ func (p *PBM) DeleteOlderThan(t time.Time, l *log.Event) error {
 		}
 
 		err = p.DeleteBackupFiles(m, stg)
+		if errors.Is(err, storage.ErrLocked) {
+			l.Info("deleting %s: %v", m.Name, err)
+			continue
+		}
 		if err != nil {
 			return errors.Wrap(err, "delete backup files from storage")
 		}
@@ -238,6 +313,10 @@ func (p *PBM) DeleteOlderThan(t time.Time, l *log.Event) error {
 		if err != nil {
 			return errors.Wrap(err, "delete backup meta from db")
 		}
+		err = p.deleteBackupEvents(m.Name)
+		if err != nil {
+			return err
+		}
 	}
 
 	if cur.Err() != nil {
@@ -293,6 +372,15 @@ func (p *PBM) deleteChunks(start, until primitive.Timestamp, stg storage.Storage
 	}
 
 	for _, chnk := range chunks {
+		err = storage.CheckUnlocked(stg, chnk.FName)
+		if errors.Is(err, storage.ErrLocked) {
+			l.Info("skip pitr chunk: %v", err)
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "check pitr chunk '%s' lock", chnk.FName)
+		}
+
 		err = stg.Delete(chnk.FName)
 		if err != nil && err != storage.ErrNotExist {
 			return errors.Wrapf(err, "delete pitr chunk '%s' (%v) from storage", chnk.FName, chnk)
@@ -0,0 +1,112 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxConditions is how many of the latest conditions the backup and
+// restore metadata (and their replsets) keep. Operations retrying many
+// times would otherwise bloat the documents toward the 16MB limit.
+// The full history is in EventsCollection.
+const MaxConditions = 32
+
+// EventOp is the type of the operation an event belongs to
+type EventOp string
+
+const (
+	EventBackup  EventOp = "backup"
+	EventRestore EventOp = "restore"
+)
+
+// Event is a state transition of the backup or restore, or of one of
+// their replsets
+type Event struct {
+	Op   EventOp `bson:"op" json:"op"`
+	Name string  `bson:"name" json:"name"`
+	// RS is empty for the transitions of the operation itself
+	RS string `bson:"rs,omitempty" json:"rs,omitempty"`
+
+	Condition `bson:",inline"`
+}
+
+// pushCondition returns the update appending the condition to the array
+// and keeping only the latest MaxConditions of it
+func pushCondition(field string, c Condition) bson.E {
+	return bson.E{"$push", bson.M{field: bson.M{
+		"$each":  bson.A{c},
+		"$slice": -MaxConditions,
+	}}}
+}
+
+// noCondition returns the query matching the conditions array without
+// the condition of the same time and status
+func noCondition(c Condition) bson.M {
+	return bson.M{"$not": bson.M{"$elemMatch": bson.M{"timestamp": c.Timestamp, "status": c.Status}}}
+}
+
+// addEvent records the state transition. The events are the history
+// only, the transition is already in the metadata. So a failed write
+// is logged and doesn't fail the transition.
+func (p *PBM) addEvent(op EventOp, name, rs string, c Condition) {
+	_, err := p.Conn.Database(DB).Collection(EventsCollection).InsertOne(p.ctx,
+		Event{Op: op, Name: name, RS: rs, Condition: c})
+	if err != nil && p.log != nil {
+		p.log.Warning(string(op), name, "", primitive.Timestamp{}, "add %s event: %v", c.Status, err)
+	}
+}
+
+// deleteBackupEvents deletes the events of the backup and of the restores
+// made from it
+func (p *PBM) deleteBackupEvents(bcpName string) error {
+	_, err := p.Conn.Database(DB).Collection(EventsCollection).DeleteMany(p.ctx,
+		bson.D{{"op", EventBackup}, {"name", bcpName}})
+	if err != nil {
+		return errors.Wrap(err, "delete backup events")
+	}
+
+	rsts, err := p.Conn.Database(DB).Collection(RestoresCollection).Distinct(p.ctx,
+		"name", bson.D{{"backup", bcpName}})
+	if err != nil {
+		return errors.Wrap(err, "get restores of the backup")
+	}
+	if len(rsts) == 0 {
+		return nil
+	}
+
+	_, err = p.Conn.Database(DB).Collection(EventsCollection).DeleteMany(p.ctx,
+		bson.D{{"op", EventRestore}, {"name", bson.M{"$in": rsts}}})
+	return errors.Wrap(err, "delete restore events")
+}
+
+// Events returns the full history of the operation's state transitions,
+// oldest first. Operations made by older versions have no events, only
+// the conditions in their metadata.
+func (p *PBM) Events(op EventOp, name string) ([]Event, error) {
+	cur, err := p.Conn.Database(DB).Collection(EventsCollection).Find(
+		p.ctx,
+		bson.D{{"op", op}, {"name", name}},
+		options.Find().SetSort(bson.D{{"timestamp", 1}, {"_id", 1}}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var evs []Event
+	err = cur.All(p.ctx, &evs)
+	return evs, errors.Wrap(err, "decode")
+}
+
+// EventsConditions returns the conditions of the operation (`rs` is empty)
+// or its replset out of the events
+func EventsConditions(evs []Event, rs string) []Condition {
+	var rv []Condition
+	for _, e := range evs {
+		if e.RS == rs {
+			rv = append(rv, e.Condition)
+		}
+	}
+	return rv
+}
@@ -0,0 +1,37 @@
+package pbm
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestEventsConditions(t *testing.T) {
+	evs := []Event{
+		{Op: EventBackup, Name: "b", Condition: Condition{Timestamp: 1, Status: StatusStarting}},
+		{Op: EventBackup, Name: "b", RS: "rs0", Condition: Condition{Timestamp: 2, Status: StatusRunning}},
+		{Op: EventBackup, Name: "b", Condition: Condition{Timestamp: 3, Status: StatusRunning}},
+		{Op: EventBackup, Name: "b", RS: "rs0", Condition: Condition{Timestamp: 4, Status: StatusError, Error: "e"}},
+	}
+
+	got := EventsConditions(evs, "")
+	want := []Condition{{Timestamp: 1, Status: StatusStarting}, {Timestamp: 3, Status: StatusRunning}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("backup: got %v, want %v", got, want)
+	}
+
+	got = EventsConditions(evs, "rs0")
+	want = []Condition{{Timestamp: 2, Status: StatusRunning}, {Timestamp: 4, Status: StatusError, Error: "e"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rs0: got %v, want %v", got, want)
+	}
+}
+
+func TestPushCondition(t *testing.T) {
+	u := pushCondition("conditions", Condition{Timestamp: 1, Status: StatusDone})
+	m, ok := u.Value.(bson.M)["conditions"].(bson.M)
+	if u.Key != "$push" || !ok || m["$slice"] != -MaxConditions {
+		t.Errorf("got %v", u)
+	}
+}
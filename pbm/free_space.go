@@ -0,0 +1,6 @@
+package pbm
+
+import "github.com/pkg/errors"
+
+// ErrFreeSpaceUnsupported means the free space can't be checked on this OS
+var ErrFreeSpaceUnsupported = errors.New("free space check is not supported on this OS")
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package pbm
+
+// FreeSpace returns the space available to the unprivileged user
+// on the volume of the path, in bytes
+func FreeSpace(string) (int64, error) {
+	return 0, ErrFreeSpaceUnsupported
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package pbm
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// FreeSpace returns the space available to the unprivileged user
+// on the volume of the path, in bytes
+func FreeSpace(path string) (int64, error) {
+	var st unix.Statfs_t
+	err := unix.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
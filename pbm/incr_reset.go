@@ -0,0 +1,40 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IncrReset marks the data as physically restored. WiredTiger doesn't
+// carry the incremental backup history over the restore, so the next
+// incremental backup has to be a base one.
+type IncrReset struct {
+	Restore string `bson:"restore" json:"restore"`
+	Backup  string `bson:"backup" json:"backup"`
+	TS      int64  `bson:"ts" json:"ts"`
+}
+
+// GetIncrReset returns the mark of the physical restore the incremental
+// backup history was reset by and nil if there is none.
+func (p *PBM) GetIncrReset() (*IncrReset, error) {
+	res := p.Conn.Database(DB).Collection(IncrResetCollection).FindOne(p.ctx, bson.D{})
+	if res.Err() != nil {
+		if res.Err() == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, errors.Wrap(res.Err(), "get")
+	}
+
+	r := &IncrReset{}
+	err := res.Decode(r)
+	return r, errors.Wrap(err, "decode")
+}
+
+// ClearIncrReset removes the marks made before `ts` (unix time), i.e.
+// the ones the base backup started at `ts` has resolved.
+func (p *PBM) ClearIncrReset(ts int64) error {
+	_, err := p.Conn.Database(DB).Collection(IncrResetCollection).DeleteMany(p.ctx,
+		bson.D{{"ts", bson.M{"$lt": ts}}})
+	return errors.Wrap(err, "delete")
+}
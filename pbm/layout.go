@@ -0,0 +1,102 @@
+package pbm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+)
+
+// StorageLayout defines how the data objects of physical backups are
+// placed on the storage. Metadata and sync files are always flat.
+type StorageLayout string
+
+const (
+	// LayoutFlat puts all data objects of the replset under one prefix:
+	// <backup>/<replset>/<file>
+	LayoutFlat StorageLayout = "flat"
+	// LayoutHashed spreads data objects among hash-bucket sub-prefixes:
+	// <backup>/<bucket>/<replset>/<file>. So S3-compatible stores with
+	// per-prefix request rate limits don't throttle large backups.
+	LayoutHashed StorageLayout = "hashed"
+)
+
+const (
+	// DefaultLayoutBuckets is the number of hash buckets of the hashed layout
+	DefaultLayoutBuckets = 16
+	// MaxLayoutBuckets is the max number of hash buckets of the hashed layout
+	MaxLayoutBuckets = 256
+)
+
+func IsValidStorageLayout(s string) bool {
+	switch StorageLayout(s) {
+	case "", LayoutFlat, LayoutHashed:
+		return true
+	}
+
+	return false
+}
+
+func validateLayoutBuckets(n int) error {
+	if n < 0 || n > MaxLayoutBuckets {
+		return errors.Errorf("should be in the range [0, %d]", MaxLayoutBuckets)
+	}
+	return nil
+}
+
+// BackupLayout is the layout the backup's data objects were saved with.
+// Restores resolve the objects with it regardless of the current config.
+type BackupLayout struct {
+	Type    StorageLayout `bson:"type" json:"type"`
+	Buckets int           `bson:"buckets,omitempty" json:"buckets,omitempty"`
+}
+
+// Layout returns the layout new backups are made with
+func (s *StorageConf) Layout() *BackupLayout {
+	if s.LayoutType != LayoutHashed {
+		return nil
+	}
+
+	l := &BackupLayout{Type: LayoutHashed, Buckets: s.LayoutBuckets}
+	if l.Buckets == 0 {
+		l.Buckets = DefaultLayoutBuckets
+	}
+	return l
+}
+
+// Path returns the storage path of the file `name` of the replset `rs` in
+// the backup `bcp`. Any suffixes (compression, chunk range) are to be
+// appended to it. All chunks of the file land in the same bucket.
+// Nil layout is the flat one.
+func (l *BackupLayout) Path(bcp, rs, name string) string {
+	if l == nil || l.Type != LayoutHashed || l.Buckets <= 0 {
+		return path.Join(bcp, rs, name)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return path.Join(bcp, fmt.Sprintf("%02x", h.Sum32()%uint32(l.Buckets)), rs, name)
+}
+
+// FilePath returns the storage path of the object of the file `f` (or of
+// its chunk for the incremental backups) compressed with `c`
+func (l *BackupLayout) FilePath(bcp, rs string, f File, c compress.CompressionType) string {
+	name := l.Path(bcp, rs, f.Name) + c.Suffix()
+	if f.Len != 0 {
+		name += fmt.Sprintf(".%d-%d", f.Off, f.Len)
+	}
+	return name
+}
+
+func (l *BackupLayout) String() string {
+	if l == nil || l.Type == "" {
+		return string(LayoutFlat)
+	}
+	if l.Type == LayoutHashed {
+		return fmt.Sprintf("%s (%d buckets)", l.Type, l.Buckets)
+	}
+	return string(l.Type)
+}
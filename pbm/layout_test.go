@@ -0,0 +1,40 @@
+package pbm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBackupLayoutPath(t *testing.T) {
+	var flat *BackupLayout
+	if p := flat.Path("b0", "rs0", "db/collection-1.wt"); p != "b0/rs0/db/collection-1.wt" {
+		t.Errorf("flat: unexpected path %s", p)
+	}
+
+	l := (&StorageConf{LayoutType: LayoutHashed}).Layout()
+	if l == nil || l.Buckets != DefaultLayoutBuckets {
+		t.Fatalf("unexpected hashed layout %v", l)
+	}
+
+	buckets := make(map[string]struct{})
+	for _, name := range []string{"WiredTiger", "journal/WiredTigerLog.0000000001",
+		"db/collection-1.wt", "db/collection-2.wt", "db/index-3.wt", "sizeStorer.wt"} {
+		p := l.Path("b0", "rs0", name)
+		if p != l.Path("b0", "rs0", name) {
+			t.Errorf("%s: path isn't stable", name)
+		}
+		parts := strings.SplitN(p, "/", 4)
+		if len(parts) != 4 || parts[0] != "b0" || parts[2] != "rs0" || parts[3] != name {
+			t.Errorf("%s: unexpected path %s", name, p)
+			continue
+		}
+		buckets[parts[1]] = struct{}{}
+	}
+	if len(buckets) < 2 {
+		t.Errorf("files aren't spread among buckets: %v", buckets)
+	}
+
+	if (&StorageConf{LayoutType: LayoutFlat}).Layout() != nil {
+		t.Error("flat layout is expected to be nil")
+	}
+}
@@ -38,6 +38,8 @@ type Lock struct {
 	cancel   context.CancelFunc
 	hbRate   time.Duration
 	staleSec uint32
+	// logged means the lock has the op log entry to finish on release
+	logged bool
 }
 
 // NewLock creates a new Lock object from geven header. Returned lock has no state.
@@ -117,7 +119,7 @@ func (l *Lock) try(old *LockHeader) (got bool, err error) {
 		// log the operation. duplicate means error
 		err := l.log()
 		if err != nil {
-			rerr := l.Release()
+			rerr := l.release()
 			if rerr != nil {
 				err = errors.Errorf("%v. Also failed to release the lock: %v", err, rerr)
 			}
@@ -149,6 +151,14 @@ func (l *Lock) try(old *LockHeader) (got bool, err error) {
 	if err != nil {
 		return false, errors.Wrap(err, "delete stale lock")
 	}
+	// the owner won't release the lock, so close its op log entry
+	// as of the last heartbeat
+	if peer.Type != CmdPITR {
+		err = l.p.finishOpLog(peer.LockHeader, peer.Heartbeat, OpStale)
+		if err != nil {
+			l.p.log.Warning(string(peer.Type), "", peer.OPID, primitive.Timestamp{}, "finish op log entry of the stale lock: %v", err)
+		}
+	}
 
 	return false, ErrWasStaleLock{Lock: peer.LockHeader}
 }
@@ -171,8 +181,10 @@ func (l *Lock) log() error {
 		return nil
 	}
 
-	_, err := l.p.Conn.Database(DB).Collection(PBMOpLogCollection).InsertOne(l.p.Context(), l.LockHeader)
+	_, err := l.p.Conn.Database(DB).Collection(PBMOpLogCollection).InsertOne(l.p.Context(),
+		OpLog{LockHeader: l.LockHeader, StartTS: l.Heartbeat})
 	if err == nil {
+		l.logged = true
 		return nil
 	}
 	if strings.Contains(err.Error(), "E11000 duplicate key error") {
@@ -212,8 +224,32 @@ func (p *PBM) MarkRestoreStale(opid string) error {
 	return p.ChangeRestoreStateOPID(opid, StatusError, "some of pbm-agents were lost during the restore")
 }
 
-// Release the lock
+// Release the lock and mark the operation as done in the op log
 func (l *Lock) Release() error {
+	return l.ReleaseWith(nil)
+}
+
+// ReleaseWith releases the lock and sets the operation outcome in the op
+// log: failed if `opErr` isn't nil and done otherwise
+func (l *Lock) ReleaseWith(opErr error) error {
+	err := l.release()
+	if err != nil || !l.logged {
+		return err
+	}
+	l.logged = false
+
+	outcome := OpDone
+	if opErr != nil {
+		outcome = OpFailed
+	}
+	ts, err := l.p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "read cluster time")
+	}
+	return errors.Wrap(l.p.finishOpLog(l.LockHeader, ts, outcome), "finish op log entry")
+}
+
+func (l *Lock) release() error {
 	if l.cancel != nil {
 		l.cancel()
 	}
@@ -12,6 +12,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readconcern"
+
+	"github.com/percona/percona-backup-mongodb/pbm/retry"
 )
 
 type Node struct {
@@ -202,6 +204,19 @@ func getFeatureCompatibilityVersion(ctx context.Context, m *mongo.Client) (strin
 	return ver.FeatureCompatibilityVersion.Version, nil
 }
 
+// PID returns the process id of the node's mongod
+func (n *Node) PID() (int, error) {
+	var stat struct {
+		PID int64 `bson:"pid"`
+	}
+	err := n.cn.Database("admin").RunCommand(n.ctx, bson.D{{"serverStatus", 1}}).Decode(&stat)
+	if err != nil {
+		return 0, errors.Wrap(err, "get serverStatus")
+	}
+
+	return int(stat.PID), nil
+}
+
 func (n *Node) GetReplsetStatus() (*ReplsetStatus, error) {
 	return GetReplsetStatus(n.ctx, n.cn)
 }
@@ -296,21 +311,17 @@ func DropTMPcoll(ctx context.Context, cn *mongo.Client) error {
 	return nil
 }
 
-func (n *Node) WaitForWrite(ts primitive.Timestamp) (err error) {
-	var lw primitive.Timestamp
-	for i := 0; i < 21; i++ {
-		lw, err = LastWrite(n.cn, false)
-		if err == nil && primitive.CompareTimestamp(lw, ts) >= 0 {
-			return nil
+func (n *Node) WaitForWrite(ts primitive.Timestamp) error {
+	return retry.Do(n.ctx, retry.Policy{Attempts: 21, Backoff: time.Second}, func() error {
+		lw, err := LastWrite(n.cn, false)
+		if err != nil {
+			return err
 		}
-		time.Sleep(time.Second * 1)
-	}
-
-	if err != nil {
-		return err
-	}
-
-	return errors.New("run out of time")
+		if primitive.CompareTimestamp(lw, ts) < 0 {
+			return errors.New("run out of time")
+		}
+		return nil
+	})
 }
 
 func LastWrite(cn *mongo.Client, majority bool) (primitive.Timestamp, error) {
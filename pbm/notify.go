@@ -0,0 +1,114 @@
+package pbm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NotifyConf is the configuration of the events notifications
+type NotifyConf struct {
+	// Webhook is the URL events are POSTed to as JSON (see Notification).
+	// Empty means no notifications are sent.
+	Webhook    string  `bson:"webhook,omitempty" json:"webhook,omitempty" yaml:"webhook,omitempty"`
+	TimeoutSec float64 `bson:"timeoutSec,omitempty" json:"timeoutSec,omitempty" yaml:"timeoutSec,omitempty"`
+}
+
+func validateWebhook(u string) error {
+	if u == "" {
+		return nil
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if pu.Scheme != "http" && pu.Scheme != "https" || pu.Host == "" {
+		return errors.Errorf("%q should be an http(s) URL", u)
+	}
+	return nil
+}
+
+const defaultNotifyTimeout = 10 * time.Second
+
+func (c NotifyConf) timeout() time.Duration {
+	if c.TimeoutSec <= 0 {
+		return defaultNotifyTimeout
+	}
+	return time.Duration(c.TimeoutSec * float64(time.Second))
+}
+
+// NotifyEvent is the type of the notification
+type NotifyEvent string
+
+const (
+	// NotifyRestoreFinished is sent by the restore leader once the restore
+	// reached the final state. Data is the RestoreReport.
+	NotifyRestoreFinished NotifyEvent = "restore.finished"
+	// NotifyPITRGapRisk is sent by the PITR slicer when the oplog is about
+	// to roll over the entries it hasn't saved yet. Data is the PITRGapRisk.
+	NotifyPITRGapRisk NotifyEvent = "pitr.gap_risk"
+	// NotifyPITRGap is sent by the PITR slicer once the oplog rolled over
+	// the entries it hasn't saved. Data is the PITRGap.
+	NotifyPITRGap NotifyEvent = "pitr.gap"
+	// NotifyRestoreSlowNode is sent by the physical restore leader when
+	// a node copies much slower than the rest of the cluster for a while
+	// (see RestoreConf.SlowNodeRateFraction). Data is the NodeProgress.
+	NotifyRestoreSlowNode NotifyEvent = "restore.slow_node"
+	// NotifyBackupLowCompression is sent by the backup leader if
+	// the compression ratio of the backup is below
+	// BackupConf.MinCompressionRatio. Data is the LowCompression.
+	NotifyBackupLowCompression NotifyEvent = "backup.low_compression"
+	// NotifyCoverageRisk is sent by the cluster leader agent once
+	// the forecast of the coverage objectives under the retention policy
+	// (see RetentionConf) gets new violations. Data is the list of
+	// CoverageWarning.
+	NotifyCoverageRisk NotifyEvent = "retention.coverage_risk"
+)
+
+// Notification is the body of the webhook request
+type Notification struct {
+	Event NotifyEvent `json:"event"`
+	Time  int64       `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// Notify sends the event to the configured webhook. It's a no-op if there
+// is no webhook. Any response status other than 2xx is an error.
+func Notify(ctx context.Context, cfg NotifyConf, event NotifyEvent, data interface{}) error {
+	if cfg.Webhook == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(Notification{Event: event, Time: time.Now().Unix(), Data: data})
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("webhook responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
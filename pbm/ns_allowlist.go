@@ -0,0 +1,90 @@
+package pbm
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// NSAllowlistReport is the result of the comparison of the backup's
+// namespaces against the allowlist
+type NSAllowlistReport struct {
+	// Checked is the number of the backup's namespaces compared
+	Checked int `json:"checked" yaml:"checked"`
+	// Unexpected are the backup's namespaces not on the allowlist
+	Unexpected []string `json:"unexpected,omitempty" yaml:"unexpected,omitempty"`
+	// Limitations are why (a part of) the backup can't be checked
+	Limitations []string `json:"limitations,omitempty" yaml:"limitations,omitempty"`
+}
+
+// OK returns true if the backup was checked and has nothing beyond
+// the allowlist
+func (r *NSAllowlistReport) OK() bool {
+	return len(r.Unexpected) == 0 && len(r.Limitations) == 0
+}
+
+// CheckNSAllowlist compares the namespaces of the backup (the ones of `nss`
+// only if it's set, as the selective restore would do) against the
+// allowlist. The admin, config and local databases and system.*
+// collections aren't user data and aren't compared.
+//
+// Only logical backups list their namespaces (in the dump metafile).
+// The report lists replsets which can't be checked in Limitations.
+func CheckNSAllowlist(bcp *BackupMeta, stg storage.Storage, allow, nss []string) (*NSAllowlistReport, error) {
+	rep := &NSAllowlistReport{}
+	if bcp.Type != LogicalBackup {
+		rep.Limitations = append(rep.Limitations, string(bcp.Type)+
+			" backups copy data files and don't list namespaces, the backup can't be checked")
+		return rep, nil
+	}
+
+	allowed := archive.DefaultNSFilter
+	if sel.IsSelective(allow) {
+		allowed = sel.MakeSelectedPred(allow)
+	}
+	selected := archive.DefaultNSFilter
+	if sel.IsSelective(nss) {
+		selected = sel.MakeSelectedPred(nss)
+	}
+
+	unexpected := make(map[string]struct{})
+	for _, rs := range bcp.Replsets {
+		if path.Base(rs.DumpName) != archive.MetaFile {
+			rep.Limitations = append(rep.Limitations, rs.Name+
+				": the dump is a single archive of an older format with no namespaces list, can't be checked")
+			continue
+		}
+
+		list, err := ReadArchiveNamespaces(stg, rs.DumpName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s namespaces", rs.Name)
+		}
+		for _, n := range list {
+			ns := archive.NSify(n.Database, n.Collection)
+			if isInternalNS(n.Database, n.Collection) || !selected(ns) {
+				continue
+			}
+			rep.Checked++
+			if !allowed(ns) {
+				unexpected[ns] = struct{}{}
+			}
+		}
+	}
+
+	for ns := range unexpected {
+		rep.Unexpected = append(rep.Unexpected, ns)
+	}
+	sort.Strings(rep.Unexpected)
+
+	return rep, nil
+}
+
+func isInternalNS(db, coll string) bool {
+	return db == "admin" || db == "config" || db == "local" || strings.HasPrefix(coll, "system.")
+}
@@ -0,0 +1,68 @@
+package pbm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	mtarchive "github.com/mongodb/mongo-tools/common/archive"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestCheckNSAllowlist(t *testing.T) {
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	var nss []*archive.Namespace
+	for _, ns := range [][2]string{
+		{"admin", "system.users"},
+		{"db1", "c1"},
+		{"db1", "c2"},
+		{"db2", "c1"},
+		{"db3", "system.views"},
+	} {
+		nss = append(nss, &archive.Namespace{
+			CollectionMetadata: &mtarchive.CollectionMetadata{Database: ns[0], Collection: ns[1]},
+		})
+	}
+	data, err := bson.MarshalExtJSON(bson.M{"namespaces": nss}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const meta = "bcp/rs0/" + archive.MetaFile
+	if err := stg.Save(meta, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	bcp := &BackupMeta{
+		Type: LogicalBackup,
+		Replsets: []BackupReplset{
+			{Name: "rs0", DumpName: meta},
+			{Name: "rs1", DumpName: "bcp_rs1.dump.s2"},
+		},
+	}
+
+	rep, err := CheckNSAllowlist(bcp, stg, []string{"db1.*"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Checked != 3 || !reflect.DeepEqual(rep.Unexpected, []string{"db2.c1"}) || len(rep.Limitations) != 1 {
+		t.Errorf("got %+v", rep)
+	}
+
+	rep, err = CheckNSAllowlist(bcp, stg, []string{"db1.c1"}, []string{"db1.*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Checked != 2 || !reflect.DeepEqual(rep.Unexpected, []string{"db1.c2"}) {
+		t.Errorf("selective: got %+v", rep)
+	}
+
+	bcp.Type = PhysicalBackup
+	rep, err = CheckNSAllowlist(bcp, stg, []string{"db1.*"}, nil)
+	if err != nil || rep.OK() || rep.Checked != 0 {
+		t.Errorf("physical: got %+v, %v", rep, err)
+	}
+}
@@ -0,0 +1,239 @@
+package pbm
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MaxNSStats is the max number of namespaces whose stats are kept in
+// the backup metadata. Only the largest ones are kept if there are more.
+const MaxNSStats = 500
+
+// NSStatsTimeout is how long the namespaces stats are collected for at most
+const NSStatsTimeout = time.Minute
+
+// NSStatsTotal is the namespace of the totals in NSStatDiff
+const NSStatsTotal = "*"
+
+// NSStat is the namespace's stats from `collStats`
+type NSStat struct {
+	NS string `bson:"ns" json:"ns"`
+	// Count is the documents count estimate
+	Count int64 `bson:"count" json:"count"`
+	// Size is the uncompressed size of the documents
+	Size    int64 `bson:"size" json:"size"`
+	Indexes int   `bson:"nindexes" json:"nindexes"`
+}
+
+// NSStats is the replset's namespaces stats taken along with the backup
+// to check the restored data against (see CompareNSStats)
+type NSStats struct {
+	Namespaces []NSStat `bson:"nss" json:"nss"`
+	// Total is the sum of the stats of all namespaces. Its Indexes is
+	// the total number of indexes and NSCount is the number of namespaces.
+	Total   NSStat `bson:"total" json:"total"`
+	NSCount int    `bson:"ns_count" json:"ns_count"`
+	// Truncated means only the MaxNSStats largest namespaces are listed
+	Truncated bool `bson:"truncated,omitempty" json:"truncated,omitempty"`
+
+	// noTotal means the totals don't cover the listed namespaces
+	// (see Filter)
+	noTotal bool
+}
+
+// Filter returns the stats of the namespaces selected by `match`
+// (all if nil). The totals of the truncated stats can't be filtered,
+// so they aren't compared after that.
+func (s *NSStats) Filter(match func(ns string) bool) *NSStats {
+	if match == nil {
+		return s
+	}
+
+	rv := &NSStats{Truncated: s.Truncated, noTotal: s.noTotal || s.Truncated}
+	for _, ns := range s.Namespaces {
+		if match(ns.NS) {
+			rv.Namespaces = append(rv.Namespaces, ns)
+		}
+	}
+	rv.Total = sumNSStats(rv.Namespaces)
+	rv.NSCount = len(rv.Namespaces)
+
+	return rv
+}
+
+// GetNSStats returns the stats of the node's user collections selected by
+// `match` (all if nil)
+func GetNSStats(ctx context.Context, m *mongo.Client, match func(ns string) bool) ([]NSStat, error) {
+	dbs, err := m.ListDatabaseNames(ctx, bson.D{{"name", bson.M{"$nin": bson.A{"admin", "config", "local"}}}})
+	if err != nil {
+		return nil, errors.Wrap(err, "list databases")
+	}
+
+	var rv []NSStat
+	for _, db := range dbs {
+		colls, err := m.Database(db).ListCollectionSpecifications(ctx, bson.D{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "list collections of %q", db)
+		}
+
+		for _, coll := range colls {
+			ns := db + "." + coll.Name
+			if coll.Type == "view" || strings.HasPrefix(coll.Name, "system.") {
+				continue
+			}
+			if match != nil && !match(ns) {
+				continue
+			}
+
+			var st struct {
+				Count   int64 `bson:"count"`
+				Size    int64 `bson:"size"`
+				Indexes int   `bson:"nindexes"`
+			}
+			err := m.Database(db).RunCommand(ctx, bson.D{{"collStats", coll.Name}}).Decode(&st)
+			if err != nil {
+				return nil, errors.Wrapf(err, "collStats %q", ns)
+			}
+			rv = append(rv, NSStat{NS: ns, Count: st.Count, Size: st.Size, Indexes: st.Indexes})
+		}
+	}
+
+	return rv, nil
+}
+
+// SetRSNSStats sets the stats of the replset's namespaces
+func (p *PBM) SetRSNSStats(bcpName, rsName string, s *NSStats) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.ns_stats": s}}},
+	)
+
+	return err
+}
+
+// SummarizeNSStats returns the stats to keep in the backup metadata.
+// Only `max` largest namespaces are listed, the totals cover all.
+func SummarizeNSStats(stats []NSStat, max int) *NSStats {
+	rv := &NSStats{
+		Total:   sumNSStats(stats),
+		NSCount: len(stats),
+	}
+
+	nss := append([]NSStat(nil), stats...)
+	if len(nss) > max {
+		sort.SliceStable(nss, func(i, j int) bool { return nss[i].Size > nss[j].Size })
+		nss = nss[:max]
+		rv.Truncated = true
+	}
+	sort.Slice(nss, func(i, j int) bool { return nss[i].NS < nss[j].NS })
+	rv.Namespaces = nss
+
+	return rv
+}
+
+func sumNSStats(stats []NSStat) NSStat {
+	t := NSStat{NS: NSStatsTotal}
+	for _, s := range stats {
+		t.Count += s.Count
+		t.Size += s.Size
+		t.Indexes += s.Indexes
+	}
+	return t
+}
+
+// NSStatsTolerance is how much (in percent) the restored namespaces' stats
+// may differ from the backup's ones. Counts may differ as the stats are
+// taken along with the backup rather than at the backup point, sizes also
+// due to the storage layout.
+type NSStatsTolerance struct {
+	CountPct float64
+	SizePct  float64
+}
+
+// NSStatDiff is the namespace whose restored stats differ from
+// the backup's ones beyond the tolerance
+type NSStatDiff struct {
+	NS string `json:"ns"`
+	// Mismatch is what differs: `missing`, `extra`, `count`, `size` or
+	// `nindexes`
+	Mismatch []string `json:"mismatch"`
+	Backup   *NSStat  `json:"backup,omitempty"`
+	Restored *NSStat  `json:"restored,omitempty"`
+}
+
+// CompareNSStats checks the restored namespaces' stats against the backup's
+// ones. The namespaces that aren't in the backup are reported only if all
+// of the backup's ones are listed. The totals are reported as NSStatsTotal.
+// `restored` is expected to be selected the same way as `bcp` (see Filter).
+func CompareNSStats(bcp *NSStats, restored []NSStat, tol NSStatsTolerance) []NSStatDiff {
+	rmap := make(map[string]NSStat, len(restored))
+	for _, s := range restored {
+		rmap[s.NS] = s
+	}
+
+	var rv []NSStatDiff
+	for i := range bcp.Namespaces {
+		b := bcp.Namespaces[i]
+		r, ok := rmap[b.NS]
+		delete(rmap, b.NS)
+		if !ok {
+			rv = append(rv, NSStatDiff{NS: b.NS, Mismatch: []string{"missing"}, Backup: &b})
+			continue
+		}
+		if m := nsStatMismatch(b, r, tol); len(m) != 0 {
+			rv = append(rv, NSStatDiff{NS: b.NS, Mismatch: m, Backup: &b, Restored: &r})
+		}
+	}
+
+	if !bcp.Truncated {
+		extra := make([]string, 0, len(rmap))
+		for ns := range rmap {
+			extra = append(extra, ns)
+		}
+		sort.Strings(extra)
+		for _, ns := range extra {
+			r := rmap[ns]
+			rv = append(rv, NSStatDiff{NS: ns, Mismatch: []string{"extra"}, Restored: &r})
+		}
+	}
+
+	if bcp.noTotal {
+		return rv
+	}
+	total := sumNSStats(restored)
+	if m := nsStatMismatch(bcp.Total, total, tol); len(m) != 0 {
+		b := bcp.Total
+		rv = append(rv, NSStatDiff{NS: NSStatsTotal, Mismatch: m, Backup: &b, Restored: &total})
+	}
+
+	return rv
+}
+
+func nsStatMismatch(b, r NSStat, tol NSStatsTolerance) []string {
+	var m []string
+	if !withinPct(b.Count, r.Count, tol.CountPct) {
+		m = append(m, "count")
+	}
+	if !withinPct(b.Size, r.Size, tol.SizePct) {
+		m = append(m, "size")
+	}
+	if b.Indexes != r.Indexes {
+		m = append(m, "nindexes")
+	}
+	return m
+}
+
+// withinPct tells if `a` and `b` differ by no more than `pct` percent
+// of the largest of them
+func withinPct(a, b int64, pct float64) bool {
+	d := math.Abs(float64(a - b))
+	return d <= math.Max(float64(a), float64(b))*pct/100
+}
@@ -0,0 +1,109 @@
+package pbm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeNSStats(t *testing.T) {
+	stats := []NSStat{
+		{NS: "db.c", Count: 10, Size: 300, Indexes: 1},
+		{NS: "db.a", Count: 20, Size: 100, Indexes: 2},
+		{NS: "db.b", Count: 30, Size: 200, Indexes: 1},
+	}
+
+	s := SummarizeNSStats(stats, 2)
+	if !s.Truncated || s.NSCount != 3 {
+		t.Errorf("truncated %v, ns count %d; want true, 3", s.Truncated, s.NSCount)
+	}
+	if want := (NSStat{NS: NSStatsTotal, Count: 60, Size: 600, Indexes: 4}); s.Total != want {
+		t.Errorf("total %+v, want %+v", s.Total, want)
+	}
+	var nss []string
+	for _, ns := range s.Namespaces {
+		nss = append(nss, ns.NS)
+	}
+	if want := []string{"db.b", "db.c"}; !reflect.DeepEqual(nss, want) {
+		t.Errorf("namespaces %v, want %v", nss, want)
+	}
+
+	if s := SummarizeNSStats(stats, MaxNSStats); s.Truncated || len(s.Namespaces) != 3 {
+		t.Errorf("truncated %v with %d namespaces, want all 3", s.Truncated, len(s.Namespaces))
+	}
+}
+
+func TestCompareNSStats(t *testing.T) {
+	bcp := SummarizeNSStats([]NSStat{
+		{NS: "db.a", Count: 1000, Size: 10000, Indexes: 2},
+		{NS: "db.b", Count: 1000, Size: 10000, Indexes: 1},
+		{NS: "db.c", Count: 10, Size: 100, Indexes: 1},
+	}, MaxNSStats)
+	tol := NSStatsTolerance{CountPct: 1, SizePct: 10}
+
+	diff := func(d []NSStatDiff) string {
+		var s []string
+		for _, x := range d {
+			s = append(s, x.NS+":"+strings.Join(x.Mismatch, "+"))
+		}
+		return strings.Join(s, " ")
+	}
+
+	cases := []struct {
+		name     string
+		bcp      *NSStats
+		restored []NSStat
+		want     string
+	}{
+		{
+			name: "within tolerance",
+			bcp:  bcp,
+			restored: []NSStat{
+				{NS: "db.a", Count: 1005, Size: 10900, Indexes: 2},
+				{NS: "db.b", Count: 995, Size: 9500, Indexes: 1},
+				{NS: "db.c", Count: 10, Size: 100, Indexes: 1},
+			},
+		},
+		{
+			name: "mismatch",
+			bcp:  bcp,
+			restored: []NSStat{
+				{NS: "db.a", Count: 900, Size: 10000, Indexes: 1},
+				{NS: "db.c", Count: 10, Size: 200, Indexes: 1},
+				{NS: "db.d", Count: 1, Size: 10, Indexes: 1},
+			},
+			want: "db.a:count+nindexes db.b:missing db.c:size db.d:extra *:count+size+nindexes",
+		},
+		{
+			name: "truncated",
+			bcp: SummarizeNSStats([]NSStat{
+				{NS: "db.a", Count: 1000, Size: 10000, Indexes: 2},
+				{NS: "db.b", Count: 1000, Size: 10000, Indexes: 1},
+			}, 1),
+			restored: []NSStat{
+				{NS: "db.a", Count: 1000, Size: 10000, Indexes: 2},
+				{NS: "db.c", Count: 1000, Size: 10000, Indexes: 1},
+			},
+		},
+		{
+			name: "truncated filtered",
+			bcp: SummarizeNSStats([]NSStat{
+				{NS: "db.a", Count: 1000, Size: 10000, Indexes: 2},
+				{NS: "db.b", Count: 1000, Size: 10000, Indexes: 1},
+				{NS: "x.a", Count: 10, Size: 10, Indexes: 1},
+			}, 2).Filter(func(ns string) bool { return strings.HasPrefix(ns, "db.") }),
+			restored: []NSStat{
+				{NS: "db.a", Count: 1000, Size: 10000, Indexes: 2},
+			},
+			want: "db.b:missing",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := diff(CompareNSStats(tc.bcp, tc.restored, tol)); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package pbm
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WriteSpan is the timestamps of the first and the last write
+// to a namespace seen in the backup's oplog
+type WriteSpan struct {
+	First primitive.Timestamp `bson:"first" json:"first"`
+	Last  primitive.Timestamp `bson:"last" json:"last"`
+}
+
+// NSWrites is the write spans of the namespaces of a selective backup.
+// Namespaces with no writes during the backup are absent.
+//
+// Namespaces contain dots, so it's stored as an array rather than
+// a document keyed by namespace.
+type NSWrites map[string]WriteSpan
+
+type nsWriteSpan struct {
+	NS        string `bson:"ns"`
+	WriteSpan `bson:",inline"`
+}
+
+func (w NSWrites) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	a := make([]nsWriteSpan, 0, len(w))
+	for ns, s := range w {
+		a = append(a, nsWriteSpan{NS: ns, WriteSpan: s})
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].NS < a[j].NS })
+
+	return bson.MarshalValue(a)
+}
+
+func (w *NSWrites) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.Null {
+		*w = nil
+		return nil
+	}
+
+	var a []nsWriteSpan
+	err := bson.RawValue{Type: t, Value: data}.Unmarshal(&a)
+	if err != nil {
+		return err
+	}
+
+	*w = make(NSWrites, len(a))
+	for _, s := range a {
+		(*w)[s.NS] = s.WriteSpan
+	}
+	return nil
+}
+
+// Add extends the namespace's span with the write at `ts`
+func (w NSWrites) Add(ns string, ts primitive.Timestamp) {
+	s, ok := w[ns]
+	if !ok {
+		w[ns] = WriteSpan{First: ts, Last: ts}
+		return
+	}
+
+	if primitive.CompareTimestamp(ts, s.First) < 0 {
+		s.First = ts
+	}
+	if primitive.CompareTimestamp(ts, s.Last) > 0 {
+		s.Last = ts
+	}
+	w[ns] = s
+}
+
+// Merge extends the spans with the ones of `o`
+func (w NSWrites) Merge(o NSWrites) {
+	for ns, s := range o {
+		w.Add(ns, s.First)
+		w.Add(ns, s.Last)
+	}
+}
+
+// Validate checks the spans are consistent with the backup's
+// oplog range [first, last]
+func (w NSWrites) Validate(first, last primitive.Timestamp) error {
+	for ns, s := range w {
+		if primitive.CompareTimestamp(s.First, s.Last) > 0 {
+			return errors.Errorf("%s: first write %v is after the last one %v", ns, s.First, s.Last)
+		}
+		if primitive.CompareTimestamp(s.First, first) < 0 || primitive.CompareTimestamp(s.Last, last) > 0 {
+			return errors.Errorf("%s: writes span [%v, %v] is out of the backup oplog range [%v, %v]",
+				ns, s.First, s.Last, first, last)
+		}
+	}
+	return nil
+}
+
+// Has tells if the namespace has any writes
+func (w NSWrites) Has(ns string) bool {
+	_, ok := w[ns]
+	return ok
+}
+
+// ClusterNSWrites returns the write spans of all replsets of the backup
+// merged. It's nil if no replset tracked them.
+func (b *BackupMeta) ClusterNSWrites() NSWrites {
+	var rv NSWrites
+	for _, rs := range b.Replsets {
+		if rs.NSWrites == nil {
+			continue
+		}
+		if rv == nil {
+			rv = make(NSWrites)
+		}
+		rv.Merge(rs.NSWrites)
+	}
+	return rv
+}
+
+// SetRSNSWrites sets the namespaces write spans seen in the replset's oplog
+func (p *PBM) SetRSNSWrites(bcpName, rsName string, w NSWrites) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.ns_writes": w}}},
+	)
+
+	return err
+}
+
+// SetNSWrites sets the namespaces write spans of the whole backup
+func (p *PBM) SetNSWrites(bcpName string, w NSWrites) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}},
+		bson.D{{"$set", bson.M{"ns_writes": w}}},
+	)
+
+	return err
+}
@@ -0,0 +1,53 @@
+package pbm
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNSWrites(t *testing.T) {
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+
+	rs1 := NSWrites{}
+	rs1.Add("db.a", ts(12))
+	rs1.Add("db.a", ts(10))
+	rs2 := NSWrites{}
+	rs2.Add("db.a", ts(15))
+	rs2.Add("db.b", ts(11))
+
+	bcp := BackupMeta{Replsets: []BackupReplset{{Name: "rs1", NSWrites: rs1}, {Name: "rs2", NSWrites: rs2}, {Name: "rs3"}}}
+	w := bcp.ClusterNSWrites()
+	want := NSWrites{"db.a": {First: ts(10), Last: ts(15)}, "db.b": {First: ts(11), Last: ts(11)}}
+	if !reflect.DeepEqual(w, want) {
+		t.Fatalf("got %v, want %v", w, want)
+	}
+	if !w.Has("db.a") || w.Has("db.c") {
+		t.Error("unexpected Has result")
+	}
+
+	if err := w.Validate(ts(10), ts(15)); err != nil {
+		t.Errorf("validate: unexpected error %v", err)
+	}
+	if err := w.Validate(ts(10), ts(14)); err == nil {
+		t.Error("validate: expected error for the span out of the oplog range")
+	}
+
+	bcp.NSWrites = w
+	b, err := bson.Marshal(bcp)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var got BackupMeta
+	if err := bson.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got.NSWrites, w) {
+		t.Errorf("round trip: got %v, want %v", got.NSWrites, w)
+	}
+	if got.Replsets[2].NSWrites != nil {
+		t.Errorf("round trip: expected no spans of rs3, got %v", got.Replsets[2].NSWrites)
+	}
+}
@@ -0,0 +1,102 @@
+package pbm
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OpLog represents log of started operation.
+// Operation progress can be get from logs by OPID.
+// Basically it is a log of all ever taken locks. With the
+// uniqueness by rs + opid
+type OpLog struct {
+	LockHeader `bson:",inline" json:",inline"`
+
+	// StartTS is the cluster time the lock was acquired at
+	StartTS primitive.Timestamp `bson:"start_ts" json:"start_ts"`
+
+	// The fields below are set when the lock is released. The entry is
+	// inserted with them zeroed: pbmOpLog is a capped collection and
+	// the update can't change the document size.
+	EndTS primitive.Timestamp `bson:"end_ts" json:"end_ts"`
+	// Duration is how long the lock was held, in seconds
+	Duration int64     `bson:"duration" json:"duration"`
+	Outcome  OpOutcome `bson:"outcome" json:"outcome"`
+}
+
+// OpOutcome is the outcome of the operation in its pbmOpLog entry.
+// It is a number rather than a string to keep the entry size fixed.
+type OpOutcome int32
+
+const (
+	OpRunning OpOutcome = iota
+	OpDone
+	OpFailed
+	// OpStale is set for the ops whose lock was found stale and deleted
+	OpStale
+)
+
+func (o OpOutcome) String() string {
+	switch o {
+	case OpRunning:
+		return "running"
+	case OpDone:
+		return "done"
+	case OpFailed:
+		return "failed"
+	case OpStale:
+		return "stale"
+	}
+	return "unknown"
+}
+
+func (o OpOutcome) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+// finishOpLog sets the end and the outcome of the running op log entry
+// of the lock. Entries written by the older versions have no end fields
+// and can't be updated in a capped collection, so they stay as is.
+func (p *PBM) finishOpLog(lh LockHeader, end primitive.Timestamp, outcome OpOutcome) error {
+	c := p.Conn.Database(DB).Collection(PBMOpLogCollection)
+	q := bson.D{{"opid", lh.OPID}, {"replset", lh.Replset}, {"outcome", OpRunning}}
+
+	var op OpLog
+	err := c.FindOne(p.ctx, q).Decode(&op)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return errors.Wrap(err, "get op log entry")
+	}
+
+	var dur int64
+	if end.T > op.StartTS.T {
+		dur = int64(end.T - op.StartTS.T)
+	}
+	_, err = c.UpdateOne(p.ctx, q,
+		bson.D{{"$set", bson.M{"end_ts": end, "duration": dur, "outcome": outcome}}},
+	)
+	return errors.Wrap(err, "update op log entry")
+}
+
+// GetOpLog returns the op log entries started after `since`, oldest first
+func (p *PBM) GetOpLog(since primitive.Timestamp) ([]OpLog, error) {
+	cur, err := p.Conn.Database(DB).Collection(PBMOpLogCollection).Find(
+		p.ctx,
+		bson.D{{"start_ts", bson.M{"$gte": since}}},
+		options.Find().SetSort(bson.D{{"start_ts", 1}}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var ops []OpLog
+	err = cur.All(p.ctx, &ops)
+	return ops, errors.Wrap(err, "decode")
+}
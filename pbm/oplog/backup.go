@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
@@ -20,6 +22,10 @@ type OplogBackup struct {
 	stopC chan struct{}
 	start primitive.Timestamp
 	end   primitive.Timestamp
+
+	// nsWrites are the write spans of the namespaces nsSelected matches
+	nsSelected func(ns string) bool
+	nsWrites   pbm.NSWrites
 }
 
 // NewOplogBackup creates a new Oplog instance
@@ -33,6 +39,18 @@ func (ot *OplogBackup) SetTailingSpan(start, end primitive.Timestamp) {
 	ot.end = end
 }
 
+// TrackNSWrites makes WriteTo track the write spans
+// of the namespaces `selected` matches
+func (ot *OplogBackup) TrackNSWrites(selected func(ns string) bool) {
+	ot.nsSelected = selected
+	ot.nsWrites = make(pbm.NSWrites)
+}
+
+// NSWrites returns the write spans tracked by WriteTo (see TrackNSWrites)
+func (ot *OplogBackup) NSWrites() pbm.NSWrites {
+	return ot.nsWrites
+}
+
 type ErrInsuffRange struct {
 	primitive.Timestamp
 }
@@ -117,6 +135,8 @@ func (ot *OplogBackup) WriteTo(w io.Writer) (int64, error) {
 			continue
 		}
 
+		ot.trackNS(cur.Current, opts)
+
 		n, err := w.Write(cur.Current)
 		if err != nil {
 			return written, errors.Wrap(err, "write to pipe")
@@ -156,3 +176,138 @@ func (ot *OplogBackup) IsSufficient(from primitive.Timestamp) (bool, error) {
 func (ot *OplogBackup) LastWrite() (primitive.Timestamp, error) {
 	return pbm.LastWrite(ot.cl, true)
 }
+
+// trackNS records the write of the op to the selected namespaces
+func (ot *OplogBackup) trackNS(op bson.Raw, ts primitive.Timestamp) {
+	if ot.nsSelected == nil {
+		return
+	}
+
+	for _, ns := range opNamespaces(op) {
+		if ot.nsSelected(ns) {
+			ot.nsWrites.Add(ns, ts)
+		}
+	}
+}
+
+// opNamespaces returns the collections the oplog entry writes to.
+// Those are the namespaces of the entries of applyOps (transactions)
+// and the collection of commands like create or drop.
+func opNamespaces(op bson.Raw) []string {
+	ns, _ := op.Lookup("ns").StringValueOK()
+	if opt, _ := op.Lookup("op").StringValueOK(); opt != "c" {
+		return []string{ns}
+	}
+
+	o, ok := op.Lookup("o").DocumentOK()
+	if !ok {
+		return nil
+	}
+	if ops, ok := o.Lookup("applyOps").ArrayOK(); ok {
+		vals, _ := ops.Values()
+		var rv []string
+		for _, v := range vals {
+			if d, ok := v.DocumentOK(); ok {
+				rv = append(rv, opNamespaces(d)...)
+			}
+		}
+		return rv
+	}
+
+	db, _, _ := strings.Cut(ns, ".")
+	for _, cmd := range selectedNSSupportedCommands {
+		if coll, ok := o.Lookup(cmd).StringValueOK(); ok {
+			return []string{db + "." + coll}
+		}
+	}
+	return nil
+}
+
+// txnEntry is an oplog entry of a multi-document transaction
+type txnEntry struct {
+	TS        primitive.Timestamp `bson:"ts"`
+	LSID      bson.Raw            `bson:"lsid"`
+	TxnNumber int64               `bson:"txnNumber"`
+	O         struct {
+		PartialTxn bool `bson:"partialTxn"`
+		Prepare    bool `bson:"prepare"`
+	} `bson:"o"`
+}
+
+// open tells if the entry is followed by other entries of the transaction
+// (commit or the rest of its operations)
+func (e txnEntry) open() bool {
+	return e.O.PartialTxn || e.O.Prepare
+}
+
+// SafeCutPoint returns the timestamp closest to `to` the oplog in the
+// (from, to] range can be cut at without splitting any transaction. If a
+// transaction is in progress at `to`, it's the timestamp of the oplog entry
+// preceding the first entry of the transaction. The returned timestamp is
+// equal to `from` if there is no such point.
+func (ot *OplogBackup) SafeCutPoint(from, to primitive.Timestamp) (primitive.Timestamp, error) {
+	oplog := ot.cl.Database("local").Collection("oplog.rs")
+	cur, err := oplog.Find(context.Background(),
+		bson.D{
+			{"ts", bson.M{"$gt": from, "$lte": to}},
+			{"op", "c"},
+			{"txnNumber", bson.M{"$exists": true}},
+		},
+		options.Find().SetProjection(bson.D{
+			{"ts", 1}, {"lsid", 1}, {"txnNumber", 1}, {"o.partialTxn", 1}, {"o.prepare", 1},
+		}),
+	)
+	if err != nil {
+		return from, errors.Wrap(err, "get transactions")
+	}
+	var ents []txnEntry
+	err = cur.All(context.Background(), &ents)
+	if err != nil {
+		return from, errors.Wrap(err, "decode transactions")
+	}
+
+	start, ok := openTxnStart(ents)
+	if !ok {
+		return to, nil
+	}
+
+	var prev struct {
+		TS primitive.Timestamp `bson:"ts"`
+	}
+	err = oplog.FindOne(context.Background(),
+		bson.D{{"ts", bson.M{"$gt": from, "$lt": start}}},
+		options.FindOne().SetSort(bson.D{{"$natural", -1}}).SetProjection(bson.D{{"ts", 1}}),
+	).Decode(&prev)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return from, nil
+	}
+	if err != nil {
+		return from, errors.Wrap(err, "get preceding entry")
+	}
+
+	return prev.TS, nil
+}
+
+// openTxnStart returns the timestamp of the first entry of the earliest
+// transaction which isn't finished by the last of the given entries
+func openTxnStart(ents []txnEntry) (primitive.Timestamp, bool) {
+	open := make(map[string]primitive.Timestamp)
+	for _, e := range ents {
+		key := string(e.LSID) + "/" + strconv.FormatInt(e.TxnNumber, 10)
+		if !e.open() {
+			delete(open, key)
+			continue
+		}
+		if _, ok := open[key]; !ok {
+			open[key] = e.TS
+		}
+	}
+
+	var start primitive.Timestamp
+	for _, ts := range open {
+		if start.IsZero() || primitive.CompareTimestamp(ts, start) < 0 {
+			start = ts
+		}
+	}
+	return start, !start.IsZero()
+}
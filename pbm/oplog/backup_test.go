@@ -0,0 +1,107 @@
+package oplog
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+)
+
+func TestOpenTxnStart(t *testing.T) {
+	ent := func(ts uint32, lsid string, txn int64, partial, prepare bool) txnEntry {
+		e := txnEntry{TS: primitive.Timestamp{T: ts}, LSID: []byte(lsid), TxnNumber: txn}
+		e.O.PartialTxn = partial
+		e.O.Prepare = prepare
+		return e
+	}
+
+	ents := []txnEntry{
+		// finished multi-entry txn
+		ent(1, "a", 1, true, false),
+		ent(2, "a", 1, false, false),
+		// single-entry txn
+		ent(3, "b", 1, false, false),
+		// open txn
+		ent(4, "a", 2, true, false),
+		// prepared txn, committed
+		ent(5, "c", 1, false, true),
+		ent(6, "c", 1, false, false),
+		// prepared txn, not committed yet
+		ent(7, "b", 2, false, true),
+		ent(8, "a", 2, true, false),
+	}
+
+	ts, ok := openTxnStart(ents)
+	if !ok || ts.T != 4 {
+		t.Errorf("expected open txn at 4, got %v %v", ts, ok)
+	}
+
+	ts, ok = openTxnStart(ents[:4])
+	if !ok || ts.T != 4 {
+		t.Errorf("expected open txn at 4, got %v %v", ts, ok)
+	}
+
+	if ts, ok = openTxnStart(ents[:3]); ok {
+		t.Errorf("expected no open txn, got %v", ts)
+	}
+}
+
+// only one of the three selected collections receives writes during the backup
+func TestTrackNSWrites(t *testing.T) {
+	op := func(ts uint32, typ, ns string, o bson.D) bson.Raw {
+		b, err := bson.Marshal(bson.D{{"ts", primitive.Timestamp{T: ts}}, {"op", typ}, {"ns", ns}, {"o", o}})
+		if err != nil {
+			t.Fatalf("marshal op: %v", err)
+		}
+		return b
+	}
+	ops := []bson.Raw{
+		op(10, "i", "db.a", bson.D{{"_id", 1}}),
+		op(11, "i", "db.other", bson.D{{"_id", 1}}),
+		op(12, "u", "db.a", bson.D{{"$set", bson.D{{"x", 1}}}}),
+		op(13, "c", "admin.$cmd", bson.D{{"applyOps", bson.A{
+			bson.D{{"op", "i"}, {"ns", "db.a"}, {"o", bson.D{{"_id", 2}}}},
+			bson.D{{"op", "i"}, {"ns", "db.x"}, {"o", bson.D{{"_id", 2}}}},
+		}}}),
+		op(14, "c", "db.$cmd", bson.D{{"createIndexes", "a"}, {"v", 2}}),
+		op(15, "c", "db.$cmd", bson.D{{"create", "other"}}),
+	}
+
+	ot := &OplogBackup{}
+	ot.TrackNSWrites(sel.MakeSelectedPred([]string{"db.a", "db.b", "db.c"}))
+	for _, o := range ops {
+		ts, _ := o.Lookup("ts").Timestamp()
+		ot.trackNS(o, primitive.Timestamp{T: ts})
+	}
+
+	want := pbm.NSWrites{"db.a": {First: primitive.Timestamp{T: 10}, Last: primitive.Timestamp{T: 14}}}
+	if got := ot.NSWrites(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	f := NewNSWritesFilter(ot.NSWrites())
+	rec := func(ts uint32, typ, ns string, o bson.D) *Record {
+		return &Record{Timestamp: primitive.Timestamp{T: ts}, Operation: typ, Namespace: ns, Object: o}
+	}
+	cases := []struct {
+		name string
+		r    *Record
+		want bool
+	}{
+		{"in span", rec(12, "u", "db.a", nil), true},
+		{"after span", rec(20, "i", "db.a", nil), true},
+		{"no writes", rec(12, "i", "db.b", nil), false},
+		{"command in span", rec(14, "c", "db.$cmd", bson.D{{"createIndexes", "a"}}), true},
+		{"command of no writes", rec(14, "c", "db.$cmd", bson.D{{"drop", "c"}}), false},
+		{"transaction", rec(13, "c", "admin.$cmd", bson.D{{"applyOps", bson.A{}}}), true},
+	}
+	for _, c := range cases {
+		if got := f(c.r); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
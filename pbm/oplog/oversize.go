@@ -0,0 +1,182 @@
+package oplog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxApplyOpsSize is the max size of the applyOps command. The server
+// accepts commands up to the max BSON size plus 16KB for the command
+// itself (BSONObjMaxInternalSize).
+var maxApplyOpsSize = int(db.MaxBSONSize) + 16*1024
+
+// applyOpsHeaderSize is the size of `{applyOps: []}`:
+// doc len + type + "applyOps\x00" + array len + array terminator + doc terminator
+const applyOpsHeaderSize = 4 + 1 + 9 + 4 + 1 + 1
+
+// oversizedOpErr is an oplog entry too large to be applied with applyOps
+type oversizedOpErr struct {
+	ts   primitive.Timestamp
+	ns   string
+	op   string
+	size int
+}
+
+func (e oversizedOpErr) Error() string {
+	return fmt.Sprintf("oplog entry %v (ns: %s, op: %s) of %d bytes exceeds the applyOps limit of %d bytes",
+		e.ts, e.ns, e.op, e.size, maxApplyOpsSize)
+}
+
+// applyOpsEntrySize is the size the entry takes in the applyOps array
+// at the given index
+func applyOpsEntrySize(entry interface{}, i int) (int, error) {
+	b, err := bson.Marshal(entry)
+	if err != nil {
+		return 0, errors.Wrap(err, "marshal")
+	}
+	// type + index as the key + terminator + the doc
+	return 1 + len(strconv.Itoa(i)) + 1 + len(b), nil
+}
+
+// splitApplyOps splits the entries into batches each fitting into a single
+// applyOps command of `limit` bytes, keeping the order. An entry is never
+// split, so each of them is still applied atomically. An entry that
+// doesn't fit on its own fails with oversizedOpErr.
+func splitApplyOps(entries []interface{}, limit int) ([][]interface{}, error) {
+	var rv [][]interface{}
+	var batch []interface{}
+	size := applyOpsHeaderSize
+	for _, e := range entries {
+		esize, err := applyOpsEntrySize(e, len(batch))
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) > 0 && size+esize > limit {
+			rv = append(rv, batch)
+			batch = nil
+			size = applyOpsHeaderSize
+			esize, _ = applyOpsEntrySize(e, 0)
+		}
+		if size+esize > limit {
+			oerr := oversizedOpErr{size: applyOpsHeaderSize + esize}
+			if op, ok := e.(db.Oplog); ok {
+				oerr.ts, oerr.ns, oerr.op = op.Timestamp, op.Namespace, op.Operation
+			}
+			return nil, oerr
+		}
+		batch = append(batch, e)
+		size += esize
+	}
+	if len(batch) > 0 {
+		rv = append(rv, batch)
+	}
+
+	return rv, nil
+}
+
+// applyOversizedInsert inserts the document of the oversized insert entry
+// directly. The document itself fits into the BSON size limit, only the
+// entry wrapped into applyOps doesn't. Upsert makes it idempotent as the
+// applyOps insert is.
+func (o *OplogRestore) applyOversizedInsert(op db.Oplog) error {
+	dbName, coll, ok := strings.Cut(op.Namespace, ".")
+	if !ok {
+		return errors.Errorf("malformed namespace %s", op.Namespace)
+	}
+	id, ok := op.Object.Map()["_id"]
+	if !ok {
+		return errors.Errorf("no _id in the document of %s at %v", op.Namespace, op.Timestamp)
+	}
+
+	_, err := o.dst.Session().Database(dbName).Collection(coll).ReplaceOne(context.TODO(),
+		bson.D{{"_id", id}}, op.Object, options.Replace().SetUpsert(true))
+	return errors.Wrap(err, "insert")
+}
+
+// txnTooLargeErr is a transaction with an entry too large to be applied
+type txnTooLargeErr struct {
+	lsid      string
+	txnNumber int64
+	ts        primitive.Timestamp
+	nss       []string
+	cause     oversizedOpErr
+}
+
+func (e txnTooLargeErr) Error() string {
+	return fmt.Sprintf("transaction (lsid: %s, txnNumber: %d, commit ts: %v) on %s can't be applied: %v. "+
+		"The transaction can't be split without breaking its atomicity. "+
+		"Restore to a point in time before or after it",
+		e.lsid, e.txnNumber, e.ts, strings.Join(e.nss, ", "), e.cause)
+}
+
+// txnOpsCheck checks each of the transaction's ops fits into applyOps.
+// Otherwise, none of them should be applied. The ops are checked one by one
+// as the transaction is streamed, so they aren't held in memory.
+type txnOpsCheck struct {
+	nss   map[string]struct{}
+	cause *oversizedOpErr
+}
+
+func (c *txnOpsCheck) add(op db.Oplog) error {
+	if c.nss == nil {
+		c.nss = make(map[string]struct{})
+	}
+	c.nss[op.Namespace] = struct{}{}
+	if c.cause != nil {
+		return nil
+	}
+
+	_, err := splitApplyOps([]interface{}{op}, maxApplyOpsSize)
+	var oerr oversizedOpErr
+	if errors.As(err, &oerr) {
+		c.cause = &oerr
+		return nil
+	}
+	return err
+}
+
+// err returns the error of the transaction committed by `commit`
+// if any of its checked ops doesn't fit
+func (c *txnOpsCheck) err(commit db.Oplog) error {
+	if c.cause == nil {
+		return nil
+	}
+
+	e := txnTooLargeErr{lsid: lsidString(commit.LSID), ts: commit.Timestamp, cause: *c.cause}
+	if commit.TxnNumber != nil {
+		e.txnNumber = *commit.TxnNumber
+	}
+	for ns := range c.nss {
+		e.nss = append(e.nss, ns)
+	}
+	sort.Strings(e.nss)
+
+	return e
+}
+
+// lsidString returns the session id of the lsid as UUID
+func lsidString(lsid bson.Raw) string {
+	v, err := lsid.LookupErr("id")
+	if err != nil {
+		return lsid.String()
+	}
+	if v.Type != bsontype.Binary {
+		return v.String()
+	}
+	_, b := v.Binary()
+	if len(b) != 16 {
+		return v.String()
+	}
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
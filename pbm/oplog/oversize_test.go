@@ -0,0 +1,116 @@
+package oplog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func insertOp(ns string, i, size int) db.Oplog {
+	return db.Oplog{
+		Timestamp: primitive.Timestamp{T: 1000, I: uint32(i)},
+		Operation: "i",
+		Namespace: ns,
+		Object:    bson.D{{"_id", i}, {"pad", strings.Repeat("x", size)}},
+	}
+}
+
+func TestSplitApplyOps(t *testing.T) {
+	var entries []interface{}
+	for i := 0; i < 10; i++ {
+		entries = append(entries, insertOp("db.c", i, 1000))
+	}
+
+	batches, err := splitApplyOps(entries, 16*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || len(batches[0]) != 10 {
+		t.Errorf("expected a single batch, got %d", len(batches))
+	}
+
+	// each entry is a bit over 1KB, so 3 of them fit into a batch
+	batches, err = splitApplyOps(entries, 3500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, b := range batches {
+		cmd, err := bson.Marshal(bson.D{{"applyOps", b}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cmd) > 3500 {
+			t.Errorf("batch of %d bytes exceeds the limit", len(cmd))
+		}
+		for _, e := range b {
+			if e.(db.Oplog).Object[0].Value != n {
+				t.Fatalf("entries reordered: expected _id %d, got %v", n, e.(db.Oplog).Object[0].Value)
+			}
+			n++
+		}
+	}
+	if len(batches) != 4 || n != 10 {
+		t.Errorf("expected 4 batches of 10 entries, got %d of %d", len(batches), n)
+	}
+
+	entries = append(entries, insertOp("db.big", 10, 5000))
+	_, err = splitApplyOps(entries, 3500)
+	var oerr oversizedOpErr
+	if !errors.As(err, &oerr) || oerr.ns != "db.big" || oerr.op != "i" {
+		t.Errorf("expected oversized op error on db.big, got %v", err)
+	}
+}
+
+func TestCheckTxnOps(t *testing.T) {
+	defer func(v int) { maxApplyOpsSize = v }(maxApplyOpsSize)
+	maxApplyOpsSize = 3500
+
+	lsid, err := bson.Marshal(bson.D{{"id", primitive.Binary{
+		Subtype: 4,
+		Data:    []byte{0xf7, 0xad, 0x86, 0x7d, 0xf9, 0xa4, 0x44, 0x4c, 0xbf, 0x5f, 0x71, 0x85, 0xe7, 0x03, 0x8d, 0x6e},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txnNum := int64(7)
+	commit := db.Oplog{
+		Timestamp: primitive.Timestamp{T: 1001, I: 1},
+		Operation: "c",
+		Namespace: "admin.$cmd",
+		LSID:      lsid,
+		TxnNumber: &txnNum,
+	}
+
+	check := func(ops []db.Oplog) error {
+		var c txnOpsCheck
+		for _, op := range ops {
+			if err := c.add(op); err != nil {
+				return err
+			}
+		}
+		return c.err(commit)
+	}
+
+	ops := []db.Oplog{insertOp("db.a", 1, 1000), insertOp("db.b", 2, 1000)}
+	if err := check(ops); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	ops = append(ops, insertOp("db.c", 3, 5000), insertOp("db.d", 4, 100))
+	err = check(ops)
+	var terr txnTooLargeErr
+	if !errors.As(err, &terr) {
+		t.Fatalf("expected txn too large error, got %v", err)
+	}
+	msg := err.Error()
+	for _, s := range []string{"f7ad867d-f9a4-444c-bf5f-7185e7038d6e", "txnNumber: 7", "db.a, db.b, db.c, db.d", "ns: db.c"} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("expected %q in error: %s", s, msg)
+		}
+	}
+}
@@ -117,7 +117,9 @@ type OplogRestore struct {
 
 // NewOplogRestore creates an object for an oplog applying
 func NewOplogRestore(dst *pbm.Node, sv *pbm.MongoVersion, unsafe, preserveUUID bool, ctxn chan pbm.RestoreTxn, txnErr chan error) (*OplogRestore, error) {
-	m, err := ns.NewMatcher(append(snapshot.ExcludeFromRestore, excludeFromOplog...))
+	// writes to PBM collections are never replayed, even if they are
+	// restored from the snapshot (see pbm.RestoreCmd.WithPBMState)
+	m, err := ns.NewMatcher(append(append([]string{}, snapshot.ExcludeFromRestore...), excludeFromOplog...))
 	if err != nil {
 		return nil, errors.Wrap(err, "create matcher for the collections exclude")
 	}
@@ -262,6 +264,29 @@ func (o *OplogRestore) isOpSelected(oe *Record) bool {
 	return false
 }
 
+// NewNSWritesFilter filters out the ops of the namespaces that had no
+// writes during the backup (see pbm.BackupMeta.NSWrites). Transactions
+// are left to the namespaces selection.
+func NewNSWritesFilter(w pbm.NSWrites) OpFilter {
+	return func(r *Record) bool {
+		if r.Operation != "c" {
+			return w.Has(r.Namespace)
+		}
+		if len(r.Object) == 0 || r.Object[0].Key == "applyOps" {
+			return true
+		}
+
+		db, _, _ := strings.Cut(r.Namespace, ".")
+		m := r.Object.Map()
+		for _, cmd := range selectedNSSupportedCommands {
+			if coll, ok := m[cmd].(string); ok {
+				return w.Has(db + "." + coll)
+			}
+		}
+		return true
+	}
+}
+
 func (o *OplogRestore) LastOpTS() uint32 {
 	return atomic.LoadUint32(&o.lastOpT)
 }
@@ -427,34 +452,57 @@ func (o *OplogRestore) handleTxnOp(meta txn.Meta, op db.Oplog) error {
 		}
 	}
 
-	// From here, we're applying transaction entries
+	// From here, we're applying transaction entries. They are checked
+	// first, so a transaction that can't be applied isn't applied partly.
+	// The transaction is streamed from the buffer for each pass rather
+	// than copied out of it.
+	var chk txnOpsCheck
+	err = o.forTxnOps(meta, chk.add)
+	if err != nil {
+		return err
+	}
+	err = chk.err(op)
+	if err != nil {
+		return err
+	}
+	err = o.forTxnOps(meta, func(op db.Oplog) error {
+		return errors.Wrap(o.handleNonTxnOp(op), "applying transaction op")
+	})
+	if err != nil {
+		return err
+	}
+
+	err = o.txnBuffer.PurgeTxn(meta)
+	if err != nil {
+		return errors.Wrap(err, "cleaning up transaction buffer")
+	}
+
+	return nil
+}
+
+// forTxnOps calls fn on each op of the buffered transaction. The stream is
+// drained on error, so the buffer's streamer isn't left blocked.
+func (o *OplogRestore) forTxnOps(meta txn.Meta, fn func(db.Oplog) error) error {
 	ops, errs := o.txnBuffer.GetTxnStream(meta)
 
-Loop:
+	var ferr error
 	for {
 		select {
 		case op, ok := <-ops:
 			if !ok {
-				break Loop
+				return ferr
 			}
-			err = o.handleNonTxnOp(op)
-			if err != nil {
-				return errors.Wrap(err, "applying transaction op")
+			if ferr == nil {
+				ferr = fn(op)
 			}
 		case err := <-errs:
 			if err != nil {
 				return errors.Wrap(err, "replaying transaction")
 			}
-			break Loop
+			// the errors are closed after the ops
+			return ferr
 		}
 	}
-
-	err = o.txnBuffer.PurgeTxn(meta)
-	if err != nil {
-		return errors.Wrap(err, "cleaning up transaction buffer")
-	}
-
-	return nil
 }
 
 func (o *OplogRestore) handleNonTxnOp(op db.Oplog) error {
@@ -610,6 +658,17 @@ func (o *OplogRestore) handleNonTxnOp(op db.Oplog) error {
 	}
 
 	err = o.applyOps([]interface{}{op})
+	var oerr oversizedOpErr
+	if errors.As(err, &oerr) {
+		if op.Operation != "i" {
+			return errors.Wrap(err, "can't be applied")
+		}
+		err = o.applyOversizedInsert(op)
+		if err != nil {
+			return errors.Wrapf(err, "apply oversized insert to %s at %v", op.Namespace, op.Timestamp)
+		}
+		return nil
+	}
 	if err != nil {
 		// https://jira.percona.com/browse/PBM-818
 		if o.unsafe &&
@@ -683,7 +742,24 @@ func extractIndexDocumentFromCommitIndexBuilds(op db.Oplog) (string, []*idx.Inde
 
 // applyOps is a wrapper for the applyOps database command, we pass in
 // a session to avoid opening a new connection for a few inserts at a time.
+// Entries not fitting into a single command are applied with several ones
+// (see splitApplyOps).
 func (o *OplogRestore) applyOps(entries []interface{}) error {
+	batches, err := splitApplyOps(entries, maxApplyOpsSize)
+	if err != nil {
+		return err
+	}
+	for _, b := range batches {
+		err = o.runApplyOps(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (o *OplogRestore) runApplyOps(entries []interface{}) error {
 	singleRes := o.dst.Session().Database("admin").RunCommand(context.TODO(), bson.D{{"applyOps", entries}})
 	if err := singleRes.Err(); err != nil {
 		return errors.Wrap(err, "applyOps")
@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -31,6 +30,8 @@ const (
 	LogCollection = "pbmLog"
 	// ConfigCollection is the name of the mongo collection that contains PBM configs
 	ConfigCollection = "pbmConfig"
+	// ConfigHistoryCollection keeps the last versions of the config
+	ConfigHistoryCollection = "pbmConfigHistory"
 	// LockCollection is the name of the mongo collection that is used
 	// by agents to coordinate mutually exclusive operations (e.g. backup/restore)
 	LockCollection = "pbmLock"
@@ -46,15 +47,58 @@ const (
 	CmdStreamCollection = "pbmCmd"
 	// PITRChunksCollection contains index metadata of PITR chunks
 	PITRChunksCollection = "pbmPITRChunks"
+	// PITRGapsCollection contains the gaps found in the PITR oplog slicing
+	PITRGapsCollection = "pbmPITRGaps"
 	// PBMOpLogCollection contains log of acquired locks (hence run ops)
 	PBMOpLogCollection = "pbmOpLog"
 	// AgentsStatusCollection is an agents registry with its status/health checks
 	AgentsStatusCollection = "pbmAgents"
+	// IncrResetCollection keeps the mark of the physical restore that reset
+	// the incremental backup history
+	IncrResetCollection = "pbmIncrReset"
+	// EventsCollection keeps the full history of backups and restores
+	// state transitions (see MaxConditions)
+	EventsCollection = "pbmEvents"
+	// BackupFreezeCollection keeps the cluster-wide backup freeze
+	// (see BackupFreeze)
+	BackupFreezeCollection = "pbmBackupFreeze"
 
 	// MetadataFileSuffix is a suffix for the metadata file on a storage
 	MetadataFileSuffix = ".pbm.json"
 )
 
+// Collections are the PBM collections in the DB. They describe the cluster
+// PBM runs on, so the logical restore never brings them from a backup
+// (unless asked with RestoreCmd.WithPBMState, see StateCollections).
+var Collections = []string{
+	LogCollection,
+	ConfigCollection,
+	ConfigHistoryCollection,
+	LockCollection,
+	LockOpCollection,
+	BcpCollection,
+	RestoresCollection,
+	CmdStreamCollection,
+	PITRChunksCollection,
+	PITRGapsCollection,
+	PBMOpLogCollection,
+	AgentsStatusCollection,
+	IncrResetCollection,
+	EventsCollection,
+	BackupFreezeCollection,
+}
+
+// StateCollections are the PBM collections the config and the backups
+// metadata are rebuilt from. The rest hold the runtime state (locks,
+// commands, logs, agents) and are never restored.
+var StateCollections = []string{
+	ConfigCollection,
+	ConfigHistoryCollection,
+	BcpCollection,
+	PITRChunksCollection,
+	IncrResetCollection,
+}
+
 // ErrNotFound - object not found
 var ErrNotFound = errors.New("not found")
 
@@ -73,6 +117,9 @@ const (
 	CmdDeleteBackup Command = "delete"
 	CmdDeletePITR   Command = "deletePitr"
 	CmdCleanup      Command = "cleanup"
+	CmdAgentDoctor  Command = "agentDoctor"
+	CmdCompact      Command = "compact"
+	CmdListDBpath   Command = "listDBpath"
 )
 
 func (c Command) String() string {
@@ -97,6 +144,12 @@ func (c Command) String() string {
 		return "Delete PITR chunks"
 	case CmdCleanup:
 		return "Cleanup backups and PITR chunks"
+	case CmdAgentDoctor:
+		return "Agents self-check"
+	case CmdCompact:
+		return "Compact incremental backups"
+	case CmdListDBpath:
+		return "List the dbpath content"
 	default:
 		return "Undefined"
 	}
@@ -166,6 +219,9 @@ type BackupCmd struct {
 	Namespaces       []string                 `bson:"nss,omitempty"`
 	Compression      compress.CompressionType `bson:"compression"`
 	CompressionLevel *int                     `bson:"level,omitempty"`
+	// Nodes are the nodes (in format `rs/host:port`) the backup has to be
+	// taken from. It overrides the nodes priority for respective replsets.
+	Nodes []string `bson:"nodes,omitempty"`
 }
 
 func (b BackupCmd) String() string {
@@ -183,6 +239,42 @@ type RestoreCmd struct {
 	BackupName string            `bson:"backupName"`
 	Namespaces []string          `bson:"nss,omitempty"`
 	RSMap      map[string]string `bson:"rsMap,omitempty"`
+	// AllowPlatformMismatch turns unsafe platform mismatches
+	// of the physical restore into warnings
+	AllowPlatformMismatch bool `bson:"allowPlatformMismatch,omitempty"`
+	// Force lets the physical restore remove files in the dbpath
+	// that weren't created by mongod and proceed if the dbpath is
+	// shared with other mongod instances
+	Force bool `bson:"force,omitempty"`
+	// WithPBMState makes the logical restore bring the PBM config and
+	// backups metadata (see StateCollections) from the backup. Then the
+	// backups list is resynced from the restored config's storage.
+	WithPBMState bool `bson:"withPBMState,omitempty"`
+	// WiredTigerSalvage makes the physical restore salvage the data with
+	// `mongod --repair`. It has to be allowed by RestoreConf.WiredTigerSalvage.
+	WiredTigerSalvage bool `bson:"wtSalvage,omitempty"`
+	// LeaderRS is the replset whose primary coordinates the physical
+	// restore instead of the config server primary. The config server
+	// metadata is still rewritten on the config server nodes.
+	LeaderRS string `bson:"leaderRS,omitempty"`
+	// PartlyDonePolicy overrides RestoreConf.PartlyDonePolicy for
+	// the physical restore
+	PartlyDonePolicy PartlyDonePolicy `bson:"partlyDonePolicy,omitempty"`
+	// NewReplsetID makes the physical restore generate a new
+	// settings.replicaSetId for the restored replsets instead of keeping
+	// the cluster's one (e.g. when the backup is cloned to a new cluster
+	// that shouldn't be mistaken for the original one). Members that aren't
+	// restored keep the old ID and have to be wiped and initial-synced.
+	NewReplsetID bool `bson:"newReplsetId,omitempty"`
+	// SnapshotOnly makes the physical restore land each replset exactly at
+	// its backup checkpoint: the oplog isn't rolled forward to the backup
+	// point. Replsets may end up at different points in time. Incremental
+	// backups can't be restored this way (see SnapshotOnlyRecoveryTS).
+	SnapshotOnly bool `bson:"snapshotOnly,omitempty"`
+	// OplogSizeMB is the oplog size of the physically restored nodes.
+	// Zero means the node keeps its oplog size if it's larger than
+	// the backup's one.
+	OplogSizeMB float64 `bson:"oplogSizeMB,omitempty"`
 }
 
 func (r RestoreCmd) String() string {
@@ -192,8 +284,14 @@ func (r RestoreCmd) String() string {
 type ReplayCmd struct {
 	Name  string              `bson:"name"`
 	Start primitive.Timestamp `bson:"start,omitempty"`
+	// End is where the replay stops. Zero means the latest point all
+	// replsets have the continuous oplog for.
 	End   primitive.Timestamp `bson:"end,omitempty"`
 	RSMap map[string]string   `bson:"rsMap,omitempty"`
+	// NoWritesAfter fails the replay if a replset has writes (other than
+	// PBM's and system ones) after it. It's the finish of the restore
+	// the replay rolls forward from, so the data is still at its point.
+	NoWritesAfter primitive.Timestamp `bson:"noWritesAfter,omitempty"`
 }
 
 func (c ReplayCmd) String() string {
@@ -207,6 +305,12 @@ type PITRestoreCmd struct {
 	Bcp        string            `bson:"bcp"`
 	Namespaces []string          `bson:"nss,omitempty"`
 	RSMap      map[string]string `bson:"rsMap,omitempty"`
+
+	// MaxLag (in seconds) lets the restore stop before the target time if
+	// the oplog isn't available up to it (e.g. PITR slicing lags behind).
+	// All replsets stop at the same point which is no more than MaxLag
+	// before the target. Zero means the oplog must reach the target.
+	MaxLag int64 `bson:"maxLag,omitempty"`
 }
 
 func (p PITRestoreCmd) String() string {
@@ -243,27 +347,79 @@ var (
 	WaitBackupStart = WaitActionStart + PITRcheckRange*12/10
 )
 
-// OpLog represents log of started operation.
-// Operation progress can be get from logs by OPID.
-// Basically it is a log of all ever taken locks. With the
-// uniqueness by rs + opid
-type OpLog struct {
-	LockHeader `bson:",inline" json:",inline"`
-}
-
 type PBM struct {
 	Conn *mongo.Client
 	log  *log.Logger
 	ctx  context.Context
+
+	// hbWC is the write concern for heartbeats and agents' statuses.
+	// nil means the connection's one.
+	hbWC *writeconcern.WriteConcern
+}
+
+// ConnOpts are the options of the PBM metadata connection.
+//
+// Both write concerns default to "majority". Anything weaker saves
+// cross-region round trips (e.g. on geo-distributed CSRS) but the writes
+// acknowledged by the minority of nodes may be rolled back on failover.
+// For heartbeats it means a stale heartbeat for a moment which is harmless.
+// For WriteConcern it affects backup/restore state transitions, so
+// the failover may lose them. WriteConcern can't be w:0 as PBM has to know
+// its metadata writes succeeded.
+type ConnOpts struct {
+	// WriteConcern is for the PBM metadata writes
+	WriteConcern string
+	// HeartbeatWriteConcern is for the frequent low-importance writes:
+	// backup/restore heartbeats and agents' statuses. Lock heartbeats
+	// aren't among them as a lost one may let another agent take over
+	// the lock. Neither are the backup size counters as a lost increment
+	// leaves the size wrong for good.
+	HeartbeatWriteConcern string
+}
+
+// ParseWriteConcern parses the write concern: "majority" or
+// the number of nodes. Empty string means "majority".
+func ParseWriteConcern(w string) (*writeconcern.WriteConcern, error) {
+	switch w {
+	case "", "majority":
+		return writeconcern.New(writeconcern.WMajority()), nil
+	}
+
+	n, err := strconv.Atoi(w)
+	if err != nil || n < 0 {
+		return nil, errors.Errorf("invalid write concern %q, should be \"majority\" or the number of nodes", w)
+	}
+
+	return writeconcern.New(writeconcern.W(n)), nil
 }
 
 // New creates a new PBM object.
 // In the sharded cluster both agents and ctls should have a connection to ConfigServer replica set in order to communicate via PBM collections.
 // If agent's or ctl's local node is not a member of ConfigServer, after discovering current topology connection will be established to ConfigServer.
 func New(ctx context.Context, uri, appName string) (*PBM, error) {
-	uri = "mongodb://" + strings.Replace(uri, "mongodb://", "", 1)
+	return NewWithOpts(ctx, uri, appName, ConnOpts{})
+}
 
-	client, err := connect(ctx, uri, appName)
+// NewWithOpts is the same as New but with the connection options.
+func NewWithOpts(ctx context.Context, uri, appName string, opts ConnOpts) (*PBM, error) {
+	uri = NormalizeURI(uri)
+
+	wc, err := ParseWriteConcern(opts.WriteConcern)
+	if err != nil {
+		return nil, errors.Wrap(err, "write concern")
+	}
+	if !wc.Acknowledged() {
+		return nil, errors.New("write concern: unacknowledged w:0 can't be used for PBM metadata")
+	}
+	var hbWC *writeconcern.WriteConcern
+	if opts.HeartbeatWriteConcern != "" {
+		hbWC, err = ParseWriteConcern(opts.HeartbeatWriteConcern)
+		if err != nil {
+			return nil, errors.Wrap(err, "heartbeat write concern")
+		}
+	}
+
+	client, err := connect(ctx, uri, appName, wc)
 	if err != nil {
 		return nil, errors.Wrap(err, "create mongo connection")
 	}
@@ -271,6 +427,7 @@ func New(ctx context.Context, uri, appName string) (*PBM, error) {
 	pbm := &PBM{
 		Conn: client,
 		ctx:  ctx,
+		hbWC: hbWC,
 	}
 	inf, err := pbm.GetNodeInfo()
 	if err != nil {
@@ -296,17 +453,12 @@ func New(ctx context.Context, uri, appName string) (*PBM, error) {
 		return nil, errors.Wrapf(err, "define config server connection URI from %s", csvr)
 	}
 
-	curi, err := url.Parse(uri)
+	// Preserving the `replicaSet` parameter will cause an error while connecting to the ConfigServer (mismatched replicaset names)
+	curi, err := HostsURI(uri, chost[1])
 	if err != nil {
-		return nil, errors.Wrapf(err, "parse mongo-uri '%s'", uri)
+		return nil, err
 	}
-
-	// Preserving the `replicaSet` parameter will cause an error while connecting to the ConfigServer (mismatched replicaset names)
-	query := curi.Query()
-	query.Del("replicaSet")
-	curi.RawQuery = query.Encode()
-	curi.Host = chost[1]
-	pbm.Conn, err = connect(ctx, curi.String(), appName)
+	pbm.Conn, err = connect(ctx, curi, appName, wc)
 	if err != nil {
 		return nil, errors.Wrapf(err, "create mongo connection to configsvr with connection string '%s'", curi)
 	}
@@ -387,13 +539,18 @@ func (p *PBM) setupNewDB() error {
 	if err != nil && !strings.Contains(err.Error(), "already exists") {
 		return errors.Wrap(err, "ensure log collection")
 	}
-	_, err = p.Conn.Database(DB).Collection(PBMOpLogCollection).Indexes().CreateOne(
+	_, err = p.Conn.Database(DB).Collection(PBMOpLogCollection).Indexes().CreateMany(
 		p.ctx,
-		mongo.IndexModel{
-			Keys: bson.D{{"opid", 1}, {"replset", 1}},
-			Options: options.Index().
-				SetUnique(true).
-				SetSparse(true),
+		[]mongo.IndexModel{
+			{
+				Keys: bson.D{{"opid", 1}, {"replset", 1}},
+				Options: options.Index().
+					SetUnique(true).
+					SetSparse(true),
+			},
+			{
+				Keys: bson.D{{"start_ts", 1}},
+			},
 		},
 	)
 	if err != nil && !strings.Contains(err.Error(), "already exists") {
@@ -419,6 +576,27 @@ func (p *PBM) setupNewDB() error {
 		return errors.Wrap(err, "ensure pitr chunks index")
 	}
 
+	_, err = p.Conn.Database(DB).Collection(ConfigHistoryCollection).Indexes().CreateOne(
+		p.ctx,
+		mongo.IndexModel{
+			Keys:    bson.D{{"v", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return errors.Wrap(err, "ensure config history index")
+	}
+
+	_, err = p.Conn.Database(DB).Collection(EventsCollection).Indexes().CreateOne(
+		p.ctx,
+		mongo.IndexModel{
+			Keys: bson.D{{"op", 1}, {"name", 1}, {"timestamp", 1}},
+		},
+	)
+	if err != nil && !strings.Contains(err.Error(), "already exists") {
+		return errors.Wrap(err, "ensure events index")
+	}
+
 	_, err = p.Conn.Database(DB).Collection(BcpCollection).Indexes().CreateMany(
 		p.ctx,
 		[]mongo.IndexModel{
@@ -437,14 +615,8 @@ func (p *PBM) setupNewDB() error {
 	return err
 }
 
-func connect(ctx context.Context, uri, appName string) (*mongo.Client, error) {
-	client, err := mongo.NewClient(
-		options.Client().ApplyURI(uri).
-			SetAppName(appName).
-			SetReadPreference(readpref.Primary()).
-			SetReadConcern(readconcern.Majority()).
-			SetWriteConcern(writeconcern.New(writeconcern.WMajority())),
-	)
+func connect(ctx context.Context, uri, appName string, wc *writeconcern.WriteConcern) (*mongo.Client, error) {
+	client, err := mongo.NewClient(clientOptions(uri, appName, wc))
 	if err != nil {
 		return nil, errors.Wrap(err, "create mongo client")
 	}
@@ -461,6 +633,28 @@ func connect(ctx context.Context, uri, appName string) (*mongo.Client, error) {
 	return client, nil
 }
 
+func clientOptions(uri, appName string, wc *writeconcern.WriteConcern) *options.ClientOptions {
+	return options.Client().ApplyURI(uri).
+		SetAppName(appName).
+		SetReadPreference(readpref.Primary()).
+		SetReadConcern(readconcern.Majority()).
+		SetWriteConcern(wc)
+}
+
+// hbCollection returns the collection handle for heartbeats and
+// agents' statuses writes (see ConnOpts.HeartbeatWriteConcern)
+func (p *PBM) hbCollection(name string) *mongo.Collection {
+	return p.Conn.Database(DB).Collection(name, hbCollectionOptions(p.hbWC))
+}
+
+func hbCollectionOptions(wc *writeconcern.WriteConcern) *options.CollectionOptions {
+	opts := options.Collection()
+	if wc != nil {
+		opts.SetWriteConcern(wc)
+	}
+	return opts
+}
+
 type BackupType string
 
 const (
@@ -503,7 +697,55 @@ type BackupMeta struct {
 	Err              string                   `bson:"error,omitempty" json:"error,omitempty"`
 	PBMVersion       string                   `bson:"pbm_version,omitempty" json:"pbm_version,omitempty"`
 	BalancerStatus   BalancerMode             `bson:"balancer" json:"balancer"`
-	runtimeError     error
+	// BalancerStop is the balancer state verified before the data of
+	// the sharded backup is copied. Nil for non-sharded backups.
+	BalancerStop *BalancerStopState `bson:"balancer_stop,omitempty" json:"balancer_stop,omitempty"`
+	// ClusterConsistentTS is the point all replsets of the backup are
+	// consistent at (see BackupMeta.ConsistentTS). Set once the backup is done.
+	ClusterConsistentTS primitive.Timestamp `bson:"cluster_consistent_ts,omitempty" json:"cluster_consistent_ts,omitempty"`
+	// Layout is the storage layout of the physical backup data objects.
+	// Nil means the flat layout.
+	Layout *BackupLayout `bson:"layout,omitempty" json:"layout,omitempty"`
+	// NSWrites is the write spans of the selected namespaces of the
+	// selective backup. Restores replay the oplog of each namespace
+	// only within its span.
+	NSWrites NSWrites `bson:"ns_writes,omitempty" json:"ns_writes,omitempty"`
+	// NodesOverride is a map of replset to the node explicitly chosen by
+	// the user to take the backup from (see `pbm backup --node`).
+	NodesOverride map[string]string `bson:"nodes_override,omitempty" json:"nodes_override,omitempty"`
+	// Protected backups can't be deleted, neither explicitly nor by
+	// the clean-up (see PBM.SetBackupProtected)
+	Protected bool `bson:"protected,omitempty" json:"protected,omitempty"`
+
+	// LastVerifiedTS and LastVerifyStatus are the time and outcome of
+	// the last verification of the backup (see PBM.SetBackupVerified)
+	LastVerifiedTS   int64        `bson:"last_verified_ts,omitempty" json:"last_verified_ts,omitempty"`
+	LastVerifyStatus VerifyStatus `bson:"last_verify_status,omitempty" json:"last_verify_status,omitempty"`
+	// LastRestoreTestName and LastRestoreTestTS are the last successful
+	// restore of the backup (see PBM.SetBackupRestoreTested)
+	LastRestoreTestName string `bson:"last_restore_test,omitempty" json:"last_restore_test,omitempty"`
+	LastRestoreTestTS   int64  `bson:"last_restore_test_ts,omitempty" json:"last_restore_test_ts,omitempty"`
+
+	// SizeUncompressed is the size of the backup data before the compression
+	// (see BackupMeta.CompressionRatio)
+	SizeUncompressed int64 `bson:"size_uncompressed,omitempty" json:"size_uncompressed,omitempty"`
+	// SizeFrom are the replsets whose data size is already added to
+	// the Size (see PBM.IncBackupSize)
+	SizeFrom []string `bson:"size_from,omitempty" json:"-"`
+	// LowCompression is set if the compression ratio of the backup is below
+	// BackupConf.MinCompressionRatio
+	LowCompression bool `bson:"low_compression,omitempty" json:"low_compression,omitempty"`
+
+	runtimeError error
+}
+
+// CompressionRatio returns how many times the backup data shrunk by
+// the compression. Zero means unknown (e.g. backups made by older versions).
+func (b *BackupMeta) CompressionRatio() float64 {
+	if b.Size <= 0 || b.SizeUncompressed <= 0 {
+		return 0
+	}
+	return float64(b.SizeUncompressed) / float64(b.Size)
 }
 
 func (b *BackupMeta) Error() error {
@@ -552,6 +794,21 @@ type BackupReplset struct {
 	Error            string              `bson:"error,omitempty" json:"error,omitempty"`
 	Conditions       []Condition         `bson:"conditions" json:"conditions"`
 	MongodOpts       *MongodOpts         `bson:"mongod_opts,omitempty" json:"mongod_opts,omitempty"`
+	// Platform of the node the physical backup was taken from
+	Platform *Platform `bson:"platform,omitempty" json:"platform,omitempty"`
+	// MongoVersion is the mongod version of the node that performed backup
+	MongoVersion string `bson:"mongodb_version,omitempty" json:"mongodb_version,omitempty"`
+	// NSWrites is the write spans of the selected namespaces seen in
+	// the replset's oplog (see BackupMeta.NSWrites)
+	NSWrites NSWrites `bson:"ns_writes,omitempty" json:"ns_writes,omitempty"`
+	// ReplLagSec is the replication lag of Node when the backup started
+	ReplLagSec int `bson:"repl_lag_sec,omitempty" json:"repl_lag_sec,omitempty"`
+	// CheckpointTS is the timestamp of the WiredTiger checkpoint
+	// the physical backup was taken of
+	CheckpointTS primitive.Timestamp `bson:"checkpoint_ts,omitempty" json:"checkpoint_ts,omitempty"`
+	// NSStats is the stats of the replset's namespaces taken along with
+	// the upload (see NSStatsTimeout)
+	NSStats *NSStats `bson:"ns_stats,omitempty" json:"ns_stats,omitempty"`
 }
 
 type File struct {
@@ -561,6 +818,22 @@ type File struct {
 	Size    int64       `bson:"fileSize" json:"fileSize"`
 	StgSize int64       `bson:"stgSize" json:"stgSize"`
 	Fmode   os.FileMode `bson:"fmode" json:"fmode"`
+	// Checksum is the CRC-32C (hex) of the uploaded data before
+	// the compression
+	Checksum string `bson:"checksum,omitempty" json:"checksum,omitempty"`
+}
+
+// RawSize returns the size of the file's data (the chunk after the offset
+// for incremental backups) before the compression
+func (f File) RawSize() int64 {
+	if f.Len == 0 {
+		return f.Size
+	}
+	// Len is a multiple of the block size, so it may run past the file end
+	if f.Off+f.Len > f.Size {
+		return f.Size - f.Off
+	}
+	return f.Len
 }
 
 func (f File) String() string {
@@ -605,14 +878,19 @@ const (
 
 func (p *PBM) SetBackupMeta(m *BackupMeta) error {
 	m.LastTransitionTS = m.StartTS
-	m.Conditions = append(m.Conditions, Condition{
+	c := Condition{
 		Timestamp: m.StartTS,
 		Status:    m.Status,
-	})
+	}
+	m.Conditions = append(m.Conditions, c)
 
 	_, err := p.Conn.Database(DB).Collection(BcpCollection).InsertOne(p.ctx, m)
+	if err != nil {
+		return err
+	}
 
-	return err
+	p.addEvent(EventBackup, m.Name, "", c)
+	return nil
 }
 
 // RS returns the metadata of the replset with given name.
@@ -626,6 +904,43 @@ func (b *BackupMeta) RS(name string) *BackupReplset {
 	return nil
 }
 
+// ConsistentTS returns the minimal last write of the backup replsets. The
+// data of all replsets is consistent at this point.
+func (b *BackupMeta) ConsistentTS() primitive.Timestamp {
+	var ts primitive.Timestamp
+	for _, rs := range b.Replsets {
+		if ts.IsZero() || primitive.CompareTimestamp(rs.LastWriteTS, ts) == -1 {
+			ts = rs.LastWriteTS
+		}
+	}
+	return ts
+}
+
+// SetBalancerStopState records the verified balancer state of the backup
+func (p *PBM) SetBalancerStopState(bcpName string, st *BalancerStopState) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}},
+		bson.D{
+			{"$set", bson.M{"balancer_stop": st}},
+		},
+	)
+
+	return err
+}
+
+func (p *PBM) SetClusterConsistentTS(bcpName string, ts primitive.Timestamp) error {
+	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}},
+		bson.D{
+			{"$set", bson.M{"cluster_consistent_ts": ts}},
+		},
+	)
+
+	return err
+}
+
 func (p *PBM) ChangeBackupStateOPID(opid string, s Status, msg string) error {
 	return p.changeBackupState(bson.D{{"opid", opid}}, s, msg)
 }
@@ -634,20 +949,63 @@ func (p *PBM) ChangeBackupState(bcpName string, s Status, msg string) error {
 	return p.changeBackupState(bson.D{{"name", bcpName}}, s, msg)
 }
 
+// ChangeBackupStateAt sets the backup's state with the given transition
+// time. The transition isn't repeated if the backup already has it, so
+// a write retried after an unknown outcome is safe.
+func (p *PBM) ChangeBackupStateAt(bcpName string, s Status, msg string, ts int64) error {
+	c := Condition{Timestamp: ts, Status: s, Error: msg}
+	res, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", bcpName}, {"conditions", noCondition(c)}},
+		bson.D{
+			{"$set", bson.M{"status": s}},
+			{"$set", bson.M{"last_transition_ts": ts}},
+			{"$set", bson.M{"error": msg}},
+			pushCondition("conditions", c),
+		},
+	)
+	if err != nil || res.ModifiedCount == 0 {
+		return err
+	}
+
+	p.addEvent(EventBackup, bcpName, "", c)
+	return nil
+}
+
 func (p *PBM) changeBackupState(clause bson.D, s Status, msg string) error {
+	_, err := p.changeBackupStateIf(clause, s, msg)
+	return err
+}
+
+// changeBackupStateIf changes the state of the backup matching the clause.
+// It returns false if there is none.
+func (p *PBM) changeBackupStateIf(clause bson.D, s Status, msg string) (bool, error) {
 	ts := time.Now().UTC().Unix()
-	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+	c := Condition{Timestamp: ts, Status: s, Error: msg}
+	res := p.Conn.Database(DB).Collection(BcpCollection).FindOneAndUpdate(
 		p.ctx,
 		clause,
 		bson.D{
 			{"$set", bson.M{"status": s}},
 			{"$set", bson.M{"last_transition_ts": ts}},
 			{"$set", bson.M{"error": msg}},
-			{"$push", bson.M{"conditions": Condition{Timestamp: ts, Status: s, Error: msg}}},
+			pushCondition("conditions", c),
 		},
+		options.FindOneAndUpdate().SetProjection(bson.D{{"name", 1}}),
 	)
+	if errors.Is(res.Err(), mongo.ErrNoDocuments) {
+		return false, nil
+	}
 
-	return err
+	var b struct {
+		Name string `bson:"name"`
+	}
+	if err := res.Decode(&b); err != nil {
+		return false, err
+	}
+
+	p.addEvent(EventBackup, b.Name, "", c)
+	return true, nil
 }
 
 func (p *PBM) BackupHB(bcpName string) error {
@@ -656,7 +1014,7 @@ func (p *PBM) BackupHB(bcpName string) error {
 		return errors.Wrap(err, "read cluster time")
 	}
 
-	_, err = p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+	_, err = p.hbCollection(BcpCollection).UpdateOne(
 		p.ctx,
 		bson.D{{"name", bcpName}},
 		bson.D{
@@ -705,39 +1063,66 @@ func (p *PBM) SetLastWrite(bcpName string, last primitive.Timestamp) error {
 
 func (p *PBM) AddRSMeta(bcpName string, rs BackupReplset) error {
 	rs.LastTransitionTS = rs.StartTS
-	rs.Conditions = append(rs.Conditions, Condition{
+	c := Condition{
 		Timestamp: rs.StartTS,
 		Status:    rs.Status,
-	})
+	}
+	rs.Conditions = append(rs.Conditions, c)
 	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
 		p.ctx,
 		bson.D{{"name", bcpName}},
 		bson.D{{"$addToSet", bson.M{"replsets": rs}}},
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	p.addEvent(EventBackup, bcpName, rs.Name, c)
+	return nil
 }
 
 func (p *PBM) ChangeRSState(bcpName string, rsName string, s Status, msg string) error {
-	ts := time.Now().UTC().Unix()
-	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
+	return p.ChangeRSStateAt(bcpName, rsName, s, msg, time.Now().UTC().Unix())
+}
+
+// ChangeRSStateAt sets the replset's state with the given transition time.
+// It is used to persist state transitions that happened earlier. The
+// transition isn't repeated if the replset already has it, so a write
+// retried after an unknown outcome is safe.
+func (p *PBM) ChangeRSStateAt(bcpName string, rsName string, s Status, msg string, ts int64) error {
+	c := Condition{Timestamp: ts, Status: s, Error: msg}
+	res, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(
 		p.ctx,
-		bson.D{{"name", bcpName}, {"replsets.name", rsName}},
+		bson.D{
+			{"name", bcpName},
+			{"replsets", bson.M{"$elemMatch": bson.M{"name": rsName, "conditions": noCondition(c)}}},
+		},
 		bson.D{
 			{"$set", bson.M{"replsets.$.status": s}},
 			{"$set", bson.M{"replsets.$.last_transition_ts": ts}},
 			{"$set", bson.M{"replsets.$.error": msg}},
-			{"$push", bson.M{"replsets.$.conditions": Condition{Timestamp: ts, Status: s, Error: msg}}},
+			pushCondition("replsets.$.conditions", c),
 		},
 	)
+	if err != nil || res.ModifiedCount == 0 {
+		return err
+	}
 
-	return err
+	p.addEvent(EventBackup, bcpName, rsName, c)
+	return nil
 }
 
-func (p *PBM) IncBackupSize(ctx context.Context, bcpName string, size int64) error {
+// IncBackupSize adds the size of the replset's backup data on the storage
+// and before the compression to the backup's ones. The size of the replset
+// is added only once (see BackupMeta.SizeFrom), so a write retried after
+// an unknown outcome is safe.
+func (p *PBM) IncBackupSize(ctx context.Context, bcpName, rsName string, size, sizeUncompressed int64) error {
 	_, err := p.Conn.Database(DB).Collection(BcpCollection).UpdateOne(ctx,
-		bson.D{{"name", bcpName}},
-		bson.D{{"$inc", bson.M{"size": size}}})
+		bson.D{{"name", bcpName}, {"size_from", bson.M{"$ne": rsName}}},
+		bson.D{
+			{"$inc", bson.M{"size": size, "size_uncompressed": sizeUncompressed}},
+			{"$addToSet", bson.M{"size_from": rsName}},
+		})
 
 	return err
 }
@@ -0,0 +1,68 @@
+package pbm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWriteConcern(t *testing.T) {
+	for w, want := range map[string]interface{}{
+		"":         "majority",
+		"majority": "majority",
+		"1":        1,
+		"0":        0,
+	} {
+		wc, err := ParseWriteConcern(w)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", w, err)
+			continue
+		}
+		if got := wc.GetW(); got != want {
+			t.Errorf("%q: expected w %v, got %v", w, want, got)
+		}
+	}
+	for _, w := range []string{"-1", "all", "1.5"} {
+		if _, err := ParseWriteConcern(w); err == nil {
+			t.Errorf("%q: expected error", w)
+		}
+	}
+
+	wc, _ := ParseWriteConcern("1")
+	if got := clientOptions("mongodb://localhost", "test", wc).WriteConcern.GetW(); got != 1 {
+		t.Errorf("client write concern: expected 1, got %v", got)
+	}
+
+	if o := hbCollectionOptions(nil); o.WriteConcern != nil {
+		t.Errorf("expected heartbeats to use the client write concern, got %v", o.WriteConcern)
+	}
+	if got := hbCollectionOptions(wc).WriteConcern.GetW(); got != 1 {
+		t.Errorf("heartbeat write concern: expected 1, got %v", got)
+	}
+
+	_, err := NewWithOpts(context.Background(), "localhost", "test", ConnOpts{HeartbeatWriteConcern: "some"})
+	if err == nil {
+		t.Error("expected invalid heartbeat write concern to be rejected")
+	}
+	_, err = NewWithOpts(context.Background(), "localhost", "test", ConnOpts{WriteConcern: "0"})
+	if err == nil || !strings.Contains(err.Error(), "unacknowledged") {
+		t.Errorf("expected unacknowledged write concern to be rejected, got %v", err)
+	}
+}
+
+func TestBackupConsistentTS(t *testing.T) {
+	b := BackupMeta{Replsets: []BackupReplset{
+		{Name: "rs0", LastWriteTS: primitive.Timestamp{T: 20, I: 1}},
+		{Name: "cfg", LastWriteTS: primitive.Timestamp{T: 10, I: 5}},
+		{Name: "rs1", LastWriteTS: primitive.Timestamp{T: 10, I: 7}},
+	}}
+	if ts := b.ConsistentTS(); ts != (primitive.Timestamp{T: 10, I: 5}) {
+		t.Errorf("expected the min last write, got %v", ts)
+	}
+
+	if ts := (&BackupMeta{}).ConsistentTS(); !ts.IsZero() {
+		t.Errorf("expected zero ts with no replsets, got %v", ts)
+	}
+}
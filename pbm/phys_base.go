@@ -0,0 +1,126 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PhysicalBase is the backup a physical point-in-time restore is based on
+type PhysicalBase struct {
+	Backup *BackupMeta
+	// Chain is the list of backups to restore, from the incremental base
+	// up to the Backup itself. It has the only item for non-incremental
+	// backups.
+	Chain []string
+}
+
+// Increments returns the number of incremental backups on top of the base
+func (b *PhysicalBase) Increments() int {
+	return len(b.Chain) - 1
+}
+
+// ResolvePhysicalBase picks the backup the physical point-in-time restore
+// to `ts` should be based on. It is the most recent physical or incremental
+// backup with LastWriteTS <= ts and an intact chain of increments. If several
+// backups are equally recent, the one with the shortest chain wins.
+//
+// If `force` is set, the backup with that name is used as the base if it is
+// suitable.
+func ResolvePhysicalBase(bcps []BackupMeta, ts primitive.Timestamp, force string) (*PhysicalBase, error) {
+	byName := make(map[string]*BackupMeta, len(bcps))
+	for i := range bcps {
+		byName[bcps[i].Name] = &bcps[i]
+	}
+
+	if force != "" {
+		b, ok := byName[force]
+		if !ok {
+			return nil, errors.Errorf("backup %s: %v", force, ErrNotFound)
+		}
+		if err := checkPhysicalBase(b, ts); err != nil {
+			return nil, errors.Wrapf(err, "backup %s", force)
+		}
+		chain, err := bcpChain(b, byName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "backup %s", force)
+		}
+
+		return &PhysicalBase{Backup: b, Chain: chain}, nil
+	}
+
+	var base *PhysicalBase
+	for i := range bcps {
+		b := &bcps[i]
+		if checkPhysicalBase(b, ts) != nil {
+			continue
+		}
+		chain, err := bcpChain(b, byName)
+		if err != nil {
+			continue
+		}
+
+		if base != nil {
+			c := primitive.CompareTimestamp(b.LastWriteTS, base.Backup.LastWriteTS)
+			if c < 0 || c == 0 && len(chain) >= len(base.Chain) {
+				continue
+			}
+		}
+		base = &PhysicalBase{Backup: b, Chain: chain}
+	}
+
+	if base == nil {
+		return nil, errors.Errorf("no physical or incremental backup with an intact chain found before %v", ts)
+	}
+
+	return base, nil
+}
+
+func checkPhysicalBase(b *BackupMeta, ts primitive.Timestamp) error {
+	if b.Type != PhysicalBackup && b.Type != IncrementalBackup {
+		return errors.Errorf("%s backup can't be a base for physical restore", b.Type)
+	}
+	if b.Status != StatusDone {
+		return errors.Errorf("backup status is %s", b.Status)
+	}
+	if primitive.CompareTimestamp(b.LastWriteTS, ts) > 0 {
+		return errors.Errorf("backup last write %v is after the target time %v", b.LastWriteTS, ts)
+	}
+
+	return nil
+}
+
+// bcpChain returns the chain of backups from the incremental base
+// to the given backup. It fails if any backup in the chain is missing
+// or unsuccessful.
+func bcpChain(b *BackupMeta, bcps map[string]*BackupMeta) ([]string, error) {
+	chain := []string{b.Name}
+	for b.Type == IncrementalBackup && b.SrcBackup != "" {
+		src, ok := bcps[b.SrcBackup]
+		if !ok {
+			return nil, errors.Errorf("chain is broken: backup %s is missing", b.SrcBackup)
+		}
+		if src.Status != StatusDone {
+			return nil, errors.Errorf("chain is broken: backup %s status is %s", src.Name, src.Status)
+		}
+		// guard against loops in corrupted meta
+		if len(chain) > len(bcps) {
+			return nil, errors.New("chain is broken: loop detected")
+		}
+
+		chain = append([]string{src.Name}, chain...)
+		b = src
+	}
+
+	return chain, nil
+}
+
+// GetPhysicalBase resolves the base backup for the physical point-in-time
+// restore among all successful backups (see ResolvePhysicalBase).
+func (p *PBM) GetPhysicalBase(ts primitive.Timestamp, force string) (*PhysicalBase, error) {
+	bcps, err := p.BackupsDoneList(nil, 0, -1)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backups list")
+	}
+
+	return ResolvePhysicalBase(bcps, ts, force)
+}
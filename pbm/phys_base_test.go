@@ -0,0 +1,59 @@
+package pbm
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestResolvePhysicalBase(t *testing.T) {
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+	bcps := []BackupMeta{
+		{Name: "l1", Type: LogicalBackup, Status: StatusDone, LastWriteTS: ts(95)},
+		{Name: "p1", Type: PhysicalBackup, Status: StatusDone, LastWriteTS: ts(10)},
+		{Name: "i1", Type: IncrementalBackup, Status: StatusDone, LastWriteTS: ts(20)},
+		{Name: "i2", Type: IncrementalBackup, Status: StatusDone, LastWriteTS: ts(30), SrcBackup: "i1"},
+		{Name: "i3", Type: IncrementalBackup, Status: StatusDone, LastWriteTS: ts(40), SrcBackup: "i2"},
+		{Name: "p2", Type: PhysicalBackup, Status: StatusDone, LastWriteTS: ts(40)},
+		{Name: "i5", Type: IncrementalBackup, Status: StatusDone, LastWriteTS: ts(60), SrcBackup: "i4"},
+		{Name: "p3", Type: PhysicalBackup, Status: StatusError, LastWriteTS: ts(70)},
+	}
+
+	cases := []struct {
+		ts    uint32
+		force string
+		want  []string
+		err   bool
+	}{
+		{5, "", nil, true},
+		{15, "", []string{"p1"}, false},
+		{35, "", []string{"i1", "i2"}, false},
+		// equally recent, shorter chain wins
+		{45, "", []string{"p2"}, false},
+		// i5 chain is broken, p3 failed
+		{100, "", []string{"p2"}, false},
+		{100, "i3", []string{"i1", "i2", "i3"}, false},
+		{100, "i5", nil, true},
+		{100, "l1", nil, true},
+		{25, "i2", nil, true},
+		{100, "nope", nil, true},
+	}
+
+	for _, c := range cases {
+		got, err := ResolvePhysicalBase(bcps, ts(c.ts), c.force)
+		if (err != nil) != c.err {
+			t.Errorf("ts %d, force %q: expected error %v, got %v", c.ts, c.force, c.err, err)
+			continue
+		}
+		if c.err {
+			continue
+		}
+		if !reflect.DeepEqual(got.Chain, c.want) {
+			t.Errorf("ts %d, force %q: expected %v, got %v", c.ts, c.force, c.want, got.Chain)
+		}
+		if got.Increments() != len(c.want)-1 {
+			t.Errorf("ts %d, force %q: expected %d increments, got %d", c.ts, c.force, len(c.want)-1, got.Increments())
+		}
+	}
+}
@@ -21,6 +21,9 @@ import (
 const (
 	// PITRdefaultSpan oplog slicing time span
 	PITRdefaultSpan = time.Minute * 10
+	// PITRdefaultMaxSpan is the max time span of the chunk when slicing
+	// by size (see PITRConf.ChunkSizeMb)
+	PITRdefaultMaxSpan = time.Hour
 	// PITRfsPrefix is a prefix (folder) for PITR chunks on the storage
 	PITRfsPrefix = "pbmPitr"
 )
@@ -33,8 +36,34 @@ type OplogChunk struct {
 	StartTS     primitive.Timestamp      `bson:"start_ts"`
 	EndTS       primitive.Timestamp      `bson:"end_ts"`
 	Size        int64                    `bson:"size"`
+	// Span is the wall-clock time the slicer waited before the cut
+	Span time.Duration `bson:"span,omitempty"`
+	// Cut is the reason the chunk was cut
+	Cut ChunkCut `bson:"cut,omitempty"`
 }
 
+// ChunkCut is the reason the slicer has cut the PITR chunk
+type ChunkCut string
+
+const (
+	// ChunkCutSpan means the configured span has passed
+	ChunkCutSpan ChunkCut = "span"
+	// ChunkCutSize means the chunk reached the target size
+	ChunkCutSize ChunkCut = "size"
+	// ChunkCutMaxSpan means the span was extended up to the max
+	// but the chunk hasn't reached the target size
+	ChunkCutMaxSpan ChunkCut = "max_span"
+	// ChunkCutThrottled means the span was extended because
+	// of the cluster load (see PITRThrottleConf)
+	ChunkCutThrottled ChunkCut = "throttled"
+	// ChunkCutGapRisk means the span was shortened so the slicer
+	// catches up with the oplog (see PITRGapActionSpeedup)
+	ChunkCutGapRisk ChunkCut = "gap_risk"
+	// ChunkCutStop means slicing was stopped or paused (backup,
+	// config change, shutdown etc.)
+	ChunkCutStop ChunkCut = "stop"
+)
+
 // IsPITR checks if PITR is enabled
 func (p *PBM) IsPITR() (bool, error) {
 	enabled, _, err := isPITREnabled(p.ctx, p.Conn)
@@ -90,6 +119,16 @@ func (p *PBM) PITRFirstChunkMeta(rs string) (*OplogChunk, error) {
 	return p.pitrChunk(rs, 1)
 }
 
+// HasPITRChunks tells if there are any PITR chunks
+func (p *PBM) HasPITRChunks() (bool, error) {
+	n, err := p.Conn.Database(DB).Collection(PITRChunksCollection).CountDocuments(p.ctx, bson.D{},
+		options.Count().SetLimit(1))
+	if err != nil {
+		return false, errors.Wrap(err, "count")
+	}
+	return n != 0, nil
+}
+
 func (p *PBM) pitrChunk(rs string, sort int) (*OplogChunk, error) {
 	res := p.Conn.Database(DB).Collection(PITRChunksCollection).FindOne(
 		p.ctx,
@@ -210,6 +249,80 @@ func (p *PBM) PITRAddChunk(c OplogChunk) error {
 	return err
 }
 
+// PITRChunkStats is the distribution of the recent PITR chunks of the replset
+type PITRChunkStats struct {
+	RS      string           `json:"rs"`
+	Chunks  int              `json:"chunks"`
+	MinSize int64            `json:"min_size"`
+	P50Size int64            `json:"p50_size"`
+	P90Size int64            `json:"p90_size"`
+	MaxSize int64            `json:"max_size"`
+	AvgSpan time.Duration    `json:"avg_span"`
+	Cuts    map[ChunkCut]int `json:"cuts,omitempty"`
+}
+
+// PITRChunksStats returns the stats of the last `n` PITR chunks of each replset
+func (p *PBM) PITRChunksStats(n int64) ([]PITRChunkStats, error) {
+	rss, err := p.Conn.Database(DB).Collection(PITRChunksCollection).Distinct(p.ctx, "rs", bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get replsets")
+	}
+
+	rv := make([]PITRChunkStats, 0, len(rss))
+	for _, r := range rss {
+		rs, _ := r.(string)
+		cur, err := p.Conn.Database(DB).Collection(PITRChunksCollection).Find(
+			p.ctx,
+			bson.D{{"rs", rs}},
+			options.Find().SetSort(bson.D{{"start_ts", -1}}).SetLimit(n),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get chunks for %s", rs)
+		}
+		var chunks []OplogChunk
+		err = cur.All(p.ctx, &chunks)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode chunks for %s", rs)
+		}
+		rv = append(rv, chunkStats(rs, chunks))
+	}
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].RS < rv[j].RS })
+	return rv, nil
+}
+
+func chunkStats(rs string, chunks []OplogChunk) PITRChunkStats {
+	st := PITRChunkStats{RS: rs, Chunks: len(chunks)}
+	if len(chunks) == 0 {
+		return st
+	}
+
+	sizes := make([]int64, len(chunks))
+	var span int64
+	for i, c := range chunks {
+		sizes[i] = c.Size
+		span += int64(c.EndTS.T) - int64(c.StartTS.T)
+		if c.Cut != "" {
+			if st.Cuts == nil {
+				st.Cuts = make(map[ChunkCut]int)
+			}
+			st.Cuts[c.Cut]++
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	pct := func(p int) int64 {
+		return sizes[(len(sizes)-1)*p/100]
+	}
+	st.MinSize = sizes[0]
+	st.P50Size = pct(50)
+	st.P90Size = pct(90)
+	st.MaxSize = sizes[len(sizes)-1]
+	st.AvgSpan = time.Duration(span/int64(len(chunks))) * time.Second
+
+	return st
+}
+
 type Timeline struct {
 	Start uint32 `json:"start"`
 	End   uint32 `json:"end"`
@@ -273,6 +386,26 @@ func (p *PBM) PITRTimelines() (tlines []Timeline, err error) {
 	return MergeTimelines(tlns...), nil
 }
 
+// CheckPITRGap checks that the oplog of the replset continues the base
+// (backup's last write) towards the target: the base is not after the
+// target and the first chunk (`chunks` are sorted by start_ts and overlap
+// [base, target]) starts at or before the base. Otherwise, there is a gap
+// no restore can recover.
+func CheckPITRGap(rs string, chunks []OplogChunk, base, target primitive.Timestamp) error {
+	if primitive.CompareTimestamp(base, target) == 1 {
+		return errors.Errorf("base on rs %s ends at %v, after the target %v", rs, base, target)
+	}
+	if len(chunks) == 0 {
+		return errors.Errorf("PITR gap between base and oplog on rs %s: no oplog after the base end %v", rs, base)
+	}
+	if primitive.CompareTimestamp(chunks[0].StartTS, base) == 1 {
+		return errors.Errorf("PITR gap between base and oplog on rs %s: oplog starts at %v, base ends at %v",
+			rs, chunks[0].StartTS, base)
+	}
+
+	return nil
+}
+
 func gettimelines(slices []OplogChunk) (tlines []Timeline) {
 	var tl Timeline
 	var prevEnd primitive.Timestamp
@@ -0,0 +1,248 @@
+package pitr
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/oplog"
+)
+
+// gapLevel is how close the oplog is to roll over the entries
+// the slicer hasn't saved yet
+type gapLevel int
+
+const (
+	gapNone gapLevel = iota
+	// gapWarn - the risk is logged
+	gapWarn
+	// gapNotify - NotifyPITRGapRisk is sent
+	gapNotify
+	// gapAct - pbm.PITRConf.GapAction is taken
+	gapAct
+)
+
+func (l gapLevel) String() string {
+	switch l {
+	case gapWarn:
+		return "warn"
+	case gapNotify:
+		return "notify"
+	case gapAct:
+		return "act"
+	}
+	return "none"
+}
+
+// gapSpeedupSpan is the span of chunks while the slicer catches up
+// with the oplog (see pbm.PITRGapActionSpeedup)
+const gapSpeedupSpan = time.Minute
+
+// assessGap tells how close the oplog [first, last] is to roll over `lastTS`
+// (the last saved timestamp). `churn` is how many seconds of the oplog are
+// dropped per wall second, `need` is how long until the next chunk is cut.
+//
+// The level rises with the lag of `lastTS` to the oplog window ratio
+// (a half, three quarters, 90%). Yet if the oplog is rolling over, the level
+// is also raised when it would roll over `lastTS` before the next two (notify)
+// or the next one (act) chunk is cut. `rollover` is that estimate, zero if
+// the oplog isn't rolling over.
+func assessGap(lastTS, first, last primitive.Timestamp, churn float64, need time.Duration) (lvl gapLevel, rollover time.Duration) {
+	window := int64(last.T) - int64(first.T)
+	if window <= 0 {
+		return gapNone, 0
+	}
+	lag := int64(last.T) - int64(lastTS.T)
+	headroom := int64(lastTS.T) - int64(first.T)
+	if headroom < 0 {
+		headroom = 0
+	}
+	if churn > 0 {
+		rollover = time.Duration(float64(headroom) / churn * float64(time.Second))
+	}
+	soon := func(n time.Duration) bool {
+		return churn > 0 && rollover <= n*need
+	}
+
+	ratio := float64(lag) / float64(window)
+	switch {
+	case ratio >= 0.9 || soon(1):
+		lvl = gapAct
+	case ratio >= 0.75 || soon(2):
+		lvl = gapNotify
+	case ratio >= 0.5:
+		lvl = gapWarn
+	}
+
+	return lvl, rollover
+}
+
+// gapWatch follows the slicer progress against the oplog window
+type gapWatch struct {
+	action  pbm.PITRGapAction
+	notify  pbm.NotifyConf
+	bcp     pbm.BackupConf
+	bcpType pbm.BackupType
+
+	// the oldest oplog entry seen at the previous check
+	// and when it was, to measure the churn
+	oldest  primitive.Timestamp
+	checked time.Time
+
+	// level is the highest one reached in the current episode (the time
+	// since the risk appeared until it's gone), so notifications and
+	// actions are taken once per episode
+	level gapLevel
+}
+
+// SetGapWatch makes the slicer watch the oplog is not about to roll over
+// the entries it hasn't saved yet and escalate the risk (see
+// pbm.PITRConf.GapAction). It should be set before the streaming started.
+func (s *Slicer) SetGapWatch(cfg pbm.Config) {
+	s.gap = &gapWatch{
+		action:  cfg.PITR.GapAction,
+		notify:  cfg.Notify,
+		bcp:     cfg.Backup,
+		bcpType: cfg.PITR.GapBcpType(),
+	}
+}
+
+// checkGap assesses the gap risk and escalates it if needed. `need` is how
+// long until the next chunk is cut.
+func (s *Slicer) checkGap(need time.Duration) {
+	if s.gap == nil {
+		return
+	}
+	g := s.gap
+
+	first, last, err := oplogBounds(s.node.Session())
+	if err != nil {
+		s.l.Warning("gap check: get the oplog bounds: %v", err)
+		return
+	}
+
+	now := time.Now()
+	var churn float64
+	if !g.checked.IsZero() && first.T > g.oldest.T {
+		churn = float64(first.T-g.oldest.T) / now.Sub(g.checked).Seconds()
+	}
+	g.oldest, g.checked = first, now
+
+	lvl, rollover := assessGap(s.lastTS, first, last, churn, need)
+	if lvl == gapNone {
+		if g.level != gapNone {
+			s.l.Info("gap check: slicer caught up with the oplog, last saved %s", formatts(s.lastTS))
+			s.hurry = false
+		}
+		g.level = gapNone
+		return
+	}
+
+	risk := pbm.PITRGapRisk{
+		RS:          s.rs,
+		Node:        s.node.Name(),
+		LastTS:      s.lastTS,
+		Oldest:      first,
+		LagSec:      int64(last.T) - int64(s.lastTS.T),
+		WindowSec:   int64(last.T) - int64(first.T),
+		RollOverSec: int64(rollover.Seconds()),
+	}
+	msg := "gap check: oplog may roll over unsaved entries: last saved %s, oplog window %ds, lag %ds"
+	if rollover > 0 {
+		s.l.Warning(msg+", roll over in ~%v", formatts(s.lastTS), risk.WindowSec, risk.LagSec, rollover.Round(time.Second))
+	} else {
+		s.l.Warning(msg, formatts(s.lastTS), risk.WindowSec, risk.LagSec)
+	}
+
+	if lvl >= gapNotify && g.level < gapNotify {
+		err := pbm.Notify(context.Background(), g.notify, pbm.NotifyPITRGapRisk, risk)
+		if err != nil {
+			s.l.Warning("gap check: notify: %v", err)
+		}
+	}
+	if lvl >= gapAct && g.level < gapAct {
+		s.gapAction()
+	}
+	if lvl > g.level {
+		g.level = lvl
+	}
+}
+
+// gapAction takes the configured emergency action
+func (s *Slicer) gapAction() {
+	switch s.gap.action {
+	case pbm.PITRGapActionSpeedup:
+		s.l.Warning("gap check: speeding up slicing to %v", gapSpeedupSpan)
+		s.hurry = true
+	case pbm.PITRGapActionBackup:
+		locks, err := s.pbm.GetLocks(&pbm.LockHeader{Type: pbm.CmdBackup})
+		if err != nil {
+			s.l.Warning("gap check: check running backups: %v", err)
+			return
+		}
+		if len(locks) != 0 {
+			s.l.Info("gap check: backup is already running")
+			return
+		}
+
+		name := time.Now().UTC().Format(time.RFC3339)
+		err = s.pbm.SendCmd(pbm.Cmd{
+			Cmd: pbm.CmdBackup,
+			Backup: &pbm.BackupCmd{
+				Type:             s.gap.bcpType,
+				Name:             name,
+				Compression:      s.gap.bcp.Compression,
+				CompressionLevel: s.gap.bcp.CompressionLevel,
+			},
+		})
+		if err != nil {
+			s.l.Error("gap check: start backup: %v", err)
+			return
+		}
+		s.l.Warning("gap check: started %s backup %s", s.gap.bcpType, name)
+	}
+}
+
+// recordGap saves the gap the oplog rolled over since the last saved
+// timestamp and notifies about it
+func (s *Slicer) recordGap() {
+	first, _, err := oplogBounds(s.node.Session())
+	if err != nil {
+		s.l.Error("record gap: get the oplog bounds: %v", err)
+		return
+	}
+
+	g := pbm.PITRGap{
+		RS:         s.rs,
+		Node:       s.node.Name(),
+		From:       s.lastTS,
+		To:         first,
+		DetectedAt: time.Now().Unix(),
+	}
+	added, err := s.pbm.AddPITRGap(g)
+	if err != nil {
+		s.l.Error("record gap %s - %s: %v", formatts(g.From), formatts(g.To), err)
+		return
+	}
+	if !added {
+		return
+	}
+	s.l.Error("oplog gap %v - %v (%s - %s)", g.From, g.To, formatts(g.From), formatts(g.To))
+
+	if s.gap == nil {
+		return
+	}
+	err = pbm.Notify(context.Background(), s.gap.notify, pbm.NotifyPITRGap, g)
+	if err != nil {
+		s.l.Warning("record gap: notify: %v", err)
+	}
+}
+
+// isGapErr tells if the error is the oplog rolled over the unsaved entries
+func isGapErr(err error) bool {
+	var e oplog.ErrInsuffRange
+	return errors.As(err, &e)
+}
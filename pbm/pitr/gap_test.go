@@ -0,0 +1,43 @@
+package pitr
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAssessGap(t *testing.T) {
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+	// an hour long oplog
+	first, last := ts(10000), ts(13600)
+
+	cases := []struct {
+		name     string
+		lastTS   uint32
+		churn    float64
+		need     time.Duration
+		want     gapLevel
+		rollover time.Duration
+	}{
+		{"caught up", 13000, 0, 10 * time.Minute, gapNone, 0},
+		{"half behind", 11700, 0, 10 * time.Minute, gapWarn, 0},
+		{"3/4 behind", 10800, 0, 10 * time.Minute, gapNotify, 0},
+		{"90% behind", 10300, 0, 10 * time.Minute, gapAct, 0},
+		// 40 min headroom rolls over in 20 min at 2 oplog sec per sec
+		{"rolls over before the next two chunks", 12400, 2, 10 * time.Minute, gapNotify, 20 * time.Minute},
+		{"rolls over before the next chunk", 12400, 4, 10 * time.Minute, gapAct, 10 * time.Minute},
+		{"rolls over later", 12400, 1, 10 * time.Minute, gapNone, 40 * time.Minute},
+		{"rolled over", 9000, 1, 10 * time.Minute, gapAct, 0},
+	}
+	for _, c := range cases {
+		lvl, rollover := assessGap(ts(c.lastTS), first, last, c.churn, c.need)
+		if lvl != c.want || rollover != c.rollover {
+			t.Errorf("%s: got %v/%v, want %v/%v", c.name, lvl, rollover, c.want, c.rollover)
+		}
+	}
+
+	if lvl, _ := assessGap(ts(10000), ts(13600), ts(13600), 0, time.Minute); lvl != gapNone {
+		t.Errorf("empty oplog window: got %v, want %v", lvl, gapNone)
+	}
+}
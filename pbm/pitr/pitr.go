@@ -26,11 +26,23 @@ type Slicer struct {
 	node    *pbm.Node
 	rs      string
 	span    int64
+	sizer   *chunkSizer
 	lastTS  primitive.Timestamp
 	storage storage.Storage
 	oplog   *oplog.OplogBackup
 	l       *log.Event
 	ep      pbm.Epoch
+	// maxReplLag is the replication lag (in seconds) the node stops
+	// slicing at (see pbm.BackupConf.MaxReplLagSec)
+	maxReplLag int
+
+	// throttle adapts the span to the cluster load, nil if disabled
+	throttle *throttle
+	// gap watches the oplog doesn't roll over unsaved entries, nil if disabled
+	gap *gapWatch
+	// hurry makes the slicer cut chunks each gapSpeedupSpan
+	// until it catches up with the oplog
+	hurry bool
 }
 
 // NewSlicer creates an incremental backup object
@@ -44,9 +56,17 @@ func NewSlicer(rs string, cn *pbm.PBM, node *pbm.Node, to storage.Storage, ep pb
 		oplog:   oplog.NewOplogBackup(node.Session()),
 		l:       cn.Logger().NewEvent(string(pbm.CmdPITR), "", "", ep.TS()),
 		ep:      ep,
+
+		maxReplLag: pbm.DefaultMaxReplLagSec,
 	}
 }
 
+// SetMaxReplLag sets the replication lag (in seconds) the node is
+// no longer suitable for slicing at
+func (s *Slicer) SetMaxReplLag(sec int) {
+	s.maxReplLag = sec
+}
+
 // SetSpan sets span duration. Streaming will recognise the change and adjust on the next iteration.
 func (s *Slicer) SetSpan(d time.Duration) {
 	atomic.StoreInt64(&s.span, int64(d))
@@ -56,6 +76,17 @@ func (s *Slicer) GetSpan() time.Duration {
 	return time.Duration(atomic.LoadInt64(&s.span))
 }
 
+// SetChunkSize makes the slicer cut chunks by the target size rather
+// than by span. The span of a chunk is extended up to `maxSpan` on low
+// traffic. It should be set before the streaming started.
+func (s *Slicer) SetChunkSize(size int64, maxSpan time.Duration) {
+	if size <= 0 {
+		s.sizer = nil
+		return
+	}
+	s.sizer = &chunkSizer{target: size, maxSpan: maxSpan}
+}
+
 // Catchup seeks for the last saved (backed up) TS - the starting point. It should be run only
 // if the timeline was lost (e.g. on (re)start, restart after backup, node's fail).
 // The starting point sets to the last backup's or last PITR chunk's TS whichever is the most recent.
@@ -134,7 +165,7 @@ func (s *Slicer) Catchup() error {
 			return errors.Wrap(err, "get config")
 		}
 
-		err = s.upload(chnk.EndTS, baseBcp.FirstWriteTS, cfg.PITR.Compression, cfg.PITR.CompressionLevel)
+		_, err = s.upload(chnk.EndTS, baseBcp.FirstWriteTS, cfg.PITR.Compression, cfg.PITR.CompressionLevel, "", 0)
 		if err != nil {
 			s.l.Warning("create last_chunk<->sanpshot slice: %v", err)
 			// duplicate key means chunk is already created by probably another routine
@@ -246,6 +277,11 @@ func (e ErrOpMoved) Error() string {
 // LogStartMsg message to log on successful streaming start
 const LogStartMsg = "start_ok"
 
+// maxTxnHold is how long the chunk is held back by a transaction in
+// progress at the cut point (see oplog.OplogBackup.SafeCutPoint). After
+// that, the chunk is cut through the transaction.
+const maxTxnHold = 10 * time.Minute
+
 // Stream streaming (saving) chunks of the oplog to the given storage
 func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compression compress.CompressionType, level *int) error {
 	if s.lastTS.T == 0 {
@@ -254,7 +290,8 @@ func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compres
 	s.l.Info("streaming started from %v / %v", time.Unix(int64(s.lastTS.T), 0).UTC(), s.lastTS.T)
 
 	cspan := s.GetSpan()
-	tk := time.NewTicker(cspan)
+	ctick := s.tickInterval(cspan)
+	tk := time.NewTicker(ctick)
 	defer tk.Stop()
 
 	nodeInfo, err := s.node.GetInfo()
@@ -269,6 +306,7 @@ func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compres
 		return errors.Wrap(err, "check oplog sufficiency")
 	}
 	if !ok {
+		s.recordGap()
 		return oplog.ErrInsuffRange{s.lastTS}
 	}
 	s.l.Debug(LogStartMsg)
@@ -276,8 +314,21 @@ func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compres
 	lastSlice := false
 	llock := &pbm.LockHeader{Replset: s.rs}
 
+	chunkStart := time.Now()
+	var ops0, ops int64
+	if s.sizer != nil {
+		ops0, err = opCount(s.node.Session())
+		if err != nil {
+			return errors.Wrap(err, "get write operations count")
+		}
+	}
+
 	var sliceTo primitive.Timestamp
+	// since when the chunk is held back by a transaction in progress
+	var txnHold time.Time
 	for {
+		cut := pbm.ChunkCutSpan
+		tick := false
 		// waiting for a trigger
 		select {
 		// wrapping up at the current point-in-time
@@ -285,6 +336,7 @@ func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compres
 		case <-ctx.Done():
 			s.l.Info("got done signal, stopping")
 			lastSlice = true
+			cut = pbm.ChunkCutStop
 		// on wakeup or tick whatever comes first do the job
 		case bcp := <-backupSig:
 			s.l.Info("got wake_up signal")
@@ -302,8 +354,39 @@ func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compres
 					return nil
 				}
 				lastSlice = true
+				cut = pbm.ChunkCutStop
 			}
 		case <-tk.C:
+			tick = true
+		}
+		if cut == pbm.ChunkCutSpan && cspan > s.GetSpan() {
+			cut = pbm.ChunkCutThrottled
+		}
+
+		if !lastSlice {
+			s.checkGap(s.nextCutIn(cspan))
+			if t := s.tickInterval(cspan); t != ctick {
+				tk.Reset(t)
+				ctick = t
+			}
+			if s.hurry && cut != pbm.ChunkCutStop {
+				cut = pbm.ChunkCutGapRisk
+			}
+		}
+
+		if s.sizer != nil {
+			ops, err = opCount(s.node.Session())
+			if err != nil {
+				return errors.Wrap(err, "get write operations count")
+			}
+			if tick && !s.hurry {
+				s.sizer.span = cspan
+				var ok bool
+				ok, cut = s.sizer.cut(time.Since(chunkStart), ops-ops0)
+				if !ok {
+					continue
+				}
+			}
 		}
 
 		nextChunkT := time.Now().Add(cspan)
@@ -313,11 +396,12 @@ func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compres
 		if err != nil {
 			return errors.Wrap(err, "get node info")
 		}
-		q, err := backup.NodeSuits(s.node, ninf)
+		q, err := backup.NodeSuits(s.node, ninf, s.maxReplLag)
 		if err != nil {
 			return errors.Wrap(err, "node check")
 		}
-		if !q {
+		if !q.OK() {
+			s.l.Info("node is not suitable anymore: %s", q)
 			return nil
 		}
 
@@ -352,10 +436,34 @@ func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compres
 			if ld.Node != nodeInfo.Me {
 				return ErrOpMoved{ld.Node}
 			}
-			sliceTo, err = s.oplog.LastWrite()
+			lastWrite, err := s.oplog.LastWrite()
 			if err != nil {
 				return errors.Wrap(err, "define last write timestamp")
 			}
+			// don't split transactions among chunks
+			sliceTo, err = s.oplog.SafeCutPoint(s.lastTS, lastWrite)
+			if err != nil {
+				return errors.Wrap(err, "define cut point")
+			}
+			if primitive.CompareTimestamp(sliceTo, s.lastTS) <= 0 {
+				if lastSlice {
+					s.l.Info("pausing/stopping with last_ts %v", time.Unix(int64(s.lastTS.T), 0).UTC())
+					return nil
+				}
+				if txnHold.IsZero() {
+					txnHold = time.Now()
+				}
+				if time.Since(txnHold) < maxTxnHold {
+					s.l.Debug("transaction in progress since the last cut %v, postpone the chunk", s.lastTS)
+					continue
+				}
+				// the transaction has been open for too long, the chunk
+				// can't wait for it forever
+				s.l.Warning("transaction in progress since the last cut %v for over %v, "+
+					"cutting the chunk through it", s.lastTS, maxTxnHold)
+				sliceTo = lastWrite
+			}
+			txnHold = time.Time{}
 		case pbm.CmdUndefined:
 			return errors.New("undefined behaviour operation is running")
 		case pbm.CmdBackup:
@@ -378,13 +486,22 @@ func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compres
 			}
 		}
 
-		err = s.upload(s.lastTS, sliceTo, compression, level)
+		size, err := s.upload(s.lastTS, sliceTo, compression, level, cut, time.Since(chunkStart))
 		if err != nil {
+			if isGapErr(err) {
+				s.recordGap()
+			}
 			return err
 		}
 
-		logm := fmt.Sprintf("created chunk %s - %s", formatts(s.lastTS), formatts(sliceTo))
-		if !lastSlice {
+		logm := fmt.Sprintf("created chunk %s - %s [%s]", formatts(s.lastTS), formatts(sliceTo), cut)
+		switch {
+		case lastSlice:
+		case s.sizer != nil:
+			s.sizer.learn(size, ops-ops0)
+			logm += fmt.Sprintf(". Next chunk is cut on reaching %d bytes or at ~%s the latest",
+				s.sizer.target, time.Now().Add(s.sizer.maxSpan).Format("2006-01-02T15:04:05"))
+		default:
 			logm += fmt.Sprintf(". Next chunk creation scheduled to begin at ~%s", nextChunkT.Format("2006-01-02T15:04:05"))
 		}
 		s.l.Info(logm)
@@ -395,15 +512,40 @@ func (s *Slicer) Stream(ctx context.Context, backupSig <-chan *pbm.OPID, compres
 		}
 
 		s.lastTS = sliceTo
+		chunkStart = time.Now()
+		ops0 = ops
 
-		if ispan := s.GetSpan(); cspan != ispan {
-			tk.Reset(ispan)
+		if ispan := s.nextSpan(); cspan != ispan {
+			ctick = s.tickInterval(ispan)
+			tk.Reset(ctick)
 			cspan = ispan
 		}
 	}
 }
 
-func (s *Slicer) upload(from, to primitive.Timestamp, compression compress.CompressionType, level *int) error {
+// tickInterval returns how often the slicer should wake up for the given span
+func (s *Slicer) tickInterval(span time.Duration) time.Duration {
+	if s.hurry && span > gapSpeedupSpan {
+		span = gapSpeedupSpan
+	}
+	if s.sizer != nil && span > sizeCheckInterval {
+		return sizeCheckInterval
+	}
+	return span
+}
+
+// nextCutIn returns the longest time the next chunk may take to be cut
+func (s *Slicer) nextCutIn(span time.Duration) time.Duration {
+	switch {
+	case s.hurry:
+		return gapSpeedupSpan
+	case s.sizer != nil && s.sizer.maxSpan > span:
+		return s.sizer.maxSpan
+	}
+	return span
+}
+
+func (s *Slicer) upload(from, to primitive.Timestamp, compression compress.CompressionType, level *int, cut pbm.ChunkCut, span time.Duration) (int64, error) {
 	s.oplog.SetTailingSpan(from, to)
 	fname := s.chunkPath(from, to, compression)
 	// if use parent ctx, upload will be canceled on the "done" signal
@@ -418,7 +560,7 @@ func (s *Slicer) upload(from, to primitive.Timestamp, compression compress.Compr
 		if derr != nil {
 			s.l.Error("remove %s: %v", fname, derr)
 		}
-		return errors.Wrapf(err, "unable to upload chunk %v.%v", from, to)
+		return 0, errors.Wrapf(err, "unable to upload chunk %v.%v", from, to)
 	}
 
 	meta := pbm.OplogChunk{
@@ -428,13 +570,15 @@ func (s *Slicer) upload(from, to primitive.Timestamp, compression compress.Compr
 		StartTS:     from,
 		EndTS:       to,
 		Size:        size,
+		Span:        span,
+		Cut:         cut,
 	}
 	err = s.pbm.PITRAddChunk(meta)
 	if err != nil {
-		return errors.Wrapf(err, "unable to save chunk meta %v", meta)
+		return 0, errors.Wrapf(err, "unable to save chunk meta %v", meta)
 	}
 
-	return nil
+	return size, nil
 }
 
 func formatts(t primitive.Timestamp) string {
@@ -0,0 +1,88 @@
+package pitr
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// sizeCheckInterval is how often the slicer checks the chunk size
+// when slicing by size
+const sizeCheckInterval = time.Minute
+
+// chunkSizer decides when to cut the chunk so it would be close to the
+// target size. The size of the oplog written since the last cut is
+// estimated as the number of write operations times the average size
+// of the operation learned from the previous chunks.
+type chunkSizer struct {
+	target  int64
+	span    time.Duration
+	maxSpan time.Duration
+	// opSize is the moving average of the stored bytes per write operation.
+	// Zero means there is no measurement yet.
+	opSize float64
+}
+
+// cut tells if the chunk spanning `elapsed` with `ops` write operations
+// should be cut now and why. It's cut as soon as it reaches the target size.
+// Otherwise it's cut on the span unless the traffic is low (the chunk is
+// less than half of the target), then the span is extended up to the max.
+func (c *chunkSizer) cut(elapsed time.Duration, ops int64) (bool, pbm.ChunkCut) {
+	if c.opSize == 0 {
+		// nothing learned yet, make the first chunk by span
+		return elapsed >= c.span, pbm.ChunkCutSpan
+	}
+
+	size := float64(ops) * c.opSize
+	switch {
+	case size >= float64(c.target):
+		return true, pbm.ChunkCutSize
+	case elapsed >= c.maxSpan:
+		return true, pbm.ChunkCutMaxSpan
+	case elapsed >= c.span && size >= float64(c.target)/2:
+		return true, pbm.ChunkCutSpan
+	}
+	return false, ""
+}
+
+// learn updates the average operation size by the chunk of the given size
+func (c *chunkSizer) learn(size, ops int64) {
+	if ops <= 0 || size <= 0 {
+		return
+	}
+	s := float64(size) / float64(ops)
+	if c.opSize == 0 {
+		c.opSize = s
+		return
+	}
+	c.opSize = 0.7*c.opSize + 0.3*s
+}
+
+// opCount returns the number of write operations the node has applied,
+// either as a primary or as a secondary
+func opCount(cn *mongo.Client) (int64, error) {
+	type counters struct {
+		Insert int64 `bson:"insert"`
+		Update int64 `bson:"update"`
+		Delete int64 `bson:"delete"`
+	}
+	var stat struct {
+		Opcounters     counters `bson:"opcounters"`
+		OpcountersRepl counters `bson:"opcountersRepl"`
+	}
+	err := cn.Database("admin").RunCommand(context.Background(), bson.D{{"serverStatus", 1}}).Decode(&stat)
+	if err != nil {
+		return 0, errors.Wrap(err, "get serverStatus")
+	}
+
+	var n int64
+	for _, c := range []counters{stat.Opcounters, stat.OpcountersRepl} {
+		n += c.Insert + c.Update + c.Delete
+	}
+	return n, nil
+}
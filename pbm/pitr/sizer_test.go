@@ -0,0 +1,46 @@
+package pitr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestChunkSizer(t *testing.T) {
+	c := &chunkSizer{target: 1000, span: 10 * time.Minute, maxSpan: time.Hour}
+
+	// no measurements yet, cut by span
+	if ok, _ := c.cut(5*time.Minute, 1000); ok {
+		t.Error("expected no cut before the span with no measurements")
+	}
+	if ok, why := c.cut(10*time.Minute, 1000); !ok || why != pbm.ChunkCutSpan {
+		t.Errorf("expected cut by span, got %v %s", ok, why)
+	}
+
+	c.learn(2000, 100) // 20 bytes per op
+
+	cases := []struct {
+		elapsed time.Duration
+		ops     int64
+		cut     bool
+		why     pbm.ChunkCut
+	}{
+		{time.Minute, 50, true, pbm.ChunkCutSize},
+		{time.Minute, 10, false, ""},
+		{10 * time.Minute, 30, true, pbm.ChunkCutSpan},
+		{10 * time.Minute, 10, false, ""},
+		{time.Hour, 1, true, pbm.ChunkCutMaxSpan},
+	}
+	for _, tc := range cases {
+		ok, why := c.cut(tc.elapsed, tc.ops)
+		if ok != tc.cut || why != tc.why {
+			t.Errorf("%v/%d ops: expected %v %q, got %v %q", tc.elapsed, tc.ops, tc.cut, tc.why, ok, why)
+		}
+	}
+
+	c.learn(4000, 100)
+	if c.opSize <= 20 || c.opSize >= 40 {
+		t.Errorf("expected op size to move towards 40, got %f", c.opSize)
+	}
+}
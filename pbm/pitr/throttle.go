@@ -0,0 +1,164 @@
+package pitr
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// LoadFn tells if the cluster is under load and why
+type LoadFn func() (loaded bool, reason string, err error)
+
+// throttle adapts the span of the oplog chunks to the cluster load (see
+// pbm.PITRThrottleConf). Under load the span doubles with each chunk up to
+// the max, and halves back to the configured one once the load is gone.
+type throttle struct {
+	maxSpan time.Duration
+	load    LoadFn
+	// cur is the current span, zero means it's not throttled yet
+	cur time.Duration
+}
+
+// next returns the span of the next chunk. It's never less than `base`
+// and never more than the max span or `limit` (if positive).
+func (t *throttle) next(base, limit time.Duration, loaded bool) time.Duration {
+	cur := t.cur
+	if cur < base {
+		cur = base
+	}
+	if loaded {
+		cur *= 2
+	} else {
+		cur /= 2
+	}
+
+	max := t.maxSpan
+	if limit > 0 && limit < max {
+		max = limit
+	}
+	if cur > max {
+		cur = max
+	}
+	if cur < base {
+		cur = base
+	}
+
+	t.cur = cur
+	return cur
+}
+
+// SetThrottle makes the slicer adapt the chunks span to the cluster
+// load. Nil `load` means the load is checked by the node metrics
+// (see NodeLoad). It should be set before the streaming started.
+func (s *Slicer) SetThrottle(c pbm.PITRConf, load LoadFn) {
+	if c.Throttle == nil || !c.Throttle.Enabled {
+		s.throttle = nil
+		return
+	}
+	if load == nil {
+		load = NodeLoad(s.node, c.Throttle)
+	}
+	s.throttle = &throttle{maxSpan: c.ThrottleMaxSpan(), load: load}
+}
+
+// nextSpan returns the span of the next chunk: the configured one or, if
+// throttled, the one adapted to the cluster load. The throttled span never
+// exceeds a half of the oplog window so the oplog can't roll over before
+// the chunk is cut.
+func (s *Slicer) nextSpan() time.Duration {
+	span := s.GetSpan()
+	if s.throttle == nil {
+		return span
+	}
+
+	loaded, reason, err := s.throttle.load()
+	if err != nil {
+		s.l.Warning("throttle: check the cluster load: %v", err)
+		loaded = false
+	}
+	limit := span
+	first, last, err := oplogBounds(s.node.Session())
+	if err != nil {
+		s.l.Warning("throttle: get the oplog window: %v", err)
+	} else {
+		limit = time.Duration(int64(last.T)-int64(first.T)) * time.Second / 2
+	}
+
+	prev := s.throttle.cur
+	next := s.throttle.next(span, limit, loaded)
+	if next != prev && !(prev == 0 && next == span) {
+		msg := fmt.Sprintf("throttle: slicing span %v -> %v", prev, next)
+		if loaded {
+			msg += ", cluster is under load: " + reason
+		}
+		s.l.Info(msg)
+	}
+	return next
+}
+
+// NodeLoad returns the LoadFn checking the node's replication lag and
+// the number of operations waiting for the locks
+func NodeLoad(node *pbm.Node, c *pbm.PITRThrottleConf) LoadFn {
+	return func() (bool, string, error) {
+		lag, err := node.ReplicationLag()
+		if err != nil {
+			return false, "", errors.Wrap(err, "get replication lag")
+		}
+		if lag > c.ReplLag() {
+			return true, fmt.Sprintf("replication lag %ds", lag), nil
+		}
+
+		if c.MaxQueuedOps == 0 {
+			return false, "", nil
+		}
+		var stat struct {
+			GlobalLock struct {
+				CurrentQueue struct {
+					Total int `bson:"total"`
+				} `bson:"currentQueue"`
+			} `bson:"globalLock"`
+		}
+		err = node.Session().Database("admin").RunCommand(context.Background(),
+			bson.D{{"serverStatus", 1}}).Decode(&stat)
+		if err != nil {
+			return false, "", errors.Wrap(err, "get serverStatus")
+		}
+		if q := stat.GlobalLock.CurrentQueue.Total; q > c.MaxQueuedOps {
+			return true, fmt.Sprintf("%d queued operations", q), nil
+		}
+
+		return false, "", nil
+	}
+}
+
+// oplogBounds returns the timestamps of the oldest and the newest
+// entries of the node's oplog
+func oplogBounds(cn *mongo.Client) (first, last primitive.Timestamp, err error) {
+	ts := func(sort int) (primitive.Timestamp, error) {
+		var e struct {
+			TS primitive.Timestamp `bson:"ts"`
+		}
+		err := cn.Database("local").Collection("oplog.rs").FindOne(context.Background(), bson.D{},
+			options.FindOne().SetSort(bson.D{{"$natural", sort}}).SetProjection(bson.D{{"ts", 1}})).Decode(&e)
+		return e.TS, err
+	}
+
+	first, err = ts(1)
+	if err != nil {
+		return first, last, errors.Wrap(err, "get the first oplog entry")
+	}
+	last, err = ts(-1)
+	if err != nil {
+		return first, last, errors.Wrap(err, "get the last oplog entry")
+	}
+
+	return first, last, nil
+}
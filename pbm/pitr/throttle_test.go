@@ -0,0 +1,35 @@
+package pitr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottleNext(t *testing.T) {
+	base := 10 * time.Minute
+	th := &throttle{maxSpan: time.Hour}
+
+	steps := []struct {
+		loaded bool
+		limit  time.Duration
+		want   time.Duration
+	}{
+		{false, 0, base},
+		{true, 0, 20 * time.Minute},
+		{true, 0, 40 * time.Minute},
+		{true, 0, time.Hour},
+		{true, 0, time.Hour},
+		// the oplog window got short
+		{true, 30 * time.Minute, 30 * time.Minute},
+		{false, 0, 15 * time.Minute},
+		{false, 0, base},
+		{false, 0, base},
+		// limit below the configured span doesn't shrink it
+		{true, 5 * time.Minute, base},
+	}
+	for i, s := range steps {
+		if got := th.next(base, s.limit, s.loaded); got != s.want {
+			t.Errorf("step %d: got %v, want %v", i, got, s.want)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package pbm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// TimeRange is a range of the cluster time. Zero End means no upper limit.
+type TimeRange struct {
+	Start primitive.Timestamp
+	End   primitive.Timestamp
+}
+
+// overlaps tells if the chunk has any oplog within the range
+func (r TimeRange) overlaps(c OplogChunk) bool {
+	if !r.End.IsZero() && primitive.CompareTimestamp(c.StartTS, r.End) > 0 {
+		return false
+	}
+	return primitive.CompareTimestamp(c.EndTS, r.Start) >= 0
+}
+
+// CopyStats reports how the files were copied between storages
+type CopyStats struct {
+	// ServerSide is the num of files copied server-side
+	// (see storage.ServerSideCopier)
+	ServerSide int `json:"serverSide"`
+	// Streamed is the num of files streamed through the agent
+	Streamed int           `json:"streamed"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (c *CopyStats) add(m storage.CopyMode, size int64) {
+	if m == storage.CopyServerSide {
+		c.ServerSide++
+	} else {
+		c.Streamed++
+	}
+	c.Bytes += size
+}
+
+// Mode is the copy mode of all files or "mixed" if both were used
+func (c CopyStats) Mode() string {
+	switch {
+	case c.Streamed == 0 && c.ServerSide == 0:
+		return ""
+	case c.Streamed == 0:
+		return string(storage.CopyServerSide)
+	case c.ServerSide == 0:
+		return string(storage.CopyStream)
+	}
+	return "mixed"
+}
+
+// Throughput is the copy throughput in bytes per second
+func (c CopyStats) Throughput() float64 {
+	if c.Duration <= 0 {
+		return 0
+	}
+	return float64(c.Bytes) / c.Duration.Seconds()
+}
+
+func (c CopyStats) String() string {
+	return fmt.Sprintf("%d server-side, %d streamed, %d bytes in %v (%.0f B/s)",
+		c.ServerSide, c.Streamed, c.Bytes, c.Duration, c.Throughput())
+}
+
+// ExportPITRChunks copies PITR chunks overlapping the range from the
+// configured storage to the `dst` one (e.g. a storage of the DR site)
+// keeping the layout, so the chunks can be imported from there with
+// ImportPITRChunks. Chunks already present on `dst` are skipped.
+// Chunks are copied server-side if `dst` shares the backend with
+// the configured storage. The log event `l` may be nil.
+func (p *PBM) ExportPITRChunks(rng TimeRange, dst storage.Storage, l *log.Event) (CopyStats, error) {
+	stg, err := p.GetStorage(l)
+	if err != nil {
+		return CopyStats{}, errors.Wrap(err, "get storage")
+	}
+
+	chunks, err := p.PITRGetChunksSlice("", rng.Start, rng.End)
+	if err != nil {
+		return CopyStats{}, errors.Wrap(err, "get chunks")
+	}
+	if rng.End.IsZero() {
+		// PITRGetChunksSlice returns all chunks if `to` is 0
+		chunks = filterChunks(chunks, rng)
+	}
+	if len(chunks) == 0 {
+		return CopyStats{}, errors.New("no chunks found in the given range")
+	}
+
+	st, err := copyPITRChunks(chunks, stg, dst)
+	if err != nil {
+		return st, err
+	}
+	if l != nil {
+		l.Info("chunks copied: %s", st)
+	}
+
+	return st, nil
+}
+
+// ImportPITRChunks copies PITR chunks overlapping the range from the `src`
+// storage (e.g. where they were exported to by ExportPITRChunks) to the
+// configured one and registers them so they're available for PITR restore.
+// The import is refused if any chunk overlaps a registered chunk of the
+// replset other than the very same one, as the restore would replay such
+// oplog twice. The log event `l` may be nil.
+func (p *PBM) ImportPITRChunks(rng TimeRange, src storage.Storage, l *log.Event) (CopyStats, error) {
+	stg, err := p.GetStorage(l)
+	if err != nil {
+		return CopyStats{}, errors.Wrap(err, "get storage")
+	}
+
+	chunks, err := storagePITRChunks(src, rng)
+	if err != nil {
+		return CopyStats{}, errors.Wrap(err, "get chunks from the source storage")
+	}
+	if len(chunks) == 0 {
+		return CopyStats{}, errors.New("no chunks found in the given range")
+	}
+
+	existing, err := p.PITRGetChunksSlice("", rng.Start, rng.End)
+	if err != nil {
+		return CopyStats{}, errors.Wrap(err, "get registered chunks")
+	}
+	if ovr := overlappingChunks(chunks, existing); len(ovr) != 0 {
+		return CopyStats{}, errors.Errorf("chunks overlap the registered ones: %s. "+
+			"Narrow the range with --start/--end", strings.Join(ovr, ", "))
+	}
+
+	st, err := copyPITRChunks(chunks, src, stg)
+	if err != nil {
+		return st, err
+	}
+	if l != nil {
+		l.Info("chunks copied: %s", st)
+		for rs, tl := range chunksTimelines(chunks) {
+			if len(tl) > 1 {
+				l.Warning("imported chunks of %s have gaps, the timelines are: %v", rs, tl)
+			}
+		}
+	}
+
+	coll := p.Conn.Database(DB).Collection(PITRChunksCollection)
+	for _, c := range chunks {
+		q := bson.D{{"rs", c.RS}, {"start_ts", c.StartTS}, {"end_ts", c.EndTS}}
+		n, err := coll.CountDocuments(p.ctx, q)
+		if err != nil {
+			return st, errors.Wrapf(err, "check chunk %s", c.FName)
+		}
+		if n != 0 {
+			continue
+		}
+
+		// the storage listing has the file size, the slicer registers
+		// chunks with the size of the oplog in them
+		c.Size, err = chunkOplogSize(stg, c)
+		if err != nil {
+			return st, errors.Wrapf(err, "get oplog size of %s", c.FName)
+		}
+		_, err = coll.UpdateOne(p.ctx, q, bson.D{{"$setOnInsert", c}}, options.Update().SetUpsert(true))
+		if err != nil {
+			return st, errors.Wrapf(err, "register chunk %s", c.FName)
+		}
+	}
+
+	return st, nil
+}
+
+// overlappingChunks returns the chunks of `imported` overlapping any chunk
+// of the same replset in `existing`. Chunks meeting at the boundary don't
+// overlap and the very same chunk (already imported) is fine.
+func overlappingChunks(imported, existing []OplogChunk) []string {
+	var rv []string
+	for _, c := range imported {
+		for _, e := range existing {
+			if e.RS != c.RS {
+				continue
+			}
+			if e.StartTS == c.StartTS && e.EndTS == c.EndTS {
+				continue
+			}
+			if primitive.CompareTimestamp(c.StartTS, e.EndTS) < 0 &&
+				primitive.CompareTimestamp(e.StartTS, c.EndTS) < 0 {
+				rv = append(rv, fmt.Sprintf("%s overlaps %s", c.FName, e.FName))
+				break
+			}
+		}
+	}
+	return rv
+}
+
+// chunkOplogSize returns the size of the oplog in the chunk file
+func chunkOplogSize(stg storage.Storage, c OplogChunk) (int64, error) {
+	r, err := stg.SourceReader(c.FName)
+	if err != nil {
+		return 0, errors.Wrap(err, "get reader")
+	}
+	defer r.Close()
+
+	data, err := compress.Decompress(r, c.Compression)
+	if err != nil {
+		return 0, errors.Wrap(err, "decompress")
+	}
+	defer data.Close()
+
+	return io.Copy(io.Discard, data)
+}
+
+// storagePITRChunks returns the chunks on the storage overlapping the range.
+// The chunks are sorted by replset and start time.
+func storagePITRChunks(stg storage.Storage, rng TimeRange) ([]OplogChunk, error) {
+	files, err := stg.List(PITRfsPrefix, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "list files")
+	}
+
+	var chunks []OplogChunk
+	for _, f := range files {
+		c := PITRmetaFromFName(f.Name)
+		if c == nil {
+			continue
+		}
+		c.Size = f.Size
+		chunks = append(chunks, *c)
+	}
+	chunks = filterChunks(chunks, rng)
+
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].RS != chunks[j].RS {
+			return chunks[i].RS < chunks[j].RS
+		}
+		return primitive.CompareTimestamp(chunks[i].StartTS, chunks[j].StartTS) < 0
+	})
+	return chunks, nil
+}
+
+func filterChunks(chunks []OplogChunk, rng TimeRange) []OplogChunk {
+	rv := chunks[:0]
+	for _, c := range chunks {
+		if rng.overlaps(c) {
+			rv = append(rv, c)
+		}
+	}
+	return rv
+}
+
+// copyPITRChunks copies chunks from `src` to `dst` storage skipping the
+// ones that are already there. After the copy, it checks that the chunks
+// on `dst` are complete and form the same timelines as the copied ones,
+// so no gaps were introduced by a partial copy.
+func copyPITRChunks(chunks []OplogChunk, src, dst storage.Storage) (st CopyStats, err error) {
+	start := time.Now()
+	defer func() { st.Duration = time.Since(start) }()
+
+	// chunks' meta has the size of the oplog, not of the file
+	want := make([]OplogChunk, 0, len(chunks))
+	for _, c := range chunks {
+		sfi, err := src.FileStat(c.FName)
+		if err != nil {
+			return st, errors.Wrapf(err, "stat %s", c.FName)
+		}
+		c.Size = sfi.Size
+		want = append(want, c)
+
+		fi, err := dst.FileStat(c.FName)
+		if err == nil && fi.Size == c.Size {
+			continue
+		}
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return st, errors.Wrapf(err, "stat %s on the destination", c.FName)
+		}
+
+		m, err := storage.CopyTo(src, c.FName, dst, c.FName, c.Size)
+		if err != nil {
+			return st, errors.Wrapf(err, "copy %s (%s)", c.FName, m)
+		}
+		st.add(m, c.Size)
+	}
+
+	copied, err := storagePITRChunks(dst, TimeRange{})
+	if err != nil {
+		return st, errors.Wrap(err, "get chunks from the destination storage")
+	}
+
+	return st, checkCopiedChunks(want, copied)
+}
+
+// checkCopiedChunks checks that every chunk of `want` is in `got` with
+// the same size and that `got` has no gaps `want` doesn't have
+func checkCopiedChunks(want, got []OplogChunk) error {
+	have := make(map[string]OplogChunk, len(got))
+	for _, c := range got {
+		have[c.FName] = c
+	}
+
+	var copied []OplogChunk
+	var errs []string
+	for _, c := range want {
+		g, ok := have[c.FName]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Sprintf("%s is missing", c.FName))
+		case g.Size != c.Size:
+			errs = append(errs, fmt.Sprintf("%s size mismatch: %d, expected %d", c.FName, g.Size, c.Size))
+		default:
+			copied = append(copied, g)
+		}
+	}
+
+	wantTL := chunksTimelines(want)
+	for rs, tl := range chunksTimelines(copied) {
+		if !equalTimelines(tl, wantTL[rs]) {
+			errs = append(errs, fmt.Sprintf("%s timelines %v, expected %v", rs, tl, wantTL[rs]))
+		}
+	}
+	if len(errs) != 0 {
+		return errors.Errorf("copied chunks check: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// chunksTimelines returns the timelines of the chunks per replset
+func chunksTimelines(chunks []OplogChunk) map[string][]Timeline {
+	byRS := make(map[string][]OplogChunk)
+	for _, c := range chunks {
+		byRS[c.RS] = append(byRS[c.RS], c)
+	}
+
+	rv := make(map[string][]Timeline, len(byRS))
+	for rs, cs := range byRS {
+		sort.Slice(cs, func(i, j int) bool {
+			return primitive.CompareTimestamp(cs[i].StartTS, cs[j].StartTS) < 0
+		})
+		rv[rs] = gettimelines(cs)
+	}
+	return rv
+}
+
+func equalTimelines(a, b []Timeline) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Start != b[i].Start || a[i].End != b[i].End {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,193 @@
+package pbm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func exportChunkName(rs string, first, last uint32) string {
+	ft := time.Unix(int64(first), 0).UTC()
+	lt := time.Unix(int64(last), 0).UTC()
+	return PITRfsPrefix + "/" + rs + "/" + ft.Format("20060102") + "/" +
+		ft.Format("20060102150405") + "-0." + lt.Format("20060102150405") + "-0.oplog" +
+		compress.CompressionTypeS2.Suffix()
+}
+
+func TestCopyPITRChunks(t *testing.T) {
+	src := fs.New(fs.Conf{Path: t.TempDir()})
+	dst := fs.New(fs.Conf{Path: t.TempDir()})
+
+	spans := map[string][][2]uint32{
+		"rs1": {{1000, 1600}, {1600, 2200}, {2200, 2800}},
+		"rs2": {{1000, 1700}, {1700, 2800}},
+	}
+	for rs, ss := range spans {
+		for _, s := range ss {
+			n := exportChunkName(rs, s[0], s[1])
+			if err := src.Save(n, strings.NewReader(strings.Repeat("x", int(s[1]-s[0]))), -1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	chunks, err := storagePITRChunks(src, TimeRange{Start: primitive.Timestamp{T: 1650}, End: primitive.Timestamp{T: 2100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 3 || chunks[0].RS != "rs1" || chunks[0].StartTS.T != 1600 || chunks[2].RS != "rs2" {
+		t.Fatalf("unexpected chunks in range: %+v", chunks)
+	}
+
+	chunks, err = storagePITRChunks(src, TimeRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 5 {
+		t.Fatalf("expected 5 chunks, got %+v", chunks)
+	}
+
+	st, err := copyPITRChunks(chunks, src, dst)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	// the files are cloned if the filesystem supports reflinks
+	// and streamed otherwise
+	if st.ServerSide+st.Streamed != 5 || st.Bytes != 3600 {
+		t.Errorf("unexpected copy stats %+v", st)
+	}
+	// the copies don't share the data with the source
+	if err := src.Save(chunks[0].FName, strings.NewReader("y"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := dst.FileStat(chunks[0].FName); err != nil || fi.Size != chunks[0].Size {
+		t.Fatalf("the copy changed with the source: %+v, %v", fi, err)
+	}
+	if err := src.Save(chunks[0].FName, strings.NewReader(strings.Repeat("x", int(chunks[0].Size))), -1); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := storagePITRChunks(dst, TimeRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) != 5 {
+		t.Fatalf("expected 5 copied chunks, got %+v", copied)
+	}
+
+	// partial copy
+	partial := []OplogChunk{copied[0], copied[2], copied[3], copied[4]}
+	err = checkCopiedChunks(chunks, partial)
+	if err == nil || !strings.Contains(err.Error(), chunks[1].FName+" is missing") ||
+		!strings.Contains(err.Error(), "rs1 timelines") {
+		t.Errorf("partial copy: unexpected error %v", err)
+	}
+
+	partial = append([]OplogChunk{}, copied...)
+	partial[3].Size--
+	err = checkCopiedChunks(chunks, partial)
+	if err == nil || !strings.Contains(err.Error(), "size mismatch") {
+		t.Errorf("truncated copy: unexpected error %v", err)
+	}
+
+	// existing chunks aren't copied again
+	if err := dst.Delete(chunks[4].FName); err != nil {
+		t.Fatal(err)
+	}
+	st, err = copyPITRChunks(chunks, src, dst)
+	if err != nil {
+		t.Fatalf("second copy: %v", err)
+	}
+	if st.ServerSide+st.Streamed != 1 || st.Bytes != 1100 {
+		t.Errorf("second copy: unexpected copy stats %+v", st)
+	}
+
+	// storages of different backends fall back to streaming
+	sdst := fs.New(fs.Conf{Path: t.TempDir()})
+	st, err = copyPITRChunks(chunks, streamOnly{src}, sdst)
+	if err != nil {
+		t.Fatalf("streamed copy: %v", err)
+	}
+	if st.ServerSide != 0 || st.Streamed != 5 || st.Mode() != "stream" {
+		t.Errorf("streamed copy: unexpected copy stats %+v", st)
+	}
+}
+
+// streamOnly hides the server-side copy of the storage
+type streamOnly struct {
+	storage.Storage
+}
+
+func TestOverlappingChunks(t *testing.T) {
+	chunk := func(rs string, first, last uint32) OplogChunk {
+		return OplogChunk{
+			RS:      rs,
+			FName:   exportChunkName(rs, first, last),
+			StartTS: primitive.Timestamp{T: first},
+			EndTS:   primitive.Timestamp{T: last},
+		}
+	}
+	existing := []OplogChunk{
+		chunk("rs1", 1000, 1600),
+		chunk("rs1", 1600, 2200),
+		chunk("rs2", 1000, 1700),
+	}
+
+	ok := []OplogChunk{
+		chunk("rs1", 1600, 2200), // already there
+		chunk("rs1", 2200, 2800), // follows
+		chunk("rs2", 1700, 2800),
+	}
+	if ovr := overlappingChunks(ok, existing); len(ovr) != 0 {
+		t.Errorf("unexpected overlaps: %v", ovr)
+	}
+
+	bad := []OplogChunk{
+		chunk("rs1", 1500, 2000),
+		chunk("rs2", 900, 1100),
+		chunk("rs3", 1000, 1600),
+	}
+	ovr := overlappingChunks(bad, existing)
+	if len(ovr) != 2 || !strings.Contains(ovr[0], bad[0].FName) || !strings.Contains(ovr[1], bad[1].FName) {
+		t.Errorf("expected rs1 and rs2 chunks to overlap, got %v", ovr)
+	}
+}
+
+func TestChunkOplogSize(t *testing.T) {
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	n := exportChunkName("rs1", 1000, 1600)
+	buf := new(bytes.Buffer)
+	w, err := compress.Compress(buf, compress.CompressionTypeS2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(strings.Repeat("x", 10000)))
+	w.Close()
+	if err := stg.Save(n, buf, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := storagePITRChunks(stg, TimeRange{})
+	if err != nil || len(chunks) != 1 {
+		t.Fatalf("unexpected chunks %+v: %v", chunks, err)
+	}
+	// the listing has the compressed size
+	if chunks[0].Size >= 10000 {
+		t.Errorf("expected the compressed file size, got %d", chunks[0].Size)
+	}
+
+	size, err := chunkOplogSize(stg, chunks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 10000 {
+		t.Errorf("expected the oplog size 10000, got %d", size)
+	}
+}
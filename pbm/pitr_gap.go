@@ -0,0 +1,70 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PITRGap is the range of the oplog lost for PITR: the node's oplog rolled
+// over the entries before the slicer saved them. There is no PITR coverage
+// between From and To until the next backup.
+type PITRGap struct {
+	RS   string `bson:"rs" json:"rs"`
+	Node string `bson:"node" json:"node"`
+	// From is the last saved timestamp
+	From primitive.Timestamp `bson:"from" json:"from"`
+	// To is the oldest entry left in the oplog when the gap was found
+	To primitive.Timestamp `bson:"to" json:"to"`
+	// DetectedAt is the unix time the gap was found at
+	DetectedAt int64 `bson:"detected_at" json:"detected_at"`
+}
+
+// PITRGapRisk describes the slicer falling behind the oplog window
+type PITRGapRisk struct {
+	RS   string `bson:"rs" json:"rs"`
+	Node string `bson:"node" json:"node"`
+	// LastTS is the last saved timestamp
+	LastTS primitive.Timestamp `bson:"last_ts" json:"last_ts"`
+	// Oldest is the oldest entry in the oplog
+	Oldest primitive.Timestamp `bson:"oldest" json:"oldest"`
+	// LagSec is how far (in seconds) LastTS is behind the newest oplog
+	// entry, WindowSec is the oplog window
+	LagSec    int64 `bson:"lag_sec" json:"lag_sec"`
+	WindowSec int64 `bson:"window_sec" json:"window_sec"`
+	// RollOverSec is the estimate of when the oplog rolls over LastTS at
+	// the current churn. Zero if the oplog isn't rolling over.
+	RollOverSec int64 `bson:"rollover_sec,omitempty" json:"rollover_sec,omitempty"`
+}
+
+// AddPITRGap records the gap. The same gap found again (e.g. on the slicer
+// restart) is recorded once, `added` is false for such.
+func (p *PBM) AddPITRGap(g PITRGap) (added bool, err error) {
+	res, err := p.Conn.Database(DB).Collection(PITRGapsCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"rs", g.RS}, {"from", g.From}},
+		bson.D{{"$setOnInsert", g}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "upsert")
+	}
+	return res.UpsertedCount != 0, nil
+}
+
+// PITRGaps returns the recorded gaps ending after `after`, oldest first
+func (p *PBM) PITRGaps(after primitive.Timestamp) ([]PITRGap, error) {
+	cur, err := p.Conn.Database(DB).Collection(PITRGapsCollection).Find(
+		p.ctx,
+		bson.D{{"to", bson.M{"$gt": after}}},
+		options.Find().SetSort(bson.D{{"from", 1}}),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "query")
+	}
+
+	var gaps []PITRGap
+	err = cur.All(p.ctx, &gaps)
+	return gaps, errors.Wrap(err, "decode")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -385,3 +386,74 @@ func printttl(tlns ...Timeline) string {
 
 	return strings.Join(ret, ", ")
 }
+
+func TestChunkStats(t *testing.T) {
+	var chunks []OplogChunk
+	for i := 1; i <= 10; i++ {
+		cut := ChunkCutSize
+		if i%5 == 0 {
+			cut = ChunkCutMaxSpan
+		}
+		chunks = append(chunks, OplogChunk{
+			StartTS: primitive.Timestamp{T: uint32(i * 100)},
+			EndTS:   primitive.Timestamp{T: uint32(i*100 + 60)},
+			Size:    int64(i * 10),
+			Cut:     cut,
+		})
+	}
+	chunks = append(chunks, OplogChunk{
+		StartTS: primitive.Timestamp{T: 2000},
+		EndTS:   primitive.Timestamp{T: 2060},
+		Size:    5,
+	})
+
+	st := chunkStats("rs1", chunks)
+	if st.Chunks != 11 || st.MinSize != 5 || st.MaxSize != 100 {
+		t.Errorf("unexpected chunks/min/max: %+v", st)
+	}
+	if st.P50Size != 50 || st.P90Size != 90 {
+		t.Errorf("unexpected percentiles: %+v", st)
+	}
+	if st.AvgSpan != time.Minute {
+		t.Errorf("expected avg span 1m, got %v", st.AvgSpan)
+	}
+	if st.Cuts[ChunkCutSize] != 8 || st.Cuts[ChunkCutMaxSpan] != 2 || len(st.Cuts) != 2 {
+		t.Errorf("unexpected cuts: %v", st.Cuts)
+	}
+
+	if st := chunkStats("rs1", nil); st.Chunks != 0 {
+		t.Errorf("expected empty stats, got %+v", st)
+	}
+}
+
+func TestCheckPITRGap(t *testing.T) {
+	chunks := []OplogChunk{
+		{StartTS: primitive.Timestamp{T: 100}, EndTS: primitive.Timestamp{T: 200}},
+		{StartTS: primitive.Timestamp{T: 200}, EndTS: primitive.Timestamp{T: 300}},
+	}
+	target := primitive.Timestamp{T: 250}
+
+	cases := []struct {
+		name   string
+		chunks []OplogChunk
+		base   primitive.Timestamp
+		gap    bool
+		err    bool
+	}{
+		{"base inside first chunk", chunks, primitive.Timestamp{T: 150}, false, false},
+		{"base at first chunk start", chunks, primitive.Timestamp{T: 100}, false, false},
+		{"base before first chunk", chunks, primitive.Timestamp{T: 90}, true, true},
+		{"no chunks", nil, primitive.Timestamp{T: 150}, true, true},
+		{"base after target", chunks, primitive.Timestamp{T: 260}, false, true},
+	}
+	for _, c := range cases {
+		err := CheckPITRGap("rs1", c.chunks, c.base, target)
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if c.gap && !strings.Contains(err.Error(), "PITR gap between base and oplog on rs rs1") {
+			t.Errorf("%s: unexpected error message %q", c.name, err)
+		}
+	}
+}
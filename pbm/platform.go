@@ -0,0 +1,142 @@
+package pbm
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mongod build flavors
+const (
+	FlavorPSMDB      = "psmdb"
+	FlavorEnterprise = "enterprise"
+	FlavorCommunity  = "community"
+)
+
+// Platform describes the platform the mongod binary is built for
+type Platform struct {
+	Arch   string `bson:"arch" json:"arch"`
+	OS     string `bson:"os" json:"os"`
+	Flavor string `bson:"flavor" json:"flavor"`
+}
+
+func (p Platform) String() string {
+	return fmt.Sprintf("%s/%s/%s", p.OS, p.Arch, p.Flavor)
+}
+
+func (n *Node) GetPlatform() (*Platform, error) {
+	p, err := GetPlatform(n.ctx, n.cn)
+	return &p, err
+}
+
+// GetPlatform returns the platform of the mongod defined by `buildInfo`
+func GetPlatform(ctx context.Context, m *mongo.Client) (Platform, error) {
+	res := m.Database("admin").RunCommand(ctx, bson.D{{"buildInfo", 1}})
+	if err := res.Err(); err != nil {
+		return Platform{}, err
+	}
+
+	var inf struct {
+		BuildEnv struct {
+			Arch string `bson:"target_arch"`
+			OS   string `bson:"target_os"`
+		} `bson:"buildEnvironment"`
+		Modules      []string `bson:"modules"`
+		PSMDBVersion string   `bson:"psmdbVersion"`
+	}
+	if err := res.Decode(&inf); err != nil {
+		return Platform{}, err
+	}
+
+	p := Platform{
+		Arch:   inf.BuildEnv.Arch,
+		OS:     inf.BuildEnv.OS,
+		Flavor: FlavorCommunity,
+	}
+	if inf.PSMDBVersion != "" {
+		p.Flavor = FlavorPSMDB
+	} else {
+		for _, m := range inf.Modules {
+			if m == "enterprise" {
+				p.Flavor = FlavorEnterprise
+				break
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// PlatformMismatch is a difference between the platform of the backup
+// and the one it is being restored onto
+type PlatformMismatch struct {
+	Field string
+	From  string
+	To    string
+	// Unsafe means the combination is known to break the data
+	Unsafe bool
+	Reason string
+}
+
+func (m PlatformMismatch) String() string {
+	s := fmt.Sprintf("%s %s -> %s", m.Field, m.From, m.To)
+	if m.Reason != "" {
+		s += ": " + m.Reason
+	}
+	return s
+}
+
+type platformRule struct {
+	field  string
+	from   string // "*" matches any
+	to     string // "*" matches any
+	reason string
+}
+
+// platformIncompat is the compatibility table of the physical backups.
+// It lists known unsafe combinations of the backup and the target
+// platforms. Other mismatches are reported as warnings.
+var platformIncompat = []platformRule{
+	{"arch", "x86_64", "aarch64", "WiredTiger files may get corrupted"},
+	{"arch", "aarch64", "x86_64", "WiredTiger files may get corrupted"},
+	{"arch", "ppc64le", "*", "64K memory pages, WiredTiger files aren't portable"},
+	{"arch", "*", "ppc64le", "64K memory pages, WiredTiger files aren't portable"},
+	{"arch", "s390x", "*", "big-endian platform"},
+	{"arch", "*", "s390x", "big-endian platform"},
+	{"os", "windows", "*", "different on-disk paths and file handling"},
+	{"os", "*", "windows", "different on-disk paths and file handling"},
+}
+
+// CheckPlatform compares the platform of the backup with the target one.
+// Backups made by older PBM versions have no platform recorded, so
+// nothing is reported for them.
+func CheckPlatform(bcp, target *Platform) []PlatformMismatch {
+	if bcp == nil || target == nil {
+		return nil
+	}
+
+	var rv []PlatformMismatch
+	for _, f := range []struct{ name, from, to string }{
+		{"arch", bcp.Arch, target.Arch},
+		{"os", bcp.OS, target.OS},
+		{"flavor", bcp.Flavor, target.Flavor},
+	} {
+		if f.from == f.to || f.from == "" || f.to == "" {
+			continue
+		}
+
+		m := PlatformMismatch{Field: f.name, From: f.from, To: f.to}
+		for _, r := range platformIncompat {
+			if r.field == f.name && (r.from == "*" || r.from == f.from) && (r.to == "*" || r.to == f.to) {
+				m.Unsafe = true
+				m.Reason = r.reason
+				break
+			}
+		}
+		rv = append(rv, m)
+	}
+
+	return rv
+}
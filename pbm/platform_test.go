@@ -0,0 +1,63 @@
+package pbm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPlatform(t *testing.T) {
+	x86 := &Platform{Arch: "x86_64", OS: "linux", Flavor: FlavorPSMDB}
+
+	cases := []struct {
+		name   string
+		target *Platform
+		want   int
+		unsafe bool
+	}{
+		{"same", &Platform{Arch: "x86_64", OS: "linux", Flavor: FlavorPSMDB}, 0, false},
+		{"no meta", nil, 0, false},
+		{"arm", &Platform{Arch: "aarch64", OS: "linux", Flavor: FlavorPSMDB}, 1, true},
+		{"flavor", &Platform{Arch: "x86_64", OS: "linux", Flavor: FlavorEnterprise}, 1, false},
+		{"unknown arch", &Platform{Arch: "riscv64", OS: "linux", Flavor: FlavorPSMDB}, 1, false},
+		{"windows", &Platform{Arch: "x86_64", OS: "windows", Flavor: FlavorCommunity}, 2, true},
+	}
+
+	for _, c := range cases {
+		got := CheckPlatform(x86, c.target)
+		if len(got) != c.want {
+			t.Errorf("%s: expected %d mismatches, got %v", c.name, c.want, got)
+			continue
+		}
+		var unsafe bool
+		for _, m := range got {
+			unsafe = unsafe || m.Unsafe
+		}
+		if unsafe != c.unsafe {
+			t.Errorf("%s: expected unsafe %v, got %v", c.name, c.unsafe, got)
+		}
+	}
+}
+
+func TestCheckPlatformReasons(t *testing.T) {
+	cases := []struct {
+		from, to string
+		reason   string
+	}{
+		{"x86_64", "ppc64le", "64K memory pages"},
+		{"ppc64le", "aarch64", "64K memory pages"},
+		{"x86_64", "s390x", "big-endian"},
+		{"s390x", "x86_64", "big-endian"},
+		{"aarch64", "x86_64", "may get corrupted"},
+	}
+
+	for _, c := range cases {
+		got := CheckPlatform(&Platform{Arch: c.from, OS: "linux"}, &Platform{Arch: c.to, OS: "linux"})
+		if len(got) != 1 || !got[0].Unsafe {
+			t.Errorf("%s -> %s: expected an unsafe mismatch, got %v", c.from, c.to, got)
+			continue
+		}
+		if !strings.Contains(got[0].Reason, c.reason) {
+			t.Errorf("%s -> %s: expected reason %q, got %q", c.from, c.to, c.reason, got[0].Reason)
+		}
+	}
+}
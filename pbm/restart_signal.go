@@ -0,0 +1,63 @@
+package pbm
+
+import (
+	"fmt"
+)
+
+// Restart signals let an external controller (e.g. a Kubernetes operator)
+// restart the mongod nodes once the physical restore is done instead of
+// the operator doing it by hand (see RestoreConf.K8sSignals).
+//
+// Each node that has restored its data, converged with the cluster and
+// recorded the final restore status and meta writes the RestartSignalNeeded
+// file to the restore sync dir:
+//
+//	<PhysRestoresDir>/<restore>/rs.<rs>/signal/<node>.restart-needed
+//
+// and waits (up to RestoreConf.K8sSignalTimeoutSec) for the controller to
+// write RestartSignalConfirmed next to it:
+//
+//	<PhysRestoresDir>/<restore>/rs.<rs>/signal/<node>.restart-confirmed
+//
+// Then the node exits. Both files are JSON-encoded RestartSignal. The
+// controller is expected to restart the pods in the ascending
+// RestartSignal.Order and may do so once the signal is there: the restore
+// outcome is already on the storage.
+
+// RestartSignalVersion is the version of the restart signals format.
+// Confirmations of other versions are rejected.
+const RestartSignalVersion = 1
+
+const (
+	RestartSignalNeeded    = "restart-needed"
+	RestartSignalConfirmed = "restart-confirmed"
+)
+
+// Restart order of the nodes (see RestartSignal.Order): config servers
+// are started first so the shards can reach them.
+const (
+	RestartOrderConfigSrv = 0
+	RestartOrderShard     = 1
+)
+
+// RestartSignal is the content of the restart signal files. The controller
+// has to set Version, the rest of the confirmation fields are optional.
+type RestartSignal struct {
+	Version int    `json:"v"`
+	Restore string `json:"restore,omitempty"`
+	RS      string `json:"rs,omitempty"`
+	Node    string `json:"node,omitempty"`
+	// Order is the restart order of the node. Nodes of the lower order
+	// go first, the order of nodes with the same one doesn't matter.
+	Order int `json:"order"`
+	// Status is the restore status of the cluster (done or partlyDone)
+	Status Status `json:"status,omitempty"`
+	// TS is the unix time the signal was written at
+	TS int64 `json:"ts"`
+}
+
+// RestartSignalPath returns the path of the node's restart signal file
+// of the given kind (RestartSignalNeeded or RestartSignalConfirmed)
+func RestartSignalPath(restore, rs, node, kind string) string {
+	return fmt.Sprintf("%s/%s/rs.%s/signal/%s.%s", PhysRestoresDir, restore, rs, node, kind)
+}
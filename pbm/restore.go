@@ -3,6 +3,7 @@ package pbm
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
@@ -23,6 +24,7 @@ type RestoreMeta struct {
 	Namespaces       []string            `bson:"nss,omitempty" json:"nss,omitempty"`
 	StartPITR        int64               `bson:"start_pitr" json:"start_pitr"`
 	PITR             int64               `bson:"pitr" json:"pitr"`
+	StopTS           primitive.Timestamp `bson:"stop_ts,omitempty" json:"stop_ts,omitempty"` // where oplog replay stops, see PITRestoreCmd.MaxLag
 	Replsets         []RestoreReplset    `bson:"replsets" json:"replsets"`
 	Hb               primitive.Timestamp `bson:"hb" json:"hb"`
 	StartTS          int64               `bson:"start_ts" json:"start_ts"`
@@ -31,10 +33,127 @@ type RestoreMeta struct {
 	Type             BackupType          `bson:"type" json:"type"`
 	Leader           string              `bson:"l,omitempty" json:"l,omitempty"`
 	Stat             *RestoreStat        `bson:"stat,omitempty" json:"stat,omitempty"`
+	Canary           *RestoreCanary      `bson:"canary,omitempty" json:"canary,omitempty"`
+	Report           *RestoreReport      `bson:"report,omitempty" json:"report,omitempty"`
+	// Mongos is the state of the cluster's mongos after the physical
+	// restore (see RestoreConf.Mongos)
+	Mongos []MongosCheck `bson:"mongos,omitempty" json:"mongos,omitempty"`
+	// Store is the storage config the restore read the backup from
+	// (see RestoreStorageConf)
+	Store *StorageConf `bson:"store,omitempty" json:"store,omitempty"`
+	// FollowUp is the manual steps the finished restore requires
+	// (see RestoreFollowUp)
+	FollowUp []string `bson:"follow_up,omitempty" json:"follow_up,omitempty"`
+	// WiredTigerSalvage means the data was salvaged with `mongod --repair`
+	// and may be incomplete (see RestoreCmd.WiredTigerSalvage)
+	WiredTigerSalvage bool `bson:"wt_salvage,omitempty" json:"wt_salvage,omitempty"`
+	// PartlyDonePolicy is the policy the physical restore evaluated
+	// the replsets with failed nodes by
+	PartlyDonePolicy PartlyDonePolicy `bson:"partly_done_policy,omitempty" json:"partly_done_policy,omitempty"`
+	// NewReplsetID means the restored replsets got new replicaSetIds
+	// (see RestoreCmd.NewReplsetID)
+	NewReplsetID bool `bson:"new_replset_id,omitempty" json:"new_replset_id,omitempty"`
+	// Progress is the nodes' progress of the running physical restore
+	// (see PhysRestoreProgressFile)
+	Progress *RestoreProgress `bson:"progress,omitempty" json:"progress,omitempty"`
+	// SnapshotOnly means the replsets landed at their backup checkpoints
+	// (see RestoreCmd.SnapshotOnly)
+	SnapshotOnly bool `bson:"snapshot_only,omitempty" json:"snapshot_only,omitempty"`
+	// RecoveryTS is the point in time the physically restored data
+	// reflects: the backup point or, for the snapshot-only restore,
+	// the earliest of the replsets' checkpoints
+	RecoveryTS primitive.Timestamp `bson:"recovery_ts,omitempty" json:"recovery_ts,omitempty"`
+	// OplogSizeMB is the oplog size requested for the restored nodes
+	// (see RestoreCmd.OplogSizeMB)
+	OplogSizeMB float64 `bson:"oplog_size_mb,omitempty" json:"oplog_size_mb,omitempty"`
+	// PITRChunks means there were PITR oplog chunks on the restore start.
+	// They can't be replayed on top of the restored data.
+	PITRChunks bool `bson:"pitr_chunks,omitempty" json:"pitr_chunks,omitempty"`
+	// PITRDisabled means PITR was on in the physically restored config
+	// and the restore turned it off (see PhysRestorePITRDisabledFile)
+	PITRDisabled bool `bson:"pitr_disabled,omitempty" json:"pitr_disabled,omitempty"`
+}
+
+// MongosCheck is the state of a mongos after the physical restore. A mongos
+// running since before the restore holds the stale routing table.
+type MongosCheck struct {
+	Host string `bson:"host" json:"host"`
+	// Pending means the mongos wasn't checked yet. The cluster is down
+	// at the end of the physical restore, so the checks run on its start
+	// after the restore.
+	Pending   bool  `bson:"pending,omitempty" json:"pending,omitempty"`
+	Reachable bool  `bson:"reachable" json:"reachable"`
+	UptimeSec int64 `bson:"uptime,omitempty" json:"uptime,omitempty"`
+	// Restarted means the mongos was started after the restore start
+	Restarted bool `bson:"restarted" json:"restarted"`
+	// Flushed means flushRouterConfig was run on the mongos
+	Flushed bool   `bson:"flushed,omitempty" json:"flushed,omitempty"`
+	Error   string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// RestoreCanary is the outcome of the canary replset restore
+// (see RestoreConf.CanaryShard)
+type RestoreCanary struct {
+	Replset   string `bson:"rs" json:"rs"`
+	Status    Status `bson:"status" json:"status"`
+	Timestamp int64  `bson:"ts" json:"ts"`
+	Error     string `bson:"error,omitempty" json:"error,omitempty"`
 }
 
 type RestoreStat struct {
 	Download map[string]map[string]s3.DownloadStat `bson:"download,omitempty" json:"download,omitempty"`
+	// Retried are the s3.MaxRetriedFiles files with the most download
+	// retries across all nodes
+	Retried []NodeFileRetryStat `bson:"retried,omitempty" json:"retried,omitempty"`
+	// Failed are the files the nodes failed to download
+	Failed []NodeFileRetryStat `bson:"failed,omitempty" json:"failed,omitempty"`
+}
+
+// NodeFileRetryStat is the download retries of the file on the node
+type NodeFileRetryStat struct {
+	Replset          string `bson:"rs" json:"rs"`
+	Node             string `bson:"node" json:"node"`
+	s3.FileRetryStat `bson:",inline"`
+}
+
+// rollupRetries collects the files with the most download retries and
+// the failed ones out of the nodes' download stats
+func (s *RestoreStat) rollupRetries() {
+	var retried []NodeFileRetryStat
+	s.Failed = nil
+	for rs, nodes := range s.Download {
+		for n, st := range nodes {
+			for _, f := range st.Retried {
+				retried = append(retried, NodeFileRetryStat{Replset: rs, Node: n, FileRetryStat: f})
+			}
+			for _, f := range st.Failed {
+				s.Failed = append(s.Failed, NodeFileRetryStat{Replset: rs, Node: n, FileRetryStat: f})
+			}
+		}
+	}
+
+	sort.SliceStable(retried, func(i, j int) bool {
+		a, b := retried[i], retried[j]
+		if a.Retries != b.Retries {
+			return a.Retries > b.Retries
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Replset+"/"+a.Node < b.Replset+"/"+b.Node
+	})
+	if len(retried) > s3.MaxRetriedFiles {
+		retried = retried[:s3.MaxRetriedFiles]
+	}
+	s.Retried = retried
+
+	sort.Slice(s.Failed, func(i, j int) bool {
+		a, b := s.Failed[i], s.Failed[j]
+		if a.Replset+"/"+a.Node != b.Replset+"/"+b.Node {
+			return a.Replset+"/"+a.Node < b.Replset+"/"+b.Node
+		}
+		return a.Name < b.Name
+	})
 }
 
 type RestoreReplset struct {
@@ -49,6 +168,51 @@ type RestoreReplset struct {
 	Error            string              `bson:"error,omitempty" json:"error,omitempty"`
 	Conditions       Conditions          `bson:"conditions" json:"conditions"`
 	Hb               primitive.Timestamp `bson:"hb" json:"hb"`
+	// Roster is the replset members and whether they took part in
+	// the (physical) restore
+	Roster []RosterNode `bson:"roster,omitempty" json:"roster,omitempty"`
+	// Sample is the outcome of the sampling verification of the logically
+	// restored collections (see RestoreConf.VerifySampleRate)
+	Sample *SampleCheck `bson:"sample,omitempty" json:"sample,omitempty"`
+	// ReplLagSec is the lag of the most lagging secondary when the logical
+	// restore is done (see RestoreConf.ReplCatchUpTimeoutSec)
+	ReplLagSec int `bson:"repl_lag_sec,omitempty" json:"repl_lag_sec,omitempty"`
+}
+
+// SampleCheck is the outcome of the sampling verification: sampled
+// documents are read back by their _id and compared with the sampled ones
+type SampleCheck struct {
+	Sampled  int64 `bson:"sampled" json:"sampled"`
+	Verified int64 `bson:"verified" json:"verified"`
+	// Failed are the namespaces with the documents that failed the check
+	Failed []NSSampleCheck `bson:"failed,omitempty" json:"failed,omitempty"`
+}
+
+type NSSampleCheck struct {
+	NS       string   `bson:"ns" json:"ns"`
+	Sampled  int64    `bson:"sampled" json:"sampled"`
+	Verified int64    `bson:"verified" json:"verified"`
+	Errors   []string `bson:"errors,omitempty" json:"errors,omitempty"`
+}
+
+// Participation tells if the replset member took part in the restore
+// and, if not, why
+type Participation string
+
+const (
+	RosterParticipated   Participation = "participated"
+	RosterSkippedArbiter Participation = "skipped-arbiter"
+	// RosterMissingAgent is a data bearing member with no live agent
+	// on the restore start
+	RosterMissingAgent Participation = "missing-agent"
+)
+
+// RosterNode is a replset member in the restore roster
+type RosterNode struct {
+	Name          string        `bson:"name" json:"name"`
+	Participation Participation `bson:"participation" json:"participation"`
+	// Status is the final status of the participated node
+	Status Status `bson:"status,omitempty" json:"status,omitempty"`
 }
 
 type Conditions []*Condition
@@ -72,6 +236,26 @@ type RestoreNode struct {
 	Error            string              `bson:"error,omitempty" json:"error,omitempty"`
 	Conditions       Conditions          `bson:"conditions" json:"conditions"`
 	Hb               primitive.Timestamp `bson:"hb" json:"hb"`
+	// Validate is the post-restore validation of the collections
+	// (see RestoreConf.ValidateNamespaces)
+	Validate []CollValidation `bson:"validate,omitempty" json:"validate,omitempty"`
+	// TmpPort is the port of the internal mongod runs of physical restore
+	TmpPort int `bson:"tmp_port,omitempty" json:"tmp_port,omitempty"`
+	// Oplog is the oplog size the physically restored node ends up with
+	Oplog *RestoreOplog `bson:"oplog,omitempty" json:"oplog,omitempty"`
+	// Files is what the physical restore wiped and wrote on the node.
+	// Only MaxFilesList largest files are listed.
+	Files *RestoreFiles `bson:"files,omitempty" json:"files,omitempty"`
+}
+
+// CollValidation is the outcome of the `validate` command on the collection
+type CollValidation struct {
+	NS       string   `bson:"ns" json:"ns"`
+	Valid    bool     `bson:"valid" json:"valid"`
+	Errors   []string `bson:"errors,omitempty" json:"errors,omitempty"`
+	Warnings []string `bson:"warnings,omitempty" json:"warnings,omitempty"`
+	// DurationMs is how long the validation took
+	DurationMs int64 `bson:"duration_ms" json:"duration_ms"`
 }
 
 type TxnState string
@@ -103,6 +287,43 @@ func (p *PBM) RestoreSetRSTxn(name string, rsName string, txn RestoreTxn) error
 	return err
 }
 
+func (p *PBM) SetRestoreRSSample(name, rsName string, s *SampleCheck) error {
+	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.sample": s}}},
+	)
+
+	return err
+}
+
+// SetRestoreMongos sets the mongos check results of the restore
+func (p *PBM) SetRestoreMongos(name string, m []MongosCheck) error {
+	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"mongos": m}}},
+	)
+
+	return err
+}
+
+// PendingMongosRestores returns the restores with the mongos that weren't
+// checked yet (see MongosCheck.Pending)
+func (p *PBM) PendingMongosRestores() ([]RestoreMeta, error) {
+	cur, err := p.Conn.Database(DB).Collection(RestoresCollection).Find(
+		p.ctx,
+		bson.D{{"mongos.pending", true}},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "query mongo")
+	}
+
+	var rv []RestoreMeta
+	err = cur.All(p.ctx, &rv)
+	return rv, errors.Wrap(err, "decode")
+}
+
 func (p *PBM) SetCurrentOp(name string, rsName string, ts primitive.Timestamp) error {
 	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
 		p.ctx,
@@ -115,14 +336,19 @@ func (p *PBM) SetCurrentOp(name string, rsName string, ts primitive.Timestamp) e
 
 func (p *PBM) SetRestoreMeta(m *RestoreMeta) error {
 	m.LastTransitionTS = m.StartTS
-	m.Conditions = append(m.Conditions, &Condition{
+	c := Condition{
 		Timestamp: m.StartTS,
 		Status:    m.Status,
-	})
+	}
+	m.Conditions = append(m.Conditions, &c)
 
 	_, err := p.Conn.Database(DB).Collection(RestoresCollection).InsertOne(p.ctx, m)
+	if err != nil {
+		return err
+	}
 
-	return err
+	p.addEvent(EventRestore, m.Name, "", c)
+	return nil
 }
 
 func (p *PBM) GetRestoreMetaByOPID(opid string) (*RestoreMeta, error) {
@@ -146,6 +372,27 @@ func (p *PBM) getRestoreMeta(clause bson.D) (*RestoreMeta, error) {
 	return r, errors.Wrap(err, "decode")
 }
 
+// OperationStorage returns the storage config the backup or the restore
+// with the given name was made with or read from
+func (p *PBM) OperationStorage(opName string) (StorageConf, error) {
+	bcp, err := p.GetBackupMeta(opName)
+	if err == nil {
+		return bcp.Store, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return StorageConf{}, errors.Wrap(err, "get backup meta")
+	}
+
+	rst, err := p.GetRestoreMeta(opName)
+	if err != nil {
+		return StorageConf{}, errors.Wrap(err, "get restore meta")
+	}
+	if rst.Store == nil {
+		return StorageConf{}, errors.Errorf("restore %s has no storage config recorded", opName)
+	}
+	return *rst.Store, nil
+}
+
 // GetLastRestore returns last successfully finished restore
 // and nil if there is no such restore yet.
 func (p *PBM) GetLastRestore() (*RestoreMeta, error) {
@@ -166,19 +413,80 @@ func (p *PBM) GetLastRestore() (*RestoreMeta, error) {
 	return r, errors.Wrap(err, "decode")
 }
 
+// LastRecoveryPoint returns the point the cluster data was brought to by
+// the latest successful restore (or oplog replay) and the restore itself
+// (see RestoreReport.RecoveryPoint). Physical restores get into the PBM db
+// only once the cluster is up after them (see SyncPhysRestores), so it fails
+// if the storage has a physical restore started after the found one: the data
+// isn't at the recovery point then.
+func (p *PBM) LastRecoveryPoint() (primitive.Timestamp, *RestoreMeta, error) {
+	r, err := p.GetLastRestore()
+	if err != nil {
+		return primitive.Timestamp{}, nil, errors.Wrap(err, "get last restore")
+	}
+	if r == nil {
+		return primitive.Timestamp{}, nil, ErrNotFound
+	}
+	if r.Report == nil || r.Report.RecoveryPoint.IsZero() {
+		return primitive.Timestamp{}, r, errors.Errorf("no recovery point recorded by restore %s", r.Name)
+	}
+
+	stg, err := p.GetRestoreStorage(nil)
+	if err != nil {
+		return primitive.Timestamp{}, r, errors.Wrap(err, "get storage")
+	}
+	files, err := stg.List(PhysRestoresDir, ".json")
+	if err != nil {
+		return primitive.Timestamp{}, r, errors.Wrap(err, "get physical restores list from the storage")
+	}
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(f.Name, ".json"))
+	}
+	if n := physRestoreAfter(names, r); n != "" {
+		return primitive.Timestamp{}, r, errors.Errorf("physical restore %s started after restore %s, "+
+			"the cluster data isn't at its recovery point", n, r.Name)
+	}
+
+	return r.Report.RecoveryPoint, r, nil
+}
+
+// physRestoreAfter returns the name of the physical restore (out of the
+// restore names, which are their start times) started after the restore `r`
+func physRestoreAfter(names []string, r *RestoreMeta) string {
+	var last string
+	var lastT time.Time
+	for _, n := range names {
+		t, err := time.Parse(time.RFC3339Nano, n)
+		if err != nil || n == r.Name || t.Unix() < r.StartTS {
+			continue
+		}
+		if last == "" || t.After(lastT) {
+			last, lastT = n, t
+		}
+	}
+
+	return last
+}
+
 func (p *PBM) AddRestoreRSMeta(name string, rs RestoreReplset) error {
 	rs.LastTransitionTS = rs.StartTS
-	rs.Conditions = append(rs.Conditions, &Condition{
+	c := Condition{
 		Timestamp: rs.StartTS,
 		Status:    rs.Status,
-	})
+	}
+	rs.Conditions = append(rs.Conditions, &c)
 	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
 		p.ctx,
 		bson.D{{"name", name}},
 		bson.D{{"$addToSet", bson.M{"replsets": rs}}},
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	p.addEvent(EventRestore, name, rs.Name, c)
+	return nil
 }
 
 func (p *PBM) RestoreHB(name string) error {
@@ -187,7 +495,7 @@ func (p *PBM) RestoreHB(name string) error {
 		return errors.Wrap(err, "read cluster time")
 	}
 
-	_, err = p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
+	_, err = p.hbCollection(RestoresCollection).UpdateOne(
 		p.ctx,
 		bson.D{{"name", name}},
 		bson.D{
@@ -199,27 +507,32 @@ func (p *PBM) RestoreHB(name string) error {
 }
 
 func (p *PBM) ChangeRestoreStateOPID(opid string, s Status, msg string) error {
-	return p.changeRestoreState(bson.D{{"name", opid}}, s, msg)
+	return p.changeRestoreState(opid, s, msg)
 }
 
 func (p *PBM) ChangeRestoreState(name string, s Status, msg string) error {
-	return p.changeRestoreState(bson.D{{"name", name}}, s, msg)
+	return p.changeRestoreState(name, s, msg)
 }
 
-func (p *PBM) changeRestoreState(clause bson.D, s Status, msg string) error {
+func (p *PBM) changeRestoreState(name string, s Status, msg string) error {
 	ts := time.Now().UTC().Unix()
+	c := Condition{Timestamp: ts, Status: s, Error: msg}
 	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
 		p.ctx,
-		clause,
+		bson.D{{"name", name}},
 		bson.D{
 			{"$set", bson.M{"status": s}},
 			{"$set", bson.M{"last_transition_ts": ts}},
 			{"$set", bson.M{"error": msg}},
-			{"$push", bson.M{"conditions": Condition{Timestamp: ts, Status: s, Error: msg}}},
+			pushCondition("conditions", c),
 		},
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	p.addEvent(EventRestore, name, "", c)
+	return nil
 }
 
 func (p *PBM) SetRestoreBackup(name, backupName string, nss []string) error {
@@ -247,8 +560,31 @@ func (p *PBM) SetOplogTimestamps(name string, start, end int64) error {
 	return err
 }
 
+func (p *PBM) SetRestoreStopTS(name string, ts primitive.Timestamp) error {
+	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
+		p.ctx,
+		bson.M{"name": name},
+		bson.M{"$set": bson.M{"stop_ts": ts}},
+	)
+
+	return err
+}
+
+// SetRestoreRSReplLag records the replication lag of the replset
+// at the end of the restore
+func (p *PBM) SetRestoreRSReplLag(name, rsName string, lag int) error {
+	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}, {"replsets.name", rsName}},
+		bson.D{{"$set", bson.M{"replsets.$.repl_lag_sec": lag}}},
+	)
+
+	return err
+}
+
 func (p *PBM) ChangeRestoreRSState(name string, rsName string, s Status, msg string) error {
 	ts := time.Now().UTC().Unix()
+	c := Condition{Timestamp: ts, Status: s, Error: msg}
 	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
 		p.ctx,
 		bson.D{{"name", name}, {"replsets.name", rsName}},
@@ -256,11 +592,15 @@ func (p *PBM) ChangeRestoreRSState(name string, rsName string, s Status, msg str
 			{"$set", bson.M{"replsets.$.status": s}},
 			{"$set", bson.M{"replsets.$.last_transition_ts": ts}},
 			{"$set", bson.M{"replsets.$.error": msg}},
-			{"$push", bson.M{"replsets.$.conditions": Condition{Timestamp: ts, Status: s, Error: msg}}},
+			pushCondition("replsets.$.conditions", c),
 		},
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	p.addEvent(EventRestore, name, rsName, c)
+	return nil
 }
 
 func (p *PBM) RestoresList(limit int64) ([]RestoreMeta, error) {
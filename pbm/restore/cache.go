@@ -0,0 +1,241 @@
+package restore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+const defaultCacheSizeMb = 10 << 10
+
+const cacheTmpSuffix = ".tmp"
+
+// objCache is a local disk cache of storage objects. Objects are kept as
+// they are on the storage (compressed) and keyed by the object name and
+// ETag. So a changed object is never served from the cache.
+type objCache struct {
+	dir string
+	max int64
+
+	// mu guards the entries on the disk: their lookup, eviction and opening.
+	// It isn't held during the downloads.
+	mu sync.Mutex
+
+	// keysMu guards keys, the locks of the entries being fetched. So
+	// the object is downloaded once while other objects are downloaded
+	// concurrently.
+	keysMu sync.Mutex
+	keys   map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newObjCache(dir string, sizeMb int) (*objCache, error) {
+	if sizeMb <= 0 {
+		sizeMb = defaultCacheSizeMb
+	}
+
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create cache dir %s", dir)
+	}
+
+	return &objCache{dir: dir, max: int64(sizeMb) << 20}, nil
+}
+
+// SourceReader returns the reader of the object from the cache. If there
+// is no valid cache entry, it downloads the object with readFn and caches
+// it first. Objects with no ETag or bigger than the cache are not cached.
+func (c *objCache) SourceReader(stg storage.Storage, readFn func(string) (io.ReadCloser, error), name string) (io.ReadCloser, error) {
+	inf, err := stg.FileStat(name)
+	if err != nil && !errors.Is(err, storage.ErrEmpty) {
+		return nil, errors.Wrapf(err, "get stat of %s", name)
+	}
+	if inf.ETag == "" || inf.Size > c.max {
+		return readFn(name)
+	}
+
+	key := cacheKey(name, inf.ETag)
+	path := filepath.Join(c.dir, key)
+
+	unlock := c.lockKey(key)
+	defer unlock()
+
+	if r, ok := c.open(path, inf.Size); ok {
+		return r, nil
+	}
+
+	err = c.fetch(readFn, name, path, inf.Size)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cache %s", name)
+	}
+
+	// the file is opened before any other eviction may remove it
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = c.evict(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "evict cache")
+	}
+
+	return os.Open(path)
+}
+
+// open opens the cache entry if it's there and complete
+func (c *objCache) open(path string, size int64) (io.ReadCloser, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if fi.Size() != size {
+		// corrupted or incomplete entry
+		os.Remove(path)
+		return nil, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// lockKey locks the cache entry and returns its unlock
+func (c *objCache) lockKey(key string) func() {
+	c.keysMu.Lock()
+	if c.keys == nil {
+		c.keys = make(map[string]*keyLock)
+	}
+	l, ok := c.keys[key]
+	if !ok {
+		l = &keyLock{}
+		c.keys[key] = l
+	}
+	l.refs++
+	c.keysMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		c.keysMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(c.keys, key)
+		}
+		c.keysMu.Unlock()
+	}
+}
+
+func (c *objCache) fetch(readFn func(string) (io.ReadCloser, error), name, path string, size int64) error {
+	r, err := readFn(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp := path + cacheTmpSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "create file")
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "write file")
+	}
+	if n != size {
+		os.Remove(tmp)
+		return errors.Errorf("size mismatch: got %d, expected %d", n, size)
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// evict removes the least recently used entries until the cache fits
+// into the limit. The `keep` entry is never removed.
+func (c *objCache) evict(keep string) error {
+	ents, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		path  string
+		size  int64
+		atime time.Time
+	}
+	var total int64
+	var es []entry
+	for _, e := range ents {
+		if e.IsDir() || strings.HasSuffix(e.Name(), cacheTmpSuffix) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		total += fi.Size()
+		es = append(es, entry{filepath.Join(c.dir, e.Name()), fi.Size(), fi.ModTime()})
+	}
+
+	sort.Slice(es, func(i, j int) bool { return es[i].atime.Before(es[j].atime) })
+	for _, e := range es {
+		if total <= c.max {
+			break
+		}
+		if e.path == keep {
+			continue
+		}
+		err := os.Remove(e.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= e.size
+	}
+
+	return nil
+}
+
+// removeTmp removes the scratch files of the downloads that didn't finish
+func (c *objCache) removeTmp() error {
+	tmp, err := filepath.Glob(filepath.Join(c.dir, "*"+cacheTmpSuffix))
+	if err != nil {
+		return err
+	}
+	for _, f := range tmp {
+		err := os.Remove(f)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func cacheKey(name, etag string) string {
+	h := sha256.Sum256([]byte(name + "\x00" + etag))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,134 @@
+package restore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestObjCache(t *testing.T) {
+	stg := newMemStorage()
+	c, err := newObjCache(t.TempDir(), 1)
+	if err != nil {
+		t.Fatalf("create cache: %v", err)
+	}
+
+	var reads int
+	readFn := func(name string) (io.ReadCloser, error) {
+		reads++
+		return stg.SourceReader(name)
+	}
+	read := func(name string) string {
+		t.Helper()
+		r, err := c.SourceReader(stg, readFn, name)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		defer r.Close()
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		return string(b)
+	}
+
+	stg.Save("a", strings.NewReader("aaa"), -1)
+	if got := read("a"); got != "aaa" || reads != 1 {
+		t.Fatalf("first read: got %q, %d reads", got, reads)
+	}
+	if got := read("a"); got != "aaa" || reads != 1 {
+		t.Fatalf("cached read: got %q, %d reads", got, reads)
+	}
+
+	// changed object must not be served from the cache
+	stg.Save("a", strings.NewReader("AAAA"), -1)
+	if got := read("a"); got != "AAAA" || reads != 2 {
+		t.Fatalf("changed object: got %q, %d reads", got, reads)
+	}
+
+	// the least recently used entries go first
+	c.max = 10
+	stg.Save("b", bytes.NewReader(make([]byte, 6)), -1)
+	read("b")
+	ents, err := os.ReadDir(c.dir)
+	if err != nil {
+		t.Fatalf("read cache dir: %v", err)
+	}
+	var total int64
+	for _, e := range ents {
+		fi, _ := e.Info()
+		total += fi.Size()
+	}
+	if total > c.max || len(ents) != 2 {
+		t.Errorf("expected stale entry to be evicted, got %d entries of %d bytes", len(ents), total)
+	}
+
+	// too big objects are read directly
+	stg.Save("c", bytes.NewReader(make([]byte, 11)), -1)
+	read("c")
+	read("c")
+	if reads != 5 {
+		t.Errorf("expected big object to bypass the cache, got %d reads", reads)
+	}
+}
+
+func TestObjCacheConcurrentFetch(t *testing.T) {
+	stg := newMemStorage()
+	c, err := newObjCache(t.TempDir(), 1)
+	if err != nil {
+		t.Fatalf("create cache: %v", err)
+	}
+	stg.Save("a", strings.NewReader("aaa"), -1)
+	stg.Save("b", strings.NewReader("bbb"), -1)
+
+	// the download of "a" waits for the one of "b" to start
+	var mu sync.Mutex
+	reads := make(map[string]int)
+	bStarted := make(chan struct{})
+	readFn := func(name string) (io.ReadCloser, error) {
+		mu.Lock()
+		reads[name]++
+		mu.Unlock()
+		switch name {
+		case "a":
+			select {
+			case <-bStarted:
+			case <-time.After(5 * time.Second):
+				return nil, errors.New("objects are fetched one at a time")
+			}
+		case "b":
+			close(bStarted)
+		}
+		return stg.SourceReader(name)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for _, name := range []string{"a", "a", "b"} {
+		name := name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := c.SourceReader(stg, readFn, name)
+			if err != nil {
+				errs <- err
+				return
+			}
+			r.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if reads["a"] != 1 || reads["b"] != 1 {
+		t.Errorf("expected each object to be fetched once, got %v", reads)
+	}
+}
@@ -0,0 +1,83 @@
+package restore
+
+import (
+	"sync"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// cleanupScope defines on which outcome of the restore a cleanup runs
+type cleanupScope int
+
+const (
+	cleanupAlways cleanupScope = iota
+	cleanupOnSuccess
+	cleanupOnError
+)
+
+func (s cleanupScope) match(noerr bool) bool {
+	switch s {
+	case cleanupOnSuccess:
+		return noerr
+	case cleanupOnError:
+		return !noerr
+	}
+	return true
+}
+
+type cleanupItem struct {
+	name  string
+	scope cleanupScope
+	fn    func() error
+	// dismissed items are skipped
+	dismissed bool
+}
+
+// cleanupRegistry keeps the cleanups of the artifacts (tmp files, host
+// locks, goroutines etc.) created during the restore. Every artifact
+// registers its cleanup as soon as it's created, so it's undone on any
+// exit path.
+type cleanupRegistry struct {
+	mu    sync.Mutex
+	items []*cleanupItem
+}
+
+// add registers the cleanup. The returned func dismisses it, e.g. once
+// the artifact is no longer to be cleaned up or was cleaned up already.
+func (c *cleanupRegistry) add(name string, scope cleanupScope, fn func() error) (dismiss func()) {
+	it := &cleanupItem{name: name, scope: scope, fn: fn}
+
+	c.mu.Lock()
+	c.items = append(c.items, it)
+	c.mu.Unlock()
+
+	return func() {
+		c.mu.Lock()
+		it.dismissed = true
+		c.mu.Unlock()
+	}
+}
+
+// run runs the cleanups matching the outcome in the reverse order of
+// registration. Failed cleanups are logged and don't stop the others.
+// The registry is empty afterwards.
+func (c *cleanupRegistry) run(noerr bool, l *log.Event) {
+	var items []*cleanupItem
+	c.mu.Lock()
+	for _, it := range c.items {
+		if !it.dismissed && it.scope.match(noerr) {
+			items = append(items, it)
+		}
+	}
+	c.items = nil
+	c.mu.Unlock()
+
+	for i := len(items) - 1; i >= 0; i-- {
+		it := items[i]
+		l.Debug("clean-up %s", it.name)
+		err := it.fn()
+		if err != nil {
+			l.Error("clean-up %s: %v", it.name, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package restore
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+func TestCleanupRegistry(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+
+	for _, noerr := range []bool{true, false} {
+		var c cleanupRegistry
+		var ran []string
+		add := func(name string, scope cleanupScope) func() {
+			return c.add(name, scope, func() error {
+				ran = append(ran, name)
+				return errors.New("failed")
+			})
+		}
+		add("always0", cleanupAlways)
+		add("success", cleanupOnSuccess)
+		dismiss := add("dismissed", cleanupAlways)
+		add("error", cleanupOnError)
+		add("always1", cleanupAlways)
+		dismiss()
+
+		c.run(noerr, l)
+		want := []string{"always1", "error", "always0"}
+		if noerr {
+			want = []string{"always1", "success", "always0"}
+		}
+		if !reflect.DeepEqual(ran, want) {
+			t.Errorf("noerr %v: ran %v, want %v", noerr, ran, want)
+		}
+
+		ran = nil
+		c.run(noerr, l)
+		if len(ran) != 0 {
+			t.Errorf("noerr %v: second run ran %v", noerr, ran)
+		}
+	}
+}
+
+// the phases of Snapshot TestPhysRestoreCleanup fails the restore at
+const (
+	// before the flush, the data is untouched
+	phaseStart = iota
+	// the flush saved what it wipes but failed to wipe it
+	phaseFlush
+	// after the flush, on the copy of the backup data
+	phaseCopy
+	// on the first mongod run over the copied data
+	phasePrepare
+	// after the node is restored
+	phaseDone
+	// the restore succeeds
+	phaseSuccess
+)
+
+// TestPhysRestoreCleanup fails the restore at each phase of Snapshot and
+// checks what the artifacts are left with
+func TestPhysRestoreCleanup(t *testing.T) {
+	phases := []string{"start", "flush", "copy", "prepare", "done", "success"}
+
+	for phase, name := range phases {
+		t.Run(name, func(t *testing.T) {
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+
+			m := &fakeMongod{
+				client:    disconnectedClient(t),
+				readyErrs: []error{errors.Wrap(ErrMongodFailed, "[F] fatal")},
+			}
+			r := newTestPhysRestore(t, m)
+			r.name = "rst"
+			r.dbpath = mkDBPath(t, "collection-0.wt", "WiredTiger")
+			stg := newMemStorage()
+			r.stg, r.bcpStg = stg, stg
+			r.syncPathNodeFiles = pbm.PhysRestoresDir + "/rst/rs.rs1/files.host:27017"
+			r.syncPathNodeStat = pbm.PhysRestoresDir + "/rst/rs.rs1/stat.host:27017"
+			r.confOpts.NumCopyWorkers = 1
+			r.confOpts.CacheDir = filepath.Join(t.TempDir(), "cache")
+			r.files = []files{{
+				BcpName: "bcp",
+				Data:    []pbm.File{{Name: "collection-1.wt", Size: 3, Fmode: 0o600}},
+			}}
+			if phase != phaseCopy {
+				stg.Save("bcp/collection-1.wt", strings.NewReader("abc"), -1)
+			}
+			// the scratch file of a download that didn't finish. The copy
+			// registers the clean-up of such files.
+			err := os.MkdirAll(r.confOpts.CacheDir, 0o700)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(r.confOpts.CacheDir, "stale"+cacheTmpSuffix), nil, 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = runPhases(r, phase)
+			switch {
+			case phase < phaseDone && err == nil:
+				t.Fatal("expected the restore to fail")
+			case phase >= phaseDone && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			r.close(phase == phaseSuccess)
+
+			if ls := lsDBPath(t, tmp); len(ls) != 0 {
+				t.Errorf("tmp files left: %v", ls)
+			}
+			ls, _ := filepath.Glob(filepath.Join(r.confOpts.CacheDir, "*"+cacheTmpSuffix))
+			if phase >= phaseCopy && len(ls) != 0 {
+				t.Errorf("cache scratch files left: %v", ls)
+			}
+			if _, ok := stg.files[path.Join(pbm.PhysRestoresDir, "rst", pbm.PhysRestoreProgressFile)]; ok {
+				t.Error("progress file left")
+			}
+			// what's wiped is recorded only if it's actually wiped
+			if _, ok := stg.files[r.syncPathNodeFiles]; ok != (phase > phaseFlush) {
+				t.Errorf("restore files record exists: %v", ok)
+			}
+
+			log := filepath.Base(r.internalLogPath())
+			var want []string
+			switch phase {
+			case phaseStart, phaseFlush:
+				want = []string{"WiredTiger", "collection-0.wt", log}
+			case phaseCopy, phasePrepare:
+				want = []string{log}
+			case phaseDone:
+				want = []string{"collection-1.wt", log}
+			case phaseSuccess:
+				want = []string{"collection-1.wt"}
+			}
+			if ls := lsDBPath(t, r.dbpath); !reflect.DeepEqual(ls, want) {
+				t.Errorf("dbpath %v, want %v", ls, want)
+			}
+		})
+	}
+}
+
+// runPhases goes through the phases of Snapshot that make the artifacts
+// up to the given one, which fails unless it's phaseDone or later. The node
+// restore can't succeed with no mongod, so from phaseDone on it's cut
+// after the copy.
+func runPhases(r *PhysRestore, phase int) error {
+	r.cleanup.add("tmp mongod logs", cleanupOnSuccess, r.removeInternalLog)
+	err := os.WriteFile(r.internalLogPath(), []byte("log"), 0o644)
+	if err != nil {
+		return err
+	}
+	err = r.setTmpConf()
+	if err != nil {
+		return errors.Wrap(err, "set tmp conf")
+	}
+	err = r.trackProgress()
+	if err != nil {
+		return errors.Wrap(err, "track progress")
+	}
+	if phase == phaseStart {
+		return errors.New("failed before the flush")
+	}
+
+	if phase == phaseFlush {
+		r.saveFiles()
+		return errors.New("failed to wipe the dbpath")
+	}
+	keepData, err := r.wipeDBpath()
+	if err != nil {
+		return err
+	}
+
+	if phase < phaseDone {
+		return r.restoreData(&pbm.RestoreMeta{}, r.log)
+	}
+	_, err = r.copyFiles()
+	if err != nil {
+		return err
+	}
+	keepData()
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package restore
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// collCompression returns the block compressor for the namespace
+// according to the mapping (see pbm.RestoreConf.CollectionCompression).
+// The exact match wins over "db.*" which wins over "*.*".
+func collCompression(m map[string]string, db, coll string) (string, bool) {
+	for _, k := range []string{db + "." + coll, db + ".*", "*.*"} {
+		if c, ok := m[k]; ok {
+			return c, true
+		}
+	}
+
+	return "", false
+}
+
+// makeCollCompressionFn returns the function that sets WiredTiger block
+// compressor in collections' options according to the mapping. Compressors
+// not supported by the target are skipped with a warning so such
+// collections will be created with the default options.
+func makeCollCompressionFn(m map[string]string, supported map[string]bool, l *log.Event) archive.NSMetaFn {
+	if len(m) == 0 {
+		return nil
+	}
+
+	return func(ns *archive.Namespace) error {
+		if ns.Type == "view" || strings.HasPrefix(ns.Collection, "system.") {
+			return nil
+		}
+
+		c, ok := collCompression(m, ns.Database, ns.Collection)
+		if !ok {
+			return nil
+		}
+		if !supported[c] {
+			l.Warning("compressor %q for %s.%s is not supported by the target, using defaults",
+				c, ns.Database, ns.Collection)
+			return nil
+		}
+
+		meta, err := setBlockCompressor(ns.Metadata, c)
+		if err != nil {
+			return errors.Wrapf(err, "set %q compressor", c)
+		}
+		ns.Metadata = meta
+
+		return nil
+	}
+}
+
+// setBlockCompressor sets the block compressor in the storageEngine
+// options of the collection's metadata (mongodump extended JSON)
+func setBlockCompressor(meta, compressor string) (string, error) {
+	var doc bson.D
+	if meta != "" {
+		err := bson.UnmarshalExtJSON([]byte(meta), true, &doc)
+		if err != nil {
+			return "", errors.Wrap(err, "unmarshal metadata")
+		}
+	}
+
+	var opts bson.D
+	i := -1
+	for j, e := range doc {
+		if e.Key == "options" {
+			i = j
+			opts, _ = e.Value.(bson.D)
+			break
+		}
+	}
+
+	set := false
+	for j, e := range opts {
+		if e.Key == "storageEngine" {
+			engine, _ := e.Value.(bson.D)
+			opts[j].Value = mergeBlockCompressor(engine, compressor)
+			set = true
+			break
+		}
+	}
+	if !set {
+		opts = append(opts, bson.E{"storageEngine", mergeBlockCompressor(nil, compressor)})
+	}
+
+	if i == -1 {
+		doc = append(doc, bson.E{"options", opts})
+	} else {
+		doc[i].Value = opts
+	}
+
+	b, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal metadata")
+	}
+
+	return string(b), nil
+}
+
+// mergeBlockCompressor sets the block compressor in the WiredTiger
+// configString of the storageEngine option keeping the rest of the settings
+func mergeBlockCompressor(engine bson.D, compressor string) bson.D {
+	for i, e := range engine {
+		if e.Key != "wiredTiger" {
+			continue
+		}
+
+		wt, _ := e.Value.(bson.D)
+		for j, we := range wt {
+			if we.Key == "configString" {
+				cs, _ := we.Value.(string)
+				wt[j].Value = setConfigStringOpt(cs, "block_compressor", compressor)
+				engine[i].Value = wt
+				return engine
+			}
+		}
+		engine[i].Value = append(wt, bson.E{"configString", "block_compressor=" + compressor})
+		return engine
+	}
+
+	return append(engine, bson.E{"wiredTiger", bson.D{{"configString", "block_compressor=" + compressor}}})
+}
+
+// setConfigStringOpt sets the `key` option in the WiredTiger config string
+// (comma-separated `key=value` pairs) replacing the existing value if any
+func setConfigStringOpt(cs, key, val string) string {
+	var opts []string
+	for _, o := range strings.Split(cs, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" || o == key || strings.HasPrefix(o, key+"=") {
+			continue
+		}
+		opts = append(opts, o)
+	}
+
+	return strings.Join(append(opts, key+"="+val), ",")
+}
+
+func (r *Restore) collCompressionFn(m map[string]string) (archive.NSMetaFn, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	supported, err := r.supportedCompressors()
+	if err != nil {
+		return nil, errors.Wrap(err, "define supported compressors")
+	}
+
+	return makeCollCompressionFn(m, supported, r.log), nil
+}
+
+// supportedCompressors returns block compressors supported by the node
+func (r *Restore) supportedCompressors() (map[string]bool, error) {
+	stat := struct {
+		StorageEngine struct {
+			Name string `bson:"name"`
+		} `bson:"storageEngine"`
+	}{}
+	err := r.node.Session().Database("admin").
+		RunCommand(r.cn.Context(), bson.D{{"serverStatus", 1}}).Decode(&stat)
+	if err != nil {
+		return nil, errors.Wrap(err, "get serverStatus")
+	}
+	if stat.StorageEngine.Name != "wiredTiger" {
+		r.log.Warning("storage engine %q doesn't support block compression", stat.StorageEngine.Name)
+		return map[string]bool{}, nil
+	}
+
+	ver, err := r.node.GetMongoVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "get mongo version")
+	}
+
+	m := map[string]bool{"none": true, "snappy": true, "zlib": true}
+	// zstd is available since 4.2
+	if len(ver.Version) > 1 && (ver.Version[0] > 4 || ver.Version[0] == 4 && ver.Version[1] >= 2) {
+		m["zstd"] = true
+	}
+
+	return m, nil
+}
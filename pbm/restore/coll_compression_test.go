@@ -0,0 +1,89 @@
+package restore
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCollCompression(t *testing.T) {
+	m := map[string]string{
+		"db1.c1": "zstd",
+		"db1.*":  "zlib",
+		"*.*":    "snappy",
+	}
+
+	cases := []struct{ db, coll, want string }{
+		{"db1", "c1", "zstd"},
+		{"db1", "c2", "zlib"},
+		{"db2", "c1", "snappy"},
+	}
+	for _, c := range cases {
+		got, ok := collCompression(m, c.db, c.coll)
+		if !ok || got != c.want {
+			t.Errorf("%s.%s: expected %q, got %q (%v)", c.db, c.coll, c.want, got, ok)
+		}
+	}
+
+	if _, ok := collCompression(map[string]string{"db1.*": "zlib"}, "db2", "c1"); ok {
+		t.Error("db2.c1: expected no match")
+	}
+}
+
+func TestSetBlockCompressor(t *testing.T) {
+	cases := []string{
+		``,
+		`{"indexes":[],"uuid":"0a1b","collectionName":"c1","type":"collection"}`,
+		`{"options":{"capped":true,"size":{"$numberInt":"1024"}},"indexes":[]}`,
+		`{"options":{"storageEngine":{"wiredTiger":{"configString":"block_compressor=snappy"}}}}`,
+	}
+
+	for _, c := range cases {
+		meta, err := setBlockCompressor(c, "zstd")
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", c, err)
+			continue
+		}
+
+		var doc bson.M
+		if err := bson.UnmarshalExtJSON([]byte(meta), true, &doc); err != nil {
+			t.Errorf("%q: unmarshal result %q: %v", c, meta, err)
+			continue
+		}
+		opts, _ := doc["options"].(bson.M)
+		se, _ := opts["storageEngine"].(bson.M)
+		wt, _ := se["wiredTiger"].(bson.M)
+		if wt["configString"] != "block_compressor=zstd" {
+			t.Errorf("%q: unexpected result %s", c, meta)
+		}
+		if strings.Contains(c, "indexes") && doc["indexes"] == nil {
+			t.Errorf("%q: lost indexes: %s", c, meta)
+		}
+		if strings.Contains(c, "capped") && opts["capped"] != true {
+			t.Errorf("%q: lost options: %s", c, meta)
+		}
+	}
+}
+
+func TestSetBlockCompressorMerge(t *testing.T) {
+	meta := `{"options":{"storageEngine":{"wiredTiger":{"configString":"prefix_compression=true,block_compressor=snappy"},"inMemory":{"configString":"x=1"}}}}`
+
+	got, err := setBlockCompressor(meta, "zstd")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var doc bson.M
+	if err := bson.UnmarshalExtJSON([]byte(got), true, &doc); err != nil {
+		t.Fatalf("unmarshal result %q: %v", got, err)
+	}
+	se, _ := doc["options"].(bson.M)["storageEngine"].(bson.M)
+	wt, _ := se["wiredTiger"].(bson.M)
+	if wt["configString"] != "prefix_compression=true,block_compressor=zstd" {
+		t.Errorf("unexpected wiredTiger config: %s", got)
+	}
+	if se["inMemory"] == nil {
+		t.Errorf("lost storage engine settings: %s", got)
+	}
+}
@@ -0,0 +1,230 @@
+package restore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// copyPartSize is the size of the parts large uncompressed files are split
+// into so several workers can fill one file concurrently
+const copyPartSize = 256 << 20 // 256Mb
+
+// copyTask is a backup file, or a part of it, to copy into the dbpath
+type copyTask struct {
+	src  string
+	dst  string
+	cmpr compress.CompressionType
+	f    pbm.File
+	// dir means the task only ensures the dst dir is created
+	dir bool
+	// partOff and partLen are the range of the storage object the task
+	// copies. Zero partLen means the whole object.
+	partOff int64
+	partLen int64
+}
+
+// size is the amount of data the task reads from the storage
+func (t copyTask) size() int64 {
+	switch {
+	case t.partLen != 0:
+		return t.partLen
+	case t.f.StgSize != 0:
+		return t.f.StgSize
+	case t.f.Len != 0:
+		return t.f.Len
+	}
+	return t.f.Size
+}
+
+// objLen is the size of the (uncompressed) storage object of the file
+func objLen(f pbm.File) int64 {
+	if f.Len != 0 {
+		return f.Len
+	}
+	if f.StgSize != 0 {
+		return f.StgSize
+	}
+	return f.Size
+}
+
+func (t copyTask) String() string {
+	if t.partLen == 0 {
+		return fmt.Sprintf("<%s> to <%s>", t.src, t.dst)
+	}
+	return fmt.Sprintf("<%s> [%d:%d] to <%s>", t.src, t.partOff, t.partLen, t.dst)
+}
+
+// planCopy turns the backup files into copy tasks. There is a list of tasks
+// per backup in the order they should be applied: the base backup first and
+// then the increments, as later ones overwrite the earlier. Within the list,
+// the largest files go first so a huge file isn't left for the end to keep
+// a single worker busy while the rest idle.
+//
+// With positive `partSize`, uncompressed files larger than it are split into
+// ranged tasks of `partSize` each.
+func planCopy(sets []files, setName, dbpath string, partSize int64) [][]copyTask {
+	plan := make([][]copyTask, 0, len(sets))
+	for i := len(sets) - 1; i >= 0; i-- {
+		set := sets[i]
+		tasks := make([]copyTask, 0, len(set.Data))
+		for _, f := range set.Data {
+			src := set.layout.Path(set.BcpName, setName, f.Name) + set.Cmpr.Suffix()
+			if f.Len != 0 {
+				src += fmt.Sprintf(".%d-%d", f.Off, f.Len)
+			}
+			// cut dbpath from destination if there is any (see PBM-1058)
+			fname := f.Name
+			if set.dbpath != "" {
+				fname = strings.TrimPrefix(fname, set.dbpath)
+			}
+			t := copyTask{
+				src:  src,
+				dst:  filepath.Join(dbpath, fname),
+				cmpr: set.Cmpr,
+				f:    f,
+				dir:  set.BcpName == bcpDir,
+			}
+
+			olen := objLen(f)
+			if t.dir || partSize <= 0 || set.Cmpr.Suffix() != "" || olen <= partSize {
+				tasks = append(tasks, t)
+				continue
+			}
+			for off := int64(0); off < olen; off += partSize {
+				t.partOff = off
+				t.partLen = partSize
+				if off+partSize > olen {
+					t.partLen = olen - off
+				}
+				tasks = append(tasks, t)
+			}
+		}
+
+		sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].size() > tasks[j].size() })
+		plan = append(plan, tasks)
+	}
+
+	return plan
+}
+
+// runCopy runs the tasks of one backup with `workers` concurrent workers.
+// The files split into parts are created with the final size beforehand,
+// so the parts are only written at their offsets. `initFn` (if any) is run
+// by each worker before it takes tasks.
+func runCopy(tasks []copyTask, workers int, initFn func(), copyFn func(t copyTask, buf []byte) error) error {
+	for _, t := range tasks {
+		err := os.MkdirAll(filepath.Dir(t.dst), os.ModeDir|0o700)
+		if err != nil {
+			return errors.Wrapf(err, "create path %s", filepath.Dir(t.dst))
+		}
+		if t.partLen != 0 && t.partOff == 0 {
+			err = prepareDst(t)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	taskC := make(chan copyTask)
+	eg := errgroup.Group{}
+	for i := 0; i < workers; i++ {
+		eg.Go(func() error {
+			if initFn != nil {
+				initFn()
+			}
+			buf := make([]byte, 32*1024)
+			var err error
+			for t := range taskC {
+				if err == nil {
+					err = copyFn(t, buf)
+				}
+			}
+			return err
+		})
+	}
+	for _, t := range tasks {
+		taskC <- t
+	}
+	close(taskC)
+
+	return eg.Wait()
+}
+
+// prepareDst creates the file the parts are written into
+func prepareDst(t copyTask) error {
+	fw, err := os.OpenFile(t.dst, os.O_WRONLY|os.O_CREATE, t.f.Fmode)
+	if err != nil {
+		return errors.Wrapf(err, "create/open destination file <%s>", t.dst)
+	}
+	defer fw.Close()
+
+	size := t.f.Size
+	if size == 0 {
+		size = t.f.Off + objLen(t.f)
+	}
+	err = fw.Truncate(size)
+	return errors.Wrapf(err, "truncate file <%s>|%d", t.dst, size)
+}
+
+// CopyFileRange reads `length` bytes at `offset` of the uncompressed backup
+// file `src` and writes them into `dst` at the same offset past the file's
+// one. The destination should exist, so the parts of the file can be
+// written concurrently.
+func CopyFileRange(rr storage.RangeReader, src string, offset, length int64, dst string, f pbm.File, buf []byte) error {
+	sr, err := rr.RangeReader(src, offset, length)
+	if err != nil {
+		return errors.Wrapf(err, "create range reader for <%s> [%d:%d]", src, offset, length)
+	}
+	defer sr.Close()
+
+	fw, err := os.OpenFile(dst, os.O_WRONLY, f.Fmode)
+	if err != nil {
+		return errors.Wrapf(err, "open destination file <%s>", dst)
+	}
+	defer fw.Close()
+
+	at := f.Off + offset
+	n := int64(0)
+	for n < length {
+		r, err := sr.Read(buf[:min64(int64(len(buf)), length-n)])
+		if r > 0 {
+			_, werr := fw.WriteAt(buf[:r], at+n)
+			if werr != nil {
+				return errors.Wrapf(werr, "write file <%s> at %d", dst, at+n)
+			}
+			n += int64(r)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "read <%s> [%d:%d]", src, offset, length)
+		}
+	}
+	if n != length {
+		return errors.Errorf("copy file <%s> [%d:%d]: short read %d", dst, offset, length, n)
+	}
+
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
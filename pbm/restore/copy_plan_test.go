@@ -0,0 +1,164 @@
+package restore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestPlanCopy(t *testing.T) {
+	sets := []files{
+		{
+			BcpName: "incr",
+			Cmpr:    compress.CompressionTypeNone,
+			Data: []pbm.File{
+				{Name: "a.wt", Off: 100, Len: 10, Size: 1000},
+			},
+		},
+		{
+			BcpName: "base",
+			Cmpr:    compress.CompressionTypeS2,
+			Data: []pbm.File{
+				{Name: "small.wt", Size: 10, StgSize: 5},
+				{Name: "huge.wt", Size: 1000, StgSize: 500},
+				{Name: "mid.wt", Size: 100, StgSize: 50},
+			},
+		},
+	}
+
+	plan := planCopy(sets, "rs0", "/data/db", 0)
+	if len(plan) != 2 {
+		t.Fatalf("expected 2 layers, got %d", len(plan))
+	}
+	var names []string
+	for _, c := range plan[0] {
+		names = append(names, c.f.Name)
+	}
+	if want := []string{"huge.wt", "mid.wt", "small.wt"}; !equalStrings(names, want) {
+		t.Errorf("base layer order: got %v, want %v", names, want)
+	}
+	if plan[1][0].src != "incr/rs0/a.wt.100-10" {
+		t.Errorf("increment src: got %s", plan[1][0].src)
+	}
+
+	// compressed files are never split
+	plan = planCopy(sets[1:], "rs0", "/data/db", 64)
+	if len(plan[0]) != 3 {
+		t.Errorf("compressed files should not be split, got %d tasks", len(plan[0]))
+	}
+
+	sets[1].Cmpr = compress.CompressionTypeNone
+	plan = planCopy(sets[1:], "rs0", "/data/db", 64)
+	// huge: 500/64 -> 8 parts, mid: 50 -> 1, small: 5 -> 1
+	if len(plan[0]) != 10 {
+		t.Fatalf("expected 10 tasks, got %d", len(plan[0]))
+	}
+	var covered int64
+	for _, c := range plan[0][:8] {
+		if c.f.Name != "huge.wt" {
+			t.Fatalf("expected huge.wt parts first, got %s", c.f.Name)
+		}
+		covered += c.partLen
+	}
+	if covered != 500 {
+		t.Errorf("parts cover %d bytes, want 500", covered)
+	}
+}
+
+func TestCopyRangesConcurrently(t *testing.T) {
+	stgDir, dbpath := t.TempDir(), t.TempDir()
+	stg := fs.New(fs.Conf{Path: stgDir})
+
+	rnd := rand.New(rand.NewSource(1))
+	put := func(name string, size int) []byte {
+		b := make([]byte, size)
+		rnd.Read(b)
+		p := filepath.Join(stgDir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	big := put("base/rs0/big.wt", 1<<20+12345)
+	small := put("base/rs0/small.wt", 777)
+	chunk := put("incr/rs0/big.wt.4096-70001", 70001)
+
+	sets := []files{
+		{
+			BcpName: "incr",
+			Cmpr:    compress.CompressionTypeNone,
+			Data:    []pbm.File{{Name: "big.wt", Off: 4096, Len: 70001, Size: int64(len(big)), Fmode: 0o600}},
+		},
+		{
+			BcpName: "base",
+			Cmpr:    compress.CompressionTypeNone,
+			Data: []pbm.File{
+				{Name: "small.wt", Size: int64(len(small)), StgSize: int64(len(small)), Fmode: 0o600},
+				{Name: "big.wt", Size: int64(len(big)), StgSize: int64(len(big)), Fmode: 0o600},
+			},
+		},
+	}
+
+	for _, tasks := range planCopy(sets, "rs0", dbpath, 16<<10) {
+		err := runCopy(tasks, 8, nil, func(c copyTask, buf []byte) error {
+			if c.partLen != 0 {
+				return CopyFileRange(stg, c.src, c.partOff, c.partLen, c.dst, c.f, buf)
+			}
+			return CopyFile(stg.SourceReader, c.src, c.cmpr, c.dst, c.f, buf)
+		})
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+	}
+
+	want := append([]byte{}, big...)
+	copy(want[4096:], chunk)
+	checkSum(t, filepath.Join(dbpath, "big.wt"), want)
+	checkSum(t, filepath.Join(dbpath, "small.wt"), small)
+}
+
+func checkSum(t *testing.T, name string, want []byte) {
+	t.Helper()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(want)) {
+		t.Fatalf("%s: size %d, want %d", name, n, len(want))
+	}
+	if w := sha256.Sum256(want); !bytes.Equal(h.Sum(nil), w[:]) {
+		t.Errorf("%s: checksum mismatch", name)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
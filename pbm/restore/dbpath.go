@@ -0,0 +1,284 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// DefaultDBPathIgnore are the entries that may be found in the root of
+// the dbpath (or of a filesystem mounted within it) which aren't mongod's
+// but belong to the filesystem or the storage appliance. They are left
+// intact during the restore.
+var DefaultDBPathIgnore = []string{"lost+found", ".snapshot", ".snapshots", ".zfs"}
+
+// mongodDirs are the dbpath subdirectories whose content is managed
+// by mongod, so it's not checked
+var mongodDirs = map[string]struct{}{
+	"journal":         {},
+	"diagnostic.data": {},
+	"_tmp":            {},
+	"key.db":          {},
+	"rollback":        {},
+	"moveChunk":       {},
+}
+
+func isMongodFile(name string) bool {
+	return strings.HasSuffix(name, ".wt") ||
+		strings.HasPrefix(name, "WiredTiger") ||
+		name == mongofslock ||
+		name == "storage.bson"
+}
+
+// dbpathLayout is the content of the dbpath besides mongod's files
+type dbpathLayout struct {
+	// ignored entries (see DefaultDBPathIgnore)
+	ignored []string
+	// unknown files, not created by mongod
+	unknown []string
+	// mountpoints within the dbpath
+	mounts map[string]struct{}
+}
+
+// scanDBPath walks the dbpath and finds the entries that aren't mongod's
+func scanDBPath(dbpath string, ignore []string) (*dbpathLayout, error) {
+	fi, err := os.Stat(dbpath)
+	if err != nil {
+		return nil, errors.Wrap(err, "stat dbpath")
+	}
+
+	lt := &dbpathLayout{mounts: make(map[string]struct{})}
+	err = lt.scan(dbpath, fileDev(fi), true, false, ignore)
+	return lt, err
+}
+
+// scan walks the dir. `fsroot` means the dir is the root of the dbpath or
+// of a mounted filesystem. Files in the `mongod` dirs aren't checked.
+func (lt *dbpathLayout) scan(dir string, dev uint64, fsroot, mongod bool, ignore []string) error {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "read dir %s", dir)
+	}
+
+	for _, e := range ents {
+		p := filepath.Join(dir, e.Name())
+		if fsroot && matchAny(e.Name(), ignore) {
+			lt.ignored = append(lt.ignored, p)
+			continue
+		}
+		if isInternalLog(e.Name()) {
+			continue
+		}
+		if e.Type()&os.ModeSymlink != 0 {
+			continue
+		}
+
+		if !e.IsDir() {
+			if !mongod && !isMongodFile(e.Name()) {
+				lt.unknown = append(lt.unknown, p)
+			}
+			continue
+		}
+
+		fi, err := e.Info()
+		if err != nil {
+			return errors.Wrapf(err, "stat %s", p)
+		}
+		d := fileDev(fi)
+		mnt := d != dev
+		if mnt {
+			lt.mounts[p] = struct{}{}
+		}
+		_, md := mongodDirs[e.Name()]
+		err = lt.scan(p, d, mnt, mongod || md, ignore)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasMount tells if the path is a mountpoint or contains one
+func (lt *dbpathLayout) hasMount(p string) bool {
+	for m := range lt.mounts {
+		if m == p || strings.HasPrefix(m, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (lt *dbpathLayout) isIgnored(p string) bool {
+	for _, i := range lt.ignored {
+		if i == p {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func fileDev(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev)
+	}
+	return 0
+}
+
+// checkDBPath fails if there are files in the dbpath that aren't mongod's
+// unless `force` is set. Such files would be either removed or mixed with
+// the restored data.
+func checkDBPath(dbpath string, ignore []string, force bool, l *log.Event) error {
+	lt, err := scanDBPath(dbpath, ignore)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range lt.ignored {
+		l.Warning("dbpath: %s is not mongod's, leaving it intact", p)
+	}
+	for p := range lt.mounts {
+		l.Info("dbpath: %s is a mountpoint, only its content will be cleared", p)
+	}
+
+	if len(lt.unknown) == 0 {
+		return nil
+	}
+	if force {
+		l.Warning("dbpath: %d unknown file(s) will be removed: %v", len(lt.unknown), lt.unknown)
+		return nil
+	}
+
+	list := lt.unknown
+	if len(list) > 10 {
+		list = list[:10]
+	}
+	return errors.Errorf("dbpath %s has %d file(s) not created by mongod: %s. "+
+		"Remove them, add to restore.dbpathIgnore config option or use --force to remove them during the restore",
+		dbpath, len(lt.unknown), strings.Join(list, ", "))
+}
+
+// ListDBPath returns the files the restore would remove from the dbpath
+// (see removeAll) with their paths relative to the dbpath
+func ListDBPath(dbpath string, ignore []string) ([]pbm.FileSize, error) {
+	fi, err := os.Stat(dbpath)
+	if err != nil {
+		return nil, errors.Wrap(err, "stat dbpath")
+	}
+
+	var rv []pbm.FileSize
+	err = listDir(dbpath, "", fileDev(fi), true, ignore, &rv)
+	return rv, err
+}
+
+func listDir(root, dir string, dev uint64, fsroot bool, ignore []string, files *[]pbm.FileSize) error {
+	ents, err := os.ReadDir(filepath.Join(root, dir))
+	if err != nil {
+		return errors.Wrapf(err, "read dir %s", filepath.Join(root, dir))
+	}
+
+	for _, e := range ents {
+		p := filepath.Join(dir, e.Name())
+		if fsroot && matchAny(e.Name(), ignore) {
+			continue
+		}
+		if dir == "" && isInternalLog(e.Name()) {
+			continue
+		}
+
+		fi, err := e.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return errors.Wrapf(err, "stat %s", p)
+		}
+		if !e.IsDir() {
+			*files = append(*files, pbm.FileSize{Name: p, Size: fi.Size()})
+			continue
+		}
+
+		d := fileDev(fi)
+		err = listDir(root, p, d, d != dev, ignore, files)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeAll clears the dbpath. It leaves ignored entries and PBM internal
+// logs intact and removes only the content of the mountpoints, not
+// the mountpoints themselves.
+func removeAll(dir string, ignore []string, l *log.Event) error {
+	lt, err := scanDBPath(dir, ignore)
+	if err != nil {
+		return errors.Wrap(err, "scan dbpath")
+	}
+
+	return lt.clear(dir, l)
+}
+
+// syncDir fsyncs the dir so the removal of its entries is persisted
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "open dir")
+	}
+	defer d.Close()
+
+	return errors.Wrap(d.Sync(), "fsync")
+}
+
+func (lt *dbpathLayout) clear(dir string, l *log.Event) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "open dir")
+	}
+	defer d.Close()
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return errors.Wrap(err, "read file names")
+	}
+	for _, n := range names {
+		p := filepath.Join(dir, n)
+		if isInternalLog(n) {
+			continue
+		}
+		if lt.isIgnored(p) {
+			l.Debug("skip %s", p)
+			continue
+		}
+		// can't remove a mountpoint or a dir with one inside
+		if lt.hasMount(p) {
+			err = lt.clear(p, l)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = os.RemoveAll(p)
+		if err != nil {
+			return errors.Wrapf(err, "remove '%s'", n)
+		}
+		l.Debug("remove %s", p)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package restore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// procDir is where the processes are looked for
+var procDir = "/proc"
+
+// dbpathRegistry is the host-local file with the dbpaths claimed by
+// the running physical restores
+var dbpathRegistry = filepath.Join(os.TempDir(), "pbm-restore-dbpaths.json")
+
+// guardDBPath makes sure the restore won't flush the dbpath used by
+// mongod instances other than the node's one. And claims the dbpath on the
+// host so no other agent would restore into it at the same time. With
+// `force` the found conflicts are only logged.
+func (r *PhysRestore) guardDBPath(force bool, l *log.Event) error {
+	self, err := r.node.PID()
+	if err != nil {
+		return errors.Wrap(err, "get mongod pid")
+	}
+
+	pids, err := checkDBPathShared(r.dbpath, self, force)
+	if err != nil {
+		return err
+	}
+	if len(pids) != 0 {
+		l.Warning("dbpath %s is used by other mongod processes (pids %s), proceeding due to --force",
+			r.dbpath, joinInts(pids))
+	}
+
+	release, err := claimDBPath(dbpathClaim{
+		DBpath:  r.dbpath,
+		PID:     os.Getpid(),
+		Restore: r.name,
+		Node:    r.nodeInfo.Me,
+		Replset: r.nodeInfo.SetName,
+	}, r.bcpDBpath())
+	if err != nil {
+		if !force {
+			return err
+		}
+		l.Warning("%v, proceeding due to --force", err)
+		return nil
+	}
+	r.cleanup.add("dbpath claim", cleanupAlways, func() error { release(); return nil })
+
+	return nil
+}
+
+// bcpDBpath returns the dbpath of the replset's node the backup was taken
+// from. Empty if the backup doesn't have it.
+func (r *PhysRestore) bcpDBpath() string {
+	setName := pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)
+	for _, rs := range r.bcp.Replsets {
+		if rs.Name == setName && rs.MongodOpts != nil {
+			return rs.MongodOpts.Storage.DBpath
+		}
+	}
+	return ""
+}
+
+// lockPID returns the PID written in the dbpath's mongod.lock.
+// Zero means the lock is empty (mongod isn't running).
+func lockPID(dbpath string) (int, error) {
+	b, err := os.ReadFile(filepath.Join(dbpath, mongofslock))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "read mongod.lock")
+	}
+
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, nil
+	}
+	pid, err := strconv.Atoi(s)
+	return pid, errors.Wrapf(err, "parse mongod.lock %q", s)
+}
+
+// mongodUsers returns the PIDs of the mongod processes besides `self`
+// whose --dbpath is `dbpath` or which hold the dbpath's mongod.lock open
+func mongodUsers(dbpath string, self int) []int {
+	ents, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil
+	}
+	dfi, err := os.Stat(dbpath)
+	if err != nil {
+		return nil
+	}
+	lock, _ := filepath.EvalSymlinks(filepath.Join(dbpath, mongofslock))
+
+	var rv []int
+	for _, e := range ents {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		pdir := filepath.Join(procDir, e.Name())
+		args := procArgs(pdir)
+		if len(args) == 0 || filepath.Base(args[0]) != "mongod" {
+			continue
+		}
+
+		if p := argDBpath(args); p != "" {
+			if !filepath.IsAbs(p) {
+				cwd, _ := os.Readlink(filepath.Join(pdir, "cwd"))
+				p = filepath.Join(cwd, p)
+			}
+			if fi, err := os.Stat(p); err == nil && os.SameFile(fi, dfi) {
+				rv = append(rv, pid)
+				continue
+			}
+		}
+		if lock != "" && holdsFile(pdir, lock) {
+			rv = append(rv, pid)
+		}
+	}
+
+	return rv
+}
+
+func procArgs(pdir string) []string {
+	b, err := os.ReadFile(filepath.Join(pdir, "cmdline"))
+	if err != nil {
+		return nil
+	}
+	return strings.Split(string(bytes.TrimRight(b, "\x00")), "\x00")
+}
+
+// argDBpath returns the --dbpath of the mongod command line
+func argDBpath(args []string) string {
+	for i, a := range args {
+		if strings.HasPrefix(a, "--dbpath=") {
+			return strings.TrimPrefix(a, "--dbpath=")
+		}
+		if a == "--dbpath" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// holdsFile tells if the process has the file open
+func holdsFile(pdir, file string) bool {
+	fds, err := os.ReadDir(filepath.Join(pdir, "fd"))
+	if err != nil {
+		return false
+	}
+	for _, fd := range fds {
+		l, err := os.Readlink(filepath.Join(pdir, "fd", fd.Name()))
+		if err == nil && l == file {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDBPathShared fails if mongod instances other than the node's one
+// (`self` PID) use the dbpath. Unless `force` is set, in which case it
+// only returns the offending PIDs.
+func checkDBPathShared(dbpath string, self int, force bool) ([]int, error) {
+	pids := mongodUsers(dbpath, self)
+	if lp, err := lockPID(dbpath); err == nil && lp != 0 && lp != self && processAlive(lp) && !hasInt(pids, lp) {
+		pids = append(pids, lp)
+	}
+	if len(pids) == 0 || force {
+		return pids, nil
+	}
+
+	return pids, errors.Errorf("dbpath %s is used by other mongod processes (pids %s) besides the node's one (pid %d). "+
+		"Fix the mongod configuration or use --force to restore anyway", dbpath, joinInts(pids), self)
+}
+
+// dbpathClaim is the dbpath claimed by the running restore
+type dbpathClaim struct {
+	DBpath  string `json:"dbpath"`
+	PID     int    `json:"pid"`
+	Restore string `json:"restore"`
+	Node    string `json:"node"`
+	Replset string `json:"rs"`
+}
+
+// claimDBPath registers the dbpath in the host-local registry (see
+// dbpathRegistry) for the time of the restore. It fails if the dbpath
+// is already claimed by another running restore on the host. Or if the
+// `bcpDBpath` (the dbpath of the node the backup was taken from) is claimed
+// by a node of another replset. Members of the same replset on the host
+// share the backup's dbpath, so they don't conflict on it.
+func claimDBPath(c dbpathClaim, bcpDBpath string) (release func(), err error) {
+	c.DBpath = realPath(c.DBpath)
+	bcpDBpath = realPath(bcpDBpath)
+
+	err = updateRegistry(func(claims []dbpathClaim) ([]dbpathClaim, error) {
+		var rv []dbpathClaim
+		for _, o := range claims {
+			if !processAlive(o.PID) || (o.PID == c.PID && o.Restore == c.Restore) {
+				continue
+			}
+			if samePath(o.DBpath, c.DBpath) {
+				return nil, errors.Errorf("dbpath %s is being restored by %s (agent pid %d, restore %s)",
+					c.DBpath, o.Node, o.PID, o.Restore)
+			}
+			if bcpDBpath != "" && bcpDBpath != c.DBpath && o.Replset != c.Replset && samePath(o.DBpath, bcpDBpath) {
+				return nil, errors.Errorf("the backup's dbpath %s is being restored by %s (agent pid %d, restore %s). "+
+					"Check the agents on this host serve the right mongod", bcpDBpath, o.Node, o.PID, o.Restore)
+			}
+			rv = append(rv, o)
+		}
+		return append(rv, c), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_ = updateRegistry(func(claims []dbpathClaim) ([]dbpathClaim, error) {
+			var rv []dbpathClaim
+			for _, o := range claims {
+				if o.PID == c.PID && o.Restore == c.Restore {
+					continue
+				}
+				rv = append(rv, o)
+			}
+			return rv, nil
+		})
+	}, nil
+}
+
+// updateRegistry applies `fn` to the registry content under the file lock
+func updateRegistry(fn func([]dbpathClaim) ([]dbpathClaim, error)) error {
+	var claims []dbpathClaim
+	return updateLockedJSON(dbpathRegistry, &claims, func() (err error) {
+		claims, err = fn(claims)
+		return err
+	})
+}
+
+// updateLockedJSON decodes the host-local json file `path` into `v`, runs
+// `fn` and writes `v` back. All under the exclusive file lock, so agents
+// on the same host see the changes of each other in order.
+func updateLockedJSON(path string, v interface{}, fn func() error) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return errors.Wrapf(err, "lock %s", path)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN) //nolint:errcheck
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "read %s", path)
+	}
+	if len(bytes.TrimSpace(b)) != 0 {
+		err = json.Unmarshal(b, v)
+		if err != nil {
+			return errors.Wrapf(err, "decode %s", path)
+		}
+	}
+
+	err = fn()
+	if err != nil {
+		return err
+	}
+
+	b, err = json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "encode %s", path)
+	}
+	if err = f.Truncate(0); err != nil {
+		return errors.Wrapf(err, "truncate %s", path)
+	}
+	_, err = f.WriteAt(b, 0)
+	return errors.Wrapf(err, "write %s", path)
+}
+
+func realPath(p string) string {
+	if p == "" {
+		return ""
+	}
+	if r, err := filepath.EvalSymlinks(p); err == nil {
+		return r
+	}
+	return filepath.Clean(p)
+}
+
+func samePath(a, b string) bool {
+	if a == b {
+		return true
+	}
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func hasInt(a []int, v int) bool {
+	for _, i := range a {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func joinInts(a []int) string {
+	s := make([]string, len(a))
+	for i, v := range a {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,95 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArgDBpath(t *testing.T) {
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"mongod", "--dbpath", "/data/db", "--port", "27017"}, "/data/db"},
+		{[]string{"/usr/bin/mongod", "--dbpath=data"}, "data"},
+		{[]string{"mongod", "--config", "/etc/mongod.conf"}, ""},
+		{[]string{"mongod", "--dbpath"}, ""},
+	}
+
+	for _, c := range cases {
+		if got := argDBpath(c.args); got != c.want {
+			t.Errorf("%v: got %q, want %q", c.args, got, c.want)
+		}
+	}
+}
+
+func TestMongodUsers(t *testing.T) {
+	root := t.TempDir()
+	dbpath := filepath.Join(root, "db")
+	if err := os.Mkdir(dbpath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	proc := t.TempDir()
+	defer func(p string) { procDir = p }(procDir)
+	procDir = proc
+
+	mkProc := func(pid, cmdline, cwd string) {
+		d := filepath.Join(proc, pid)
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(d, "cmdline"), []byte(cmdline), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if cwd != "" {
+			if err := os.Symlink(cwd, filepath.Join(d, "cwd")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	mkProc("10", "mongod\x00--dbpath\x00"+dbpath+"\x00", "")
+	mkProc("11", "/usr/bin/mongod\x00--dbpath=db\x00", root)
+	mkProc("12", "mongod\x00--dbpath\x00"+t.TempDir()+"\x00", "")
+	mkProc("13", "mongos\x00--dbpath\x00"+dbpath+"\x00", "")
+
+	got := mongodUsers(dbpath, 10)
+	if len(got) != 1 || got[0] != 11 {
+		t.Errorf("got %v, want [11]", got)
+	}
+}
+
+func TestClaimDBPath(t *testing.T) {
+	defer func(p string) { dbpathRegistry = p }(dbpathRegistry)
+	dbpathRegistry = filepath.Join(t.TempDir(), "registry.json")
+
+	a, b := t.TempDir(), t.TempDir()
+	pid := os.Getpid()
+
+	release, err := claimDBPath(dbpathClaim{DBpath: a, PID: pid, Restore: "r1", Node: "rs1:27017", Replset: "rs1"}, "")
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	_, err = claimDBPath(dbpathClaim{DBpath: a, PID: pid, Restore: "r2", Node: "rs2:27017"}, "")
+	if err == nil {
+		t.Error("expected the claimed dbpath to be refused")
+	}
+	_, err = claimDBPath(dbpathClaim{DBpath: b, PID: pid, Restore: "r2", Node: "rs2:27017", Replset: "rs2"}, a)
+	if err == nil {
+		t.Error("expected the backup dbpath claimed by another replset to be refused")
+	}
+	// a member of the same replset on the host
+	releaseB, err := claimDBPath(dbpathClaim{DBpath: b, PID: pid, Restore: "r1.b", Node: "rs1:27018", Replset: "rs1"}, a)
+	if err != nil {
+		t.Errorf("claim by the same replset member: %v", err)
+	} else {
+		releaseB()
+	}
+
+	release()
+	_, err = claimDBPath(dbpathClaim{DBpath: a, PID: pid, Restore: "r2", Node: "rs2:27017"}, "")
+	if err != nil {
+		t.Errorf("claim released dbpath: %v", err)
+	}
+}
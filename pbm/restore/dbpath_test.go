@@ -0,0 +1,175 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+func mkDBPath(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, f := range files {
+		p := filepath.Join(dir, f)
+		if f[len(f)-1] == '/' {
+			if err := os.MkdirAll(p, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func lsDBPath(t *testing.T, dir string) []string {
+	t.Helper()
+	var rv []string
+	err := filepath.Walk(dir, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir {
+			rel, _ := filepath.Rel(dir, p)
+			rv = append(rv, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+func TestDBPathIgnored(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	dir := mkDBPath(t,
+		"lost+found/",
+		".snapshot/hourly.0/collection-1.wt",
+		"WiredTiger",
+		"mongod.lock",
+		"collection-2.wt",
+		"journal/WiredTigerLog.0000000001",
+		"diagnostic.data/metrics.interim",
+		"db1/collection-3.wt",
+	)
+
+	if err := checkDBPath(dir, DefaultDBPathIgnore, false, l); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := removeAll(dir, DefaultDBPathIgnore, l); err != nil {
+		t.Fatalf("remove all: %v", err)
+	}
+	got := lsDBPath(t, dir)
+	want := []string{".snapshot", ".snapshot/hourly.0", ".snapshot/hourly.0/collection-1.wt", "lost+found"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v left, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v left, got %v", want, got)
+			break
+		}
+	}
+}
+
+func TestDBPathUnknown(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	dir := mkDBPath(t,
+		"WiredTiger",
+		"collection-2.wt",
+		"backup.tar.gz",
+		"old/notes.txt",
+	)
+
+	err := checkDBPath(dir, DefaultDBPathIgnore, false, l)
+	if err == nil {
+		t.Fatal("expected error on unknown files")
+	}
+
+	if err := checkDBPath(dir, DefaultDBPathIgnore, true, l); err != nil {
+		t.Errorf("expected no error with force, got %v", err)
+	}
+	if err := checkDBPath(dir, []string{"backup.*", "old"}, false, l); err != nil {
+		t.Errorf("expected no error with custom ignore list, got %v", err)
+	}
+	// ignore list applies only to the root
+	if err := checkDBPath(dir, []string{"backup.*", "notes.txt"}, false, l); err == nil {
+		t.Error("expected error on unknown file in subdir")
+	}
+}
+
+func TestDBPathMount(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	dir := mkDBPath(t,
+		"WiredTiger",
+		"journal/WiredTigerLog.0000000001",
+		"journal/lost+found/",
+		"db1/index/index-1.wt",
+	)
+
+	lt, err := scanDBPath(dir, DefaultDBPathIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// simulate separately mounted dirs as it requires privileges
+	lt.mounts[filepath.Join(dir, "journal")] = struct{}{}
+	lt.mounts[filepath.Join(dir, "db1", "index")] = struct{}{}
+	lt.ignored = append(lt.ignored, filepath.Join(dir, "journal", "lost+found"))
+
+	if err := lt.clear(dir, l); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	got := lsDBPath(t, dir)
+	want := []string{"db1", "db1/index", "journal", "journal/lost+found"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v left, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v left, got %v", want, got)
+			break
+		}
+	}
+}
+
+func TestListDBPath(t *testing.T) {
+	dir := mkDBPath(t,
+		"lost+found/",
+		".snapshot/hourly.0/collection-1.wt",
+		"WiredTiger",
+		"pbm.restore.log.2023-01-01.log",
+		"journal/WiredTigerLog.0000000001",
+		"db1/",
+	)
+
+	files, err := ListDBPath(dir, DefaultDBPathIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		if f.Size != 1 {
+			t.Errorf("%s: expected size 1, got %d", f.Name, f.Size)
+		}
+		got = append(got, f.Name)
+	}
+	sort.Strings(got)
+	want := []string{"WiredTiger", "journal/WiredTigerLog.0000000001"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
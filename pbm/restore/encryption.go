@@ -0,0 +1,199 @@
+package restore
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+const (
+	defaultVaultPort = 8200
+	defaultKMIPPort  = 5696
+
+	keyServerDialTimeout = 5 * time.Second
+)
+
+// checkEncryption returns the security options for the tmp mongod and fails
+// if the data of the backup can't be read with them. It's run before any
+// data is touched so the restore fails early with the exact reason instead
+// of the WiredTiger panic.
+func (r *PhysRestore) checkEncryption() (*pbm.MongodOptsSec, error) {
+	sec := withEncryption(r.secOpts, r.confOpts.Encryption)
+
+	var bcpSec *pbm.MongodOptsSec
+	setName := pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)
+	if rs := getRS(r.bcp, setName); rs != nil && rs.MongodOpts != nil {
+		bcpSec = rs.MongodOpts.Security
+	}
+
+	return sec, checkEncryption(bcpSec, sec)
+}
+
+// withEncryption returns the node's security options with the encryption
+// options replaced by the given ones (if any)
+func withEncryption(node, enc *pbm.MongodOptsSec) *pbm.MongodOptsSec {
+	if enc == nil {
+		return node
+	}
+
+	sec := &pbm.MongodOptsSec{}
+	if node != nil {
+		sec.RelaxPermChecks = node.RelaxPermChecks
+	}
+	sec.EnableEncryption = enc.EnableEncryption
+	sec.EncryptionCipherMode = enc.EncryptionCipherMode
+	sec.EncryptionKeyFile = enc.EncryptionKeyFile
+	sec.Vault = enc.Vault
+	sec.KMIP = enc.KMIP
+	if enc.RelaxPermChecks != nil {
+		sec.RelaxPermChecks = enc.RelaxPermChecks
+	}
+	return sec
+}
+
+func isEncrypted(sec *pbm.MongodOptsSec) bool {
+	return sec != nil && sec.EnableEncryption != nil && *sec.EnableEncryption
+}
+
+// keySource describes where the master key comes from
+func keySource(sec *pbm.MongodOptsSec) string {
+	switch {
+	case sec.EncryptionKeyFile != nil:
+		return "key file " + *sec.EncryptionKeyFile
+	case sec.Vault != nil:
+		return "vault server " + strval(sec.Vault.ServerName)
+	case sec.KMIP != nil:
+		return "KMIP server " + strval(sec.KMIP.ServerName)
+	}
+	return "no key source"
+}
+
+// checkEncryption checks that the data encrypted (or not) according to
+// the backup's options can be read by mongod with the given ones
+func checkEncryption(bcp, sec *pbm.MongodOptsSec) error {
+	const hint = "Set the restore.encryption config option to provide the key source for the restore"
+
+	switch {
+	case !isEncrypted(bcp) && !isEncrypted(sec):
+		return nil
+	case !isEncrypted(sec):
+		return errors.Errorf("backup data is encrypted at rest (%s) but encryption is not enabled on the node. %s",
+			keySource(bcp), hint)
+	case bcp != nil && !isEncrypted(bcp):
+		return errors.Errorf("encryption at rest is enabled on the node (%s) but the backup data isn't encrypted. "+
+			"Set restore.encryption.enableEncryption to false to restore it", keySource(sec))
+	}
+
+	if c := strval(sec.EncryptionCipherMode); bcp != nil && c != "" && strval(bcp.EncryptionCipherMode) != "" &&
+		c != strval(bcp.EncryptionCipherMode) {
+		return errors.Errorf("encryption cipher mode %s doesn't match the backup's one %s",
+			c, strval(bcp.EncryptionCipherMode))
+	}
+
+	missing := checkKeySource(sec)
+	if len(missing) != 0 {
+		return errors.Errorf("encryption key source (%s) is not available: %s. %s",
+			keySource(sec), strings.Join(missing, "; "), hint)
+	}
+
+	return nil
+}
+
+// checkKeySource returns what is missing for mongod to get the master key
+func checkKeySource(sec *pbm.MongodOptsSec) []string {
+	var missing []string
+	file := func(opt string, p *string, secret bool) {
+		if p == nil || *p == "" {
+			return
+		}
+		if err := checkReadable(*p, secret && !boolval(sec.RelaxPermChecks)); err != nil {
+			missing = append(missing, opt+": "+err.Error())
+		}
+	}
+	server := func(opt string, hosts *string, port *int, defPort int) {
+		if hosts == nil || *hosts == "" {
+			missing = append(missing, opt+" is not set")
+			return
+		}
+		p := defPort
+		if port != nil {
+			p = *port
+		}
+		if err := dialAny(strings.Split(*hosts, ","), p); err != nil {
+			missing = append(missing, opt+": "+err.Error())
+		}
+	}
+
+	switch {
+	case sec.EncryptionKeyFile != nil:
+		file("encryptionKeyFile", sec.EncryptionKeyFile, true)
+	case sec.Vault != nil:
+		v := sec.Vault
+		file("vault.tokenFile", v.TokenFile, true)
+		file("vault.serverCAFile", v.ServerCAFile, false)
+		server("vault.serverName", v.ServerName, v.Port, defaultVaultPort)
+	case sec.KMIP != nil:
+		k := sec.KMIP
+		file("kmip.clientCertificateFile", k.ClientCertificateFile, false)
+		file("kmip.clientKeyFile", k.ClientKeyFile, false)
+		file("kmip.serverCAFile", k.ServerCAFile, false)
+		server("kmip.serverName", k.ServerName, k.Port, defaultKMIPPort)
+	default:
+		missing = append(missing, "none of encryptionKeyFile, vault or kmip is set")
+	}
+
+	return missing
+}
+
+// checkReadable checks the file can be read. `private` means mongod
+// requires the file to be accessible only by the owner.
+func checkReadable(p string, private bool) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return errors.Errorf("%s is a directory", p)
+	}
+	if private && fi.Mode().Perm()&0o077 != 0 {
+		return errors.Errorf("%s is too permissive (%v), should be accessible only by the owner", p, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// dialAny checks that at least one of the servers is reachable
+func dialAny(hosts []string, port int) error {
+	var errs []string
+	for _, h := range hosts {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(strings.TrimSpace(h), strconv.Itoa(port)), keyServerDialTimeout)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return errors.Errorf("unreachable: %s", strings.Join(errs, ", "))
+}
+
+func strval(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func boolval(b *bool) bool {
+	return b != nil && *b
+}
@@ -0,0 +1,108 @@
+package restore
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func encOpts(keyFile string) *pbm.MongodOptsSec {
+	t := true
+	return &pbm.MongodOptsSec{EnableEncryption: &t, EncryptionKeyFile: &keyFile}
+}
+
+func TestCheckEncryptionKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "mongodb-keyfile")
+	if err := os.WriteFile(key, []byte("c2VjcmV0a2V5c2VjcmV0a2V5c2VjcmV0a2V5MTIzNA=="), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	bcp := encOpts("/etc/mongodb-keyfile")
+	if err := checkEncryption(bcp, encOpts(key)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkEncryption(nil, nil); err != nil {
+		t.Errorf("no encryption: unexpected error: %v", err)
+	}
+
+	err := checkEncryption(bcp, encOpts(filepath.Join(dir, "no-such-key")))
+	if err == nil || !strings.Contains(err.Error(), "encryptionKeyFile") || !strings.Contains(err.Error(), "no-such-key") {
+		t.Errorf("missing key file: unexpected error %v", err)
+	}
+
+	if err := os.Chmod(key, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = checkEncryption(bcp, encOpts(key))
+	if err == nil || !strings.Contains(err.Error(), "too permissive") {
+		t.Errorf("permissive key file: unexpected error %v", err)
+	}
+	relax := encOpts(key)
+	relax.RelaxPermChecks = new(bool)
+	*relax.RelaxPermChecks = true
+	if err := checkEncryption(bcp, relax); err != nil {
+		t.Errorf("relaxPermChecks: unexpected error: %v", err)
+	}
+
+	err = checkEncryption(bcp, nil)
+	if err == nil || !strings.Contains(err.Error(), "/etc/mongodb-keyfile") {
+		t.Errorf("not encrypted node: unexpected error %v", err)
+	}
+
+	// the key source for the restore is set by the config
+	sec := withEncryption(nil, encOpts(key))
+	if err := os.Chmod(key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEncryption(bcp, sec); err != nil {
+		t.Errorf("override: unexpected error: %v", err)
+	}
+}
+
+func TestCheckEncryptionKMIP(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "client.pem")
+	if err := os.WriteFile(cert, []byte("cert"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a port nobody listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	host := "127.0.0.1"
+	enabled := true
+	sec := &pbm.MongodOptsSec{EnableEncryption: &enabled}
+	sec.KMIP = &pbm.MongodOptsKMIP{ServerName: &host, Port: &port, ClientCertificateFile: &cert}
+
+	err = checkEncryption(sec, sec)
+	if err == nil || !strings.Contains(err.Error(), "kmip.serverName") || !strings.Contains(err.Error(), "unreachable") {
+		t.Errorf("unreachable KMIP: unexpected error %v", err)
+	}
+
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port = ln.Addr().(*net.TCPAddr).Port
+	if err := checkEncryption(sec, sec); err != nil {
+		t.Errorf("reachable KMIP: unexpected error %v", err)
+	}
+
+	ca := filepath.Join(dir, "ca.pem")
+	sec.KMIP.ServerCAFile = &ca
+	err = checkEncryption(sec, sec)
+	if err == nil || !strings.Contains(err.Error(), "kmip.serverCAFile") {
+		t.Errorf("missing CA: unexpected error %v", err)
+	}
+}
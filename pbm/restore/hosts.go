@@ -0,0 +1,82 @@
+package restore
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const hostLookupTimeout = 10 * time.Second
+
+// lookupHost resolves the host name. It's a var so tests can replace it.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// checkHostsResolvable makes sure the hosts the restore writes into the
+// replset config, config.shards and shardIdentity can be resolved on the
+// node. With the DNS seed lists and logical host names, the names may not
+// be resolvable from every environment, and the restored node would fail
+// to rejoin the cluster only after all data has been copied.
+func (r *PhysRestore) checkHostsResolvable() error {
+	var hosts []string
+	for _, m := range r.rsConf.Members {
+		hosts = append(hosts, m.Host)
+	}
+	if r.nodeInfo.IsConfigSrv() {
+		for _, s := range r.shards {
+			hosts = append(hosts, connStrHosts(s)...)
+		}
+	}
+	hosts = append(hosts, connStrHosts(r.cfgConn)...)
+
+	return resolveHosts(context.Background(), hosts)
+}
+
+// connStrHosts returns the hosts of the `rs/host1:port,host2:port`
+// connection string
+func connStrHosts(s string) []string {
+	if s == "" {
+		return nil
+	}
+	if _, h, ok := strings.Cut(s, "/"); ok {
+		s = h
+	}
+	return strings.Split(s, ",")
+}
+
+// resolveHosts fails if any of host:port names can't be resolved.
+// The error lists all unresolvable ones.
+func resolveHosts(ctx context.Context, hosts []string) error {
+	seen := make(map[string]struct{})
+	var failed []string
+	for _, h := range hosts {
+		name := h
+		if hn, _, err := net.SplitHostPort(h); err == nil {
+			name = hn
+		}
+		if name == "" || net.ParseIP(name) != nil {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		lctx, cancel := context.WithTimeout(ctx, hostLookupTimeout)
+		_, err := lookupHost(lctx, name)
+		cancel()
+		if err != nil {
+			failed = append(failed, name+" ("+err.Error()+")")
+		}
+	}
+
+	if len(failed) != 0 {
+		sort.Strings(failed)
+		return errors.Errorf("member hosts can't be resolved on the node, "+
+			"check DNS records or the hosts file: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResolveHosts(t *testing.T) {
+	defer func(f func(context.Context, string) ([]string, error)) { lookupHost = f }(lookupHost)
+	var looked []string
+	lookupHost = func(_ context.Context, h string) ([]string, error) {
+		looked = append(looked, h)
+		if strings.HasSuffix(h, ".invalid") {
+			return nil, errors.New("no such host")
+		}
+		return []string{"10.0.0.1"}, nil
+	}
+
+	hosts := append([]string{"rs101.svc:27017", "rs101.svc:27018", "10.0.0.2:27017"},
+		connStrHosts("rs1/rs102.invalid:27017,rs103.svc:27017")...)
+	err := resolveHosts(context.Background(), hosts)
+	if err == nil || !strings.Contains(err.Error(), "rs102.invalid (no such host)") {
+		t.Errorf("expected rs102.invalid to fail, got %v", err)
+	}
+	if want := []string{"rs101.svc", "rs102.invalid", "rs103.svc"}; !equalStrings(looked, want) {
+		t.Errorf("looked up %v, want %v", looked, want)
+	}
+
+	if err := resolveHosts(context.Background(), connStrHosts("rs1/rs101.svc:27017")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package restore
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+var cgroupRoot = "/sys/fs/cgroup"
+
+const (
+	ioprioClassBE   = 2
+	ioprioClassIdle = 3
+	ioprioClassSh   = 13
+	ioprioWhoProc   = 1
+)
+
+// setPriority applies the niceness and the IO priority of the limits to
+// the process or the thread `id`. All that can be applied is applied,
+// the error lists the rest.
+func setPriority(id int, c *pbm.RestoreLimitsConf) error {
+	var errs []string
+	if c.Nice != 0 {
+		err := syscall.Setpriority(syscall.PRIO_PROCESS, id, c.Nice)
+		if err != nil {
+			errs = append(errs, "nice "+strconv.Itoa(c.Nice)+": "+err.Error())
+		}
+	}
+	if c.IOClass != "" {
+		prio := ioprioClassIdle << ioprioClassSh
+		if c.IOClass == pbm.IOClassBestEffort {
+			lvl := pbm.DefaultIOLevel
+			if c.IOLevel != nil {
+				lvl = *c.IOLevel
+			}
+			prio = ioprioClassBE<<ioprioClassSh | lvl
+		}
+		_, _, e := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProc, uintptr(id), uintptr(prio))
+		if e != 0 {
+			errs = append(errs, "ionice "+c.IOClass+": "+e.Error())
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// limitProc puts the process `pid` (the internal mongod) under the limits
+func limitProc(pid int, c *pbm.RestoreLimitsConf) error {
+	err := setPriority(pid, c)
+	if c.Cgroup != "" {
+		if cerr := joinCgroup(c.Cgroup, pid); cerr != nil {
+			if err != nil {
+				return errors.Errorf("%v; %v", err, cerr)
+			}
+			return cerr
+		}
+	}
+	return err
+}
+
+// limitThread puts the calling OS thread under the niceness and the IO
+// priority of the limits. The goroutine has to be locked to the thread
+// for good (runtime.LockOSThread with no unlock), so the thread exits
+// along with it rather than goes back to the runtime with lowered
+// priority.
+func limitThread(c *pbm.RestoreLimitsConf) error {
+	return setPriority(syscall.Gettid(), c)
+}
+
+func cgroupDir(cg string) string {
+	if filepath.IsAbs(cg) {
+		return cg
+	}
+	return filepath.Join(cgroupRoot, cg)
+}
+
+// joinCgroup moves the process into the cgroup
+func joinCgroup(cg string, pid int) error {
+	p := filepath.Join(cgroupDir(cg), "cgroup.procs")
+	err := os.WriteFile(p, []byte(strconv.Itoa(pid)), 0)
+	return errors.Wrapf(err, "join cgroup %s", cg)
+}
+
+// enterCgroup moves the agent process into the cgroup and returns the func
+// moving it back into its own one
+func enterCgroup(cg string) (leave func() error, err error) {
+	own, err := procCgroup("/proc/self/cgroup")
+	if err != nil {
+		return nil, errors.Wrap(err, "get own cgroup")
+	}
+
+	pid := os.Getpid()
+	err = joinCgroup(cg, pid)
+	if err != nil {
+		return nil, err
+	}
+	return func() error { return joinCgroup(own, pid) }, nil
+}
+
+// procCgroup returns the cgroup v2 of the process from /proc/<pid>/cgroup
+// (the `0::<path>` line)
+func procCgroup(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if l := sc.Text(); strings.HasPrefix(l, "0::") {
+			return cgroupDir(strings.TrimPrefix(l, "0::/")), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no cgroup v2, only cgroup v2 is supported")
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+package restore
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// errLimitsUnsupported is returned as the niceness, the IO priority and
+// cgroup v2 limits are applied by Linux-only means
+var errLimitsUnsupported = errors.New("restore limits are supported on Linux only")
+
+// limitProc puts the process `pid` (the internal mongod) under the limits
+func limitProc(int, *pbm.RestoreLimitsConf) error {
+	return errLimitsUnsupported
+}
+
+// limitThread puts the calling OS thread under the niceness and the IO
+// priority of the limits
+func limitThread(*pbm.RestoreLimitsConf) error {
+	return errLimitsUnsupported
+}
+
+// enterCgroup moves the agent process into the cgroup and returns the func
+// moving it back into its own one
+func enterCgroup(string) (func() error, error) {
+	return nil, errLimitsUnsupported
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcCgroup(t *testing.T) {
+	root := t.TempDir()
+	defer func(r string) { cgroupRoot = r }(cgroupRoot)
+	cgroupRoot = root
+
+	dir := t.TempDir()
+	v2 := filepath.Join(dir, "v2")
+	v1 := filepath.Join(dir, "v1")
+	if err := os.WriteFile(v2, []byte("0::/system.slice/pbm-agent.service\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(v1, []byte("12:cpu,cpuacct:/\n1:name=systemd:/\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cg, err := procCgroup(v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "system.slice/pbm-agent.service"); cg != want {
+		t.Errorf("got %s, want %s", cg, want)
+	}
+	if _, err := procCgroup(v1); err == nil {
+		t.Error("expected error on cgroup v1")
+	}
+
+	if err := os.Mkdir(filepath.Join(root, "pbm-restore"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := joinCgroup("pbm-restore", 42); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(root, "pbm-restore", "cgroup.procs"))
+	if err != nil || string(b) != "42" {
+		t.Errorf("cgroup.procs: got %q, %v", b, err)
+	}
+	if err := joinCgroup("none", 42); err == nil {
+		t.Error("expected error on missing cgroup")
+	}
+}
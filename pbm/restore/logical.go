@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"path"
 	"strings"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/oplog"
@@ -31,6 +33,8 @@ type Restore struct {
 	stopHB   chan struct{}
 	nodeInfo *pbm.NodeInfo
 	stg      storage.Storage
+	// stgConf is the config stg is created with
+	stgConf pbm.StorageConf
 	// Shards to participate in restore. Num of shards in bcp could
 	// be less than in the cluster and this is ok. Only these shards
 	// would be expected to run restore (distributed transactions sync,
@@ -48,6 +52,14 @@ type Restore struct {
 	oplog *oplog.OplogRestore
 	log   *log.Event
 	opid  string
+	// bcp is the backup being restored, nil for the oplog replay
+	bcp *pbm.BackupMeta
+	// pbmState means the PBM config and backups metadata are restored
+	// from the backup (see pbm.RestoreCmd.WithPBMState)
+	pbmState bool
+	// replCatchUp is how long to wait for the secondaries to catch up
+	// once the oplog is applied (see pbm.RestoreConf.ReplCatchUpTimeoutSec)
+	replCatchUp time.Duration
 }
 
 // New creates a new restore object
@@ -79,6 +91,7 @@ func (r *Restore) exit(err error, l *log.Event) {
 		}
 	}
 
+	r.report(l)
 	r.Close()
 }
 
@@ -90,16 +103,23 @@ func (r *Restore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (er
 	if err != nil {
 		return err
 	}
+	r.bcp = bcp
 
 	err = r.init(cmd.Name, opid, l)
 	if err != nil {
 		return err
 	}
 
+	err = r.useBackupStorage(bcp)
+	if err != nil {
+		return err
+	}
+
 	nss := cmd.Namespaces
 	if !sel.IsSelective(nss) {
 		nss = bcp.Namespaces
 	}
+	r.pbmState = cmd.WithPBMState && !sel.IsSelective(nss)
 
 	err = r.cn.SetRestoreBackup(r.name, cmd.BackupName, nss)
 	if err != nil {
@@ -134,6 +154,7 @@ func (r *Restore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (er
 	if err != nil {
 		return err
 	}
+	r.verifySample(bcp, dump, nss)
 
 	err = r.toState(pbm.StatusDumpDone, nil)
 	if err != nil {
@@ -144,6 +165,8 @@ func (r *Restore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (er
 	if r.nodeInfo.IsConfigSrv() && sel.IsSelective(nss) {
 		oplogOption.nss = []string{"config.databases"}
 		oplogOption.filter = newConfigsvrOpFilter(nss)
+	} else if sel.IsSelective(nss) && bcp.NSWrites != nil {
+		r.setNSWritesOption(bcp, oplogOption)
 	}
 
 	err = r.applyOplog([]pbm.OplogChunk{{
@@ -156,12 +179,59 @@ func (r *Restore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event) (er
 	if err != nil {
 		return err
 	}
+	r.waitReplCatchUp()
 
 	if err = r.updateRouterConfig(r.cn.Context()); err != nil {
 		return errors.WithMessage(err, "update router config")
 	}
 
-	return r.Done()
+	err = r.Done()
+	if err != nil {
+		return err
+	}
+
+	if r.pbmState && r.nodeInfo.IsLeader() {
+		r.resyncPBMState()
+	}
+
+	return nil
+}
+
+// resyncPBMState rebuilds the backups list from the storage of the config
+// restored from the backup and resets the epoch, so agents pick up the
+// restored config
+func (r *Restore) resyncPBMState() {
+	r.log.Info("PBM state restored from the backup, resync the backups list")
+	err := r.cn.ResyncStorage(r.log)
+	if err != nil {
+		r.log.Error("resync the backups list: %v. Run `pbm config --force-resync`", err)
+		return
+	}
+
+	ep, err := r.cn.ResetEpoch()
+	if err != nil {
+		r.log.Error("reset epoch: %v", err)
+		return
+	}
+	r.log.Debug("epoch set to %v", ep)
+}
+
+// pbmCollFilter wraps the namespaces filter of the restore to skip
+// the PBM collections. pbm.StateCollections are let through if the PBM
+// state is restored as well.
+func (r *Restore) pbmCollFilter(match archive.NSFilterFn) archive.NSFilterFn {
+	return func(ns string) bool {
+		if !snapshot.IsPBMNamespace(ns) {
+			return match(ns)
+		}
+		if r.pbmState && snapshot.IsPBMStateNamespace(ns) {
+			r.log.Info("restoring PBM collection %s", ns)
+			return match(ns)
+		}
+
+		r.log.Info("skipping PBM collection %s", ns)
+		return false
+	}
 }
 
 // newConfigsvrOpFilter filters out not needed ops during selective backup on configsvr
@@ -187,6 +257,20 @@ func newConfigsvrOpFilter(nss []string) oplog.OpFilter {
 	}
 }
 
+// setNSWritesOption skips the ops of the selected namespaces that had no
+// writes during the backup (see pbm.BackupMeta.NSWrites). The replay still
+// goes up to the backup's LastWriteTS so transactions aren't cut. If the
+// spans are inconsistent with the backup, the whole oplog is replayed.
+func (r *Restore) setNSWritesOption(bcp *pbm.BackupMeta, o *applyOplogOption) {
+	err := bcp.NSWrites.Validate(bcp.FirstWriteTS, bcp.LastWriteTS)
+	if err != nil {
+		r.log.Warning("namespaces write spans: %v. Replaying the whole backup oplog", err)
+		return
+	}
+
+	o.filter = oplog.NewNSWritesFilter(bcp.NSWrites)
+}
+
 // PITR do the Point-in-Time Recovery
 func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err error) {
 	defer func() { r.exit(err, l) }() // !!! has to be in a closure
@@ -216,6 +300,13 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 		}
 	}
 
+	r.bcp = bcp
+
+	err = r.useBackupStorage(bcp)
+	if err != nil {
+		return err
+	}
+
 	nss := cmd.Namespaces
 	if len(nss) == 0 {
 		nss = bcp.Namespaces
@@ -256,7 +347,16 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 		r.sMap = r.getShardMapping(bcp)
 	}
 
-	chunks, err := r.chunks(bcp.LastWriteTS, tsTo)
+	stopTS, err := r.stopPoint(bcp, tsTo, cmd.MaxLag)
+	if err != nil {
+		return errors.Wrap(err, "define stop point")
+	}
+	if stopTS != tsTo {
+		r.log.Info("oplog is available up to %v, replay stops %ds before the target %v",
+			stopTS, tsTo.T-stopTS.T, tsTo)
+	}
+
+	chunks, err := r.chunks(bcp.LastWriteTS, stopTS)
 	if err != nil {
 		return err
 	}
@@ -275,6 +375,7 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 	if err != nil {
 		return err
 	}
+	r.verifySample(bcp, dump, nss)
 
 	err = r.toState(pbm.StatusDumpDone, nil)
 	if err != nil {
@@ -289,7 +390,7 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 		EndTS:       bcp.LastWriteTS,
 	}
 
-	oplogOption := applyOplogOption{end: &tsTo, nss: nss}
+	oplogOption := applyOplogOption{end: &stopTS, nss: nss}
 	if r.nodeInfo.IsConfigSrv() && sel.IsSelective(nss) {
 		oplogOption.nss = []string{"config.databases"}
 		oplogOption.filter = newConfigsvrOpFilter(nss)
@@ -299,6 +400,7 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 	if err != nil {
 		return err
 	}
+	r.waitReplCatchUp()
 
 	if err = r.updateRouterConfig(r.cn.Context()); err != nil {
 		return errors.WithMessage(err, "update router config")
@@ -307,6 +409,36 @@ func (r *Restore) PITR(cmd *pbm.PITRestoreCmd, opid pbm.OPID, l *log.Event) (err
 	return r.Done()
 }
 
+// sysNSRe matches namespaces of PBM and mongod internal writes
+const sysNSRe = `admin\.(pbm|system\.)|config\.`
+
+// userWriteAfter returns the time of the first oplog entry after `ts`
+// changing the user data and nil if there's none. The commands on
+// the admin db are skipped unless they're transactions with user writes.
+func userWriteAfter(ctx context.Context, m *mongo.Client, ts primitive.Timestamp) (*primitive.Timestamp, error) {
+	var e struct {
+		TS primitive.Timestamp `bson:"ts"`
+	}
+	err := m.Database("local").Collection("oplog.rs").FindOne(ctx, bson.D{
+		{"ts", bson.M{"$gt": ts}},
+		{"op", bson.M{"$ne": "n"}},
+		{"$or", bson.A{
+			bson.M{"ns": bson.M{"$not": primitive.Regex{Pattern: "^(" + sysNSRe + `|admin\.\$cmd$)`}}},
+			bson.M{"o.applyOps": bson.M{"$elemMatch": bson.M{
+				"ns": bson.M{"$not": primitive.Regex{Pattern: "^(" + sysNSRe + ")"}},
+			}}},
+		}},
+	}).Decode(&e)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &e.TS, nil
+}
+
 func (r *Restore) ReplayOplog(cmd *pbm.ReplayCmd, opid pbm.OPID, l *log.Event) (err error) {
 	defer func() { r.exit(err, l) }() // !!! has to be in a closure
 
@@ -318,19 +450,27 @@ func (r *Restore) ReplayOplog(cmd *pbm.ReplayCmd, opid pbm.OPID, l *log.Event) (
 		return errors.Errorf("%q is not primary", r.nodeInfo.SetName)
 	}
 
+	if !cmd.NoWritesAfter.IsZero() {
+		ts, err := userWriteAfter(r.cn.Context(), r.node.Session(), cmd.NoWritesAfter)
+		if err != nil {
+			return errors.Wrap(err, "check writes after the restore")
+		}
+		if ts != nil {
+			return errors.Errorf("the replset has writes since %v (e.g. at %v), "+
+				"the data isn't at the restore's recovery point anymore", cmd.NoWritesAfter, *ts)
+		}
+	}
+
 	r.shards, err = r.cn.ClusterMembers()
 	if err != nil {
 		return errors.Wrap(err, "get cluster members")
 	}
 
-	if r.nodeInfo.IsLeader() {
-		err := r.cn.SetOplogTimestamps(r.name, int64(cmd.Start.T), int64(cmd.End.T))
-		if err != nil {
-			return errors.Wrap(err, "set oplog timestamps")
-		}
+	to := cmd.End
+	if to.IsZero() {
+		to = primitive.Timestamp{T: math.MaxUint32}
 	}
-
-	oplogShards, err := r.cn.AllOplogRSNames(r.cn.Context(), cmd.Start, cmd.End)
+	oplogShards, err := r.cn.AllOplogRSNames(r.cn.Context(), cmd.Start, to)
 	if err != nil {
 		return err
 	}
@@ -340,11 +480,19 @@ func (r *Restore) ReplayOplog(cmd *pbm.ReplayCmd, opid pbm.OPID, l *log.Event) (
 		return errors.WithMessage(err, "topology")
 	}
 
+	end, err := r.replayEnd(cmd, oplogShards)
+	if err != nil {
+		return errors.Wrap(err, "define end point")
+	}
+	if cmd.End.IsZero() {
+		r.log.Info("replaying up to %v, the latest point all replsets have the oplog for", end)
+	}
+
 	if !Contains(oplogShards, pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)) {
 		return r.Done() // skip. no oplog for current rs
 	}
 
-	chunks, err := r.chunks(cmd.Start, cmd.End)
+	chunks, err := r.chunks(cmd.Start, end)
 	if err != nil {
 		return err
 	}
@@ -356,12 +504,13 @@ func (r *Restore) ReplayOplog(cmd *pbm.ReplayCmd, opid pbm.OPID, l *log.Event) (
 
 	oplogOption := applyOplogOption{
 		start:  &cmd.Start,
-		end:    &cmd.End,
+		end:    &end,
 		unsafe: true,
 	}
 	if err = r.applyOplog(chunks, &oplogOption); err != nil {
 		return err
 	}
+	r.waitReplCatchUp()
 
 	return r.Done()
 }
@@ -377,8 +526,14 @@ func (r *Restore) init(name string, opid pbm.OPID, l *log.Event) (err error) {
 		return errors.Wrap(err, "unable to define replica set")
 	}
 
+	cfg, err := r.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+
 	r.name = name
 	r.opid = opid.String()
+	r.replCatchUp = cfg.Restore.ReplCatchUpTimeout()
 	if r.nodeInfo.IsLeader() {
 		ts, err := r.cn.ClusterTime()
 		if err != nil {
@@ -394,6 +549,12 @@ func (r *Restore) init(name string, opid pbm.OPID, l *log.Event) (err error) {
 			Replsets: []pbm.RestoreReplset{},
 			Hb:       ts,
 		}
+		stg := pbm.RestoreStorageConf(cfg)
+		meta.Store = &stg
+		meta.PITRChunks, err = r.cn.HasPITRChunks()
+		if err != nil {
+			return errors.Wrap(err, "init restore meta, check pitr chunks")
+		}
 		err = r.cn.SetRestoreMeta(meta)
 		if err != nil {
 			return errors.Wrap(err, "write backup meta to db")
@@ -436,10 +597,15 @@ func (r *Restore) init(name string, opid pbm.OPID, l *log.Event) (err error) {
 		return errors.Wrap(err, "add shard's metadata")
 	}
 
-	r.stg, err = r.cn.GetStorage(r.log)
+	r.stg, err = pbm.RestoreStorage(cfg, r.log)
 	if err != nil {
 		return errors.Wrap(err, "get backup storage")
 	}
+	r.stgConf = pbm.RestoreStorageConf(cfg)
+	err = pbm.CheckRestoreStorage(cfg, r.stg)
+	if err != nil {
+		return errors.Wrap(err, "check backup storage")
+	}
 
 	return nil
 }
@@ -479,8 +645,9 @@ func (r *Restore) chunks(from, to primitive.Timestamp) ([]pbm.OplogChunk, error)
 		return nil, errors.Wrap(err, "get chunks index")
 	}
 
-	if len(chunks) == 0 {
-		return nil, errors.New("no chunks found")
+	err = pbm.CheckPITRGap(mapRevRS(r.nodeInfo.SetName), chunks, from, to)
+	if err != nil {
+		return nil, err
 	}
 
 	if primitive.CompareTimestamp(chunks[len(chunks)-1].EndTS, to) == -1 {
@@ -503,6 +670,179 @@ func (r *Restore) chunks(from, to primitive.Timestamp) ([]pbm.OplogChunk, error)
 	return chunks, nil
 }
 
+// stopPoint returns the cluster-wide point the oplog replay stops at.
+// The leader defines it and others wait for it so all replsets stop at
+// the same point.
+func (r *Restore) stopPoint(bcp *pbm.BackupMeta, target primitive.Timestamp, maxLag int64) (primitive.Timestamp, error) {
+	if r.nodeInfo.IsLeader() {
+		coverage := make(map[string]primitive.Timestamp, len(bcp.Replsets))
+		for _, rs := range bcp.Replsets {
+			chunks, err := r.cn.PITRGetChunksSlice(rs.Name, bcp.LastWriteTS, target)
+			if err != nil {
+				return primitive.Timestamp{}, errors.Wrapf(err, "get chunks index for %s", rs.Name)
+			}
+			// fail before any data is touched
+			err = pbm.CheckPITRGap(rs.Name, chunks, bcp.LastWriteTS, target)
+			if err != nil {
+				return primitive.Timestamp{}, err
+			}
+			coverage[rs.Name] = oplogCoverage(chunks, bcp.LastWriteTS)
+		}
+
+		stop, err := pitrStopPoint(target, maxLag, coverage)
+		if err != nil {
+			return stop, err
+		}
+
+		err = r.cn.SetRestoreStopTS(r.name, stop)
+		return stop, errors.Wrap(err, "write stop point")
+	}
+
+	return r.waitStopPoint()
+}
+
+// replayEnd returns the cluster-wide point the oplog replay ends at:
+// cmd.End or, if it's not set, the latest point all replsets have
+// the continuous oplog for since cmd.Start. The leader defines it and
+// records it as the stop point, so the recovery point of the replay is
+// exact (see pbm.RestoreReport). Others wait for it.
+func (r *Restore) replayEnd(cmd *pbm.ReplayCmd, oplogShards []string) (primitive.Timestamp, error) {
+	if !r.nodeInfo.IsLeader() {
+		return r.waitStopPoint()
+	}
+
+	end := cmd.End
+	if end.IsZero() {
+		coverage := make(map[string]primitive.Timestamp, len(oplogShards))
+		for _, rs := range oplogShards {
+			chunks, err := r.cn.PITRGetChunksSlice(rs, cmd.Start, primitive.Timestamp{T: math.MaxUint32})
+			if err != nil {
+				return primitive.Timestamp{}, errors.Wrapf(err, "get chunks index for %s", rs)
+			}
+			if len(chunks) != 0 {
+				err = pbm.CheckPITRGap(rs, chunks, cmd.Start, chunks[len(chunks)-1].EndTS)
+				if err != nil {
+					return primitive.Timestamp{}, err
+				}
+			}
+			coverage[rs] = oplogCoverage(chunks, cmd.Start)
+		}
+
+		var err error
+		end, err = commonOplogEnd(cmd.Start, coverage)
+		if err != nil {
+			return end, err
+		}
+	}
+
+	err := r.cn.SetOplogTimestamps(r.name, int64(cmd.Start.T), int64(end.T))
+	if err != nil {
+		return end, errors.Wrap(err, "set oplog timestamps")
+	}
+	err = r.cn.SetRestoreStopTS(r.name, end)
+	return end, errors.Wrap(err, "write stop point")
+}
+
+// commonOplogEnd returns the latest point all replsets have the oplog for
+// given the end of their continuous oplog since `start`
+func commonOplogEnd(start primitive.Timestamp, coverage map[string]primitive.Timestamp) (primitive.Timestamp, error) {
+	if len(coverage) == 0 {
+		return start, errors.Errorf("no oplog after %v", start)
+	}
+
+	var end primitive.Timestamp
+	var lagging string
+	for rs, e := range coverage {
+		if end.IsZero() || primitive.CompareTimestamp(e, end) == -1 {
+			end, lagging = e, rs
+		}
+	}
+	if primitive.CompareTimestamp(end, start) != 1 {
+		return end, errors.Errorf("no continuous oplog of %s after %v", lagging, start)
+	}
+
+	return end, nil
+}
+
+// waitStopPoint waits for the leader to define the stop point
+// of the oplog replay
+func (r *Restore) waitStopPoint() (primitive.Timestamp, error) {
+	tk := time.NewTicker(time.Second)
+	defer tk.Stop()
+	tout := time.NewTimer(pbm.WaitActionStart)
+	defer tout.Stop()
+	for {
+		select {
+		case <-tk.C:
+			meta, err := r.cn.GetRestoreMeta(r.name)
+			if err != nil {
+				return primitive.Timestamp{}, errors.Wrap(err, "get restore metadata")
+			}
+			if meta.Status == pbm.StatusError {
+				return primitive.Timestamp{}, errors.Errorf("restore failed with: %s", meta.Error)
+			}
+			if !meta.StopTS.IsZero() {
+				return meta.StopTS, nil
+			}
+		case <-tout.C:
+			return primitive.Timestamp{}, errors.New("no stop point from the leader")
+		}
+	}
+}
+
+// oplogCoverage returns the end of the continuous oplog starting
+// at `from` the chunks (sorted by start_ts) have
+func oplogCoverage(chunks []pbm.OplogChunk, from primitive.Timestamp) primitive.Timestamp {
+	end := from
+	for _, c := range chunks {
+		if primitive.CompareTimestamp(c.StartTS, end) == 1 {
+			break
+		}
+		if primitive.CompareTimestamp(c.EndTS, end) == 1 {
+			end = c.EndTS
+		}
+	}
+
+	return end
+}
+
+// pitrStopPoint returns the latest point up to the target all replsets
+// have the oplog for. It fails if the point is more than maxLag (seconds)
+// before the target.
+func pitrStopPoint(target primitive.Timestamp, maxLag int64, coverage map[string]primitive.Timestamp) (primitive.Timestamp, error) {
+	stop, lagging := target, ""
+	for rs, end := range coverage {
+		if primitive.CompareTimestamp(end, stop) == -1 {
+			stop, lagging = end, rs
+		}
+	}
+
+	lag := int64(target.T) - int64(stop.T)
+	if lagging != "" && (maxLag == 0 || lag > maxLag) {
+		return stop, errors.Errorf("oplog of %s is available up to %v, that is %ds before the target %v (max lag %ds)",
+			lagging, stop, lag, target, maxLag)
+	}
+
+	return stop, nil
+}
+
+// useBackupStorage switches the restore to the storage the backup and
+// the PITR chunks on top of it are read with (see pbm.BackupStorage)
+func (r *Restore) useBackupStorage(bcp *pbm.BackupMeta) error {
+	cfg, err := r.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+
+	stg, conf, err := pbm.BackupStorage(cfg, bcp, r.log)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+
+	r.stg, r.stgConf = stg, conf
+	return nil
+}
+
 func (r *Restore) SnapshotMeta(backupName string) (bcp *pbm.BackupMeta, err error) {
 	bcp, err = r.cn.GetBackupMeta(backupName)
 	if errors.Is(err, pbm.ErrNotFound) {
@@ -615,9 +955,13 @@ func (r *Restore) checkSnapshot(bcp *pbm.BackupMeta) error {
 			return errors.WithMessage(err, "get mongo version")
 		}
 
-		if majmin(bcp.MongoVersion) != majmin(ver.VersionString) {
+		bcpVer := bcp.MongoVersion
+		if rs := getRS(bcp, pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)); rs != nil && rs.MongoVersion != "" {
+			bcpVer = rs.MongoVersion
+		}
+		if majmin(bcpVer) != majmin(ver.VersionString) {
 			r.log.Warning("backup mongo version %q is incompatible with the running mongo version %q",
-				bcp.MongoVersion, ver.VersionString)
+				bcpVer, ver.VersionString)
 			return nil
 		}
 	}
@@ -668,6 +1012,13 @@ func (r *Restore) RunSnapshot(dump string, bcp *pbm.BackupMeta, nss []string) (e
 		if err != nil {
 			return errors.WithMessage(err, "get config")
 		}
+		cfg.Storage = r.stgConf
+
+		var metaFn archive.NSMetaFn
+		metaFn, err = r.collCompressionFn(cfg.Restore.CollectionCompression)
+		if err != nil {
+			return errors.WithMessage(err, "collection compression")
+		}
 
 		rdr, err = snapshot.DownloadDump(
 			func(ns string) (io.ReadCloser, error) {
@@ -681,7 +1032,8 @@ func (r *Restore) RunSnapshot(dump string, bcp *pbm.BackupMeta, nss []string) (e
 				return stg.SourceReader(path.Join(bcp.Name, mapRS(r.node.RS()), ns))
 			},
 			bcp.Compression,
-			sel.MakeSelectedPred(nss))
+			r.pbmCollFilter(sel.MakeSelectedPred(nss)),
+			metaFn)
 	}
 	if err != nil {
 		return err
@@ -1119,7 +1471,7 @@ func (r *Restore) snapshot(input io.Reader) (err error) {
 		return errors.Wrap(err, "unable to get PBM config settings")
 	}
 
-	rf, err := snapshot.NewRestore(r.node.ConnURI(), &cfg)
+	rf, err := snapshot.NewRestore(r.node.ConnURI(), &cfg, r.pbmState)
 	if err != nil {
 		return err
 	}
@@ -1148,6 +1500,70 @@ func (r *Restore) replayChunk(file string, c compress.CompressionType) (lts prim
 
 // Done waits for the replicas to finish the job
 // and marks restore as done
+// replCatchUpInterval is how often the secondaries' lag is checked
+var replCatchUpInterval = time.Second
+
+// waitReplCatchUp waits (up to r.replCatchUp) for the secondaries to catch
+// up with the primary, so the restore isn't done while the restored data
+// is still on the way to them (e.g. for change stream consumers reading
+// from the secondaries). The remaining lag is recorded in the replset's
+// restore meta.
+func (r *Restore) waitReplCatchUp() {
+	lag, err := waitReplLag(r.node.GetReplsetStatus, r.replCatchUp)
+	if err != nil {
+		r.log.Warning("wait for secondaries to catch up: %v", err)
+		return
+	}
+	if lag > 0 {
+		r.log.Warning("secondaries are still lagging by %ds after %v", lag, r.replCatchUp)
+	} else {
+		r.log.Info("secondaries caught up")
+	}
+
+	err = r.cn.SetRestoreRSReplLag(r.name, r.nodeInfo.SetName, lag)
+	if err != nil {
+		r.log.Warning("record replication lag: %v", err)
+	}
+}
+
+func waitReplLag(status func() (*pbm.ReplsetStatus, error), timeout time.Duration) (int, error) {
+	dn := time.Now().Add(timeout)
+	for {
+		s, err := status()
+		if err != nil {
+			return 0, errors.Wrap(err, "get replset status")
+		}
+		lag := secondariesLag(s)
+		if lag <= 0 || !time.Now().Before(dn) {
+			return lag, nil
+		}
+		time.Sleep(replCatchUpInterval)
+	}
+}
+
+// secondariesLag returns the lag (in seconds) of the most lagging healthy
+// secondary behind the primary
+func secondariesLag(s *pbm.ReplsetStatus) int {
+	var primary uint32
+	for _, m := range s.Members {
+		if m.State == pbm.NodeStatePrimary && m.Optime != nil {
+			primary = m.Optime.TS.T
+		}
+	}
+
+	lag := 0
+	for _, m := range s.Members {
+		if m.State != pbm.NodeStateSecondary || m.Health != pbm.NodeHealthUp || m.Optime == nil {
+			continue
+		}
+		if l := int(primary) - int(m.Optime.TS.T); l > lag {
+			lag = l
+		}
+	}
+
+	return lag
+}
+
 func (r *Restore) Done() error {
 	err := r.cn.ChangeRestoreRSState(r.name, r.nodeInfo.SetName, pbm.StatusDone, "")
 	if err != nil {
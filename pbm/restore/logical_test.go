@@ -0,0 +1,101 @@
+package restore
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestOplogCoverage(t *testing.T) {
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+	chunks := []pbm.OplogChunk{
+		{StartTS: ts(5), EndTS: ts(20)},
+		{StartTS: ts(20), EndTS: ts(30)},
+		{StartTS: ts(40), EndTS: ts(50)}, // gap
+	}
+
+	if got := oplogCoverage(chunks, ts(10)); got != ts(30) {
+		t.Errorf("expected coverage up to 30, got %v", got)
+	}
+	if got := oplogCoverage(chunks, ts(1)); got != ts(1) {
+		t.Errorf("expected no coverage, got %v", got)
+	}
+}
+
+func TestPITRStopPoint(t *testing.T) {
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+	target := primitive.Timestamp{T: 100, I: 3}
+
+	cases := []struct {
+		name     string
+		maxLag   int64
+		coverage map[string]primitive.Timestamp
+		want     primitive.Timestamp
+		err      bool
+	}{
+		{"all reach target", 0, map[string]primitive.Timestamp{"rs0": ts(120), "cfg": ts(101)}, target, false},
+		{"no lag allowed", 0, map[string]primitive.Timestamp{"rs0": ts(120), "cfg": ts(95)}, ts(95), true},
+		{"within lag", 10, map[string]primitive.Timestamp{"rs0": ts(97), "cfg": ts(95)}, ts(95), false},
+		{"too far behind", 10, map[string]primitive.Timestamp{"rs0": ts(120), "cfg": ts(80)}, ts(80), true},
+	}
+
+	for _, c := range cases {
+		got, err := pitrStopPoint(target, c.maxLag, c.coverage)
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestCommonOplogEnd(t *testing.T) {
+	ts := func(t uint32) primitive.Timestamp { return primitive.Timestamp{T: t} }
+	start := ts(10)
+
+	end, err := commonOplogEnd(start, map[string]primitive.Timestamp{"rs0": ts(50), "cfg": ts(30)})
+	if err != nil || end != ts(30) {
+		t.Errorf("expected 30, got %v, %v", end, err)
+	}
+	if _, err := commonOplogEnd(start, map[string]primitive.Timestamp{"rs0": ts(50), "cfg": ts(10)}); err == nil {
+		t.Error("expected error on replset with no oplog after the start")
+	}
+	if _, err := commonOplogEnd(start, nil); err == nil {
+		t.Error("expected error on no oplog")
+	}
+}
+
+func TestWaitReplLag(t *testing.T) {
+	defer func(d time.Duration) { replCatchUpInterval = d }(replCatchUpInterval)
+	replCatchUpInterval = time.Millisecond
+
+	member := func(st pbm.NodeState, h pbm.NodeHealth, ts uint32) pbm.NodeStatus {
+		return pbm.NodeStatus{State: st, Health: h, Optime: &pbm.OpTime{TS: primitive.Timestamp{T: ts}}}
+	}
+	// the secondary catches up by 2 sec on every check
+	var checks uint32
+	status := func() (*pbm.ReplsetStatus, error) {
+		checks++
+		return &pbm.ReplsetStatus{Members: []pbm.NodeStatus{
+			member(pbm.NodeStatePrimary, pbm.NodeHealthUp, 100),
+			member(pbm.NodeStateSecondary, pbm.NodeHealthUp, 92+2*checks),
+			member(pbm.NodeStateSecondary, pbm.NodeHealthDown, 10),
+			member(pbm.NodeStateArbiter, pbm.NodeHealthUp, 0),
+		}}, nil
+	}
+
+	lag, err := waitReplLag(status, time.Minute)
+	if err != nil || lag != 0 || checks != 4 {
+		t.Errorf("expected caught up on 4th check, got lag %d after %d checks: %v", lag, checks, err)
+	}
+
+	checks = 0
+	lag, err = waitReplLag(status, 0)
+	if err != nil || lag != 6 {
+		t.Errorf("no wait: expected lag 6, got %d: %v", lag, err)
+	}
+}
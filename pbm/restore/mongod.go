@@ -0,0 +1,246 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	slog "log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// MongodRunOpts describes the mongod instance to run
+type MongodRunOpts struct {
+	// Bin is the mongod binary
+	Bin    string
+	DBpath string
+	// Port the instance listens on (defined by Conf)
+	Port    int
+	Conf    string
+	LogPath string
+	// Params are `--setParameter` values
+	Params []string
+	// Repair runs mongod with `--repair`. Such mongod salvages what data
+	// it can and exits (see MongodRunner.Run).
+	Repair bool
+	// Limits are applied to the mongod process once it's started.
+	// Those that can't be applied are reported via Warn.
+	Limits *pbm.RestoreLimitsConf
+	Warn   func(msg string, args ...interface{})
+}
+
+// Args returns the command line arguments of mongod
+func (o MongodRunOpts) Args() []string {
+	args := []string{"--dbpath", o.DBpath}
+	for _, p := range o.Params {
+		args = append(args, "--setParameter", p)
+	}
+	if o.Conf != "" {
+		args = append(args, "-f", o.Conf)
+	}
+	if o.Repair {
+		args = append(args, "--repair")
+	}
+
+	return append(args, "--logpath", o.LogPath)
+}
+
+// MongodRunner runs the standalone mongod instances the physical restore
+// needs to manipulate restored data. It handles one instance at a time.
+type MongodRunner interface {
+	// Start starts mongod in background
+	Start(opts MongodRunOpts) error
+	// Run runs mongod that exits on its own (e.g. with --repair)
+	// and waits until it's done
+	Run(opts MongodRunOpts) error
+	// WaitReady makes a single attempt to connect to the started mongod
+	// within the timeout. It returns ErrMongodFailed if mongod has failed
+	// and there is no sense to retry.
+	WaitReady(tout time.Duration) (*mongo.Client, error)
+	// Shutdown shuts down the started mongod and waits until it
+	// releases the dbpath
+	Shutdown(c *mongo.Client) error
+	// Version returns the version of the given mongod binary
+	Version(bin string) (string, error)
+}
+
+// ErrMongodFailed means mongod has reported errors and won't get ready
+var ErrMongodFailed = errors.New("mongod failed")
+
+// NewMongodRunner returns MongodRunner that executes mongod binary
+func NewMongodRunner() MongodRunner {
+	return &execMongod{}
+}
+
+type execMongod struct {
+	opts MongodRunOpts
+}
+
+func (m *execMongod) Start(opts MongodRunOpts) error {
+	m.opts = opts
+
+	errBuf := new(bytes.Buffer)
+	cmd := exec.Command(opts.Bin, opts.Args()...)
+
+	cmd.Stderr = errBuf
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	if opts.Limits.IsSet() {
+		err = limitProc(cmd.Process.Pid, opts.Limits)
+		if err != nil && opts.Warn != nil {
+			opts.Warn("apply limits to mongod: %v", err)
+		}
+	}
+
+	// release process resources
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			slog.Printf("mongod process: %v, %s", err, errBuf)
+		}
+	}()
+	return nil
+}
+
+func (m *execMongod) Run(opts MongodRunOpts) error {
+	m.opts = opts
+
+	errBuf := new(bytes.Buffer)
+	cmd := exec.Command(opts.Bin, opts.Args()...)
+
+	cmd.Stderr = errBuf
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	if opts.Limits.IsSet() {
+		err = limitProc(cmd.Process.Pid, opts.Limits)
+		if err != nil && opts.Warn != nil {
+			opts.Warn("apply limits to mongod: %v", err)
+		}
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		return errors.Wrapf(ErrMongodFailed, "%v: %s. See the log %s", err, errBuf, opts.LogPath)
+	}
+	return nil
+}
+
+// WaitReady tries to connect to mongo. If the try is unsuccessful,
+// it checks the mongo logs and reports ErrMongodFailed if there are
+// errors or fatals.
+func (m *execMongod) WaitReady(tout time.Duration) (*mongo.Client, error) {
+	type mlog struct {
+		T struct {
+			Date string `json:"$date"`
+		} `json:"t"`
+		S   string `json:"s"`
+		Msg string `json:"msg"`
+	}
+
+	cn, err := conn(m.opts.Port, tout)
+	if err == nil {
+		return cn, nil
+	}
+
+	f, ferr := os.Open(m.opts.LogPath)
+	if ferr != nil {
+		return nil, errors.Errorf("open logs: %v, connect err: %v", ferr, err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for {
+		var l mlog
+		if derr := dec.Decode(&l); derr == io.EOF {
+			break
+		} else if derr != nil {
+			return nil, errors.Errorf("decode logs: %v, connect err: %v", derr, err)
+		}
+		if l.S == "E" || l.S == "F" {
+			return nil, errors.Wrapf(ErrMongodFailed, "[%s] %s / %s, connect err: %v", l.S, l.Msg, l.T.Date, err)
+		}
+	}
+
+	return nil, err
+}
+
+func (m *execMongod) Shutdown(c *mongo.Client) error {
+	err := c.Database("admin").RunCommand(context.Background(), bson.D{{"shutdown", 1}}).Err()
+	if err != nil && !strings.Contains(err.Error(), "socket was unexpectedly closed") {
+		return err
+	}
+
+	err = waitMgoShutdown(m.opts.DBpath)
+	if err != nil {
+		return errors.Wrap(err, "shutdown")
+	}
+
+	return nil
+}
+
+func (m *execMongod) Version(bin string) (string, error) {
+	cmd := exec.Command(bin, "--version")
+
+	stderr := new(bytes.Buffer)
+	stdout := new(bytes.Buffer)
+
+	cmd.Stderr = stderr
+	cmd.Stdout = stdout
+
+	err := cmd.Run()
+	if err != nil {
+		return "", errors.Errorf("run: %v. stderr: %s", err, stderr)
+	}
+
+	_, v, ok := strings.Cut(strings.Split(stdout.String(), "\n")[0], "db version ")
+	if !ok {
+		return "", errors.Errorf("parse version from output %s", stdout.String())
+	}
+
+	return v, nil
+}
+
+func conn(port int, tout time.Duration) (*mongo.Client, error) {
+	ctx := context.Background()
+
+	opts := options.Client().
+		SetHosts([]string{"localhost:" + strconv.Itoa(port)}).
+		SetAppName("pbm-physical-restore").
+		SetDirect(true).
+		SetConnectTimeout(time.Second * 120).
+		SetServerSelectionTimeout(tout)
+
+	conn, err := mongo.NewClient(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "create mongo client")
+	}
+
+	err = conn.Connect(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect")
+	}
+
+	err = conn.Ping(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "ping")
+	}
+
+	return conn, nil
+}
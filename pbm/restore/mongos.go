@@ -0,0 +1,283 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// physMongosFile is the mongos to check and then the check results in
+// the physical restore sync dir
+const physMongosFile = "mongos.json"
+
+// physMongosDiscoveredFile is the mongos found in the restored config.mongos
+// by the config server primary. The cluster leader may belong to another
+// replset (see pbm.RestoreCmd.LeaderRS), so the hosts are passed
+// through the storage.
+const physMongosDiscoveredFile = "discovered-mongos.json"
+
+const mongosCheckTimeout = 10 * time.Second
+
+type mongosTarget struct {
+	host string
+	opts *options.ClientOptions
+}
+
+// mongosTargets returns the mongos to check: the ones from `uris` and the
+// `discovered` hosts. The latter are connected to with the options of
+// the agent's own connection (`nodeURI`).
+func mongosTargets(uris, discovered []string, nodeURI string) []mongosTarget {
+	seen := make(map[string]struct{})
+	var rv []mongosTarget
+	add := func(host string, opts *options.ClientOptions) {
+		if _, ok := seen[host]; ok {
+			return
+		}
+		seen[host] = struct{}{}
+		rv = append(rv, mongosTarget{host: host, opts: opts})
+	}
+
+	for _, u := range uris {
+		opts := options.Client().ApplyURI(u)
+		add(strings.Join(opts.Hosts, ","), opts)
+	}
+	for _, h := range discovered {
+		// the DNS seed list URI can't be used for the direct connection
+		uri, err := pbm.HostsURI(nodeURI, h)
+		if err != nil {
+			uri = nodeURI
+		}
+		opts := options.Client().ApplyURI(uri).SetHosts([]string{h}).SetDirect(true)
+		opts.ReplicaSet = nil
+		add(h, opts)
+	}
+
+	return rv
+}
+
+// checkMongos tells if the mongos was restarted since `since` (unix time)
+// and runs flushRouterConfig on it if it wasn't and `flush` is set
+func checkMongos(ctx context.Context, t mongosTarget, since int64, flush bool) pbm.MongosCheck {
+	rv := pbm.MongosCheck{Host: t.host}
+
+	ctx, cancel := context.WithTimeout(ctx, mongosCheckTimeout)
+	defer cancel()
+
+	c, err := mongo.Connect(ctx, t.opts.SetAppName("pbm-agent").SetServerSelectionTimeout(mongosCheckTimeout))
+	if err != nil {
+		rv.Error = errors.Wrap(err, "connect").Error()
+		return rv
+	}
+	defer func() { c.Disconnect(context.Background()) }()
+
+	err = c.Ping(ctx, nil)
+	if err != nil {
+		rv.Error = errors.Wrap(err, "ping").Error()
+		return rv
+	}
+	rv.Reachable = true
+
+	var st struct {
+		Process string  `bson:"process"`
+		Uptime  float64 `bson:"uptime"`
+	}
+	err = c.Database("admin").RunCommand(ctx, bson.D{{"serverStatus", 1}}).Decode(&st)
+	if err != nil {
+		rv.Error = errors.Wrap(err, "get server status").Error()
+		return rv
+	}
+	if st.Process != "mongos" {
+		rv.Error = "not a mongos: " + st.Process
+		return rv
+	}
+	rv.UptimeSec = int64(st.Uptime)
+	rv.Restarted = time.Now().Unix()-rv.UptimeSec >= since
+
+	if !rv.Restarted && flush {
+		err = c.Database("admin").RunCommand(ctx, bson.D{{"flushRouterConfig", 1}}).Err()
+		if err != nil {
+			rv.Error = errors.Wrap(err, "flushRouterConfig").Error()
+			return rv
+		}
+		rv.Flushed = true
+	}
+
+	return rv
+}
+
+// recordMongos records the cluster's mongos to check (see
+// pbm.RestoreConf.Mongos) next to the sync files. The cluster is down by
+// now, so they're checked by CheckMongos on the cluster's start. Only
+// the hosts are recorded as the URIs may carry credentials.
+func (r *PhysRestore) recordMongos() {
+	mc := r.confOpts.Mongos
+	if mc == nil {
+		return
+	}
+
+	var discovered []string
+	if mc.Discover {
+		var err error
+		discovered, err = r.discoveredMongos()
+		if err != nil {
+			r.log.Warning("get discovered mongos: %v", err)
+		}
+	}
+	targets := mongosTargets(mc.URIs, discovered, r.node.ConnURI())
+	if len(targets) == 0 {
+		r.log.Info("no mongos to check")
+		return
+	}
+
+	res := make([]pbm.MongosCheck, 0, len(targets))
+	for _, t := range targets {
+		res = append(res, pbm.MongosCheck{Host: t.host, Pending: true})
+	}
+	err := saveMongosChecks(r.stg, r.name, res)
+	if err != nil {
+		r.log.Warning("record mongos: %v", err)
+	}
+}
+
+// CheckMongos checks the mongos recorded by the physical restores (see
+// PhysRestore.recordMongos) and saves the results to the storage and
+// the restore meta. It's advisory, so failed checks are reported but never
+// change the restore status. Recorded hosts are connected to with the
+// credentials of the configured URIs or of the agent's own connection
+// (`nodeURI`).
+func CheckMongos(cn *pbm.PBM, stg storage.Storage, nodeURI string, l *log.Event) error {
+	rr, err := cn.PendingMongosRestores()
+	if err != nil {
+		return errors.Wrap(err, "get restores")
+	}
+	if len(rr) == 0 {
+		return nil
+	}
+
+	cfg, err := cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get config")
+	}
+	var uris []string
+	flush := false
+	if mc := cfg.Restore.Mongos; mc != nil {
+		uris = mc.URIs
+		flush = mc.FlushRouterConfig
+	}
+
+	for _, meta := range rr {
+		hosts := make([]string, 0, len(meta.Mongos))
+		recorded := make(map[string]struct{}, len(meta.Mongos))
+		for _, m := range meta.Mongos {
+			hosts = append(hosts, m.Host)
+			recorded[m.Host] = struct{}{}
+		}
+
+		res := make([]pbm.MongosCheck, 0, len(hosts))
+		for _, t := range mongosTargets(uris, hosts, nodeURI) {
+			if _, ok := recorded[t.host]; !ok {
+				continue
+			}
+
+			c := checkMongos(cn.Context(), t, meta.StartTS, flush)
+			switch {
+			case c.Error != "":
+				l.Warning("restore %s: check mongos %s: %s", meta.Name, c.Host, c.Error)
+			case !c.Restarted && !c.Flushed:
+				l.Warning("restore %s: mongos %s is running since before the restore. "+
+					"Restart it to drop the stale routing table", meta.Name, c.Host)
+			default:
+				l.Info("restore %s: mongos %s: restarted %v, flushed %v", meta.Name, c.Host, c.Restarted, c.Flushed)
+			}
+			res = append(res, c)
+		}
+
+		if meta.Type == pbm.PhysicalBackup {
+			err = saveMongosChecks(stg, meta.Name, res)
+			if err != nil {
+				l.Warning("restore %s: write mongos check results: %v", meta.Name, err)
+			}
+		}
+		err = cn.SetRestoreMongos(meta.Name, res)
+		if err != nil {
+			return errors.Wrapf(err, "restore %s: set mongos check results", meta.Name)
+		}
+	}
+
+	return nil
+}
+
+// saveMongosChecks saves the mongos checks of the physical restore next to
+// its sync files
+func saveMongosChecks(stg storage.Storage, name string, m []pbm.MongosCheck) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+	return stg.Save(path.Join(pbm.PhysRestoresDir, name, physMongosFile), bytes.NewReader(b), int64(len(b)))
+}
+
+// saveDiscoveredMongos saves the mongos listed in the restored config.mongos
+// for the cluster leader
+func (r *PhysRestore) saveDiscoveredMongos(ctx context.Context, c *mongo.Client) {
+	hosts, err := mongosFromConfig(ctx, c)
+	if err != nil {
+		r.log.Warning("discover mongos: %v", err)
+		return
+	}
+
+	b, err := json.Marshal(hosts)
+	if err != nil {
+		r.log.Warning("encode discovered mongos: %v", err)
+		return
+	}
+	err = r.stg.Save(path.Join(pbm.PhysRestoresDir, r.name, physMongosDiscoveredFile), bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		r.log.Warning("write discovered mongos: %v", err)
+	}
+}
+
+// discoveredMongos returns the mongos saved by saveDiscoveredMongos
+func (r *PhysRestore) discoveredMongos() ([]string, error) {
+	b, err := pbm.ReadStatusFile(r.stg, path.Join(pbm.PhysRestoresDir, r.name, physMongosDiscoveredFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	var hosts []string
+	err = json.Unmarshal(b, &hosts)
+	return hosts, errors.Wrap(err, "decode")
+}
+
+// mongosFromConfig returns the mongos hosts listed in config.mongos
+func mongosFromConfig(ctx context.Context, c *mongo.Client) ([]string, error) {
+	cur, err := c.Database("config").Collection("mongos").Find(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.Wrap(err, "find")
+	}
+
+	var docs []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+
+	rv := make([]string, 0, len(docs))
+	for _, d := range docs {
+		rv = append(rv, d.ID)
+	}
+	return rv, nil
+}
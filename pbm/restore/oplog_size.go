@@ -0,0 +1,202 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// oplogResizeColl keeps the oplog entries while the oplog is recreated
+const oplogResizeColl = "pbmOplogResize"
+
+// oplogCopyBatch is the number of entries copied in one insert
+const oplogCopyBatch = 1000
+
+// oplogStats is the oplog's collStats
+type oplogStats struct {
+	MaxSize     int64 `bson:"maxSize"`
+	Size        int64 `bson:"size"`
+	StorageSize int64 `bson:"storageSize"`
+}
+
+func getOplogStats(ctx context.Context, c *mongo.Client) (oplogStats, error) {
+	var st oplogStats
+	err := c.Database("local").RunCommand(ctx, bson.D{{"collStats", "oplog.rs"}}).Decode(&st)
+	return st, errors.Wrap(err, "collStats")
+}
+
+// oplogMaxSize returns the max size (in bytes) of the node's oplog
+func oplogMaxSize(ctx context.Context, c *mongo.Client) (int64, error) {
+	st, err := getOplogStats(ctx, c)
+	return st.MaxSize, err
+}
+
+// diskNeeded estimates the disk space the oplog entries take once copied
+// into the oplog of the `size` max size
+func (s oplogStats) diskNeeded(size int64) int64 {
+	if s.Size > size && s.Size > 0 {
+		return int64(float64(s.StorageSize) * float64(size) / float64(s.Size))
+	}
+	return s.StorageSize
+}
+
+// restoredOplogSize returns the oplog size the restored node should end up
+// with: the requested one if any, otherwise the node's own if it's larger
+// than the backup's one. So the target pre-sized for a bigger oplog
+// doesn't revert to the source's (possibly tiny) one.
+func restoredOplogSize(sizeMB float64, target, restored int64) int64 {
+	switch {
+	case sizeMB > 0:
+		return int64(sizeMB * (1 << 20))
+	case target > restored:
+		return target
+	}
+	return restored
+}
+
+// resizeOplog sets the size of the restored oplog (see restoredOplogSize)
+// and saves the outcome into the node's sync file. mongod has to run
+// as standalone.
+func (r *PhysRestore) resizeOplog(ctx context.Context, c *mongo.Client) error {
+	ost, err := getOplogStats(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "get restored oplog size")
+	}
+	restored := ost.MaxSize
+
+	st := pbm.RestoreOplog{
+		TargetSize: r.targetOplogSize,
+		BackupSize: restored,
+		Size:       restored,
+	}
+	if size := restoredOplogSize(r.oplogSizeMB, r.targetOplogSize, restored); size != restored {
+		err = checkOplogSpace(r.dbpath, ost.diskNeeded(size))
+		if err != nil {
+			r.log.Warning("skip the oplog resize from %d MB to %d MB: %v. "+
+				"Resize it with `replSetResizeOplog` once the node is started",
+				restored>>20, size>>20, err)
+		} else {
+			r.log.Info("resizing the oplog from %d MB to %d MB", restored>>20, size>>20)
+			err = recreateOplog(ctx, c, size)
+			if err != nil {
+				return errors.Wrap(err, "resize oplog")
+			}
+			st.Size = size
+		}
+	}
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		r.log.Warning("encode oplog size: %v", err)
+	} else if err = r.stg.Save(r.syncPathNodeOplog, bytes.NewReader(b), int64(len(b))); err != nil {
+		r.log.Warning("write oplog size: %v", err)
+	}
+
+	return nil
+}
+
+// checkOplogSpace fails if the dbpath has less than `need` bytes free
+// for the copy of the oplog entries. The check is skipped where the free
+// space can't be figured out.
+func checkOplogSpace(dbpath string, need int64) error {
+	free, err := pbm.FreeSpace(dbpath)
+	if errors.Is(err, pbm.ErrFreeSpaceUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "get free space of %s", dbpath)
+	}
+	if free < need {
+		return errors.Errorf("%s has %d MB free, %d MB needed for the oplog copy", dbpath, free>>20, need>>20)
+	}
+	return nil
+}
+
+// recreateOplog recreates the oplog with the new size keeping its entries.
+// That's the way to resize the oplog of a standalone mongod as
+// `replSetResizeOplog` runs only on replset members. The oldest entries
+// that don't fit into a smaller oplog are dropped. The entries are copied
+// into the new capped collection first, which then replaces the oplog.
+// So the original oplog is intact until the new one is fully populated.
+func recreateOplog(ctx context.Context, c *mongo.Client, size int64) error {
+	local := c.Database("local")
+	tmp := local.Collection(oplogResizeColl)
+
+	// leftovers of a failed run
+	err := tmp.Drop(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "drop %s", oplogResizeColl)
+	}
+	err = local.RunCommand(ctx, bson.D{
+		{"create", oplogResizeColl},
+		{"capped", true},
+		{"size", size},
+	}).Err()
+	if err != nil {
+		return errors.Wrap(err, "create new oplog")
+	}
+
+	// the original oplog is left as is on failure
+	dropTmp := func(err error) error {
+		if derr := tmp.Drop(ctx); derr != nil {
+			return errors.Wrapf(err, "drop %s: %v", oplogResizeColl, derr)
+		}
+		return err
+	}
+
+	err = copyColl(ctx, local.Collection("oplog.rs"), tmp)
+	if err != nil {
+		return dropTmp(errors.Wrap(err, "copy oplog entries"))
+	}
+
+	// the oplog can be renamed into while not replicating
+	err = c.Database("admin").RunCommand(ctx, bson.D{
+		{"renameCollection", "local." + oplogResizeColl},
+		{"to", "local.oplog.rs"},
+		{"dropTarget", true},
+	}).Err()
+	if err != nil {
+		return dropTmp(errors.Wrap(err, "replace oplog"))
+	}
+
+	return nil
+}
+
+// copyColl copies the documents in the natural order
+func copyColl(ctx context.Context, from, to *mongo.Collection) error {
+	cur, err := from.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{"$natural", 1}}))
+	if err != nil {
+		return errors.Wrap(err, "find")
+	}
+	defer cur.Close(ctx)
+
+	batch := make([]interface{}, 0, oplogCopyBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := to.InsertMany(ctx, batch)
+		batch = batch[:0]
+		return errors.Wrap(err, "insert")
+	}
+	for cur.Next(ctx) {
+		batch = append(batch, bson.Raw(append([]byte(nil), cur.Current...)))
+		if len(batch) == oplogCopyBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return errors.Wrap(err, "cursor")
+	}
+
+	return flush()
+}
@@ -0,0 +1,37 @@
+package restore
+
+import "testing"
+
+func TestRestoredOplogSize(t *testing.T) {
+	const mb = 1 << 20
+
+	cases := []struct {
+		name     string
+		sizeMB   float64
+		target   int64
+		restored int64
+		want     int64
+	}{
+		{"keep larger target", 0, 5000 * mb, 990 * mb, 5000 * mb},
+		{"smaller target", 0, 990 * mb, 5000 * mb, 5000 * mb},
+		{"unknown target", 0, 0, 990 * mb, 990 * mb},
+		{"requested", 2048, 5000 * mb, 990 * mb, 2048 * mb},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := restoredOplogSize(c.sizeMB, c.target, c.restored); got != c.want {
+				t.Errorf("got %d, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestOplogDiskNeeded(t *testing.T) {
+	st := oplogStats{MaxSize: 1000, Size: 800, StorageSize: 400}
+	if got := st.diskNeeded(2000); got != 400 {
+		t.Errorf("larger oplog: got %d, want 400", got)
+	}
+	if got := st.diskNeeded(200); got != 100 {
+		t.Errorf("smaller oplog: got %d, want 100", got)
+	}
+}
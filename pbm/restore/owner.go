@@ -0,0 +1,130 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// dataOwner is the owner the restored files are given
+type dataOwner struct {
+	uid int
+	gid int
+}
+
+func (o *dataOwner) String() string {
+	return fmt.Sprintf("%d:%d", o.uid, o.gid)
+}
+
+// resolveOwner returns the owner of the restored files according to
+// RestoreConf.DataOwner. "auto" is the owner of the dbpath. Nil means the
+// files are left as created by the agent (that's the agent's user).
+func resolveOwner(conf, dbpath string) (*dataOwner, error) {
+	var o *dataOwner
+	switch conf {
+	case "":
+		return nil, nil
+	case pbm.DataOwnerAuto:
+		fi, err := os.Stat(dbpath)
+		if err != nil {
+			return nil, errors.Wrap(err, "stat dbpath")
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil, errors.New("dbpath owner is unknown on this platform")
+		}
+		o = &dataOwner{uid: int(st.Uid), gid: int(st.Gid)}
+	default:
+		var err error
+		o, err = lookupOwner(conf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.uid == os.Geteuid() && o.gid == os.Getegid() {
+		return nil, nil
+	}
+	return o, nil
+}
+
+// lookupOwner parses "user[:group]" where both can be either names or ids.
+// The user's primary group is used if the group is omitted.
+func lookupOwner(s string) (*dataOwner, error) {
+	un, gn, hasGroup := strings.Cut(s, ":")
+
+	u, err := user.Lookup(un)
+	if err != nil {
+		u, err = user.LookupId(un)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "lookup user %q", un)
+	}
+	o := &dataOwner{}
+	o.uid, _ = strconv.Atoi(u.Uid)
+	o.gid, _ = strconv.Atoi(u.Gid)
+
+	if hasGroup {
+		g, err := user.LookupGroup(gn)
+		if err != nil {
+			g, err = user.LookupGroupId(gn)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "lookup group %q", gn)
+		}
+		o.gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	return o, nil
+}
+
+// checkChown checks that the agent is able to give files in the dir
+// to the owner
+func checkChown(dir string, o *dataOwner) error {
+	f, err := os.CreateTemp(dir, ".pbm-chown-probe-")
+	if err != nil {
+		return errors.Wrap(err, "create probe file")
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	err = os.Chown(f.Name(), o.uid, o.gid)
+	if errors.Is(err, os.ErrPermission) {
+		return errors.Errorf("the agent is not permitted to change the files owner to %s. "+
+			"It needs to run as root or have the CAP_CHOWN capability", o)
+	}
+	return errors.Wrap(err, "chown probe file")
+}
+
+// chownTree gives the dbpath with everything in it to the owner. Entries of
+// the dbpath root matching `ignore` are left intact.
+func chownTree(dbpath string, ignore []string, o *dataOwner) error {
+	return walkRestored(dbpath, ignore, func(p string, _ os.FileInfo) error {
+		return errors.Wrapf(os.Lchown(p, o.uid, o.gid), "chown %s", p)
+	})
+}
+
+// walkRestored calls fn for the dbpath and everything in it except the
+// dbpath root entries matching `ignore`
+func walkRestored(dbpath string, ignore []string, fn func(p string, fi os.FileInfo) error) error {
+	return filepath.Walk(dbpath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if filepath.Dir(p) == filepath.Clean(dbpath) && matchAny(fi.Name(), ignore) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(p, fi)
+	})
+}
@@ -0,0 +1,58 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestResolveOwner(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, c := range []string{"", "auto", fmt.Sprintf("%d:%d", os.Geteuid(), os.Getegid())} {
+		o, err := resolveOwner(c, dir)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", c, err)
+		}
+		// the agent's own user needs no chown
+		if o != nil {
+			t.Errorf("%q: expected no owner change, got %s", c, o)
+		}
+	}
+
+	o, err := lookupOwner(fmt.Sprintf("%d", os.Geteuid()))
+	if err != nil {
+		t.Fatalf("lookup by uid: %v", err)
+	}
+	if o.uid != os.Geteuid() {
+		t.Errorf("expected uid %d, got %d", os.Geteuid(), o.uid)
+	}
+
+	if _, err = resolveOwner("pbm-no-such-user", dir); err == nil {
+		t.Error("expected error on unknown user")
+	}
+	if _, err = resolveOwner(fmt.Sprintf("%d:pbm-no-such-group", os.Geteuid()), dir); err == nil {
+		t.Error("expected error on unknown group")
+	}
+}
+
+func TestChownTree(t *testing.T) {
+	dir := mkDBPath(t,
+		"lost+found/",
+		"WiredTiger",
+		"journal/WiredTigerLog.0000000001",
+		"db1/collection-1.wt",
+	)
+	o := &dataOwner{uid: os.Geteuid(), gid: os.Getegid()}
+
+	if err := checkChown(dir, o); err != nil {
+		t.Fatalf("check chown: %v", err)
+	}
+	if err := chownTree(dir, DefaultDBPathIgnore, o); err != nil {
+		t.Fatalf("chown tree: %v", err)
+	}
+	// the probe file is removed
+	if got := lsDBPath(t, dir); len(got) != 6 {
+		t.Errorf("unexpected dbpath content %v", got)
+	}
+}
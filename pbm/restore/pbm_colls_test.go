@@ -0,0 +1,45 @@
+package restore
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+func TestPBMCollFilter(t *testing.T) {
+	all := func(string) bool { return true }
+	r := &Restore{log: log.New(nil, "", "").NewEvent("", "", "", primitive.Timestamp{})}
+
+	cases := []struct {
+		ns        string
+		data      bool
+		withState bool
+	}{
+		{"db.coll", true, true},
+		{"admin.system.users", true, true},
+		{pbm.DB + "." + pbm.TmpUsersCollection, true, true},
+		{pbm.DB + "." + pbm.ConfigCollection, false, true},
+		{pbm.DB + "." + pbm.BcpCollection, false, true},
+		{pbm.DB + "." + pbm.PITRChunksCollection, false, true},
+		{pbm.DB + "." + pbm.LockCollection, false, false},
+		{pbm.DB + "." + pbm.RestoresCollection, false, false},
+		{pbm.DB + "." + pbm.AgentsStatusCollection, false, false},
+	}
+
+	for _, state := range []bool{false, true} {
+		r.pbmState = state
+		f := r.pbmCollFilter(all)
+		for _, c := range cases {
+			want := c.data
+			if state {
+				want = c.withState
+			}
+			if got := f(c.ns); got != want {
+				t.Errorf("pbmState %v, %s: got %v, want %v", state, c.ns, got, want)
+			}
+		}
+	}
+}
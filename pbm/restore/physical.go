@@ -6,14 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	slog "log"
-	"math/rand"
-	"net"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,8 +26,10 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/retry"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+	"github.com/percona/percona-backup-mongodb/pbm/tracing"
 	"github.com/percona/percona-backup-mongodb/version"
 )
 
@@ -48,6 +46,8 @@ type files struct {
 	BcpName string
 	Cmpr    compress.CompressionType
 	Data    []pbm.File
+	// layout of the backup's objects on the storage
+	layout *pbm.BackupLayout
 
 	// dbpath to cut from destination if there is any (see PBM-1058)
 	dbpath string
@@ -57,6 +57,8 @@ type PhysRestore struct {
 	cn     *pbm.PBM
 	node   *pbm.Node
 	dbpath string
+	// port of the mongod the agent is bound to
+	port int
 	// an ephemeral port to restart mongod on during the restore
 	tmpPort int
 	tmpConf *os.File
@@ -65,6 +67,9 @@ type PhysRestore struct {
 	cfgConn string            // shardIdentity configsvrConnectionString
 	startTS int64
 	secOpts *pbm.MongodOptsSec
+	// the restore is aborted if it didn't converge before the deadline
+	// (see pbm.RestoreConf.MaxRestoreDurationMin). Zero means no limit.
+	deadline time.Time
 
 	name     string
 	opid     string
@@ -74,8 +79,25 @@ type PhysRestore struct {
 	files    []files
 
 	confOpts pbm.RestoreConf
+	notify   pbm.NotifyConf
+	// stgConf is the config of stg (see pbm.RestoreStorageConf)
+	stgConf pbm.StorageConf
+	// bcpStg is the storage the backup files are read from
+	// (see pbm.BackupStorage). The sync files go to stg.
+	bcpStg storage.Storage
+	// owner of the restored files, nil if it's left to the agent's user
+	owner *dataOwner
+	// SELinux relabeling of the restored files, nil if it's not needed
+	relabel *selinuxRelabel
+	// cleanups of the artifacts created during the restore (tmp files,
+	// host locks etc.), run when the restore exits
+	cleanup cleanupRegistry
 
 	mongod string // location of mongod used for internal restarts
+	runner MongodRunner
+
+	// restore despite the backup platform is known to be incompatible
+	allowPlatformMismatch bool
 
 	// path to files on a storage the node will sync its
 	// state with the resto of the cluster
@@ -84,20 +106,71 @@ type PhysRestore struct {
 	syncPathRS       string
 	syncPathCluster  string
 	syncPathPeers    map[string]struct{}
+	// Voting members among the peers (see pbm.PartlyDoneQuorum)
+	syncPathVoters map[string]struct{}
 	// Shards to participate in restore.
 	// Only the restore leader would have this info.
 	syncPathShards map[string]struct{}
-	// Non-ConfigServer shards
+	// Shards the leader replset waits to shut down before its own
+	// nodes do (non-ConfigServer shards by default)
 	syncPathDataShards map[string]struct{}
+	// Restore gate written by the canary replset (see RestoreConf.CanaryShard)
+	syncPathCanary string
+	// Post-restore collections validation results of the node
+	syncPathNodeValidate string
+	// The tmp port of the node's internal mongod runs
+	syncPathNodePort string
+	// The oplog size the node ends up with (see pbm.RestoreOplog)
+	syncPathNodeOplog string
+	// The files the restore wipes and writes on the node
+	// (see pbm.RestoreFiles)
+	syncPathNodeFiles string
+	// The node's heartbeat object (see pbm.PhysRestoreBeat)
+	syncPathBeat string
+
+	// storages to fall back to for backup files the restore storage
+	// fails to serve (see pbm.RestoreS3Conf.ReadEndpoints)
+	readFallbacks []fallbackStorage
+	// name of the restore storage endpoint if there are fallbacks
+	readPrimary string
+
+	// when the node's heartbeat was written last
+	lastHB time.Time
+	// the last fresh heartbeats seen of the sync objects
+	hbSeen map[string]int64
 
 	stopHB chan struct{}
 
 	log *log.Event
 
 	rsMap map[string]string
+	// replset whose primary is the cluster leader of the restore (see
+	// pbm.RestoreCmd.LeaderRS). Empty means the config server primary.
+	leaderRS string
+	// outcome of the replset with failed nodes (see pbm.PartlyDonePolicy)
+	partlyDone pbm.PartlyDonePolicy
+	// generate a new replicaSetId (see pbm.RestoreCmd.NewReplsetID)
+	newReplsetID bool
+	// the oplog is truncated after it: the backup point or, for
+	// the snapshot-only restore, the replset's checkpoint
+	recoveryTS primitive.Timestamp
+	// the oplog size requested for the restored node
+	// (see pbm.RestoreCmd.OplogSizeMB)
+	oplogSizeMB float64
+	// the node's oplog size before the restore
+	targetOplogSize int64
+
+	// the node's phase and copy progress reported in the heartbeats
+	progress phaseProgress
+	// the cluster leader's estimate of the nodes' progress
+	progressTrack *progressTracker
+
+	// nil unless the tracing is configured (see pbm.Config.Tracing)
+	tracer *tracing.Tracer
+	span   *tracing.Span
 }
 
-func NewPhysical(cn *pbm.PBM, node *pbm.Node, inf *pbm.NodeInfo, rsMap map[string]string) (*PhysRestore, error) {
+func NewPhysical(cn *pbm.PBM, node *pbm.Node, inf *pbm.NodeInfo, rsMap map[string]string, runner MongodRunner) (*PhysRestore, error) {
 	opts, err := node.GetOpts(nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "get mongo options")
@@ -146,94 +219,82 @@ func NewPhysical(cn *pbm.PBM, node *pbm.Node, inf *pbm.NodeInfo, rsMap map[strin
 		return nil, errors.New("undefined replica set")
 	}
 
-	tmpPort, err := peekTmpPort(opts.Net.Port)
-	if err != nil {
-		return nil, errors.Wrap(err, "peek tmp port")
-	}
-
 	return &PhysRestore{
 		cn:       cn,
 		node:     node,
 		dbpath:   p,
+		port:     opts.Net.Port,
 		rsConf:   rcf,
 		shards:   shards,
 		cfgConn:  csvr,
 		nodeInfo: inf,
-		tmpPort:  tmpPort,
 		secOpts:  opts.Security,
 		rsMap:    rsMap,
+		runner:   runner,
 	}, nil
 }
 
-// peeks a random free port in a range [minPort, maxPort]
-func peekTmpPort(current int) (int, error) {
-	const (
-		rng = 1111
-		try = 150
-	)
-
-	rand.Seed(time.Now().UnixNano())
+const defaultTmpPortRange = 1111
 
-	for i := 0; i < try; i++ {
-		p := current + rand.Intn(rng) + 1
-		ln, err := net.Listen("tcp", ":"+strconv.Itoa(p))
-		if err == nil {
-			ln.Close()
-			return p, nil
-		}
+// tmpPortRange returns the range of ports to peek the tmp port from.
+// Node's range (restore.tmpPortRangeMap) takes precedence over
+// the global one (restore.tmpPortRange). If none is set, it is the
+// defaultTmpPortRange ports right above the current mongod port.
+func (r *PhysRestore) tmpPortRange() (from, to int, err error) {
+	rng := r.confOpts.TmpPortRange
+	if m, ok := r.confOpts.TmpPortRangeMap[r.nodeInfo.Me]; ok {
+		rng = m
+	}
+	if rng == "" {
+		return r.port + 1, r.port + defaultTmpPortRange, nil
 	}
 
-	return -1, errors.Errorf("can't find unused port in range [%d, %d]", current, current+rng)
+	return pbm.ParsePortRange(rng)
 }
 
 // Close releases object resources.
 // Should be run to avoid leaks.
-func (r *PhysRestore) close(noerr, cleanup bool) {
-	if r.tmpConf != nil {
-		r.log.Debug("rm tmp conf")
-		err := os.Remove(r.tmpConf.Name())
-		if err != nil {
-			r.log.Error("remove tmp config %s: %v", r.tmpConf.Name(), err)
-		}
-	}
-	// clean-up internal mongod log only if there is no error
-	if noerr {
-		r.log.Debug("rm tmp logs")
-		err := os.Remove(path.Join(r.dbpath, internalMongodLog))
-		if err != nil {
-			r.log.Error("remove tmp mongod logs %s: %v", path.Join(r.dbpath, internalMongodLog), err)
-		}
-	} else if cleanup { // clean-up dbpath on err if needed
-		r.log.Debug("clean-up dbpath")
-		err := removeAll(r.dbpath, r.log)
-		if err != nil {
-			r.log.Error("flush dbpath %s: %v", r.dbpath, err)
-		}
+func (r *PhysRestore) close(noerr bool) {
+	r.cleanup.run(noerr, r.log)
+}
+
+// removeInternalLog removes the log of internal mongod runs or uploads it
+// to the storage first if it's asked to be kept
+func (r *PhysRestore) removeInternalLog() error {
+	if r.confOpts.AlwaysKeepInternalMongodLog {
+		r.uploadInternalLog()
+		return nil
 	}
-	if r.stopHB != nil {
-		close(r.stopHB)
+
+	err := os.Remove(r.internalLogPath())
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove tmp mongod logs %s", r.internalLogPath())
 	}
+	return nil
 }
 
-func (r *PhysRestore) flush() error {
+// flush shuts the node down and wipes its data (see wipeDBpath)
+func (r *PhysRestore) flush() (keepData func(), err error) {
 	r.log.Debug("shutdown server")
 	rsStat, err := r.node.GetReplsetStatus()
 	if err != nil {
-		return errors.Wrap(err, "get replset status")
+		return nil, errors.Wrap(err, "get replset status")
 	}
 
-	if r.nodeInfo.IsConfigSrv() {
+	// Data shards won't go down until the canary has finished. So there is
+	// nothing to wait for if the leader replset is the canary.
+	if r.inLeaderRS() && len(r.syncPathDataShards) != 0 && !r.isCanary() {
 		r.log.Debug("waiting for shards to shutdown")
 		_, err := r.waitFiles(pbm.StatusDown, r.syncPathDataShards, false)
 		if err != nil {
-			return errors.Wrap(err, "wait for datashards to shutdown")
+			return nil, errors.Wrap(err, "wait for datashards to shutdown")
 		}
 	}
 
 	for {
 		inf, err := r.node.GetInfo()
 		if err != nil {
-			return errors.Wrap(err, "get node info")
+			return nil, errors.Wrap(err, "get node info")
 		}
 		// single-node replica set won't stepdown do secondary
 		// so we have to shut it down despite of role
@@ -242,7 +303,7 @@ func (r *PhysRestore) flush() error {
 			if err != nil &&
 				strings.Contains(err.Error(), // wait a bit and let the node to stepdown
 					"(ConflictingOperationInProgress) This node is already in the process of stepping down") {
-				return errors.Wrap(err, "shutdown server")
+				return nil, errors.Wrap(err, "shutdown server")
 			}
 			break
 		}
@@ -253,24 +314,37 @@ func (r *PhysRestore) flush() error {
 	r.log.Debug("waiting for the node to shutdown")
 	err = waitMgoShutdown(r.dbpath)
 	if err != nil {
-		return errors.Wrap(err, "shutdown")
+		return nil, errors.Wrap(err, "shutdown")
 	}
 
 	if r.nodeInfo.IsPrimary {
 		err = r.stg.Save(r.syncPathRS+"."+string(pbm.StatusDown),
 			okStatus(), -1)
 		if err != nil {
-			return errors.Wrap(err, "write replset StatusDown")
+			return nil, errors.Wrap(err, "write replset StatusDown")
 		}
 	}
 
+	return r.wipeDBpath()
+}
+
+// wipeDBpath records what's about to be wiped (see saveFiles) and removes
+// the node's data. The data is gone from now on, so the dbpath is cleaned
+// up on error. Once the node is restored, keepData makes the data stay
+// whatever happens.
+func (r *PhysRestore) wipeDBpath() (keepData func(), err error) {
+	keepFiles := r.saveFiles()
+
 	r.log.Debug("revome old data")
-	err = removeAll(r.dbpath, r.log)
+	err = removeAll(r.dbpath, r.dbpathIgnore(), r.log)
 	if err != nil {
-		return errors.Wrapf(err, "flush dbpath %s", r.dbpath)
+		return nil, errors.Wrapf(err, "flush dbpath %s", r.dbpath)
 	}
+	keepFiles()
 
-	return nil
+	return r.cleanup.add("dbpath", cleanupOnError, func() error {
+		return errors.Wrapf(removeAll(r.dbpath, r.dbpathIgnore(), r.log), "flush dbpath %s", r.dbpath)
+	}), nil
 }
 
 func waitMgoShutdown(dbpath string) error {
@@ -302,7 +376,8 @@ func waitMgoShutdown(dbpath string) error {
 //	  - Each node writes a file with the given state.
 //	  - The replset leader (primary node) or every rs node, in case of status
 //	    "done",  waits for files from all replica set nodes. And writes a status
-//	    file for the replica set.
+//	    file for the replica set. Only the node that wins the claim (see
+//	    writeLeaderStatus) writes it, except for "done" written by every node.
 //	  - The cluster leader (primary node - on config server in case of sharded) or
 //	    every node, in case of status "done",  waits for status files from all
 //	    replica sets. And sets the status file for the cluster.
@@ -313,47 +388,50 @@ func waitMgoShutdown(dbpath string) error {
 //
 //		.pbm.restore/<restore-name>
 //			rs.<rs-name>/
-//				node.<node-name>.hb			// hearbeats. last beat ts inside.
+//				beat.<node-name>			// node's heartbeat (see pbm.PhysRestoreBeat). Older agents write separate node, rs and cluster .hb files with the last beat ts inside instead.
 //				node.<node-name>.<status>	// node's PBM status. Inside is the ts of the transition. In case of error, file contains an error text.
 //				rs.<status>					// replicaset's PBM status. Inside is the ts of the transition. In case of error, file contains an error text.
-//			cluster.hb						// hearbeats. last beat ts inside.
 //			cluster.<status>				// cluster's PBM status. Inside is the ts of the transition. In case of error, file contains an error text.
+//			canary.<rs-name>.<status>		// canary replset outcome, if set (see RestoreConf.CanaryShard). Other replsets won't wipe data until it is "done".
+//			leader/							// claims of the rs and cluster status writes. Inside is the name of the node that won the claim.
 //
 //	 For example:
 //
 //	     2022-08-02T18:50:35.1889332Z
 //	     ├── cluster.done
-//	     ├── cluster.hb
 //	     ├── cluster.running
 //	     ├── cluster.starting
 //	     ├── rs.rs1
 //	     │   ├── node.rs101:27017.done
-//	     │   ├── node.rs101:27017.hb
 //	     │   ├── node.rs101:27017.running
 //	     │   ├── node.rs101:27017.starting
 //	     │   ├── node.rs102:27017.done
-//	     │   ├── node.rs102:27017.hb
 //	     │   ├── node.rs102:27017.running
 //	     │   ├── node.rs102:27017.starting
 //	     │   ├── node.rs103:27017.done
-//	     │   ├── node.rs103:27017.hb
 //	     │   ├── node.rs103:27017.running
 //	     │   ├── node.rs103:27017.starting
+//	     │   ├── beat.rs101:27017
+//	     │   ├── beat.rs102:27017
+//	     │   ├── beat.rs103:27017
 //	     │   ├── rs.done
-//	     │   ├── rs.hb
 //	     │   ├── rs.running
 //	     │   └── rs.starting
 func (r *PhysRestore) toState(status pbm.Status) (rStatus pbm.Status, err error) {
+	// replsets rejected by the partlyDone policy fail the cluster even
+	// on the `done` step
+	var rejected bool
 	defer func() {
 		if err != nil {
-			if r.nodeInfo.IsPrimary && status != pbm.StatusDone {
+			rejected = rejected || errors.Is(err, errPartlyDoneRejected)
+			if r.nodeInfo.IsPrimary && (status != pbm.StatusDone || rejected) {
 				serr := r.stg.Save(r.syncPathRS+"."+string(pbm.StatusError),
 					errStatus(err), -1)
 				if serr != nil {
 					r.log.Error("toState: write replset error state `%v`: %v", err, serr)
 				}
 			}
-			if r.nodeInfo.IsClusterLeader() && status != pbm.StatusDone {
+			if r.isClusterLeader() && (status != pbm.StatusDone || rejected) {
 				serr := r.stg.Save(r.syncPathCluster+"."+string(pbm.StatusError),
 					errStatus(err), -1)
 				if serr != nil {
@@ -378,24 +456,22 @@ func (r *PhysRestore) toState(status pbm.Status) (rStatus pbm.Status, err error)
 			return pbm.StatusError, errors.Wrap(err, "wait for nodes in rs")
 		}
 
-		err = r.stg.Save(r.syncPathRS+"."+string(cstat),
-			okStatus(), -1)
+		err = r.writeLeaderStatus(r.syncPathRS, status, cstat)
 		if err != nil {
 			return pbm.StatusError, errors.Wrap(err, "write replset state")
 		}
 	}
 
-	if r.nodeInfo.IsClusterLeader() || status == pbm.StatusDone {
+	if r.isClusterLeader() || status == pbm.StatusDone {
 		r.log.Info("waiting for shards %v", r.syncPathShards)
 		cstat, err := r.waitFiles(status, copyMap(r.syncPathShards), true)
 		if err != nil {
 			return pbm.StatusError, errors.Wrap(err, "wait for shards")
 		}
 
-		err = r.stg.Save(r.syncPathCluster+"."+string(cstat),
-			okStatus(), -1)
+		err = r.writeLeaderStatus(r.syncPathCluster, status, cstat)
 		if err != nil {
-			return pbm.StatusError, errors.Wrap(err, "write replset state")
+			return pbm.StatusError, errors.Wrap(err, "write cluster state")
 		}
 	}
 
@@ -410,6 +486,54 @@ func (r *PhysRestore) toState(status pbm.Status) (rStatus pbm.Status, err error)
 	return cstat, nil
 }
 
+const syncLeaderDir = "leader"
+
+// writeLeaderStatus writes the replset or cluster level status file
+// (`target` is syncPathRS or syncPathCluster) of the `status` step. Only
+// one node is allowed to write it, even if several nodes consider themselves
+// a leader (e.g. two primaries racing on stepdown mid-restore). The node
+// that lost the claim leaves the status to the winner.
+//
+// The `done` status isn't claimed: every node writes it, so the restore
+// doesn't hang on a claim owner that died before writing it.
+func (r *PhysRestore) writeLeaderStatus(target string, status, cstat pbm.Status) error {
+	if status == pbm.StatusDone {
+		return r.stg.Save(target+"."+string(cstat), okStatus(), -1)
+	}
+
+	ok, err := r.claimStatus(target, status)
+	if err != nil {
+		return errors.Wrap(err, "claim status")
+	}
+	if !ok {
+		r.log.Info("`%s` status of %s has been claimed by another node, deferring to it", status, target)
+		return nil
+	}
+
+	return r.stg.Save(target+"."+string(cstat), okStatus(), -1)
+}
+
+// claimStatus saves the claim object for the status step, e.g.
+// `<restore>/leader/rs.rs1.rs.running` for the replset `running` status.
+// It returns false if the claim is already taken. Storages without
+// conditional writes fall back to the (racy) check-and-write.
+func (r *PhysRestore) claimStatus(target string, status pbm.Status) (bool, error) {
+	dir := path.Join(pbm.PhysRestoresDir, r.name)
+	name := strings.ReplaceAll(strings.TrimPrefix(target, dir+"/"), "/", ".")
+
+	err := storage.SaveIfNotExists(r.stg,
+		path.Join(dir, syncLeaderDir, name+"."+string(status)),
+		strings.NewReader(r.nodeInfo.Me), -1)
+	if errors.Is(err, storage.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func errStatus(err error) io.Reader {
 	return bytes.NewReader([]byte(
 		fmt.Sprintf("%d:%v", time.Now().Unix(), err),
@@ -431,6 +555,12 @@ func (n nodeErr) Error() string {
 	return fmt.Sprintf("%s failed: %s", n.node, n.msg)
 }
 
+// Is tells the replset rejected by the partlyDone policy by its error file,
+// so the rejection fails the cluster as well
+func (n nodeErr) Is(target error) bool {
+	return target == errPartlyDoneRejected && strings.Contains(n.msg, errPartlyDoneRejected.Error())
+}
+
 func copyMap[K comparable, V any](m map[K]V) map[K]V {
 	cp := make(map[K]V)
 	for k, v := range m {
@@ -445,29 +575,27 @@ func (r *PhysRestore) waitFiles(status pbm.Status, objs map[string]struct{}, clu
 		return pbm.StatusError, errors.New("empty objects maps")
 	}
 
-	tk := time.NewTicker(time.Second * 5)
-	defer tk.Stop()
-
 	retStatus = status
 
 	var curErr error
-	var haveDone bool
-	for range tk.C {
+	// the objects that reached the status
+	done := make(map[string]struct{})
+	for {
+		time.Sleep(retry.Jitter(syncPollInterval))
+
+		err = r.checkDeadline()
+		if err != nil {
+			return pbm.StatusError, err
+		}
+
 		for f := range objs {
-			errFile := f + "." + string(pbm.StatusError)
-			_, err = r.stg.FileStat(errFile)
-			if err != nil && err != storage.ErrNotExist {
-				return pbm.StatusError, errors.Wrapf(err, "get file %s", errFile)
+			errFile, err := pbm.FindStatusFile(r.stg, f+"."+string(pbm.StatusError))
+			if err != nil && !errors.Is(err, storage.ErrNotExist) {
+				return pbm.StatusError, errors.Wrapf(err, "get file %s", f+"."+string(pbm.StatusError))
 			}
 
 			if err == nil {
-				r, err := r.stg.SourceReader(errFile)
-				if err != nil {
-					return pbm.StatusError, errors.Wrapf(err, "open error file %s", errFile)
-				}
-
-				b, err := io.ReadAll(r)
-				r.Close()
+				b, err := pbm.ReadStatusFile(r.stg, errFile)
 				if err != nil {
 					return pbm.StatusError, errors.Wrapf(err, "read error file %s", errFile)
 				}
@@ -479,9 +607,9 @@ func (r *PhysRestore) waitFiles(status pbm.Status, objs map[string]struct{}, clu
 				continue
 			}
 
-			err := r.checkHB(f + "." + syncHbSuffix)
+			err = r.checkHB(f)
 			if err != nil {
-				curErr = errors.Wrapf(err, "check heartbeat in %s.%s", f, syncHbSuffix)
+				curErr = errors.Wrapf(err, "check heartbeat of %s", f)
 				if status != pbm.StatusDone {
 					return pbm.StatusError, curErr
 				}
@@ -510,7 +638,7 @@ func (r *PhysRestore) waitFiles(status pbm.Status, objs map[string]struct{}, clu
 				retStatus = pbm.StatusPartlyDone
 			}
 
-			haveDone = true
+			done[f] = struct{}{}
 			delete(objs, f)
 		}
 
@@ -519,25 +647,50 @@ func (r *PhysRestore) waitFiles(status pbm.Status, objs map[string]struct{}, clu
 				return retStatus, nil
 			}
 
-			if haveDone && !cluster {
-				return pbm.StatusPartlyDone, nil
+			if len(done) != 0 && !cluster {
+				if r.partlyDoneOK(done) {
+					return pbm.StatusPartlyDone, nil
+				}
+				return pbm.StatusError, errors.Wrapf(errPartlyDoneRejected, "%s policy: %v", r.partlyDone, curErr)
 			}
 
 			return pbm.StatusError, curErr
 		}
 	}
+}
+
+// errPartlyDoneRejected means the replset failed by the partlyDone policy
+// while some of its nodes succeeded
+var errPartlyDoneRejected = errors.New("rejected by the partlyDone policy")
+
+// partlyDoneOK tells if the replset where only the `done` nodes succeeded
+// is partlyDone by the policy
+func (r *PhysRestore) partlyDoneOK(done map[string]struct{}) bool {
+	switch r.partlyDone {
+	case pbm.PartlyDoneStrict:
+		return false
+	case pbm.PartlyDoneQuorum:
+		n := 0
+		for f := range done {
+			if _, ok := r.syncPathVoters[f]; ok {
+				n++
+			}
+		}
+		return n > len(r.syncPathVoters)/2
+	}
 
-	return pbm.StatusError, storage.ErrNotExist
+	return true
 }
 
+// checkFile tells if there is the non-empty sync file `f`, plain or
+// compressed (see pbm.FindStatusFile)
 func checkFile(f string, stg storage.Storage) (ok bool, err error) {
-	_, err = stg.FileStat(f)
-
+	_, err = pbm.FindStatusFile(stg, f)
 	if err == nil {
 		return true, nil
 	}
 
-	if err == storage.ErrNotExist || err == storage.ErrEmpty {
+	if errors.Is(err, storage.ErrNotExist) {
 		return false, nil
 	}
 
@@ -617,8 +770,6 @@ func (l *logBuff) Flush() error {
 //   - Cleans up data and resets replicaset config to the working state.
 //   - Shuts down mongod and agent (the leader also dumps metadata to the storage).
 func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event, stopAgentC chan<- struct{}, pauseHB func()) (err error) {
-	l.Debug("port: %d", r.tmpPort)
-
 	meta := &pbm.RestoreMeta{
 		Type:     pbm.PhysicalBackup,
 		OPID:     opid.String(),
@@ -627,9 +778,7 @@ func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event,
 		StartTS:  time.Now().Unix(),
 		Status:   pbm.StatusInit,
 		Replsets: []pbm.RestoreReplset{{Name: r.nodeInfo.Me}},
-	}
-	if r.nodeInfo.IsClusterLeader() {
-		meta.Leader = r.nodeInfo.Me + "/" + r.rsConf.ID
+		Store:    &r.stgConf,
 	}
 
 	var progress nodeStatus
@@ -639,19 +788,105 @@ func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event,
 		if err != nil && !progress.is(restoreDone) && !errors.Is(err, ErrNoDataForShard) {
 			r.MarkFailed(meta, err, !progress.is(restoreStared))
 		}
+		if !errors.Is(err, ErrNoDataForShard) {
+			r.report(meta)
+		}
 
-		r.close(err == nil, progress.is(restoreStared) && !progress.is(restoreDone))
+		r.endTrace(err)
+		r.close(err == nil)
 	}()
 
+	r.leaderRS = cmd.LeaderRS
+	r.partlyDone = cmd.PartlyDonePolicy
 	err = r.init(cmd.Name, opid, l)
 	if err != nil {
 		return errors.Wrap(err, "init")
 	}
+	if r.isClusterLeader() {
+		meta.Leader = r.nodeInfo.Me + "/" + r.rsConf.ID
+	}
+	meta.PITRChunks, err = r.cn.HasPITRChunks()
+	if err != nil {
+		l.Warning("check pitr chunks: %v", err)
+	}
+
+	if cmd.WiredTigerSalvage {
+		if !r.confOpts.WiredTigerSalvage {
+			return errors.New("WiredTiger salvage is requested but not allowed by the config " +
+				"(restore.wiredTigerSalvage)")
+		}
+		meta.WiredTigerSalvage = true
+		l.Warning("!!! WiredTiger salvage is on: `mongod --repair` will run on the restored data " +
+			"and DISCARD whatever it can't read")
+	}
+	if cmd.NewReplsetID {
+		r.newReplsetID = true
+		meta.NewReplsetID = true
+		if m := unrestoredMembers(r.rsConf); len(m) != 0 {
+			l.Warning("a new replicaSetId is requested: members %v aren't restored and keep the old one. "+
+				"They won't rejoin the replset until their dbpath is wiped and they initial sync", m)
+		}
+	}
 
+	r.allowPlatformMismatch = cmd.AllowPlatformMismatch
 	err = r.prepareBackup(cmd.BackupName)
 	if err != nil {
 		return err
 	}
+
+	r.recoveryTS = r.bcp.LastWriteTS
+	meta.RecoveryTS = r.bcp.LastWriteTS
+	if cmd.SnapshotOnly {
+		meta.RecoveryTS, err = pbm.SnapshotOnlyRecoveryTS(r.bcp)
+		if err != nil {
+			return err
+		}
+		if rs := getRS(r.bcp, pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)); rs != nil {
+			r.recoveryTS = rs.CheckpointTS
+		}
+		meta.SnapshotOnly = true
+		l.Info("snapshot-only restore: landing at the checkpoint %v, the oplog won't be rolled forward "+
+			"to the backup point %v", r.recoveryTS, r.bcp.LastWriteTS)
+	}
+
+	err = pbm.ValidateOplogSizeMB(cmd.OplogSizeMB)
+	if err != nil {
+		return err
+	}
+	r.oplogSizeMB = cmd.OplogSizeMB
+	meta.OplogSizeMB = cmd.OplogSizeMB
+	r.targetOplogSize, err = oplogMaxSize(context.Background(), r.node.Session())
+	if err != nil {
+		l.Warning("get the node's oplog size: %v", err)
+	}
+
+	err = checkDBPath(r.dbpath, r.dbpathIgnore(), cmd.Force, l)
+	if err != nil {
+		return errors.Wrap(err, "check dbpath")
+	}
+	err = r.guardDBPath(cmd.Force, l)
+	if err != nil {
+		return errors.Wrap(err, "check dbpath is not shared")
+	}
+	err = r.checkHostsResolvable()
+	if err != nil {
+		return errors.Wrap(err, "check member hosts")
+	}
+	r.secOpts, err = r.checkEncryption()
+	if err != nil {
+		return errors.Wrap(err, "check encryption")
+	}
+	r.owner, err = resolveOwner(r.confOpts.DataOwner, r.dbpath)
+	if err != nil {
+		return errors.Wrap(err, "define data owner")
+	}
+	if r.owner != nil {
+		err = checkChown(r.dbpath, r.owner)
+		if err != nil {
+			return errors.Wrap(err, "check data owner")
+		}
+	}
+	r.relabel = prepareRelabel(r.confOpts, r.dbpath, r.dbpathIgnore(), l)
 	meta.Type = r.bcp.Type
 	err = r.setTmpConf()
 	if err != nil {
@@ -698,11 +933,33 @@ func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event,
 	l.Debug("stop agents heartbeats")
 	pauseHB()
 
+	if r.isCanary() {
+		l.Info("running as the canary replset")
+	} else if r.confOpts.CanaryShard != "" {
+		l.Info("waiting for the canary replset %s", r.confOpts.CanaryShard)
+		err = r.waitCanary()
+		if err != nil {
+			return errors.Wrap(err, "wait for canary")
+		}
+	}
+
 	l.Info("stopping mongod and flushing old data")
-	err = r.flush()
+	var keepData func()
+	err = r.traced("flush", func() (err error) {
+		keepData, err = r.flush()
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	if d := r.confOpts.PostFlushDelay(); d > 0 {
+		err = syncDir(r.dbpath)
+		if err != nil {
+			l.Warning("sync dbpath %s: %v", r.dbpath, err)
+		}
+		l.Info("waiting %v for the filesystem to settle", d)
+		time.Sleep(d)
+	}
 
 	// A point of no return. From now on, we should clean the dbPath if an
 	// error happens before the node is restored successfully.
@@ -714,55 +971,140 @@ func (r *PhysRestore) Snapshot(cmd *pbm.RestoreCmd, opid pbm.OPID, l *log.Event,
 	// Should not be set before `r.flush()` as `flush` cleans the dbPath on its
 	// own (which sets the no-return point).
 	progress |= restoreStared
+	// Aborting the restore from now on would leave the node with no data,
+	// so keep trying whatever time it takes.
+	if !r.deadline.IsZero() {
+		l.Info("passed the point of no return, max restore duration won't be enforced")
+		r.deadline = time.Time{}
+	}
+
+	err = r.restoreData(meta, l)
+	if err != nil {
+		return err
+	}
+
+	l.Info("restore on node succeed")
+	// The node at this stage was restored successfully, so we shouldn't
+	// clean up dbPath nor write error status for the node whatever happens
+	// next.
+	progress |= restoreDone
+	keepData()
+
+	if r.isCanary() && r.nodeInfo.IsPrimary {
+		err = r.stg.Save(r.syncPathCanary+"."+string(pbm.StatusDone), okStatus(), -1)
+		if err != nil {
+			return errors.Wrap(err, "write canary status")
+		}
+	}
+
+	r.progress.setPhase(pbm.PhysRestorePhaseWait)
+	stat, err := r.toState(pbm.StatusDone)
+	if err != nil {
+		return errors.Wrapf(err, "moving to state %s", pbm.StatusDone)
+	}
+
+	if stat == pbm.StatusDone && r.isClusterLeader() {
+		r.recordMongos()
+	}
+
+	r.log.Info("writing restore meta")
+	err = r.dumpMeta(meta, stat, "")
+	if err != nil {
+		return errors.Wrap(err, "writing restore meta to storage")
+	}
+
+	// the controller may restart the node as soon as it sees the signal,
+	// so the final status and the meta have to be on the storage by then
+	err = r.signalRestart(stat)
+	if err != nil {
+		r.log.Error("restart signal: %v. mongod has to be restarted manually", err)
+	}
+
+	return nil
+}
 
+// restoreData copies the backup data into the wiped dbpath and makes
+// the node out of it
+func (r *PhysRestore) restoreData(meta *pbm.RestoreMeta, l *log.Event) error {
 	l.Info("copying backup data")
 	dstat, err := r.copyFiles()
+	// the stat of the failed copy tells which files couldn't be downloaded
+	if dstat != nil || err == nil {
+		serr := r.writeStat(dstat)
+		if serr != nil {
+			r.log.Warning("write download stat: %v", serr)
+		}
+	}
 	if err != nil {
 		return errors.Wrap(err, "copy files")
 	}
-	err = r.writeStat(dstat)
-	if err != nil {
-		r.log.Warning("write download stat: %v", err)
+
+	if meta.WiredTigerSalvage {
+		l.Warning("!!! salvaging the data with `mongod --repair`. " +
+			"Data WiredTiger can't read is DISCARDED, the restored data may be incomplete")
+		err = r.traced("salvageData", r.salvageData)
+		if err != nil {
+			return errors.Wrap(err, "salvage data")
+		}
+		l.Warning("salvage done, check %s for what was dropped", r.internalLogPath())
 	}
 
 	l.Info("preparing data")
-	err = r.prepareData()
+	err = r.traced("prepareData", r.prepareData)
 	if err != nil {
 		return errors.Wrap(err, "prepare data")
 	}
 
-	l.Info("recovering oplog as standalone")
-	err = r.recoverStandalone()
+	if meta.SnapshotOnly {
+		l.Info("truncating oplog to the checkpoint as standalone")
+	} else {
+		l.Info("recovering oplog as standalone")
+	}
+	err = r.traced("recoverStandalone", r.recoverStandalone)
 	if err != nil {
 		return errors.Wrap(err, "recover oplog as standalone")
 	}
 
 	l.Info("clean-up and reset replicaset config")
-	err = r.resetRS()
+	err = r.traced("resetRS", r.resetRS)
 	if err != nil {
 		return errors.Wrap(err, "clean-up, rs_reset")
 	}
 
-	l.Info("restore on node succeed")
-	// The node at this stage was restored successfully, so we shouldn't
-	// clean up dbPath nor write error status for the node whatever happens
-	// next.
-	progress |= restoreDone
-
-	stat, err := r.toState(pbm.StatusDone)
-	if err != nil {
-		return errors.Wrapf(err, "moving to state %s", pbm.StatusDone)
+	// Internal mongod runs create files as the agent's user and have to
+	// be able to read the copied ones. So the owner is changed only once
+	// mongod is done with the data.
+	if r.owner != nil {
+		l.Info("changing the owner of the restored files to %s", r.owner)
+		err = chownTree(r.dbpath, r.dbpathIgnore(), r.owner)
+		if err != nil {
+			return errors.Wrap(err, "change restored files owner")
+		}
 	}
-
-	r.log.Info("writing restore meta")
-	err = r.dumpMeta(meta, stat, "")
-	if err != nil {
-		return errors.Wrap(err, "writing restore meta to storage")
+	if r.relabel != nil {
+		l.Info("restoring SELinux context of the restored files")
+		if err := r.relabel.apply(r.dbpath, r.dbpathIgnore()); err != nil {
+			l.Error("failed to restore SELinux context of the restored files: %v. "+
+				"mongod won't start with SELinux enforcing until it's fixed (e.g. `restorecon -R %s`)",
+				err, r.dbpath)
+		}
 	}
 
 	return nil
 }
 
+// ErrRestoreTimeout means the restore didn't converge
+// in time (see pbm.RestoreConf.MaxRestoreDurationMin)
+var ErrRestoreTimeout = errors.New("max restore duration exceeded")
+
+func (r *PhysRestore) checkDeadline() error {
+	if r.deadline.IsZero() || time.Now().Before(r.deadline) {
+		return nil
+	}
+
+	return errors.Wrapf(ErrRestoreTimeout, "%v", time.Duration(r.confOpts.MaxRestoreDurationMin*float64(time.Minute)))
+}
+
 func (r *PhysRestore) writeStat(stat any) error {
 	d := struct {
 		D any `json:"d"`
@@ -806,6 +1148,7 @@ func (r *PhysRestore) dumpMeta(meta *pbm.RestoreMeta, s pbm.Status, msg string)
 		meta.Error = condsm.Error
 		meta.Hb = condsm.Hb
 		meta.Conditions = condsm.Conditions
+		meta.Mongos = condsm.Mongos
 	}
 	if err != nil || s == pbm.StatusError {
 		ts := time.Now().Unix()
@@ -831,9 +1174,27 @@ func (r *PhysRestore) dumpMeta(meta *pbm.RestoreMeta, s pbm.Status, msg string)
 }
 
 func (r *PhysRestore) copyFiles() (stat *s3.DownloadStat, err error) {
-	readFn := r.stg.SourceReader
-	if t, ok := r.stg.(*s3.S3); ok {
-		d := t.NewDownload(r.confOpts.NumDownloadWorkers, r.confOpts.MaxDownloadBufferMb, r.confOpts.DownloadChunkMb)
+	r.progress.setPhase(pbm.PhysRestorePhaseCopy)
+	span := r.span.Child(pbm.PhysRestorePhaseCopy)
+	defer func() { span.End(err) }()
+
+	var served *servedStat
+	if len(r.readFallbacks) != 0 {
+		served = newServedStat()
+		defer func() {
+			if stat == nil {
+				stat = &s3.DownloadStat{}
+			}
+			stat.Served = served.list()
+		}()
+	}
+
+	readFn := r.bcpStg.SourceReader
+	if t, ok := r.bcpStg.(*s3.S3); ok {
+		// each copy worker gets its own download as the download's buffers
+		// can't be shared by several files at once
+		d := t.NewDownloadPool(r.confOpts.NumCopyWorkers,
+			r.confOpts.NumDownloadWorkers, r.confOpts.MaxDownloadBufferMb, r.confOpts.DownloadChunkMb)
 		readFn = d.SourceReader
 		defer func() {
 			s := d.Stat()
@@ -842,81 +1203,149 @@ func (r *PhysRestore) copyFiles() (stat *s3.DownloadStat, err error) {
 		}()
 	}
 
-	setName := pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)
-	cpbuf := make([]byte, 32*1024)
-	for i := len(r.files) - 1; i >= 0; i-- {
-		set := r.files[i]
-		for _, f := range set.Data {
-			src := filepath.Join(set.BcpName, setName, f.Name+set.Cmpr.Suffix())
-			if f.Len != 0 {
-				src += fmt.Sprintf(".%d-%d", f.Off, f.Len)
-			}
-			// cut dbpath from destination if there is any (see PBM-1058)
-			fname := f.Name
-			if set.dbpath != "" {
-				fname = strings.TrimPrefix(fname, set.dbpath)
-			}
-			dst := filepath.Join(r.dbpath, fname)
+	if r.confOpts.CacheDir != "" {
+		c, err := newObjCache(r.confOpts.CacheDir, r.confOpts.CacheSizeMb)
+		if err != nil {
+			return stat, errors.Wrap(err, "init cache")
+		}
+		r.log.Info("use cache %s", r.confOpts.CacheDir)
+		r.cleanup.add("cache scratch files", cleanupAlways, c.removeTmp)
+		fetch := readFn
+		readFn = func(name string) (io.ReadCloser, error) {
+			return c.SourceReader(r.bcpStg, fetch, name)
+		}
+	}
+
+	// ranged reads bypass the cache
+	var partSize int64
+	rr, ranged := r.bcpStg.(storage.RangeReader)
+	if ranged && r.confOpts.NumCopyWorkers > 1 && r.confOpts.CacheDir == "" {
+		partSize = copyPartSize
+	}
+
+	// fallbacks bypass the cache
+	eps := []readEndpoint{{name: r.readPrimary, read: readFn, rr: rr}}
+	for _, fb := range r.readFallbacks {
+		ep := readEndpoint{name: fb.name, read: fb.stg.SourceReader}
+		if t, ok := fb.stg.(*s3.S3); ok {
+			d := t.NewDownloadPool(r.confOpts.NumCopyWorkers,
+				r.confOpts.NumDownloadWorkers, r.confOpts.MaxDownloadBufferMb, r.confOpts.DownloadChunkMb)
+			ep.read = d.SourceReader
+		}
+		ep.rr, _ = fb.stg.(storage.RangeReader)
+		eps = append(eps, ep)
+	}
 
-			err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0o700)
+	var initWorker func()
+	if lim := r.confOpts.Limits; lim.IsSet() {
+		if lim.Cgroup != "" {
+			leave, err := enterCgroup(lim.Cgroup)
 			if err != nil {
-				return stat, errors.Wrapf(err, "create path %s", filepath.Dir(dst))
+				r.log.Warning("copy files: apply limits: %v", err)
+			} else {
+				defer func() {
+					if err := leave(); err != nil {
+						r.log.Warning("copy files: leave cgroup: %v", err)
+					}
+				}()
 			}
-			// if this is a directory, only ensure it is created.
-			if set.BcpName == bcpDir {
-				r.log.Info("create dir <%s>", filepath.Dir(f.Name))
-				continue
+		}
+		if lim.Nice != 0 || lim.IOClass != "" {
+			var warn sync.Once
+			initWorker = func() {
+				runtime.LockOSThread()
+				if err := limitThread(lim); err != nil {
+					warn.Do(func() { r.log.Warning("copy files: apply limits: %v", err) })
+				}
 			}
+		}
+	}
 
-			r.log.Info("copy <%s> to <%s>", src, dst)
-			sr, err := readFn(src)
-			if err != nil {
-				return stat, errors.Wrapf(err, "create source reader for <%s>", src)
+	setName := pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)
+	plan := planCopy(r.files, setName, r.dbpath, partSize)
+	var total int64
+	for _, tasks := range plan {
+		for _, t := range tasks {
+			if !t.dir {
+				total += t.size()
 			}
-			defer sr.Close()
+		}
+	}
+	r.progress.setTotal(total)
 
-			data, err := compress.Decompress(sr, set.Cmpr)
-			if err != nil {
-				return stat, errors.Wrapf(err, "decompress object %s", src)
+	for _, tasks := range plan {
+		err = runCopy(tasks, r.confOpts.NumCopyWorkers, initWorker, func(t copyTask, buf []byte) error {
+			// if this is a directory, only ensure it is created.
+			if t.dir {
+				r.log.Info("create dir <%s>", filepath.Dir(t.f.Name))
+				return nil
 			}
-			defer data.Close()
 
-			fw, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE, f.Fmode)
-			if err != nil {
-				return stat, errors.Wrapf(err, "create/open destination file <%s>", dst)
-			}
-			defer fw.Close()
-			if f.Off != 0 {
-				_, err := fw.Seek(f.Off, io.SeekStart)
-				if err != nil {
-					return stat, errors.Wrapf(err, "set file offset <%s>|%d", dst, f.Off)
-				}
-			}
-			_, err = io.CopyBuffer(fw, data, cpbuf)
-			if err != nil {
-				return stat, errors.Wrapf(err, "copy file <%s>", dst)
-			}
-			if f.Size != 0 {
-				err = fw.Truncate(f.Size)
-				if err != nil {
-					return stat, errors.Wrapf(err, "truncate file <%s>|%d", dst, f.Size)
-				}
+			r.log.Info("copy %s", t)
+			sp := span.Child("copyFile")
+			sp.SetAttr("pbm.file", t.f.Name)
+			sp.SetAttr("pbm.file.size", t.f.Size)
+			err := copyFailover(eps, t, buf, served, r.log)
+			sp.End(err)
+			if err == nil {
+				r.progress.add(t.size())
 			}
+			return err
+		})
+		if err != nil {
+			return stat, err
 		}
 	}
 	return stat, nil
 }
 
-func (r *PhysRestore) prepareData() error {
-	err := r.startMongo("--dbpath", r.dbpath,
-		"--setParameter", "disableLogicalSessionCacheRefresh=true")
+// CopyFile reads the backup file `src` with `readFn`, decompresses and
+// writes it into `dst` according to the file's offset and size. It doesn't
+// need a running mongod, so the copy path can be measured alone.
+func CopyFile(readFn func(string) (io.ReadCloser, error), src string, cmpr compress.CompressionType,
+	dst string, f pbm.File, buf []byte,
+) error {
+	sr, err := readFn(src)
+	if err != nil {
+		return errors.Wrapf(err, "create source reader for <%s>", src)
+	}
+	defer sr.Close()
+
+	data, err := compress.Decompress(sr, cmpr)
+	if err != nil {
+		return errors.Wrapf(err, "decompress object %s", src)
+	}
+	defer data.Close()
+
+	fw, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE, f.Fmode)
+	if err != nil {
+		return errors.Wrapf(err, "create/open destination file <%s>", dst)
+	}
+	defer fw.Close()
+	if f.Off != 0 {
+		_, err := fw.Seek(f.Off, io.SeekStart)
+		if err != nil {
+			return errors.Wrapf(err, "set file offset <%s>|%d", dst, f.Off)
+		}
+	}
+	_, err = io.CopyBuffer(fw, data, buf)
 	if err != nil {
-		return errors.Wrap(err, "start mongo")
+		return errors.Wrapf(err, "copy file <%s>", dst)
+	}
+	if f.Size != 0 {
+		err = fw.Truncate(f.Size)
+		if err != nil {
+			return errors.Wrapf(err, "truncate file <%s>|%d", dst, f.Size)
+		}
 	}
 
-	c, err := tryConn(5, time.Minute*5, r.tmpPort, path.Join(r.dbpath, internalMongodLog))
+	return nil
+}
+
+func (r *PhysRestore) prepareData() error {
+	c, err := r.startMongo("disableLogicalSessionCacheRefresh=true")
 	if err != nil {
-		return errors.Wrap(err, "connect to mongo")
+		return err
 	}
 
 	ctx := context.Background()
@@ -945,15 +1374,18 @@ func (r *PhysRestore) prepareData() error {
 		return errors.Wrap(err, "insert to replset.minvalid")
 	}
 
-	r.log.Debug("oplogTruncateAfterPoint: %v", r.bcp.LastWriteTS)
+	// The oplog past the truncate point is dropped on the recovery, the rest
+	// is replayed on top of the checkpoint. With the snapshot-only restore
+	// the point is the checkpoint itself, so nothing is replayed.
+	r.log.Debug("oplogTruncateAfterPoint: %v", r.recoveryTS)
 	_, err = c.Database("local").Collection("replset.oplogTruncateAfterPoint").InsertOne(ctx,
-		bson.M{"_id": "oplogTruncateAfterPoint", "oplogTruncateAfterPoint": r.bcp.LastWriteTS},
+		bson.M{"_id": "oplogTruncateAfterPoint", "oplogTruncateAfterPoint": r.recoveryTS},
 	)
 	if err != nil {
 		return errors.Wrap(err, "set oplogTruncateAfterPoint")
 	}
 
-	err = shutdown(c, r.dbpath)
+	err = r.runner.Shutdown(c)
 	if err != nil {
 		return errors.Wrap(err, "shutdown mongo")
 	}
@@ -961,57 +1393,71 @@ func (r *PhysRestore) prepareData() error {
 	return nil
 }
 
-func shutdown(c *mongo.Client, dbpath string) error {
-	err := c.Database("admin").RunCommand(context.Background(), bson.D{{"shutdown", 1}}).Err()
-	if err != nil && !strings.Contains(err.Error(), "socket was unexpectedly closed") {
+// salvageData runs `mongod --repair` on the copied data. WiredTiger
+// salvages the damaged files and drops whatever it can't read, so the
+// restored data may be incomplete (see pbm.RestoreConf.WiredTigerSalvage).
+func (r *PhysRestore) salvageData() error {
+	opts := r.mongodRunOpts()
+	opts.Repair = true
+
+	return r.runner.Run(opts)
+}
+
+func (r *PhysRestore) recoverStandalone() error {
+	c, err := r.startMongo("recoverFromOplogAsStandalone=true",
+		"takeUnstableCheckpointOnShutdown=true")
+	if err != nil {
 		return err
 	}
 
-	err = waitMgoShutdown(dbpath)
+	err = r.runner.Shutdown(c)
 	if err != nil {
-		return errors.Wrap(err, "shutdown")
+		return errors.Wrap(err, "shutdown mongo")
 	}
 
 	return nil
 }
 
-func (r *PhysRestore) recoverStandalone() error {
-	err := r.startMongo("--dbpath", r.dbpath,
-		"--setParameter", "recoverFromOplogAsStandalone=true",
-		"--setParameter", "takeUnstableCheckpointOnShutdown=true")
+// dropSessions drops config.system.sessions if there is one. The drop is
+// retried while mongod has a background operation on the collection
+// (see pbm.RestoreConf.SessionsDropRetry).
+func (r *PhysRestore) dropSessions(ctx context.Context, c *mongo.Client) error {
+	colls, err := c.Database("config").ListCollectionNames(ctx, bson.D{{"name", "system.sessions"}})
 	if err != nil {
-		return errors.Wrap(err, "start mongo")
+		return errors.Wrap(err, "check collection exists")
+	}
+	if len(colls) == 0 {
+		r.log.Debug("no config.system.sessions, skip drop")
+		return nil
 	}
 
-	c, err := tryConn(5, time.Minute*5, r.tmpPort, path.Join(r.dbpath, internalMongodLog))
-	if err != nil {
-		return errors.Wrap(err, "connect to mongo")
-	}
-
-	err = shutdown(c, r.dbpath)
-	if err != nil {
-		return errors.Wrap(err, "shutdown mongo")
-	}
-
-	return nil
+	n, backoff := r.confOpts.SessionsDropRetry()
+	return retry.Do(ctx, retry.Policy{Attempts: n, Backoff: backoff, Linear: true},
+		func() error {
+			return c.Database("config").Collection("system.sessions").Drop(ctx)
+		},
+		retry.If(func(err error) bool {
+			return strings.Contains(err.Error(), "(BackgroundOperationInProgressForNamespace)")
+		}),
+		retry.OnRetry(func(a retry.Attempt) {
+			r.log.Debug("drop config.system.sessions: BackgroundOperationInProgressForNamespace, retry %d/%d in %v",
+				a.N, a.Of-1, a.Wait)
+		}))
 }
 
 func (r *PhysRestore) resetRS() error {
-	err := r.startMongo("--dbpath", r.dbpath,
-		"--setParameter", "disableLogicalSessionCacheRefresh=true",
-		"--setParameter", "skipShardingConfigurationChecks=true")
+	c, err := r.startMongo("disableLogicalSessionCacheRefresh=true",
+		"skipShardingConfigurationChecks=true")
 	if err != nil {
-		return errors.Wrap(err, "start mongo")
-	}
-
-	c, err := tryConn(5, time.Minute*5, r.tmpPort, path.Join(r.dbpath, internalMongodLog))
-	if err != nil {
-		return errors.Wrap(err, "connect to mongo")
+		return err
 	}
 
 	ctx := context.Background()
 
 	if r.nodeInfo.IsConfigSrv() {
+		if r.confOpts.Mongos != nil && r.confOpts.Mongos.Discover && r.nodeInfo.IsClusterLeader() {
+			r.saveDiscoveredMongos(ctx, c)
+		}
 		err = c.Database("config").Collection("mongos").Drop(ctx)
 		if err != nil {
 			return errors.Wrap(err, "drop config.mongos")
@@ -1041,6 +1487,10 @@ func (r *PhysRestore) resetRS() error {
 		for _, doc := range docs {
 			doc.I = mapS(doc.I)
 			doc.H = r.shards[doc.I]
+			if doc.H == "" {
+				r.log.Warning("config.shards: %q is created for a shard that doesn't exist "+
+					"in the cluster, drain and remove it after the restore", doc.I)
+			}
 			ms = append(ms, &mongo.InsertOneModel{Document: doc})
 		}
 
@@ -1088,15 +1538,7 @@ func (r *PhysRestore) resetRS() error {
 		}
 	}
 
-	const retry = 5
-	for i := 0; i < retry; i++ {
-		err = c.Database("config").Collection("system.sessions").Drop(ctx)
-		if err == nil || !strings.Contains(err.Error(), "(BackgroundOperationInProgressForNamespace)") {
-			break
-		}
-		r.log.Debug("drop config.system.sessions: BackgroundOperationInProgressForNamespace, retrying")
-		time.Sleep(time.Second * time.Duration(i+1))
-	}
+	err = r.dropSessions(ctx, c)
 	if err != nil {
 		return errors.Wrap(err, "drop config.system.sessions")
 	}
@@ -1106,15 +1548,12 @@ func (r *PhysRestore) resetRS() error {
 		return errors.Wrap(err, "delete from system.replset")
 	}
 
+	rsID, err := r.agreeReplsetID()
+	if err != nil {
+		return errors.Wrap(err, "agree on the replicaSetId")
+	}
 	_, err = c.Database("local").Collection("system.replset").InsertOne(ctx,
-		pbm.RSConfig{
-			ID:       r.rsConf.ID,
-			CSRS:     r.nodeInfo.IsConfigSrv(),
-			Version:  1,
-			Members:  r.rsConf.Members,
-			Settings: r.rsConf.Settings,
-		},
-	)
+		restoredRSConfig(r.rsConf, r.nodeInfo.IsConfigSrv(), rsID))
 	if err != nil {
 		return errors.Wrapf(err, "update rs.member host to %s", r.nodeInfo.Me)
 	}
@@ -1125,20 +1564,53 @@ func (r *PhysRestore) resetRS() error {
 	// restore and chunks made after the backup. So it would successfully start slicing
 	// and overwrites chunks after the backup.
 	if r.nodeInfo.IsLeader() {
-		_, err = c.Database(pbm.DB).Collection(pbm.ConfigCollection).UpdateOne(ctx, bson.D{},
+		res, err := c.Database(pbm.DB).Collection(pbm.ConfigCollection).UpdateOne(ctx, bson.D{},
 			bson.D{{"$set", bson.M{"pitr.enabled": false}}},
 		)
 		if err != nil {
 			return errors.Wrap(err, "turn off pitr")
 		}
+		if res.ModifiedCount != 0 {
+			err = r.stg.Save(path.Join(pbm.PhysRestoresDir, r.name, pbm.PhysRestorePITRDisabledFile),
+				strings.NewReader("1"), -1)
+			if err != nil {
+				r.log.Warning("mark pitr as disabled: %v", err)
+			}
+		}
+
+		// WiredTiger doesn't keep the incremental backup history over the
+		// restore. Leave the mark so the next incremental backup is made as
+		// a base one rather than failing on the missing history.
+		_, err = c.Database(pbm.DB).Collection(pbm.IncrResetCollection).ReplaceOne(ctx, bson.D{},
+			pbm.IncrReset{Restore: r.name, Backup: r.bcp.Name, TS: r.startTS},
+			options.Replace().SetUpsert(true),
+		)
+		if err != nil {
+			return errors.Wrap(err, "mark incremental backup history reset")
+		}
+	} else {
+		// the mark is read and cleared only in the PBM db of the leader rs.
+		// Drop the one carried over in the restored data of the shard (e.g.
+		// the backup of a replset restored into the shard) so it doesn't linger.
+		err = c.Database(pbm.DB).Collection(pbm.IncrResetCollection).Drop(ctx)
+		if err != nil {
+			return errors.Wrap(err, "drop incremental backup history reset mark")
+		}
+	}
+
+	err = r.resizeOplog(ctx, c)
+	if err != nil {
+		return err
 	}
 
-	err = shutdown(c, r.dbpath)
+	verr := r.validateColls(ctx, c)
+
+	err = r.runner.Shutdown(c)
 	if err != nil {
 		return errors.Wrap(err, "shutdown mongo")
 	}
 
-	return nil
+	return verr
 }
 
 func (r *PhysRestore) getShardMapping(bcp *pbm.BackupMeta) map[string]string {
@@ -1166,104 +1638,124 @@ func (r *PhysRestore) getShardMapping(bcp *pbm.BackupMeta) map[string]string {
 	return rv
 }
 
-// Tries to connect to mongo n times, timeout is applied for each try.
-// If a try is unsuccessful, it will check the mongo logs and retry if
-// there are no errors or fatals.
-func tryConn(n int, tout time.Duration, port int, logpath string) (cn *mongo.Client, err error) {
-	type mlog struct {
-		T struct {
-			Date string `json:"$date"`
-		} `json:"t"`
-		S   string `json:"s"`
-		Msg string `json:"msg"`
-	}
-	for i := 0; i < n; i++ {
-		cn, err = conn(port, tout)
-		if err == nil {
-			return cn, nil
-		}
-
-		f, ferr := os.Open(logpath)
-		if ferr != nil {
-			return nil, errors.Errorf("open logs: %v, connect err: %v", ferr, err)
-		}
-		defer f.Close()
-
-		dec := json.NewDecoder(f)
-		for {
-			var m mlog
-			if derr := dec.Decode(&m); derr == io.EOF {
-				break
-			} else if derr != nil {
-				return nil, errors.Errorf("decode logs: %v, connect err: %v", derr, err)
-			}
-			if m.S == "E" || m.S == "F" {
-				return nil, errors.Errorf("mongo failed with [%s] %s / %s, connect err: %v", m.S, m.Msg, m.T.Date, err)
-			}
-		}
-	}
+const internalLogPrefix = "pbm.restore."
 
-	return nil, errors.Errorf("failed to  connect after %d tries: %v", n, err)
+// internalLogPath returns the path of the log of internal mongod runs.
+// The file name contains the node's identity so agents of several mongod
+// instances on the same host won't clash.
+func (r *PhysRestore) internalLogPath() string {
+	return path.Join(r.dbpath, internalLogPrefix+nodeFileName(r.nodeInfo.Me)+".log")
 }
 
-func conn(port int, tout time.Duration) (*mongo.Client, error) {
-	ctx := context.Background()
-
-	opts := options.Client().
-		SetHosts([]string{"localhost:" + strconv.Itoa(port)}).
-		SetAppName("pbm-physical-restore").
-		SetDirect(true).
-		SetConnectTimeout(time.Second * 120).
-		SetServerSelectionTimeout(tout)
-
-	conn, err := mongo.NewClient(opts)
+// uploadInternalLog saves the log of internal mongod runs to the storage
+// next to the node's restore log
+func (r *PhysRestore) uploadInternalLog() {
+	f, err := os.Open(r.internalLogPath())
 	if err != nil {
-		return nil, errors.Wrap(err, "create mongo client")
+		r.log.Error("open tmp mongod logs: %v", err)
+		return
 	}
+	defer f.Close()
 
-	err = conn.Connect(ctx)
+	fi, err := f.Stat()
 	if err != nil {
-		return nil, errors.Wrap(err, "connect")
+		r.log.Error("stat tmp mongod logs: %v", err)
+		return
 	}
 
-	err = conn.Ping(ctx, nil)
+	name := fmt.Sprintf("%s/%s/rs.%s/log/%s.mongod.log", pbm.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
+	err = r.stg.Save(name, f, fi.Size())
 	if err != nil {
-		return nil, errors.Wrap(err, "ping")
+		r.log.Error("upload tmp mongod logs to %s: %v", name, err)
+		return
 	}
+	r.log.Debug("tmp mongod logs kept in %s and uploaded to %s", r.internalLogPath(), name)
+}
 
-	return conn, nil
+func (r *PhysRestore) dbpathIgnore() []string {
+	if len(r.confOpts.DBPathIgnore) != 0 {
+		return r.confOpts.DBPathIgnore
+	}
+	return DefaultDBPathIgnore
 }
 
-const internalMongodLog = "pbm.restore.log"
+func isInternalLog(fname string) bool {
+	return strings.HasPrefix(fname, internalLogPrefix) && strings.HasSuffix(fname, ".log")
+}
+
+// nodeFileName makes the node name (host:port) safe to use in file names
+func nodeFileName(node string) string {
+	return strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(node)
+}
 
-func (r *PhysRestore) startMongo(opts ...string) error {
+const (
+	connTries   = 5
+	connTimeout = time.Minute * 5
+)
+
+// startMongo starts a standalone mongod on the tmp port with the given
+// `--setParameter` values and waits for it to get ready
+func (r *PhysRestore) mongodRunOpts(params ...string) MongodRunOpts {
+	opts := MongodRunOpts{
+		Bin:     r.mongod,
+		DBpath:  r.dbpath,
+		Port:    r.tmpPort,
+		LogPath: r.internalLogPath(),
+		Params:  params,
+	}
 	if r.tmpConf != nil {
-		opts = append(opts, []string{"-f", r.tmpConf.Name()}...)
+		opts.Conf = r.tmpConf.Name()
+	}
+	if r.confOpts.Limits.IsSet() {
+		opts.Limits = r.confOpts.Limits
+		opts.Warn = r.log.Warning
 	}
 
-	opts = append(opts, []string{"--logpath", path.Join(r.dbpath, internalMongodLog)}...)
-
-	errBuf := new(bytes.Buffer)
-	cmd := exec.Command(r.mongod, opts...)
+	return opts
+}
 
-	cmd.Stderr = errBuf
-	err := cmd.Start()
+func (r *PhysRestore) startMongo(params ...string) (*mongo.Client, error) {
+	err := r.runner.Start(r.mongodRunOpts(params...))
 	if err != nil {
-		return err
+		return nil, errors.Wrap(err, "start mongo")
 	}
 
-	// release process resources
-	go func() {
-		err := cmd.Wait()
-		if err != nil {
-			slog.Printf("mongod process: %v, %s", err, errBuf)
-		}
-	}()
-	return nil
+	var c *mongo.Client
+	tries := 0
+	err = retry.Do(context.Background(), retry.Policy{Attempts: connTries},
+		func() error {
+			tries++
+			c, err = r.runner.WaitReady(connTimeout)
+			return err
+		},
+		retry.If(func(err error) bool {
+			// mongod is gone, there is nothing to wait for
+			return !errors.Is(err, ErrMongodFailed)
+		}),
+		retry.OnRetry(func(a retry.Attempt) {
+			r.log.Debug("connect to mongo, try %d: %v", a.N, a.Err)
+		}))
+	switch {
+	case err == nil:
+		return c, nil
+	case errors.Is(err, ErrMongodFailed):
+		return nil, errors.Wrap(err, "connect to mongo")
+	}
+
+	return nil, errors.Wrapf(err, "connect to mongo: failed after %d tries", tries)
 }
 
 const hbFrameSec = 60 * 2
 
+// hbJitter spreads the heartbeats of the nodes a bit, so they don't hit
+// the storage at once. It's fixed as a beat late by the tuned retry jitter
+// could be taken as stale (see pbm.PhysRestoreBeat).
+const hbJitter = 0.05
+
+// syncPollInterval is how often the sync files are checked while waiting
+// for the rest of the cluster
+const syncPollInterval = time.Second * 5
+
 func (r *PhysRestore) init(name string, opid pbm.OPID, l *log.Event) (err error) {
 	var cfg pbm.Config
 	cfg, err = r.cn.GetConfig()
@@ -1271,39 +1763,114 @@ func (r *PhysRestore) init(name string, opid pbm.OPID, l *log.Event) (err error)
 		return errors.Wrap(err, "get pbm config")
 	}
 
-	r.stg, err = pbm.Storage(cfg, l)
+	pbm.ApplyRetryConf(cfg)
+
+	r.stg, err = pbm.RestoreStorage(cfg, l)
 	if err != nil {
 		return errors.Wrap(err, "get storage")
 	}
+	r.stgConf = pbm.RestoreStorageConf(cfg)
+	for _, c := range pbm.RestoreReadEndpointsConf(cfg) {
+		stg, err := s3.New(c, l)
+		if err != nil {
+			return errors.Wrapf(err, "get read endpoint %s storage", s3EndpointName(c))
+		}
+		r.readFallbacks = append(r.readFallbacks, fallbackStorage{name: s3EndpointName(c), stg: stg})
+	}
+	if len(r.readFallbacks) != 0 {
+		r.readPrimary = s3EndpointName(pbm.RestoreStorageConf(cfg).S3)
+	}
+
+	err = pbm.CheckRestoreStorage(cfg, r.stg)
+	if err != nil {
+		return errors.Wrap(err, "check storage")
+	}
 
 	r.confOpts = cfg.Restore
+	r.notify = cfg.Notify
 
-	r.mongod = "mongod" // run from $PATH by default
-	if r.confOpts.MongodLocation != "" {
-		r.mongod = r.confOpts.MongodLocation
-	}
-	if m, ok := r.confOpts.MongodLocationMap[r.nodeInfo.Me]; ok {
-		r.mongod = m
+	r.mongod = r.confOpts.MongodLocationFor(r.nodeInfo.Me, r.nodeInfo.Tags)
+	if r.mongod == "" {
+		r.mongod = "mongod" // run from $PATH by default
 	}
 
 	r.log = l
+	// the log is kept for investigation on error
+	r.cleanup.add("tmp mongod logs", cleanupOnSuccess, r.removeInternalLog)
+
+	from, to, err := r.tmpPortRange()
+	if err != nil {
+		return errors.Wrap(err, "define tmp port range")
+	}
+	if r.confOpts.TmpPortLock {
+		var release func()
+		r.tmpPort, release, err = reserveTmpPort(from, to, portClaim{
+			PID:     os.Getpid(),
+			Restore: name,
+			Node:    r.nodeInfo.Me,
+		})
+		if err == nil {
+			r.cleanup.add("tmp port lock", cleanupAlways, func() error { release(); return nil })
+		}
+	} else {
+		r.tmpPort, err = peekTmpPort(from, to)
+	}
+	if err != nil {
+		return errors.Wrap(err, "peek tmp port")
+	}
+	l.Debug("port: %d", r.tmpPort)
 
 	r.name = name
 	r.opid = opid.String()
 
 	r.startTS = time.Now().Unix()
+	if m := r.confOpts.MaxRestoreDurationMin; m > 0 {
+		r.deadline = time.Unix(r.startTS, 0).Add(time.Duration(m * float64(time.Minute)))
+	}
 
 	r.syncPathNode = fmt.Sprintf("%s/%s/rs.%s/node.%s", pbm.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
 	r.syncPathNodeStat = fmt.Sprintf("%s/%s/rs.%s/stat.%s", pbm.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
+	r.syncPathNodeValidate = fmt.Sprintf("%s/%s/rs.%s/validate.%s", pbm.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
+	r.syncPathNodePort = fmt.Sprintf("%s/%s/rs.%s/port.%s", pbm.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
+	r.syncPathNodeOplog = fmt.Sprintf("%s/%s/rs.%s/oplog.%s", pbm.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
+	r.syncPathNodeFiles = fmt.Sprintf("%s/%s/rs.%s/files.%s", pbm.PhysRestoresDir, r.name, r.rsConf.ID, r.nodeInfo.Me)
 	r.syncPathRS = fmt.Sprintf("%s/%s/rs.%s/rs", pbm.PhysRestoresDir, r.name, r.rsConf.ID)
+	r.syncPathBeat = pbm.PhysRestoreBeatPath(r.name, r.rsConf.ID, r.nodeInfo.Me)
 	r.syncPathCluster = fmt.Sprintf("%s/%s/cluster", pbm.PhysRestoresDir, r.name)
+	r.syncPathCanary = fmt.Sprintf("%s/%s/canary.%s", pbm.PhysRestoresDir, r.name, r.confOpts.CanaryShard)
 	r.syncPathPeers = make(map[string]struct{})
+	r.syncPathVoters = make(map[string]struct{})
 	for _, m := range r.rsConf.Members {
 		if !m.ArbiterOnly {
-			r.syncPathPeers[fmt.Sprintf("%s/%s/rs.%s/node.%s", pbm.PhysRestoresDir, r.name, r.rsConf.ID, m.Host)] = struct{}{}
+			p := fmt.Sprintf("%s/%s/rs.%s/node.%s", pbm.PhysRestoresDir, r.name, r.rsConf.ID, m.Host)
+			r.syncPathPeers[p] = struct{}{}
+			if m.Votes > 0 {
+				r.syncPathVoters[p] = struct{}{}
+			}
 		}
 	}
-	if r.nodeInfo.IsConfigSrv() {
+	err = r.stg.Save(r.syncPathNodePort, strings.NewReader(strconv.Itoa(r.tmpPort)), -1)
+	if err != nil {
+		l.Warning("write tmp port: %v", err)
+	}
+	if r.nodeInfo.IsPrimary {
+		err = r.writeRoster()
+		if err != nil {
+			l.Warning("write roster: %v", err)
+		}
+	}
+	r.startTrace(cfg.Tracing)
+	err = r.agreeLeaderRS()
+	if err != nil {
+		return errors.Wrap(err, "agree on the leader replset")
+	}
+	err = r.agreePartlyDonePolicy()
+	if err != nil {
+		return errors.Wrap(err, "agree on the partlyDone policy")
+	}
+	if r.leaderRS != "" {
+		l.Info("the cluster leader is the primary of the designated replset %s", r.leaderRS)
+	} else if r.nodeInfo.IsConfigSrv() {
 		sh, err := r.cn.GetShards()
 		if err != nil {
 			return errors.Wrap(err, "get data shards")
@@ -1320,8 +1887,9 @@ func (r *PhysRestore) init(name string, opid pbm.OPID, l *log.Event) (err error)
 	}
 
 	r.stopHB = make(chan struct{})
+	r.cleanup.add("heartbeats", cleanupAlways, func() error { close(r.stopHB); return nil })
 	go func() {
-		tk := time.NewTicker(time.Second * hbFrameSec)
+		tk := time.NewTimer(retry.JitterBy(time.Second*hbFrameSec, hbJitter))
 		defer func() {
 			tk.Stop()
 			l.Debug("hearbeats stopped")
@@ -1329,10 +1897,17 @@ func (r *PhysRestore) init(name string, opid pbm.OPID, l *log.Event) (err error)
 		for {
 			select {
 			case <-tk.C:
+				tk.Reset(retry.JitterBy(time.Second*hbFrameSec, hbJitter))
 				err := r.hb()
 				if err != nil {
 					l.Warning("send heartbeat: %v", err)
 				}
+				if r.isClusterLeader() {
+					err = r.trackProgress()
+					if err != nil {
+						l.Warning("track progress: %v", err)
+					}
+				}
 			case <-r.stopHB:
 				return
 			}
@@ -1342,66 +1917,213 @@ func (r *PhysRestore) init(name string, opid pbm.OPID, l *log.Event) (err error)
 	return nil
 }
 
-const syncHbSuffix = "hb"
+// syncLeaderRSFile holds the replset designated as the cluster leader
+// (empty for the default one)
+const syncLeaderRSFile = "leader.rs"
 
-func (r *PhysRestore) hb() error {
-	ts := time.Now().Unix()
-
-	err := r.stg.Save(r.syncPathNode+"."+syncHbSuffix,
-		bytes.NewReader([]byte(strconv.FormatInt(ts, 10))), -1)
+// agreeLeaderRS records the designated leader replset in the sync dir. The
+// first node to do it wins and the rest adopt its designation, so all
+// nodes agree on the leader.
+func (r *PhysRestore) agreeLeaderRS() error {
+	rs, err := r.agreeSyncValue(syncLeaderRSFile, r.leaderRS)
 	if err != nil {
-		return errors.Wrap(err, "write node hb")
+		return err
+	}
+	if rs != r.leaderRS {
+		r.log.Warning("leader replset %q is already designated as %q, adopting it", r.leaderRS, rs)
+		r.leaderRS = rs
 	}
 
-	err = r.stg.Save(r.syncPathRS+"."+syncHbSuffix,
-		bytes.NewReader([]byte(strconv.FormatInt(ts, 10))), -1)
-	if err != nil {
-		return errors.Wrap(err, "write rs hb")
+	return nil
+}
+
+// agreePartlyDonePolicy records the partlyDone policy in the sync dir the
+// same way as agreeLeaderRS, so all nodes evaluate replsets identically.
+func (r *PhysRestore) agreePartlyDonePolicy() error {
+	if r.partlyDone == "" {
+		r.partlyDone = r.confOpts.PartlyDonePolicy
+	}
+	if r.partlyDone == "" {
+		r.partlyDone = pbm.PartlyDoneLenient
 	}
 
-	err = r.stg.Save(r.syncPathCluster+"."+syncHbSuffix,
-		bytes.NewReader([]byte(strconv.FormatInt(ts, 10))), -1)
+	p, err := r.agreeSyncValue(pbm.PhysRestorePartlyDoneFile, string(r.partlyDone))
 	if err != nil {
-		return errors.Wrap(err, "write rs hb")
+		return err
+	}
+	if pbm.PartlyDonePolicy(p) != r.partlyDone {
+		r.log.Warning("partlyDone policy %q is already set to %q, adopting it", r.partlyDone, p)
+		r.partlyDone = pbm.PartlyDonePolicy(p)
 	}
 
 	return nil
 }
 
-func (r *PhysRestore) checkHB(file string) error {
-	ts := time.Now().Unix()
+// agreeSyncValue saves the value to the sync dir file unless it's there
+// already. It returns the value the file holds.
+func (r *PhysRestore) agreeSyncValue(name, v string) (string, error) {
+	f := path.Join(pbm.PhysRestoresDir, r.name, name)
+	err := storage.SaveIfNotExists(r.stg, f, strings.NewReader(v), -1)
+	if err == nil {
+		return v, nil
+	}
+	if !errors.Is(err, storage.ErrExist) {
+		return "", errors.Wrap(err, "save")
+	}
 
-	_, err := r.stg.FileStat(file)
-	// compare with restore start if heartbeat files are yet to be created.
-	// basically wait another hbFrameSec*2 sec for heartbeat files.
-	if errors.Is(err, storage.ErrNotExist) {
-		if r.startTS+hbFrameSec*2 < ts {
-			return errors.Errorf("stuck, last beat ts: %d", r.startTS)
-		}
-		return nil
+	rdr, err := r.stg.SourceReader(f)
+	if err != nil {
+		return "", errors.Wrap(err, "get")
 	}
+	defer rdr.Close()
+	b, err := io.ReadAll(rdr)
 	if err != nil {
-		return errors.Wrap(err, "get file stat")
+		return "", errors.Wrap(err, "read")
 	}
 
-	f, err := r.stg.SourceReader(file)
+	return string(b), nil
+}
+
+// isClusterLeader tells if the node coordinates the cluster-wide steps of
+// the sync protocol: it's the primary of the designated leader replset or,
+// by default, the config server primary.
+func (r *PhysRestore) isClusterLeader() bool {
+	if r.leaderRS == "" {
+		return r.nodeInfo.IsClusterLeader()
+	}
+	return r.nodeInfo.IsPrimary && r.nodeInfo.Me == r.nodeInfo.Primary && r.inLeaderRS()
+}
+
+// inLeaderRS tells if the node belongs to the leader replset. The config
+// server metadata is rewritten on the config server nodes regardless.
+func (r *PhysRestore) inLeaderRS() bool {
+	if r.leaderRS == "" {
+		return r.nodeInfo.IsConfigSrv()
+	}
+	return r.nodeInfo.SetName == r.leaderRS
+}
+
+// syncRosterFile is the roster of the replset, see pbm.RestoreReplset.Roster
+const syncRosterFile = "roster.json"
+
+// writeRoster saves the replset roster into the sync dir right on the start,
+// so it's there even if the node fails later
+func (r *PhysRestore) writeRoster() error {
+	agents, err := r.cn.AgentsStatus()
+	if err != nil {
+		return errors.Wrap(err, "get agents")
+	}
+	ct, err := r.cn.ClusterTime()
 	if err != nil {
-		return errors.Wrap(err, "get hb file")
+		return errors.Wrap(err, "get cluster time")
 	}
 
-	b, err := ioutil.ReadAll(f)
+	b, err := json.Marshal(physRoster(r.rsConf.Members, liveAgents(agents, r.nodeInfo.SetName, ct)))
 	if err != nil {
-		return errors.Wrap(err, "read content")
+		return errors.Wrap(err, "encode")
+	}
+
+	return r.stg.Save(fmt.Sprintf("%s/%s/rs.%s/%s", pbm.PhysRestoresDir, r.name, r.rsConf.ID, syncRosterFile),
+		bytes.NewReader(b), int64(len(b)))
+}
+
+// liveAgents returns the nodes of the replset `rs` with agents which
+// heartbeat isn't stale at the cluster time `ct`. Status documents of
+// the gone agents stay until collected and mustn't count.
+func liveAgents(agents []pbm.AgentStat, rs string, ct primitive.Timestamp) map[string]bool {
+	live := make(map[string]bool)
+	for _, a := range agents {
+		if a.RS == rs && !a.IsStale(ct) {
+			live[a.Node] = true
+		}
+	}
+	return live
+}
+
+// physRoster tells which members take part in the restore. Arbiters have
+// no data to restore. The rest are expected to be restored, members with
+// no live agent are marked so it's clear why the restore might fail on them.
+func physRoster(members []pbm.RSMember, live map[string]bool) []pbm.RosterNode {
+	rv := make([]pbm.RosterNode, 0, len(members))
+	for _, m := range members {
+		n := pbm.RosterNode{Name: m.Host, Participation: pbm.RosterParticipated}
+		switch {
+		case m.ArbiterOnly:
+			n.Participation = pbm.RosterSkippedArbiter
+		case !live[m.Host]:
+			n.Participation = pbm.RosterMissingAgent
+		}
+		rv = append(rv, n)
+	}
+	return rv
+}
+
+// hb writes the node's heartbeat object (see pbm.PhysRestoreBeat) and,
+// on the cluster leader, the cluster heartbeat. It's skipped if the last
+// one was written less than half the frame ago.
+func (r *PhysRestore) hb() error {
+	now := time.Now()
+	if !r.lastHB.IsZero() && now.Sub(r.lastHB) < time.Second*hbFrameSec/2 {
+		return nil
+	}
+
+	ts := now.Unix()
+	phase, done, total := r.progress.get()
+	b, err := json.Marshal(pbm.PhysRestoreBeat{
+		Node:  ts,
+		RS:    ts,
+		Phase: phase,
+		Done:  done,
+		Total: total,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	err = r.stg.Save(r.syncPathBeat, bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return errors.Wrap(err, "write hb")
+	}
+	if r.isClusterLeader() {
+		err = r.stg.Save(pbm.PhysRestoreClusterHbPath(r.name), okStatus(), -1)
+		if err != nil {
+			return errors.Wrap(err, "write cluster hb")
+		}
+	}
+	r.lastHB = now
+
+	return nil
+}
+
+// checkHB checks the heartbeat of the sync object (node, rs or cluster).
+// The heartbeat isn't read again while the last one seen stays fresh for
+// at least another frame.
+func (r *PhysRestore) checkHB(obj string) error {
+	ts := time.Now().Unix()
+
+	if t, ok := r.hbSeen[obj]; ok && t+hbFrameSec >= ts {
+		return nil
 	}
 
-	t, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 0)
+	t, err := pbm.LastPhysRestoreHb(r.stg, r.name, strings.TrimPrefix(obj, path.Join(pbm.PhysRestoresDir, r.name)+"/"))
 	if err != nil {
-		return errors.Wrap(err, "decode")
+		return errors.Wrap(err, "read hb")
+	}
+	// compare with restore start if heartbeat files are yet to be created.
+	// basically wait another hbFrameSec*2 sec for heartbeat files.
+	if t == 0 {
+		if r.startTS+hbFrameSec*2 < ts {
+			return errors.Errorf("stuck, last beat ts: %d", r.startTS)
+		}
+		return nil
 	}
 
 	if t+hbFrameSec*2 < ts {
 		return errors.Errorf("stuck, last beat ts: %d", t)
 	}
+	if r.hbSeen == nil {
+		r.hbSeen = make(map[string]int64)
+	}
+	r.hbSeen[obj] = t
 
 	return nil
 }
@@ -1425,10 +2147,14 @@ func (r *PhysRestore) setTmpConf() (err error) {
 	opts.Storage.DBpath = r.dbpath
 	opts.Security = r.secOpts
 
-	r.tmpConf, err = os.CreateTemp("", "pbmMongdTmpConf")
+	r.tmpConf, err = os.CreateTemp("", "pbmMongdTmpConf."+nodeFileName(r.nodeInfo.Me)+".")
 	if err != nil {
 		return errors.Wrap(err, "create tmp config")
 	}
+	tmpConf := r.tmpConf.Name()
+	r.cleanup.add("tmp conf", cleanupAlways, func() error {
+		return errors.Wrapf(os.Remove(tmpConf), "remove tmp config %s", tmpConf)
+	})
 	defer r.tmpConf.Close()
 
 	enc := yaml.NewEncoder(r.tmpConf)
@@ -1476,10 +2202,15 @@ func (r *PhysRestore) setBcpFiles() (err error) {
 	}
 
 	for {
+		if !isSupportedCompression(bcp.Compression) {
+			return errors.Errorf("unsupported compression %s in backup %s", bcp.Compression, bcp.Name)
+		}
+
 		data := files{
 			BcpName: bcp.Name,
 			Cmpr:    bcp.Compression,
 			Data:    []pbm.File{},
+			layout:  bcp.Layout,
 		}
 		// PBM-1058
 		var is1058 bool
@@ -1546,6 +2277,13 @@ func (r *PhysRestore) setBcpFiles() (err error) {
 	return nil
 }
 
+// isSupportedCompression checks if the given compression can be decoded by
+// the current build. Empty compression means the backup was made by the older
+// PBM version which didn't compress physical files.
+func isSupportedCompression(c compress.CompressionType) bool {
+	return c == "" || compress.IsValidCompressionType(string(c))
+}
+
 // Checks if dbpath exists in the file name (affected by PBM-1058) and
 // returns it.
 // We suppose that "journal" will always be present in the backup and it is
@@ -1604,29 +2342,47 @@ func (r *PhysRestore) prepareBackup(backupName string) (err error) {
 		return errors.Errorf("backup version (v%s) is not compatible with PBM v%s", r.bcp.PBMVersion, version.DefaultInfo.Version)
 	}
 
+	cfg, err := r.cn.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "get pbm config")
+	}
+	r.bcpStg, _, err = pbm.BackupStorage(cfg, r.bcp, r.log)
+	if err != nil {
+		return errors.Wrap(err, "get backup storage")
+	}
+
 	mgoV, err := r.node.GetMongoVersion()
 	if err != nil || len(mgoV.Version) < 1 {
 		return errors.Wrap(err, "define mongo version")
 	}
 
-	if semver.Compare(majmin(r.bcp.MongoVersion), majmin(mgoV.VersionString)) != 0 {
-		return errors.Errorf("backup's Mongo version (%s) is not compatible with Mongo %s", r.bcp.MongoVersion, mgoV.VersionString)
+	bcpVer := r.bcp.MongoVersion
+	if rs := getRS(r.bcp, pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)); rs != nil && rs.MongoVersion != "" {
+		bcpVer = rs.MongoVersion
+	}
+	if semver.Compare(majmin(bcpVer), majmin(mgoV.VersionString)) != 0 {
+		return errors.Errorf("backup's Mongo version (%s) is not compatible with Mongo %s", bcpVer, mgoV.VersionString)
 	}
 
-	mv, err := r.checkMongod(r.bcp.MongoVersion)
+	mv, err := r.checkMongod(bcpVer)
 	if err != nil {
 		return errors.Wrap(err, "check mongod binary")
 	}
 	r.log.Debug("mongod binary: %s, version: %s", r.mongod, mv)
 
-	err = r.setBcpFiles()
+	s, err := r.cn.ClusterMembers()
 	if err != nil {
-		return errors.Wrap(err, "get data for restore")
+		return errors.Wrap(err, "get cluster members")
 	}
 
-	s, err := r.cn.ClusterMembers()
+	err = checkRSMapTargets(r.rsMap, s)
 	if err != nil {
-		return errors.Wrap(err, "get cluster members")
+		return err
+	}
+
+	err = r.setBcpFiles()
+	if err != nil {
+		return errors.Wrap(err, "get data for restore")
 	}
 
 	mapRevRS := pbm.MakeReverseRSMapFunc(r.rsMap)
@@ -1636,6 +2392,18 @@ func (r *PhysRestore) prepareBackup(backupName string) (err error) {
 		fl[mapRevRS(rs.RS)] = rs
 		r.syncPathShards[fmt.Sprintf("%s/%s/rs.%s/rs", pbm.PhysRestoresDir, r.name, rs.RS)] = struct{}{}
 	}
+	if r.leaderRS != "" {
+		if _, ok := r.syncPathShards[fmt.Sprintf("%s/%s/rs.%s/rs", pbm.PhysRestoresDir, r.name, r.leaderRS)]; !ok {
+			return errors.Errorf("leader replset %s not found in cluster", r.leaderRS)
+		}
+		if getRS(r.bcp, mapRevRS(r.leaderRS)) == nil {
+			return errors.Errorf("no data for the leader replset %s in backup", r.leaderRS)
+		}
+		if r.inLeaderRS() {
+			r.syncPathDataShards = copyMap(r.syncPathShards)
+			delete(r.syncPathDataShards, r.syncPathRS)
+		}
+	}
 
 	var nors []string
 	for _, sh := range r.bcp.Replsets {
@@ -1648,43 +2416,81 @@ func (r *PhysRestore) prepareBackup(backupName string) (err error) {
 		return errors.Errorf("extra/unknown replica set found in the backup: %s", strings.Join(nors, ","))
 	}
 
-	setName := mapRevRS(r.nodeInfo.SetName)
-	var ok bool
-	for _, v := range r.bcp.Replsets {
-		if v.Name == setName {
-			ok = true
-			break
+	absent, err := shardsNotInBackup(s, r.bcp, mapRevRS, r.confOpts.StrictShardCoverage)
+	if err != nil {
+		return err
+	}
+	if len(absent) != 0 {
+		r.log.Warning("no data in the backup for shards %v: they're left out of the restore", absent)
+		for _, rs := range absent {
+			p := fmt.Sprintf("%s/%s/rs.%s/rs", pbm.PhysRestoresDir, r.name, rs)
+			delete(r.syncPathShards, p)
+			delete(r.syncPathDataShards, p)
 		}
 	}
-	if !ok {
+
+	setName := mapRevRS(r.nodeInfo.SetName)
+	rsMeta := getRS(r.bcp, setName)
+	if rsMeta == nil {
 		if r.nodeInfo.IsLeader() {
 			return errors.New("no data for the config server or sole rs in backup")
 		}
 		return ErrNoDataForShard
 	}
 
-	return nil
+	pl, err := r.node.GetPlatform()
+	if err != nil {
+		return errors.Wrap(err, "get platform")
+	}
+
+	return checkPlatform(rsMeta.Platform, pl, r.allowPlatformMismatch, r.log)
 }
 
-// ensure mongod for internal restarts is available and matches
-// the backup's version
-func (r *PhysRestore) checkMongod(needVersion string) (version string, err error) {
-	cmd := exec.Command(r.mongod, "--version")
+// checkPlatform fails if the backup is made on the platform known to be
+// incompatible with the target one unless the mismatch is explicitly
+// allowed. Other mismatches are only logged.
+func checkPlatform(bcp, target *pbm.Platform, allow bool, l *log.Event) error {
+	var unsafe []string
+	for _, m := range pbm.CheckPlatform(bcp, target) {
+		if !m.Unsafe || allow {
+			l.Warning("platform mismatch: %s", m)
+			continue
+		}
+		unsafe = append(unsafe, m.String())
+	}
 
-	stderr := new(bytes.Buffer)
-	stdout := new(bytes.Buffer)
+	if len(unsafe) > 0 {
+		return errors.Errorf("backup platform %s is incompatible with %s: %s. "+
+			"Use --allow-platform-mismatch to restore anyway", bcp, target, strings.Join(unsafe, "; "))
+	}
 
-	cmd.Stderr = stderr
-	cmd.Stdout = stdout
+	return nil
+}
 
-	err = cmd.Run()
-	if err != nil {
-		return "", errors.Errorf("run: %v. stderr: %s", err, stderr)
+// checkRSMapTargets ensures every target replset of the mapping is a member
+// of the cluster. Otherwise, the restore would hang waiting for status files
+// of a non-existent replset.
+func checkRSMapTargets(rsMap map[string]string, members []pbm.Shard) error {
+	rss := make(map[string]struct{}, len(members))
+	for _, m := range members {
+		rss[m.RS] = struct{}{}
 	}
 
-	_, v, ok := strings.Cut(strings.Split(stdout.String(), "\n")[0], "db version ")
-	if !ok {
-		return "", errors.Errorf("parse version from output %s", stdout.String())
+	for _, target := range rsMap {
+		if _, ok := rss[target]; !ok {
+			return errors.Errorf("rsMap target %s not found in cluster", target)
+		}
+	}
+
+	return nil
+}
+
+// ensure mongod for internal restarts is available and matches
+// the backup's version
+func (r *PhysRestore) checkMongod(needVersion string) (version string, err error) {
+	v, err := r.runner.Version(r.mongod)
+	if err != nil {
+		return "", err
 	}
 
 	if semver.Compare(majmin(needVersion), majmin(v)) != 0 {
@@ -1725,37 +2531,67 @@ func (r *PhysRestore) MarkFailed(meta *pbm.RestoreMeta, e error, markCluster boo
 			r.log.Error("MarkFailed: write replset error state `%v`: %v", e, serr)
 		}
 	}
-	if r.nodeInfo.IsClusterLeader() && markCluster {
+	if r.isClusterLeader() && markCluster {
 		serr := r.stg.Save(r.syncPathCluster+"."+string(pbm.StatusError),
 			errStatus(e), -1)
 		if serr != nil {
 			r.log.Error("MarkFailed: write cluster error state `%v`: %v", e, serr)
 		}
 	}
+	// let the rest of the cluster know it shouldn't proceed
+	if r.isCanary() && r.nodeInfo.IsPrimary {
+		serr := r.stg.Save(r.syncPathCanary+"."+string(pbm.StatusError),
+			errStatus(e), -1)
+		if serr != nil {
+			r.log.Error("MarkFailed: write canary error state `%v`: %v", e, serr)
+		}
+	}
 }
 
-func removeAll(dir string, l *log.Event) error {
-	d, err := os.Open(dir)
-	if err != nil {
-		return errors.Wrap(err, "open dir")
-	}
-	defer d.Close()
+func (r *PhysRestore) isCanary() bool {
+	return r.confOpts.CanaryShard != "" && r.confOpts.CanaryShard == r.nodeInfo.SetName
+}
 
-	names, err := d.Readdirnames(-1)
-	if err != nil {
-		return errors.Wrap(err, "read file names")
-	}
-	for _, n := range names {
-		if n == internalMongodLog {
-			continue
+// waitCanary waits for the canary replset to finish its restore. It returns
+// an error if the canary failed or got stuck. In this case, the node should
+// bail out before touching its data.
+func (r *PhysRestore) waitCanary() error {
+	canaryRS := fmt.Sprintf("%s/%s/rs.%s/rs", pbm.PhysRestoresDir, r.name, r.confOpts.CanaryShard)
+
+	for {
+		time.Sleep(retry.Jitter(syncPollInterval))
+
+		err := r.checkDeadline()
+		if err != nil {
+			return err
+		}
+
+		errFile, err := pbm.FindStatusFile(r.stg, r.syncPathCanary+"."+string(pbm.StatusError))
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return errors.Wrapf(err, "check file %s", r.syncPathCanary+"."+string(pbm.StatusError))
 		}
-		err = os.RemoveAll(filepath.Join(dir, n))
+		if err == nil {
+			b, err := pbm.ReadStatusFile(r.stg, errFile)
+			if err != nil {
+				return errors.Wrapf(err, "read error file %s", errFile)
+			}
+			return errors.Errorf("canary replset %s failed: %s", r.confOpts.CanaryShard, b)
+		}
+
+		ok, err := checkFile(r.syncPathCanary+"."+string(pbm.StatusDone), r.stg)
 		if err != nil {
-			return errors.Wrapf(err, "remove '%s'", n)
+			return errors.Wrapf(err, "check file %s", r.syncPathCanary+"."+string(pbm.StatusDone))
+		}
+		if ok {
+			r.log.Info("canary replset %s succeed", r.confOpts.CanaryShard)
+			return nil
+		}
+
+		err = r.checkHB(canaryRS)
+		if err != nil {
+			return errors.Wrapf(err, "canary replset %s", r.confOpts.CanaryShard)
 		}
-		l.Debug("remove %s", filepath.Join(dir, n))
 	}
-	return nil
 }
 
 func majmin(v string) string {
@@ -1769,3 +2605,21 @@ func majmin(v string) string {
 
 	return semver.MajorMinor(v)
 }
+
+// shardsNotInBackup returns the live replsets the backup has no data for.
+// Their nodes leave the restore with ErrNoDataForShard, so nobody should
+// wait for them. With `strict` it's an error instead.
+func shardsNotInBackup(live []pbm.Shard, bcp *pbm.BackupMeta, mapRevRS pbm.RSMapFunc, strict bool) ([]string, error) {
+	var rv []string
+	for _, sh := range live {
+		if getRS(bcp, mapRevRS(sh.RS)) == nil {
+			rv = append(rv, sh.RS)
+		}
+	}
+
+	if len(rv) != 0 && strict {
+		return nil, errors.Errorf("no data in the backup for shards %s (restore.strictShardCoverage is on)",
+			strings.Join(rv, ","))
+	}
+	return rv, nil
+}
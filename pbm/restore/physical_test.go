@@ -0,0 +1,904 @@
+package restore
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+// Two agents on the same host (different mongod instances) should not
+// clash on host-local resources during a physical restore.
+func TestPhysRestoreMultipleAgentsPerHost(t *testing.T) {
+	dir := t.TempDir()
+	conf := pbm.RestoreConf{
+		TmpPortRangeMap: map[string]string{
+			"host:27017": "28100-28149",
+			"host:27018": "28150-28199",
+		},
+	}
+
+	rs := []*PhysRestore{
+		{
+			dbpath:   filepath.Join(dir, "rs1"),
+			port:     27017,
+			nodeInfo: &pbm.NodeInfo{Me: "host:27017"},
+			confOpts: conf,
+		},
+		{
+			dbpath:   filepath.Join(dir, "rs2"),
+			port:     27018,
+			nodeInfo: &pbm.NodeInfo{Me: "host:27018"},
+			confOpts: conf,
+		},
+	}
+
+	logs := make(map[string]struct{})
+	ports := make(map[int]struct{})
+	for _, r := range rs {
+		from, to, err := r.tmpPortRange()
+		if err != nil {
+			t.Fatalf("%s: tmp port range: %v", r.nodeInfo.Me, err)
+		}
+		p, err := peekTmpPort(from, to)
+		if err != nil {
+			t.Fatalf("%s: peek tmp port: %v", r.nodeInfo.Me, err)
+		}
+		if p < from || p > to {
+			t.Errorf("%s: port %d is out of range [%d, %d]", r.nodeInfo.Me, p, from, to)
+		}
+		ports[p] = struct{}{}
+
+		lp := r.internalLogPath()
+		if !isInternalLog(filepath.Base(lp)) {
+			t.Errorf("%s: %s isn't recognized as internal log", r.nodeInfo.Me, lp)
+		}
+		logs[filepath.Base(lp)] = struct{}{}
+	}
+
+	if len(ports) != len(rs) {
+		t.Errorf("agents got the same tmp port: %v", ports)
+	}
+	if len(logs) != len(rs) {
+		t.Errorf("agents got the same internal log name: %v", logs)
+	}
+
+	// internal logs should survive dbpath clean-up
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	for _, r := range rs {
+		if err := os.MkdirAll(r.dbpath, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []string{r.internalLogPath(), filepath.Join(r.dbpath, "WiredTiger")} {
+			if err := os.WriteFile(f, []byte("x"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := removeAll(r.dbpath, DefaultDBPathIgnore, l); err != nil {
+			t.Fatalf("%s: remove all: %v", r.nodeInfo.Me, err)
+		}
+		names, err := os.ReadDir(r.dbpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 1 || names[0].Name() != filepath.Base(r.internalLogPath()) {
+			t.Errorf("%s: unexpected files after clean-up: %v", r.nodeInfo.Me, names)
+		}
+	}
+}
+
+func TestTmpPortRangeDefault(t *testing.T) {
+	r := &PhysRestore{port: 27017, nodeInfo: &pbm.NodeInfo{Me: "host:27017"}}
+	from, to, err := r.tmpPortRange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 27018 || to != 27017+defaultTmpPortRange {
+		t.Errorf("got [%d, %d]", from, to)
+	}
+
+	r.confOpts.TmpPortRange = "30000-30010"
+	from, to, err = r.tmpPortRange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 30000 || to != 30010 {
+		t.Errorf("got [%d, %d]", from, to)
+	}
+
+	for _, s := range []string{"30010-30000", "abc", "1-70000", "30000"} {
+		r.confOpts.TmpPortRange = s
+		if _, _, err := r.tmpPortRange(); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestCheckDeadline(t *testing.T) {
+	r := &PhysRestore{}
+	if err := r.checkDeadline(); err != nil {
+		t.Errorf("no deadline: unexpected error %v", err)
+	}
+
+	r.confOpts.MaxRestoreDurationMin = 1
+	r.deadline = time.Now().Add(time.Minute)
+	if err := r.checkDeadline(); err != nil {
+		t.Errorf("deadline ahead: unexpected error %v", err)
+	}
+
+	r.deadline = time.Now().Add(-time.Second)
+	if err := r.checkDeadline(); !errors.Is(err, ErrRestoreTimeout) {
+		t.Errorf("deadline passed: expected %v, got %v", ErrRestoreTimeout, err)
+	}
+}
+
+type fakeMongod struct {
+	calls []string
+	opts  []MongodRunOpts
+	// errors returned by the subsequent WaitReady calls
+	readyErrs []error
+	client    *mongo.Client
+	version   string
+}
+
+func (m *fakeMongod) Start(opts MongodRunOpts) error {
+	m.calls = append(m.calls, "start")
+	m.opts = append(m.opts, opts)
+	return nil
+}
+
+func (m *fakeMongod) Run(opts MongodRunOpts) error {
+	m.calls = append(m.calls, "run")
+	m.opts = append(m.opts, opts)
+	return nil
+}
+
+func (m *fakeMongod) WaitReady(time.Duration) (*mongo.Client, error) {
+	m.calls = append(m.calls, "ready")
+	if len(m.readyErrs) > 0 {
+		err := m.readyErrs[0]
+		m.readyErrs = m.readyErrs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m.client, nil
+}
+
+func (m *fakeMongod) Shutdown(*mongo.Client) error {
+	m.calls = append(m.calls, "shutdown")
+	return nil
+}
+
+func (m *fakeMongod) Version(string) (string, error) {
+	m.calls = append(m.calls, "version")
+	return m.version, nil
+}
+
+func newTestPhysRestore(t *testing.T, m *fakeMongod) *PhysRestore {
+	conf, err := os.CreateTemp(t.TempDir(), "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Close()
+
+	return &PhysRestore{
+		dbpath:   "/data/db",
+		tmpPort:  28000,
+		tmpConf:  conf,
+		mongod:   "/usr/bin/mongod",
+		nodeInfo: &pbm.NodeInfo{Me: "host:27017"},
+		bcp:      &pbm.BackupMeta{},
+		runner:   m,
+		log:      log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{}),
+	}
+}
+
+// disconnectedClient returns a client that fails any operation,
+// so the data manipulation steps error out right away
+func disconnectedClient(t *testing.T) *mongo.Client {
+	c, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPhysRestoreMongodRuns(t *testing.T) {
+	errConn := errors.New("connection refused")
+
+	cases := []struct {
+		name      string
+		run       func(r *PhysRestore) error
+		params    []string
+		repair    bool
+		readyErrs []error
+		calls     []string
+		err       string
+		errIs     error
+	}{
+		{
+			name:   "recoverStandalone",
+			run:    (*PhysRestore).recoverStandalone,
+			params: []string{"recoverFromOplogAsStandalone=true", "takeUnstableCheckpointOnShutdown=true"},
+			calls:  []string{"start", "ready", "shutdown"},
+		},
+		{
+			name:      "recoverStandalone retry",
+			run:       (*PhysRestore).recoverStandalone,
+			params:    []string{"recoverFromOplogAsStandalone=true", "takeUnstableCheckpointOnShutdown=true"},
+			readyErrs: []error{errConn, errConn},
+			calls:     []string{"start", "ready", "ready", "ready", "shutdown"},
+		},
+		{
+			name:      "recoverStandalone tries exhausted",
+			run:       (*PhysRestore).recoverStandalone,
+			params:    []string{"recoverFromOplogAsStandalone=true", "takeUnstableCheckpointOnShutdown=true"},
+			readyErrs: []error{errConn, errConn, errConn, errConn, errConn, errConn},
+			calls:     []string{"start", "ready", "ready", "ready", "ready", "ready"},
+			errIs:     errConn,
+		},
+		{
+			name:      "prepareData mongod failed",
+			run:       (*PhysRestore).prepareData,
+			params:    []string{"disableLogicalSessionCacheRefresh=true"},
+			readyErrs: []error{errConn, errors.Wrap(ErrMongodFailed, "[F] fatal")},
+			calls:     []string{"start", "ready", "ready"},
+			errIs:     ErrMongodFailed,
+		},
+		{
+			name:   "prepareData",
+			run:    (*PhysRestore).prepareData,
+			params: []string{"disableLogicalSessionCacheRefresh=true"},
+			calls:  []string{"start", "ready"},
+			err:    "drop replset.minvalid",
+		},
+		{
+			name:   "salvageData",
+			run:    (*PhysRestore).salvageData,
+			repair: true,
+			calls:  []string{"run"},
+		},
+		{
+			name:   "resetRS",
+			run:    (*PhysRestore).resetRS,
+			params: []string{"disableLogicalSessionCacheRefresh=true", "skipShardingConfigurationChecks=true"},
+			calls:  []string{"start", "ready"},
+			err:    "update shardIdentity",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &fakeMongod{readyErrs: c.readyErrs, client: disconnectedClient(t)}
+			r := newTestPhysRestore(t, m)
+
+			err := c.run(r)
+			switch {
+			case c.errIs != nil:
+				if !errors.Is(err, c.errIs) {
+					t.Errorf("expected error %v, got %v", c.errIs, err)
+				}
+			case c.err != "":
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("expected error %q, got %v", c.err, err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error %v", err)
+			}
+
+			if !reflect.DeepEqual(m.calls, c.calls) {
+				t.Errorf("expected calls %v, got %v", c.calls, m.calls)
+			}
+
+			want := MongodRunOpts{
+				Bin:     r.mongod,
+				DBpath:  r.dbpath,
+				Port:    r.tmpPort,
+				Conf:    r.tmpConf.Name(),
+				LogPath: r.internalLogPath(),
+				Params:  c.params,
+				Repair:  c.repair,
+			}
+			if len(m.opts) != 1 || !reflect.DeepEqual(m.opts[0], want) {
+				t.Errorf("expected start opts %+v, got %+v", want, m.opts)
+			}
+		})
+	}
+}
+
+func TestMongodRunOptsArgs(t *testing.T) {
+	o := MongodRunOpts{
+		DBpath:  "/data/db",
+		Conf:    "/tmp/conf",
+		LogPath: "/data/db/pbm.restore.log",
+		Params:  []string{"a=1", "b=2"},
+	}
+	want := []string{
+		"--dbpath", "/data/db",
+		"--setParameter", "a=1",
+		"--setParameter", "b=2",
+		"-f", "/tmp/conf",
+		"--logpath", "/data/db/pbm.restore.log",
+	}
+	if got := o.Args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	o.Repair = true
+	want = append(want[:len(want)-2:len(want)-2], "--repair", "--logpath", "/data/db/pbm.restore.log")
+	if got := o.Args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("repair: expected %v, got %v", want, got)
+	}
+}
+
+func TestCheckMongod(t *testing.T) {
+	m := &fakeMongod{version: "v6.0.5"}
+	r := newTestPhysRestore(t, m)
+
+	if _, err := r.checkMongod("6.0.2"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := r.checkMongod("5.0.14"); err == nil {
+		t.Error("expected version mismatch error")
+	}
+}
+
+// memStorage is an in-memory storage with conditional writes
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[string][]byte)}
+}
+
+func (*memStorage) Type() storage.Type { return storage.Undef }
+
+func (s *memStorage) Save(name string, data io.Reader, _ int64) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = b
+	return nil
+}
+
+func (s *memStorage) SaveIfNotExists(name string, data io.Reader, _ int64) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; ok {
+		return storage.ErrExist
+	}
+	s.files[name] = b
+	return nil
+}
+
+func (s *memStorage) SourceReader(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[name]
+	if !ok {
+		return nil, storage.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *memStorage) FileStat(name string) (storage.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[name]
+	if !ok {
+		return storage.FileInfo{}, storage.ErrNotExist
+	}
+	if len(b) == 0 {
+		return storage.FileInfo{}, storage.ErrEmpty
+	}
+	return storage.FileInfo{Name: name, Size: int64(len(b)), ETag: fmt.Sprintf("%x", md5.Sum(b))}, nil
+}
+
+func (s *memStorage) List(prefix, suffix string) ([]storage.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rv []storage.FileInfo
+	for name, b := range s.files {
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			rv = append(rv, storage.FileInfo{Name: strings.TrimPrefix(name, prefix+"/"), Size: int64(len(b))})
+		}
+	}
+	return rv, nil
+}
+
+func (s *memStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, name)
+	return nil
+}
+
+func (s *memStorage) Copy(src, dst string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[dst] = s.files[src]
+	return nil
+}
+
+// Two nodes considering themselves primaries (e.g. stepdown mid-restore)
+// should not both write the replset status.
+func TestWriteLeaderStatusRace(t *testing.T) {
+	stgs := map[string]storage.Storage{
+		"conditional": newMemStorage(),
+		"filesystem":  fs.New(fs.Conf{Path: t.TempDir()}),
+	}
+
+	for name, stg := range stgs {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				rname := fmt.Sprintf("restore-%d", i)
+				rs := make([]*PhysRestore, 2)
+				for j := range rs {
+					rs[j] = &PhysRestore{
+						name:       rname,
+						stg:        stg,
+						nodeInfo:   &pbm.NodeInfo{Me: fmt.Sprintf("host:%d", 27017+j), IsPrimary: true},
+						syncPathRS: fmt.Sprintf("%s/%s/rs.rs1/rs", pbm.PhysRestoresDir, rname),
+						log:        log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{}),
+					}
+				}
+
+				var wg sync.WaitGroup
+				errs := make([]error, len(rs))
+				for j, r := range rs {
+					wg.Add(1)
+					go func(j int, r *PhysRestore, cstat pbm.Status) {
+						defer wg.Done()
+						errs[j] = r.writeLeaderStatus(r.syncPathRS, pbm.StatusRunning, cstat)
+					}(j, r, []pbm.Status{pbm.StatusRunning, pbm.StatusError}[j])
+				}
+				wg.Wait()
+
+				for j, err := range errs {
+					if err != nil {
+						t.Fatalf("node %d: unexpected error %v", j, err)
+					}
+				}
+
+				var written []string
+				for _, s := range []pbm.Status{pbm.StatusRunning, pbm.StatusError} {
+					f := rs[0].syncPathRS + "." + string(s)
+					if _, err := stg.FileStat(f); err == nil {
+						written = append(written, f)
+					}
+				}
+				if len(written) != 1 {
+					t.Fatalf("expected exactly one rs status written, got %v", written)
+				}
+
+				ok, err := rs[0].claimStatus(rs[0].syncPathRS, pbm.StatusRunning)
+				if err != nil || ok {
+					t.Fatalf("repeated claim: expected lost claim, got %v, %v", ok, err)
+				}
+
+				// `done` is written by every node, even with its claim taken
+				_, err = rs[1].claimStatus(rs[1].syncPathRS, pbm.StatusDone)
+				if err != nil {
+					t.Fatalf("claim done: unexpected error %v", err)
+				}
+				err = rs[0].writeLeaderStatus(rs[0].syncPathRS, pbm.StatusDone, pbm.StatusDone)
+				if err != nil {
+					t.Fatalf("write done: unexpected error %v", err)
+				}
+				if _, err := stg.FileStat(rs[0].syncPathRS + "." + string(pbm.StatusDone)); err != nil {
+					t.Fatalf("done status is not written: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckRSMapTargets(t *testing.T) {
+	members := []pbm.Shard{{RS: "cfg"}, {RS: "rs1"}, {RS: "rs2"}}
+
+	if err := checkRSMapTargets(nil, members); err != nil {
+		t.Errorf("no mapping: unexpected error %v", err)
+	}
+	if err := checkRSMapTargets(map[string]string{"src1": "rs1", "src2": "rs2"}, members); err != nil {
+		t.Errorf("valid mapping: unexpected error %v", err)
+	}
+
+	err := checkRSMapTargets(map[string]string{"src1": "rs1", "src2": "rs3"}, members)
+	if err == nil || err.Error() != "rsMap target rs3 not found in cluster" {
+		t.Errorf("wrong mapping: unexpected error %v", err)
+	}
+}
+
+func TestPhysRoster(t *testing.T) {
+	members := []pbm.RSMember{
+		{Host: "rs101:27017"},
+		{Host: "rs102:27017"},
+		{Host: "rs103:27017", ArbiterOnly: true},
+	}
+
+	got := physRoster(members, map[string]bool{"rs101:27017": true})
+	want := []pbm.RosterNode{
+		{Name: "rs101:27017", Participation: pbm.RosterParticipated},
+		{Name: "rs102:27017", Participation: pbm.RosterMissingAgent},
+		{Name: "rs103:27017", Participation: pbm.RosterSkippedArbiter},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestLiveAgents(t *testing.T) {
+	ct := primitive.Timestamp{T: 1675000100}
+	agents := []pbm.AgentStat{
+		{RS: "rs1", Node: "rs101:27017", Heartbeat: primitive.Timestamp{T: 1675000095}},
+		{RS: "rs1", Node: "rs102:27017", Heartbeat: primitive.Timestamp{T: 1675000000}},
+		{RS: "rs2", Node: "rs201:27017", Heartbeat: primitive.Timestamp{T: 1675000095}},
+	}
+
+	got := liveAgents(agents, "rs1", ct)
+	want := map[string]bool{"rs101:27017": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCheckPlatform(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	x86 := &pbm.Platform{Arch: "x86_64", OS: "linux", Flavor: pbm.FlavorPSMDB}
+	arm := &pbm.Platform{Arch: "aarch64", OS: "linux", Flavor: pbm.FlavorPSMDB}
+
+	if err := checkPlatform(x86, arm, false, l); err == nil {
+		t.Error("expected error for x86_64 -> aarch64")
+	}
+	if err := checkPlatform(x86, arm, true, l); err != nil {
+		t.Errorf("unexpected error with the mismatch allowed: %v", err)
+	}
+	if err := checkPlatform(nil, arm, false, l); err != nil {
+		t.Errorf("unexpected error for the backup with no platform: %v", err)
+	}
+}
+
+func TestPhysRestoreLeaderRS(t *testing.T) {
+	csrsPrimary := &pbm.NodeInfo{Me: "cfg1:27017", Primary: "cfg1:27017", IsPrimary: true, SetName: "cfg", ConfigSvr: 2}
+	rs0Primary := &pbm.NodeInfo{Me: "rs01:27017", Primary: "rs01:27017", IsPrimary: true, SetName: "rs0", ConfigServerState: &pbm.ConfigServerState{}}
+	rs0Secondary := &pbm.NodeInfo{Me: "rs02:27017", Primary: "rs01:27017", SetName: "rs0", ConfigServerState: &pbm.ConfigServerState{}}
+
+	cases := []struct {
+		name     string
+		node     *pbm.NodeInfo
+		leaderRS string
+		leader   bool
+		inRS     bool
+	}{
+		{"default csrs primary", csrsPrimary, "", true, true},
+		{"default shard primary", rs0Primary, "", false, false},
+		{"designated csrs primary", csrsPrimary, "rs0", false, false},
+		{"designated shard primary", rs0Primary, "rs0", true, true},
+		{"designated shard secondary", rs0Secondary, "rs0", false, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &PhysRestore{nodeInfo: c.node, leaderRS: c.leaderRS}
+			if got := r.isClusterLeader(); got != c.leader {
+				t.Errorf("isClusterLeader: expected %v, got %v", c.leader, got)
+			}
+			if got := r.inLeaderRS(); got != c.inRS {
+				t.Errorf("inLeaderRS: expected %v, got %v", c.inRS, got)
+			}
+		})
+	}
+}
+
+// The config server metadata has to be rewritten on the CSRS nodes even if
+// they are not the leader. Operations on the disconnected client fail, so
+// the error tells which step was taken first.
+func TestResetRSConfigMetaWithLeaderRS(t *testing.T) {
+	cases := []struct {
+		name string
+		node *pbm.NodeInfo
+		err  string
+	}{
+		{
+			name: "csrs primary",
+			node: &pbm.NodeInfo{Me: "cfg1:27017", Primary: "cfg1:27017", IsPrimary: true, SetName: "cfg", ConfigSvr: 2},
+			err:  "drop config.mongos",
+		},
+		{
+			name: "csrs secondary",
+			node: &pbm.NodeInfo{Me: "cfg2:27017", Primary: "cfg1:27017", SetName: "cfg", ConfigSvr: 2},
+			err:  "drop config.mongos",
+		},
+		{
+			name: "leader shard primary",
+			node: &pbm.NodeInfo{Me: "rs01:27017", Primary: "rs01:27017", IsPrimary: true, SetName: "rs0", ConfigServerState: &pbm.ConfigServerState{}},
+			err:  "update shardIdentity",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := newTestPhysRestore(t, &fakeMongod{client: disconnectedClient(t)})
+			r.nodeInfo = c.node
+			r.leaderRS = "rs0"
+
+			err := r.resetRS()
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected error %q, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestAgreeLeaderRS(t *testing.T) {
+	stg := newMemStorage()
+	rs := make([]*PhysRestore, 3)
+	for i, d := range []string{"rs0", "rs1", ""} {
+		rs[i] = &PhysRestore{
+			name:     "restore",
+			stg:      stg,
+			leaderRS: d,
+			log:      log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{}),
+		}
+		if err := rs[i].agreeLeaderRS(); err != nil {
+			t.Fatalf("node %d: %v", i, err)
+		}
+	}
+	for i, r := range rs {
+		if r.leaderRS != "rs0" {
+			t.Errorf("node %d: expected leader rs0, got %q", i, r.leaderRS)
+		}
+	}
+}
+
+func TestAgreePartlyDonePolicy(t *testing.T) {
+	stg := newMemStorage()
+	rs := make([]*PhysRestore, 3)
+	for i, p := range []pbm.PartlyDonePolicy{"", pbm.PartlyDoneStrict, pbm.PartlyDoneQuorum} {
+		rs[i] = &PhysRestore{
+			name:       "restore",
+			stg:        stg,
+			partlyDone: p,
+			confOpts:   pbm.RestoreConf{PartlyDonePolicy: pbm.PartlyDoneQuorum},
+			log:        log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{}),
+		}
+		if err := rs[i].agreePartlyDonePolicy(); err != nil {
+			t.Fatalf("node %d: %v", i, err)
+		}
+	}
+	for i, r := range rs {
+		if r.partlyDone != pbm.PartlyDoneQuorum {
+			t.Errorf("node %d: expected the config's policy, got %q", i, r.partlyDone)
+		}
+	}
+}
+
+func TestPartlyDoneOK(t *testing.T) {
+	voters := map[string]struct{}{"a": {}, "b": {}, "c": {}}
+	cases := []struct {
+		policy pbm.PartlyDonePolicy
+		done   []string
+		ok     bool
+	}{
+		{pbm.PartlyDoneLenient, []string{"d"}, true},
+		{pbm.PartlyDoneQuorum, []string{"a", "d"}, false},
+		{pbm.PartlyDoneQuorum, []string{"a", "c"}, true},
+		{pbm.PartlyDoneStrict, []string{"a", "b"}, false},
+	}
+	for _, c := range cases {
+		r := &PhysRestore{partlyDone: c.policy, syncPathVoters: voters}
+		done := make(map[string]struct{})
+		for _, n := range c.done {
+			done[n] = struct{}{}
+		}
+		if ok := r.partlyDoneOK(done); ok != c.ok {
+			t.Errorf("%s with %v done: got %v, want %v", c.policy, c.done, ok, c.ok)
+		}
+	}
+}
+
+func TestNodeErrRejected(t *testing.T) {
+	rej := errors.Wrapf(errPartlyDoneRejected, "%s policy: %v", pbm.PartlyDoneStrict, "rs102 failed")
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nodeErr{"rs.rs1", rej.Error()}, true},
+		{errors.Wrap(nodeErr{"rs.rs1", rej.Error()}, "wait for shards"), true},
+		{nodeErr{"rs.rs1", "no space left on device"}, false},
+		{errors.Wrap(nodeErr{"rs.rs1", "check heartbeat: stuck"}, "wait for shards"), false},
+	}
+	for _, c := range cases {
+		if got := errors.Is(c.err, errPartlyDoneRejected); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+// countingStorage counts the storage requests
+type countingStorage struct {
+	*memStorage
+	saves, reads, stats, lists int
+}
+
+func (s *countingStorage) Save(name string, data io.Reader, size int64) error {
+	s.saves++
+	return s.memStorage.Save(name, data, size)
+}
+
+func (s *countingStorage) SourceReader(name string) (io.ReadCloser, error) {
+	s.reads++
+	return s.memStorage.SourceReader(name)
+}
+
+func (s *countingStorage) FileStat(name string) (storage.FileInfo, error) {
+	s.stats++
+	return s.memStorage.FileStat(name)
+}
+
+func (s *countingStorage) List(prefix, suffix string) ([]storage.FileInfo, error) {
+	s.lists++
+	return s.memStorage.List(prefix, suffix)
+}
+
+func (s *countingStorage) requests() int {
+	return s.saves + s.reads + s.stats + s.lists
+}
+
+func TestHeartbeatRequests(t *testing.T) {
+	stg := &countingStorage{memStorage: newMemStorage()}
+	nodes := []string{"rs101:27017", "rs102:27017", "rs103:27017"}
+
+	rr := make([]*PhysRestore, len(nodes))
+	for i, n := range nodes {
+		r := newTestPhysRestore(t, &fakeMongod{})
+		r.name = "rst"
+		r.stg = stg
+		r.startTS = time.Now().Unix()
+		r.nodeInfo.Me = n
+		r.syncPathBeat = pbm.PhysRestoreBeatPath("rst", "rs1", n)
+		rr[i] = r
+	}
+	// the first node is the cluster leader and gives the cluster beat
+	rr[0].nodeInfo.IsPrimary = true
+	rr[0].nodeInfo.Primary = nodes[0]
+
+	// a frame worth of heartbeats: the first one is written, the rest
+	// are within half the frame and skipped
+	for i := 0; i < 3; i++ {
+		for _, r := range rr {
+			if err := r.hb(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if stg.saves != len(nodes)+1 {
+		t.Errorf("expected %d heartbeat writes, got %d", len(nodes)+1, stg.saves)
+	}
+	// legacy: node, rs and cluster .hb files per node
+	legacyWrites := 3 * len(nodes)
+	t.Logf("heartbeat writes: %d, legacy: %d", stg.saves, legacyWrites)
+
+	// a minute of polling of the peers, replset and cluster heartbeats
+	dir := pbm.PhysRestoresDir + "/rst"
+	objs := []string{dir + "/cluster", dir + "/rs.rs1/rs"}
+	for _, n := range nodes {
+		objs = append(objs, dir+"/rs.rs1/node."+n)
+	}
+	polls := int(time.Minute / syncPollInterval)
+
+	stg.saves, stg.reads, stg.stats, stg.lists = 0, 0, 0, 0
+	for i := 0; i < polls; i++ {
+		for _, o := range objs {
+			if err := rr[0].checkHB(o); err != nil {
+				t.Fatalf("%s: %v", o, err)
+			}
+		}
+	}
+	// legacy: FileStat and read of the .hb file per object and poll
+	legacyReads := 2 * len(objs) * polls
+	t.Logf("heartbeat check requests: %d, legacy: %d", stg.requests(), legacyReads)
+	if stg.requests()*polls/2 > legacyReads {
+		t.Errorf("expected at least %dx fewer requests than legacy %d, got %d", polls/2, legacyReads, stg.requests())
+	}
+
+	// a stale beat is detected once the cached one gets old
+	stale := time.Now().Unix() - hbFrameSec*3
+	b, _ := json.Marshal(pbm.PhysRestoreBeat{Node: stale, RS: stale})
+	for _, n := range nodes {
+		stg.memStorage.Save(pbm.PhysRestoreBeatPath("rst", "rs1", n), bytes.NewReader(b), -1)
+	}
+	stg.memStorage.Save(pbm.PhysRestoreClusterHbPath("rst"), strings.NewReader(fmt.Sprint(stale)), -1)
+	rr[0].hbSeen[objs[0]] = stale
+	if err := rr[0].checkHB(objs[0]); err == nil {
+		t.Error("expected stale cluster heartbeat")
+	}
+	if err := rr[0].checkHB(objs[2]); err != nil {
+		t.Errorf("cached node heartbeat should be fresh: %v", err)
+	}
+}
+
+func TestShardsNotInBackup(t *testing.T) {
+	bcp := &pbm.BackupMeta{Replsets: []pbm.BackupReplset{{Name: "cfg"}, {Name: "rs0"}, {Name: "rs1"}}}
+	noMap := pbm.MakeReverseRSMapFunc(nil)
+
+	cases := []struct {
+		name   string
+		live   []string
+		rsMap  map[string]string
+		strict bool
+		want   []string
+		err    bool
+	}{
+		{name: "same", live: []string{"cfg", "rs0", "rs1"}},
+		{name: "live has extra", live: []string{"cfg", "rs0", "rs1", "rs2"}, want: []string{"rs2"}},
+		{name: "live has extra strict", live: []string{"cfg", "rs0", "rs1", "rs2"}, strict: true, err: true},
+		// extra backup replsets are refused separately
+		{name: "backup has extra", live: []string{"cfg", "rs0"}},
+		{name: "both have extra", live: []string{"cfg", "rs0", "rs3"}, want: []string{"rs3"}},
+		{
+			name:  "mapped",
+			live:  []string{"cfg", "new0", "rs1", "rs2"},
+			rsMap: map[string]string{"rs0": "new0"},
+			want:  []string{"rs2"},
+		},
+		{
+			name:   "mapped strict",
+			live:   []string{"cfg", "new0", "rs1"},
+			rsMap:  map[string]string{"rs0": "new0"},
+			strict: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var live []pbm.Shard
+			for _, rs := range c.live {
+				live = append(live, pbm.Shard{RS: rs})
+			}
+			mapRev := noMap
+			if c.rsMap != nil {
+				mapRev = pbm.MakeReverseRSMapFunc(c.rsMap)
+			}
+
+			got, err := shardsNotInBackup(live, bcp, mapRev, c.strict)
+			if c.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// phaseProgress is the node's restore phase and the copy progress
+// reported in its heartbeats
+type phaseProgress struct {
+	mu    sync.Mutex
+	phase string
+	done  int64
+	total int64
+}
+
+func (p *phaseProgress) setPhase(phase string) {
+	p.mu.Lock()
+	p.phase = phase
+	p.mu.Unlock()
+}
+
+func (p *phaseProgress) setTotal(n int64) {
+	p.mu.Lock()
+	p.done, p.total = 0, n
+	p.mu.Unlock()
+}
+
+func (p *phaseProgress) add(n int64) {
+	p.mu.Lock()
+	p.done += n
+	p.mu.Unlock()
+}
+
+func (p *phaseProgress) get() (phase string, done, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.phase, p.done, p.total
+}
+
+// phaseOrder is the order of the node's restore phases. The node in
+// the earliest one is the slowest.
+var phaseOrder = map[string]int{
+	"flush":                  1,
+	pbm.PhysRestorePhaseCopy: 2,
+	"salvageData":            3,
+	"prepareData":            4,
+	"recoverStandalone":      5,
+	"resetRS":                6,
+	pbm.PhysRestorePhaseWait: 7,
+}
+
+type nodeTrack struct {
+	phase string
+	// the last heartbeat seen
+	ts   int64
+	done int64
+
+	rate     float64
+	measured bool
+
+	slowSince int64
+	reported  bool
+}
+
+// progressTracker estimates the restore progress out of the nodes'
+// heartbeats. It's run by the cluster leader.
+type progressTracker struct {
+	slowFraction float64
+	slowFor      time.Duration
+
+	nodes map[string]*nodeTrack
+}
+
+func newProgressTracker(c pbm.RestoreConf) *progressTracker {
+	f, d := c.SlowNode()
+	return &progressTracker{slowFraction: f, slowFor: d, nodes: make(map[string]*nodeTrack)}
+}
+
+// update takes the nodes' heartbeats by "<rs>/<node>" and returns the
+// cluster progress and the nodes that have just been slow long enough to
+// be reported
+func (p *progressTracker) update(beats map[string]pbm.PhysRestoreBeat, now int64) (pbm.RestoreProgress, []pbm.NodeProgress) {
+	prg := pbm.RestoreProgress{TS: now}
+
+	var rates []float64
+	for name, hb := range beats {
+		n, ok := p.nodes[name]
+		if !ok {
+			n = &nodeTrack{phase: hb.Phase, ts: hb.Node, done: hb.Done}
+			p.nodes[name] = n
+		}
+		switch {
+		case n.phase != hb.Phase:
+			*n = nodeTrack{phase: hb.Phase, ts: hb.Node, done: hb.Done}
+		case hb.Node > n.ts:
+			n.rate = float64(hb.Done-n.done) / float64(hb.Node-n.ts)
+			n.measured = true
+			n.ts, n.done = hb.Node, hb.Done
+		}
+		if n.measured && n.phase == pbm.PhysRestorePhaseCopy && hb.Done < hb.Total {
+			rates = append(rates, n.rate)
+		}
+	}
+
+	var slow []pbm.NodeProgress
+	med := median(rates)
+	for name, hb := range beats {
+		n := p.nodes[name]
+		np := pbm.NodeProgress{
+			Node:  name,
+			Phase: hb.Phase,
+			Done:  hb.Done,
+			Total: hb.Total,
+			Rate:  n.rate,
+		}
+		copying := n.measured && n.phase == pbm.PhysRestorePhaseCopy && hb.Done < hb.Total
+		if copying && n.rate > 0 {
+			np.ETA = hb.Node + int64(float64(hb.Total-hb.Done)/n.rate)
+		}
+
+		// the median of a single node is its own rate
+		if copying && len(rates) > 1 && n.rate < med*p.slowFraction {
+			if n.slowSince == 0 {
+				n.slowSince = now
+			}
+			np.SlowSince = n.slowSince
+			if !n.reported && time.Duration(now-n.slowSince)*time.Second >= p.slowFor {
+				n.reported = true
+				slow = append(slow, np)
+			}
+		} else {
+			n.slowSince, n.reported = 0, false
+		}
+
+		prg.Nodes = append(prg.Nodes, np)
+	}
+	sort.Slice(prg.Nodes, func(i, j int) bool { return prg.Nodes[i].Node < prg.Nodes[j].Node })
+
+	for i := range prg.Nodes {
+		if prg.Slowest == nil || slower(prg.Nodes[i], *prg.Slowest) {
+			prg.Slowest = &prg.Nodes[i]
+		}
+	}
+	if prg.Slowest != nil {
+		prg.ETA = prg.Slowest.ETA
+	}
+
+	return prg, slow
+}
+
+// slower tells if the node a is behind the node b: it's in an earlier
+// phase or expected to finish the copy later
+func slower(a, b pbm.NodeProgress) bool {
+	if phaseOrder[a.Phase] != phaseOrder[b.Phase] {
+		return phaseOrder[a.Phase] < phaseOrder[b.Phase]
+	}
+	if a.ETA == 0 || b.ETA == 0 {
+		return a.ETA == 0 && b.ETA != 0 && a.Done < a.Total
+	}
+	return a.ETA > b.ETA
+}
+
+func median(v []float64) float64 {
+	if len(v) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), v...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+// trackProgress estimates the restore progress, writes it to the sync dir
+// and reports slow nodes. It's done by the cluster leader on its
+// heartbeats.
+func (r *PhysRestore) trackProgress() error {
+	f := path.Join(pbm.PhysRestoresDir, r.name, pbm.PhysRestoreProgressFile)
+	if r.progressTrack == nil {
+		r.progressTrack = newProgressTracker(r.confOpts)
+		// it's the progress of the running restore only
+		r.cleanup.add("progress file", cleanupAlways, func() error { return r.stg.Delete(f) })
+	}
+
+	beats, err := pbm.ReadPhysRestoreBeats(r.stg, r.name)
+	if err != nil {
+		return errors.Wrap(err, "read heartbeats")
+	}
+	prg, slow := r.progressTrack.update(beats, time.Now().Unix())
+
+	b, err := json.Marshal(prg)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	err = r.stg.Save(f, bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return errors.Wrapf(err, "write %s", f)
+	}
+
+	if s := prg.Slowest; s != nil {
+		if prg.ETA != 0 {
+			r.log.Info("progress: the slowest node %s is in %s at %.1f MB/s, ETA %v",
+				s.Node, s.Phase, s.Rate/(1<<20), time.Unix(prg.ETA, 0).UTC().Format(time.RFC3339))
+		} else {
+			r.log.Info("progress: the slowest node %s is in %s", s.Node, s.Phase)
+		}
+	}
+	for _, n := range slow {
+		r.log.Warning("node %s copies at %.1f MB/s, below %.0f%% of the cluster median since %v: "+
+			"check its disk and network", n.Node, n.Rate/(1<<20), r.progressTrack.slowFraction*100,
+			time.Unix(n.SlowSince, 0).UTC().Format(time.RFC3339))
+		err := pbm.Notify(context.Background(), r.notify, pbm.NotifyRestoreSlowNode, n)
+		if err != nil {
+			r.log.Warning("notify slow node: %v", err)
+		}
+	}
+
+	return nil
+}
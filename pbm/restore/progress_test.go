@@ -0,0 +1,85 @@
+package restore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestProgressTracker(t *testing.T) {
+	const mb = 1 << 20
+	p := newProgressTracker(pbm.RestoreConf{SlowNodeRateFraction: 0.5, SlowNodeMin: 4})
+
+	beat := func(ts, done int64) pbm.PhysRestoreBeat {
+		return pbm.PhysRestoreBeat{Node: ts, Phase: pbm.PhysRestorePhaseCopy, Done: done, Total: 1000 * mb}
+	}
+	// rs1/c copies at a tenth of the others' rate, rs2/d is done copying
+	beats := func(ts int64) map[string]pbm.PhysRestoreBeat {
+		dt := ts - 1000
+		return map[string]pbm.PhysRestoreBeat{
+			"rs0/a": beat(ts, dt*mb),
+			"rs0/b": beat(ts, dt*mb),
+			"rs1/c": beat(ts, dt*mb/10),
+			"rs2/d": {Node: ts, Phase: pbm.PhysRestorePhaseWait, Done: 10 * mb, Total: 10 * mb},
+		}
+	}
+
+	prg, slow := p.update(beats(1000), 1000)
+	if len(slow) != 0 || prg.ETA != 0 {
+		t.Fatalf("rates are unknown yet: eta %d, slow %v", prg.ETA, slow)
+	}
+	if len(prg.Nodes) != 4 {
+		t.Fatalf("got %d nodes, want 4", len(prg.Nodes))
+	}
+
+	prg, slow = p.update(beats(1100), 1100)
+	if prg.Slowest == nil || prg.Slowest.Node != "rs1/c" {
+		t.Fatalf("slowest %+v, want rs1/c", prg.Slowest)
+	}
+	if r := prg.Slowest.Rate; r < 0.09*mb || r > 0.11*mb {
+		t.Errorf("rate %f, want ~%d", r, mb/10)
+	}
+	// 990MB left at 0.1MB/s
+	if want := int64(1100 + 9900); prg.ETA < want-10 || prg.ETA > want+10 {
+		t.Errorf("eta %d, want ~%d", prg.ETA, want)
+	}
+	if prg.Slowest.SlowSince != 1100 {
+		t.Errorf("slow since %d, want 1100", prg.Slowest.SlowSince)
+	}
+	if len(slow) != 0 {
+		t.Errorf("reported before %v: %v", p.slowFor, slow)
+	}
+
+	_, slow = p.update(beats(1200), 1200)
+	if len(slow) != 0 {
+		t.Errorf("reported before %v: %v", p.slowFor, slow)
+	}
+
+	_, slow = p.update(beats(1100+int64(4*time.Minute/time.Second)), 1100+int64(4*time.Minute/time.Second))
+	if len(slow) != 1 || slow[0].Node != "rs1/c" {
+		t.Fatalf("got slow %v, want rs1/c", slow)
+	}
+
+	// reported once
+	_, slow = p.update(beats(1400), 1400)
+	if len(slow) != 0 {
+		t.Errorf("reported again: %v", slow)
+	}
+}
+
+func TestProgressTrackerPhase(t *testing.T) {
+	p := newProgressTracker(pbm.RestoreConf{})
+
+	prg, _ := p.update(map[string]pbm.PhysRestoreBeat{
+		"rs0/a": {Node: 10, Phase: pbm.PhysRestorePhaseWait},
+		"rs1/b": {Node: 10, Phase: "prepareData"},
+		"rs2/c": {Node: 10, Phase: "resetRS"},
+	}, 10)
+	if prg.Slowest == nil || prg.Slowest.Node != "rs1/b" {
+		t.Errorf("slowest %+v, want rs1/b in the earliest phase", prg.Slowest)
+	}
+	if prg.ETA != 0 {
+		t.Errorf("eta %d for no copying nodes", prg.ETA)
+	}
+}
@@ -0,0 +1,105 @@
+package restore
+
+import (
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+)
+
+// fallbackStorage is the storage of the restore read endpoint
+type fallbackStorage struct {
+	name string
+	stg  storage.Storage
+}
+
+// readEndpoint is a storage the physical restore copies backup files from
+// (see pbm.RestoreS3Conf.ReadEndpoints)
+type readEndpoint struct {
+	name string
+	read func(string) (io.ReadCloser, error)
+	// nil if the storage can't read ranges
+	rr storage.RangeReader
+}
+
+// s3EndpointName returns the name of the S3 endpoint for the logs and stats
+func s3EndpointName(c s3.Conf) string {
+	ep := c.EndpointURL
+	if ep == "" {
+		ep = "s3." + c.Region
+	}
+	return ep + "/" + c.Bucket
+}
+
+// servedStat records the files served by a fallback endpoint or failed on
+// all of them. The files served by the primary endpoint aren't recorded,
+// as they're usually all of them.
+type servedStat struct {
+	mu    sync.Mutex
+	files map[string]s3.FileEndpointStat
+}
+
+func newServedStat() *servedStat {
+	return &servedStat{files: make(map[string]s3.FileEndpointStat)}
+}
+
+func (s *servedStat) add(name, endpoint string, errs []string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the failure of any part of the object is reported
+	if f, ok := s.files[name]; ok && f.Endpoint == "" {
+		return
+	}
+	s.files[name] = s3.FileEndpointStat{Name: name, Endpoint: endpoint, Errors: errs}
+}
+
+func (s *servedStat) list() []s3.FileEndpointStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rv := make([]s3.FileEndpointStat, 0, len(s.files))
+	for _, f := range s.files {
+		rv = append(rv, f)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Name < rv[j].Name })
+	return rv
+}
+
+// copyFailover copies the file of the task trying the endpoints in order.
+// An endpoint serves the file if the whole of it is read and decompressed
+// with no error. Otherwise, the next one overwrites what was copied.
+// It returns the error of the last endpoint if none succeeds.
+func copyFailover(eps []readEndpoint, t copyTask, buf []byte, served *servedStat, l *log.Event) error {
+	var errs []string
+	err := errors.Errorf("no endpoint to read %s", t)
+	for i, ep := range eps {
+		if t.partLen != 0 {
+			if ep.rr == nil {
+				continue
+			}
+			err = CopyFileRange(ep.rr, t.src, t.partOff, t.partLen, t.dst, t.f, buf)
+		} else {
+			err = CopyFile(ep.read, t.src, t.cmpr, t.dst, t.f, buf)
+		}
+		if err == nil {
+			if i != 0 || len(errs) != 0 {
+				served.add(t.src, ep.name, errs)
+			}
+			return nil
+		}
+		if len(eps) > 1 {
+			l.Warning("copy %s from %s: %v", t, ep.name, err)
+		}
+		errs = append(errs, ep.name+": "+err.Error())
+	}
+	served.add(t.src, "", errs)
+
+	return err
+}
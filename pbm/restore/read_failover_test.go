@@ -0,0 +1,113 @@
+package restore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// brokenReader returns part of the data and fails
+type brokenReader struct {
+	io.Reader
+}
+
+func (r brokenReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func (brokenReader) Close() error { return nil }
+
+func TestCopyFailover(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	data := map[string]string{
+		"a.wt": "aaaaaaaaaa",
+		"b.wt": "bbbbbbbbbb",
+		"c.wt": "cccccccccc",
+	}
+	endpoint := func(serves func(name string) (io.ReadCloser, bool)) func(string) (io.ReadCloser, error) {
+		return func(name string) (io.ReadCloser, error) {
+			rdr, ok := serves(name)
+			if !ok {
+				return nil, errors.New("503 slow down")
+			}
+			return rdr, nil
+		}
+	}
+	eps := []readEndpoint{
+		{
+			name: "primary",
+			read: endpoint(func(name string) (io.ReadCloser, bool) {
+				switch name {
+				case "a.wt":
+					return io.NopCloser(strings.NewReader(data[name])), true
+				case "b.wt":
+					// a truncated object
+					return brokenReader{strings.NewReader(data[name][:3])}, true
+				}
+				return nil, false
+			}),
+		},
+		{
+			name: "replica",
+			read: endpoint(func(name string) (io.ReadCloser, bool) {
+				if name == "c.wt" {
+					return nil, false
+				}
+				return io.NopCloser(strings.NewReader(data[name])), true
+			}),
+		},
+	}
+
+	dir := t.TempDir()
+	served := newServedStat()
+	for _, name := range []string{"a.wt", "b.wt"} {
+		tk := copyTask{
+			src:  name,
+			dst:  filepath.Join(dir, name),
+			cmpr: compress.CompressionTypeNone,
+			f:    pbm.File{Name: name, Fmode: 0o600},
+		}
+		if err := copyFailover(eps, tk, make([]byte, 4), served, l); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		b, err := os.ReadFile(tk.dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, []byte(data[name])) {
+			t.Errorf("%s: expected %q, got %q", name, data[name], b)
+		}
+	}
+
+	tk := copyTask{src: "c.wt", dst: filepath.Join(dir, "c.wt"), cmpr: compress.CompressionTypeNone}
+	if err := copyFailover(eps, tk, make([]byte, 4), served, l); err == nil {
+		t.Error("expected error if no endpoint serves the file")
+	}
+
+	// a.wt served by the primary isn't recorded
+	got := served.list()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 recorded files, got %+v", got)
+	}
+	if got[0].Name != "b.wt" || got[0].Endpoint != "replica" || len(got[0].Errors) != 1 ||
+		!strings.Contains(got[0].Errors[0], "primary") {
+		t.Errorf("unexpected b.wt stat: %+v", got[0])
+	}
+	if got[1].Name != "c.wt" || got[1].Endpoint != "" || len(got[1].Errors) != 2 {
+		t.Errorf("unexpected c.wt stat: %+v", got[1])
+	}
+}
@@ -0,0 +1,67 @@
+package restore
+
+import (
+	"path"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// syncReplsetIDFile holds the new replicaSetId of the replset
+// (see pbm.RestoreCmd.NewReplsetID)
+const syncReplsetIDFile = "replset.id"
+
+// agreeReplsetID returns the new replicaSetId all nodes of the replset
+// agree on. The first node to get here generates it, the rest adopt it.
+// It's nil unless a new ID is requested.
+func (r *PhysRestore) agreeReplsetID() (primitive.ObjectID, error) {
+	if !r.newReplsetID {
+		return primitive.NilObjectID, nil
+	}
+
+	v, err := r.agreeSyncValue(path.Join("rs."+r.rsConf.ID, syncReplsetIDFile), primitive.NewObjectID().Hex())
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, err := primitive.ObjectIDFromHex(v)
+	if err != nil {
+		return primitive.NilObjectID, errors.Wrapf(err, "parse replicaSetId %q", v)
+	}
+	r.log.Info("new replicaSetId: %s", id.Hex())
+
+	return id, nil
+}
+
+// restoredRSConfig returns the replset config to put into
+// local.system.replset of the restored node. It keeps the members and
+// settings of the current config, including its replicaSetId unless
+// the new `id` is given.
+func restoredRSConfig(conf *pbm.RSConfig, csrs bool, id primitive.ObjectID) pbm.RSConfig {
+	settings := conf.Settings
+	if !id.IsZero() {
+		settings.ReplicaSetID = id
+	}
+
+	return pbm.RSConfig{
+		ID:       conf.ID,
+		CSRS:     csrs,
+		Version:  1,
+		Members:  conf.Members,
+		Settings: settings,
+	}
+}
+
+// unrestoredMembers returns the members of the replset the restore
+// doesn't touch. With a new replicaSetId they're rejected by the restored
+// ones until they're wiped and initial-synced.
+func unrestoredMembers(conf *pbm.RSConfig) []string {
+	var rv []string
+	for _, m := range conf.Members {
+		if m.ArbiterOnly {
+			rv = append(rv, m.Host)
+		}
+	}
+	return rv
+}
@@ -0,0 +1,104 @@
+package restore
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestRestoredRSConfig(t *testing.T) {
+	oldID := primitive.NewObjectID()
+	conf := &pbm.RSConfig{
+		ID:      "rs0",
+		Version: 7,
+		Members: []pbm.RSMember{{ID: 0, Host: "a:27017"}, {ID: 1, Host: "b:27017"}},
+		Settings: pbm.RSSettings{
+			ChainingAllowed:       true,
+			ElectionTimeoutMillis: 10000,
+			ReplicaSetID:          oldID,
+		},
+	}
+
+	settings := func(t *testing.T, c pbm.RSConfig) bson.M {
+		t.Helper()
+		b, err := bson.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc struct {
+			Settings bson.M `bson:"settings"`
+		}
+		if err := bson.Unmarshal(b, &doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc.Settings
+	}
+
+	t.Run("preserve", func(t *testing.T) {
+		c := restoredRSConfig(conf, false, primitive.NilObjectID)
+		if c.ID != "rs0" || c.Version != 1 || !reflect.DeepEqual(c.Members, conf.Members) {
+			t.Errorf("unexpected config %+v", c)
+		}
+		s := settings(t, c)
+		if s["replicaSetId"] != oldID {
+			t.Errorf("replicaSetId %v, want %v", s["replicaSetId"], oldID)
+		}
+		if s["chainingAllowed"] != true || s["electionTimeoutMillis"] != int32(10000) {
+			t.Errorf("settings aren't preserved: %v", s)
+		}
+	})
+
+	t.Run("regenerate", func(t *testing.T) {
+		newID := primitive.NewObjectID()
+		c := restoredRSConfig(conf, true, newID)
+		if !c.CSRS {
+			t.Error("configsvr isn't set")
+		}
+		s := settings(t, c)
+		if s["replicaSetId"] != newID {
+			t.Errorf("replicaSetId %v, want %v", s["replicaSetId"], newID)
+		}
+		if s["chainingAllowed"] != true || s["electionTimeoutMillis"] != int32(10000) {
+			t.Errorf("settings aren't preserved: %v", s)
+		}
+		if conf.Settings.ReplicaSetID != oldID {
+			t.Error("the original config is modified")
+		}
+	})
+}
+
+func TestAgreeReplsetID(t *testing.T) {
+	stg := newMemStorage()
+	newRestore := func(regen bool) *PhysRestore {
+		r := newTestPhysRestore(t, &fakeMongod{})
+		r.name = "rst"
+		r.stg = stg
+		r.rsConf = &pbm.RSConfig{ID: "rs0"}
+		r.newReplsetID = regen
+		return r
+	}
+
+	id, err := newRestore(false).agreeReplsetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.IsZero() {
+		t.Errorf("got %v with no regeneration requested", id)
+	}
+
+	id1, err := newRestore(true).agreeReplsetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := newRestore(true).agreeReplsetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1.IsZero() || id1 != id2 {
+		t.Errorf("nodes disagree on the replicaSetId: %v, %v", id1, id2)
+	}
+}
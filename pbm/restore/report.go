@@ -0,0 +1,108 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// physReportFile is the report of the physical restore in its sync dir
+const physReportFile = "report.json"
+
+// report persists the report of the finished restore and sends the
+// notification. Only the leader does it.
+func (r *Restore) report(l *log.Event) {
+	if r.nodeInfo == nil || !r.nodeInfo.IsLeader() || r.name == "" {
+		return
+	}
+
+	meta, err := r.cn.GetRestoreMeta(r.name)
+	if err != nil {
+		l.Error("report: get restore meta: %v", err)
+		return
+	}
+
+	rep := pbm.MakeRestoreReport(meta, r.bcp)
+	err = r.cn.SetRestoreReport(r.name, rep)
+	if err != nil {
+		l.Error("report: save: %v", err)
+	}
+
+	// only the full restore tests the backup
+	if meta.Status == pbm.StatusDone && meta.Backup != "" && len(meta.Namespaces) == 0 {
+		err = r.cn.SetBackupRestoreTested(meta.Backup, r.name, meta.LastTransitionTS)
+		if err != nil {
+			l.Warning("report: mark backup %s as restore-tested: %v", meta.Backup, err)
+		}
+	}
+
+	cfg, err := r.cn.GetConfig()
+	if err != nil {
+		l.Error("report: get config: %v", err)
+		return
+	}
+	err = pbm.Notify(r.cn.Context(), cfg.Notify, pbm.NotifyRestoreFinished, rep)
+	if err != nil {
+		l.Error("report: notify: %v", err)
+	}
+}
+
+// report saves the report of the finished restore next to the sync files
+// and sends the notification. Only the cluster leader does it and, should
+// there be several, only the one that managed to save the report sends it.
+// The PBM db is not available at this point, so the config is the one
+// read on the restore start.
+func (r *PhysRestore) report(meta *pbm.RestoreMeta) {
+	if r.stg == nil || r.log == nil || r.nodeInfo == nil || !r.isClusterLeader() {
+		return
+	}
+
+	m, err := pbm.GetPhysRestoreMeta(r.name, r.stg, r.log)
+	if err != nil {
+		r.log.Warning("report: get restore meta: %v", err)
+		if m == nil {
+			return
+		}
+	}
+	if m.OPID == "" {
+		m.OPID = meta.OPID
+	}
+	if m.Backup == "" {
+		m.Backup = meta.Backup
+	}
+	if m.StartTS == 0 {
+		m.StartTS = meta.StartTS
+	}
+	if m.PITR == 0 {
+		m.PITR = meta.PITR
+	}
+
+	rep := pbm.MakeRestoreReport(m, r.bcp)
+	b, err := json.Marshal(rep)
+	if err != nil {
+		r.log.Error("report: encode: %v", err)
+		return
+	}
+
+	err = storage.SaveIfNotExists(r.stg, path.Join(pbm.PhysRestoresDir, r.name, physReportFile),
+		bytes.NewReader(b), int64(len(b)))
+	if errors.Is(err, storage.ErrExist) {
+		r.log.Info("report: has been saved by another node")
+		return
+	}
+	if err != nil {
+		r.log.Error("report: save: %v", err)
+	}
+
+	err = pbm.Notify(context.Background(), r.notify, pbm.NotifyRestoreFinished, rep)
+	if err != nil {
+		r.log.Error("report: notify: %v", err)
+	}
+}
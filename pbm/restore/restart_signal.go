@@ -0,0 +1,101 @@
+package restore
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// restartSignalPoll is how often the restart confirmation is checked for
+var restartSignalPoll = 5 * time.Second
+
+// errRestartNotConfirmed means the controller hasn't confirmed the restart
+// signal in time
+var errRestartNotConfirmed = errors.New("restart isn't confirmed")
+
+// signalRestart writes the node's restart-needed signal and waits for
+// the controller to confirm it (see pbm.RestartSignal). It does nothing
+// unless pbm.RestoreConf.K8sSignals is set.
+func (r *PhysRestore) signalRestart(stat pbm.Status) error {
+	if !r.confOpts.K8sSignals {
+		return nil
+	}
+
+	order := pbm.RestartOrderShard
+	if r.nodeInfo.IsConfigSrv() {
+		order = pbm.RestartOrderConfigSrv
+	}
+	sig := pbm.RestartSignal{
+		Version: pbm.RestartSignalVersion,
+		Restore: r.name,
+		RS:      r.rsConf.ID,
+		Node:    r.nodeInfo.Me,
+		Order:   order,
+		Status:  stat,
+		TS:      time.Now().Unix(),
+	}
+	b, err := json.Marshal(sig)
+	if err != nil {
+		return errors.Wrap(err, "marshal signal")
+	}
+
+	needed := pbm.RestartSignalPath(r.name, r.rsConf.ID, r.nodeInfo.Me, pbm.RestartSignalNeeded)
+	err = r.stg.Save(needed, bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return errors.Wrapf(err, "write %s", needed)
+	}
+
+	confirmed := pbm.RestartSignalPath(r.name, r.rsConf.ID, r.nodeInfo.Me, pbm.RestartSignalConfirmed)
+	timeout := r.confOpts.K8sSignalTimeout()
+	r.log.Info("waiting up to %v for the restart confirmation %s", timeout, confirmed)
+	ack, err := waitRestartConfirm(r.stg, confirmed, timeout, restartSignalPoll)
+	if err != nil {
+		return err
+	}
+	r.log.Info("restart is confirmed at %v", time.Unix(ack.TS, 0).UTC())
+
+	return nil
+}
+
+// waitRestartConfirm waits for the restart confirmation file `name` to
+// appear and returns its content
+func waitRestartConfirm(stg storage.Storage, name string, timeout, poll time.Duration) (*pbm.RestartSignal, error) {
+	tk := time.NewTicker(poll)
+	defer tk.Stop()
+	tout := time.NewTimer(timeout)
+	defer tout.Stop()
+
+	for {
+		_, err := stg.FileStat(name)
+		if err == nil {
+			b, err := pbm.ReadStatusFile(stg, name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "read %s", name)
+			}
+			ack := &pbm.RestartSignal{}
+			err = json.Unmarshal(b, ack)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse %s", name)
+			}
+			if ack.Version != pbm.RestartSignalVersion {
+				return nil, errors.Errorf("unsupported restart signal version %d in %s, expected %d",
+					ack.Version, name, pbm.RestartSignalVersion)
+			}
+			return ack, nil
+		}
+		if !errors.Is(err, storage.ErrNotExist) {
+			return nil, errors.Wrapf(err, "get file %s", name)
+		}
+
+		select {
+		case <-tk.C:
+		case <-tout.C:
+			return nil, errors.Wrapf(errRestartNotConfirmed, "no %s in %v", name, timeout)
+		}
+	}
+}
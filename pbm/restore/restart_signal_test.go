@@ -0,0 +1,108 @@
+package restore
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestSignalRestart(t *testing.T) {
+	defer func(p time.Duration) { restartSignalPoll = p }(restartSignalPoll)
+	restartSignalPoll = time.Millisecond * 10
+
+	newRestore := func(stg *memStorage, timeoutSec int) *PhysRestore {
+		r := newTestPhysRestore(t, &fakeMongod{})
+		r.name = "rst"
+		r.stg = stg
+		r.rsConf = &pbm.RSConfig{ID: "cfg"}
+		r.nodeInfo.SetName = "cfg"
+		r.nodeInfo.ConfigSvr = 2
+		r.confOpts = pbm.RestoreConf{K8sSignals: true, K8sSignalTimeoutSec: timeoutSec}
+		return r
+	}
+	needed := pbm.RestartSignalPath("rst", "cfg", "host:27017", pbm.RestartSignalNeeded)
+	confirmed := pbm.RestartSignalPath("rst", "cfg", "host:27017", pbm.RestartSignalConfirmed)
+
+	// controller confirms the restart once it sees the signal
+	controller := func(stg *memStorage, version int) chan pbm.RestartSignal {
+		seen := make(chan pbm.RestartSignal, 1)
+		go func() {
+			for {
+				b, err := pbm.ReadStatusFile(stg, needed)
+				if err != nil {
+					time.Sleep(time.Millisecond * 5)
+					continue
+				}
+				sig := pbm.RestartSignal{}
+				if err := json.Unmarshal(b, &sig); err != nil {
+					t.Error(err)
+				}
+				seen <- sig
+				ack, _ := json.Marshal(pbm.RestartSignal{Version: version, TS: time.Now().Unix()})
+				stg.Save(confirmed, bytes.NewReader(ack), -1)
+				return
+			}
+		}()
+		return seen
+	}
+
+	t.Run("confirmed", func(t *testing.T) {
+		stg := newMemStorage()
+		seen := controller(stg, pbm.RestartSignalVersion)
+		err := newRestore(stg, 5).signalRestart(pbm.StatusDone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := <-seen
+		want := pbm.RestartSignal{
+			Version: pbm.RestartSignalVersion,
+			Restore: "rst",
+			RS:      "cfg",
+			Node:    "host:27017",
+			Order:   pbm.RestartOrderConfigSrv,
+			Status:  pbm.StatusDone,
+			TS:      sig.TS,
+		}
+		if sig != want {
+			t.Errorf("signal %+v, want %+v", sig, want)
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		stg := newMemStorage()
+		controller(stg, pbm.RestartSignalVersion+1)
+		err := newRestore(stg, 5).signalRestart(pbm.StatusDone)
+		if err == nil || !strings.Contains(err.Error(), "version") {
+			t.Fatalf("expected version error, got %v", err)
+		}
+	})
+
+	t.Run("not confirmed", func(t *testing.T) {
+		stg := newMemStorage()
+		err := newRestore(stg, 1).signalRestart(pbm.StatusPartlyDone)
+		if !errors.Is(err, errRestartNotConfirmed) {
+			t.Fatalf("expected errRestartNotConfirmed, got %v", err)
+		}
+		if _, err := stg.FileStat(needed); err != nil {
+			t.Errorf("no restart-needed signal: %v", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		stg := newMemStorage()
+		r := newRestore(stg, 1)
+		r.confOpts.K8sSignals = false
+		if err := r.signalRestart(pbm.StatusDone); err != nil {
+			t.Fatal(err)
+		}
+		if len(stg.files) != 0 {
+			t.Errorf("files written: %v", stg.files)
+		}
+	})
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/percona/percona-backup-mongodb/pbm"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
 )
 
 func init() {
@@ -25,8 +26,16 @@ func init() {
 	})
 }
 
+// GetMetaFromStore reads the backup's metadata from the storage. If S3
+// redirects the request to the bucket's region, the storage is switched
+// to it (see s3.S3.FollowRegion).
 func GetMetaFromStore(stg storage.Storage, bcpName string) (*pbm.BackupMeta, error) {
 	rd, err := stg.SourceReader(bcpName + pbm.MetadataFileSuffix)
+	if s, ok := stg.(*s3.S3); ok && s3.IsRegionRedirect(err) {
+		if _, ferr := s.FollowRegion(); ferr == nil {
+			rd, err = stg.SourceReader(bcpName + pbm.MetadataFileSuffix)
+		}
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "get from store")
 	}
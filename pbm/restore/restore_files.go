@@ -0,0 +1,57 @@
+package restore
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// saveFiles records the dbpath content the flush is about to wipe and the
+// backup files that replace it. The full lists are saved to the storage
+// for the audit. It's not a reason to fail the restore if it can't be done.
+// The record is removed on error unless keep is called once the dbpath is
+// actually wiped.
+func (r *PhysRestore) saveFiles() (keep func()) {
+	keep = func() {}
+
+	wipe, err := ListDBPath(r.dbpath, r.dbpathIgnore())
+	if err != nil {
+		r.log.Warning("list dbpath files: %v", err)
+		return keep
+	}
+
+	var write []pbm.FileSize
+	if rs := getRS(r.bcp, pbm.MakeReverseRSMapFunc(r.rsMap)(r.nodeInfo.SetName)); rs != nil {
+		// r.files has the data of each backup in the chain (see setBcpFiles)
+		chain := [][]pbm.File{append(append([]pbm.File(nil), rs.Files...), rs.Journal...)}
+		for _, f := range r.files {
+			if f.BcpName != bcpDir {
+				chain = append(chain, f.Data)
+			}
+		}
+		write = pbm.BackupFiles(chain)
+	}
+
+	st := pbm.RestoreFiles{
+		Wipe:  pbm.NewFileList(wipe, 0),
+		Write: pbm.NewFileList(write, 0),
+	}
+	r.log.Info("wiping %d files (%d bytes) in the dbpath, %d files (%d bytes) are restored instead",
+		st.Wipe.Count, st.Wipe.Size, st.Write.Count, st.Write.Size)
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		r.log.Warning("encode restore files: %v", err)
+		return keep
+	}
+	err = r.stg.Save(r.syncPathNodeFiles, bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		r.log.Warning("write restore files: %v", err)
+		return keep
+	}
+
+	return r.cleanup.add("restore files", cleanupOnError, func() error {
+		return r.stg.Delete(r.syncPathNodeFiles)
+	})
+}
@@ -0,0 +1,224 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/sel"
+	"github.com/percona/percona-backup-mongodb/version"
+)
+
+const (
+	defaultSampleMax = 1000
+	// maxSampleErrors is the number of errors kept per namespace
+	maxSampleErrors = 5
+)
+
+// sampleSize returns the number of documents to sample out of `count`
+func sampleSize(count int64, rate float64, max int) int64 {
+	if count <= 0 || rate <= 0 {
+		return 0
+	}
+	if max <= 0 {
+		max = defaultSampleMax
+	}
+
+	n := int64(math.Ceil(float64(count) * rate))
+	if n > int64(max) {
+		n = int64(max)
+	}
+	if n > count {
+		n = count
+	}
+	return n
+}
+
+// sampleNamespaces returns the user collections with data restored
+// from the backup
+func sampleNamespaces(nss []*archive.Namespace, selected []string) []string {
+	if !sel.IsSelective(selected) {
+		selected = []string{"*.*"}
+	}
+	pred := sel.MakeSelectedPred(selected)
+
+	var rv []string
+	for _, ns := range nss {
+		if ns.Size == 0 || (ns.Type != "" && ns.Type != "collection") {
+			continue
+		}
+		switch ns.Database {
+		case "admin", "config", "local":
+			continue
+		}
+		if strings.HasPrefix(ns.Collection, "system.") {
+			continue
+		}
+
+		name := ns.Database + "." + ns.Collection
+		if pred(name) {
+			rv = append(rv, name)
+		}
+	}
+
+	return rv
+}
+
+// sampleNS checks that a random sample of the collection documents from
+// the backup `bcp` (the decompressed namespace file) can be read back from
+// the restored collection by their _id and is the same.
+func sampleNS(ctx context.Context, c *mongo.Collection, bcp io.Reader, rate float64, max int) (pbm.NSSampleCheck, error) {
+	rv := pbm.NSSampleCheck{NS: c.Database().Name() + "." + c.Name()}
+
+	count, err := c.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return rv, errors.Wrap(err, "count documents")
+	}
+	n := sampleSize(count, rate, max)
+	if n == 0 {
+		return rv, nil
+	}
+
+	docs, err := sampleDocs(bcp, n, rand.New(rand.NewSource(time.Now().UnixNano())))
+	if err != nil {
+		return rv, errors.Wrap(err, "sample backup documents")
+	}
+
+	fail := func(f string, a ...interface{}) {
+		if len(rv.Errors) < maxSampleErrors {
+			rv.Errors = append(rv.Errors, fmt.Sprintf(f, a...))
+		}
+	}
+	for _, bdoc := range docs {
+		rv.Sampled++
+
+		id, err := bdoc.LookupErr("_id")
+		if err != nil {
+			fail("backup document with no _id")
+			continue
+		}
+		doc, err := c.FindOne(ctx, bson.D{{"_id", id}}).DecodeBytes()
+		if err != nil {
+			fail("_id %s: %v", id, err)
+			continue
+		}
+		if !bytes.Equal(doc, bdoc) {
+			fail("_id %s: restored document differs from the backup one", id)
+			continue
+		}
+		rv.Verified++
+	}
+
+	return rv, nil
+}
+
+// sampleDocs returns a random sample (reservoir) of up to `n` documents
+// out of the bson stream `r`
+func sampleDocs(r io.Reader, n int64, rnd *rand.Rand) ([]bson.Raw, error) {
+	rv := make([]bson.Raw, 0, n)
+	var buf []byte
+	for i := int64(0); ; i++ {
+		b, err := archive.ReadBSONBuffer(r, buf)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return rv, nil
+			}
+			return nil, errors.Wrap(err, "read bson")
+		}
+		buf = b[:cap(b)]
+
+		j := i
+		if i >= n {
+			j = rnd.Int63n(i + 1)
+			if j >= n {
+				continue
+			}
+		}
+		doc := make(bson.Raw, len(b))
+		copy(doc, b)
+		if j < int64(len(rv)) {
+			rv[j] = doc
+		} else {
+			rv = append(rv, doc)
+		}
+	}
+}
+
+// sampleBackupNS runs sampleNS on the namespace `ns` against its file
+// in the backup which `dump` is the metadata file of
+func (r *Restore) sampleBackupNS(bcp *pbm.BackupMeta, dump, ns string, rate float64, max int) (pbm.NSSampleCheck, error) {
+	db, coll, _ := strings.Cut(ns, ".")
+	rdr, err := r.stg.SourceReader(path.Join(path.Dir(dump), archive.NSify(db, coll)+bcp.Compression.Suffix()))
+	if err != nil {
+		return pbm.NSSampleCheck{NS: ns}, errors.Wrap(err, "open backup file")
+	}
+	defer rdr.Close()
+
+	data, err := compress.Decompress(rdr, bcp.Compression)
+	if err != nil {
+		return pbm.NSSampleCheck{NS: ns}, errors.Wrap(err, "decompress backup file")
+	}
+	defer data.Close()
+
+	return sampleNS(r.cn.Context(), r.node.Session().Database(db).Collection(coll), data, rate, max)
+}
+
+// verifySample runs the sampling verification of the restored collections
+// (see pbm.RestoreConf.VerifySampleRate) and records the results in the
+// replset's restore meta. It's advisory, so it never fails the restore.
+// It must run right after the snapshot is restored, as the documents are
+// compared with the backup ones and the oplog replay may change them.
+func (r *Restore) verifySample(bcp *pbm.BackupMeta, dump string, nss []string) {
+	if version.IsLegacyArchive(bcp.PBMVersion) || (r.nodeInfo.IsConfigSrv() && sel.IsSelective(nss)) {
+		return
+	}
+
+	cfg, err := r.cn.GetConfig()
+	if err != nil {
+		r.log.Warning("sampling verification: get config: %v", err)
+		return
+	}
+	if cfg.Restore.VerifySampleRate <= 0 {
+		return
+	}
+
+	anss, err := pbm.ReadArchiveNamespaces(r.stg, dump)
+	if err != nil {
+		r.log.Warning("sampling verification: read backup namespaces: %v", err)
+		return
+	}
+
+	res := &pbm.SampleCheck{}
+	for _, ns := range sampleNamespaces(anss, nss) {
+		s, err := r.sampleBackupNS(bcp, dump, ns, cfg.Restore.VerifySampleRate, cfg.Restore.VerifySampleMax)
+		if err != nil {
+			s.Errors = append(s.Errors, err.Error())
+		}
+		res.Sampled += s.Sampled
+		res.Verified += s.Verified
+		if len(s.Errors) != 0 {
+			r.log.Warning("sampling verification of %s: %d of %d documents verified: %s",
+				ns, s.Verified, s.Sampled, strings.Join(s.Errors, "; "))
+			res.Failed = append(res.Failed, s)
+		}
+	}
+	r.log.Info("sampling verification: %d of %d documents verified", res.Verified, res.Sampled)
+
+	err = r.cn.SetRestoreRSSample(r.name, r.nodeInfo.SetName, res)
+	if err != nil {
+		r.log.Warning("sampling verification: save results: %v", err)
+	}
+}
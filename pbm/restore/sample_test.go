@@ -0,0 +1,99 @@
+package restore
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	mtarchive "github.com/mongodb/mongo-tools/common/archive"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/percona/percona-backup-mongodb/pbm/archive"
+)
+
+func TestSampleSize(t *testing.T) {
+	for _, c := range []struct {
+		count int64
+		rate  float64
+		max   int
+		want  int64
+	}{
+		{0, 0.1, 0, 0},
+		{100, 0, 0, 0},
+		{100, 0.01, 0, 1},
+		{101, 0.01, 0, 2},
+		{1e6, 0.5, 0, defaultSampleMax},
+		{1e6, 0.5, 10, 10},
+		{3, 1, 10, 3},
+	} {
+		if got := sampleSize(c.count, c.rate, c.max); got != c.want {
+			t.Errorf("sampleSize(%d, %v, %d): expected %d, got %d", c.count, c.rate, c.max, c.want, got)
+		}
+	}
+}
+
+func TestSampleNamespaces(t *testing.T) {
+	ns := func(db, coll, typ string, size int64) *archive.Namespace {
+		return &archive.Namespace{
+			CollectionMetadata: &mtarchive.CollectionMetadata{Database: db, Collection: coll, Type: typ},
+			Size:               size,
+		}
+	}
+	nss := []*archive.Namespace{
+		ns("db1", "c1", "collection", 10),
+		ns("db1", "c2", "", 10),
+		ns("db1", "empty", "collection", 0),
+		ns("db1", "v1", "view", 10),
+		ns("db1", "system.js", "collection", 10),
+		ns("db2", "c1", "collection", 10),
+		ns("admin", "pbmBackups", "collection", 10),
+		ns("config", "chunks", "collection", 10),
+	}
+
+	got := sampleNamespaces(nss, nil)
+	if want := []string{"db1.c1", "db1.c2", "db2.c1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	got = sampleNamespaces(nss, []string{"db2.*"})
+	if want := []string{"db2.c1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selective: expected %v, got %v", want, got)
+	}
+}
+
+func TestSampleDocs(t *testing.T) {
+	var stream bytes.Buffer
+	for i := 0; i < 100; i++ {
+		b, err := bson.Marshal(bson.D{{"_id", i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Write(b)
+	}
+	data := stream.Bytes()
+
+	docs, err := sampleDocs(bytes.NewReader(data), 10, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 10 {
+		t.Fatalf("expected 10 documents, got %d", len(docs))
+	}
+	seen := make(map[int32]bool)
+	for _, d := range docs {
+		id := d.Lookup("_id").Int32()
+		if id < 0 || id >= 100 || seen[id] {
+			t.Errorf("unexpected or duplicate _id %d", id)
+		}
+		seen[id] = true
+	}
+
+	docs, err = sampleDocs(bytes.NewReader(data[:len(data)/2]), 1000, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 50 {
+		t.Errorf("expected all 50 documents, got %d", len(docs))
+	}
+}
@@ -0,0 +1,117 @@
+//go:build linux
+
+package restore
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// selinuxEnforceFile holds the SELinux mode: "1" is enforcing, "0" is
+// permissive. There is no such file if SELinux is disabled.
+var selinuxEnforceFile = "/sys/fs/selinux/enforce"
+
+const selinuxXattr = "security.selinux"
+
+func selinuxEnforcing() bool {
+	b, err := os.ReadFile(selinuxEnforceFile)
+	return err == nil && strings.TrimSpace(string(b)) == "1"
+}
+
+// selinuxRelabel restores the SELinux context of the restored files. Files
+// created by the restore may lack the context mongod's policy requires
+// (e.g. mongod_var_lib_t) and mongod then fails to start on them.
+// AppArmor profiles are path-based, so nothing has to be done there.
+type selinuxRelabel struct {
+	// restorecon binary to relabel the dbpath with
+	restorecon string
+	// context the dbpath content had before the restore
+	label string
+}
+
+// prepareRelabel defines how the restored files are going to be relabeled.
+// It has to be called before the dbpath is cleaned up. Nil means no
+// relabeling is needed.
+func prepareRelabel(conf pbm.RestoreConf, dbpath string, ignore []string, l *log.Event) *selinuxRelabel {
+	if conf.DisableSELinuxRelabel || !selinuxEnforcing() {
+		return nil
+	}
+
+	rl := &selinuxRelabel{restorecon: conf.RestoreconPath}
+	if rl.restorecon != "" {
+		return rl
+	}
+
+	label, err := dbpathLabel(dbpath, ignore)
+	if err != nil {
+		l.Warning("get SELinux context of the dbpath: %v", err)
+	}
+	rl.label = label
+	if rl.label == "" {
+		rl.restorecon, _ = exec.LookPath("restorecon")
+	}
+	l.Debug("SELinux is enforcing, restored files are to be relabeled with %q (restorecon: %q)",
+		rl.label, rl.restorecon)
+	return rl
+}
+
+// apply relabels the dbpath content
+func (rl *selinuxRelabel) apply(dbpath string, ignore []string) error {
+	switch {
+	case rl.restorecon != "":
+		out, err := exec.Command(rl.restorecon, "-R", dbpath).CombinedOutput()
+		return errors.Wrapf(err, "%s: %s", rl.restorecon, out)
+	case rl.label != "":
+		return walkRestored(dbpath, ignore, func(p string, fi os.FileInfo) error {
+			if fi.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+			return errors.Wrapf(syscall.Setxattr(p, selinuxXattr, []byte(rl.label), 0), "set context of %s", p)
+		})
+	}
+
+	return errors.New("the dbpath context is unknown and there is no restorecon")
+}
+
+// dbpathLabel returns the SELinux context of the mongod files in the dbpath
+// root or of the dbpath itself if there are none. Empty string means
+// there is no context.
+func dbpathLabel(dbpath string, ignore []string) (string, error) {
+	ents, err := os.ReadDir(dbpath)
+	if err != nil {
+		return "", errors.Wrap(err, "read dbpath")
+	}
+	for _, e := range ents {
+		if e.Type().IsRegular() && isMongodFile(e.Name()) && !matchAny(e.Name(), ignore) {
+			label, err := fileLabel(filepath.Join(dbpath, e.Name()))
+			if err != nil || label != "" {
+				return label, err
+			}
+		}
+	}
+
+	return fileLabel(dbpath)
+}
+
+func fileLabel(p string) (string, error) {
+	n, err := syscall.Getxattr(p, selinuxXattr, nil)
+	if err == nil && n > 0 {
+		b := make([]byte, n)
+		n, err = syscall.Getxattr(p, selinuxXattr, b)
+		if err == nil {
+			return strings.TrimRight(string(b[:n]), "\x00"), nil
+		}
+	}
+	if errors.Is(err, syscall.ENODATA) || errors.Is(err, syscall.ENOTSUP) {
+		return "", nil
+	}
+	return "", errors.Wrapf(err, "get context of %s", p)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package restore
+
+import (
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+)
+
+// selinuxRelabel restores the SELinux context of the restored files.
+// SELinux is Linux-only, so there is nothing to relabel.
+type selinuxRelabel struct{}
+
+func prepareRelabel(pbm.RestoreConf, string, []string, *log.Event) *selinuxRelabel {
+	return nil
+}
+
+func (rl *selinuxRelabel) apply(string, []string) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build linux
+
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSELinuxEnforcing(t *testing.T) {
+	defer func(f string) { selinuxEnforceFile = f }(selinuxEnforceFile)
+
+	selinuxEnforceFile = filepath.Join(t.TempDir(), "enforce")
+	if selinuxEnforcing() {
+		t.Error("expected not enforcing without the enforce file")
+	}
+	for mode, want := range map[string]bool{"1\n": true, "0\n": false} {
+		if err := os.WriteFile(selinuxEnforceFile, []byte(mode), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got := selinuxEnforcing(); got != want {
+			t.Errorf("%q: expected enforcing %v, got %v", mode, want, got)
+		}
+	}
+}
+
+func TestSELinuxRelabelRestorecon(t *testing.T) {
+	dir := mkDBPath(t, "WiredTiger", "db1/collection-1.wt")
+	out := filepath.Join(t.TempDir(), "args")
+	bin := filepath.Join(t.TempDir(), "restorecon")
+	err := os.WriteFile(bin, []byte("#!/bin/sh\necho \"$@\" > "+out+"\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rl := &selinuxRelabel{restorecon: bin}
+	if err := rl.apply(dir, DefaultDBPathIgnore); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(b)); got != "-R "+dir {
+		t.Errorf("unexpected restorecon args %q", got)
+	}
+
+	if err := (&selinuxRelabel{}).apply(dir, DefaultDBPathIgnore); err == nil {
+		t.Error("expected error without context and restorecon")
+	}
+}
@@ -0,0 +1,96 @@
+package restore
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tmpPortRegistry is the host-local file with the tmp ports reserved by
+// the running physical restores (see pbm.RestoreConf.TmpPortLock)
+var tmpPortRegistry = filepath.Join(os.TempDir(), "pbm-restore-ports.json")
+
+type portClaim struct {
+	Port    int    `json:"port"`
+	PID     int    `json:"pid"`
+	Restore string `json:"restore"`
+	Node    string `json:"node"`
+}
+
+// peeks a random free port in a range [from, to]
+func peekTmpPort(from, to int) (int, error) {
+	return peekFreePort(from, to, nil)
+}
+
+// peekFreePort peeks a random port in a range [from, to] that is free
+// to listen on and isn't `taken`
+func peekFreePort(from, to int, taken map[int]bool) (int, error) {
+	const try = 150
+
+	rand.Seed(time.Now().UnixNano())
+
+	for i := 0; i < try; i++ {
+		p := from + rand.Intn(to-from+1)
+		if taken[p] {
+			continue
+		}
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(p))
+		if err == nil {
+			ln.Close()
+			return p, nil
+		}
+	}
+
+	return -1, errors.Errorf("can't find unused port in range [%d, %d]", from, to)
+}
+
+// reserveTmpPort peeks the tmp port in a range [from, to] and reserves it
+// in the host-local registry (tmpPortRegistry) for the time of the restore.
+// Ports reserved by other running restores on the host are skipped, so
+// co-located agents don't pick the same one before any of them starts
+// listening on it.
+func reserveTmpPort(from, to int, c portClaim) (port int, release func(), err error) {
+	var claims []portClaim
+	err = updateLockedJSON(tmpPortRegistry, &claims, func() error {
+		taken := make(map[int]bool)
+		var rv []portClaim
+		for _, o := range claims {
+			if !processAlive(o.PID) || (o.PID == c.PID && o.Restore == c.Restore) {
+				continue
+			}
+			taken[o.Port] = true
+			rv = append(rv, o)
+		}
+
+		p, err := peekFreePort(from, to, taken)
+		if err != nil {
+			return err
+		}
+		c.Port = p
+		claims = append(rv, c)
+		return nil
+	})
+	if err != nil {
+		return -1, nil, err
+	}
+
+	return c.Port, func() {
+		var claims []portClaim
+		_ = updateLockedJSON(tmpPortRegistry, &claims, func() error {
+			var rv []portClaim
+			for _, o := range claims {
+				if o.PID == c.PID && o.Restore == c.Restore {
+					continue
+				}
+				rv = append(rv, o)
+			}
+			claims = rv
+			return nil
+		})
+	}, nil
+}
@@ -0,0 +1,41 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReserveTmpPort(t *testing.T) {
+	defer func(p string) { tmpPortRegistry = p }(tmpPortRegistry)
+	tmpPortRegistry = filepath.Join(t.TempDir(), "ports.json")
+
+	const from, to = 40100, 40101
+	p1, release1, err := reserveTmpPort(from, to, portClaim{PID: os.Getpid(), Restore: "r1", Node: "rs101:27017"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, release2, err := reserveTmpPort(from, to, portClaim{PID: os.Getpid(), Restore: "r2", Node: "rs102:27017"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1 == p2 {
+		t.Fatalf("co-located restores got the same port %d", p1)
+	}
+
+	_, _, err = reserveTmpPort(from, to, portClaim{PID: os.Getpid(), Restore: "r3", Node: "rs103:27017"})
+	if err == nil {
+		t.Error("expected no free port left in the range")
+	}
+
+	release1()
+	p3, release3, err := reserveTmpPort(from, to, portClaim{PID: os.Getpid(), Restore: "r3", Node: "rs103:27017"})
+	if err != nil {
+		t.Fatalf("released port should be reusable: %v", err)
+	}
+	if p3 != p1 {
+		t.Errorf("expected released port %d, got %d", p1, p3)
+	}
+	release2()
+	release3()
+}
@@ -0,0 +1,62 @@
+package restore
+
+import (
+	"context"
+
+	"github.com/percona/percona-backup-mongodb/pbm/tracing"
+)
+
+// syncTraceFile holds the traceparent of the first node's restore span.
+// The other nodes' spans are its children, so the cluster-wide restore is
+// a single trace.
+const syncTraceFile = "trace.parent"
+
+// startTrace starts the node's restore span. It's no-op unless
+// the tracing is configured.
+func (r *PhysRestore) startTrace(cfg tracing.Conf) {
+	r.tracer = tracing.New(cfg, "pbm-agent", map[string]string{
+		"host.name":   r.nodeInfo.Me,
+		"pbm.replset": r.nodeInfo.SetName,
+	})
+	if r.tracer == nil {
+		return
+	}
+
+	sp := r.tracer.Start("restore", tracing.SpanContext{})
+	tp, err := r.agreeSyncValue(syncTraceFile, sp.Context().Traceparent())
+	if err != nil {
+		r.log.Warning("tracing: agree on the trace: %v", err)
+	} else if tp != sp.Context().Traceparent() {
+		parent, err := tracing.ParseTraceparent(tp)
+		if err != nil {
+			r.log.Warning("tracing: %v", err)
+		} else {
+			sp = r.tracer.Start("restore", parent)
+		}
+	}
+	sp.SetAttr("pbm.restore", r.name)
+	sp.SetAttr("pbm.opid", r.opid)
+	r.span = sp
+}
+
+// endTrace ends the node's restore span and exports the spans
+func (r *PhysRestore) endTrace(err error) {
+	if r.tracer == nil {
+		return
+	}
+
+	r.span.End(err)
+	if err := r.tracer.Flush(context.Background()); err != nil {
+		r.log.Warning("tracing: export spans: %v", err)
+	}
+}
+
+// traced runs the restore phase in its own span and reports it in
+// the heartbeats
+func (r *PhysRestore) traced(name string, fn func() error) error {
+	r.progress.setPhase(name)
+	sp := r.span.Child(name)
+	err := fn()
+	sp.End(err)
+	return err
+}
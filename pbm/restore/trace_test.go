@@ -0,0 +1,38 @@
+package restore
+
+import (
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/tracing"
+)
+
+func TestStartTrace(t *testing.T) {
+	stg := newMemStorage()
+	node := func(host string, cfg tracing.Conf) *PhysRestore {
+		r := newTestPhysRestore(t, &fakeMongod{})
+		r.name = "rst"
+		r.stg = stg
+		r.nodeInfo.Me = host
+		r.startTrace(cfg)
+		return r
+	}
+
+	if r := node("a:27017", tracing.Conf{}); r.tracer != nil || r.span != nil {
+		t.Fatal("tracing isn't configured but started")
+	}
+	if len(stg.files) != 0 {
+		t.Errorf("files written: %v", stg.files)
+	}
+
+	cfg := tracing.Conf{Endpoint: "http://localhost:4318"}
+	first := node("a:27017", cfg)
+	second := node("b:27017", cfg)
+
+	fc, sc := first.span.Context(), second.span.Context()
+	if fc.TraceID != sc.TraceID {
+		t.Errorf("nodes are in different traces: %x, %x", fc.TraceID, sc.TraceID)
+	}
+	if fc.SpanID == sc.SpanID {
+		t.Error("nodes share the span")
+	}
+}
@@ -0,0 +1,94 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+// errCodeNamespaceNotFound is the server's NamespaceNotFound error code
+const errCodeNamespaceNotFound = 26
+
+// validateColls runs `validate` on the collections listed in
+// RestoreConf.ValidateNamespaces and saves the results into the node's sync
+// file. An invalid collection fails the restore only if
+// RestoreConf.ValidateStrict is set.
+func (r *PhysRestore) validateColls(ctx context.Context, c *mongo.Client) error {
+	if len(r.confOpts.ValidateNamespaces) == 0 {
+		return nil
+	}
+
+	res := make([]pbm.CollValidation, 0, len(r.confOpts.ValidateNamespaces))
+	for _, ns := range r.confOpts.ValidateNamespaces {
+		r.log.Info("validating %s", ns)
+		v := validateColl(ctx, c, ns)
+		r.log.Debug("%s validated in %v, valid: %v", ns, time.Duration(v.DurationMs)*time.Millisecond, v.Valid)
+		res = append(res, v)
+	}
+
+	b, err := json.Marshal(res)
+	if err != nil {
+		r.log.Warning("encode validation results: %v", err)
+	} else if err = r.stg.Save(r.syncPathNodeValidate, bytes.NewReader(b), int64(len(b))); err != nil {
+		r.log.Warning("write validation results: %v", err)
+	}
+
+	invalid := invalidColls(res)
+	if len(invalid) == 0 {
+		return nil
+	}
+	if r.confOpts.ValidateStrict {
+		return errors.Errorf("invalid collections: %s", strings.Join(invalid, "; "))
+	}
+	r.log.Warning("invalid collections: %s", strings.Join(invalid, "; "))
+	return nil
+}
+
+// validateColl runs `validate` on the collection. A collection that doesn't
+// exist on the node (e.g. it's on other shards) is considered valid.
+func validateColl(ctx context.Context, c *mongo.Client, ns string) pbm.CollValidation {
+	rv := pbm.CollValidation{NS: ns}
+	db, coll, _ := strings.Cut(ns, ".")
+
+	start := time.Now()
+	var res struct {
+		Valid    bool     `bson:"valid"`
+		Errors   []string `bson:"errors"`
+		Warnings []string `bson:"warnings"`
+	}
+	err := c.Database(db).RunCommand(ctx, bson.D{{"validate", coll}}).Decode(&res)
+	rv.DurationMs = time.Since(start).Milliseconds()
+
+	var cerr mongo.CommandError
+	switch {
+	case errors.As(err, &cerr) && cerr.HasErrorCode(errCodeNamespaceNotFound):
+		rv.Valid = true
+		rv.Warnings = []string{"collection not found"}
+	case err != nil:
+		rv.Errors = []string{err.Error()}
+	default:
+		rv.Valid = res.Valid
+		rv.Errors = res.Errors
+		rv.Warnings = res.Warnings
+	}
+
+	return rv
+}
+
+func invalidColls(res []pbm.CollValidation) []string {
+	var rv []string
+	for _, v := range res {
+		if !v.Valid {
+			rv = append(rv, v.NS+": "+strings.Join(v.Errors, ", "))
+		}
+	}
+	return rv
+}
@@ -0,0 +1,177 @@
+package pbm
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// PhysRestoreStaleSec is how old the heartbeat of the physical restore's
+// node, replset or cluster may be before it's considered gone (two frames
+// of the restore heartbeat)
+const PhysRestoreStaleSec = 2 * 120
+
+// ErrRestoreAlive means the restore still has live agents
+var ErrRestoreAlive = errors.New("restore has live agents")
+
+// restoreAbortGrace is how long the agents are given to observe the abort
+// status before the restore files are removed. The agents check the sync
+// files every 5 sec.
+var restoreAbortGrace = 15 * time.Second
+
+const restoreAbortMsg = "aborted by the user"
+
+// AbortAndCleanRestore aborts the failed physical restore and removes its
+// files (sync files, logs and metadata) from the storage. It fails with
+// ErrRestoreAlive if any heartbeat of the restore is fresh and refuses to
+// clean up the restore that has succeeded. Otherwise,
+// the cluster and replsets of the restore are marked failed first, so any
+// lingering agent stops waiting.
+func (p *PBM) AbortAndCleanRestore(name string) error {
+	l := p.log.NewEvent(string(CmdRestore), name, "", primitive.Timestamp{})
+
+	stg, err := p.GetRestoreStorage(l)
+	if err != nil {
+		return errors.Wrap(err, "get storage")
+	}
+
+	err = abortAndCleanRestore(stg, name, restoreAbortGrace, l)
+	if err != nil {
+		return err
+	}
+
+	meta, err := p.GetRestoreMeta(name)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "get restore meta")
+	}
+	if !isFinalStatus(meta.Status) {
+		err = p.ChangeRestoreState(name, StatusError, restoreAbortMsg)
+		if err != nil {
+			return errors.Wrap(err, "set restore meta status")
+		}
+	}
+
+	return nil
+}
+
+func abortAndCleanRestore(stg storage.Storage, name string, grace time.Duration, l *log.Event) error {
+	dir := path.Join(PhysRestoresDir, name)
+	files, err := stg.List(dir, "")
+	if err != nil {
+		return errors.Wrap(err, "list restore files")
+	}
+	if len(files) == 0 {
+		return errors.Errorf("no files of restore %s", name)
+	}
+
+	// sync objects (cluster, rs.<name>/rs) which have no final status yet
+	objs := make(map[string]bool)
+	var alive []string
+	now := time.Now().Unix()
+	for _, f := range files {
+		if isPhysRestoreBeat(f.Name) {
+			hb, err := ReadPhysRestoreBeat(stg, path.Join(dir, f.Name))
+			if err != nil {
+				return err
+			}
+			if hb.Node+PhysRestoreStaleSec >= now {
+				alive = append(alive, f.Name)
+			}
+			continue
+		}
+
+		obj, st := splitSyncFile(f.Name)
+		if st == syncHbSuffix {
+			ok, err := hbFresh(stg, path.Join(dir, f.Name), now)
+			if err != nil {
+				return err
+			}
+			if ok {
+				alive = append(alive, obj)
+			}
+		}
+		if obj == "cluster" && (Status(st) == StatusDone || Status(st) == StatusPartlyDone) {
+			return errors.Errorf("restore %s has succeeded", name)
+		}
+		if obj != "cluster" && !strings.HasSuffix(obj, "/rs") {
+			continue
+		}
+		objs[obj] = objs[obj] || isFinalStatus(Status(st))
+	}
+	if len(alive) != 0 {
+		return errors.Wrapf(ErrRestoreAlive, "fresh heartbeats of %s", strings.Join(alive, ", "))
+	}
+
+	marked := 0
+	for obj, final := range objs {
+		if final {
+			continue
+		}
+		fname := path.Join(dir, obj+"."+string(StatusError))
+		err = stg.Save(fname, strings.NewReader(fmt.Sprintf("%d:%s", now, restoreAbortMsg)), -1)
+		if err != nil {
+			return errors.Wrapf(err, "write %s", fname)
+		}
+		marked++
+	}
+	if marked != 0 && grace > 0 {
+		l.Info("marked restore %s failed, waiting %v for agents to observe it", name, grace)
+		time.Sleep(grace)
+	}
+
+	// list again to catch whatever was written meanwhile
+	files, err = stg.List(dir, "")
+	if err != nil {
+		return errors.Wrap(err, "list restore files")
+	}
+	for _, f := range files {
+		err = stg.Delete(path.Join(dir, f.Name))
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return errors.Wrapf(err, "delete %s", f.Name)
+		}
+	}
+	err = stg.Delete(dir + ".json")
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return errors.Wrap(err, "delete restore meta")
+	}
+
+	l.Info("removed %d files of restore %s", len(files), name)
+	return nil
+}
+
+const syncHbSuffix = "hb"
+
+// splitSyncFile splits the sync file name into the object and status
+// (e.g. "rs.rs0/node.host:27017.done" into "rs.rs0/node.host:27017"
+// and "done")
+func splitSyncFile(name string) (obj, status string) {
+	name = statusFileName(name)
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return name, ""
+	}
+	return name[:i], name[i+1:]
+}
+
+func hbFresh(stg storage.Storage, name string, now int64) (bool, error) {
+	b, err := ReadStatusFile(stg, name)
+	if err != nil {
+		return false, errors.Wrapf(err, "read %s", name)
+	}
+	ts, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse %s", name)
+	}
+	return ts+PhysRestoreStaleSec >= now, nil
+}
@@ -0,0 +1,89 @@
+package pbm
+
+import (
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+// savesStorage records the names of the saved files
+type savesStorage struct {
+	storage.Storage
+	saved []string
+}
+
+func (s *savesStorage) Save(name string, data io.Reader, size int64) error {
+	s.saved = append(s.saved, name)
+	return s.Storage.Save(name, data, size)
+}
+
+func TestAbortAndCleanRestore(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("restore", "", "", primitive.Timestamp{})
+	stg := &savesStorage{Storage: fs.New(fs.Conf{Path: t.TempDir()})}
+	save := func(name, data string) {
+		t.Helper()
+		if err := stg.Storage.Save(name, strings.NewReader(data), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := path.Join(PhysRestoresDir, "rst")
+	stale := strconv.FormatInt(time.Now().Unix()-PhysRestoreStaleSec-10, 10)
+	save(dir+".json", "{}")
+	save(dir+"/cluster.starting", stale)
+	save(dir+"/cluster.hb", stale)
+	save(dir+"/rs.rs0/rs.starting", stale)
+	save(dir+"/rs.rs0/rs.hb", stale)
+	save(dir+"/rs.rs1/rs.error", stale+":failed")
+	save(dir+"/rs.rs0/node.host:27017.hb", strconv.FormatInt(time.Now().Unix(), 10))
+	save(dir+"/rs.rs0/log/host:27017.0.log", "log")
+
+	err := abortAndCleanRestore(stg, "rst", 0, l)
+	if !errors.Is(err, ErrRestoreAlive) {
+		t.Fatalf("live node: expected ErrRestoreAlive, got %v", err)
+	}
+	if len(stg.saved) != 0 {
+		t.Fatalf("live node: saved %v", stg.saved)
+	}
+
+	save(dir+"/rs.rs0/node.host:27017.hb", stale)
+	err = abortAndCleanRestore(stg, "rst", 0, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{dir + "/cluster.error": true, dir + "/rs.rs0/rs.error": true}
+	if len(stg.saved) != len(want) {
+		t.Errorf("saved %v, want %v", stg.saved, want)
+	}
+	for _, s := range stg.saved {
+		if !want[s] {
+			t.Errorf("unexpected %s saved", s)
+		}
+	}
+
+	files, err := stg.List(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("files left: %v", files)
+	}
+	if _, err := stg.FileStat(dir + ".json"); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("meta isn't removed: %v", err)
+	}
+
+	save(dir+"/cluster.done", stale)
+	if err := abortAndCleanRestore(stg, "rst", 0, l); err == nil {
+		t.Error("expected error on the succeeded restore")
+	}
+}
@@ -0,0 +1,166 @@
+package pbm
+
+import (
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// PhysRestoreBeatPrefix is the name prefix of the nodes' heartbeat objects
+// in the physical restore sync dir: rs.<rs>/beat.<node>
+const PhysRestoreBeatPrefix = "beat."
+
+// PhysRestoreBeat is the heartbeat of the physical restore's node along
+// with the beat it gives for its replset (unix time). It's a single object
+// per node written instead of the separate node.<node>.hb and rs.hb files,
+// so a node makes one write per heartbeat rather than two, and no object
+// is written by every node of the replset. The cluster beat stays a single
+// cluster.hb file written by the cluster leader only. Older agents still
+// write the separate files, which are read as well.
+type PhysRestoreBeat struct {
+	Node int64 `json:"node"`
+	RS   int64 `json:"rs"`
+	// Phase is the restore phase the node is in (see PhysRestorePhaseCopy)
+	// and Done of Total bytes is its progress in the copy phase
+	Phase string `json:"phase,omitempty"`
+	Done  int64  `json:"done,omitempty"`
+	Total int64  `json:"total,omitempty"`
+}
+
+// PhysRestoreBeatPath returns the path of the node's heartbeat object
+func PhysRestoreBeatPath(restore, rs, node string) string {
+	return path.Join(PhysRestoresDir, restore, "rs."+rs, PhysRestoreBeatPrefix+node)
+}
+
+// PhysRestoreClusterHbPath returns the path of the restore's cluster
+// heartbeat file
+func PhysRestoreClusterHbPath(restore string) string {
+	return path.Join(PhysRestoresDir, restore, "cluster."+syncHbSuffix)
+}
+
+// isPhysRestoreBeat tells if the sync file (relative to the restore
+// sync dir) is a node's heartbeat object
+func isPhysRestoreBeat(name string) bool {
+	p := strings.Split(name, "/")
+	return len(p) == 2 && strings.HasPrefix(p[0], "rs.") && strings.HasPrefix(p[1], PhysRestoreBeatPrefix)
+}
+
+// ReadPhysRestoreBeat reads the node's heartbeat object
+func ReadPhysRestoreBeat(stg storage.Storage, name string) (*PhysRestoreBeat, error) {
+	b, err := ReadStatusFile(stg, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", name)
+	}
+
+	hb := &PhysRestoreBeat{}
+	err = json.Unmarshal(b, hb)
+	return hb, errors.Wrapf(err, "decode %s", name)
+}
+
+// ReadPhysRestoreBeats returns the heartbeats of the restore's nodes
+// by "<rs>/<node>"
+func ReadPhysRestoreBeats(stg storage.Storage, restore string) (map[string]PhysRestoreBeat, error) {
+	dir := path.Join(PhysRestoresDir, restore)
+	files, err := stg.List(dir, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "list sync files")
+	}
+
+	rv := make(map[string]PhysRestoreBeat)
+	for _, f := range files {
+		if !isPhysRestoreBeat(f.Name) {
+			continue
+		}
+		hb, err := ReadPhysRestoreBeat(stg, path.Join(dir, f.Name))
+		if err != nil {
+			return nil, err
+		}
+		rs, node := path.Split(f.Name)
+		rv[strings.TrimPrefix(path.Clean(rs), "rs.")+"/"+strings.TrimPrefix(node, PhysRestoreBeatPrefix)] = *hb
+	}
+
+	return rv, nil
+}
+
+// LastPhysRestoreHb returns the last heartbeat (unix time) the restore
+// object got: "cluster", "rs.<rs>/rs" or "rs.<rs>/node.<node>". It's the
+// latest of the nodes' beats for the object and of its legacy .hb file.
+// The cluster has only the .hb file written by the cluster leader.
+// Zero means there is none.
+func LastPhysRestoreHb(stg storage.Storage, restore, obj string) (int64, error) {
+	dir := path.Join(PhysRestoresDir, restore)
+
+	var last int64
+	seen := func(t int64) {
+		if t > last {
+			last = t
+		}
+	}
+
+	legacy := path.Join(dir, obj+"."+syncHbSuffix)
+	ok, err := syncFileExists(stg, legacy)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		b, err := ReadStatusFile(stg, legacy)
+		if err != nil {
+			return 0, errors.Wrapf(err, "read %s", legacy)
+		}
+		t, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "parse %s", legacy)
+		}
+		seen(t)
+	}
+
+	rsDir, o := path.Split(obj)
+	switch {
+	case o == "rs":
+		files, err := stg.List(path.Join(dir, rsDir), "")
+		if err != nil {
+			return 0, errors.Wrap(err, "list sync files")
+		}
+		for _, f := range files {
+			if !isPhysRestoreBeat(path.Join(rsDir, f.Name)) {
+				continue
+			}
+			hb, err := ReadPhysRestoreBeat(stg, path.Join(dir, rsDir, f.Name))
+			if err != nil {
+				return 0, err
+			}
+			seen(hb.RS)
+		}
+	case strings.HasPrefix(o, "node."):
+		name := path.Join(dir, rsDir, PhysRestoreBeatPrefix+strings.TrimPrefix(o, "node."))
+		ok, err := syncFileExists(stg, name)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hb, err := ReadPhysRestoreBeat(stg, name)
+			if err != nil {
+				return 0, err
+			}
+			seen(hb.Node)
+		}
+	}
+
+	return last, nil
+}
+
+func syncFileExists(stg storage.Storage, name string) (bool, error) {
+	_, err := stg.FileStat(name)
+	if errors.Is(err, storage.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "get file %s", name)
+	}
+	return true, nil
+}
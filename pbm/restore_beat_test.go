@@ -0,0 +1,64 @@
+package pbm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestLastPhysRestoreHb(t *testing.T) {
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+	save := func(name, data string) {
+		t.Helper()
+		if err := stg.Save(name, strings.NewReader(data), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	beat := func(rs, node string, ts int64) {
+		b, _ := json.Marshal(PhysRestoreBeat{Node: ts, RS: ts})
+		save(PhysRestoreBeatPath("rst", rs, node), string(b))
+	}
+
+	dir := PhysRestoresDir + "/rst/"
+	beat("rs0", "n1.example.com:27017", 100)
+	beat("rs0", "n2.example.com:27017", 120)
+	beat("rs1", "n3.example.com:27017", 130)
+	// legacy files of an older agent
+	save(dir+"rs.rs1/node.n4.example.com:27017.hb", "140")
+	save(dir+"rs.rs1/rs.hb", "140")
+	save(dir+"cluster.hb", "110")
+	save(dir+"rs.rs0/node.n1.example.com:27017.done", "100")
+
+	cases := []struct {
+		obj  string
+		want int64
+	}{
+		{"rs.rs0/node.n1.example.com:27017", 100},
+		{"rs.rs0/node.n2.example.com:27017", 120},
+		{"rs.rs1/node.n4.example.com:27017", 140},
+		{"rs.rs0/node.none:27017", 0},
+		{"rs.rs0/rs", 120},
+		{"rs.rs1/rs", 140},
+		{"rs.rs2/rs", 0},
+		{"cluster", 110},
+	}
+	for _, c := range cases {
+		got, err := LastPhysRestoreHb(stg, "rst", c.obj)
+		if err != nil {
+			t.Fatalf("%s: %v", c.obj, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: expected %d, got %d", c.obj, c.want, got)
+		}
+	}
+
+	beats, err := ReadPhysRestoreBeats(stg, "rst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(beats) != 3 || beats["rs0/n2.example.com:27017"].Node != 120 || beats["rs1/n3.example.com:27017"].Node != 130 {
+		t.Errorf("unexpected beats %v", beats)
+	}
+}
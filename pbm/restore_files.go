@@ -0,0 +1,212 @@
+package pbm
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxFilesList is the max number of files listed in FileList kept in the
+// metadata and the agents' status. Only the largest ones are listed if there
+// are more, the totals cover all.
+const MaxFilesList = 1000
+
+// FileSize is the file (path relative to the dbpath) and its size in bytes
+type FileSize struct {
+	Name string `bson:"n" json:"name"`
+	Size int64  `bson:"s" json:"size"`
+}
+
+// FileList is the list of files with the totals
+type FileList struct {
+	Files []FileSize `bson:"files,omitempty" json:"files,omitempty"`
+	// Count and Size are the totals of all files, listed or not
+	Count int   `bson:"count" json:"count"`
+	Size  int64 `bson:"size" json:"size"`
+	// Truncated means only the largest files are listed
+	Truncated bool `bson:"truncated,omitempty" json:"truncated,omitempty"`
+}
+
+// NewFileList returns the list of `files` sorted by name. Only `max` largest
+// files are listed if `max` > 0.
+func NewFileList(files []FileSize, max int) *FileList {
+	l := &FileList{Count: len(files)}
+	for _, f := range files {
+		l.Size += f.Size
+	}
+
+	fs := append([]FileSize(nil), files...)
+	if max > 0 && len(fs) > max {
+		sort.SliceStable(fs, func(i, j int) bool { return fs[i].Size > fs[j].Size })
+		fs = fs[:max]
+		l.Truncated = true
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Name < fs[j].Name })
+	l.Files = fs
+
+	return l
+}
+
+// truncate returns the list with only `max` largest files listed
+func (l *FileList) truncate(max int) *FileList {
+	if l == nil || len(l.Files) <= max {
+		return l
+	}
+
+	t := NewFileList(l.Files, max)
+	t.Count, t.Size = l.Count, l.Size
+	return t
+}
+
+// RestoreFiles is what the physical restore replaces on the node: the files
+// in the dbpath the flush wipes and the backup files written instead
+type RestoreFiles struct {
+	Wipe  *FileList `bson:"wipe" json:"wipe"`
+	Write *FileList `bson:"write" json:"write"`
+}
+
+// BackupFiles returns the files the physical restore of the replset writes
+// to the dbpath. `chain` is the replset's files in the restored backup and
+// in each of its sources up to the base, the restored backup first (see
+// BackupChain). Only the files listed in the restored backup are written
+// and an incremental backup writes the whole file, so each one has the
+// size from the latest backup that has its data.
+func BackupFiles(chain [][]File) []FileSize {
+	if len(chain) == 0 {
+		return nil
+	}
+
+	target := make(map[string]struct{})
+	for _, f := range chain[0] {
+		target[f.Name] = struct{}{}
+	}
+
+	seen := make(map[string]struct{})
+	var rv []FileSize
+	for _, files := range chain {
+		for _, f := range files {
+			if _, ok := target[f.Name]; !ok || f.Off < 0 || f.Len < 0 {
+				continue
+			}
+			if _, ok := seen[f.Name]; ok {
+				continue
+			}
+			seen[f.Name] = struct{}{}
+			rv = append(rv, FileSize{Name: f.Name, Size: f.Size})
+		}
+	}
+
+	return rv
+}
+
+// BackupChain returns the backup and each of its sources up to
+// the incremental base, the backup first
+func (p *PBM) BackupChain(bcp *BackupMeta) ([]*BackupMeta, error) {
+	chain := []*BackupMeta{bcp}
+	for bcp.SrcBackup != "" {
+		src, err := p.GetBackupMeta(bcp.SrcBackup)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get source backup %s", bcp.SrcBackup)
+		}
+		// guard against loops in corrupted meta
+		for _, b := range chain {
+			if b.Name == src.Name {
+				return nil, errors.Errorf("chain is broken: loop at %s", src.Name)
+			}
+		}
+
+		chain = append(chain, src)
+		bcp = src
+	}
+
+	return chain, nil
+}
+
+// rsChainFiles returns the replset's files in each backup of the chain
+// (see BackupFiles)
+func rsChainFiles(chain []*BackupMeta, rs string) [][]File {
+	var rv [][]File
+	for _, b := range chain {
+		r := b.RS(rs)
+		if r == nil {
+			break
+		}
+		rv = append(rv, append(append([]File(nil), r.Files...), r.Journal...))
+	}
+	return rv
+}
+
+// NodeRestoreFiles is what the physical restore would replace on the node
+type NodeRestoreFiles struct {
+	RS   string `json:"rs"`
+	Node string `json:"node"`
+	RestoreFiles
+}
+
+// PlanRestoreFiles returns what the physical restore of the backup would
+// replace on each node the agents serve. `chain` is the backup and its
+// sources (see BackupChain). The dbpath content is the one the agents
+// listed since `since` (see CmdListDBpath), Wipe is nil if it's unknown.
+func PlanRestoreFiles(chain []*BackupMeta, agents []AgentStat, since primitive.Timestamp, rsMap map[string]string) []NodeRestoreFiles {
+	bcp := chain[0]
+	if bcp.Type != PhysicalBackup && bcp.Type != IncrementalBackup {
+		return nil
+	}
+
+	mapRevRS := MakeReverseRSMapFunc(rsMap)
+	var rv []NodeRestoreFiles
+	for _, a := range agents {
+		rs := mapRevRS(a.RS)
+		if bcp.RS(rs) == nil {
+			continue
+		}
+
+		n := NodeRestoreFiles{
+			RS:   a.RS,
+			Node: a.Node,
+			RestoreFiles: RestoreFiles{
+				Write: NewFileList(BackupFiles(rsChainFiles(chain, rs)), MaxFilesList),
+			},
+		}
+		if l := a.DBpath; l != nil && primitive.CompareTimestamp(l.TS, since) >= 0 {
+			n.Wipe = l.Files
+		}
+		rv = append(rv, n)
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].RS != rv[j].RS {
+			return rv[i].RS < rv[j].RS
+		}
+		return rv[i].Node < rv[j].Node
+	})
+	return rv
+}
+
+// DBpathList is the dbpath content the agent listed on request
+// (see CmdListDBpath)
+type DBpathList struct {
+	// TS is the cluster time of the listing
+	TS    primitive.Timestamp `bson:"ts"`
+	Files *FileList           `bson:"files,omitempty"`
+	// Err is why the dbpath can't be listed
+	Err string `bson:"e,omitempty"`
+}
+
+// SetAgentDBpathList records the dbpath content the node agent listed
+func (p *PBM) SetAgentDBpathList(rs, node string, l *DBpathList) error {
+	ct, err := p.ClusterTime()
+	if err != nil {
+		return errors.Wrap(err, "get cluster time")
+	}
+	l.TS = ct
+
+	_, err = p.Conn.Database(DB).Collection(AgentsStatusCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"n", node}, {"rs", rs}},
+		bson.D{{"$set", bson.M{"dbp": l}}},
+	)
+	return errors.Wrap(err, "write into db")
+}
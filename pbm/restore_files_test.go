@@ -0,0 +1,82 @@
+package pbm
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewFileList(t *testing.T) {
+	files := []FileSize{{"c.wt", 30}, {"a.wt", 10}, {"b.wt", 20}}
+
+	l := NewFileList(files, 2)
+	want := &FileList{Files: []FileSize{{"b.wt", 20}, {"c.wt", 30}}, Count: 3, Size: 60, Truncated: true}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("expected %+v, got %+v", want, l)
+	}
+	if l := NewFileList(files, 0); l.Truncated || len(l.Files) != 3 || l.Files[0].Name != "a.wt" {
+		t.Errorf("expected all files sorted, got %+v", l)
+	}
+	if l := l.truncate(1); l.Count != 3 || l.Size != 60 || len(l.Files) != 1 || l.Files[0].Name != "c.wt" {
+		t.Errorf("expected c.wt of 3 files, got %+v", l)
+	}
+}
+
+func TestPlanRestoreFiles(t *testing.T) {
+	base := &BackupMeta{
+		Name: "base",
+		Type: IncrementalBackup,
+		Replsets: []BackupReplset{{
+			Name: "rsA",
+			Files: []File{
+				{Name: "collection-1.wt", Off: 0, Len: 4096, Size: 4096},
+				{Name: "collection-2.wt", Off: 0, Len: 2048, Size: 2048},
+				{Name: "collection-3.wt", Off: 0, Len: 1024, Size: 1024},
+			},
+		}},
+	}
+	bcp := &BackupMeta{
+		Name:      "inc",
+		Type:      IncrementalBackup,
+		SrcBackup: "base",
+		Replsets: []BackupReplset{{
+			Name: "rsA",
+			Files: []File{
+				{Name: "collection-1.wt", Off: 0, Len: 4096, Size: 8192},
+				{Name: "collection-1.wt", Off: 4096, Len: 4096, Size: 8192},
+				// unchanged since the base
+				{Name: "collection-2.wt", Off: -1, Len: -1, Size: 2048},
+				{Name: "db1", Off: -1, Len: -1, Size: -1},
+			},
+			Journal: []File{{Name: "journal/WiredTigerLog.1", Size: 100}},
+		}},
+	}
+	since := primitive.Timestamp{T: 100}
+	agents := []AgentStat{
+		{RS: "rs1", Node: "n2:27017"},
+		{RS: "rs1", Node: "n1:27017", DBpath: &DBpathList{TS: since, Files: &FileList{Count: 5, Size: 500}}},
+		{RS: "rs1", Node: "n4:27017", DBpath: &DBpathList{TS: primitive.Timestamp{T: 99}, Files: &FileList{Count: 1}}},
+		{RS: "rs2", Node: "n3:27017"},
+	}
+
+	got := PlanRestoreFiles([]*BackupMeta{bcp, base}, agents, since, map[string]string{"rsA": "rs1"})
+	write := &FileList{
+		Files: []FileSize{{"collection-1.wt", 8192}, {"collection-2.wt", 2048}, {"journal/WiredTigerLog.1", 100}},
+		Count: 3,
+		Size:  10340,
+	}
+	want := []NodeRestoreFiles{
+		{RS: "rs1", Node: "n1:27017", RestoreFiles: RestoreFiles{Wipe: &FileList{Count: 5, Size: 500}, Write: write}},
+		{RS: "rs1", Node: "n2:27017", RestoreFiles: RestoreFiles{Write: write}},
+		{RS: "rs1", Node: "n4:27017", RestoreFiles: RestoreFiles{Write: write}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	bcp.Type = LogicalBackup
+	if got := PlanRestoreFiles([]*BackupMeta{bcp}, agents, since, nil); got != nil {
+		t.Errorf("expected nothing for the logical backup, got %+v", got)
+	}
+}
@@ -0,0 +1,90 @@
+package pbm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RestoreFollowUp returns the manual steps the finished restore requires
+// from the operator. Restores are done at the data level once all nodes
+// have the data, but some leave the cluster needing attention: physical
+// restores turn PITR off and leave the cluster down, failed nodes have to
+// be re-synced, and so on. It's nil for restores that aren't finished
+// successfully and for those that need nothing.
+func RestoreFollowUp(meta *RestoreMeta) []string {
+	if meta.Status != StatusDone && meta.Status != StatusPartlyDone {
+		return nil
+	}
+
+	var rv []string
+	if meta.Type == PhysicalBackup || meta.Type == IncrementalBackup {
+		rv = append(rv,
+			"Restart all mongod nodes and pbm-agents",
+			"Run `pbm config --force-resync` to get the backups list in sync with the storage")
+	}
+	if meta.PITRDisabled {
+		rv = append(rv, "PITR was disabled by the restore: re-enable it with "+
+			"`pbm config --set pitr.enabled=true` once the resync is done")
+	}
+	if meta.WiredTigerSalvage {
+		rv = append(rv, "The data was salvaged with `mongod --repair` and may be incomplete: "+
+			"check the internal mongod log for what was dropped and validate the collections")
+	}
+	if meta.NewReplsetID {
+		rv = append(rv, "The replsets got new replicaSetIds: members that weren't restored "+
+			"(arbiters, nodes without a pbm-agent) keep the old ones and are rejected by the restored members. "+
+			"Wipe their dbpath and let them initial sync")
+	}
+	if meta.PITRChunks || meta.PITRDisabled {
+		rv = append(rv, "Make a fresh backup: oplog slices made before the restore "+
+			"can't be replayed on top of the restored data")
+	}
+
+	for _, rs := range meta.Replsets {
+		for _, n := range rs.Nodes {
+			if n.Status == StatusDone {
+				continue
+			}
+			if rs.Status == StatusPartlyDone || meta.Status == StatusPartlyDone {
+				rv = append(rv, fmt.Sprintf("Node %s/%s failed to restore: "+
+					"wipe its dbpath and re-sync it from the restored members (initial sync)", rs.Name, n.Name))
+			}
+		}
+		for _, n := range rs.Roster {
+			if n.Participation == RosterMissingAgent {
+				rv = append(rv, fmt.Sprintf("Node %s/%s had no running pbm-agent and wasn't restored: "+
+					"wipe its dbpath and re-sync it from the restored members (initial sync)", rs.Name, n.Name))
+			}
+		}
+
+		var invalid []string
+		for _, n := range rs.Nodes {
+			for _, v := range n.Validate {
+				if !v.Valid {
+					invalid = append(invalid, n.Name+": "+v.NS)
+				}
+			}
+		}
+		if len(invalid) != 0 {
+			rv = append(rv, fmt.Sprintf("Collections failed the post-restore validation on %s (%s): "+
+				"check the data", rs.Name, strings.Join(invalid, ", ")))
+		}
+		if rs.Sample != nil && len(rs.Sample.Failed) != 0 {
+			var nss []string
+			for _, f := range rs.Sample.Failed {
+				nss = append(nss, f.NS)
+			}
+			rv = append(rv, fmt.Sprintf("Sampled documents don't match the backup on %s (%s): "+
+				"check the data", rs.Name, strings.Join(nss, ", ")))
+		}
+	}
+
+	for _, m := range meta.Mongos {
+		if !m.Pending && !m.Restarted && !m.Flushed {
+			rv = append(rv, fmt.Sprintf("mongos %s runs with the routing table from before the restore: "+
+				"restart it or run `flushRouterConfig` on it", m.Host))
+		}
+	}
+
+	return rv
+}
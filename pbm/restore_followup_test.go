@@ -0,0 +1,73 @@
+package pbm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRestoreFollowUp(t *testing.T) {
+	meta := &RestoreMeta{
+		Type:         PhysicalBackup,
+		Status:       StatusPartlyDone,
+		PITRDisabled: true,
+		Replsets: []RestoreReplset{
+			{
+				Name:   "rs1",
+				Status: StatusPartlyDone,
+				Nodes: []RestoreNode{
+					{Name: "rs101:27017", Status: StatusDone},
+					{Name: "rs102:27017", Status: StatusError},
+				},
+				Roster: []RosterNode{
+					{Name: "rs103:27017", Participation: RosterMissingAgent},
+				},
+			},
+		},
+		Mongos: []MongosCheck{
+			{Host: "mongos1:27017", Reachable: true, Restarted: true},
+			{Host: "mongos2:27017", Reachable: true},
+			{Host: "mongos3:27017", Pending: true},
+		},
+	}
+
+	steps := RestoreFollowUp(meta)
+	for _, want := range []string{"--force-resync", "pitr.enabled", "fresh backup", "rs1/rs102:27017", "rs1/rs103:27017", "mongos2"} {
+		found := false
+		for _, s := range steps {
+			if strings.Contains(s, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no step mentions %q: %v", want, steps)
+		}
+	}
+	for _, s := range steps {
+		if strings.Contains(s, "rs101") || strings.Contains(s, "mongos1") || strings.Contains(s, "mongos3") {
+			t.Errorf("unexpected step: %s", s)
+		}
+	}
+
+	meta = &RestoreMeta{Type: PhysicalBackup, Status: StatusDone}
+	for _, s := range RestoreFollowUp(meta) {
+		if strings.Contains(s, "pitr.enabled") || strings.Contains(s, "fresh backup") {
+			t.Errorf("physical restore with no PITR: unexpected step: %s", s)
+		}
+	}
+
+	meta = &RestoreMeta{Type: LogicalBackup, Status: StatusDone}
+	if steps = RestoreFollowUp(meta); len(steps) != 0 {
+		t.Errorf("logical restore with no PITR chunks: expected no steps, got %v", steps)
+	}
+	meta.PITRChunks = true
+	steps = RestoreFollowUp(meta)
+	if len(steps) != 1 || !strings.Contains(steps[0], "fresh backup") {
+		t.Errorf("logical restore: expected only the fresh backup step, got %v", steps)
+	}
+
+	meta.Status = StatusError
+	if steps = RestoreFollowUp(meta); steps != nil {
+		t.Errorf("failed restore: expected no steps, got %v", steps)
+	}
+}
@@ -0,0 +1,28 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+)
+
+// MinOplogSizeMB is the smallest oplog size mongod accepts
+const MinOplogSizeMB = 990
+
+// ValidateOplogSizeMB checks the oplog size requested for the physically
+// restored nodes (see RestoreCmd.OplogSizeMB). Zero means not set.
+func ValidateOplogSizeMB(mb float64) error {
+	if mb != 0 && mb < MinOplogSizeMB {
+		return errors.Errorf("oplog size should be at least %d MB", MinOplogSizeMB)
+	}
+	return nil
+}
+
+// RestoreOplog is the oplog size (in bytes) of the physically restored node
+type RestoreOplog struct {
+	// TargetSize is the node's oplog size before the restore.
+	// Zero if unknown.
+	TargetSize int64 `bson:"target_size,omitempty" json:"target_size,omitempty"`
+	// BackupSize is the oplog size of the backup's data
+	BackupSize int64 `bson:"backup_size" json:"backup_size"`
+	// Size is the oplog size the node ends up with
+	Size int64 `bson:"size" json:"size"`
+}
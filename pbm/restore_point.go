@@ -0,0 +1,127 @@
+package pbm
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RestorePoint is the latest restorable point strictly before some target
+// time (e.g. the time of an erroneous operation)
+type RestorePoint struct {
+	// TS is the point the data will be restored to
+	TS primitive.Timestamp
+	// Backup is the snapshot to restore or the base snapshot
+	// of the point-in-time restore
+	Backup *BackupMeta
+	// Timeline is the PITR timeline covering TS. It is nil if the point
+	// is the snapshot's LastWriteTS so no oplog replay is needed.
+	Timeline *Timeline
+	// Loss is the time between TS and the target. Changes made
+	// during that time won't be restored.
+	Loss time.Duration
+}
+
+// IsPITR tells if the point requires oplog replay
+func (p *RestorePoint) IsPITR() bool {
+	return p.Timeline != nil
+}
+
+// prevTS returns the latest timestamp that is strictly before ts
+func prevTS(ts primitive.Timestamp) primitive.Timestamp {
+	if ts.I > 0 {
+		return primitive.Timestamp{T: ts.T, I: ts.I - 1}
+	}
+	return primitive.Timestamp{T: ts.T - 1, I: math.MaxUint32}
+}
+
+// FindRestorePointBefore returns the latest point strictly before `target`
+// the cluster can be restored to. It is either the last write of a full
+// snapshot or a point covered by PITR timeline that has a logical base
+// snapshot in it. The snapshot restore is preferred over the equal PITR one.
+func FindRestorePointBefore(target primitive.Timestamp, bcps []BackupMeta, tlns []Timeline) (*RestorePoint, error) {
+	if target.T == 0 {
+		return nil, errors.New("undefined target time")
+	}
+	before := prevTS(target)
+
+	var rp *RestorePoint
+	better := func(ts primitive.Timestamp) bool {
+		return rp == nil || primitive.CompareTimestamp(ts, rp.TS) > 0
+	}
+
+	for i := range bcps {
+		b := &bcps[i]
+		if b.Status != StatusDone || len(b.Namespaces) != 0 {
+			continue
+		}
+		if primitive.CompareTimestamp(b.LastWriteTS, before) > 0 || !better(b.LastWriteTS) {
+			continue
+		}
+		rp = &RestorePoint{TS: b.LastWriteTS, Backup: b}
+	}
+
+	for i := range tlns {
+		tl := &tlns[i]
+		if tl.Start > before.T {
+			continue
+		}
+
+		// timelines have the precision of a second and the last chunk may
+		// end anywhere within tl.End, so the last second can't be fully used
+		ts := before
+		if ts.T >= tl.End {
+			ts = primitive.Timestamp{T: tl.End}
+		}
+		if !better(ts) {
+			continue
+		}
+
+		// the latest logical snapshot within the timeline before the point
+		var base *BackupMeta
+		for j := range bcps {
+			b := &bcps[j]
+			if b.Status != StatusDone || len(b.Namespaces) != 0 ||
+				(b.Type != LogicalBackup && b.Type != "") {
+				continue
+			}
+			if b.LastWriteTS.T < tl.Start || primitive.CompareTimestamp(b.LastWriteTS, ts) >= 0 {
+				continue
+			}
+			if base == nil || primitive.CompareTimestamp(b.LastWriteTS, base.LastWriteTS) > 0 {
+				base = b
+			}
+		}
+		if base == nil {
+			continue
+		}
+
+		rp = &RestorePoint{TS: ts, Backup: base, Timeline: tl}
+	}
+
+	if rp == nil {
+		return nil, errors.Errorf("no restorable point found before %v", target)
+	}
+
+	rp.Loss = time.Duration(target.T-rp.TS.T) * time.Second
+
+	return rp, nil
+}
+
+// RestorePointBefore returns the latest restorable point strictly
+// before `target` (see FindRestorePointBefore)
+func (p *PBM) RestorePointBefore(target primitive.Timestamp) (*RestorePoint, error) {
+	bcps, err := p.BackupsDoneList(nil, 0, -1)
+	if err != nil {
+		return nil, errors.Wrap(err, "get backups list")
+	}
+
+	tlns, err := p.PITRTimelines()
+	if err != nil {
+		return nil, errors.Wrap(err, "get PITR timelines")
+	}
+
+	return FindRestorePointBefore(target, bcps, tlns)
+}
@@ -0,0 +1,69 @@
+package pbm
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFindRestorePointBefore(t *testing.T) {
+	bcps := []BackupMeta{
+		{Name: "l1", Type: LogicalBackup, Status: StatusDone, LastWriteTS: primitive.Timestamp{T: 100, I: 1}},
+		{Name: "p1", Type: PhysicalBackup, Status: StatusDone, LastWriteTS: primitive.Timestamp{T: 200, I: 1}},
+		{Name: "s1", Type: LogicalBackup, Status: StatusDone, LastWriteTS: primitive.Timestamp{T: 250, I: 1}, Namespaces: []string{"db.c"}},
+		{Name: "l2", Type: LogicalBackup, Status: StatusError, LastWriteTS: primitive.Timestamp{T: 260, I: 1}},
+	}
+	tlns := []Timeline{{Start: 90, End: 150}, {Start: 180, End: 300}}
+
+	cases := []struct {
+		target primitive.Timestamp
+		ts     primitive.Timestamp
+		bcp    string
+		pitr   bool
+		loss   time.Duration
+		err    bool
+	}{
+		{target: primitive.Timestamp{T: 50}, err: true},
+		{target: primitive.Timestamp{T: 100, I: 1}, err: true},
+		{
+			target: primitive.Timestamp{T: 101},
+			ts:     primitive.Timestamp{T: 100, I: math.MaxUint32},
+			bcp:    "l1", pitr: true, loss: time.Second,
+		},
+		{
+			target: primitive.Timestamp{T: 120, I: 5},
+			ts:     primitive.Timestamp{T: 120, I: 4},
+			bcp:    "l1", pitr: true,
+		},
+		// the last second of the timeline can't be used
+		{
+			target: primitive.Timestamp{T: 170},
+			ts:     primitive.Timestamp{T: 150},
+			bcp:    "l1", pitr: true, loss: 20 * time.Second,
+		},
+		// no logical base within the second timeline
+		{
+			target: primitive.Timestamp{T: 250},
+			ts:     primitive.Timestamp{T: 200, I: 1},
+			bcp:    "p1", loss: 50 * time.Second,
+		},
+	}
+
+	for _, c := range cases {
+		rp, err := FindRestorePointBefore(c.target, bcps, tlns)
+		if (err != nil) != c.err {
+			t.Errorf("%v: expected error %v, got %v", c.target, c.err, err)
+			continue
+		}
+		if c.err {
+			continue
+		}
+
+		if rp.TS != c.ts || rp.Backup.Name != c.bcp || rp.IsPITR() != c.pitr || rp.Loss != c.loss {
+			t.Errorf("%v: expected %v/%s/pitr %v/loss %v, got %v/%s/pitr %v/loss %v",
+				c.target, c.ts, c.bcp, c.pitr, c.loss, rp.TS, rp.Backup.Name, rp.IsPITR(), rp.Loss)
+		}
+	}
+}
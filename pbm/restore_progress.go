@@ -0,0 +1,43 @@
+package pbm
+
+// PhysRestoreProgressFile is the file in the physical restore sync dir
+// the cluster leader keeps the restore progress in (see RestoreProgress)
+const PhysRestoreProgressFile = "cluster.progress"
+
+// Phases of the physical restore on a node reported in its heartbeats.
+// Only the copy phase has measurable progress.
+const (
+	PhysRestorePhaseCopy = "copyFiles"
+	// PhysRestorePhaseWait is the node waiting for the rest of the cluster
+	// once its data is restored
+	PhysRestorePhaseWait = "wait"
+)
+
+// RestoreProgress is the progress of the physical restore's nodes
+// as the cluster leader sees it
+type RestoreProgress struct {
+	// TS is the unix time the progress was estimated at
+	TS int64 `json:"ts"`
+	// Slowest is the node expected to be the last to finish
+	Slowest *NodeProgress `json:"slowest,omitempty"`
+	// ETA is the unix time the slowest node is expected to finish its
+	// phase by. Zero means it's unknown.
+	ETA   int64          `json:"eta,omitempty"`
+	Nodes []NodeProgress `json:"nodes,omitempty"`
+}
+
+// NodeProgress is the progress of the node of the physical restore
+type NodeProgress struct {
+	// Node is "<rs>/<host>"
+	Node  string `json:"node"`
+	Phase string `json:"phase,omitempty"`
+	Done  int64  `json:"done,omitempty"`
+	Total int64  `json:"total,omitempty"`
+	// Rate is the copy rate (bytes/sec) since the previous heartbeat
+	Rate float64 `json:"rate,omitempty"`
+	// ETA is the unix time the node is expected to finish the copy by
+	ETA int64 `json:"eta,omitempty"`
+	// SlowSince is the unix time the node's rate dropped below
+	// RestoreConf.SlowNodeRateFraction of the cluster median
+	SlowSince int64 `json:"slow_since,omitempty"`
+}
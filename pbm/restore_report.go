@@ -0,0 +1,107 @@
+package pbm
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RestoreReport is the outcome of the restore. It's assembled by the restore
+// leader once the restore reached the final state, persisted along with the
+// restore meta and sent as the NotifyRestoreFinished notification. It has
+// everything to confirm the cluster is recovered (or to escalate) without
+// any further queries.
+type RestoreReport struct {
+	Name   string     `bson:"name" json:"name"`
+	OPID   string     `bson:"opid" json:"opid"`
+	Type   BackupType `bson:"type" json:"type"`
+	Backup string     `bson:"backup" json:"backup"`
+	Status Status     `bson:"status" json:"status"`
+	Error  string     `bson:"error,omitempty" json:"error,omitempty"`
+	// RecoveryPoint is the cluster time the data is restored to
+	RecoveryPoint primitive.Timestamp `bson:"recovery_point" json:"recovery_point"`
+	// Bytes is the size of the backup data restored
+	Bytes       int64                  `bson:"bytes" json:"bytes"`
+	StartTS     int64                  `bson:"start_ts" json:"start_ts"`
+	FinishTS    int64                  `bson:"finish_ts" json:"finish_ts"`
+	DurationSec int64                  `bson:"duration_sec" json:"duration_sec"`
+	Replsets    []RestoreReportReplset `bson:"replsets" json:"replsets"`
+	// PartlyDone lists the nodes that failed while the rest of their
+	// replset was restored (the "partlyDone" status)
+	PartlyDone []RestoreReportNode `bson:"partly_done,omitempty" json:"partly_done,omitempty"`
+	// FollowUp is the manual steps the restore requires (see RestoreFollowUp)
+	FollowUp []string `bson:"follow_up,omitempty" json:"follow_up,omitempty"`
+}
+
+type RestoreReportReplset struct {
+	Name   string              `bson:"name" json:"name"`
+	Status Status              `bson:"status" json:"status"`
+	Error  string              `bson:"error,omitempty" json:"error,omitempty"`
+	Nodes  []RestoreReportNode `bson:"nodes,omitempty" json:"nodes,omitempty"`
+}
+
+type RestoreReportNode struct {
+	Replset string `bson:"rs,omitempty" json:"rs,omitempty"`
+	Name    string `bson:"name" json:"name"`
+	Status  Status `bson:"status" json:"status"`
+	Error   string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// MakeRestoreReport builds the report of the finished restore. `bcp` is
+// the restored backup, nil if there is none (oplog replay).
+func MakeRestoreReport(meta *RestoreMeta, bcp *BackupMeta) *RestoreReport {
+	rep := &RestoreReport{
+		Name:     meta.Name,
+		OPID:     meta.OPID,
+		Type:     meta.Type,
+		Backup:   meta.Backup,
+		Status:   meta.Status,
+		Error:    meta.Error,
+		StartTS:  meta.StartTS,
+		FinishTS: meta.LastTransitionTS,
+	}
+	if rep.FinishTS > rep.StartTS {
+		rep.DurationSec = rep.FinishTS - rep.StartTS
+	}
+
+	switch {
+	case !meta.StopTS.IsZero():
+		rep.RecoveryPoint = meta.StopTS
+	case meta.PITR != 0:
+		rep.RecoveryPoint = primitive.Timestamp{T: uint32(meta.PITR)}
+	case bcp != nil:
+		rep.RecoveryPoint = bcp.LastWriteTS
+	}
+	if bcp != nil {
+		rep.Bytes = bcp.Size
+		if rep.Type == "" {
+			rep.Type = bcp.Type
+		}
+	}
+
+	for _, rs := range meta.Replsets {
+		rrs := RestoreReportReplset{Name: rs.Name, Status: rs.Status, Error: rs.Error}
+		for _, n := range rs.Nodes {
+			rrs.Nodes = append(rrs.Nodes, RestoreReportNode{Name: n.Name, Status: n.Status, Error: n.Error})
+			if n.Status != StatusDone && (rs.Status == StatusPartlyDone || meta.Status == StatusPartlyDone) {
+				rep.PartlyDone = append(rep.PartlyDone,
+					RestoreReportNode{Replset: rs.Name, Name: n.Name, Status: n.Status, Error: n.Error})
+			}
+		}
+		rep.Replsets = append(rep.Replsets, rrs)
+	}
+	rep.FollowUp = RestoreFollowUp(meta)
+
+	return rep
+}
+
+// SetRestoreReport saves the report and the follow-up steps
+// into the restore meta
+func (p *PBM) SetRestoreReport(name string, rep *RestoreReport) error {
+	_, err := p.Conn.Database(DB).Collection(RestoresCollection).UpdateOne(
+		p.ctx,
+		bson.D{{"name", name}},
+		bson.D{{"$set", bson.M{"report": rep, "follow_up": rep.FollowUp}}},
+	)
+
+	return err
+}
@@ -0,0 +1,119 @@
+package pbm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMakeRestoreReport(t *testing.T) {
+	meta := &RestoreMeta{
+		Name:             "2023-01-01T00:00:00Z",
+		OPID:             "op1",
+		Type:             PhysicalBackup,
+		Backup:           "bcp1",
+		Status:           StatusPartlyDone,
+		StartTS:          1000,
+		LastTransitionTS: 1600,
+		Replsets: []RestoreReplset{
+			{
+				Name:   "rs1",
+				Status: StatusDone,
+				Nodes: []RestoreNode{
+					{Name: "rs101:27017", Status: StatusDone},
+					{Name: "rs102:27017", Status: StatusDone},
+				},
+			},
+			{
+				Name:   "rs2",
+				Status: StatusPartlyDone,
+				Nodes: []RestoreNode{
+					{Name: "rs201:27017", Status: StatusDone},
+					{Name: "rs202:27017", Status: StatusError, Error: "copy files: no space left"},
+				},
+			},
+		},
+	}
+	bcp := &BackupMeta{Size: 1 << 30, LastWriteTS: primitive.Timestamp{T: 900, I: 3}}
+
+	rep := MakeRestoreReport(meta, bcp)
+	if rep.DurationSec != 600 {
+		t.Errorf("expected duration 600, got %d", rep.DurationSec)
+	}
+	if rep.RecoveryPoint != bcp.LastWriteTS {
+		t.Errorf("expected recovery point %v, got %v", bcp.LastWriteTS, rep.RecoveryPoint)
+	}
+	if rep.Bytes != bcp.Size {
+		t.Errorf("expected %d bytes, got %d", bcp.Size, rep.Bytes)
+	}
+	if len(rep.Replsets) != 2 || len(rep.Replsets[1].Nodes) != 2 {
+		t.Fatalf("unexpected replsets %+v", rep.Replsets)
+	}
+	if len(rep.PartlyDone) != 1 || rep.PartlyDone[0].Replset != "rs2" || rep.PartlyDone[0].Name != "rs202:27017" {
+		t.Errorf("unexpected partlyDone %+v", rep.PartlyDone)
+	}
+
+	meta.PITR = 1200
+	if rep = MakeRestoreReport(meta, bcp); rep.RecoveryPoint.T != 1200 {
+		t.Errorf("expected PITR recovery point 1200, got %v", rep.RecoveryPoint)
+	}
+	meta.StopTS = primitive.Timestamp{T: 1190, I: 1}
+	if rep = MakeRestoreReport(meta, bcp); rep.RecoveryPoint != meta.StopTS {
+		t.Errorf("expected stop ts recovery point %v, got %v", meta.StopTS, rep.RecoveryPoint)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var got struct {
+		Event NotifyEvent   `json:"event"`
+		Data  RestoreReport `json:"data"`
+	}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	err := Notify(context.Background(), NotifyConf{Webhook: srv.URL}, NotifyRestoreFinished,
+		&RestoreReport{Name: "r1", Status: StatusDone})
+	if err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if got.Event != NotifyRestoreFinished || got.Data.Name != "r1" || got.Data.Status != StatusDone {
+		t.Errorf("unexpected notification %+v", got)
+	}
+
+	status = http.StatusInternalServerError
+	err = Notify(context.Background(), NotifyConf{Webhook: srv.URL}, NotifyRestoreFinished, &RestoreReport{})
+	if err == nil {
+		t.Error("expected error on 500 response")
+	}
+
+	if err = Notify(context.Background(), NotifyConf{}, NotifyRestoreFinished, nil); err != nil {
+		t.Errorf("expected no-op without webhook, got %v", err)
+	}
+}
+
+func TestPhysRestoreAfter(t *testing.T) {
+	r := &RestoreMeta{Name: "2023-01-01T10:00:00.5Z", StartTS: 1672567200}
+	names := []string{
+		"2023-01-01T09:00:00.123456789Z",
+		"2023-01-01T10:00:00.5Z",
+		"not-a-restore",
+	}
+	if n := physRestoreAfter(names, r); n != "" {
+		t.Errorf("expected none, got %s", n)
+	}
+
+	names = append(names, "2023-01-01T12:00:00.1Z", "2023-01-01T11:00:00.12Z")
+	if n := physRestoreAfter(names, r); n != "2023-01-01T12:00:00.1Z" {
+		t.Errorf("expected the latest one, got %q", n)
+	}
+}
@@ -0,0 +1,35 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SnapshotOnlyRecoveryTS checks the backup can be restored in the
+// snapshot-only mode (see RestoreCmd.SnapshotOnly) and returns the
+// earliest checkpoint of its replsets. Each replset lands at its own
+// checkpoint, so that's the point the data of all of them reflects.
+func SnapshotOnlyRecoveryTS(bcp *BackupMeta) (primitive.Timestamp, error) {
+	var ts primitive.Timestamp
+
+	switch bcp.Type {
+	case PhysicalBackup:
+	case IncrementalBackup:
+		return ts, errors.New("snapshot-only restore isn't possible for incremental backups: " +
+			"increments are taken at different points and have to be rolled forward to the backup point")
+	default:
+		return ts, errors.Errorf("snapshot-only restore is for physical backups, got %s", bcp.Type)
+	}
+
+	for _, rs := range bcp.Replsets {
+		if rs.CheckpointTS.IsZero() {
+			return ts, errors.Errorf("no checkpoint timestamp of %s in the backup "+
+				"(made by an older PBM version)", rs.Name)
+		}
+		if ts.IsZero() || primitive.CompareTimestamp(rs.CheckpointTS, ts) < 0 {
+			ts = rs.CheckpointTS
+		}
+	}
+
+	return ts, nil
+}
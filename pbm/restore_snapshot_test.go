@@ -0,0 +1,41 @@
+package pbm
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSnapshotOnlyRecoveryTS(t *testing.T) {
+	bcp := &BackupMeta{
+		Type: PhysicalBackup,
+		Replsets: []BackupReplset{
+			{Name: "cfg", CheckpointTS: primitive.Timestamp{T: 100, I: 2}, LastWriteTS: primitive.Timestamp{T: 120}},
+			{Name: "rs0", CheckpointTS: primitive.Timestamp{T: 100, I: 1}, LastWriteTS: primitive.Timestamp{T: 120}},
+			{Name: "rs1", CheckpointTS: primitive.Timestamp{T: 110, I: 1}, LastWriteTS: primitive.Timestamp{T: 120}},
+		},
+	}
+
+	ts, err := SnapshotOnlyRecoveryTS(bcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (primitive.Timestamp{T: 100, I: 1}); ts != want {
+		t.Errorf("got %v, want %v", ts, want)
+	}
+
+	bcp.Type = IncrementalBackup
+	if _, err := SnapshotOnlyRecoveryTS(bcp); err == nil {
+		t.Error("incremental backup is accepted")
+	}
+	bcp.Type = LogicalBackup
+	if _, err := SnapshotOnlyRecoveryTS(bcp); err == nil {
+		t.Error("logical backup is accepted")
+	}
+
+	bcp.Type = PhysicalBackup
+	bcp.Replsets[1].CheckpointTS = primitive.Timestamp{}
+	if _, err := SnapshotOnlyRecoveryTS(bcp); err == nil {
+		t.Error("backup with no checkpoint timestamp is accepted")
+	}
+}
@@ -0,0 +1,199 @@
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultJitter is the fraction the waits are randomly spread by unless
+// the tuning sets another one (see Tuning)
+const DefaultJitter = 0.1
+
+// Policy is how the operation is retried
+type Policy struct {
+	// Attempts is the max number of calls. At least one call is made.
+	Attempts int
+	// Backoff is the wait before the first retry
+	Backoff time.Duration
+	// Factor grows the wait of each next retry. Zero or one keeps it
+	// the same.
+	Factor float64
+	// Linear makes the n-th retry wait n*Backoff instead (Factor is ignored)
+	Linear bool
+	// MaxBackoff caps the wait. Zero means no cap.
+	MaxBackoff time.Duration
+}
+
+// Delay returns the wait before the n-th retry (starting with 1) without
+// the jitter
+func (p Policy) Delay(n int) time.Duration {
+	if n < 1 {
+		n = 1
+	}
+
+	var d float64
+	switch {
+	case p.Linear:
+		d = float64(p.Backoff) * float64(n)
+	case p.Factor > 1:
+		d = float64(p.Backoff) * math.Pow(p.Factor, float64(n-1))
+	default:
+		d = float64(p.Backoff)
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// Tuning adjusts all policies of the process at once (see SetTuning).
+// Zero values keep the policies as they are.
+type Tuning struct {
+	// AttemptsFactor scales the attempts of each policy
+	AttemptsFactor float64
+	// BackoffFactor scales the waits of each policy
+	BackoffFactor float64
+	// MaxBackoff caps the wait of any policy
+	MaxBackoff time.Duration
+	// Jitter is the fraction the waits are randomly spread by.
+	// Zero means DefaultJitter.
+	Jitter float64
+}
+
+// Apply returns the policy adjusted by the tuning
+func (t Tuning) Apply(p Policy) Policy {
+	if t.AttemptsFactor > 0 {
+		p.Attempts = int(math.Round(float64(p.Attempts) * t.AttemptsFactor))
+	}
+	if p.Attempts < 1 {
+		p.Attempts = 1
+	}
+	if t.BackoffFactor > 0 {
+		p.Backoff = time.Duration(float64(p.Backoff) * t.BackoffFactor)
+		p.MaxBackoff = time.Duration(float64(p.MaxBackoff) * t.BackoffFactor)
+	}
+	if t.MaxBackoff > 0 && (p.MaxBackoff == 0 || p.MaxBackoff > t.MaxBackoff) {
+		p.MaxBackoff = t.MaxBackoff
+	}
+	return p
+}
+
+func (t Tuning) jitter() float64 {
+	if t.Jitter > 0 {
+		return t.Jitter
+	}
+	return DefaultJitter
+}
+
+var (
+	mu     sync.Mutex
+	tuning Tuning
+	rnd    = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// SetTuning sets the tuning of the process' retries
+func SetTuning(t Tuning) {
+	mu.Lock()
+	defer mu.Unlock()
+	tuning = t
+}
+
+// GetTuning returns the tuning of the process' retries
+func GetTuning() Tuning {
+	mu.Lock()
+	defer mu.Unlock()
+	return tuning
+}
+
+// Jitter returns `d` randomly spread by the process' jitter (see Tuning).
+// So the retries and polls of the nodes that started together don't hit
+// mongod or the storage in bursts.
+func Jitter(d time.Duration) time.Duration {
+	return JitterBy(d, GetTuning().jitter())
+}
+
+// JitterBy returns `d` randomly spread by the `f` fraction regardless of
+// the tuning. For the waits that have to stay close to the nominal one,
+// e.g. heartbeats checked for staleness against a fixed frame.
+func JitterBy(d time.Duration, f float64) time.Duration {
+	spread := int64(float64(d) * f)
+	if spread <= 0 {
+		return d
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	return d - time.Duration(spread) + time.Duration(rnd.Int63n(2*spread+1))
+}
+
+// Attempt is the failed call about to be retried
+type Attempt struct {
+	// N is the number of the failed call (starting with 1)
+	N int
+	// Of is the max number of calls
+	Of   int
+	Err  error
+	Wait time.Duration
+}
+
+type options struct {
+	retryable func(error) bool
+	onRetry   func(Attempt)
+}
+
+// Option is the option of Do
+type Option func(*options)
+
+// If retries only the errors `pred` returns true for. Others are
+// returned right away. All errors are retried by default.
+func If(pred func(error) bool) Option {
+	return func(o *options) {
+		o.retryable = pred
+	}
+}
+
+// OnRetry calls `f` before each wait (e.g. to log the failed call)
+func OnRetry(f func(Attempt)) Option {
+	return func(o *options) {
+		o.onRetry = f
+	}
+}
+
+// Do calls `fn` until it succeeds, returns an error that isn't retryable
+// (see If) or the attempts of the policy (adjusted by the process' tuning)
+// are exhausted. It returns the last error of `fn` or the context's error
+// if the context is done while waiting.
+func Do(ctx context.Context, p Policy, fn func() error, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	p = GetTuning().Apply(p)
+
+	var err error
+	for i := 1; ; i++ {
+		err = fn()
+		if err == nil || i >= p.Attempts || o.retryable != nil && !o.retryable(err) {
+			return err
+		}
+
+		wait := Jitter(p.Delay(i))
+		if o.onRetry != nil {
+			o.onRetry(Attempt{N: i, Of: p.Attempts, Err: err, Wait: wait})
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errFatal     = errors.New("fatal")
+)
+
+func isTransient(err error) bool { return errors.Is(err, errTransient) }
+
+func TestDoPredicate(t *testing.T) {
+	p := Policy{Attempts: 5, Backoff: time.Millisecond}
+
+	cases := []struct {
+		name  string
+		errs  []error
+		want  error
+		calls int
+	}{
+		{"succeeds", nil, nil, 1},
+		{"retried then succeeds", []error{errTransient, errTransient}, nil, 3},
+		{"not retryable", []error{errTransient, errFatal, errTransient}, errFatal, 2},
+		{"exhausted", []error{errTransient, errTransient, errTransient, errTransient, errTransient, errTransient},
+			errTransient, 5},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			calls, retries := 0, 0
+			err := Do(context.Background(), p,
+				func() error {
+					calls++
+					if calls <= len(c.errs) {
+						return c.errs[calls-1]
+					}
+					return nil
+				},
+				If(isTransient),
+				OnRetry(func(a Attempt) {
+					retries++
+					if a.N != retries || a.Of != p.Attempts || !isTransient(a.Err) {
+						t.Errorf("unexpected attempt %+v", a)
+					}
+				}))
+			if !errors.Is(err, c.want) || (err == nil) != (c.want == nil) {
+				t.Errorf("got error %v, want %v", err, c.want)
+			}
+			if calls != c.calls {
+				t.Errorf("got %d calls, want %d", calls, c.calls)
+			}
+			if retries != calls-1 {
+				t.Errorf("got %d retries for %d calls", retries, calls)
+			}
+		})
+	}
+}
+
+func TestDoRetriesAllByDefault(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), Policy{Attempts: 3, Backoff: time.Millisecond}, func() error {
+		calls++
+		return errFatal
+	})
+	if !errors.Is(err, errFatal) || calls != 3 {
+		t.Errorf("got %v after %d calls, want %v after 3", err, calls, errFatal)
+	}
+}
+
+func TestDoContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := Do(ctx, Policy{Attempts: 10, Backoff: time.Hour}, func() error {
+		calls++
+		return errTransient
+	}, OnRetry(func(Attempt) { cancel() }))
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("got %v after %d calls, want %v after 1", err, calls, context.Canceled)
+	}
+}
+
+func TestPolicyDelay(t *testing.T) {
+	cases := []struct {
+		name string
+		p    Policy
+		want []time.Duration
+	}{
+		{"constant", Policy{Backoff: time.Second}, []time.Duration{time.Second, time.Second, time.Second}},
+		{"linear", Policy{Backoff: time.Second, Factor: 3, Linear: true},
+			[]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+		{"exponential", Policy{Backoff: time.Second, Factor: 2, MaxBackoff: 3 * time.Second},
+			[]time.Duration{time.Second, 2 * time.Second, 3 * time.Second}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for i, want := range c.want {
+				if got := c.p.Delay(i + 1); got != want {
+					t.Errorf("retry %d: got %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTuningApply(t *testing.T) {
+	p := Policy{Attempts: 5, Backoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	got := Tuning{}.Apply(p)
+	if got != p {
+		t.Errorf("zero tuning: got %+v, want %+v", got, p)
+	}
+
+	got = Tuning{AttemptsFactor: 2, BackoffFactor: 0.5, MaxBackoff: 4 * time.Second}.Apply(p)
+	want := Policy{Attempts: 10, Backoff: 500 * time.Millisecond, MaxBackoff: 4 * time.Second}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	got = Tuning{AttemptsFactor: 0.01}.Apply(p)
+	if got.Attempts != 1 {
+		t.Errorf("got %d attempts, want at least 1", got.Attempts)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	defer SetTuning(GetTuning())
+
+	for _, j := range []float64{0, 0.5} {
+		SetTuning(Tuning{Jitter: j})
+		frac := j
+		if frac == 0 {
+			frac = DefaultJitter
+		}
+
+		d := 10 * time.Second
+		min, max := d-time.Duration(float64(d)*frac), d+time.Duration(float64(d)*frac)
+		for i := 0; i < 1000; i++ {
+			if got := Jitter(d); got < min || got > max {
+				t.Fatalf("jitter %v: got %v, want within [%v, %v]", j, got, min, max)
+			}
+		}
+	}
+
+	// the fixed jitter ignores the tuning
+	SetTuning(Tuning{Jitter: 0.9})
+	d := 10 * time.Second
+	for i := 0; i < 1000; i++ {
+		if got := JitterBy(d, 0.05); got < d-d/20 || got > d+d/20 {
+			t.Fatalf("fixed jitter: got %v, want within 5%% of %v", got, d)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package pbm
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/retry"
+)
+
+// RetryConf tunes the retries of the agents' operations (e.g. connecting to
+// the mongod started by the physical restore, dropping config.system.sessions,
+// opening the backup cursor) all at once. Each operation keeps its own
+// defaults, zero values leave them as they are.
+type RetryConf struct {
+	// AttemptsFactor scales the number of attempts of each operation
+	AttemptsFactor float64 `bson:"attemptsFactor,omitempty" json:"attemptsFactor,omitempty" yaml:"attemptsFactor,omitempty"`
+	// BackoffFactor scales the waits between the attempts
+	BackoffFactor float64 `bson:"backoffFactor,omitempty" json:"backoffFactor,omitempty" yaml:"backoffFactor,omitempty"`
+	// MaxBackoffSec caps any wait between the attempts
+	MaxBackoffSec float64 `bson:"maxBackoffSec,omitempty" json:"maxBackoffSec,omitempty" yaml:"maxBackoffSec,omitempty"`
+	// Jitter is the fraction the waits and the sync polls are randomly
+	// spread by. Defaults to retry.DefaultJitter.
+	Jitter float64 `bson:"jitter,omitempty" json:"jitter,omitempty" yaml:"jitter,omitempty"`
+}
+
+func validateRetryConf(c *RetryConf) error {
+	if c == nil {
+		return nil
+	}
+	if c.AttemptsFactor < 0 || c.BackoffFactor < 0 || c.MaxBackoffSec < 0 {
+		return errors.New("retry options can't be negative")
+	}
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		return errors.New("retry.jitter should be in the range [0, 1)")
+	}
+	return nil
+}
+
+// Tuning returns the tuning of the process' retries
+func (c *RetryConf) Tuning() retry.Tuning {
+	if c == nil {
+		return retry.Tuning{}
+	}
+
+	return retry.Tuning{
+		AttemptsFactor: c.AttemptsFactor,
+		BackoffFactor:  c.BackoffFactor,
+		MaxBackoff:     time.Duration(c.MaxBackoffSec * float64(time.Second)),
+		Jitter:         c.Jitter,
+	}
+}
+
+// ApplyRetryConf sets the tuning of the process' retries from the config
+func ApplyRetryConf(c Config) {
+	retry.SetTuning(c.Retry.Tuning())
+}
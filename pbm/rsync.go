@@ -16,6 +16,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/percona/percona-backup-mongodb/pbm/archive"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
 	"github.com/percona/percona-backup-mongodb/pbm/log"
 	"github.com/percona/percona-backup-mongodb/pbm/storage"
 	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
@@ -42,30 +43,9 @@ func (p *PBM) ResyncStorage(l *log.Event) error {
 		return errors.Wrap(err, "init storage")
 	}
 
-	rstrs, err := stg.List(PhysRestoresDir, ".json")
+	err = p.SyncPhysRestores(stg, true, l)
 	if err != nil {
-		return errors.Wrap(err, "get physical restores list from the storage")
-	}
-	l.Debug("got physical restores list: %v", len(rstrs))
-	for _, rs := range rstrs {
-		rname := strings.TrimSuffix(rs.Name, ".json")
-		rmeta, err := GetPhysRestoreMeta(rname, stg, l)
-		if err != nil {
-			l.Error("get meta for restore %s: %v", rs.Name, err)
-			if rmeta == nil {
-				continue
-			}
-		}
-
-		_, err = p.Conn.Database(DB).Collection(RestoresCollection).ReplaceOne(
-			p.ctx,
-			bson.D{{"name", rmeta.Name}},
-			rmeta,
-			options.Replace().SetUpsert(true),
-		)
-		if err != nil {
-			return errors.Wrapf(err, "upsert restore %s/%s", rmeta.Name, rmeta.Backup)
-		}
+		return err
 	}
 
 	bcps, err := stg.List("", MetadataFileSuffix)
@@ -239,6 +219,7 @@ func GetPhysRestoreMeta(restore string, stg storage.Storage, l *log.Event) (rmet
 	}
 
 	if rmeta == nil {
+		condsm.FollowUp = RestoreFollowUp(condsm)
 		return condsm, err
 	}
 
@@ -254,10 +235,79 @@ func GetPhysRestoreMeta(restore string, stg storage.Storage, l *log.Event) (rmet
 	rmeta.Conditions = condsm.Conditions
 	rmeta.Type = PhysicalBackup
 	rmeta.Stat = condsm.Stat
+	rmeta.Canary = condsm.Canary
+	rmeta.PartlyDonePolicy = condsm.PartlyDonePolicy
+	rmeta.PITRDisabled = condsm.PITRDisabled
+	rmeta.Progress = condsm.Progress
+	if condsm.Report != nil {
+		rmeta.Report = condsm.Report
+	}
+	rmeta.FollowUp = RestoreFollowUp(rmeta)
 
 	return rmeta, err
 }
 
+// SyncPhysRestores mirrors physical restores meta from the storage into
+// the RestoresCollection so all restores can be queried from the db.
+// The storage remains the source of truth for physical restores. Unless
+// `all` is set, restores that already have a final status in the db
+// are skipped.
+func (p *PBM) SyncPhysRestores(stg storage.Storage, all bool, l *log.Event) error {
+	rstrs, err := stg.List(PhysRestoresDir, ".json")
+	if err != nil {
+		return errors.Wrap(err, "get physical restores list from the storage")
+	}
+	l.Debug("got physical restores list: %v", len(rstrs))
+	for _, rs := range rstrs {
+		rname := strings.TrimSuffix(rs.Name, ".json")
+		if !all {
+			m, err := p.GetRestoreMeta(rname)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return errors.Wrapf(err, "get restore %s meta from db", rname)
+			}
+			if m != nil && isFinalStatus(m.Status) {
+				continue
+			}
+		}
+
+		rmeta, err := GetPhysRestoreMeta(rname, stg, l)
+		if err != nil {
+			l.Error("get meta for restore %s: %v", rs.Name, err)
+			if rmeta == nil {
+				continue
+			}
+		}
+
+		_, err = p.Conn.Database(DB).Collection(RestoresCollection).ReplaceOne(
+			p.ctx,
+			bson.D{{"name", rmeta.Name}},
+			rmeta,
+			options.Replace().SetUpsert(true),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "upsert restore %s/%s", rmeta.Name, rmeta.Backup)
+		}
+
+		if rmeta.Status == StatusDone && rmeta.Backup != "" && len(rmeta.Namespaces) == 0 {
+			err = p.SetBackupRestoreTested(rmeta.Backup, rmeta.Name, rmeta.LastTransitionTS)
+			if err != nil {
+				l.Warning("mark backup %s as restore-tested: %v", rmeta.Backup, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func isFinalStatus(s Status) bool {
+	switch s {
+	case StatusDone, StatusPartlyDone, StatusError, StatusCancelled:
+		return true
+	}
+
+	return false
+}
+
 // ParsePhysRestoreStatus parses phys restore's sync files and creates RestoreMeta.
 //
 // On files format, see comments for *PhysRestore.toState() in pbm/restore/physical.go
@@ -275,7 +325,7 @@ func ParsePhysRestoreStatus(restore string, stg storage.Storage, l *log.Event) (
 	})
 
 	for _, f := range rfiles {
-		parts := strings.SplitN(f.Name, ".", 2)
+		parts := strings.SplitN(statusFileName(f.Name), ".", 2)
 		if len(parts) != 2 {
 			continue
 		}
@@ -338,8 +388,107 @@ func ParsePhysRestoreStatus(restore string, stg storage.Storage, l *log.Event) (
 					rs.rs.LastTransitionTS = l.Timestamp
 					rs.rs.Error = l.Error
 				}
+			case "beat":
+				hb, err := ReadPhysRestoreBeat(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+				if err != nil {
+					l.Error("get heartbeat file %s: %v", f.Name, err)
+					break
+				}
+				nName := strings.Join(p[1:], ".")
+				node, ok := rs.nodes[nName]
+				if !ok {
+					node.Name = nName
+				}
+				node.Hb.T = maxUint32(node.Hb.T, uint32(hb.Node))
+				rs.nodes[nName] = node
+				rs.rs.Hb.T = maxUint32(rs.rs.Hb.T, uint32(hb.RS))
+			case "roster":
+				b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+				if err != nil {
+					l.Error("get roster file %s: %v", f.Name, err)
+					break
+				}
+				rs.rs.Roster = nil
+				err = json.Unmarshal(b, &rs.rs.Roster)
+				if err != nil {
+					l.Error("unmarshal roster file %s: %v", f.Name, err)
+				}
+			case "validate":
+				b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+				if err != nil {
+					l.Error("get validate file %s: %v", f.Name, err)
+					break
+				}
+				nName := strings.Join(p[1:], ".")
+				node, ok := rs.nodes[nName]
+				if !ok {
+					node.Name = nName
+				}
+				err = json.Unmarshal(b, &node.Validate)
+				if err != nil {
+					l.Error("unmarshal validate file %s: %v", f.Name, err)
+					break
+				}
+				rs.nodes[nName] = node
+			case "port":
+				b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+				if err != nil {
+					l.Error("get port file %s: %v", f.Name, err)
+					break
+				}
+				nName := strings.Join(p[1:], ".")
+				node, ok := rs.nodes[nName]
+				if !ok {
+					node.Name = nName
+				}
+				node.TmpPort, err = strconv.Atoi(strings.TrimSpace(string(b)))
+				if err != nil {
+					l.Error("parse port file %s: %v", f.Name, err)
+					break
+				}
+				rs.nodes[nName] = node
+			case "oplog":
+				b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+				if err != nil {
+					l.Error("get oplog file %s: %v", f.Name, err)
+					break
+				}
+				nName := strings.Join(p[1:], ".")
+				node, ok := rs.nodes[nName]
+				if !ok {
+					node.Name = nName
+				}
+				node.Oplog = new(RestoreOplog)
+				err = json.Unmarshal(b, node.Oplog)
+				if err != nil {
+					l.Error("unmarshal oplog file %s: %v", f.Name, err)
+					break
+				}
+				rs.nodes[nName] = node
+			case "files":
+				b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+				if err != nil {
+					l.Error("get files file %s: %v", f.Name, err)
+					break
+				}
+				nName := strings.Join(p[1:], ".")
+				node, ok := rs.nodes[nName]
+				if !ok {
+					node.Name = nName
+				}
+				var fs RestoreFiles
+				err = json.Unmarshal(b, &fs)
+				if err != nil {
+					l.Error("unmarshal files file %s: %v", f.Name, err)
+					break
+				}
+				node.Files = &RestoreFiles{
+					Wipe:  fs.Wipe.truncate(MaxFilesList),
+					Write: fs.Write.truncate(MaxFilesList),
+				}
+				rs.nodes[nName] = node
 			case "stat":
-				src, err := stg.SourceReader(filepath.Join(PhysRestoresDir, restore, f.Name))
+				b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
 				if err != nil {
 					l.Error("get stat file %s: %v", f.Name, err)
 					break
@@ -350,7 +499,7 @@ func ParsePhysRestoreStatus(restore string, stg storage.Storage, l *log.Event) (
 				st := struct {
 					D s3.DownloadStat `json:"d"`
 				}{}
-				err = json.NewDecoder(src).Decode(&st)
+				err = json.Unmarshal(b, &st)
 				if err != nil {
 					l.Error("unmarshal stat file %s: %v", f.Name, err)
 					break
@@ -363,7 +512,63 @@ func ParsePhysRestoreStatus(restore string, stg storage.Storage, l *log.Event) (
 			}
 			rss[rsName] = rs
 
+		case "canary":
+			cond, err := parsePhysRestoreCond(stg, f.Name, restore)
+			if err != nil {
+				return nil, err
+			}
+			meta.Canary = &RestoreCanary{
+				Replset:   strings.TrimSuffix(parts[1], "."+string(cond.Status)),
+				Status:    cond.Status,
+				Timestamp: cond.Timestamp,
+				Error:     cond.Error,
+			}
+		case "report":
+			b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+			if err != nil {
+				l.Error("get report file %s: %v", f.Name, err)
+				break
+			}
+			rep := new(RestoreReport)
+			err = json.Unmarshal(b, rep)
+			if err != nil {
+				l.Error("unmarshal report file %s: %v", f.Name, err)
+				break
+			}
+			meta.Report = rep
+		case "partlydone":
+			b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+			if err != nil {
+				l.Error("get partlyDone policy file %s: %v", f.Name, err)
+				break
+			}
+			meta.PartlyDonePolicy = PartlyDonePolicy(b)
+		case "pitr":
+			meta.PITRDisabled = statusFileName(f.Name) == PhysRestorePITRDisabledFile
+		case "mongos":
+			b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+			if err != nil {
+				l.Error("get mongos file %s: %v", f.Name, err)
+				break
+			}
+			err = json.Unmarshal(b, &meta.Mongos)
+			if err != nil {
+				l.Error("unmarshal mongos file %s: %v", f.Name, err)
+			}
 		case "cluster":
+			if statusFileName(f.Name) == PhysRestoreProgressFile {
+				b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, f.Name))
+				if err != nil {
+					l.Error("get progress file %s: %v", f.Name, err)
+					break
+				}
+				meta.Progress = new(RestoreProgress)
+				err = json.Unmarshal(b, meta.Progress)
+				if err != nil {
+					l.Error("unmarshal progress file %s: %v", f.Name, err)
+				}
+				break
+			}
 			cond, err := parsePhysRestoreCond(stg, f.Name, restore)
 			if err != nil {
 				return nil, err
@@ -386,6 +591,14 @@ func ParsePhysRestoreStatus(restore string, stg storage.Storage, l *log.Event) (
 	for _, rs := range rss {
 		noerr := 0
 		nodeErr := ""
+		// a node reporting to the sync dir took part in the restore
+		// even if its agent was missing on the start
+		for i, n := range rs.rs.Roster {
+			if node, ok := rs.nodes[n.Name]; ok {
+				rs.rs.Roster[i].Participation = RosterParticipated
+				rs.rs.Roster[i].Status = node.Status
+			}
+		}
 		for _, node := range rs.nodes {
 			rs.rs.Nodes = append(rs.rs.Nodes, node)
 			if node.Status != StatusError {
@@ -408,18 +621,29 @@ func ParsePhysRestoreStatus(restore string, stg storage.Storage, l *log.Event) (
 		meta.Replsets = append(meta.Replsets, rs.rs)
 	}
 
+	if meta.Stat != nil {
+		meta.Stat.rollupRetries()
+	}
+	// the progress is of the running restore only
+	if isFinalStatus(meta.Status) {
+		meta.Progress = nil
+	}
+
 	return &meta, nil
 }
 
+func maxUint32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func parsePhysRestoreCond(stg storage.Storage, fname, restore string) (*Condition, error) {
-	s := strings.Split(fname, ".")
+	s := strings.Split(statusFileName(fname), ".")
 	cond := Condition{Status: Status(s[len(s)-1])}
 
-	src, err := stg.SourceReader(filepath.Join(PhysRestoresDir, restore, fname))
-	if err != nil {
-		return nil, errors.Wrapf(err, "get file %s", fname)
-	}
-	b, err := io.ReadAll(src)
+	b, err := ReadStatusFile(stg, filepath.Join(PhysRestoresDir, restore, fname))
 	if err != nil {
 		return nil, errors.Wrapf(err, "read file %s", fname)
 	}
@@ -444,3 +668,67 @@ func parsePhysRestoreCond(stg storage.Storage, fname, restore string) (*Conditio
 
 	return &cond, nil
 }
+
+// PhysRestorePartlyDoneFile is the sync file with the PartlyDonePolicy
+// all nodes of the physical restore agreed on
+const PhysRestorePartlyDoneFile = "partlydone.policy"
+
+// PhysRestorePITRDisabledFile is the sync file the nodes leave if PITR was
+// on in the restored config (see RestoreMeta.PITRDisabled)
+const PhysRestorePITRDisabledFile = "pitr.disabled"
+
+// ReadStatusFile reads the content of the physical restore sync file.
+// Compression is detected by the file's suffix, so both plain and
+// compressed files can be read.
+func ReadStatusFile(stg storage.Storage, name string) ([]byte, error) {
+	src, err := stg.SourceReader(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "open")
+	}
+	defer src.Close()
+
+	c := compress.FileCompression(strings.TrimPrefix(path.Ext(name), "."))
+	r, err := compress.Decompress(src, c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decompress %s", c)
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// FindStatusFile returns the name of the physical restore sync file `name`
+// on the storage, plain or with the compression suffix (see ReadStatusFile).
+// It's storage.ErrNotExist if there is neither or the file is empty.
+func FindStatusFile(stg storage.Storage, name string) (string, error) {
+	_, err := stg.FileStat(name)
+	if err == nil {
+		return name, nil
+	}
+	if !errors.Is(err, storage.ErrNotExist) && !errors.Is(err, storage.ErrEmpty) {
+		return "", err
+	}
+
+	dir, base := path.Split(name)
+	files, err := stg.List(strings.TrimSuffix(dir, "/"), "")
+	if err != nil {
+		return "", errors.Wrap(err, "list")
+	}
+	for _, f := range files {
+		if f.Name != base && f.Size != 0 && statusFileName(f.Name) == base {
+			return path.Join(dir, f.Name), nil
+		}
+	}
+
+	return "", storage.ErrNotExist
+}
+
+// statusFileName returns the sync file name without the compression suffix
+func statusFileName(name string) string {
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	if compress.FileCompression(ext) == compress.CompressionTypeNone {
+		return name
+	}
+
+	return strings.TrimSuffix(name, "."+ext)
+}
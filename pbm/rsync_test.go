@@ -0,0 +1,250 @@
+package pbm
+
+import (
+	"bytes"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/s3"
+)
+
+func TestReadStatusFile(t *testing.T) {
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	for _, c := range []compress.CompressionType{
+		compress.CompressionTypeNone,
+		compress.CompressionTypePGZIP,
+		compress.CompressionTypeLZ4,
+		compress.CompressionTypeSNAPPY,
+		compress.CompressionTypeS2,
+		compress.CompressionTypeZstandard,
+	} {
+		name := "rs.rs1/node.host:27017.error" + c.Suffix()
+
+		buf := &bytes.Buffer{}
+		w, err := compress.Compress(buf, c, nil)
+		if err != nil {
+			t.Fatalf("%s: compress: %v", c, err)
+		}
+		if _, err = w.Write([]byte("1675000000:some error")); err != nil {
+			t.Fatalf("%s: write: %v", c, err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("%s: close: %v", c, err)
+		}
+		if err = stg.Save(name, buf, int64(buf.Len())); err != nil {
+			t.Fatalf("%s: save: %v", c, err)
+		}
+
+		b, err := ReadStatusFile(stg, name)
+		if err != nil {
+			t.Fatalf("%s: read: %v", c, err)
+		}
+		if string(b) != "1675000000:some error" {
+			t.Errorf("%s: got %q", c, b)
+		}
+
+		if n := statusFileName(name); n != "rs.rs1/node.host:27017.error" {
+			t.Errorf("%s: got name %q", c, n)
+		}
+	}
+}
+
+func TestFindStatusFile(t *testing.T) {
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	for name, content := range map[string]string{
+		"rs.rs1/node.a:27017.done":     "1675000000",
+		"rs.rs1/node.b:27017.done.zst": "compressed",
+		"rs.rs1/node.c:27017.done.s2":  "",
+	} {
+		if err := stg.Save(name, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"rs.rs1/node.a:27017.done": "rs.rs1/node.a:27017.done",
+		"rs.rs1/node.b:27017.done": "rs.rs1/node.b:27017.done.zst",
+		"rs.rs1/node.c:27017.done": "",
+		"rs.rs1/node.d:27017.done": "",
+	} {
+		got, err := FindStatusFile(stg, name)
+		if want == "" {
+			if !errors.Is(err, storage.ErrNotExist) {
+				t.Errorf("%s: expected not exist, got %q, %v", name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestParsePhysRestoreRoster(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	dir := path.Join(PhysRestoresDir, "r1")
+	for name, content := range map[string]string{
+		"rs.rs1/roster.json": `[{"name":"rs101:27017","participation":"participated"},` +
+			`{"name":"rs102:27017","participation":"missing-agent"},` +
+			`{"name":"rs103:27017","participation":"skipped-arbiter"}]`,
+		"rs.rs1/node.rs101:27017.done": "1675000010",
+		"rs.rs1/node.rs102:27017.done": "1675000020",
+		"rs.rs1/rs.done":               "1675000030",
+		"cluster.done":                 "1675000040",
+		PhysRestorePITRDisabledFile:    "1",
+	} {
+		if err := stg.Save(path.Join(dir, name), strings.NewReader(content), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	meta, err := ParsePhysRestoreStatus("r1", stg, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Replsets) != 1 {
+		t.Fatalf("expected 1 replset, got %+v", meta.Replsets)
+	}
+	if !meta.PITRDisabled {
+		t.Error("expected PITR to be marked as disabled")
+	}
+
+	// rs102 has reported after all
+	want := []RosterNode{
+		{Name: "rs101:27017", Participation: RosterParticipated, Status: StatusDone},
+		{Name: "rs102:27017", Participation: RosterParticipated, Status: StatusDone},
+		{Name: "rs103:27017", Participation: RosterSkippedArbiter},
+	}
+	if got := meta.Replsets[0].Roster; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected roster %v, got %v", want, got)
+	}
+}
+
+func TestParsePhysRestoreValidate(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	dir := path.Join(PhysRestoresDir, "r1")
+	for name, content := range map[string]string{
+		"rs.rs1/node.rs101:27017.done": "1675000010",
+		"rs.rs1/port.rs101:27017":      "28123",
+		"rs.rs1/oplog.rs101:27017":     `{"target_size":2147483648,"backup_size":1073741824,"size":2147483648}`,
+		"rs.rs1/files.rs101:27017": `{"wipe":{"files":[{"name":"a.wt","size":10},{"name":"b.wt","size":20}],"count":2,"size":30},` +
+			`"write":{"files":[{"name":"c.wt","size":5}],"count":1,"size":5}}`,
+		"rs.rs1/validate.rs101:27017": `[{"ns":"db.c1","valid":true,"duration_ms":10},` +
+			`{"ns":"db.c2","valid":false,"errors":["index a_1 is corrupted"],"duration_ms":20}]`,
+	} {
+		if err := stg.Save(path.Join(dir, name), strings.NewReader(content), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	meta, err := ParsePhysRestoreStatus("r1", stg, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Replsets) != 1 || len(meta.Replsets[0].Nodes) != 1 {
+		t.Fatalf("expected 1 node, got %+v", meta.Replsets)
+	}
+
+	n := meta.Replsets[0].Nodes[0]
+	want := []CollValidation{
+		{NS: "db.c1", Valid: true, DurationMs: 10},
+		{NS: "db.c2", Errors: []string{"index a_1 is corrupted"}, DurationMs: 20},
+	}
+	if n.Name != "rs101:27017" || n.Status != StatusDone || n.TmpPort != 28123 || !reflect.DeepEqual(n.Validate, want) {
+		t.Errorf("unexpected node %+v", n)
+	}
+	wantOplog := &RestoreOplog{TargetSize: 2 << 30, BackupSize: 1 << 30, Size: 2 << 30}
+	if !reflect.DeepEqual(n.Oplog, wantOplog) {
+		t.Errorf("expected oplog %+v, got %+v", wantOplog, n.Oplog)
+	}
+	wantFiles := &RestoreFiles{
+		Wipe:  &FileList{Files: []FileSize{{"a.wt", 10}, {"b.wt", 20}}, Count: 2, Size: 30},
+		Write: &FileList{Files: []FileSize{{"c.wt", 5}}, Count: 1, Size: 5},
+	}
+	if !reflect.DeepEqual(n.Files, wantFiles) {
+		t.Errorf("expected files %+v, got %+v", wantFiles, n.Files)
+	}
+}
+
+func TestParsePhysRestoreMongos(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	content := `[{"host":"mongos1:27017","reachable":true,"uptime":30,"restarted":true},` +
+		`{"host":"mongos2:27017","reachable":false,"restarted":false,"error":"ping: timeout"}]`
+	err := stg.Save(path.Join(PhysRestoresDir, "r1", "mongos.json"), strings.NewReader(content), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := ParsePhysRestoreStatus("r1", stg, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MongosCheck{
+		{Host: "mongos1:27017", Reachable: true, UptimeSec: 30, Restarted: true},
+		{Host: "mongos2:27017", Error: "ping: timeout"},
+	}
+	if !reflect.DeepEqual(meta.Mongos, want) {
+		t.Errorf("unexpected mongos %+v", meta.Mongos)
+	}
+}
+
+func TestParsePhysRestoreDownloadRetries(t *testing.T) {
+	l := log.New(nil, "", "").NewEvent("test", "", "", primitive.Timestamp{})
+	stg := fs.New(fs.Conf{Path: t.TempDir()})
+
+	dir := path.Join(PhysRestoresDir, "r1")
+	for name, content := range map[string]string{
+		"rs.rs1/node.rs101:27017.done": "1675000010",
+		"rs.rs1/stat.rs101:27017":      `{"d":{"retried":[{"name":"b/rs1/f1","retries":7},{"name":"b/rs1/f2","retries":2}]}}`,
+		"rs.rs2/node.rs201:27017.done": "1675000010",
+		"rs.rs2/stat.rs201:27017": `{"d":{"retried":[{"name":"b/rs2/f1","retries":4}],` +
+			`"failed":[{"name":"b/rs2/f1","retries":4,"error":"download: timeout"}]}}`,
+	} {
+		if err := stg.Save(path.Join(dir, name), strings.NewReader(content), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	meta, err := ParsePhysRestoreStatus("r1", stg, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Stat == nil {
+		t.Fatal("no stat")
+	}
+
+	f1 := s3.FileRetryStat{Name: "b/rs1/f1", Retries: 7}
+	f2 := s3.FileRetryStat{Name: "b/rs1/f2", Retries: 2}
+	f3 := s3.FileRetryStat{Name: "b/rs2/f1", Retries: 4}
+	wantRetried := []NodeFileRetryStat{
+		{Replset: "rs1", Node: "rs101:27017", FileRetryStat: f1},
+		{Replset: "rs2", Node: "rs201:27017", FileRetryStat: f3},
+		{Replset: "rs1", Node: "rs101:27017", FileRetryStat: f2},
+	}
+	if !reflect.DeepEqual(meta.Stat.Retried, wantRetried) {
+		t.Errorf("retried: got %+v, want %+v", meta.Stat.Retried, wantRetried)
+	}
+
+	f3.Error = "download: timeout"
+	wantFailed := []NodeFileRetryStat{{Replset: "rs2", Node: "rs201:27017", FileRetryStat: f3}}
+	if !reflect.DeepEqual(meta.Stat.Failed, wantFailed) {
+		t.Errorf("failed: got %+v, want %+v", meta.Stat.Failed, wantFailed)
+	}
+}
@@ -0,0 +1,97 @@
+package pbm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ShardSetChange is the difference between the shards of the backup and
+// the ones of the cluster it's restored into. The shards are replset names
+// on the target cluster (i.e. after the replsets remapping).
+type ShardSetChange struct {
+	// Added are the shards added to the cluster after the backup
+	Added []string `json:"added,omitempty" yaml:"added,omitempty"`
+	// Removed are the backup's shards the cluster no longer has
+	Removed []string `json:"removed,omitempty" yaml:"removed,omitempty"`
+}
+
+// Empty returns true if the backup and the cluster have the same shards
+func (c *ShardSetChange) Empty() bool {
+	return c == nil || len(c.Added) == 0 && len(c.Removed) == 0
+}
+
+// Consequences returns what the restore does to the changed shards and
+// the follow-ups they require
+func (c *ShardSetChange) Consequences() []string {
+	if c.Empty() {
+		return nil
+	}
+
+	var rv []string
+	for _, s := range c.Added {
+		rv = append(rv, fmt.Sprintf("shard %q was added after the backup: the restored config metadata "+
+			"doesn't know it and its data will be orphaned. After the restore, re-add the shard "+
+			"(`sh.addShard()`) and let the balancer rebalance, or clean up its data", s))
+	}
+	for _, s := range c.Removed {
+		rv = append(rv, fmt.Sprintf("shard %q was removed after the backup: the restored config metadata "+
+			"refers to a ghost shard. Either bring the replset back and map it with --replset-remapping, "+
+			"or drain and remove the ghost shard (`removeShard`) after the restore", s))
+	}
+	return rv
+}
+
+// CheckShardSetChange compares the shards the backup was taken from
+// against the current shards of the cluster. It's nil for non-sharded
+// clusters and backups.
+func (p *PBM) CheckShardSetChange(bcp *BackupMeta, rsMap map[string]string) (*ShardSetChange, error) {
+	inf, err := p.GetNodeInfo()
+	if err != nil {
+		return nil, errors.Wrap(err, "get node info")
+	}
+	if !inf.IsSharded() {
+		return nil, nil
+	}
+
+	shards, err := p.GetShards()
+	if err != nil {
+		return nil, errors.Wrap(err, "get shards")
+	}
+
+	return shardSetChange(bcp, shards, rsMap), nil
+}
+
+func shardSetChange(bcp *BackupMeta, shards []Shard, rsMap map[string]string) *ShardSetChange {
+	mapRS := MakeRSMapFunc(rsMap)
+
+	sharded := false
+	inBcp := make(map[string]bool)
+	for _, rs := range bcp.Replsets {
+		if rs.IsConfigSvr != nil && *rs.IsConfigSvr {
+			sharded = true
+			continue
+		}
+		inBcp[mapRS(rs.Name)] = true
+	}
+	if !sharded {
+		return nil
+	}
+
+	c := &ShardSetChange{}
+	for _, s := range shards {
+		if inBcp[s.RS] {
+			delete(inBcp, s.RS)
+			continue
+		}
+		c.Added = append(c.Added, s.RS)
+	}
+	for rs := range inBcp {
+		c.Removed = append(c.Removed, rs)
+	}
+	sort.Strings(c.Added)
+	sort.Strings(c.Removed)
+
+	return c
+}
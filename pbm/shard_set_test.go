@@ -0,0 +1,41 @@
+package pbm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShardSetChange(t *testing.T) {
+	cs := true
+	bcp := &BackupMeta{
+		Replsets: []BackupReplset{
+			{Name: "cfg", IsConfigSvr: &cs},
+			{Name: "rs0"},
+			{Name: "rs1"},
+		},
+	}
+
+	c := shardSetChange(bcp, []Shard{{ID: "rs0", RS: "rs0"}, {ID: "rs1", RS: "rs1"}}, nil)
+	if !c.Empty() {
+		t.Errorf("same shards: got %+v", c)
+	}
+
+	c = shardSetChange(bcp, []Shard{{ID: "rs0", RS: "rs0"}, {ID: "sh2", RS: "rs2"}}, nil)
+	want := &ShardSetChange{Added: []string{"rs2"}, Removed: []string{"rs1"}}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+	if len(c.Consequences()) != 2 {
+		t.Errorf("consequences: %v", c.Consequences())
+	}
+
+	c = shardSetChange(bcp, []Shard{{ID: "rs0", RS: "rs0"}, {ID: "rsX", RS: "rsX"}}, map[string]string{"rs1": "rsX"})
+	if !c.Empty() {
+		t.Errorf("remapped: got %+v", c)
+	}
+
+	c = shardSetChange(&BackupMeta{Replsets: []BackupReplset{{Name: "rs0"}}}, []Shard{{ID: "rs0", RS: "rs0"}}, nil)
+	if c != nil {
+		t.Errorf("non-sharded backup: got %+v", c)
+	}
+}
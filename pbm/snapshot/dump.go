@@ -27,7 +27,9 @@ type UploadDumpOptions struct {
 
 type UploadFunc func(ns, ext string, r io.Reader) error
 
-func UploadDump(wt io.WriterTo, upload UploadFunc, opts UploadDumpOptions) (int64, error) {
+// UploadDump splits the dump into namespaces and uploads them. It returns
+// the size of the uploaded data and of the dump before the compression.
+func UploadDump(wt io.WriterTo, upload UploadFunc, opts UploadDumpOptions) (int64, int64, error) {
 	wg := sync.WaitGroup{}
 	pr, pw := io.Pipe()
 	size := int64(0)
@@ -67,14 +69,15 @@ func UploadDump(wt io.WriterTo, upload UploadFunc, opts UploadDumpOptions) (int6
 		return dwc, errors.WithMessagef(err, "create compressor: %q", ns)
 	}
 
-	err := archive.Decompose(pr, newWriter, opts.NSFilter, opts.DocFilter)
+	raw := &readCounter{r: pr}
+	err := archive.Decompose(raw, newWriter, opts.NSFilter, opts.DocFilter)
 	wg.Wait()
-	return size, errors.WithMessage(err, "decompose")
+	return size, raw.n, errors.WithMessage(err, "decompose")
 }
 
 type DownloadFunc func(filename string) (io.ReadCloser, error)
 
-func DownloadDump(download DownloadFunc, compression compress.CompressionType, match archive.NSFilterFn) (io.ReadCloser, error) {
+func DownloadDump(download DownloadFunc, compression compress.CompressionType, match archive.NSFilterFn, metaFn archive.NSMetaFn) (io.ReadCloser, error) {
 	pr, pw := io.Pipe()
 
 	go func() {
@@ -96,7 +99,7 @@ func DownloadDump(download DownloadFunc, compression compress.CompressionType, m
 			return r, errors.WithMessagef(err, "create decompressor: %q", ns)
 		}
 
-		err := archive.Compose(pw, match, newReader)
+		err := archive.Compose(pw, match, metaFn, newReader)
 		pw.CloseWithError(errors.WithMessage(err, "compose"))
 	}()
 
@@ -18,17 +18,11 @@ const (
 	numInsertionWorkersDefault = 10
 )
 
-var ExcludeFromRestore = []string{
-	pbm.DB + "." + pbm.CmdStreamCollection,
-	pbm.DB + "." + pbm.LogCollection,
-	pbm.DB + "." + pbm.ConfigCollection,
-	pbm.DB + "." + pbm.BcpCollection,
-	pbm.DB + "." + pbm.RestoresCollection,
-	pbm.DB + "." + pbm.LockCollection,
-	pbm.DB + "." + pbm.LockOpCollection,
-	pbm.DB + "." + pbm.PITRChunksCollection,
-	pbm.DB + "." + pbm.AgentsStatusCollection,
-	pbm.DB + "." + pbm.PBMOpLogCollection,
+// ExcludeFromRestore are the namespaces the logical restore never brings
+// from a backup: the PBM collections and the cluster specific ones
+var ExcludeFromRestore = append(pbmNSs(pbm.Collections), clusterNSs...)
+
+var clusterNSs = []string{
 	"config.version",
 	"config.mongos",
 	"config.lockpings",
@@ -46,9 +40,56 @@ var ExcludeFromRestore = []string{
 	pbm.DB + ".pbmPITRChunks.old",
 }
 
+func pbmNSs(colls []string) []string {
+	rv := make([]string, 0, len(colls))
+	for _, c := range colls {
+		rv = append(rv, pbm.DB+"."+c)
+	}
+	return rv
+}
+
+// IsPBMNamespace tells if the namespace is a PBM collection
+func IsPBMNamespace(ns string) bool {
+	for _, c := range pbmNSs(pbm.Collections) {
+		if ns == c {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPBMStateNamespace tells if the namespace is one of pbm.StateCollections
+func IsPBMStateNamespace(ns string) bool {
+	for _, c := range pbmNSs(pbm.StateCollections) {
+		if ns == c {
+			return true
+		}
+	}
+	return false
+}
+
+// excludeNSs returns the namespaces to exclude from the restore.
+// With `withPBMState`, pbm.StateCollections are restored.
+func excludeNSs(withPBMState bool) []string {
+	if !withPBMState {
+		return ExcludeFromRestore
+	}
+
+	rv := make([]string, 0, len(ExcludeFromRestore))
+	for _, ns := range ExcludeFromRestore {
+		if !IsPBMStateNamespace(ns) {
+			rv = append(rv, ns)
+		}
+	}
+	return rv
+}
+
 type restorer struct{ *mongorestore.MongoRestore }
 
-func NewRestore(uri string, cfg *pbm.Config) (io.ReaderFrom, error) {
+// NewRestore creates the mongorestore of the dump. With `withPBMState`,
+// the PBM config and backups metadata are restored from the dump as well
+// (see pbm.StateCollections).
+func NewRestore(uri string, cfg *pbm.Config, withPBMState bool) (io.ReaderFrom, error) {
 	topts := options.New("mongorestore", "0.0.1", "none", "", true, options.EnabledOptions{Auth: true, Connection: true, Namespace: true, URI: true})
 	var err error
 	topts.URI, err = options.NewURI(uri)
@@ -89,7 +130,7 @@ func NewRestore(uri string, cfg *pbm.Config) (io.ReaderFrom, error) {
 		WriteConcern:             "majority",
 	}
 	mopts.NSOptions = &mongorestore.NSOptions{
-		NSExclude: ExcludeFromRestore,
+		NSExclude: excludeNSs(withPBMState),
 	}
 
 	mr, err := mongorestore.New(mopts)
@@ -0,0 +1,40 @@
+package snapshot
+
+import (
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+)
+
+func TestExcludeNSs(t *testing.T) {
+	has := func(nss []string, ns string) bool {
+		for _, n := range nss {
+			if n == ns {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, c := range pbm.Collections {
+		if !has(excludeNSs(false), pbm.DB+"."+c) {
+			t.Errorf("%s.%s should be excluded", pbm.DB, c)
+		}
+	}
+
+	withState := excludeNSs(true)
+	for _, c := range pbm.Collections {
+		ns := pbm.DB + "." + c
+		if has(withState, ns) == IsPBMStateNamespace(ns) {
+			t.Errorf("with the PBM state, %s excluded: %v", ns, has(withState, ns))
+		}
+	}
+	for _, ns := range []string{pbm.DB + "." + pbm.LockCollection, pbm.DB + "." + pbm.CmdStreamCollection, "config.version"} {
+		if !has(withState, ns) {
+			t.Errorf("with the PBM state, %s should be excluded", ns)
+		}
+	}
+	if len(excludeNSs(false)) != len(ExcludeFromRestore) {
+		t.Error("ExcludeFromRestore is changed")
+	}
+}
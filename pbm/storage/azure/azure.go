@@ -14,6 +14,7 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/pkg/errors"
 
 	"github.com/percona/percona-backup-mongodb/pbm/log"
@@ -93,6 +94,28 @@ func (b *Blob) Save(name string, data io.Reader, sizeb int64) error {
 	return err
 }
 
+// SaveIfNotExists saves the file unless it already exists using conditional
+// write (`If-None-Match: *`). It's meant for small files as the data is
+// uploaded in a single request.
+func (b *Blob) SaveIfNotExists(name string, data io.Reader, _ int64) error {
+	buf, err := io.ReadAll(data)
+	if err != nil {
+		return errors.Wrap(err, "read data")
+	}
+
+	etag := azcore.ETagAny
+	_, err = b.c.UploadBuffer(context.TODO(), b.opts.Container, path.Join(b.opts.Prefix, name), buf, &azblob.UploadBufferOptions{
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etag},
+		},
+	})
+	if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+		return storage.ErrExist
+	}
+
+	return err
+}
+
 func (b *Blob) List(prefix, suffix string) ([]storage.FileInfo, error) {
 	prfx := path.Join(b.opts.Prefix, prefix)
 
@@ -153,6 +176,9 @@ func (b *Blob) FileStat(name string) (inf storage.FileInfo, err error) {
 	if p.ContentLength != nil {
 		inf.Size = *p.ContentLength
 	}
+	if p.ETag != nil {
+		inf.ETag = string(*p.ETag)
+	}
 
 	if inf.Size == 0 {
 		return inf, storage.ErrEmpty
@@ -207,6 +233,17 @@ func (b *Blob) SourceReader(name string) (io.ReadCloser, error) {
 	return o.Body, nil
 }
 
+func (b *Blob) RangeReader(name string, offset, length int64) (io.ReadCloser, error) {
+	o, err := b.c.DownloadStream(context.TODO(), b.opts.Container, path.Join(b.opts.Prefix, name), &azblob.DownloadStreamOptions{
+		Range: azblob.HTTPRange{Offset: offset, Count: length},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "download object range")
+	}
+
+	return o.Body, nil
+}
+
 func (b *Blob) Delete(name string) error {
 	_, err := b.c.DeleteBlob(context.TODO(), b.opts.Container, path.Join(b.opts.Prefix, name), nil)
 	if err != nil {
@@ -219,6 +256,28 @@ func (b *Blob) Delete(name string) error {
 	return nil
 }
 
+// Lock returns the version-level immutability policy and legal hold of
+// the file
+func (b *Blob) Lock(name string) (storage.Lock, error) {
+	var l storage.Lock
+	p, err := b.c.ServiceClient().NewContainerClient(b.opts.Container).NewBlockBlobClient(path.Join(b.opts.Prefix, name)).GetProperties(context.TODO(), nil)
+	if err != nil {
+		if isNotFound(err) {
+			return l, nil
+		}
+		return l, errors.Wrap(err, "get properties")
+	}
+
+	if p.ImmutabilityPolicyExpiresOn != nil {
+		l.RetainUntil = *p.ImmutabilityPolicyExpiresOn
+	}
+	if p.LegalHold != nil {
+		l.LegalHold = *p.LegalHold
+	}
+
+	return l, nil
+}
+
 func (b *Blob) ensureContainer() error {
 	_, err := b.c.ServiceClient().NewContainerClient(b.opts.Container).GetProperties(context.TODO(), nil)
 	// container already exists
@@ -0,0 +1,47 @@
+//go:build linux
+
+package fs
+
+import (
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// cloneFile makes a copy-on-write clone (FICLONE) of the `src` file. The
+// clone is made into a temporary file which is then renamed to `dst`, so
+// a failed clone leaves no partial file behind.
+func cloneFile(src, dst string) error {
+	from, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "open src")
+	}
+	defer from.Close()
+
+	tmp, err := os.CreateTemp(path.Dir(dst), "."+path.Base(dst)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "create tmp file for <%s>", dst)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = unix.IoctlFileClone(int(tmp.Fd()), int(from.Fd()))
+	if err != nil {
+		if errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EOPNOTSUPP) ||
+			errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTTY) {
+			return storage.ErrServerSideCopyUnsupported
+		}
+		return errors.Wrapf(err, "clone <%s>", src)
+	}
+
+	err = os.Chmod(tmp.Name(), 0o644)
+	if err != nil {
+		return errors.Wrapf(err, "change permissions for file <%s>", tmp.Name())
+	}
+
+	return errors.Wrapf(os.Rename(tmp.Name(), dst), "rename to <%s>", dst)
+}
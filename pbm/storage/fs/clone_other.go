@@ -0,0 +1,9 @@
+//go:build !linux
+
+package fs
+
+import "github.com/percona/percona-backup-mongodb/pbm/storage"
+
+func cloneFile(_, _ string) error {
+	return storage.ErrServerSideCopyUnsupported
+}
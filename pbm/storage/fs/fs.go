@@ -64,12 +64,70 @@ func (fs *FS) Save(name string, data io.Reader, _ int64) error {
 	return errors.Wrap(fw.Sync(), "write to file")
 }
 
+// SaveIfNotExists saves the file unless it already exists. The data is
+// written into a temporary file first which is then hard-linked to the
+// destination, so the file appears atomically and link(2) fails if it's
+// already there.
+func (fs *FS) SaveIfNotExists(name string, data io.Reader, _ int64) error {
+	filepath := path.Join(fs.opts.Path, name)
+
+	err := os.MkdirAll(path.Dir(filepath), os.ModeDir|0o755)
+	if err != nil {
+		return errors.Wrapf(err, "create path %s", path.Dir(filepath))
+	}
+
+	tmp, err := os.CreateTemp(path.Dir(filepath), "."+path.Base(filepath)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "create tmp file for <%s>", filepath)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	err = os.Chmod(tmp.Name(), 0o644)
+	if err != nil {
+		return errors.Wrapf(err, "change permissions for file <%s>", tmp.Name())
+	}
+	_, err = io.Copy(tmp, data)
+	if err != nil {
+		return errors.Wrapf(err, "copy file <%s>", tmp.Name())
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return errors.Wrap(err, "write to file")
+	}
+
+	err = os.Link(tmp.Name(), filepath)
+	if errors.Is(err, os.ErrExist) {
+		return storage.ErrExist
+	}
+
+	return errors.Wrapf(err, "link file <%s>", filepath)
+}
+
 func (fs *FS) SourceReader(name string) (io.ReadCloser, error) {
 	filepath := path.Join(fs.opts.Path, name)
 	fr, err := os.Open(filepath)
 	return fr, errors.Wrapf(err, "open file '%s'", filepath)
 }
 
+func (fs *FS) RangeReader(name string, offset, length int64) (io.ReadCloser, error) {
+	filepath := path.Join(fs.opts.Path, name)
+	fr, err := os.Open(filepath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open file '%s'", filepath)
+	}
+	_, err = fr.Seek(offset, io.SeekStart)
+	if err != nil {
+		fr.Close()
+		return nil, errors.Wrapf(err, "seek file '%s'", filepath)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(fr, length), fr}, nil
+}
+
 func (fs *FS) FileStat(name string) (inf storage.FileInfo, err error) {
 	f, err := os.Stat(path.Join(fs.opts.Path, name))
 
@@ -134,6 +192,27 @@ func (fs *FS) Copy(src, dst string) error {
 	return err
 }
 
+// ServerSideCopy clones the file into the `dst` filesystem storage with
+// a reflink, so no data is copied and the copies don't share the writes
+// (Save rewrites files in place). It returns
+// storage.ErrServerSideCopyUnsupported if `dst` isn't a filesystem
+// storage or the filesystem can't clone the file (e.g. a different
+// device or no reflink support).
+func (fs *FS) ServerSideCopy(src string, dst storage.Storage, dstName string) error {
+	d, ok := dst.(*FS)
+	if !ok {
+		return storage.ErrServerSideCopyUnsupported
+	}
+
+	to := path.Join(d.opts.Path, dstName)
+	err := os.MkdirAll(path.Dir(to), os.ModeDir|0o755)
+	if err != nil {
+		return errors.Wrapf(err, "create path %s", path.Dir(to))
+	}
+
+	return cloneFile(path.Join(fs.opts.Path, src), to)
+}
+
 // Delete deletes given file from FS.
 // It returns storage.ErrNotExist if a file isn't exists
 func (fs *FS) Delete(name string) error {
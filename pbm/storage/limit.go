@@ -0,0 +1,412 @@
+package storage
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OpClass is the class of the storage uploads the Limiter shares the
+// concurrency and the bandwidth between
+type OpClass string
+
+const (
+	OpPITR   OpClass = "pitr"
+	OpBackup OpClass = "backup"
+	OpMeta   OpClass = "meta"
+)
+
+// OpClasses are all upload classes
+var OpClasses = []OpClass{OpPITR, OpBackup, OpMeta}
+
+// LimiterConf is the Limiter's limits. Zero means no limit.
+type LimiterConf struct {
+	// MaxConcurrent is the max number of uploads passing data to
+	// the storage at once. An upload holds a slot only while the storage
+	// takes a chunk of its data, not while it waits for the data to be
+	// produced, so the uploads fed by the same producer (e.g. the
+	// namespaces of the dump) can't lock each other out. It can't be less
+	// than the number of the classes as each class has a slot reserved
+	// so no class is starved by others.
+	MaxConcurrent int
+	// MaxBytesPerSec is the aggregate bandwidth of all uploads
+	MaxBytesPerSec int64
+	// Weights decide which class gets the freed slot first and the share
+	// of the bandwidth each class gets while several are uploading,
+	// regardless of the number of their uploads. The classes not in
+	// the map have the weight 1.
+	Weights map[OpClass]float64
+}
+
+func (c LimiterConf) weight(class OpClass) float64 {
+	if w, ok := c.Weights[class]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// rateChunk is the max bytes passed to the storage at once by the
+// bandwidth-limited upload
+const rateChunk = 256 << 10
+
+// Limiter limits the uploads of the agent to the storage across all
+// operations (see Limit)
+type Limiter struct {
+	mx   sync.Mutex
+	conf LimiterConf
+
+	inflight map[OpClass]int
+	total    int
+	waiters  []*limitWaiter
+	stats    map[OpClass]*OpClassStat
+
+	// uploads is the number of the uploads of each class
+	uploads map[OpClass]int
+	// bwWaiters are the chunks waiting for the bandwidth
+	bwWaiters []*limitWaiter
+	// served is the bytes per weight each class got the bandwidth for
+	served map[OpClass]float64
+	// next is when the bandwidth taken so far is paid off
+	next  time.Time
+	timer *time.Timer
+}
+
+type limitWaiter struct {
+	class OpClass
+	n     int
+	ready chan struct{}
+}
+
+// NewLimiter creates a Limiter with no limits
+func NewLimiter() *Limiter {
+	return &Limiter{
+		inflight: make(map[OpClass]int),
+		stats:    make(map[OpClass]*OpClassStat),
+		uploads:  make(map[OpClass]int),
+		served:   make(map[OpClass]float64),
+	}
+}
+
+// SetConf changes the limits. The running uploads keep their slots.
+func (l *Limiter) SetConf(c LimiterConf) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.conf = c
+	l.wakeup()
+	l.dispatch()
+}
+
+// acquire waits for the upload slot of the class
+func (l *Limiter) acquire(class OpClass) {
+	start := time.Now()
+	w := &limitWaiter{class: class, ready: make(chan struct{})}
+
+	l.mx.Lock()
+	st := l.stat(class)
+	l.waiters = append(l.waiters, w)
+	st.Waiting++
+	l.wakeup()
+	l.mx.Unlock()
+
+	<-w.ready
+
+	l.mx.Lock()
+	st.WaitMs += time.Since(start).Milliseconds()
+	l.mx.Unlock()
+}
+
+func (l *Limiter) release(class OpClass) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.inflight[class]--
+	l.total--
+	l.stat(class).Running--
+	l.wakeup()
+}
+
+func (l *Limiter) take(class OpClass) {
+	l.inflight[class]++
+	l.total++
+	l.stat(class).Running++
+}
+
+// allowed tells if the class can start one more upload. Each class has
+// a slot reserved, others can't take it while the class is idle.
+func (l *Limiter) allowed(class OpClass) bool {
+	max := l.conf.MaxConcurrent
+	if max <= 0 {
+		return true
+	}
+	if l.total >= max {
+		return false
+	}
+	if l.inflight[class] == 0 {
+		return true
+	}
+
+	reserved := 0
+	for _, c := range OpClasses {
+		if c != class && l.inflight[c] == 0 {
+			reserved++
+		}
+	}
+	return l.total+reserved < max
+}
+
+// wakeup hands the free slots to the waiters. The class with the least
+// running uploads per its weight goes first, the waiters of the same class
+// go in order.
+func (l *Limiter) wakeup() {
+	for len(l.waiters) != 0 {
+		next := -1
+		var nextLoad float64
+		for i, w := range l.waiters {
+			if !l.allowed(w.class) {
+				continue
+			}
+			load := float64(l.inflight[w.class]) / l.conf.weight(w.class)
+			if next == -1 || load < nextLoad {
+				next, nextLoad = i, load
+			}
+		}
+		if next == -1 {
+			return
+		}
+
+		w := l.waiters[next]
+		l.waiters = append(l.waiters[:next], l.waiters[next+1:]...)
+		l.stat(w.class).Waiting--
+		l.take(w.class)
+		close(w.ready)
+	}
+}
+
+// open starts the upload of the class
+func (l *Limiter) open(class OpClass) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.stat(class).Ops++
+	l.uploads[class]++
+	if l.uploads[class] != 1 {
+		return
+	}
+
+	// the class that was idle doesn't get the bandwidth it didn't use
+	// meanwhile, it starts on par with the busy ones
+	first := true
+	var min float64
+	for c, n := range l.uploads {
+		if c == class || n == 0 {
+			continue
+		}
+		if v := l.served[c]; first || v < min {
+			min, first = v, false
+		}
+	}
+	if !first && l.served[class] < min {
+		l.served[class] = min
+	}
+}
+
+// close ends the upload of the class
+func (l *Limiter) close(class OpClass) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.uploads[class]--
+}
+
+// wait blocks until the bandwidth for n more bytes is available
+func (l *Limiter) wait(class OpClass, n int) {
+	l.mx.Lock()
+	if l.conf.MaxBytesPerSec <= 0 {
+		l.stat(class).Bytes += int64(n)
+		l.mx.Unlock()
+		return
+	}
+
+	w := &limitWaiter{class: class, n: n, ready: make(chan struct{})}
+	l.bwWaiters = append(l.bwWaiters, w)
+	l.dispatch()
+	l.mx.Unlock()
+
+	<-w.ready
+}
+
+// dispatch hands the bandwidth to the waiting chunks once the bandwidth
+// taken so far is paid off. The class that got the least bytes per its
+// weight goes first, the chunks of the same class go in order.
+func (l *Limiter) dispatch() {
+	rate := l.conf.MaxBytesPerSec
+	for len(l.bwWaiters) != 0 {
+		if rate <= 0 {
+			for _, w := range l.bwWaiters {
+				l.stat(w.class).Bytes += int64(w.n)
+				close(w.ready)
+			}
+			l.bwWaiters = nil
+			return
+		}
+
+		now := time.Now()
+		if l.next.After(now) {
+			if l.timer == nil {
+				l.timer = time.AfterFunc(l.next.Sub(now), func() {
+					l.mx.Lock()
+					defer l.mx.Unlock()
+					l.timer = nil
+					l.dispatch()
+				})
+			}
+			return
+		}
+
+		next := 0
+		for i, w := range l.bwWaiters {
+			if l.served[w.class] < l.served[l.bwWaiters[next].class] {
+				next = i
+			}
+		}
+		w := l.bwWaiters[next]
+		l.bwWaiters = append(l.bwWaiters[:next], l.bwWaiters[next+1:]...)
+		l.served[w.class] += float64(w.n) / l.conf.weight(w.class)
+		l.stat(w.class).Bytes += int64(w.n)
+		l.next = now.Add(time.Duration(float64(w.n) / float64(rate) * float64(time.Second)))
+		close(w.ready)
+	}
+}
+
+func (l *Limiter) stat(class OpClass) *OpClassStat {
+	st, ok := l.stats[class]
+	if !ok {
+		st = &OpClassStat{Class: class}
+		l.stats[class] = st
+	}
+	return st
+}
+
+// LimiterStat is the state of the Limiter
+type LimiterStat struct {
+	MaxConcurrent  int           `bson:"maxConcurrent,omitempty" json:"maxConcurrent,omitempty"`
+	MaxBytesPerSec int64         `bson:"maxBps,omitempty" json:"maxBytesPerSec,omitempty"`
+	Running        int           `bson:"running" json:"running"`
+	Classes        []OpClassStat `bson:"classes,omitempty" json:"classes,omitempty"`
+}
+
+// OpClassStat is the uploads of the class since the agent start
+type OpClassStat struct {
+	Class   OpClass `bson:"class" json:"class"`
+	Weight  float64 `bson:"weight" json:"weight"`
+	Running int     `bson:"running" json:"running"`
+	Waiting int     `bson:"waiting" json:"waiting"`
+	Ops     int64   `bson:"ops" json:"ops"`
+	Bytes   int64   `bson:"bytes" json:"bytes"`
+	// WaitMs is the total time the uploads waited for a slot
+	WaitMs int64 `bson:"waitMs" json:"waitMs"`
+}
+
+// Stat returns the current state of the Limiter
+func (l *Limiter) Stat() LimiterStat {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	st := LimiterStat{
+		MaxConcurrent:  l.conf.MaxConcurrent,
+		MaxBytesPerSec: l.conf.MaxBytesPerSec,
+		Running:        l.total,
+	}
+	for _, c := range l.stats {
+		cs := *c
+		cs.Weight = l.conf.weight(c.Class)
+		st.Classes = append(st.Classes, cs)
+	}
+	sort.Slice(st.Classes, func(i, j int) bool { return st.Classes[i].Class < st.Classes[j].Class })
+
+	return st
+}
+
+// Limit returns the storage whose uploads of the class go through
+// the limiter. Other operations aren't limited.
+func Limit(stg Storage, l *Limiter, class OpClass) Storage {
+	if ls, ok := stg.(*limited); ok {
+		stg = ls.Storage
+	}
+	return &limited{Storage: stg, l: l, class: class}
+}
+
+// WithOpClass returns the storage whose uploads are counted as
+// the class if it's limited (see Limit) and the storage as is otherwise
+func WithOpClass(stg Storage, class OpClass) Storage {
+	ls, ok := stg.(*limited)
+	if !ok {
+		return stg
+	}
+	return &limited{Storage: ls.Storage, l: ls.l, class: class}
+}
+
+type limited struct {
+	Storage
+	l     *Limiter
+	class OpClass
+}
+
+func (s *limited) Save(name string, data io.Reader, size int64) error {
+	r := s.reader(data)
+	defer r.close()
+
+	return s.Storage.Save(name, r, size)
+}
+
+func (s *limited) SaveIfNotExists(name string, data io.Reader, size int64) error {
+	r := s.reader(data)
+	defer r.close()
+
+	return SaveIfNotExists(s.Storage, name, r, size)
+}
+
+func (s *limited) reader(data io.Reader) *limitedReader {
+	s.l.open(s.class)
+	return &limitedReader{r: data, l: s.l, class: s.class}
+}
+
+// limitedReader takes the upload slot for each chunk it passes to
+// the storage. The slot is held until the storage asks for the next chunk
+// and isn't held while the chunk is read from the source.
+type limitedReader struct {
+	r     io.Reader
+	l     *Limiter
+	class OpClass
+	slot  bool
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	r.done()
+
+	if len(p) > rateChunk {
+		p = p[:rateChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.l.acquire(r.class)
+		r.slot = true
+		r.l.wait(r.class, n)
+	}
+	return n, err
+}
+
+// done releases the slot if it's held
+func (r *limitedReader) done() {
+	if r.slot {
+		r.l.release(r.class)
+		r.slot = false
+	}
+}
+
+func (r *limitedReader) close() {
+	r.done()
+	r.l.close(r.class)
+}
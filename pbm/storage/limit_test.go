@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type blockingStorage struct {
+	Storage
+	started chan string
+	release chan struct{}
+}
+
+// Save takes the first chunk of the data and blocks until released
+func (s *blockingStorage) Save(name string, data io.Reader, _ int64) error {
+	_, err := data.Read(make([]byte, 1))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	s.started <- name
+	<-s.release
+	_, err = io.Copy(io.Discard, data)
+	return err
+}
+
+func (s *blockingStorage) FileStat(string) (FileInfo, error) {
+	return FileInfo{}, ErrNotExist
+}
+
+func TestLimiterReservedSlot(t *testing.T) {
+	l := NewLimiter()
+	l.SetConf(LimiterConf{MaxConcurrent: 4})
+	stg := &blockingStorage{started: make(chan string, 10), release: make(chan struct{})}
+
+	bcp := Limit(stg, l, OpBackup)
+	for _, n := range []string{"b1", "b2", "b3"} {
+		go bcp.Save(n, bytes.NewReader([]byte("x")), 0) //nolint:errcheck
+	}
+	// the other two classes have a slot reserved
+	for i := 0; i < 2; i++ {
+		<-stg.started
+	}
+	select {
+	case n := <-stg.started:
+		t.Fatalf("%s started beyond the backup's share", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go Limit(stg, l, OpPITR).Save("p1", bytes.NewReader([]byte("x")), 0) //nolint:errcheck
+	if n := <-stg.started; n != "p1" {
+		t.Fatalf("expected the PITR chunk to start, got %s", n)
+	}
+
+	st := l.Stat()
+	if st.Running != 3 {
+		t.Errorf("expected 3 running, got %d", st.Running)
+	}
+	for _, c := range st.Classes {
+		if c.Class == OpBackup && (c.Running != 2 || c.Waiting != 1) {
+			t.Errorf("expected 2 running and 1 waiting backup uploads, got %+v", c)
+		}
+	}
+
+	close(stg.release)
+}
+
+func TestLimiterWeights(t *testing.T) {
+	l := NewLimiter()
+	l.SetConf(LimiterConf{MaxConcurrent: 4, Weights: map[OpClass]float64{OpPITR: 4}})
+
+	l.acquire(OpBackup)
+	l.acquire(OpPITR)
+	l.acquire(OpMeta)
+	l.acquire(OpBackup)
+
+	got := make(chan OpClass, 2)
+	for _, c := range []OpClass{OpBackup, OpPITR} {
+		c := c
+		go func() {
+			l.acquire(c)
+			got <- c
+		}()
+	}
+	for waiting(l) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// PITR goes first: 1/4 running per weight is less than backup's 1/1
+	l.release(OpBackup)
+	if c := <-got; c != OpPITR {
+		t.Errorf("expected pitr to get the slot, got %s", c)
+	}
+}
+
+func waiting(l *Limiter) int {
+	n := 0
+	for _, c := range l.Stat().Classes {
+		n += c.Waiting
+	}
+	return n
+}
+
+func TestLimiterBandwidth(t *testing.T) {
+	l := NewLimiter()
+	l.SetConf(LimiterConf{MaxBytesPerSec: 10 << 20})
+	stg := &blockingStorage{started: make(chan string, 1), release: make(chan struct{}, 1)}
+	stg.release <- struct{}{}
+
+	start := time.Now()
+	err := Limit(stg, l, OpBackup).Save("b", bytes.NewReader(make([]byte, 2<<20)), 2<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("2MB at 10MB/s took %v", d)
+	}
+	if st := l.Stat(); len(st.Classes) != 1 || st.Classes[0].Bytes != 2<<20 || st.Classes[0].Ops != 1 {
+		t.Errorf("unexpected stat %+v", st)
+	}
+}
+
+type discardStorage struct {
+	Storage
+}
+
+func (discardStorage) Save(_ string, data io.Reader, _ int64) error {
+	_, err := io.Copy(io.Discard, data)
+	return err
+}
+
+// The uploads fed by the same producer (like the namespaces demuxed from
+// the dump) don't hold the slots while the producer writes to others.
+func TestLimiterInterleavedStreams(t *testing.T) {
+	l := NewLimiter()
+	l.SetConf(LimiterConf{MaxConcurrent: len(OpClasses)})
+	stg := Limit(discardStorage{}, l, OpBackup)
+
+	const streams = 5
+	var pws []*io.PipeWriter
+	errc := make(chan error, streams)
+	for i := 0; i < streams; i++ {
+		pr, pw := io.Pipe()
+		pws = append(pws, pw)
+		go func() { errc <- stg.Save("ns", pr, -1) }()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		chunk := make([]byte, 1024)
+		for round := 0; round < 10; round++ {
+			for _, pw := range pws {
+				pw.Write(chunk) //nolint:errcheck
+			}
+		}
+		for _, pw := range pws {
+			pw.Close()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the uploads deadlocked")
+	}
+	for i := 0; i < streams; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := l.Stat(); st.Running != 0 {
+		t.Errorf("expected no running uploads, got %d", st.Running)
+	}
+}
+
+// Many backup uploads don't take the bandwidth from the PITR
+func TestLimiterBandwidthWeights(t *testing.T) {
+	l := NewLimiter()
+	l.SetConf(LimiterConf{
+		MaxBytesPerSec: 20 * rateChunk,
+		Weights:        map[OpClass]float64{OpPITR: 4, OpBackup: 1},
+	})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	run := func(class OpClass) {
+		defer wg.Done()
+		l.open(class)
+		defer l.close(class)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				l.wait(class, rateChunk)
+			}
+		}
+	}
+	wg.Add(5)
+	go run(OpPITR)
+	for i := 0; i < 4; i++ {
+		go run(OpBackup)
+	}
+	time.Sleep(time.Second)
+	bytes := make(map[OpClass]int64)
+	for _, c := range l.Stat().Classes {
+		bytes[c.Class] = c.Bytes
+	}
+	close(stop)
+	l.SetConf(LimiterConf{})
+	wg.Wait()
+
+	if bytes[OpPITR] < 2*bytes[OpBackup] {
+		t.Errorf("expected pitr to get most of the bandwidth, got %d pitr and %d backup bytes",
+			bytes[OpPITR], bytes[OpBackup])
+	}
+}
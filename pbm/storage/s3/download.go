@@ -9,6 +9,8 @@ import (
 	"net/http"
 	"path"
 	"runtime"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -49,6 +51,10 @@ const (
 
 	ccSpanDefault = 32 << 20
 	arenaSpans    = 8 // an amount of spans in arena
+
+	// MaxRetriedFiles is how many of the files with the most
+	// download retries the stat keeps
+	MaxRetriedFiles = 10
 )
 
 type DownloadStat struct {
@@ -58,11 +64,60 @@ type DownloadStat struct {
 	SpansNum    int         `bson:"spanNum" json:"spanNum"`
 	SpanSize    int         `bson:"spanSize" json:"spanSize"`
 	BufSize     int         `bson:"bufSize" json:"bufSize"`
+	// Retried are the MaxRetriedFiles files with the most chunk download
+	// retries. Objects retried over and over again are likely corrupted
+	// or served slowly by the storage.
+	Retried []FileRetryStat `bson:"retried,omitempty" json:"retried,omitempty"`
+	// Failed are the files which download has failed despite the retries
+	Failed []FileRetryStat `bson:"failed,omitempty" json:"failed,omitempty"`
+	// Served are the files copied from a fallback read endpoint or failed
+	// on all endpoints. Set only if the restore has fallback endpoints.
+	Served []FileEndpointStat `bson:"served,omitempty" json:"served,omitempty"`
+}
+
+// FileEndpointStat is the read endpoint that served the file
+type FileEndpointStat struct {
+	Name string `bson:"name" json:"name"`
+	// Endpoint is empty if no endpoint served the file
+	Endpoint string `bson:"endpoint" json:"endpoint"`
+	// Errors are the failures of the preceding endpoints
+	Errors []string `bson:"errors,omitempty" json:"errors,omitempty"`
 }
 
 func (s DownloadStat) String() string {
-	return fmt.Sprintf("buf %d, arena %d, span %d, spanNum %d, cc %d, %v",
-		s.BufSize, s.ArenaSize, s.SpanSize, s.SpansNum, s.Concurrency, s.Arenas)
+	return fmt.Sprintf("buf %d, arena %d, span %d, spanNum %d, cc %d, %v, retried %v, failed %v",
+		s.BufSize, s.ArenaSize, s.SpanSize, s.SpansNum, s.Concurrency, s.Arenas, s.Retried, s.Failed)
+}
+
+// FileRetryStat is the download retries of the file
+type FileRetryStat struct {
+	Name    string `bson:"name" json:"name"`
+	Retries int    `bson:"retries" json:"retries"`
+	Error   string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// TopRetried returns the `n` files with the most retries, the ones with no
+// retries are left out
+func TopRetried(files []FileRetryStat, n int) []FileRetryStat {
+	rv := make([]FileRetryStat, 0, len(files))
+	for _, f := range files {
+		if f.Retries > 0 {
+			rv = append(rv, f)
+		}
+	}
+	sort.SliceStable(rv, func(i, j int) bool {
+		if rv[i].Retries != rv[j].Retries {
+			return rv[i].Retries > rv[j].Retries
+		}
+		return rv[i].Name < rv[j].Name
+	})
+	if len(rv) > n {
+		rv = rv[:n]
+	}
+	if len(rv) == 0 {
+		return nil
+	}
+	return rv
 }
 
 // Download is used to concurrently download objects from the storage.
@@ -74,6 +129,10 @@ type Download struct {
 	cc       int // download concurrency
 
 	stat DownloadStat
+
+	mx      sync.Mutex
+	retries map[string]int
+	failed  map[string]string
 }
 
 func (s *S3) NewDownload(cc, bufSizeMb, spanSizeMb int) *Download {
@@ -142,7 +201,27 @@ func downloadOpts(cc, bufMaxMb, spanSizeMb int) (arenaSize, span, c int) {
 }
 
 func (d *Download) SourceReader(name string) (io.ReadCloser, error) {
-	return d.s3.sourceReader(name, d.arenas, d.cc, d.spanSize)
+	return d.s3.sourceReader(name, d.arenas, d.cc, d.spanSize, d.recordFile)
+}
+
+// recordFile records the retries and the error of the file download
+func (d *Download) recordFile(name string, retries int, err error) {
+	if retries == 0 && err == nil {
+		return
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if d.retries == nil {
+		d.retries = make(map[string]int)
+		d.failed = make(map[string]string)
+	}
+	// the file may be downloaded more than once
+	d.retries[name] += retries
+	if err != nil {
+		d.failed[name] = err.Error()
+	}
 }
 
 func (d *Download) Stat() DownloadStat {
@@ -151,6 +230,21 @@ func (d *Download) Stat() DownloadStat {
 		d.stat.Arenas = append(d.stat.Arenas, a.stat)
 	}
 
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	files := make([]FileRetryStat, 0, len(d.retries))
+	for n, r := range d.retries {
+		files = append(files, FileRetryStat{Name: n, Retries: r})
+	}
+	d.stat.Retried = TopRetried(files, MaxRetriedFiles)
+
+	d.stat.Failed = nil
+	for n, e := range d.failed {
+		d.stat.Failed = append(d.stat.Failed, FileRetryStat{Name: n, Retries: d.retries[n], Error: e})
+	}
+	sort.Slice(d.stat.Failed, func(i, j int) bool { return d.stat.Failed[i].Name < d.stat.Failed[j].Name })
+
 	return d.stat
 }
 
@@ -158,6 +252,140 @@ func (s *S3) SourceReader(name string) (io.ReadCloser, error) {
 	return s.d.SourceReader(name)
 }
 
+// DownloadPool is several downloads to read as many objects at once. The
+// buffers of a download can't be shared by objects, so each reads one
+// object at a time. The concurrency and the buffer size are split between
+// the downloads.
+type DownloadPool struct {
+	dd   []*Download
+	free chan *Download
+}
+
+// NewDownloadPool creates the pool of `n` downloads
+func (s *S3) NewDownloadPool(n, cc, bufSizeMb, spanSizeMb int) *DownloadPool {
+	if n < 1 {
+		n = 1
+	}
+	if cc == 0 {
+		cc = runtime.GOMAXPROCS(0)
+	}
+	cc = maxInt(cc/n, 1)
+	if bufSizeMb != 0 {
+		bufSizeMb = maxInt(bufSizeMb/n, 1)
+	}
+
+	p := &DownloadPool{free: make(chan *Download, n)}
+	for i := 0; i < n; i++ {
+		d := s.NewDownload(cc, bufSizeMb, spanSizeMb)
+		p.dd = append(p.dd, d)
+		p.free <- d
+	}
+	return p
+}
+
+// SourceReader reads the object with the first download that is free.
+// The download is free again once the reader is closed.
+func (p *DownloadPool) SourceReader(name string) (io.ReadCloser, error) {
+	d := <-p.free
+	rdr, err := d.SourceReader(name)
+	if err != nil {
+		p.free <- d
+		return nil, err
+	}
+	return &poolReader{ReadCloser: rdr, release: func() { p.free <- d }}, nil
+}
+
+type poolReader struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (r *poolReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// Stat returns the stat of all the downloads of the pool
+func (p *DownloadPool) Stat() DownloadStat {
+	ss := make([]DownloadStat, 0, len(p.dd))
+	for _, d := range p.dd {
+		ss = append(ss, d.Stat())
+	}
+	return mergeDownloadStats(ss)
+}
+
+func mergeDownloadStats(ss []DownloadStat) DownloadStat {
+	if len(ss) == 0 {
+		return DownloadStat{}
+	}
+
+	rv := ss[0]
+	rv.Arenas = nil
+	rv.Concurrency, rv.BufSize = 0, 0
+	rv.Failed = nil
+	retries := make(map[string]int)
+	for _, s := range ss {
+		rv.Arenas = append(rv.Arenas, s.Arenas...)
+		rv.Concurrency += s.Concurrency
+		rv.BufSize += s.BufSize
+		rv.Failed = append(rv.Failed, s.Failed...)
+		for _, f := range s.Retried {
+			retries[f.Name] += f.Retries
+		}
+	}
+
+	files := make([]FileRetryStat, 0, len(retries))
+	for n, r := range retries {
+		files = append(files, FileRetryStat{Name: n, Retries: r})
+	}
+	rv.Retried = TopRetried(files, MaxRetriedFiles)
+	sort.Slice(rv.Failed, func(i, j int) bool { return rv.Failed[i].Name < rv.Failed[j].Name })
+
+	return rv
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// RangeReader reads a part of the object in a single request
+func (s *S3) RangeReader(name string, offset, length int64) (io.ReadCloser, error) {
+	sess, err := s.s3session()
+	if err != nil {
+		return nil, errors.Wrap(err, "get S3 session")
+	}
+
+	getObjOpts := &s3.GetObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(path.Join(s.opts.Prefix, name)),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	}
+
+	sse := s.opts.ServerSideEncryption
+	if sse != nil && sse.SseCustomerAlgorithm != "" {
+		getObjOpts.SSECustomerAlgorithm = aws.String(sse.SseCustomerAlgorithm)
+		decodedKey, err := base64.StdEncoding.DecodeString(sse.SseCustomerKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "SseCustomerAlgorithm specified with invalid SseCustomerKey")
+		}
+		getObjOpts.SSECustomerKey = aws.String(string(decodedKey[:]))
+		keyMD5 := md5.Sum(decodedKey[:])
+		getObjOpts.SSECustomerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(keyMD5[:]))
+	}
+
+	s3obj, err := sess.GetObject(getObjOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "get object range")
+	}
+
+	return s3obj.Body, nil
+}
+
 type errGetObj error
 
 // requests an object in chunks and retries if download has failed
@@ -166,6 +394,8 @@ type partReader struct {
 	fsize     int64 // a total size of object (file) to download
 	written   int64
 	chunkSize int64
+	// failed chunk download attempts
+	retries atomic.Int64
 
 	getSess func() (*s3.S3, error)
 	l       *log.Event
@@ -222,7 +452,11 @@ func (b *chunksQueue) Pop() any {
 	return x
 }
 
-func (s *S3) sourceReader(fname string, arenas []*arena, cc, downloadChuckSize int) (io.ReadCloser, error) {
+// sourceReader downloads the file concurrently. `done` is called with the
+// num of retries and the error (if any) once the download is over.
+func (s *S3) sourceReader(fname string, arenas []*arena, cc, downloadChuckSize int,
+	done func(fname string, retries int, err error),
+) (io.ReadCloser, error) {
 	if cc < 1 {
 		return nil, errors.Errorf("num of workers shuld be at least 1 (got %d)", cc)
 	}
@@ -246,6 +480,13 @@ func (s *S3) sourceReader(fname string, arenas []*arena, cc, downloadChuckSize i
 		defer func() {
 			w.CloseWithError(exitErr)
 			pr.Reset()
+
+			var err error
+			// the consumer closing the reader isn't a download failure
+			if exitErr != io.EOF && !errors.Is(exitErr, io.ErrClosedPipe) {
+				err = exitErr
+			}
+			done(fname, int(pr.retries.Load()), err)
 		}()
 
 		cqueue := &chunksQueue{}
@@ -388,6 +629,7 @@ func (pr *partReader) tryChunk(buf *arena, s *s3.S3, start, end int64) (r io.Rea
 		if err == nil || err == io.EOF {
 			return r, nil
 		}
+		pr.retries.Add(1)
 		switch err.(type) {
 		case errGetObj:
 			return r, err
@@ -1,6 +1,7 @@
 package s3
 
 import (
+	"bytes"
 	"crypto/md5"
 	"crypto/tls"
 	"encoding/base64"
@@ -10,8 +11,10 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -53,6 +56,12 @@ type Conf struct {
 	MaxUploadParts       int         `bson:"maxUploadParts,omitempty" json:"maxUploadParts,omitempty" yaml:"maxUploadParts,omitempty"`
 	StorageClass         string      `bson:"storageClass,omitempty" json:"storageClass,omitempty" yaml:"storageClass,omitempty"`
 
+	// ForcePathStyle makes requests address the bucket in the path
+	// (endpoint/bucket/key) rather than in the host name
+	// (bucket.endpoint/key). On by default as S3-compatible stores
+	// (MinIO, Ceph) often support path-style only.
+	ForcePathStyle *bool `bson:"forcePathStyle,omitempty" json:"forcePathStyle,omitempty" yaml:"forcePathStyle,omitempty"`
+
 	// InsecureSkipTLSVerify disables client verification of the server's
 	// certificate chain and host name
 	InsecureSkipTLSVerify bool `bson:"insecureSkipTLSVerify" json:"insecureSkipTLSVerify" yaml:"insecureSkipTLSVerify"`
@@ -222,6 +231,10 @@ type S3 struct {
 	s3s  *s3.S3
 
 	d *Download // default downloader for small files
+
+	lockOnce    sync.Once
+	lockEnabled bool
+	lockErr     error
 }
 
 func New(opts Conf, l *log.Event) (*S3, error) {
@@ -369,6 +382,58 @@ func (s *S3) Save(name string, data io.Reader, sizeb int64) error {
 	}
 }
 
+// SaveIfNotExists saves the file unless it already exists using conditional
+// write (`If-None-Match: *`). It's meant for small files as the data is
+// uploaded in a single request. Backends not supporting conditional writes
+// (GCS via minio client) fall back to storage.CheckAndSave.
+func (s *S3) SaveIfNotExists(name string, data io.Reader, size int64) error {
+	if s.opts.Provider == S3ProviderGCS {
+		return storage.CheckAndSave(s, name, data, size)
+	}
+
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return errors.Wrap(err, "read data")
+	}
+
+	putOpts := &s3.PutObjectInput{
+		Bucket:       aws.String(s.opts.Bucket),
+		Key:          aws.String(path.Join(s.opts.Prefix, name)),
+		Body:         bytes.NewReader(b),
+		StorageClass: &s.opts.StorageClass,
+	}
+
+	sse := s.opts.ServerSideEncryption
+	if sse != nil {
+		if sse.SseAlgorithm == s3.ServerSideEncryptionAwsKms {
+			putOpts.ServerSideEncryption = aws.String(sse.SseAlgorithm)
+			putOpts.SSEKMSKeyId = aws.String(sse.KmsKeyID)
+		} else if sse.SseCustomerAlgorithm != "" {
+			putOpts.SSECustomerAlgorithm = aws.String(sse.SseCustomerAlgorithm)
+			decodedKey, err := base64.StdEncoding.DecodeString(sse.SseCustomerKey)
+			putOpts.SSECustomerKey = aws.String(string(decodedKey[:]))
+			if err != nil {
+				return errors.Wrap(err, "SseCustomerAlgorithm specified with invalid SseCustomerKey")
+			}
+			keyMD5 := md5.Sum(decodedKey[:])
+			putOpts.SSECustomerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(keyMD5[:]))
+		}
+	}
+
+	_, err = s.s3s.PutObjectWithContext(aws.BackgroundContext(), putOpts, func(r *request.Request) {
+		r.HTTPRequest.Header.Set("If-None-Match", "*")
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok &&
+			(aerr.StatusCode() == http.StatusPreconditionFailed || aerr.StatusCode() == http.StatusConflict) {
+			return storage.ErrExist
+		}
+		return errors.Wrap(err, "put S3 object")
+	}
+
+	return nil
+}
+
 func (s *S3) List(prefix, suffix string) ([]storage.FileInfo, error) {
 	prfx := path.Join(s.opts.Prefix, prefix)
 
@@ -414,10 +479,50 @@ func (s *S3) List(prefix, suffix string) ([]storage.FileInfo, error) {
 }
 
 func (s *S3) Copy(src, dst string) error {
+	return s.copyObject(path.Join(s.opts.Bucket, s.opts.Prefix, src), s.opts.Bucket, path.Join(s.opts.Prefix, dst))
+}
+
+// maxCopyObjectSize is the largest object CopyObject can copy.
+// Larger ones need the multipart copy.
+const maxCopyObjectSize = 5 << 30
+
+// ServerSideCopy copies the object to the `dst` storage with CopyObject,
+// so the data doesn't leave S3. The destination has to be on the same
+// endpoint and account with the same encryption options. Otherwise, and
+// for the objects CopyObject can't copy in one go, it returns
+// storage.ErrServerSideCopyUnsupported.
+func (s *S3) ServerSideCopy(src string, dst storage.Storage, dstName string) error {
+	d, ok := dst.(*S3)
+	if !ok || !sameAccount(s.opts, d.opts) {
+		return storage.ErrServerSideCopyUnsupported
+	}
+
+	fi, err := s.FileStat(src)
+	if err != nil {
+		return errors.Wrap(err, "stat source")
+	}
+	if fi.Size > maxCopyObjectSize {
+		return storage.ErrServerSideCopyUnsupported
+	}
+
+	return s.copyObject(path.Join(s.opts.Bucket, s.opts.Prefix, src), d.opts.Bucket, path.Join(d.opts.Prefix, dstName))
+}
+
+// sameAccount tells if the storages are reachable with the same client
+// and keep the data encrypted the same way
+func sameAccount(a, b Conf) bool {
+	return a.Provider == b.Provider &&
+		a.EndpointURL == b.EndpointURL &&
+		a.Region == b.Region &&
+		reflect.DeepEqual(a.Credentials, b.Credentials) &&
+		reflect.DeepEqual(a.ServerSideEncryption, b.ServerSideEncryption)
+}
+
+func (s *S3) copyObject(source, bucket, key string) error {
 	copyOpts := &s3.CopyObjectInput{
-		Bucket:     aws.String(s.opts.Bucket),
-		CopySource: aws.String(path.Join(s.opts.Bucket, s.opts.Prefix, src)),
-		Key:        aws.String(path.Join(s.opts.Prefix, dst)),
+		Bucket:     aws.String(bucket),
+		CopySource: aws.String(source),
+		Key:        aws.String(key),
 	}
 
 	sse := s.opts.ServerSideEncryption
@@ -474,6 +579,7 @@ func (s *S3) FileStat(name string) (inf storage.FileInfo, err error) {
 	}
 	inf.Name = name
 	inf.Size = aws.Int64Value(h.ContentLength)
+	inf.ETag = aws.StringValue(h.ETag)
 
 	if inf.Size == 0 {
 		return inf, storage.ErrEmpty
@@ -485,6 +591,48 @@ func (s *S3) FileStat(name string) (inf storage.FileInfo, err error) {
 	return inf, nil
 }
 
+// IsRegionRedirect tells if the request failed because the bucket is in
+// another region than the client's one
+func IsRegionRedirect(err error) bool {
+	var rf awserr.RequestFailure
+	if errors.As(err, &rf) && rf.StatusCode() == http.StatusMovedPermanently {
+		return true
+	}
+
+	var ae awserr.Error
+	if errors.As(err, &ae) {
+		switch ae.Code() {
+		case "PermanentRedirect", "BucketRegionError", "AuthorizationHeaderMalformed":
+			return true
+		}
+	}
+	return false
+}
+
+// FollowRegion switches the client to the bucket's region taken from
+// the S3 redirect response (see IsRegionRedirect). It returns the region
+// in use. It isn't safe to call while other requests are running.
+func (s *S3) FollowRegion() (string, error) {
+	region, err := s3manager.GetBucketRegionWithClient(aws.BackgroundContext(), s.s3s, s.opts.Bucket)
+	if err != nil {
+		return "", errors.Wrap(err, "get bucket region")
+	}
+	if region == "" || region == s.opts.Region {
+		return s.opts.Region, nil
+	}
+
+	prev := s.opts.Region
+	s.opts.Region = region
+	s3s, err := s.s3session()
+	if err != nil {
+		s.opts.Region = prev
+		return "", errors.Wrap(err, "AWS session")
+	}
+	s.s3s = s3s
+
+	return region, nil
+}
+
 // Delete deletes given file.
 // It returns storage.ErrNotExist if a file isn't exists
 func (s *S3) Delete(name string) error {
@@ -505,6 +653,85 @@ func (s *S3) Delete(name string) error {
 	return nil
 }
 
+// Lock returns the Object Lock retention and legal hold of the file.
+// The bucket's Object Lock configuration is fetched once, files in
+// buckets without it enabled are never locked.
+func (s *S3) Lock(name string) (storage.Lock, error) {
+	var l storage.Lock
+	if s.opts.Provider == S3ProviderGCS {
+		return l, nil
+	}
+
+	s.lockOnce.Do(func() {
+		s.lockEnabled, s.lockErr = s.objectLockEnabled()
+	})
+	if s.lockErr != nil {
+		return l, s.lockErr
+	}
+	if !s.lockEnabled {
+		return l, nil
+	}
+
+	bucket := aws.String(s.opts.Bucket)
+	key := aws.String(path.Join(s.opts.Prefix, name))
+
+	r, err := s.s3s.GetObjectRetention(&s3.GetObjectRetentionInput{Bucket: bucket, Key: key})
+	switch {
+	case err == nil:
+		if r.Retention != nil {
+			l.RetainUntil = aws.TimeValue(r.Retention.RetainUntilDate)
+		}
+	case isNoLock(err):
+	default:
+		return l, errors.Wrap(err, "get object retention")
+	}
+
+	h, err := s.s3s.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{Bucket: bucket, Key: key})
+	switch {
+	case err == nil:
+		if h.LegalHold != nil {
+			l.LegalHold = aws.StringValue(h.LegalHold.Status) == s3.ObjectLockLegalHoldStatusOn
+		}
+	case isNoLock(err):
+	default:
+		return l, errors.Wrap(err, "get object legal hold")
+	}
+
+	return l, nil
+}
+
+func (s *S3) objectLockEnabled() (bool, error) {
+	c, err := s.s3s.GetObjectLockConfiguration(&s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(s.opts.Bucket),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case "ObjectLockConfigurationNotFoundError", "NotImplemented":
+				return false, nil
+			}
+		}
+		return false, errors.Wrap(err, "get bucket object lock configuration")
+	}
+
+	return c.ObjectLockConfiguration != nil &&
+		aws.StringValue(c.ObjectLockConfiguration.ObjectLockEnabled) == s3.ObjectLockEnabledEnabled, nil
+}
+
+// isNoLock tells if the request failed because the object has no
+// retention or legal hold set or doesn't exist
+func isNoLock(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case "NoSuchObjectLockConfiguration", s3.ErrCodeNoSuchKey, "NotFound":
+		return true
+	}
+	return false
+}
+
 func (s *S3) s3session() (*s3.S3, error) {
 	sess, err := s.session()
 	if err != nil {
@@ -566,7 +793,7 @@ func (s *S3) session() (*session.Session, error) {
 		Region:           aws.String(s.opts.Region),
 		Endpoint:         aws.String(s.opts.EndpointURL),
 		Credentials:      credentials.NewChainCredentials(providers),
-		S3ForcePathStyle: aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(s.opts.ForcePathStyle == nil || *s.opts.ForcePathStyle),
 		HTTPClient:       httpClient,
 		LogLevel:         aws.LogLevel(SDKLogLevel(s.opts.DebugLogLevels, nil)),
 		Logger:           awsLogger(s.log),
@@ -2,13 +2,23 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"time"
 )
 
 var (
 	// ErrNotExist is an error for file doesn't exists on storage
 	ErrNotExist = errors.New("no such file")
 	ErrEmpty    = errors.New("file is empty")
+	// ErrExist is an error for file that already exists on storage
+	ErrExist = errors.New("file already exists")
+	// ErrServerSideCopyUnsupported is returned by ServerSideCopy if the
+	// file can't be copied to the destination storage server-side
+	ErrServerSideCopyUnsupported = errors.New("server-side copy is not supported")
+	// ErrLocked is an error for file that can't be deleted because of
+	// the retention or the legal hold set on it (see LockChecker)
+	ErrLocked = errors.New("file is locked")
 )
 
 // Type represents a type of the destination storage for backups
@@ -25,6 +35,9 @@ const (
 type FileInfo struct {
 	Name string // with path
 	Size int64
+	// ETag identifies the object's content. Empty if the storage
+	// doesn't provide it.
+	ETag string
 }
 
 type Storage interface {
@@ -42,3 +55,153 @@ type Storage interface {
 	// Copy makes a copy of the src objec/file under dst name
 	Copy(src, dst string) error
 }
+
+// ConditionalSaver is implemented by storages that can atomically save
+// a file only if it doesn't exist yet (e.g. S3 `If-None-Match: *`).
+type ConditionalSaver interface {
+	// SaveIfNotExists saves the file unless there is one with the same
+	// name already. It returns ErrExist in the latter case.
+	SaveIfNotExists(name string, data io.Reader, size int64) error
+}
+
+// RangeReader is implemented by storages that can read a part of
+// an object without downloading the whole of it.
+type RangeReader interface {
+	// RangeReader returns the reader of `length` bytes of the file
+	// starting from `offset`.
+	RangeReader(name string, offset, length int64) (io.ReadCloser, error)
+}
+
+// ServerSideCopier is implemented by storages that can copy a file to
+// another storage of the same backend without streaming the data through
+// the agent (e.g. S3 CopyObject).
+type ServerSideCopier interface {
+	// ServerSideCopy copies the `src` file to the `dst` storage under
+	// the `dstName` name. It returns ErrServerSideCopyUnsupported if the
+	// storages don't share the backend (e.g. a different S3 endpoint or
+	// account).
+	ServerSideCopy(src string, dst Storage, dstName string) error
+}
+
+// Lock is the write-once-read-many protection of a file: S3 Object Lock
+// or Azure immutability policy.
+type Lock struct {
+	// RetainUntil is the time the retention period of the file ends.
+	// Zero if there is no retention.
+	RetainUntil time.Time
+	// LegalHold prevents the deletion until the hold is removed
+	// regardless of the retention.
+	LegalHold bool
+}
+
+// Active tells if the lock prevents the file deletion at the `now` time
+func (l Lock) Active(now time.Time) bool {
+	return l.LegalHold || now.Before(l.RetainUntil)
+}
+
+func (l Lock) String() string {
+	if l.LegalHold {
+		return "legal hold"
+	}
+	return "retained until " + l.RetainUntil.UTC().Format(time.RFC3339)
+}
+
+// LockChecker is implemented by storages that can protect files from
+// deletion (e.g. S3 Object Lock or Azure immutable storage). Deleting
+// a locked object in a versioned bucket may just add a delete marker,
+// so the lock has to be checked beforehand.
+type LockChecker interface {
+	// Lock returns the lock of the file. It returns the zero Lock if
+	// the file isn't locked, doesn't exist or locks aren't enabled.
+	Lock(name string) (Lock, error)
+}
+
+// AsLockChecker returns the LockChecker of the storage (see Limit) if
+// the storage supports locks
+func AsLockChecker(stg Storage) (LockChecker, bool) {
+	if ls, ok := stg.(*limited); ok {
+		stg = ls.Storage
+	}
+	lc, ok := stg.(LockChecker)
+	return lc, ok
+}
+
+// CheckUnlocked returns ErrLocked if any of the files is under the active
+// retention or the legal hold. It does nothing if the storage doesn't
+// support locks.
+func CheckUnlocked(stg Storage, names ...string) error {
+	lc, ok := AsLockChecker(stg)
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	for _, name := range names {
+		l, err := lc.Lock(name)
+		if err != nil {
+			return fmt.Errorf("get lock of %s: %w", name, err)
+		}
+		if l.Active(now) {
+			return fmt.Errorf("%s: %w (%s)", name, ErrLocked, l)
+		}
+	}
+
+	return nil
+}
+
+// CopyMode is how the file was copied between storages
+type CopyMode string
+
+const (
+	CopyServerSide CopyMode = "server-side"
+	CopyStream     CopyMode = "stream"
+)
+
+// CopyTo copies the file from the `src` storage to the `dst` one. It uses
+// the server-side copy if the storages support it and streams the data
+// through the agent otherwise.
+func CopyTo(src Storage, name string, dst Storage, dstName string, size int64) (CopyMode, error) {
+	if c, ok := src.(ServerSideCopier); ok {
+		err := c.ServerSideCopy(name, dst, dstName)
+		if err == nil {
+			return CopyServerSide, nil
+		}
+		if !errors.Is(err, ErrServerSideCopyUnsupported) {
+			return CopyServerSide, err
+		}
+	}
+
+	r, err := src.SourceReader(name)
+	if err != nil {
+		return CopyStream, err
+	}
+	defer r.Close()
+
+	return CopyStream, dst.Save(dstName, r, size)
+}
+
+// SaveIfNotExists saves the file only if it doesn't exist yet. So only one
+// of concurrent writers wins and others get ErrExist. It uses conditional
+// writes if the storage supports them and falls back to CheckAndSave
+// otherwise.
+func SaveIfNotExists(stg Storage, name string, data io.Reader, size int64) error {
+	if cs, ok := stg.(ConditionalSaver); ok {
+		return cs.SaveIfNotExists(name, data, size)
+	}
+
+	return CheckAndSave(stg, name, data, size)
+}
+
+// CheckAndSave saves the file if it doesn't exist yet. The check and
+// the write are not atomic, so concurrent writers may still both succeed.
+func CheckAndSave(stg Storage, name string, data io.Reader, size int64) error {
+	_, err := stg.FileStat(name)
+	switch {
+	case err == nil, errors.Is(err, ErrEmpty):
+		return ErrExist
+	case !errors.Is(err, ErrNotExist):
+		return err
+	}
+
+	return stg.Save(name, data, size)
+}
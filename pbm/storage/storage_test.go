@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type lockedStorage struct {
+	Storage
+	locks map[string]Lock
+}
+
+func (s *lockedStorage) Lock(name string) (Lock, error) {
+	return s.locks[name], nil
+}
+
+func TestCheckUnlocked(t *testing.T) {
+	now := time.Now()
+	stg := &lockedStorage{locks: map[string]Lock{
+		"expired":  {RetainUntil: now.Add(-time.Hour)},
+		"retained": {RetainUntil: now.Add(time.Hour)},
+		"hold":     {LegalHold: true},
+	}}
+
+	cases := []struct {
+		names  []string
+		locked bool
+	}{
+		{[]string{"free", "expired"}, false},
+		{[]string{"free", "retained"}, true},
+		{[]string{"hold"}, true},
+	}
+	for _, c := range cases {
+		err := CheckUnlocked(stg, c.names...)
+		if errors.Is(err, ErrLocked) != c.locked {
+			t.Errorf("%v: expected locked %v, got %v", c.names, c.locked, err)
+		}
+	}
+
+	// limited storage is checked through
+	err := CheckUnlocked(Limit(stg, NewLimiter(), OpBackup), "hold")
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("limited storage: expected locked, got %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package pbm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// StorageOp is an operation PBM needs from the storage
+type StorageOp string
+
+const (
+	StorageOpPut       StorageOp = "put"
+	StorageOpStat      StorageOp = "stat"
+	StorageOpGet       StorageOp = "get"
+	StorageOpRangeRead StorageOp = "range read"
+	StorageOpList      StorageOp = "list"
+	StorageOpMultipart StorageOp = "multipart upload"
+	StorageOpDelete    StorageOp = "delete"
+)
+
+// StorageCheck is the result of a single storage operation
+type StorageCheck struct {
+	Op      StorageOp     `json:"op"`
+	OK      bool          `json:"ok"`
+	Skipped bool          `json:"skipped,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Err     string        `json:"error,omitempty"`
+}
+
+func (c StorageCheck) String() string {
+	switch {
+	case c.Skipped:
+		return fmt.Sprintf("%-16s skipped: %s", c.Op, c.Err)
+	case c.OK:
+		return fmt.Sprintf("%-16s ok (%v)", c.Op, c.Latency.Round(time.Millisecond))
+	default:
+		return fmt.Sprintf("%-16s FAILED (%v): %s", c.Op, c.Latency.Round(time.Millisecond), c.Err)
+	}
+}
+
+// StorageTestReport is the result of TestStorage
+type StorageTestReport struct {
+	Type   storage.Type   `json:"type"`
+	Path   string         `json:"path"`
+	Checks []StorageCheck `json:"checks"`
+}
+
+// OK tells if all operations, except the skipped ones, have succeeded
+func (r StorageTestReport) OK() bool {
+	for _, c := range r.Checks {
+		if !c.OK && !c.Skipped {
+			return false
+		}
+	}
+	return true
+}
+
+func (r StorageTestReport) String() string {
+	s := fmt.Sprintf("Storage %s, probe path %s:\n", r.Type, r.Path)
+	for _, c := range r.Checks {
+		s += "  " + c.String() + "\n"
+	}
+	if r.OK() {
+		s += "Storage is OK\n"
+	} else {
+		s += "Storage check FAILED\n"
+	}
+	return s
+}
+
+const storageTestDir = ".pbm.storage-test"
+
+// The default part size of both S3 and Azure uploads is 10Mb. So the probe
+// a bit bigger would be uploaded in (at least) two parts.
+const multipartProbeSize = 11 << 20
+
+// TestStorage runs every operation PBM needs against the storage of the
+// given config and reports per-operation success and latency. It works
+// with the files in a temporary probe path and removes them afterward.
+// The returned error means the storage can't be created at all.
+func (p *PBM) TestStorage(cfg Config) (StorageTestReport, error) {
+	err := validateConfig(&cfg)
+	if err != nil {
+		return StorageTestReport{}, errors.Wrap(err, "validate config")
+	}
+
+	stg, err := Storage(cfg, nil)
+	if err != nil {
+		return StorageTestReport{}, errors.Wrap(err, "create storage")
+	}
+
+	return testStorage(stg, storageTestDir+"/"+time.Now().UTC().Format("20060102150405.000000000"),
+		multipartProbeSizeFor(cfg)), nil
+}
+
+func multipartProbeSizeFor(cfg Config) int64 {
+	size := int64(multipartProbeSize)
+	if cfg.Storage.Type == storage.S3 {
+		if ps := int64(cfg.Storage.S3.UploadPartSize); ps >= size {
+			size = ps + 1<<20
+		}
+	}
+	return size
+}
+
+func testStorage(stg storage.Storage, dir string, mpSize int64) StorageTestReport {
+	rep := StorageTestReport{Type: stg.Type(), Path: dir}
+
+	run := func(op StorageOp, f func() error) bool {
+		start := time.Now()
+		err := f()
+		c := StorageCheck{Op: op, OK: err == nil, Latency: time.Since(start)}
+		if err != nil {
+			c.Err = err.Error()
+		}
+		rep.Checks = append(rep.Checks, c)
+		return c.OK
+	}
+	skip := func(op StorageOp, why string) {
+		rep.Checks = append(rep.Checks, StorageCheck{Op: op, Skipped: true, Err: why})
+	}
+
+	probe := dir + "/probe"
+	mpProbe := dir + "/multipart"
+	defer func() {
+		_ = stg.Delete(probe)
+		_ = stg.Delete(mpProbe)
+		_ = stg.Delete(dir)
+	}()
+
+	data := probeData(4 << 10)
+	if !run(StorageOpPut, func() error {
+		return stg.Save(probe, bytes.NewReader(data), int64(len(data)))
+	}) {
+		for _, op := range []StorageOp{StorageOpStat, StorageOpGet, StorageOpRangeRead, StorageOpList} {
+			skip(op, "put failed")
+		}
+	} else {
+		run(StorageOpStat, func() error {
+			inf, err := stg.FileStat(probe)
+			if err != nil {
+				return err
+			}
+			if inf.Size != int64(len(data)) {
+				return errors.Errorf("size mismatch: got %d, expected %d", inf.Size, len(data))
+			}
+			return nil
+		})
+
+		run(StorageOpGet, func() error {
+			r, err := stg.SourceReader(probe)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			return checkProbeData(r, data)
+		})
+
+		if rr, ok := stg.(storage.RangeReader); ok {
+			run(StorageOpRangeRead, func() error {
+				off, l := int64(len(data)/3), int64(len(data)/3)
+				r, err := rr.RangeReader(probe, off, l)
+				if err != nil {
+					return err
+				}
+				defer r.Close()
+				return checkProbeData(r, data[off:off+l])
+			})
+		} else {
+			skip(StorageOpRangeRead, "not supported by the storage")
+		}
+
+		run(StorageOpList, func() error {
+			files, err := stg.List(dir, "")
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				if f.Name == "probe" {
+					return nil
+				}
+			}
+			return errors.Errorf("probe file is not listed in %v", files)
+		})
+	}
+
+	if stg.Type() == storage.BlackHole {
+		skip(StorageOpMultipart, "not supported by the storage")
+	} else {
+		run(StorageOpMultipart, func() error {
+			err := stg.Save(mpProbe, io.LimitReader(probeReader{}, mpSize), mpSize)
+			if err != nil {
+				return err
+			}
+			inf, err := stg.FileStat(mpProbe)
+			if err != nil {
+				return errors.Wrap(err, "stat")
+			}
+			if inf.Size != mpSize {
+				return errors.Errorf("size mismatch: got %d, expected %d", inf.Size, mpSize)
+			}
+			return nil
+		})
+	}
+
+	run(StorageOpDelete, func() error {
+		err := stg.Delete(probe)
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return err
+		}
+		_, err = stg.FileStat(probe)
+		if !errors.Is(err, storage.ErrNotExist) {
+			return errors.Errorf("file still exists after delete (stat: %v)", err)
+		}
+		return nil
+	})
+
+	return rep
+}
+
+// probeReader is an endless stream of the probe data
+type probeReader struct{}
+
+func (probeReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte('a' + i%26)
+	}
+	return len(p), nil
+}
+
+func probeData(n int) []byte {
+	b := make([]byte, n)
+	_, _ = probeReader{}.Read(b)
+	return b
+}
+
+func checkProbeData(r io.Reader, want []byte) error {
+	got, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "read")
+	}
+	if !bytes.Equal(got, want) {
+		return errors.Errorf("content mismatch: got %d bytes, expected %d", len(got), len(want))
+	}
+	return nil
+}
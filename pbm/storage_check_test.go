@@ -0,0 +1,38 @@
+package pbm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+func TestTestStorage(t *testing.T) {
+	root := t.TempDir()
+	stg := fs.New(fs.Conf{Path: root})
+
+	rep := testStorage(stg, storageTestDir+"/t", 1<<20)
+	if !rep.OK() {
+		t.Fatalf("expected storage to be OK:\n%s", rep)
+	}
+	if len(rep.Checks) != 7 {
+		t.Errorf("expected 7 checks, got %d:\n%s", len(rep.Checks), rep)
+	}
+	for _, c := range rep.Checks {
+		if c.Skipped {
+			t.Errorf("unexpected skipped check %s", c)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root, storageTestDir, "t")); !os.IsNotExist(err) {
+		t.Errorf("expected probe path to be removed, stat: %v", err)
+	}
+
+	// read-only storage
+	ro := fs.New(fs.Conf{Path: filepath.Join(root, "no-such-dir", "\x00")})
+	rep = testStorage(ro, storageTestDir+"/t", 1<<20)
+	if rep.OK() {
+		t.Errorf("expected storage check to fail:\n%s", rep)
+	}
+}
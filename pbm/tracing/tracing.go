@@ -0,0 +1,513 @@
+// Package tracing records the spans of the backup and restore operations
+// and exports them to an OpenTelemetry collector over OTLP/HTTP (JSON
+// encoding), so a cluster-wide operation can be seen as one distributed
+// trace. A nil Tracer (no endpoint configured) records nothing.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Conf is the configuration of the tracing
+type Conf struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver of the collector
+	// (e.g. http://otel-collector:4318). Spans are POSTed to its /v1/traces.
+	// Empty means no tracing.
+	Endpoint   string  `bson:"endpoint,omitempty" json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	TimeoutSec float64 `bson:"timeoutSec,omitempty" json:"timeoutSec,omitempty" yaml:"timeoutSec,omitempty"`
+	// Headers are sent with each export request (e.g. the collector's
+	// authentication)
+	Headers map[string]string `bson:"headers,omitempty" json:"headers,omitempty" yaml:"headers,omitempty"`
+	TLS     *TLSConf          `bson:"tls,omitempty" json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+// TLSConf is the TLS options of the connection to the collector
+type TLSConf struct {
+	// CAFile is the CA bundle to verify the collector's certificate with.
+	// The system's one is used if not set.
+	CAFile string `bson:"caFile,omitempty" json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	// CertFile and KeyFile are the client certificate for the mutual TLS
+	CertFile string `bson:"certFile,omitempty" json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile  string `bson:"keyFile,omitempty" json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	// InsecureSkipVerify disables the verification of the collector's
+	// certificate
+	InsecureSkipVerify bool `bson:"insecureSkipVerify,omitempty" json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+}
+
+// httpClient returns the client to export the spans with
+func (c Conf) httpClient() (*http.Client, error) {
+	if c.TLS == nil {
+		return http.DefaultClient, nil
+	}
+
+	tc := &tls.Config{InsecureSkipVerify: c.TLS.InsecureSkipVerify} //nolint:gosec
+	if c.TLS.CAFile != "" {
+		pem, err := os.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA file")
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates in %s", c.TLS.CAFile)
+		}
+	}
+	if c.TLS.CertFile != "" || c.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tc
+	return &http.Client{Transport: tr}, nil
+}
+
+// ValidateEndpoint checks the collector endpoint is an http(s) URL
+func ValidateEndpoint(u string) error {
+	if u == "" {
+		return nil
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if pu.Scheme != "http" && pu.Scheme != "https" || pu.Host == "" {
+		return errors.Errorf("%q should be an http(s) URL", u)
+	}
+	return nil
+}
+
+const defaultTimeout = 10 * time.Second
+
+func (c Conf) timeout() time.Duration {
+	if c.TimeoutSec <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(c.TimeoutSec * float64(time.Second))
+}
+
+// batchSize is the number of the ended spans exported at once. The spans
+// are exported in the background as soon as a batch is collected, so
+// the long operation doesn't keep all its spans in memory.
+const batchSize = 512
+
+// maxPending is the number of the ended spans kept while the collector
+// doesn't keep up. Spans over it are dropped and reported by Flush.
+const maxPending = 10000
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+// TraceIDFrom makes the trace ID out of the operation ID, so the nodes
+// running the operation put their spans into the same trace without
+// agreeing on it
+func TraceIDFrom(b []byte) TraceID {
+	var id TraceID
+	copy(id[:], b)
+	return id
+}
+
+// SpanContext identifies the span across the processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid tells if the context refers to a span
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != TraceID{} && c.SpanID != SpanID{}
+}
+
+// Traceparent returns the context in the W3C traceparent format
+func (c SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(c.TraceID[:]), hex.EncodeToString(c.SpanID[:]))
+}
+
+// ParseTraceparent parses the W3C traceparent
+func ParseTraceparent(s string) (SpanContext, error) {
+	var c SpanContext
+
+	p := strings.Split(strings.TrimSpace(s), "-")
+	if len(p) != 4 || p[0] != "00" {
+		return c, errors.Errorf("invalid traceparent %q", s)
+	}
+	tid, err := hex.DecodeString(p[1])
+	if err != nil || len(tid) != len(c.TraceID) {
+		return c, errors.Errorf("invalid trace id in %q", s)
+	}
+	sid, err := hex.DecodeString(p[2])
+	if err != nil || len(sid) != len(c.SpanID) {
+		return c, errors.Errorf("invalid span id in %q", s)
+	}
+	copy(c.TraceID[:], tid)
+	copy(c.SpanID[:], sid)
+	if !c.IsValid() {
+		return c, errors.Errorf("zero ids in %q", s)
+	}
+
+	return c, nil
+}
+
+// Tracer collects the ended spans and exports them in batches and on Flush
+type Tracer struct {
+	cfg    Conf
+	attrs  map[string]string
+	client *http.Client
+	// cerr is the failure to set up the client. Nothing is exported then.
+	cerr error
+
+	// exportMu serializes the exports
+	exportMu sync.Mutex
+
+	mu        sync.Mutex
+	pending   []*Span
+	exporting bool
+	dropped   int
+	// failed is the number of spans the background exports failed for
+	// and lastErr is the last of the failures
+	failed  int
+	lastErr error
+}
+
+// New returns the tracer for the service (e.g. pbm-agent) with the
+// resource attributes (e.g. host) or nil if the tracing isn't configured
+func New(cfg Conf, service string, attrs map[string]string) *Tracer {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+
+	a := map[string]string{"service.name": service}
+	for k, v := range attrs {
+		a[k] = v
+	}
+	t := &Tracer{cfg: cfg, attrs: a}
+	t.client, t.cerr = cfg.httpClient()
+	return t
+}
+
+// Start starts the span. It's the child of the parent span if the parent
+// is valid. A parent with the trace ID only puts the root span into that
+// trace, otherwise a new trace is started.
+func (t *Tracer) Start(name string, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+
+	s := &Span{t: t, name: name, start: time.Now()}
+	s.ctx.TraceID = parent.TraceID
+	s.parent = parent.SpanID
+	if s.ctx.TraceID == (TraceID{}) {
+		randID(s.ctx.TraceID[:])
+		s.parent = SpanID{}
+	}
+	randID(s.ctx.SpanID[:])
+
+	return s
+}
+
+func (t *Tracer) ended(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cerr != nil {
+		return
+	}
+	if len(t.pending) >= maxPending {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, s)
+	if len(t.pending) >= batchSize && !t.exporting {
+		t.exporting = true
+		go t.exportBatches()
+	}
+}
+
+// exportBatches exports the pending spans by full batches in the background
+func (t *Tracer) exportBatches() {
+	t.exportMu.Lock()
+	defer t.exportMu.Unlock()
+
+	for {
+		t.mu.Lock()
+		if len(t.pending) < batchSize {
+			t.exporting = false
+			t.mu.Unlock()
+			return
+		}
+		batch := t.pending[:batchSize]
+		t.pending = append([]*Span(nil), t.pending[batchSize:]...)
+		t.mu.Unlock()
+
+		err := t.send(context.Background(), batch)
+		if err != nil {
+			t.mu.Lock()
+			t.failed += len(batch)
+			t.lastErr = err
+			t.mu.Unlock()
+		}
+	}
+}
+
+// Flush exports the ended spans and reports the spans that weren't exported
+// since the last flush. The spans are dropped on failure.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	if t.cerr != nil {
+		return errors.Wrap(t.cerr, "set up the client")
+	}
+
+	// wait for the background export
+	t.exportMu.Lock()
+	defer t.exportMu.Unlock()
+
+	t.mu.Lock()
+	spans, dropped, failed, lastErr := t.pending, t.dropped, t.failed, t.lastErr
+	t.pending, t.dropped, t.failed, t.lastErr = nil, 0, 0, nil
+	t.mu.Unlock()
+
+	for len(spans) != 0 {
+		n := len(spans)
+		if n > batchSize {
+			n = batchSize
+		}
+		err := t.send(ctx, spans[:n])
+		if err != nil {
+			failed += n
+			lastErr = err
+		}
+		spans = spans[n:]
+	}
+
+	if lastErr != nil {
+		return errors.Wrapf(lastErr, "%d spans weren't exported", failed)
+	}
+	if dropped != 0 {
+		return errors.Errorf("%d spans were dropped over the limit of %d", dropped, maxPending)
+	}
+
+	return nil
+}
+
+// send exports the spans to the collector
+func (t *Tracer) send(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(t.export(spans))
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.cfg.timeout())
+	defer cancel()
+
+	u := strings.TrimSuffix(t.cfg.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+	for k, v := range t.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("collector responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// Span is the timed operation of the trace. All methods of a nil span
+// are no-op.
+type Span struct {
+	t      *Tracer
+	name   string
+	ctx    SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs map[string]string
+	err   string
+}
+
+// Context returns the span's context to propagate to other processes.
+// It's zero for a nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// Child starts the child span
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.t.Start(name, s.ctx)
+}
+
+// SetAttr sets the span attribute
+func (s *Span) SetAttr(k string, v interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]string)
+	}
+	s.attrs[k] = fmt.Sprint(v)
+}
+
+// End ends the span with the error status if err isn't nil. Only the first
+// call counts.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.mu.Unlock()
+
+	s.t.ended(s)
+}
+
+func randID(b []byte) {
+	for {
+		_, _ = rand.Read(b)
+		for _, v := range b {
+			if v != 0 {
+				return
+			}
+		}
+	}
+}
+
+// OTLP/JSON messages (opentelemetry-proto, collector/trace/v1). IDs are
+// hex-encoded and 64-bit integers are strings in the JSON mapping.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+func otlpAttrs(m map[string]string) []otlpKeyValue {
+	var rv []otlpKeyValue
+	for k, v := range m {
+		rv = append(rv, otlpKeyValue{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	return rv
+}
+
+func (t *Tracer) export(spans []*Span) otlpRequest {
+	ss := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.ctx.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.ctx.SpanID[:]),
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttrs(s.attrs),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if s.parent != (SpanID{}) {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			o.Status = otlpStatus{Code: otlpStatusError, Message: s.err}
+		}
+		s.mu.Unlock()
+		ss = append(ss, o)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttrs(t.attrs)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "pbm"}, Spans: ss}},
+	}}}
+}
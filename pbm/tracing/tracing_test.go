@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestTraceparent(t *testing.T) {
+	tr := New(Conf{Endpoint: "http://localhost:4318"}, "test", nil)
+	sp := tr.Start("op", SpanContext{})
+
+	c, err := ParseTraceparent(sp.Context().Traceparent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != sp.Context() {
+		t.Errorf("got %+v, want %+v", c, sp.Context())
+	}
+
+	for _, s := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c",
+		"01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c8031-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+	} {
+		if _, err := ParseTraceparent(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestNoop(t *testing.T) {
+	tr := New(Conf{}, "test", nil)
+	if tr != nil {
+		t.Fatal("expected nil tracer")
+	}
+
+	sp := tr.Start("op", SpanContext{})
+	sp.SetAttr("k", "v")
+	sp.Child("child").End(nil)
+	sp.End(nil)
+	if sp.Context().IsValid() {
+		t.Error("nil span has a valid context")
+	}
+	if err := tr.Flush(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var got []otlpRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = append(got, req)
+	}))
+	defer srv.Close()
+
+	tr := New(Conf{Endpoint: srv.URL + "/"}, "pbm-agent", map[string]string{"host.name": "rs0/a:27017"})
+	root := tr.Start("restore", SpanContext{})
+	remote := tr.Start("restore", root.Context())
+	child := root.Child("copyFiles")
+	child.SetAttr("pbm.file", "collection-1.wt")
+	child.End(errors.New("no space left"))
+	remote.End(nil)
+	root.End(nil)
+	root.End(errors.New("ended twice"))
+
+	err := tr.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	spans := got[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+
+	byName := make(map[string][]otlpSpan)
+	for _, s := range spans {
+		byName[s.Name] = append(byName[s.Name], s)
+		if s.TraceID != spans[0].TraceID {
+			t.Errorf("span %s is in another trace %s", s.Name, s.TraceID)
+		}
+	}
+	c := byName["copyFiles"][0]
+	if c.Status.Code != otlpStatusError || c.Status.Message != "no space left" {
+		t.Errorf("copyFiles status %+v", c.Status)
+	}
+	if len(c.Attributes) != 1 || c.Attributes[0].Value.StringValue != "collection-1.wt" {
+		t.Errorf("copyFiles attributes %+v", c.Attributes)
+	}
+	var rootID string
+	for _, s := range byName["restore"] {
+		if s.ParentSpanID == "" {
+			rootID = s.SpanID
+			if s.Status.Code != otlpStatusOK {
+				t.Errorf("root status %+v", s.Status)
+			}
+		}
+	}
+	for _, s := range append(byName["restore"], c) {
+		if s.SpanID != rootID && s.ParentSpanID != rootID {
+			t.Errorf("span %s (%s) isn't the root's child", s.Name, s.SpanID)
+		}
+	}
+
+	// nothing is pending
+	if err := tr.Flush(context.Background()); err != nil || len(got) != 1 {
+		t.Errorf("flushed again: %v, %d requests", err, len(got))
+	}
+}
+
+func TestTraceIDFrom(t *testing.T) {
+	tr := New(Conf{Endpoint: "http://localhost:4318"}, "test", nil)
+	id := TraceIDFrom([]byte("0123456789ab"))
+
+	a := tr.Start("backup", SpanContext{TraceID: id})
+	b := tr.Start("backup", SpanContext{TraceID: id})
+	if a.Context().TraceID != id || b.Context().TraceID != id {
+		t.Error("spans aren't in the given trace")
+	}
+	if a.parent != (SpanID{}) {
+		t.Error("root span has a parent")
+	}
+}
+
+func TestBatches(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		sizes = append(sizes, len(req.ResourceSpans[0].ScopeSpans[0].Spans))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	cfg := Conf{Endpoint: srv.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+	tr := New(cfg, "pbm-agent", nil)
+	root := tr.Start("restore", SpanContext{})
+	for i := 0; i < 2*batchSize+10; i++ {
+		root.Child("copyFile").End(nil)
+	}
+	root.End(nil)
+
+	if err := tr.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, n := range sizes {
+		if n > batchSize {
+			t.Errorf("batch of %d spans, max %d", n, batchSize)
+		}
+		total += n
+	}
+	if total != 2*batchSize+11 || len(sizes) < 3 {
+		t.Errorf("exported %d spans in %d requests", total, len(sizes))
+	}
+
+	// the failed exports are reported
+	tr = New(Conf{Endpoint: srv.URL}, "pbm-agent", nil)
+	tr.Start("restore", SpanContext{}).End(nil)
+	if err := tr.Flush(context.Background()); err == nil {
+		t.Error("expected the export failure")
+	}
+}
@@ -0,0 +1,121 @@
+package pbm
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// UploadLimitConf limits the agent's uploads to the storage across the PITR
+// chunks, backups and metadata writes. Each class of uploads has a slot
+// reserved, so a running backup can't take all of them from the PITR.
+type UploadLimitConf struct {
+	// MaxConcurrent is the max number of uploads passing data to
+	// the storage at once. Zero means no limit.
+	MaxConcurrent int `bson:"maxConcurrent,omitempty" json:"maxConcurrent,omitempty" yaml:"maxConcurrent,omitempty"`
+	// MaxMBps is the max aggregate bandwidth of the uploads in MB/s.
+	// Zero means no limit.
+	MaxMBps float64 `bson:"maxMBps,omitempty" json:"maxMBps,omitempty" yaml:"maxMBps,omitempty"`
+	// Weights decide which class of the waiting uploads goes first and
+	// the share of MaxMBps each class gets
+	Weights *UploadWeights `bson:"weights,omitempty" json:"weights,omitempty" yaml:"weights,omitempty"`
+}
+
+// UploadWeights are the weights of the uploads classes. Zero means
+// the default one.
+type UploadWeights struct {
+	PITR   float64 `bson:"pitr,omitempty" json:"pitr,omitempty" yaml:"pitr,omitempty"`
+	Backup float64 `bson:"backup,omitempty" json:"backup,omitempty" yaml:"backup,omitempty"`
+	Meta   float64 `bson:"meta,omitempty" json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// Default weights of the uploads classes. PITR chunks go first as
+// a delayed chunk widens the gap in the PITR timeline.
+const (
+	DefaultUploadWeightPITR   = 4
+	DefaultUploadWeightBackup = 1
+	DefaultUploadWeightMeta   = 2
+)
+
+func validateUploadLimit(c *UploadLimitConf) error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxConcurrent < 0 || c.MaxMBps < 0 {
+		return errors.New("uploadLimit options can't be negative")
+	}
+	if c.MaxConcurrent != 0 && c.MaxConcurrent < len(storage.OpClasses) {
+		return errors.Errorf("uploadLimit.maxConcurrent can't be less than %d, "+
+			"a slot per uploads class", len(storage.OpClasses))
+	}
+	if w := c.Weights; w != nil && (w.PITR < 0 || w.Backup < 0 || w.Meta < 0) {
+		return errors.New("uploadLimit.weights can't be negative")
+	}
+	return nil
+}
+
+// LimiterConf returns the limits for the storage.Limiter
+func (c *UploadLimitConf) LimiterConf() storage.LimiterConf {
+	lc := storage.LimiterConf{
+		Weights: map[storage.OpClass]float64{
+			storage.OpPITR:   DefaultUploadWeightPITR,
+			storage.OpBackup: DefaultUploadWeightBackup,
+			storage.OpMeta:   DefaultUploadWeightMeta,
+		},
+	}
+	if c == nil {
+		return lc
+	}
+
+	lc.MaxConcurrent = c.MaxConcurrent
+	lc.MaxBytesPerSec = int64(c.MaxMBps * 1024 * 1024)
+	if w := c.Weights; w != nil {
+		for class, v := range map[storage.OpClass]float64{
+			storage.OpPITR:   w.PITR,
+			storage.OpBackup: w.Backup,
+			storage.OpMeta:   w.Meta,
+		} {
+			if v > 0 {
+				lc.Weights[class] = v
+			}
+		}
+	}
+	return lc
+}
+
+// uploadLimiter is shared by all uploads of the process (the agent)
+var uploadLimiter = storage.NewLimiter()
+
+// UploadLimiter returns the limiter of the process' uploads to the storage
+func UploadLimiter() *storage.Limiter {
+	return uploadLimiter
+}
+
+// ApplyUploadLimit sets the limits of the process' uploads from the config
+func ApplyUploadLimit(c Config) {
+	uploadLimiter.SetConf(c.UploadLimit.LimiterConf())
+}
+
+// UploadStorage returns the storage whose uploads go through the process'
+// limiter as the `class` (see UploadLimitConf)
+func UploadStorage(c Config, l *log.Event, class storage.OpClass) (storage.Storage, error) {
+	stg, err := Storage(c, l)
+	if err != nil {
+		return nil, err
+	}
+
+	ApplyUploadLimit(c)
+	return storage.Limit(stg, uploadLimiter, class), nil
+}
+
+// GetUploadStorage reads current config and returns the storage whose
+// uploads are limited (see UploadStorage)
+func (p *PBM) GetUploadStorage(l *log.Event, class storage.OpClass) (storage.Storage, error) {
+	c, err := p.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "get config")
+	}
+
+	return UploadStorage(c, l, class)
+}
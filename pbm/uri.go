@@ -0,0 +1,87 @@
+package pbm
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	uriScheme    = "mongodb://"
+	srvURIScheme = "mongodb+srv://"
+)
+
+// lookupTXT is the resolver of the DNS seed list TXT records
+var lookupTXT = net.LookupTXT
+
+// NormalizeURI adds the `mongodb://` scheme to the connection string if
+// it has none. DNS seed list (`mongodb+srv://`) URIs are kept as is.
+func NormalizeURI(uri string) string {
+	if strings.HasPrefix(uri, srvURIScheme) {
+		return uri
+	}
+	return uriScheme + strings.Replace(uri, uriScheme, "", 1)
+}
+
+// IsSRVURI tells if the connection string is a DNS seed list one
+func IsSRVURI(uri string) bool {
+	return strings.HasPrefix(uri, srvURIScheme)
+}
+
+// HostsURI returns the connection string `uri` pointed at `hosts` (comma
+// separated host:port list) instead of its own. The `replicaSet` option is
+// dropped as the hosts may belong to another replset.
+//
+// The DNS seed list URI is turned into the standard one since the seed list
+// can't be used along with explicit hosts. The options the seed list implies
+// (TLS and the ones from the TXT record of the seed host) are kept unless
+// the URI sets them explicitly.
+func HostsURI(uri, hosts string) (string, error) {
+	uri = NormalizeURI(uri)
+	srv := IsSRVURI(uri)
+	if srv {
+		uri = uriScheme + strings.TrimPrefix(uri, srvURIScheme)
+	}
+
+	curi, err := url.Parse(uri)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse mongo-uri '%s'", uri)
+	}
+
+	query := curi.Query()
+	if srv {
+		// TXT record is optional, the lookup error means there is none
+		txt, _ := lookupTXT(curi.Hostname())
+		for _, t := range txt {
+			opts, err := url.ParseQuery(t)
+			if err != nil {
+				return "", errors.Wrapf(err, "parse TXT record of %s", curi.Hostname())
+			}
+			for k, v := range opts {
+				if !hasURIOpt(query, k) {
+					query[k] = v
+				}
+			}
+		}
+		if !hasURIOpt(query, "tls") && !hasURIOpt(query, "ssl") {
+			query.Set("tls", "true")
+		}
+	}
+	query.Del("replicaSet")
+	curi.RawQuery = query.Encode()
+	curi.Host = hosts
+
+	return curi.String(), nil
+}
+
+// hasURIOpt tells if the option is set. URI options are case-insensitive.
+func hasURIOpt(q url.Values, opt string) bool {
+	for k := range q {
+		if strings.EqualFold(k, opt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+)
+
+// DefaultTolerance is the relative degradation tolerated by Compare
+const DefaultTolerance = 0.1
+
+// DefaultCases are all storage and compression combinations
+var DefaultCases = func() []Case {
+	var cs []Case
+	for _, s := range []string{"mem", "fs"} {
+		for _, c := range []compress.CompressionType{
+			compress.CompressionTypeNone,
+			compress.CompressionTypeS2,
+			compress.CompressionTypeZstandard,
+			compress.CompressionTypeGZIP,
+		} {
+			cs = append(cs, Case{Storage: s, Compression: c})
+		}
+	}
+	return cs
+}()
+
+// Baseline is a set of measurements keyed by the case name
+type Baseline map[string]Result
+
+// LoadBaseline reads the baseline saved by Save
+func LoadBaseline(file string) (Baseline, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+
+	var rv Baseline
+	err = json.Unmarshal(b, &rv)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode")
+	}
+	return rv, nil
+}
+
+// Save writes the baseline into the file as JSON
+func (b Baseline) Save(file string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+	return errors.Wrap(os.WriteFile(file, data, 0o644), "write file")
+}
+
+// Regression is a case that got worse than its baseline
+type Regression struct {
+	Case string
+	Base Result
+	Cur  Result
+	// Reason describes which metric degraded and by how much
+	Reason string
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: %s (baseline: %v, now: %v)", r.Case, r.Reason, r.Base, r.Cur)
+}
+
+// Compare returns the cases of `cur` whose throughput dropped or number of
+// allocations grew by more than `tolerance` (relative) against `base`.
+// Cases missing in either set are ignored.
+func Compare(base, cur Baseline, tolerance float64) []Regression {
+	var rv []Regression
+	for name, c := range cur {
+		b, ok := base[name]
+		if !ok {
+			continue
+		}
+
+		if bs := b.MBps(); bs > 0 {
+			if drop := (bs - c.MBps()) / bs; drop > tolerance {
+				rv = append(rv, Regression{name, b, c, fmt.Sprintf("throughput dropped by %.0f%%", drop*100)})
+				continue
+			}
+		}
+		if b.Allocs > 0 {
+			if grow := (float64(c.Allocs) - float64(b.Allocs)) / float64(b.Allocs); grow > tolerance {
+				rv = append(rv, Regression{name, b, c, fmt.Sprintf("allocations grew by %.0f%%", grow*100)})
+			}
+		}
+	}
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Case < rv[j].Case })
+	return rv
+}
@@ -0,0 +1,218 @@
+// Package bench measures the backup upload and the restore copy pipelines
+// on synthetic file sets. It doesn't need a running mongod, so the results
+// are repeatable and can be compared with a baseline to spot regressions.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/percona/percona-backup-mongodb/pbm"
+	"github.com/percona/percona-backup-mongodb/pbm/backup"
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+	"github.com/percona/percona-backup-mongodb/pbm/log"
+	"github.com/percona/percona-backup-mongodb/pbm/restore"
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+	"github.com/percona/percona-backup-mongodb/pbm/storage/fs"
+)
+
+// Spec describes a synthetic backup file set
+type Spec struct {
+	Files    int
+	FileSize int64
+	// Compressibility is the share (0..1) of each block filled with
+	// repeated data, the rest is random
+	Compressibility float64
+	Seed            int64
+}
+
+// Size returns the total size of the file set
+func (s Spec) Size() int64 {
+	return int64(s.Files) * s.FileSize
+}
+
+const blockSize = 4 << 10
+
+// Generate writes the file set into the dir
+func Generate(dir string, spec Spec) ([]pbm.File, error) {
+	rnd := rand.New(rand.NewSource(spec.Seed))
+	block := make([]byte, blockSize)
+	rndPart := blockSize - int(float64(blockSize)*spec.Compressibility)
+
+	files := make([]pbm.File, 0, spec.Files)
+	for i := 0; i < spec.Files; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("collection-%d.wt", i))
+		fw, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return nil, errors.Wrap(err, "create file")
+		}
+
+		for written := int64(0); written < spec.FileSize; {
+			rnd.Read(block[:rndPart])
+			for j := rndPart; j < blockSize; j++ {
+				block[j] = byte(j % 64)
+			}
+			n := int64(blockSize)
+			if spec.FileSize-written < n {
+				n = spec.FileSize - written
+			}
+			_, err = fw.Write(block[:n])
+			if err != nil {
+				fw.Close()
+				return nil, errors.Wrapf(err, "write %s", name)
+			}
+			written += n
+		}
+		err = fw.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "close %s", name)
+		}
+
+		files = append(files, pbm.File{Name: name, Size: spec.FileSize, Fmode: 0o600})
+	}
+
+	return files, nil
+}
+
+// Result is a measurement of a pipeline run
+type Result struct {
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"duration"`
+	Allocs     uint64        `json:"allocs"`
+	AllocBytes uint64        `json:"alloc_bytes"`
+}
+
+// MBps returns the throughput in MB/s
+func (r Result) MBps() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / (1 << 20) / r.Duration.Seconds()
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%.2f MB/s, %d allocs (%d bytes)", r.MBps(), r.Allocs, r.AllocBytes)
+}
+
+func measure(bytes int64, fn func() error) (Result, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	err := fn()
+	d := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return Result{
+		Bytes:      bytes,
+		Duration:   d,
+		Allocs:     after.Mallocs - before.Mallocs,
+		AllocBytes: after.TotalAlloc - before.TotalAlloc,
+	}, err
+}
+
+const stgDir = "bench"
+
+var nopLog = log.New(nil, "", "").NewEvent("bench", "", "", primitive.Timestamp{})
+
+// Upload uploads the files to the storage the way physical backup does
+func Upload(files []pbm.File, stg storage.Storage, c compress.CompressionType, level *int) error {
+	for _, f := range files {
+		_, err := backup.WriteFile(context.Background(), f, path.Join(stgDir, filepath.Base(f.Name)), stg, c, level, nopLog)
+		if err != nil {
+			return errors.Wrapf(err, "upload %s", f.Name)
+		}
+	}
+	return nil
+}
+
+// Restore copies the files uploaded by Upload from the storage into
+// the dir the way physical restore does
+func Restore(files []pbm.File, stg storage.Storage, c compress.CompressionType, dir string) error {
+	buf := make([]byte, 32*1024)
+	for _, f := range files {
+		name := filepath.Base(f.Name)
+		err := restore.CopyFile(stg.SourceReader, path.Join(stgDir, name)+c.Suffix(), c,
+			filepath.Join(dir, name), pbm.File{Size: f.Size, Fmode: f.Fmode}, buf)
+		if err != nil {
+			return errors.Wrapf(err, "restore %s", name)
+		}
+	}
+	return nil
+}
+
+// Case is a pipeline run configuration
+type Case struct {
+	Storage     string
+	Compression compress.CompressionType
+}
+
+func (c Case) String() string {
+	return c.Storage + "/" + string(c.Compression)
+}
+
+// Run runs the upload and the restore of the file set for each case and
+// returns the measurements keyed by "upload|restore/<storage>/<compression>".
+// Storage "mem" is in-memory, "fs" is a local disk dir.
+func Run(spec Spec, cases []Case, workdir string) (Baseline, error) {
+	src := filepath.Join(workdir, "src")
+	err := os.MkdirAll(src, 0o700)
+	if err != nil {
+		return nil, errors.Wrap(err, "create source dir")
+	}
+	files, err := Generate(src, spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate files")
+	}
+
+	rv := make(Baseline)
+	for i, c := range cases {
+		stg, err := NewStorage(c.Storage, filepath.Join(workdir, fmt.Sprintf("stg-%d", i)))
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(workdir, fmt.Sprintf("dst-%d", i))
+		err = os.MkdirAll(dst, 0o700)
+		if err != nil {
+			return nil, errors.Wrap(err, "create destination dir")
+		}
+
+		r, err := measure(spec.Size(), func() error { return Upload(files, stg, c.Compression, nil) })
+		if err != nil {
+			return nil, errors.Wrapf(err, "upload %s", c)
+		}
+		rv["upload/"+c.String()] = r
+
+		r, err = measure(spec.Size(), func() error { return Restore(files, stg, c.Compression, dst) })
+		if err != nil {
+			return nil, errors.Wrapf(err, "restore %s", c)
+		}
+		rv["restore/"+c.String()] = r
+	}
+
+	return rv, nil
+}
+
+// NewStorage returns the storage of the given kind: "mem" or "fs"
+func NewStorage(kind, dir string) (storage.Storage, error) {
+	switch kind {
+	case "mem":
+		return NewMemStorage(), nil
+	case "fs":
+		err := os.MkdirAll(dir, 0o700)
+		if err != nil {
+			return nil, errors.Wrap(err, "create storage dir")
+		}
+		return fs.New(fs.Conf{Path: dir}), nil
+	}
+	return nil, errors.Errorf("unknown storage %q", kind)
+}
@@ -0,0 +1,155 @@
+package bench
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/percona/percona-backup-mongodb/pbm/compress"
+)
+
+var (
+	baselineFile   = flag.String("baseline", "", "compare the pipelines against the baseline file")
+	updateBaseline = flag.Bool("update-baseline", false, "write the measurements into the baseline file")
+)
+
+var benchSpec = Spec{Files: 4, FileSize: 8 << 20, Compressibility: 0.5, Seed: 1}
+
+func benchCases(b *testing.B, fn func(b *testing.B, c Case)) {
+	for _, c := range DefaultCases {
+		c := c
+		b.Run(c.String(), func(b *testing.B) { fn(b, c) })
+	}
+}
+
+func BenchmarkUpload(b *testing.B) {
+	files, err := Generate(b.TempDir(), benchSpec)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchCases(b, func(b *testing.B, c Case) {
+		stg, err := NewStorage(c.Storage, b.TempDir())
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(benchSpec.Size())
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := Upload(files, stg, c.Compression, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkRestore(b *testing.B) {
+	files, err := Generate(b.TempDir(), benchSpec)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchCases(b, func(b *testing.B, c Case) {
+		stg, err := NewStorage(c.Storage, b.TempDir())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := Upload(files, stg, c.Compression, nil); err != nil {
+			b.Fatal(err)
+		}
+		dst := b.TempDir()
+		b.SetBytes(benchSpec.Size())
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := Restore(files, stg, c.Compression, dst); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestRegression runs the matrix and fails if any case degraded against
+// the baseline, e.g.:
+//
+//	go test ./speedt/bench -run Regression -baseline=bench.json
+//
+// Add -update-baseline to record a new one.
+func TestRegression(t *testing.T) {
+	if *baselineFile == "" {
+		t.Skip("no -baseline given")
+	}
+
+	cur, err := Run(benchSpec, DefaultCases, t.TempDir())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	for _, c := range DefaultCases {
+		for _, op := range []string{"upload/", "restore/"} {
+			t.Logf("%s%s: %v", op, c, cur[op+c.String()])
+		}
+	}
+
+	if *updateBaseline {
+		if err := cur.Save(*baselineFile); err != nil {
+			t.Fatalf("save baseline: %v", err)
+		}
+		return
+	}
+
+	base, err := LoadBaseline(*baselineFile)
+	if err != nil {
+		t.Fatalf("load baseline: %v", err)
+	}
+	for _, r := range Compare(base, cur, DefaultTolerance) {
+		t.Error(r)
+	}
+}
+
+func TestRoundtrip(t *testing.T) {
+	spec := Spec{Files: 2, FileSize: 100 << 10, Compressibility: 0.7, Seed: 1}
+	for _, c := range []Case{{"mem", compress.CompressionTypeS2}, {"fs", compress.CompressionTypeZstandard}} {
+		got, err := Run(spec, []Case{c}, t.TempDir())
+		if err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		for _, op := range []string{"upload/", "restore/"} {
+			if r, ok := got[op+c.String()]; !ok || r.Bytes != spec.Size() {
+				t.Errorf("%s%s: unexpected result %+v", op, c, r)
+			}
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := Baseline{
+		"upload/mem/s2":   {Bytes: 100 << 20, Duration: time.Second, Allocs: 1000},
+		"restore/mem/s2":  {Bytes: 100 << 20, Duration: time.Second, Allocs: 1000},
+		"upload/fs/zstd":  {Bytes: 100 << 20, Duration: time.Second, Allocs: 1000},
+		"restore/fs/zstd": {Bytes: 100 << 20, Duration: time.Second, Allocs: 1000},
+	}
+	cur := Baseline{
+		// within the tolerance
+		"upload/mem/s2": {Bytes: 100 << 20, Duration: 1050 * time.Millisecond, Allocs: 1050},
+		// slower
+		"restore/mem/s2": {Bytes: 100 << 20, Duration: 1500 * time.Millisecond, Allocs: 1000},
+		// more allocations
+		"upload/fs/zstd": {Bytes: 100 << 20, Duration: time.Second, Allocs: 2000},
+		// faster
+		"restore/fs/zstd": {Bytes: 100 << 20, Duration: 500 * time.Millisecond, Allocs: 900},
+		// not in the baseline
+		"upload/fs/gzip": {Bytes: 100 << 20, Duration: time.Hour, Allocs: 1},
+	}
+
+	got := Compare(base, cur, DefaultTolerance)
+	want := []string{"restore/mem/s2", "upload/fs/zstd"}
+	if len(got) != len(want) {
+		t.Fatalf("expected regressions in %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i].Case != want[i] {
+			t.Errorf("expected regressions in %v, got %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package bench
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/percona/percona-backup-mongodb/pbm/storage"
+)
+
+// MemStorage is an in-memory storage. It excludes the storage I/O from
+// the measurements so only the pipeline itself (compression, buffers) counts.
+type MemStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{files: make(map[string][]byte)}
+}
+
+func (*MemStorage) Type() storage.Type { return storage.Undef }
+
+func (s *MemStorage) Save(name string, data io.Reader, size int64) error {
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	_, err := io.Copy(buf, data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = buf.Bytes()
+	return nil
+}
+
+func (s *MemStorage) SourceReader(name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[name]
+	if !ok {
+		return nil, storage.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *MemStorage) FileStat(name string) (storage.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[name]
+	if !ok {
+		return storage.FileInfo{}, storage.ErrNotExist
+	}
+	if len(b) == 0 {
+		return storage.FileInfo{}, storage.ErrEmpty
+	}
+	return storage.FileInfo{Name: name, Size: int64(len(b))}, nil
+}
+
+func (s *MemStorage) List(prefix, suffix string) ([]storage.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rv []storage.FileInfo
+	for name, b := range s.files {
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			rv = append(rv, storage.FileInfo{Name: strings.TrimPrefix(name, prefix+"/"), Size: int64(len(b))})
+		}
+	}
+	return rv, nil
+}
+
+func (s *MemStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return storage.ErrNotExist
+	}
+	delete(s.files, name)
+	return nil
+}
+
+func (s *MemStorage) Copy(src, dst string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.files[src]
+	if !ok {
+		return storage.ErrNotExist
+	}
+	s.files[dst] = b
+	return nil
+}